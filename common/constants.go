@@ -119,6 +119,15 @@ var SyncFrequency int // unit is second
 var BatchUpdateEnabled = false
 var BatchUpdateInterval int
 
+// AsyncWriteEnabled 日志与计费由写入池异步写入，不阻塞请求
+var AsyncWriteEnabled = false
+var AsyncWriteWorkers int
+var AsyncWriteQueueSize int
+var AsyncWriteBatchSize int // 单次批量插入的最大日志数
+
+// AsyncWriteDropVerbose 写入队列已满时丢弃错误日志与系统日志，计费与消费日志始终写入
+var AsyncWriteDropVerbose bool
+
 var RelayTimeout int // unit is second
 
 var GeminiSafetySetting string
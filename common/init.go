@@ -89,6 +89,10 @@ func InitEnv() {
 	// Initialize variables with GetEnvOrDefault
 	SyncFrequency = GetEnvOrDefault("SYNC_FREQUENCY", 60)
 	BatchUpdateInterval = GetEnvOrDefault("BATCH_UPDATE_INTERVAL", 5)
+	AsyncWriteWorkers = GetEnvOrDefault("ASYNC_WRITE_WORKERS", 4)
+	AsyncWriteQueueSize = GetEnvOrDefault("ASYNC_WRITE_QUEUE_SIZE", 10000)
+	AsyncWriteBatchSize = GetEnvOrDefault("ASYNC_WRITE_BATCH_SIZE", 100)
+	AsyncWriteDropVerbose = GetEnvOrDefaultBool("ASYNC_WRITE_DROP_VERBOSE", false)
 	RelayTimeout = GetEnvOrDefault("RELAY_TIMEOUT", 0)
 
 	// Initialize string variables with GetEnvOrDefaultString
@@ -112,8 +116,16 @@ func InitEnv() {
 
 func initConstantEnv() {
 	constant.StreamingTimeout = GetEnvOrDefault("STREAMING_TIMEOUT", 300)
+	// StreamMaxEvents 单个流式响应允许的最大事件数，超过后终止读取，0 表示不限制
+	constant.StreamMaxEvents = GetEnvOrDefault("STREAM_MAX_EVENTS", 200000)
+	// StreamMaxAccumulatedBytes 流式响应中为日志累积响应原文的最大字节数，超过后保留开头与结尾各一半
+	constant.StreamMaxAccumulatedBytes = GetEnvOrDefault("STREAM_MAX_ACCUMULATED_BYTES", 1<<20)
+	// StreamBodyCaptureOnErrorOnly 成功的流式请求不在日志中记录响应原文，仅保留中途中断的流
+	constant.StreamBodyCaptureOnErrorOnly = GetEnvOrDefaultBool("STREAM_BODY_CAPTURE_ON_ERROR_ONLY", false)
 	constant.DifyDebug = GetEnvOrDefaultBool("DIFY_DEBUG", true)
 	constant.MaxFileDownloadMB = GetEnvOrDefault("MAX_FILE_DOWNLOAD_MB", 20)
+	// MaxDecompressedRequestBodyMB 压缩上传的请求体解压后允许的最大大小，超过时返回 413
+	constant.MaxDecompressedRequestBodyMB = GetEnvOrDefault("MAX_DECOMPRESSED_REQUEST_BODY_MB", 64)
 	// ForceStreamOption 覆盖请求参数，强制返回usage信息
 	constant.ForceStreamOption = GetEnvOrDefaultBool("FORCE_STREAM_OPTION", true)
 	constant.CountToken = GetEnvOrDefaultBool("CountToken", true)
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -80,6 +81,18 @@ func StringsContains(strs []string, str string) bool {
 	return false
 }
 
+// TruncateUTF8Bytes 将字节切片截断到不超过 maxBytes 字节，截断位置回退到 UTF-8 字符边界，避免截断出不完整的字符
+func TruncateUTF8Bytes(b []byte, maxBytes int) []byte {
+	if len(b) <= maxBytes {
+		return b
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(b[cut]) {
+		cut--
+	}
+	return b[:cut]
+}
+
 // StringToByteSlice []byte only read, panic on append
 func StringToByteSlice(s string) []byte {
 	tmp1 := (*[2]uintptr)(unsafe.Pointer(&s))
@@ -8,6 +8,18 @@ const (
 
 	ContextKeyOriginalModel    ContextKey = "original_model"
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
+	// ContextKeyModelGroup 请求以模型组名称作为模型时记录的模型组名称
+	ContextKeyModelGroup ContextKey = "model_group"
+	// ContextKeyExperiment 请求参与的 A/B 实验名称
+	ContextKeyExperiment ContextKey = "experiment"
+	// ContextKeyExperimentArm 请求被分配到的实验分组
+	ContextKeyExperimentArm ContextKey = "experiment_arm"
+	// ContextKeyValidationMirror 请求为发往验证期模型的镜像流量，不向用户计费
+	ContextKeyValidationMirror ContextKey = "validation_mirror"
+	// ContextKeyConsumedQuota 记录消费日志时本次请求实际消耗的额度
+	ContextKeyConsumedQuota ContextKey = "consumed_quota"
+	// ContextKeyStreamWriteLock 流式响应的写锁，扫描协程之外向客户端写入时需要持有
+	ContextKeyStreamWriteLock ContextKey = "stream_write_lock"
 
 	/* token related keys */
 	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
@@ -17,6 +29,10 @@ const (
 	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenMaxOutputTokens   ContextKey = "token_max_output_tokens"
+	ContextKeyTokenMaxToolCalls      ContextKey = "token_max_tool_calls"
+	ContextKeyTokenFeatureFlags      ContextKey = "token_feature_flags"
+	ContextKeyTokenRequestTimeout    ContextKey = "token_request_timeout"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -45,6 +61,7 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+	ContextKeyUserRole    ContextKey = "role"
 
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
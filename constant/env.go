@@ -1,8 +1,12 @@
 package constant
 
 var StreamingTimeout int
+var StreamMaxEvents int
+var StreamMaxAccumulatedBytes int
+var StreamBodyCaptureOnErrorOnly bool
 var DifyDebug bool
 var MaxFileDownloadMB int
+var MaxDecompressedRequestBodyMB int
 var ForceStreamOption bool
 var CountToken bool
 var GetMediaToken bool
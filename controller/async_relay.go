@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldRelayAsync 判断请求是否以异步模式处理，仅支持 Chat Completions、Claude Messages 与 Responses 的非流式请求
+func shouldRelayAsync(c *gin.Context, relayFormat types.RelayFormat) bool {
+	if !model_setting.GetAsyncRelaySettings().Enabled || !service.IsAsyncRelayRequested(c) {
+		return false
+	}
+	switch relayFormat {
+	case types.RelayFormatOpenAI, types.RelayFormatClaude, types.RelayFormatOpenAIResponses:
+		return true
+	}
+	return false
+}
+
+// relayAsync 立即返回 202 与任务 ID，由 worker 在后台按同步流程完成请求与计费，结果通过回调地址推送或轮询获取
+func relayAsync(c *gin.Context, relayFormat types.RelayFormat) {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		writeAsyncRelayError(c, relayFormat, types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry()))
+		return
+	}
+	var peek struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	_ = common.Unmarshal(body, &peek)
+	if peek.Stream {
+		writeAsyncRelayError(c, relayFormat, types.NewErrorWithStatusCode(errors.New("async mode does not support streaming requests"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry()))
+		return
+	}
+	callbackUrl := c.GetHeader(service.AsyncRelayCallbackHeader)
+	if err := service.ValidateAsyncRelayCallbackUrl(callbackUrl); err != nil {
+		writeAsyncRelayError(c, relayFormat, types.NewErrorWithStatusCode(fmt.Errorf("invalid callback url: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry()))
+		return
+	}
+
+	// gin 会在请求结束后复用上下文，这里复制请求与上下文数据供 worker 使用
+	keys := make(map[string]any, len(c.Keys))
+	for k, v := range c.Keys {
+		keys[k] = v
+	}
+	request := c.Request.Clone(context.Background())
+	request.Header.Del(service.AsyncRelayHeader)
+	request.Header.Del(service.AsyncRelayCallbackHeader)
+	run := func() (int, []byte) {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		ctx.Request = request
+		for k, v := range keys {
+			ctx.Set(k, v)
+		}
+		Relay(ctx, relayFormat)
+		return recorder.Code, recorder.Body.Bytes()
+	}
+
+	job := &service.AsyncRelayJob{
+		Model:       peek.Model,
+		UserId:      c.GetInt("id"),
+		TokenId:     common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		CallbackUrl: callbackUrl,
+	}
+	if err := service.SubmitAsyncRelayJob(job, run, c.GetString("token_key")); err != nil {
+		writeAsyncRelayError(c, relayFormat, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry()))
+		return
+	}
+	logger.LogInfo(c, fmt.Sprintf("async job %s submitted", job.Id))
+	c.JSON(http.StatusAccepted, job)
+}
+
+func writeAsyncRelayError(c *gin.Context, relayFormat types.RelayFormat, apiErr *types.NewAPIError) {
+	apiErr.SetMessage(common.MessageWithRequestId(apiErr.Error(), c.GetString(common.RequestIdKey)))
+	if relayFormat == types.RelayFormatClaude {
+		c.JSON(apiErr.StatusCode, gin.H{
+			"type":  "error",
+			"error": apiErr.ToClaudeError(),
+		})
+		return
+	}
+	c.JSON(apiErr.StatusCode, gin.H{
+		"error": apiErr.ToOpenAIError(),
+	})
+}
+
+// GetAsyncRelayJob 轮询异步任务状态与结果
+func GetAsyncRelayJob(c *gin.Context) {
+	job := service.GetAsyncRelayJob(c.Param("id"), c.GetInt("id"))
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": types.OpenAIError{
+				Message: "async job not found",
+				Type:    "invalid_request_error",
+				Code:    "async_job_not_found",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -11,11 +11,13 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
+	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 
 	"github.com/gin-gonic/gin"
@@ -121,6 +123,32 @@ type OpenRouterCreditResponse struct {
 	} `json:"data"`
 }
 
+// OpenAIOrganizationCostsResponse OpenAI 组织费用接口 /v1/organization/costs 的响应
+type OpenAIOrganizationCostsResponse struct {
+	Data []struct {
+		Results []struct {
+			Amount struct {
+				Value    float64 `json:"value"`
+				Currency string  `json:"currency"`
+			} `json:"amount"`
+		} `json:"results"`
+	} `json:"data"`
+	HasMore  bool   `json:"has_more"`
+	NextPage string `json:"next_page"`
+}
+
+// AnthropicCostReportResponse Anthropic Admin API /v1/organizations/cost_report 的响应，金额单位为美分
+type AnthropicCostReportResponse struct {
+	Data []struct {
+		Results []struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		} `json:"results"`
+	} `json:"data"`
+	HasMore  bool   `json:"has_more"`
+	NextPage string `json:"next_page"`
+}
+
 // GetAuthHeader get auth header
 func GetAuthHeader(token string) http.Header {
 	h := http.Header{}
@@ -166,9 +194,9 @@ func GetResponseBody(method, url string, channel *model.Channel, headers http.He
 	return body, nil
 }
 
-func updateChannelCloseAIBalance(channel *model.Channel) (float64, error) {
+func queryChannelCloseAIBalance(channel *model.Channel, key string) (float64, error) {
 	url := fmt.Sprintf("%s/dashboard/billing/credit_grants", channel.GetBaseURL())
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 
 	if err != nil {
 		return 0, err
@@ -178,13 +206,12 @@ func updateChannelCloseAIBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	channel.UpdateBalance(response.TotalAvailable)
 	return response.TotalAvailable, nil
 }
 
-func updateChannelOpenAISBBalance(channel *model.Channel) (float64, error) {
-	url := fmt.Sprintf("https://api.openai-sb.com/sb-api/user/status?api_key=%s", channel.Key)
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+func queryChannelOpenAISBBalance(channel *model.Channel, key string) (float64, error) {
+	url := fmt.Sprintf("https://api.openai-sb.com/sb-api/user/status?api_key=%s", key)
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -200,14 +227,13 @@ func updateChannelOpenAISBBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	channel.UpdateBalance(balance)
 	return balance, nil
 }
 
-func updateChannelAIProxyBalance(channel *model.Channel) (float64, error) {
+func queryChannelAIProxyBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://aiproxy.io/api/report/getUserOverview"
 	headers := http.Header{}
-	headers.Add("Api-Key", channel.Key)
+	headers.Add("Api-Key", key)
 	body, err := GetResponseBody("GET", url, channel, headers)
 	if err != nil {
 		return 0, err
@@ -220,13 +246,12 @@ func updateChannelAIProxyBalance(channel *model.Channel) (float64, error) {
 	if !response.Success {
 		return 0, fmt.Errorf("code: %d, message: %s", response.ErrorCode, response.Message)
 	}
-	channel.UpdateBalance(response.Data.TotalPoints)
 	return response.Data.TotalPoints, nil
 }
 
-func updateChannelAPI2GPTBalance(channel *model.Channel) (float64, error) {
+func queryChannelAPI2GPTBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://api.api2gpt.com/dashboard/billing/credit_grants"
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 
 	if err != nil {
 		return 0, err
@@ -236,13 +261,12 @@ func updateChannelAPI2GPTBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	channel.UpdateBalance(response.TotalRemaining)
 	return response.TotalRemaining, nil
 }
 
-func updateChannelSiliconFlowBalance(channel *model.Channel) (float64, error) {
+func queryChannelSiliconFlowBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://api.siliconflow.cn/v1/user/info"
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -258,13 +282,12 @@ func updateChannelSiliconFlowBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	channel.UpdateBalance(balance)
 	return balance, nil
 }
 
-func updateChannelDeepSeekBalance(channel *model.Channel) (float64, error) {
+func queryChannelDeepSeekBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://api.deepseek.com/user/balance"
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -287,13 +310,12 @@ func updateChannelDeepSeekBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	channel.UpdateBalance(balance)
 	return balance, nil
 }
 
-func updateChannelAIGC2DBalance(channel *model.Channel) (float64, error) {
+func queryChannelAIGC2DBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://api.aigc2d.com/dashboard/billing/credit_grants"
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -302,13 +324,12 @@ func updateChannelAIGC2DBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	channel.UpdateBalance(response.TotalAvailable)
 	return response.TotalAvailable, nil
 }
 
-func updateChannelOpenRouterBalance(channel *model.Channel) (float64, error) {
+func queryChannelOpenRouterBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://openrouter.ai/api/v1/credits"
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -318,13 +339,12 @@ func updateChannelOpenRouterBalance(channel *model.Channel) (float64, error) {
 		return 0, err
 	}
 	balance := response.Data.TotalCredits - response.Data.TotalUsage
-	channel.UpdateBalance(balance)
 	return balance, nil
 }
 
-func updateChannelMoonshotBalance(channel *model.Channel) (float64, error) {
+func queryChannelMoonshotBalance(channel *model.Channel, key string) (float64, error) {
 	url := "https://api.moonshot.cn/v1/users/me/balance"
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -352,46 +372,134 @@ func updateChannelMoonshotBalance(channel *model.Channel) (float64, error) {
 	}
 	availableBalanceCny := response.Data.AvailableBalance
 	availableBalanceUsd := decimal.NewFromFloat(availableBalanceCny).Div(decimal.NewFromFloat(operation_setting.Price)).InexactFloat64()
-	channel.UpdateBalance(availableBalanceUsd)
 	return availableBalanceUsd, nil
 }
 
-func updateChannelBalance(channel *model.Channel) (float64, error) {
+// organizationCostPageLimit 组织费用接口最多翻页次数
+const organizationCostPageLimit = 10
+
+// queryOpenAIOrganizationBalance 使用 Admin Key 查询 OpenAI 组织本月费用，余额为月度预算减去本月费用
+func queryOpenAIOrganizationBalance(channel *model.Channel, otherSettings dto.ChannelOtherSettings) (float64, error) {
+	if otherSettings.BalanceMonthlyBudget <= 0 {
+		return 0, errors.New("使用 Admin Key 查询余额需要设置月度预算")
+	}
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	headers := GetAuthHeader(otherSettings.BalanceAdminKey)
+	cost := 0.0
+	page := ""
+	for i := 0; i < organizationCostPageLimit; i++ {
+		url := fmt.Sprintf("https://api.openai.com/v1/organization/costs?start_time=%d&limit=31", monthStart.Unix())
+		if page != "" {
+			url += "&page=" + page
+		}
+		body, err := GetResponseBody("GET", url, channel, headers)
+		if err != nil {
+			return 0, err
+		}
+		response := OpenAIOrganizationCostsResponse{}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return 0, err
+		}
+		for _, bucket := range response.Data {
+			for _, result := range bucket.Results {
+				cost += result.Amount.Value
+			}
+		}
+		if !response.HasMore || response.NextPage == "" {
+			break
+		}
+		page = response.NextPage
+	}
+	return otherSettings.BalanceMonthlyBudget - cost, nil
+}
+
+// queryAnthropicOrganizationBalance 使用 Admin Key 查询 Anthropic 组织本月费用，余额为月度预算减去本月费用
+func queryAnthropicOrganizationBalance(channel *model.Channel, otherSettings dto.ChannelOtherSettings) (float64, error) {
+	if otherSettings.BalanceMonthlyBudget <= 0 {
+		return 0, errors.New("使用 Admin Key 查询余额需要设置月度预算")
+	}
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	headers := GetClaudeAuthHeader(otherSettings.BalanceAdminKey)
+	costCents := decimal.Zero
+	page := ""
+	for i := 0; i < organizationCostPageLimit; i++ {
+		url := fmt.Sprintf("https://api.anthropic.com/v1/organizations/cost_report?starting_at=%s", monthStart.Format(time.RFC3339))
+		if page != "" {
+			url += "&page=" + page
+		}
+		body, err := GetResponseBody("GET", url, channel, headers)
+		if err != nil {
+			return 0, err
+		}
+		response := AnthropicCostReportResponse{}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return 0, err
+		}
+		for _, bucket := range response.Data {
+			for _, result := range bucket.Results {
+				amount, err := decimal.NewFromString(result.Amount)
+				if err != nil {
+					return 0, err
+				}
+				costCents = costCents.Add(amount)
+			}
+		}
+		if !response.HasMore || response.NextPage == "" {
+			break
+		}
+		page = response.NextPage
+	}
+	return otherSettings.BalanceMonthlyBudget - costCents.Div(decimal.NewFromInt(100)).InexactFloat64(), nil
+}
+
+// queryChannelBalance 使用指定密钥查询上游余额，不写入数据库
+func queryChannelBalance(channel *model.Channel, key string) (float64, error) {
 	baseURL := constant.ChannelBaseURLs[channel.Type]
 	if channel.GetBaseURL() == "" {
 		channel.BaseURL = &baseURL
 	}
+	otherSettings := channel.GetOtherSettings()
 	switch channel.Type {
 	case constant.ChannelTypeOpenAI:
+		if otherSettings.BalanceAdminKey != "" {
+			return queryOpenAIOrganizationBalance(channel, otherSettings)
+		}
 		if channel.GetBaseURL() != "" {
 			baseURL = channel.GetBaseURL()
 		}
+	case constant.ChannelTypeAnthropic:
+		if otherSettings.BalanceAdminKey != "" {
+			return queryAnthropicOrganizationBalance(channel, otherSettings)
+		}
+		return 0, errors.New("Anthropic 渠道需要配置 Admin Key 才能查询余额")
 	case constant.ChannelTypeAzure:
 		return 0, errors.New("尚未实现")
 	case constant.ChannelTypeCustom:
 		baseURL = channel.GetBaseURL()
 	//case common.ChannelTypeOpenAISB:
-	//	return updateChannelOpenAISBBalance(channel)
+	//	return queryChannelOpenAISBBalance(channel, key)
 	case constant.ChannelTypeAIProxy:
-		return updateChannelAIProxyBalance(channel)
+		return queryChannelAIProxyBalance(channel, key)
 	case constant.ChannelTypeAPI2GPT:
-		return updateChannelAPI2GPTBalance(channel)
+		return queryChannelAPI2GPTBalance(channel, key)
 	case constant.ChannelTypeAIGC2D:
-		return updateChannelAIGC2DBalance(channel)
+		return queryChannelAIGC2DBalance(channel, key)
 	case constant.ChannelTypeSiliconFlow:
-		return updateChannelSiliconFlowBalance(channel)
+		return queryChannelSiliconFlowBalance(channel, key)
 	case constant.ChannelTypeDeepSeek:
-		return updateChannelDeepSeekBalance(channel)
+		return queryChannelDeepSeekBalance(channel, key)
 	case constant.ChannelTypeOpenRouter:
-		return updateChannelOpenRouterBalance(channel)
+		return queryChannelOpenRouterBalance(channel, key)
 	case constant.ChannelTypeMoonshot:
-		return updateChannelMoonshotBalance(channel)
+		return queryChannelMoonshotBalance(channel, key)
 	default:
 		return 0, errors.New("尚未实现")
 	}
 	url := fmt.Sprintf("%s/v1/dashboard/billing/subscription", baseURL)
 
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -407,7 +515,7 @@ func updateChannelBalance(channel *model.Channel) (float64, error) {
 		startDate = now.AddDate(0, 0, -100).Format("2006-01-02")
 	}
 	url = fmt.Sprintf("%s/v1/dashboard/billing/usage?start_date=%s&end_date=%s", baseURL, startDate, endDate)
-	body, err = GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err = GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		return 0, err
 	}
@@ -416,9 +524,63 @@ func updateChannelBalance(channel *model.Channel) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	balance := subscription.HardLimitUSD - usage.TotalUsage/100
+	return subscription.HardLimitUSD - usage.TotalUsage/100, nil
+}
+
+// ChannelKeyBalance 多密钥渠道中单个密钥的余额
+type ChannelKeyBalance struct {
+	Index   int     `json:"index"`
+	Balance float64 `json:"balance"`
+	Error   string  `json:"error,omitempty"`
+	key     string
+}
+
+// updateChannelBalance 查询并保存渠道余额
+// 多密钥渠道（且未配置组织级 Admin Key）逐个查询已启用密钥的余额，渠道余额为各密钥余额之和
+// 任一密钥查询失败时渠道余额不完整，不保存余额并返回错误，同时返回各密钥的查询结果
+// 余额低于渠道设置的告警阈值时通知管理员
+func updateChannelBalance(channel *model.Channel) (float64, []ChannelKeyBalance, error) {
+	otherSettings := channel.GetOtherSettings()
+	var balance float64
+	var keyBalances []ChannelKeyBalance
+	if !channel.ChannelInfo.IsMultiKey || otherSettings.BalanceAdminKey != "" {
+		var err error
+		balance, err = queryChannelBalance(channel, channel.Key)
+		if err != nil {
+			return 0, nil, err
+		}
+	} else {
+		var lastErr error
+		failed := 0
+		for i, key := range channel.GetKeys() {
+			if status, ok := channel.ChannelInfo.MultiKeyStatusList[i]; ok && status != common.ChannelStatusEnabled {
+				continue
+			}
+			keyBalance := ChannelKeyBalance{Index: i, key: key}
+			keyBalance.Balance, lastErr = queryChannelBalance(channel, key)
+			if lastErr != nil {
+				keyBalance.Error = lastErr.Error()
+				failed++
+			} else {
+				balance += keyBalance.Balance
+			}
+			keyBalances = append(keyBalances, keyBalance)
+		}
+		if !lo.ContainsBy(keyBalances, func(item ChannelKeyBalance) bool { return item.Error == "" }) {
+			if lastErr == nil {
+				lastErr = errors.New("没有可查询余额的密钥")
+			}
+			return 0, keyBalances, lastErr
+		}
+		if failed > 0 {
+			return 0, keyBalances, fmt.Errorf("%d 个密钥余额查询失败，渠道余额不完整: %w", failed, lastErr)
+		}
+	}
 	channel.UpdateBalance(balance)
-	return balance, nil
+	if otherSettings.BalanceAlertThreshold > 0 && balance < otherSettings.BalanceAlertThreshold {
+		service.NotifyChannelLowBalance(channel.Id, channel.Name, balance, otherSettings.BalanceAlertThreshold)
+	}
+	return balance, keyBalances, nil
 }
 
 func UpdateChannelBalance(c *gin.Context) {
@@ -432,22 +594,20 @@ func UpdateChannelBalance(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	if channel.ChannelInfo.IsMultiKey {
+	balance, keyBalances, err := updateChannelBalance(channel)
+	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "多密钥渠道不支持余额查询",
+			"success":      false,
+			"message":      err.Error(),
+			"key_balances": keyBalances,
 		})
 		return
 	}
-	balance, err := updateChannelBalance(channel)
-	if err != nil {
-		common.ApiError(c, err)
-		return
-	}
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
-		"balance": balance,
+		"success":      true,
+		"message":      "",
+		"balance":      balance,
+		"key_balances": keyBalances,
 	})
 }
 
@@ -460,21 +620,23 @@ func updateAllChannelsBalance() error {
 		if channel.Status != common.ChannelStatusEnabled {
 			continue
 		}
-		if channel.ChannelInfo.IsMultiKey {
-			continue // skip multi-key channels
-		}
 		// TODO: support Azure
 		//if channel.Type != common.ChannelTypeOpenAI && channel.Type != common.ChannelTypeCustom {
 		//	continue
 		//}
-		balance, err := updateChannelBalance(channel)
+		balance, keyBalances, err := updateChannelBalance(channel)
+		// 多密钥渠道只禁用余额耗尽的密钥，部分密钥查询失败时仍处理查询成功的密钥
+		for _, keyBalance := range keyBalances {
+			if keyBalance.Error == "" && keyBalance.Balance <= 0 {
+				service.DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, true, keyBalance.key, channel.GetAutoBan()), "余额不足")
+			}
+		}
 		if err != nil {
 			continue
-		} else {
-			// err is nil & balance <= 0 means quota is used up
-			if balance <= 0 {
-				service.DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, "", channel.GetAutoBan()), "余额不足")
-			}
+		}
+		// err is nil & balance <= 0 means quota is used up
+		if keyBalances == nil && balance <= 0 {
+			service.DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, "", channel.GetAutoBan()), "余额不足")
 		}
 		time.Sleep(common.RequestInterval)
 	}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel/openai"
+	"github.com/QuantumNous/new-api/relay/channel/openai_responses"
+	"github.com/QuantumNous/new-api/service"
+)
+
+// checkResponsesChannelModels 保存 OpenAI Responses 渠道前校验配置的模型
+// 不在内置模型列表中的模型以及会触发参数限制的模型以提示信息返回；
+// probe 为 true 时使用 key 探测每个模型在上游是否可用，存在不可用的模型时返回错误
+func checkResponsesChannelModels(channel *model.Channel, key string, probe bool) ([]string, error) {
+	if channel == nil || channel.Type != constant.ChannelTypeOpenAIResponses {
+		return nil, nil
+	}
+	modelMapping := make(map[string]string)
+	if mapping := channel.GetModelMapping(); mapping != "" && mapping != "{}" {
+		if err := common.Unmarshal([]byte(mapping), &modelMapping); err != nil {
+			return nil, fmt.Errorf("模型重定向格式错误：%s", err.Error())
+		}
+	}
+
+	var warnings []string
+	var unreachable []string
+	for _, modelName := range channel.GetModels() {
+		upstreamModel := modelName
+		if mapped := modelMapping[modelName]; mapped != "" {
+			upstreamModel = mapped
+		}
+		if !openai_responses.IsKnownModel(upstreamModel) {
+			warnings = append(warnings, fmt.Sprintf("模型 %s 不在 OpenAI Responses 内置模型列表中，请确认上游支持", upstreamModel))
+		}
+		if restrictions := openai.GetModelParameterRestrictions(upstreamModel); len(restrictions) > 0 {
+			warnings = append(warnings, fmt.Sprintf("模型 %s 存在参数限制：%s", upstreamModel, strings.Join(restrictions, "；")))
+		}
+		if probe {
+			if err := probeResponsesModel(channel, key, upstreamModel); err != nil {
+				unreachable = append(unreachable, fmt.Sprintf("%s（%s）", upstreamModel, err.Error()))
+			}
+		}
+	}
+	if len(unreachable) > 0 {
+		return warnings, fmt.Errorf("以下模型在上游不可用：%s", strings.Join(unreachable, "，"))
+	}
+	return warnings, nil
+}
+
+// probeResponsesModel 通过 GET /v1/models/{model} 探测模型在上游是否可用，不产生调用费用
+func probeResponsesModel(channel *model.Channel, key string, modelName string) error {
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = constant.ChannelBaseURLs[channel.Type]
+	}
+	// 多密钥渠道仅使用第一个密钥探测
+	key = strings.TrimSpace(strings.Split(strings.TrimSpace(key), "\n")[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/models/%s", strings.TrimRight(baseURL, "/"), url.PathEscape(modelName)), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
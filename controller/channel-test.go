@@ -319,6 +319,7 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		httpResp = resp.(*http.Response)
 		if httpResp.StatusCode != http.StatusOK {
 			err := service.RelayErrorHandler(c.Request.Context(), httpResp, true)
+			service.SetChannelCooldown(channel.Id, service.GetChannelCooldownKeyIndex(c), err.RetryAfter)
 			return testResult{
 				context:     c,
 				localErr:    err,
@@ -559,6 +560,10 @@ func testAllChannels(notify bool) error {
 		}()
 
 		for _, channel := range channels {
+			// 上游要求的限流冷却期内不进行探测，避免加重限流；多 key 渠道按 key 冷却，探测时才确定使用的 key，这里不跳过
+			if !channel.ChannelInfo.IsMultiKey && service.GetChannelCooldown(channel.Id, 0) > 0 {
+				continue
+			}
 			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
 			tik := time.Now()
 			result := testChannel(channel, "", "")
@@ -583,7 +588,7 @@ func testAllChannels(notify bool) error {
 
 			// disable channel
 			if isChannelEnabled && shouldBanChannel && channel.GetAutoBan() {
-				processChannelError(result.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(result.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+				processChannelError(result.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(result.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError, relaycommon.ConversionSourceNone)
 			}
 
 			// enable channel
@@ -6,13 +6,18 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel/claude"
+	"github.com/QuantumNous/new-api/relay/channel/openai_responses"
 	"github.com/QuantumNous/new-api/relay/channel/volcengine"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
 )
@@ -61,6 +66,12 @@ func clearChannelInfo(channel *model.Channel) {
 		channel.ChannelInfo.MultiKeyDisabledReason = nil
 		channel.ChannelInfo.MultiKeyDisabledTime = nil
 	}
+	// 组织 Admin Key 与渠道密钥一样不返回给前端，更新渠道时留空表示保持不变
+	if strings.Contains(channel.OtherSettings, "balance_admin_key") {
+		otherSettings := channel.GetOtherSettings()
+		otherSettings.BalanceAdminKey = ""
+		channel.SetOtherSettings(otherSettings)
+	}
 }
 
 func GetAllChannels(c *gin.Context) {
@@ -476,6 +487,39 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 		}
 	}
 
+	// OpenAI Responses 与 Claude 渠道的 Base URL 校验
+	if err := service.ValidateChannelConfig(channel); err != nil {
+		return err
+	}
+
+	// 维护窗口校验
+	otherSettings := channel.GetOtherSettings()
+	for i, window := range otherSettings.MaintenanceWindows {
+		if window.StartTime <= 0 || window.EndTime <= window.StartTime {
+			return fmt.Errorf("维护窗口 #%d 的结束时间必须晚于开始时间", i+1)
+		}
+		if window.DrainSeconds < 0 {
+			return fmt.Errorf("维护窗口 #%d 的排空时长不能为负数", i+1)
+		}
+	}
+
+	// 允许接口校验
+	for _, endpoint := range otherSettings.AllowedEndpoints {
+		if !dto.IsValidChannelEndpoint(endpoint) {
+			return fmt.Errorf("未知的接口: %s，可选值: %s", endpoint, strings.Join(dto.ChannelEndpoints, ", "))
+		}
+	}
+
+	// 服务目标校验
+	if slo := otherSettings.SLO; slo != nil {
+		if slo.FirstTokenP95Ms < 0 {
+			return fmt.Errorf("SLO 首字延迟不能为负数")
+		}
+		if slo.ErrorRatePercent < 0 || slo.ErrorRatePercent > 100 {
+			return fmt.Errorf("SLO 错误率必须在 0 到 100 之间")
+		}
+	}
+
 	// VertexAI 特殊校验
 	if channel.Type == constant.ChannelTypeVertexAi {
 		if channel.Other == "" {
@@ -550,6 +594,14 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	modelWarnings, err := checkResponsesChannelModels(addChannelRequest.Channel, addChannelRequest.Channel.Key, c.Query("probe_models") == "true")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 
 	addChannelRequest.Channel.CreatedTime = common.GetTimestamp()
 	keys := make([]string, 0)
@@ -627,9 +679,11 @@ func AddChannel(c *gin.Context) {
 		return
 	}
 	service.ResetProxyClientCache()
+	go service.RefreshChannelReadinessReport()
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
+		"success":  true,
+		"message":  "",
+		"warnings": modelWarnings,
 	})
 	return
 }
@@ -839,6 +893,28 @@ func UpdateChannel(c *gin.Context) {
 	// Always copy the original ChannelInfo so that fields like IsMultiKey and MultiKeySize are retained.
 	channel.ChannelInfo = originChannel.ChannelInfo
 
+	// 查询渠道时不返回组织 Admin Key，未重新填写时保留原值
+	if originAdminKey := originChannel.GetOtherSettings().BalanceAdminKey; originAdminKey != "" {
+		otherSettings := channel.GetOtherSettings()
+		if otherSettings.BalanceAdminKey == "" {
+			otherSettings.BalanceAdminKey = originAdminKey
+			channel.SetOtherSettings(otherSettings)
+		}
+	}
+
+	probeKey := channel.Key
+	if probeKey == "" {
+		probeKey = originChannel.Key
+	}
+	modelWarnings, err := checkResponsesChannelModels(&channel.Channel, probeKey, c.Query("probe_models") == "true")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	// If the request explicitly specifies a new MultiKeyMode, apply it on top of the original info.
 	if channel.MultiKeyMode != nil && *channel.MultiKeyMode != "" {
 		channel.ChannelInfo.MultiKeyMode = constant.MultiKeyMode(*channel.MultiKeyMode)
@@ -913,12 +989,14 @@ func UpdateChannel(c *gin.Context) {
 	}
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
+	go service.RefreshChannelReadinessReport()
 	channel.Key = ""
 	clearChannelInfo(&channel.Channel)
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
-		"data":    channel,
+		"success":  true,
+		"message":  "",
+		"data":     channel,
+		"warnings": modelWarnings,
 	})
 	return
 }
@@ -1608,3 +1686,261 @@ func ManageMultiKeys(c *gin.Context) {
 		return
 	}
 }
+
+// GetResponsesQuarantine 获取未通过结构校验而被隔离的上游 Responses 响应
+func GetResponsesQuarantine(c *gin.Context) {
+	entries, counts := service.GetResponsesQuarantine()
+	common.ApiSuccess(c, gin.H{
+		"items":             entries,
+		"incompatibilities": counts,
+	})
+}
+
+// GetQualitySamples 获取转换质量抽样审核队列，可按审核结论与渠道筛选
+func GetQualitySamples(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	common.ApiSuccess(c, gin.H{
+		"items":   service.GetQualitySamples(c.Query("verdict"), channelId),
+		"summary": service.GetQualitySampleSummary(),
+	})
+}
+
+// GetQualitySample 获取单个转换质量抽样样本
+func GetQualitySample(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sample, ok := service.GetQualitySample(id)
+	if !ok {
+		common.ApiErrorMsg(c, "样本不存在")
+		return
+	}
+	common.ApiSuccess(c, sample)
+}
+
+type qualitySampleReviewRequest struct {
+	Verdict string `json:"verdict"`
+	Note    string `json:"note"`
+}
+
+// ReviewQualitySample 记录转换质量抽样样本的人工审核结论
+func ReviewQualitySample(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req qualitySampleReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sample, err := service.ReviewQualitySample(id, req.Verdict, req.Note)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sample)
+}
+
+// DeleteQualitySample 从审核队列中删除转换质量抽样样本
+func DeleteQualitySample(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !service.DeleteQualitySample(id) {
+		common.ApiErrorMsg(c, "样本不存在")
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetConversionTraces 分页获取转换追踪记录列表，只包含摘要
+func GetConversionTraces(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	traces, total, err := model.GetConversionTraces(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(traces)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetConversionTrace 按请求 ID 获取转换追踪记录，包含原始请求、上游请求、上游原始响应与返回给客户端的响应
+func GetConversionTrace(c *gin.Context) {
+	trace, err := model.GetConversionTraceByRequestId(c.Param("request_id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "追踪记录不存在")
+		return
+	}
+	common.ApiSuccess(c, trace)
+}
+
+type streamReplayRequest struct {
+	SampleId        int64                        `json:"sample_id"`
+	ConvertedFrom   relaycommon.ConversionSource `json:"converted_from"`
+	Model           string                       `json:"model"`
+	OriginalRequest string                       `json:"original_request"`
+	Transcript      string                       `json:"transcript"`
+}
+
+// ReplayConversionStream 将录制的上游 Responses 事件流在进程内重新送入流式转换器，返回转换器下发的客户端事件
+// 指定 sample_id 时使用质量抽样样本中记录的原始请求与上游事件流，请求中的其他非空字段会覆盖样本内容
+func ReplayConversionStream(c *gin.Context) {
+	var req streamReplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.SampleId != 0 {
+		sample, ok := service.GetQualitySample(req.SampleId)
+		if !ok {
+			common.ApiErrorMsg(c, "样本不存在")
+			return
+		}
+		if !sample.IsStream {
+			common.ApiErrorMsg(c, "样本不是流式请求，无法回放")
+			return
+		}
+		if req.ConvertedFrom == "" {
+			req.ConvertedFrom = sample.ConvertedFrom
+		}
+		if req.Model == "" {
+			req.Model = sample.UpstreamModel
+		}
+		if req.OriginalRequest == "" {
+			req.OriginalRequest = sample.OriginalRequest
+		}
+		if req.Transcript == "" {
+			req.Transcript = sample.Response
+		}
+	}
+	if strings.TrimSpace(req.Transcript) == "" {
+		common.ApiErrorMsg(c, "事件流不能为空")
+		return
+	}
+	result, err := openai_responses.ReplayStream(req.ConvertedFrom, req.Model, req.OriginalRequest, req.Transcript)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
+
+// GetChannelMaintenance 获取配置了未结束维护窗口的渠道，包括当前是否处于排空或维护中以及进行中的请求数，用于确认流量已排空
+func GetChannelMaintenance(c *gin.Context) {
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	now := common.GetTimestamp()
+	items := make([]gin.H, 0)
+	for _, channel := range channels {
+		otherSettings := channel.GetOtherSettings()
+		windows := make([]dto.ChannelMaintenanceWindow, 0, len(otherSettings.MaintenanceWindows))
+		for _, window := range otherSettings.MaintenanceWindows {
+			if window.EndTime > now {
+				windows = append(windows, window)
+			}
+		}
+		if len(windows) == 0 {
+			continue
+		}
+		state := "scheduled"
+		if window := otherSettings.GetActiveMaintenanceWindow(now); window != nil {
+			state = "maintenance"
+			if window.IsDraining(now) {
+				state = "draining"
+			}
+		}
+		items = append(items, gin.H{
+			"channel_id":   channel.Id,
+			"channel_name": channel.Name,
+			"state":        state,
+			"in_flight":    service.GetChannelInFlight(channel.Id),
+			"windows":      windows,
+		})
+	}
+	common.ApiSuccess(c, items)
+}
+
+// GetChannelHealth 获取渠道按时间分桶的错误码与流中断统计，用于区分网关问题与上游故障
+func GetChannelHealth(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	since, _ := strconv.ParseInt(c.Query("since"), 10, 64)
+	if since <= 0 {
+		since = time.Now().Add(-time.Hour).Unix()
+	}
+	bucketSeconds, _ := strconv.ParseInt(c.Query("bucket"), 10, 64)
+	common.ApiSuccess(c, service.GetChannelHealthSeries(channelId, since, bucketSeconds))
+}
+
+// GetChannelSLO 获取配置了服务目标的渠道在统计窗口内的达标情况，以及是否因违反 SLO 被降级
+func GetChannelSLO(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	items := make([]service.ChannelSLOStatus, 0)
+	for _, channel := range channels {
+		if channelId != 0 && channel.Id != channelId {
+			continue
+		}
+		if !channel.GetOtherSettings().SLO.IsSet() {
+			continue
+		}
+		items = append(items, service.EvaluateChannelSLO(channel))
+	}
+	common.ApiSuccess(c, items)
+}
+
+// SimulateChannelRoute 模拟指定令牌以指定入站接口请求模型时会依次尝试的渠道，用于排查路由决策
+func SimulateChannelRoute(c *gin.Context) {
+	modelName := c.Query("model")
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	if modelName == "" || tokenId <= 0 {
+		common.ApiErrorMsg(c, "model 和 token_id 不能为空")
+		return
+	}
+	apiType := types.RelayFormat(c.DefaultQuery("api_type", string(types.RelayFormatOpenAI)))
+	claudeBeta, _ := strconv.ParseBool(c.Query("claude_beta"))
+	result, err := service.SimulateRoute(service.RouteSimulationRequest{
+		ModelName:  modelName,
+		TokenId:    tokenId,
+		ApiType:    apiType,
+		ClaudeBeta: claudeBeta,
+		ClaudeChannelResponsesRouted: func(channel *model.Channel) bool {
+			return claude.IsResponsesRoutedModel(channel.GetOtherSettings(), modelName)
+		},
+	})
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
+
+// GetChannelReadiness 获取渠道配置就绪报告，refresh=true 时重新检查
+func GetChannelReadiness(c *gin.Context) {
+	var report *service.ChannelReadinessReport
+	var err error
+	if c.Query("refresh") == "true" {
+		report, err = service.RefreshChannelReadinessReport()
+	} else {
+		report, err = service.GetChannelReadinessReport()
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/relay/channel"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelCapabilities 获取渠道支持的接口与特性，渠道配置了允许的接口时，未允许的接口视为不支持
+func GetChannelCapabilities(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	ch, err := model.GetChannelById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	apiType, _ := common.ChannelType2APIType(ch.Type)
+	adaptor := relay.GetAdaptor(apiType)
+	if adaptor == nil {
+		common.ApiErrorMsg(c, fmt.Sprintf("渠道类型 %d 没有对应的适配器", ch.Type))
+		return
+	}
+	capabilities := adaptor.Capabilities()
+	restrictChannelCapabilities(ch, &capabilities)
+	common.ApiSuccess(c, gin.H{
+		"channel_id":   ch.Id,
+		"channel_type": ch.Type,
+		"capabilities": capabilities,
+	})
+}
+
+// restrictChannelCapabilities 按渠道允许的接口收窄适配器支持的接口
+func restrictChannelCapabilities(ch *model.Channel, capabilities *channel.Capabilities) {
+	endpoints := map[string]*bool{
+		dto.ChannelEndpointChatCompletions: &capabilities.Chat,
+		dto.ChannelEndpointResponses:       &capabilities.Responses,
+		dto.ChannelEndpointClaudeMessages:  &capabilities.Claude,
+		dto.ChannelEndpointGemini:          &capabilities.Gemini,
+		dto.ChannelEndpointEmbeddings:      &capabilities.Embeddings,
+		dto.ChannelEndpointAudio:           &capabilities.Audio,
+		dto.ChannelEndpointImages:          &capabilities.Images,
+		dto.ChannelEndpointRerank:          &capabilities.Rerank,
+	}
+	for endpoint, supported := range endpoints {
+		if *supported && !ch.IsEndpointAllowed(endpoint) {
+			*supported = false
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelGroupBindings 获取所有用户与令牌的渠道分组绑定
+func GetChannelGroupBindings(c *gin.Context) {
+	common.ApiSuccess(c, model_setting.GetChannelGroupBindingSettings().Bindings)
+}
+
+// UpdateChannelGroupBinding 新增或修改一个用户或令牌的渠道分组绑定，配置会持久化并同步到其他节点
+func UpdateChannelGroupBinding(c *gin.Context) {
+	var req model_setting.ChannelGroupBinding
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	req.Group = strings.TrimSpace(req.Group)
+	if req.Group == "" || strings.Contains(req.Group, ",") {
+		common.ApiErrorMsg(c, "分组不能为空且只能绑定一个分组")
+		return
+	}
+	if !ratio_setting.ContainsGroupRatio(req.Group) {
+		common.ApiErrorMsg(c, fmt.Sprintf("分组 %s 不存在", req.Group))
+		return
+	}
+	switch req.Scope {
+	case model_setting.ChannelGroupBindingScopeUser:
+		if _, err := model.GetUserById(req.Id, false); err != nil {
+			common.ApiErrorMsg(c, fmt.Sprintf("用户 %d 不存在", req.Id))
+			return
+		}
+	case model_setting.ChannelGroupBindingScopeToken:
+		if _, err := model.GetTokenById(req.Id); err != nil {
+			common.ApiErrorMsg(c, fmt.Sprintf("令牌 %d 不存在", req.Id))
+			return
+		}
+	default:
+		common.ApiErrorMsg(c, fmt.Sprintf("未知的绑定范围: %s", req.Scope))
+		return
+	}
+
+	current := model_setting.GetChannelGroupBindingSettings().Bindings
+	bindings := make([]model_setting.ChannelGroupBinding, 0, len(current)+1)
+	for _, binding := range current {
+		if binding.Scope != req.Scope || binding.Id != req.Id {
+			bindings = append(bindings, binding)
+		}
+	}
+	bindings = append(bindings, req)
+	saveChannelGroupBindings(c, bindings)
+}
+
+// DeleteChannelGroupBinding 删除一个用户或令牌的渠道分组绑定
+func DeleteChannelGroupBinding(c *gin.Context) {
+	scope := c.Param("scope")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "无效的 ID")
+		return
+	}
+	current := model_setting.GetChannelGroupBindingSettings().Bindings
+	bindings := make([]model_setting.ChannelGroupBinding, 0, len(current))
+	for _, binding := range current {
+		if binding.Scope != scope || binding.Id != id {
+			bindings = append(bindings, binding)
+		}
+	}
+	if len(bindings) == len(current) {
+		common.ApiErrorMsg(c, "绑定不存在")
+		return
+	}
+	saveChannelGroupBindings(c, bindings)
+}
+
+// saveChannelGroupBindings 保存绑定列表并返回保存后的绑定
+func saveChannelGroupBindings(c *gin.Context, bindings []model_setting.ChannelGroupBinding) {
+	data, err := common.Marshal(bindings)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.UpdateOption("channel_group_binding.bindings", string(data)); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	GetChannelGroupBindings(c)
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReloadClaudeSmartRouting 立即从数据库重新加载指定渠道，使其智能路由配置的修改在当前节点生效，返回生效后的配置
+// 只刷新该渠道的缓存，不影响其他渠道与全局配置
+func ReloadClaudeSmartRouting(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "无效的渠道 ID")
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		common.ApiErrorMsg(c, fmt.Sprintf("渠道 %d 不存在", id))
+		return
+	}
+	model.CacheUpdateChannel(channel)
+	common.ApiSuccess(c, channel.GetOtherSettings().ClaudeSmartRouting)
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetConversionKillSwitch 获取各转换方向的开关状态
+func GetConversionKillSwitch(c *gin.Context) {
+	items := make([]gin.H, 0, len(model_setting.ConversionDirections))
+	for _, direction := range model_setting.ConversionDirections {
+		items = append(items, gin.H{
+			"direction":       direction,
+			"disabled":        model_setting.IsConversionDirectionDisabled(direction),
+			"disabled_by_env": model_setting.IsConversionDirectionDisabledByEnv(direction),
+		})
+	}
+	common.ApiSuccess(c, items)
+}
+
+type conversionKillSwitchRequest struct {
+	Directions []string `json:"directions"`
+	Disabled   bool     `json:"disabled"`
+}
+
+// UpdateConversionKillSwitch 一次性关闭或重新开启一个或多个转换方向，配置会持久化并同步到其他节点
+// 由环境变量关闭的方向无法通过接口重新开启
+func UpdateConversionKillSwitch(c *gin.Context) {
+	var req conversionKillSwitchRequest
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil || len(req.Directions) == 0 {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	for _, direction := range req.Directions {
+		if !model_setting.IsValidConversionDirection(direction) {
+			common.ApiErrorMsg(c, fmt.Sprintf("未知的转换方向: %s", direction))
+			return
+		}
+		if !req.Disabled && model_setting.IsConversionDirectionDisabledByEnv(direction) {
+			common.ApiErrorMsg(c, fmt.Sprintf("转换方向 %s 由环境变量 DISABLED_CONVERSION_DIRECTIONS 关闭，无法通过接口开启", direction))
+			return
+		}
+	}
+
+	current := model_setting.GetConversionKillSwitchSettings().DisabledDirections
+	disabled := make(map[string]bool, len(current)+len(req.Directions))
+	for _, direction := range current {
+		disabled[direction] = true
+	}
+	for _, direction := range req.Directions {
+		disabled[direction] = req.Disabled
+	}
+	directions := make([]string, 0, len(disabled))
+	for _, direction := range model_setting.ConversionDirections {
+		if disabled[direction] {
+			directions = append(directions, direction)
+		}
+	}
+	data, err := common.Marshal(directions)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.UpdateOption("conversion_kill_switch.disabled_directions", string(data)); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	GetConversionKillSwitch(c)
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetExperiments 获取所有 A/B 实验配置
+func GetExperiments(c *gin.Context) {
+	common.ApiSuccess(c, model_setting.GetExperimentSettings().Experiments)
+}
+
+// UpdateExperiments 替换 A/B 实验配置，配置会持久化并同步到其他节点
+func UpdateExperiments(c *gin.Context) {
+	var experiments []model_setting.Experiment
+	if err := common.DecodeJson(c.Request.Body, &experiments); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	if err := model_setting.ValidateExperiments(experiments); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	data, err := common.Marshal(experiments)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.UpdateOption("experiment.experiments", string(data)); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	GetExperiments(c)
+}
+
+// GetExperimentMetrics 获取 A/B 实验各分组的成功率、延迟与消耗，可通过 name 参数只查询一个实验
+// 指标保存在节点内存中，多节点部署时只包含处理本次查询的节点
+func GetExperimentMetrics(c *gin.Context) {
+	common.ApiSuccess(c, service.GetExperimentMetrics(c.Query("name")))
+}
+
+// ResetExperimentMetrics 清空一个实验已收集的指标
+func ResetExperimentMetrics(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := model_setting.GetExperiment(name); !ok {
+		common.ApiErrorMsg(c, "实验不存在")
+		return
+	}
+	service.ResetExperimentMetrics(name)
+	common.ApiSuccess(c, nil)
+}
@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -167,3 +169,105 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+// GetLogArchiveURL 获取日志中归档的完整请求体/响应体的限时下载链接
+func GetLogArchiveURL(c *gin.Context) {
+	signedURL, err := service.GetLogArchiveSignedURL(c.Query("key"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"url": signedURL,
+	})
+}
+
+func parseLogExportFilter(c *gin.Context) model.LogExportFilter {
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	return model.LogExportFilter{
+		LogType:        logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      c.Query("model_name"),
+		Channel:        channel,
+		ConvertedFrom:  c.Query("converted_from"),
+		ErrorCode:      c.Query("error_code"),
+	}
+}
+
+// ExportLogs 按条件导出日志为 NDJSON 或 CSV
+// 同步模式通过 before_id 游标分页，下一页游标由 X-Next-Cursor 响应头返回；async=true 时创建异步导出任务
+func ExportLogs(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		common.ApiErrorMsg(c, "format 仅支持 ndjson 或 csv")
+		return
+	}
+	filter := parseLogExportFilter(c)
+
+	if c.Query("async") == "true" {
+		job := service.StartLogExportJob(format, filter)
+		common.ApiSuccess(c, job)
+		return
+	}
+
+	beforeId, _ := strconv.Atoi(c.Query("before_id"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > service.LogExportMaxSyncRows {
+		limit = service.LogExportMaxSyncRows
+	}
+
+	// 先读取本页的 id 范围以便在写出响应体前确定下一页游标，日志内容随后流式写出
+	page, err := service.PrepareLogExportPage(filter, beforeId, limit)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	contentType := "application/x-ndjson"
+	if format == "csv" {
+		contentType = "text/csv"
+	}
+	c.Header("X-Next-Cursor", strconv.Itoa(page.NextCursor))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=logs.%s", format))
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	// 响应头已写出，写出失败时只能中断响应并记录日志
+	writer := service.NewLogExportWriter(format, c.Writer)
+	err = writer.WriteHeader()
+	if err == nil && page.Rows > 0 {
+		_, _, err = service.ExportLogsTo(writer, page.Filter, page.BeforeId, page.Rows)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		common.SysError("failed to export logs: " + err.Error())
+	}
+}
+
+// GetLogExportJob 查询异步导出任务状态
+func GetLogExportJob(c *gin.Context) {
+	job, _, ok := service.GetLogExportJob(c.Param("id"))
+	if !ok {
+		common.ApiErrorMsg(c, "导出任务不存在或已过期")
+		return
+	}
+	common.ApiSuccess(c, job)
+}
+
+// DownloadLogExport 下载已完成的异步导出结果
+func DownloadLogExport(c *gin.Context) {
+	job, filePath, ok := service.GetLogExportJob(c.Param("id"))
+	if !ok {
+		common.ApiErrorMsg(c, "导出任务不存在或已过期")
+		return
+	}
+	if job.Status != "completed" {
+		common.ApiErrorMsg(c, "导出任务尚未完成")
+		return
+	}
+	c.FileAttachment(filePath, fmt.Sprintf("logs-%s.%s", job.Id, job.Format))
+}
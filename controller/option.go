@@ -118,6 +118,14 @@ func UpdateOption(c *gin.Context) {
 				"message": "无法启用 Turnstile 校验，请先填入 Turnstile 校验相关配置信息！",
 			})
 
+			return
+		}
+	case "responses.response_id_mapping_enabled":
+		if option.Value == "true" && !common.RedisEnabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用响应 ID 映射，请先启用 Redis，否则映射只保存在单个实例的内存中！",
+			})
 			return
 		}
 	case "TelegramOAuthEnabled":
@@ -0,0 +1,434 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// pricingConfigVersion 定价配置文档的当前版本，导入时拒绝更高版本的文档
+const pricingConfigVersion = 1
+
+// pricingConfigSections 定价配置文档中的定价分区与对应的配置项
+var pricingConfigSections = []struct {
+	name      string
+	optionKey string
+}{
+	{"model_ratio", "ModelRatio"},
+	{"model_price", "ModelPrice"},
+	{"completion_ratio", "CompletionRatio"},
+	{"cache_ratio", "CacheRatio"},
+	{"image_ratio", "ImageRatio"},
+	{"audio_ratio", "AudioRatio"},
+	{"audio_completion_ratio", "AudioCompletionRatio"},
+	{"group_ratio", "GroupRatio"},
+}
+
+// groupGroupRatioOptionKey 分组间倍率的配置项，值为二级映射，单独作为文档的 group_group_ratio 字段导入导出
+const groupGroupRatioOptionKey = "GroupGroupRatio"
+
+// pricingConfigModel 模型元数据，供应商以名称表示，便于在不同实例间同步
+type pricingConfigModel struct {
+	ModelName   string `json:"model_name"`
+	Description string `json:"description,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	Tags        string `json:"tags,omitempty"`
+	Vendor      string `json:"vendor,omitempty"`
+	Endpoints   string `json:"endpoints,omitempty"`
+	Status      int    `json:"status"`
+	NameRule    int    `json:"name_rule"`
+}
+
+// pricingConfigDocument 声明式定价配置文档
+// GroupGroupRatio 为用户分组使用其他分组时的倍率，导入时缺省表示保持不变，出现时整体替换
+type pricingConfigDocument struct {
+	Version         int                           `json:"version"`
+	ExportedAt      int64                         `json:"exported_at,omitempty"`
+	Pricing         map[string]map[string]float64 `json:"pricing"`
+	GroupGroupRatio map[string]map[string]float64 `json:"group_group_ratio,omitempty"`
+	Models          []pricingConfigModel          `json:"models"`
+}
+
+// pricingValueChange 定价项的新旧值
+type pricingValueChange struct {
+	Old float64 `json:"old"`
+	New float64 `json:"new"`
+}
+
+// pricingSectionDiff 一个定价分区的差异，导入时分区整体替换，文档中没有的项会被删除
+type pricingSectionDiff struct {
+	Added   map[string]float64            `json:"added"`
+	Changed map[string]pricingValueChange `json:"changed"`
+	Removed []string                      `json:"removed"`
+}
+
+// pricingConfigDiff 导入文档与当前配置的差异，分组间倍率的键为 "用户分组:使用分组"
+type pricingConfigDiff struct {
+	Pricing         map[string]*pricingSectionDiff `json:"pricing"`
+	GroupGroupRatio *pricingSectionDiff            `json:"group_group_ratio,omitempty"`
+	CreatedModels   []string                       `json:"created_models"`
+	UpdatedModels   []string                       `json:"updated_models"`
+	UnchangedModels int                            `json:"unchanged_models"`
+}
+
+// ExportPricingConfig 导出定价倍率与模型元数据为带版本号的 JSON 文档，可导入到其他实例
+func ExportPricingConfig(c *gin.Context) {
+	doc, err := buildPricingConfigDocument()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	doc.ExportedAt = common.GetTimestamp()
+	common.ApiSuccess(c, doc)
+}
+
+// PreviewPricingConfigImport 校验待导入的文档并返回与当前配置的差异，不修改配置
+func PreviewPricingConfigImport(c *gin.Context) {
+	importPricingConfig(c, true)
+}
+
+// ImportPricingConfig 导入定价配置文档：文档中出现的定价分区与分组间倍率整体替换，未出现的保持不变；
+// 模型元数据按名称创建或更新，文档中没有的模型保持不变
+func ImportPricingConfig(c *gin.Context) {
+	importPricingConfig(c, false)
+}
+
+func importPricingConfig(c *gin.Context, dryRun bool) {
+	var doc pricingConfigDocument
+	if err := common.DecodeJson(c.Request.Body, &doc); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	if err := validatePricingConfigDocument(&doc); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	current, err := buildPricingConfigDocument()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	diff := diffPricingConfig(current, &doc)
+	if dryRun {
+		common.ApiSuccess(c, diff)
+		return
+	}
+	if err := applyPricingConfig(&doc, diff); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, diff)
+}
+
+// buildPricingConfigDocument 读取当前的定价配置与模型元数据，模型按名称排序
+func buildPricingConfigDocument() (*pricingConfigDocument, error) {
+	doc := &pricingConfigDocument{
+		Version: pricingConfigVersion,
+		Pricing: make(map[string]map[string]float64, len(pricingConfigSections)),
+	}
+	common.OptionMapRWMutex.RLock()
+	values := make(map[string]string, len(pricingConfigSections)+1)
+	for _, section := range pricingConfigSections {
+		values[section.optionKey] = common.OptionMap[section.optionKey]
+	}
+	values[groupGroupRatioOptionKey] = common.OptionMap[groupGroupRatioOptionKey]
+	common.OptionMapRWMutex.RUnlock()
+	for _, section := range pricingConfigSections {
+		entries := make(map[string]float64)
+		if value := values[section.optionKey]; value != "" {
+			if err := common.UnmarshalJsonStr(value, &entries); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", section.optionKey, err)
+			}
+		}
+		doc.Pricing[section.name] = entries
+	}
+	doc.GroupGroupRatio = make(map[string]map[string]float64)
+	if value := values[groupGroupRatioOptionKey]; value != "" {
+		if err := common.UnmarshalJsonStr(value, &doc.GroupGroupRatio); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", groupGroupRatioOptionKey, err)
+		}
+	}
+
+	var vendors []model.Vendor
+	if err := model.DB.Find(&vendors).Error; err != nil {
+		return nil, err
+	}
+	vendorNames := make(map[int]string, len(vendors))
+	for _, vendor := range vendors {
+		vendorNames[vendor.Id] = vendor.Name
+	}
+	var models []model.Model
+	if err := model.DB.Order("model_name").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	doc.Models = make([]pricingConfigModel, 0, len(models))
+	for _, m := range models {
+		doc.Models = append(doc.Models, pricingConfigModel{
+			ModelName:   m.ModelName,
+			Description: m.Description,
+			Icon:        m.Icon,
+			Tags:        m.Tags,
+			Vendor:      vendorNames[m.VendorID],
+			Endpoints:   m.Endpoints,
+			Status:      m.Status,
+			NameRule:    m.NameRule,
+		})
+	}
+	return doc, nil
+}
+
+// validatePricingConfigDocument 校验文档版本、分区名称、定价项、分组倍率与模型元数据，预览与导入使用同一校验，全部通过后才会修改配置
+func validatePricingConfigDocument(doc *pricingConfigDocument) error {
+	if doc.Version <= 0 || doc.Version > pricingConfigVersion {
+		return fmt.Errorf("不支持的定价配置版本: %d，当前支持的最高版本为 %d", doc.Version, pricingConfigVersion)
+	}
+	knownSections := make(map[string]bool, len(pricingConfigSections))
+	for _, section := range pricingConfigSections {
+		knownSections[section.name] = true
+	}
+	for name, entries := range doc.Pricing {
+		if !knownSections[name] {
+			return fmt.Errorf("未知的定价分区: %s", name)
+		}
+		for key, value := range entries {
+			if strings.TrimSpace(key) == "" {
+				return fmt.Errorf("定价分区 %s 中存在空的名称", name)
+			}
+			if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+				return fmt.Errorf("定价分区 %s 中 %s 的值无效: %v", name, key, value)
+			}
+		}
+		if name == "group_ratio" {
+			data, err := common.Marshal(entries)
+			if err != nil {
+				return err
+			}
+			if err := ratio_setting.CheckGroupRatio(string(data)); err != nil {
+				return err
+			}
+		}
+	}
+	for userGroup, ratios := range doc.GroupGroupRatio {
+		if strings.TrimSpace(userGroup) == "" {
+			return fmt.Errorf("分组间倍率中存在空的用户分组")
+		}
+		for usingGroup, value := range ratios {
+			if strings.TrimSpace(usingGroup) == "" {
+				return fmt.Errorf("分组间倍率 %s 中存在空的使用分组", userGroup)
+			}
+			if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+				return fmt.Errorf("分组间倍率 %s:%s 的值无效: %v", userGroup, usingGroup, value)
+			}
+		}
+	}
+	names := make(map[string]bool, len(doc.Models))
+	for _, m := range doc.Models {
+		if strings.TrimSpace(m.ModelName) == "" {
+			return fmt.Errorf("模型名称不能为空")
+		}
+		if names[m.ModelName] {
+			return fmt.Errorf("模型名称重复: %s", m.ModelName)
+		}
+		names[m.ModelName] = true
+		if m.NameRule < model.NameRuleExact || m.NameRule > model.NameRuleSuffix {
+			return fmt.Errorf("模型 %s 的名称匹配规则无效: %d", m.ModelName, m.NameRule)
+		}
+		if m.Endpoints != "" {
+			var endpoints any
+			if err := common.UnmarshalJsonStr(m.Endpoints, &endpoints); err != nil {
+				return fmt.Errorf("模型 %s 的端点配置不是合法的 JSON: %s", m.ModelName, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// diffPricingConfig 计算导入文档相对当前配置的差异，文档中未出现的定价分区不参与比较
+func diffPricingConfig(current *pricingConfigDocument, doc *pricingConfigDocument) *pricingConfigDiff {
+	diff := &pricingConfigDiff{
+		Pricing:       make(map[string]*pricingSectionDiff),
+		CreatedModels: []string{},
+		UpdatedModels: []string{},
+	}
+	for name, entries := range doc.Pricing {
+		diff.Pricing[name] = diffPricingSection(current.Pricing[name], entries)
+	}
+	if doc.GroupGroupRatio != nil {
+		diff.GroupGroupRatio = diffPricingSection(flattenGroupGroupRatio(current.GroupGroupRatio), flattenGroupGroupRatio(doc.GroupGroupRatio))
+	}
+
+	existingModels := make(map[string]pricingConfigModel, len(current.Models))
+	for _, m := range current.Models {
+		existingModels[m.ModelName] = m
+	}
+	for _, m := range doc.Models {
+		existing, ok := existingModels[m.ModelName]
+		switch {
+		case !ok:
+			diff.CreatedModels = append(diff.CreatedModels, m.ModelName)
+		case existing != m:
+			diff.UpdatedModels = append(diff.UpdatedModels, m.ModelName)
+		default:
+			diff.UnchangedModels++
+		}
+	}
+	return diff
+}
+
+// diffPricingSection 计算一个分区整体替换前后的差异
+func diffPricingSection(existing map[string]float64, entries map[string]float64) *pricingSectionDiff {
+	section := &pricingSectionDiff{
+		Added:   make(map[string]float64),
+		Changed: make(map[string]pricingValueChange),
+		Removed: []string{},
+	}
+	for key, value := range entries {
+		old, ok := existing[key]
+		if !ok {
+			section.Added[key] = value
+		} else if old != value {
+			section.Changed[key] = pricingValueChange{Old: old, New: value}
+		}
+	}
+	for key := range existing {
+		if _, ok := entries[key]; !ok {
+			section.Removed = append(section.Removed, key)
+		}
+	}
+	sort.Strings(section.Removed)
+	return section
+}
+
+// flattenGroupGroupRatio 将分组间倍率展开为 "用户分组:使用分组" 到倍率的映射，便于比较差异
+func flattenGroupGroupRatio(ratios map[string]map[string]float64) map[string]float64 {
+	flat := make(map[string]float64)
+	for userGroup, groups := range ratios {
+		for usingGroup, value := range groups {
+			flat[userGroup+":"+usingGroup] = value
+		}
+	}
+	return flat
+}
+
+// hasChanges 分区是否有变化
+func (d *pricingSectionDiff) hasChanges() bool {
+	return len(d.Added) > 0 || len(d.Changed) > 0 || len(d.Removed) > 0
+}
+
+// pricingOptionUpdate 待写入的定价配置项及写入前的值，用于失败时回滚
+type pricingOptionUpdate struct {
+	key      string
+	value    string
+	previous string
+}
+
+// applyPricingConfig 在一个数据库事务中创建或更新模型元数据并保存有变化的定价配置项
+// 任一步骤失败时事务回滚，已生效的内存配置恢复为导入前的值，不会留下部分生效的配置
+// 定价配置项通过配置项持久化并同步到其他节点
+func applyPricingConfig(doc *pricingConfigDocument, diff *pricingConfigDiff) error {
+	var updates []pricingOptionUpdate
+	for _, section := range pricingConfigSections {
+		sectionDiff, ok := diff.Pricing[section.name]
+		if !ok || !sectionDiff.hasChanges() {
+			continue
+		}
+		data, err := common.Marshal(doc.Pricing[section.name])
+		if err != nil {
+			return err
+		}
+		updates = append(updates, pricingOptionUpdate{key: section.optionKey, value: string(data)})
+	}
+	if diff.GroupGroupRatio != nil && diff.GroupGroupRatio.hasChanges() {
+		data, err := common.Marshal(doc.GroupGroupRatio)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, pricingOptionUpdate{key: groupGroupRatioOptionKey, value: string(data)})
+	}
+	common.OptionMapRWMutex.RLock()
+	for i := range updates {
+		updates[i].previous = common.OptionMap[updates[i].key]
+	}
+	common.OptionMapRWMutex.RUnlock()
+
+	changed := make(map[string]bool, len(diff.CreatedModels)+len(diff.UpdatedModels))
+	for _, name := range diff.CreatedModels {
+		changed[name] = true
+	}
+	for _, name := range diff.UpdatedModels {
+		changed[name] = true
+	}
+	// 供应商在事务外按名称查找或创建，重复导入时复用，不影响回滚后的配置
+	vendorIDCache := make(map[string]int)
+	createdVendors := 0
+	for _, m := range doc.Models {
+		if changed[m.ModelName] {
+			ensureVendorID(m.Vendor, nil, vendorIDCache, &createdVendors)
+		}
+	}
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		now := common.GetTimestamp()
+		for _, m := range doc.Models {
+			if !changed[m.ModelName] {
+				continue
+			}
+			var local model.Model
+			exists := tx.Where("model_name = ?", m.ModelName).First(&local).Error == nil
+			local.ModelName = m.ModelName
+			local.Description = m.Description
+			local.Icon = m.Icon
+			local.Tags = m.Tags
+			local.VendorID = vendorIDCache[m.Vendor]
+			local.Endpoints = m.Endpoints
+			local.Status = m.Status
+			local.NameRule = m.NameRule
+			local.UpdatedTime = now
+			var err error
+			if exists {
+				err = tx.Model(&model.Model{}).Where("id = ?", local.Id).Omit("created_time").Select("*").Updates(&local).Error
+			} else {
+				local.CreatedTime = now
+				err = tx.Create(&local).Error
+			}
+			if err != nil {
+				return fmt.Errorf("failed to save model %s: %w", m.ModelName, err)
+			}
+		}
+
+		for _, update := range updates {
+			if err := model.SaveOptionTx(tx, update.key, update.value); err != nil {
+				return fmt.Errorf("failed to update %s: %w", update.key, err)
+			}
+		}
+		// 内存配置在事务提交前生效，任一项失败时回滚事务
+		for _, update := range updates {
+			if err := model.UpdateOptionMap(update.key, update.value); err != nil {
+				return fmt.Errorf("failed to update %s: %w", update.key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil && len(updates) > 0 {
+		// 事务回滚时部分内存配置可能已生效，统一恢复为导入前的值
+		restorePricingOptionMap(updates)
+	}
+	return err
+}
+
+// restorePricingOptionMap 将内存中的定价配置项恢复为导入前的值
+func restorePricingOptionMap(updates []pricingOptionUpdate) {
+	for i := len(updates) - 1; i >= 0; i-- {
+		if err := model.UpdateOptionMap(updates[i].key, updates[i].previous); err != nil {
+			common.SysError(fmt.Sprintf("failed to restore option %s: %s", updates[i].key, err.Error()))
+		}
+	}
+}
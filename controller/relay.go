@@ -2,11 +2,13 @@ package controller
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -20,6 +22,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -62,6 +65,10 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewA
 }
 
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
+	if shouldRelayAsync(c, relayFormat) {
+		relayAsync(c, relayFormat)
+		return
+	}
 
 	requestId := c.GetString(common.RequestIdKey)
 	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
@@ -82,9 +89,16 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		defer ws.Close()
 	}
 
+	// 调试模式下记录转换追踪，需在错误响应写出之后保存
+	trace := service.StartConversionTrace(c)
+	defer func() {
+		trace.Finish(newAPIError)
+	}()
+
 	defer func() {
 		if newAPIError != nil {
 			logger.LogError(c, fmt.Sprintf("relay error: %s", newAPIError.Error()))
+			newAPIError.Localize(service.GetErrorMessageLanguage(c))
 			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
 			switch relayFormat {
 			case types.RelayFormatOpenAIRealtime:
@@ -104,6 +118,14 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	request, err := helper.GetAndValidateRequest(c, relayFormat)
 	if err != nil {
+		var claudeValidationErr *helper.ClaudeRequestValidationError
+		if errors.As(err, &claudeValidationErr) {
+			newAPIError = types.WithClaudeError(types.ClaudeError{
+				Type:    "invalid_request_error",
+				Message: claudeValidationErr.Message,
+			}, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+			return
+		}
 		newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
 		return
 	}
@@ -113,6 +135,11 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		newAPIError = types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
 		return
 	}
+	trace.Attach(relayInfo)
+	// 请求结束时记录所在 A/B 实验分组的结果
+	defer func() {
+		service.ObserveExperiment(c, relayInfo, newAPIError)
+	}()
 
 	meta := request.GetTokenCountMeta()
 
@@ -125,6 +152,11 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}
 
+	newAPIError = service.CheckRequestAbuse(c, request, meta)
+	if newAPIError != nil {
+		return
+	}
+
 	tokens, err := service.CountRequestToken(c, meta, relayInfo)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -141,6 +173,11 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	// common.SetContextKey(c, constant.ContextKeyTokenCountMeta, meta)
 
+	newAPIError = service.CheckTokenRateLimit(relayInfo)
+	if newAPIError != nil {
+		return
+	}
+
 	if priceData.FreeModel {
 		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
 	} else {
@@ -166,28 +203,109 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 
 addUsedChannel(c, channel.Id)
+		keyIndex := service.GetChannelCooldownKeyIndex(c)
+		if maxWait := model_setting.GetRetryAfterMaxWait(); maxWait > 0 && !service.WaitChannelCooldown(c.Request.Context().Done(), channel.Id, keyIndex, maxWait) {
+			if c.Request.Context().Err() != nil {
+				break
+			}
+			// 渠道仍处于上游要求的限流冷却期内且超过最长等待时间，直接尝试下一个渠道
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgChannelCoolingDown, channel.Id, service.GetChannelCooldown(channel.Id, keyIndex).Round(time.Second).String()), types.ErrorCodeChannelCoolingDown, http.StatusTooManyRequests)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
+		if window := channel.GetActiveMaintenanceWindow(common.GetTimestamp()); window != nil {
+			// 指定渠道或未启用内存缓存时选择阶段不会过滤维护中的渠道，这里跳过
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgChannelInMaintenance, channel.Id, time.Unix(window.EndTime, 0).Format(time.RFC3339)), types.ErrorCodeChannelInMaintenance, http.StatusServiceUnavailable)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
+		if endpoint := service.GetRelayEndpoint(relayFormat, relayInfo.RelayMode); !channel.IsEndpointAllowed(endpoint) {
+			// 指定渠道时选择阶段不会按渠道允许的接口筛选，这里跳过不允许当前接口的渠道
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgChannelEndpointNotAllowed, channel.Id, endpoint), types.ErrorCodeEndpointNotAllowed, http.StatusServiceUnavailable)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
+		if endpoint := service.GetRelayEndpoint(relayFormat, relayInfo.RelayMode); !service.IsChannelTypeEndpointSupported(channel.Type, endpoint) {
+			// 指定渠道时选择阶段不会排除无法处理该接口的渠道类型，这里直接返回该渠道支持的接口
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgChannelEndpointUnsupported, channel.Id, endpoint, strings.Join(service.GetChannelTypeSupportedEndpoints(channel.Type), ", ")), types.ErrorCodeEndpointUnsupported, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+			break
+		}
+		if direction, disabled := service.IsChannelConversionDisabled(channel.Type, relayFormat); disabled {
+			// 指定渠道时选择阶段不会按转换方向筛选，该转换方向已被全局关闭时跳过需要转换的渠道，回退到原生渠道
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgConversionDisabled, direction, channel.Id), types.ErrorCodeConversionDisabled, http.StatusServiceUnavailable)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
+		if blockType, native := service.ShouldRouteClaudeBlocksNatively(channel.Type, request); native {
+			// 指定渠道时选择阶段不会按内容块筛选，交互式工具调用等内容块在转换后会丢失，跳过 Responses 渠道，交由原生 Claude 渠道处理
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgChannelClaudeBlockNative, channel.Id, blockType), types.ErrorCodeConvertRequestFailed, http.StatusServiceUnavailable)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
+		if relayFormat == types.RelayFormatClaude && service.ShouldRouteClaudeBetaNatively(c, channel.Type) {
+			// 指定渠道时选择阶段不会按 beta 路由筛选，beta 请求按路由配置只能由原生 Claude 渠道处理，跳过 Responses 渠道
+			newAPIError = types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgChannelClaudeBetaNative, channel.Id), types.ErrorCodeConvertRequestFailed, http.StatusServiceUnavailable)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
+		attemptStartTime := time.Now()
+		relayInfo.StreamAborted = false
+		relayInfo.StreamTerminated = false
+		relayInfo.TokenRateReported = 0
+		relayInfo.StreamErrored = false
+		relayInfo.ResetAttemptOutput()
+		relayInfo.ResetConversion()
 		requestBody, _ := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
 		// 将请求体存储到 relayInfo 中
 		relayInfo.RequestBody = string(requestBody)
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
+		newAPIError = dispatchRelay(c, relayFormat, relayInfo, channel.Id)
+
+		if newAPIError != nil && !relayInfo.RequestDeadline.IsZero() && (relayInfo.DeadlineExceeded || time.Now().After(relayInfo.RequestDeadline)) {
+			// 客户端指定的截止时间已到，读取上游响应失败等错误统一按超时返回；超时由客户端设置，不计入渠道健康统计，也不再重试
+			relayInfo.DeadlineExceeded = true
+			newAPIError = helper.RequestDeadlineExceededError(relayInfo)
+			break
 		}
 
+		service.RecordChannelHealth(channel.Id, newAPIError, relayInfo.StreamAborted && !relayInfo.DeadlineExceeded)
+		service.ObserveChannelSLO(channel, newAPIError, attemptStartTime, relayInfo.FirstResponseTime)
+
 		if newAPIError == nil {
+			service.RecordValidationModelSuccess(relayInfo, originalModel)
+			mirrorValidationTraffic(c, relayFormat, originalModel)
 			return
 		}
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		attempt := relaycommon.RelayAttempt{
+			ChannelId:     channel.Id,
+			ChannelName:   channel.Name,
+			StatusCode:    newAPIError.StatusCode,
+			ErrorCode:     string(newAPIError.GetErrorCode()),
+			Error:         newAPIError.MaskSensitiveError(),
+			StreamStarted: relayInfo.FirstResponseTime.After(attemptStartTime),
+			UseTimeMs:     time.Since(attemptStartTime).Milliseconds(),
+		}
+		service.FillRelayAttemptUsage(relayInfo, &attempt)
+		relayInfo.Attempts = append(relayInfo.Attempts, attempt)
+
+		service.SetChannelCooldown(channel.Id, keyIndex, newAPIError.RetryAfter)
+
+		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError, relayInfo.ConversionSource)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
 			break
@@ -208,6 +326,22 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// dispatchRelay 按请求格式将请求转发到当前渠道，转发期间计入渠道的进行中请求数，转发异常退出时同样扣减
+func dispatchRelay(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, channelId int) *types.NewAPIError {
+	service.IncreaseChannelInFlight(channelId)
+	defer service.DecreaseChannelInFlight(channelId)
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, relayInfo)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, relayInfo)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, relayInfo)
+	default:
+		return relayHandler(c, relayInfo)
+	}
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -228,12 +362,19 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	var channel *model.Channel
+	var selectGroup string
+	var err error
+	if policy, ok := model_setting.GetModelGroupPolicy(common.GetContextKeyString(c, constant.ContextKeyModelGroup)); ok {
+		channel, selectGroup, err = service.CacheGetModelGroupChannel(c, group, originalModel, policy, retryCount)
+	} else {
+		channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	}
 	if err != nil {
-		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+		return nil, types.NewError(types.NewLocalizedError(types.ErrMsgRetryGetChannelFailed, selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
 	if channel == nil {
-		return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在（retry）", selectGroup, originalModel), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+		return nil, types.NewError(types.NewLocalizedError(types.ErrMsgRetryChannelNotFound, selectGroup, originalModel), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
 	newAPIError := middleware.SetupContextForSelectedChannel(c, channel, originalModel)
 	if newAPIError != nil {
@@ -284,7 +425,7 @@ func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) b
 	return true
 }
 
-func processChannelError(c *gin.Context, channelError types.ChannelError, err *types.NewAPIError) {
+func processChannelError(c *gin.Context, channelError types.ChannelError, err *types.NewAPIError, conversionSource relaycommon.ConversionSource) {
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
@@ -308,6 +449,9 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 		}
 		other["error_type"] = err.GetErrorType()
 		other["error_code"] = err.GetErrorCode()
+		if conversionSource != relaycommon.ConversionSourceNone {
+			other["converted_from"] = string(conversionSource)
+		}
 		other["status_code"] = err.StatusCode
 		other["channel_id"] = channelId
 		other["channel_name"] = c.GetString("channel_name")
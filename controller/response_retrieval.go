@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	relaychannel "github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RetrieveResponse 按网关 ID 查询上游保存的响应
+func RetrieveResponse(c *gin.Context) {
+	relayStoredResponse(c, http.MethodGet, "")
+}
+
+// CancelResponse 按网关 ID 取消上游的后台响应
+func CancelResponse(c *gin.Context) {
+	relayStoredResponse(c, http.MethodPost, "/cancel")
+}
+
+// relayStoredResponse 按 ID 映射找回上游 ID 与生成响应的渠道，向该渠道转发查询或取消请求，返回结果中的上游 ID 替换为网关 ID
+// 请求通过渠道适配器构建，与生成响应时使用相同的地址、认证与请求头覆盖
+// 只能访问本用户请求生成的响应，未启用 ID 映射或映射已过期时返回 404
+func relayStoredResponse(c *gin.Context, method string, action string) {
+	mapping := service.GetResponseIdMapping(c.Param("id"), c.GetInt("id"))
+	if mapping == nil {
+		respondStoredResponseError(c, http.StatusNotFound, fmt.Sprintf("No response found with id '%s'.", c.Param("id")), "response_not_found")
+		return
+	}
+	channel, err := model.CacheGetChannel(mapping.ChannelId)
+	if err != nil {
+		respondStoredResponseError(c, http.StatusNotFound, fmt.Sprintf("No response found with id '%s'.", c.Param("id")), "response_not_found")
+		return
+	}
+	if apiErr := middleware.SetupContextForSelectedChannel(c, channel, ""); apiErr != nil {
+		respondStoredResponseError(c, http.StatusInternalServerError, apiErr.Error(), apiErr.GetErrorCode())
+		return
+	}
+	// 上游 ID 只在创建它的账号下有效，多 Key 渠道使用生成响应时的 Key
+	if keys := channel.GetKeys(); channel.ChannelInfo.IsMultiKey && mapping.KeyIndex < len(keys) {
+		common.SetContextKey(c, constant.ContextKeyChannelKey, keys[mapping.KeyIndex])
+		common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, mapping.KeyIndex)
+	}
+	info := relaycommon.GenRelayInfoResponses(c, &dto.OpenAIResponsesRequest{})
+	info.InitChannelMeta(c)
+	info.RequestURLPath = "/v1/responses"
+	adaptor := relay.GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		respondStoredResponseError(c, http.StatusInternalServerError, fmt.Sprintf("invalid api type: %d, adaptor is nil", info.ApiType), types.ErrorCodeInvalidApiType)
+		return
+	}
+	adaptor.Init(info)
+	req, err := relaychannel.NewStoredResponseRequest(c.Request.Context(), adaptor, c, info, mapping.UpstreamId)
+	if err != nil {
+		respondStoredResponseError(c, http.StatusInternalServerError, err.Error(), types.ErrorCodeDoRequestFailed)
+		return
+	}
+	req.Method = method
+	req.URL.Path += action
+	if c.Request.URL.RawQuery != "" {
+		query := req.URL.Query()
+		for key, values := range c.Request.URL.Query() {
+			query[key] = values
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	client := service.GetHttpClient()
+	if proxy := info.ChannelSetting.Proxy; proxy != "" {
+		client, err = service.NewProxyHttpClient(proxy)
+		if err != nil {
+			respondStoredResponseError(c, http.StatusInternalServerError, err.Error(), types.ErrorCodeDoRequestFailed)
+			return
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		respondStoredResponseError(c, http.StatusBadGateway, err.Error(), types.ErrorCodeDoRequestFailed)
+		return
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		respondStoredResponseError(c, http.StatusBadGateway, err.Error(), types.ErrorCodeReadResponseBodyFailed)
+		return
+	}
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), service.NewResponseIdMapperFor(mapping).Rewrite(body))
+}
+
+func respondStoredResponseError(c *gin.Context, status int, message string, code any) {
+	c.JSON(status, gin.H{
+		"error": types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetActiveStreams 列出所有节点上正在进行的流式请求
+func GetActiveStreams(c *gin.Context) {
+	streams, err := service.ListActiveStreams()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, streams)
+}
+
+// TerminateActiveStream 强制终止指定请求 ID 的流式请求
+func TerminateActiveStream(c *gin.Context) {
+	id := c.Param("id")
+	found, err := service.TerminateActiveStream(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !found {
+		common.ApiErrorMsg(c, fmt.Sprintf("流式请求 %s 不存在或已结束", id))
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
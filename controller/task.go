@@ -17,6 +17,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
@@ -112,6 +113,10 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		}
 		return err
 	}
+	if cooldown := service.GetChannelCooldown(channelId, 0); cooldown > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("渠道 #%d 处于限流冷却中，剩余 %s，跳过本轮查询", channelId, cooldown.Round(time.Second)))
+		return nil
+	}
 	adaptor := relay.GetTaskAdaptor(constant.TaskPlatformSuno)
 	if adaptor == nil {
 		return errors.New("adaptor not found")
@@ -124,6 +129,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		return err
 	}
 	if resp.StatusCode != http.StatusOK {
+		service.SetChannelCooldown(channelId, 0, service.ParseRetryAfter(resp.StatusCode, resp.Header))
 		logger.LogError(ctx, fmt.Sprintf("Get Task status code: %d", resp.StatusCode))
 		return errors.New(fmt.Sprintf("Get Task status code: %d", resp.StatusCode))
 	}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -135,6 +136,61 @@ func GetTokenUsage(c *gin.Context) {
 	})
 }
 
+// GetTokenDailyUsage 令牌持有者查询自身的每日用量、费用、模型构成与转换路径占比
+// 只能查询请求所用令牌自身的数据，返回的是精确汇总值，未做加噪或小样本屏蔽等隐私处理
+func GetTokenDailyUsage(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	summary, err := service.GetTokenUsageSummary(c.GetInt("token_id"), startTimestamp, endTimestamp)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    true,
+		"message": "ok",
+		"data":    summary,
+	})
+}
+
+// ExchangeToken 使用当前令牌签发短期子令牌（限定有效期、模型与额度），供浏览器或边缘函数等环境临时使用，避免暴露长期令牌
+func ExchangeToken(c *gin.Context) {
+	var req service.TokenExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	parent, err := model.GetTokenByKey(c.GetString("token_key"), true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	child, err := service.ExchangeChildToken(parent, req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"object":          "token_exchange",
+			"id":              child.Id,
+			"key":             "sk-" + child.Key,
+			"expires_at":      child.ExpiredTime,
+			"quota":           child.RemainQuota,
+			"model_limits":    child.GetModelLimits(),
+			"parent_token_id": child.ParentTokenId,
+		},
+	})
+}
+
 func AddToken(c *gin.Context) {
 	token := model.Token{}
 	err := c.ShouldBindJSON(&token)
@@ -149,6 +205,13 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := service.ValidateTokenFeatureFlags(token.FeatureFlags); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -171,6 +234,10 @@ func AddToken(c *gin.Context) {
 		ModelLimits:        token.ModelLimits,
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
+		MaxOutputTokens:    token.MaxOutputTokens,
+		MaxToolCalls:       token.MaxToolCalls,
+		FeatureFlags:       token.FeatureFlags,
+		RequestTimeout:     token.RequestTimeout,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -215,6 +282,13 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := service.ValidateTokenFeatureFlags(token.FeatureFlags); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
@@ -247,6 +321,10 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimitsEnabled = token.ModelLimitsEnabled
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
+		cleanToken.MaxOutputTokens = token.MaxOutputTokens
+		cleanToken.MaxToolCalls = token.MaxToolCalls
+		cleanToken.FeatureFlags = token.FeatureFlags
+		cleanToken.RequestTimeout = token.RequestTimeout
 		cleanToken.Group = token.Group
 	}
 	err = cleanToken.Update()
@@ -287,3 +365,30 @@ func DeleteTokenBatch(c *gin.Context) {
 		"data":    count,
 	})
 }
+
+// GetTokenAbuseEvents 获取令牌异常流量事件及当前被临时封禁的令牌
+func GetTokenAbuseEvents(c *gin.Context) {
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"events":  service.GetAbuseEvents(tokenId),
+			"blocked": service.GetAbuseBlockedTokens(),
+		},
+	})
+}
+
+// UnblockAbuseToken 解除令牌因异常流量导致的临时封禁
+func UnblockAbuseToken(c *gin.Context) {
+	tokenId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.UnblockAbuseToken(tokenId),
+	})
+}
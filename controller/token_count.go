@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxTokenCountItems 单次批量计数允许的最大请求数
+	maxTokenCountItems = 20
+	// maxTokenCountItemBytes 单个请求体允许的最大字节数
+	maxTokenCountItemBytes = 1 << 20
+	// maxTokenCountBodyBytes 批量计数请求体允许的最大字节数
+	maxTokenCountBodyBytes = 4 << 20
+)
+
+type TokenCountItem struct {
+	// Format 请求格式：chat、claude 或 responses
+	Format  string          `json:"format"`
+	Request json.RawMessage `json:"request"`
+}
+
+type TokenCountResult struct {
+	Index        int                         `json:"index"`
+	Model        string                      `json:"model"`
+	PromptTokens int                         `json:"prompt_tokens"`
+	Breakdown    *types.PromptTokenBreakdown `json:"breakdown,omitempty"`
+	// ToolTokens 函数工具在各上游渲染方式下的 token 数，仅 Claude 格式且包含函数工具时返回
+	ToolTokens map[service.ToolSchemaRendering]int `json:"tool_tokens,omitempty"`
+	Error      string                              `json:"error,omitempty"`
+}
+
+// CountTokens 使用令牌鉴权批量估算请求的提示词 token 数，远程图片按基础 token 估算
+func CountTokens(c *gin.Context) {
+	if c.Request.ContentLength > maxTokenCountBodyBytes {
+		common.ApiErrorMsg(c, fmt.Sprintf("请求体不能超过 %d 字节", maxTokenCountBodyBytes))
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTokenCountBodyBytes)
+	var items []TokenCountItem
+	if err := common.UnmarshalBodyReusable(c, &items); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	if len(items) == 0 {
+		common.ApiErrorMsg(c, "请求列表不能为空")
+		return
+	}
+	if len(items) > maxTokenCountItems {
+		common.ApiErrorMsg(c, fmt.Sprintf("单次最多计算 %d 个请求", maxTokenCountItems))
+		return
+	}
+
+	results := make([]TokenCountResult, 0, len(items))
+	for i, item := range items {
+		result := TokenCountResult{Index: i}
+		if err := countTokenItem(c, item, &result); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	common.ApiSuccess(c, results)
+}
+
+func countTokenItem(c *gin.Context, item TokenCountItem, result *TokenCountResult) error {
+	if len(item.Request) > maxTokenCountItemBytes {
+		return fmt.Errorf("request exceeds %d bytes", maxTokenCountItemBytes)
+	}
+	var request dto.Request
+	var modelName string
+	var relayFormat types.RelayFormat
+	switch item.Format {
+	case "", "chat":
+		req := &dto.GeneralOpenAIRequest{}
+		if err := common.Unmarshal(item.Request, req); err != nil {
+			return err
+		}
+		request, modelName, relayFormat = req, req.Model, types.RelayFormatOpenAI
+	case "claude":
+		req := &dto.ClaudeRequest{}
+		if err := common.Unmarshal(item.Request, req); err != nil {
+			return err
+		}
+		request, modelName, relayFormat = req, req.Model, types.RelayFormatClaude
+	case "responses":
+		req := &dto.OpenAIResponsesRequest{}
+		if err := common.Unmarshal(item.Request, req); err != nil {
+			return err
+		}
+		request, modelName, relayFormat = req, req.Model, types.RelayFormatOpenAIResponses
+	default:
+		return fmt.Errorf("unsupported format: %s", item.Format)
+	}
+
+	// 只在本地估算，不下载请求中的远程媒体，避免单次调用触发大量外部请求
+	info := &relaycommon.RelayInfo{
+		RelayFormat:          relayFormat,
+		IsStream:             request.IsStream(c),
+		OriginModelName:      modelName,
+		SkipRemoteMediaFetch: true,
+	}
+	result.Model = modelName
+	meta := request.GetTokenCountMeta()
+	promptTokens, err := service.EstimateRequestToken(c, meta, info)
+	if err != nil {
+		return err
+	}
+	result.PromptTokens = promptTokens
+	result.Breakdown = info.PromptTokenBreakdown
+	if relayFormat == types.RelayFormatClaude && len(meta.ToolSchemas) > 0 {
+		result.ToolTokens = map[service.ToolSchemaRendering]int{
+			service.ToolSchemaRenderingClaude:    service.EstimateToolSchemaTokens(meta, service.ToolSchemaRenderingClaude, modelName),
+			service.ToolSchemaRenderingResponses: service.EstimateToolSchemaTokens(meta, service.ToolSchemaRenderingResponses, modelName),
+		}
+	}
+	return nil
+}
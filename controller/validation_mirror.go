@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+// validationMirrorIdentityKeys 镜像请求需要清除的来源用户、令牌与实验信息
+var validationMirrorIdentityKeys = []constant.ContextKey{
+	constant.ContextKeyUserId,
+	constant.ContextKeyUserName,
+	constant.ContextKeyUserEmail,
+	constant.ContextKeyUserQuota,
+	constant.ContextKeyUserSetting,
+	constant.ContextKeyUserRole,
+	constant.ContextKeyTokenId,
+	constant.ContextKeyTokenKey,
+	constant.ContextKeyTokenGroup,
+	constant.ContextKeyTokenModelLimitEnabled,
+	constant.ContextKeyTokenModelLimit,
+	constant.ContextKeyTokenMaxOutputTokens,
+	constant.ContextKeyTokenMaxToolCalls,
+	constant.ContextKeyTokenFeatureFlags,
+	constant.ContextKeyTokenRequestTimeout,
+	constant.ContextKeyExperiment,
+	constant.ContextKeyExperimentArm,
+	"token_name",
+}
+
+// mirrorValidationTraffic 来源模型请求成功后，按配置在后台复制一份请求发往验证期模型
+// 镜像请求以系统身份发起，响应被丢弃，不向用户计费，也不记录用户可见的日志与用量，成功的转换请求计入验证期模型的转正次数
+// 仅支持请求体中携带模型名称的 Chat Completions、Claude Messages 与 Responses 请求
+// 参数:
+//   - c: 来源请求的 Gin 上下文
+//   - relayFormat: 来源请求的格式
+//   - modelName: 来源请求的模型名称
+func mirrorValidationTraffic(c *gin.Context, relayFormat types.RelayFormat, modelName string) {
+	switch relayFormat {
+	case types.RelayFormatOpenAI, types.RelayFormatClaude, types.RelayFormatOpenAIResponses:
+	default:
+		return
+	}
+	if common.GetContextKeyBool(c, constant.ContextKeyValidationMirror) {
+		return
+	}
+	target, ok := service.AcquireValidationMirror(modelName)
+	if !ok {
+		return
+	}
+	body, err := common.GetRequestBody(c)
+	if err == nil {
+		body, err = sjson.SetBytes(body, "model", target)
+	}
+	if err != nil {
+		service.ReleaseValidationMirror()
+		logger.LogError(c, fmt.Sprintf("failed to build validation mirror request for model %s: %s", target, err.Error()))
+		return
+	}
+
+	// gin 会在请求结束后复用上下文，这里复制请求与上下文数据供后台镜像使用
+	keys := make(map[string]any, len(c.Keys))
+	for k, v := range c.Keys {
+		keys[k] = v
+	}
+	for _, key := range validationMirrorIdentityKeys {
+		delete(keys, string(key))
+	}
+	request := c.Request.Clone(context.Background())
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+
+	gopool.Go(func() {
+		defer service.ReleaseValidationMirror()
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		ctx.Request = request
+		for k, v := range keys {
+			ctx.Set(k, v)
+		}
+		ctx.Set(common.KeyRequestBody, body)
+		ctx.Set("use_channel", []string{})
+		common.SetContextKey(ctx, constant.ContextKeyModelGroup, "")
+		common.SetContextKey(ctx, constant.ContextKeyTokenUnlimited, true)
+		common.SetContextKey(ctx, constant.ContextKeyValidationMirror, true)
+
+		channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(ctx, group, target, 0)
+		if err != nil || channel == nil {
+			logger.LogWarn(ctx, fmt.Sprintf("no available channel for validation mirror model %s in group %s", target, selectGroup))
+			return
+		}
+		if apiErr := middleware.SetupContextForSelectedChannel(ctx, channel, target); apiErr != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("failed to setup validation mirror channel #%d: %s", channel.Id, apiErr.Error()))
+			return
+		}
+		Relay(ctx, relayFormat)
+	})
+}
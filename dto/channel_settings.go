@@ -1,5 +1,7 @@
 package dto
 
+import "strings"
+
 type ChannelSettings struct {
 	ForceFormat            bool   `json:"force_format,omitempty"`
 	ThinkingToContent      bool   `json:"thinking_to_content,omitempty"`
@@ -31,6 +33,177 @@ type ChannelOtherSettings struct {
 	DisableStore          bool          `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
 	AllowSafetyIdentifier bool          `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
 	AwsKeyType            AwsKeyType    `json:"aws_key_type,omitempty"`
+	BalanceAdminKey       string        `json:"balance_admin_key,omitempty"`       // OpenAI / Anthropic 组织 Admin Key，用于查询组织费用
+	BalanceMonthlyBudget  float64       `json:"balance_monthly_budget,omitempty"`  // 使用 Admin Key 查询时的月度预算（美元），余额为预算减去本月费用
+	BalanceAlertThreshold float64       `json:"balance_alert_threshold,omitempty"` // 余额低于该值时通知管理员，0 表示不告警
+	// ResponsesTerminalEvent Responses 流式透传时终止事件的兼容处理：为空保持上游原样，
+	// completed/done 统一重命名为 response.completed/response.done，both 同时发送两者
+	ResponsesTerminalEvent string `json:"responses_terminal_event,omitempty"`
+	// MaintenanceWindows 计划内的维护窗口，窗口内及开始前的排空期内渠道不参与选择
+	MaintenanceWindows []ChannelMaintenanceWindow `json:"maintenance_windows,omitempty"`
+	// SupportsResponsesStop 上游 Responses 接口是否支持 text.stop，开启后转换请求中的 stop / stop_sequences 会透传，否则丢弃
+	SupportsResponsesStop bool `json:"supports_responses_stop,omitempty"`
+	// AllowedEndpoints 渠道允许处理的接口，取值见 ChannelEndpoint* 常量，为空时不限制
+	AllowedEndpoints []string `json:"allowed_endpoints,omitempty"`
+	// SLO 渠道的服务目标，开启 SLO 跟踪后违反目标的渠道在选择时被降级
+	SLO *ChannelSLO `json:"slo,omitempty"`
+	// OptimizeInlineImages 是否在转换请求发送前压缩内联 base64 图片，为空时跟随全局 image_optimize 配置
+	OptimizeInlineImages *bool `json:"optimize_inline_images,omitempty"`
+	// ClaudeSmartRouting Claude 渠道的智能路由配置，为空时不路由
+	ClaudeSmartRouting *ClaudeSmartRoutingConfig `json:"claude_smart_routing,omitempty"`
+}
+
+// ClaudeSmartRoutingConfig Claude 渠道智能路由配置，命中的模型在该渠道上改为请求 Responses 接口
+type ClaudeSmartRoutingConfig struct {
+	Enabled bool `json:"enabled"`
+	// ResponsesModels 需要路由到 Responses 接口的模型，支持精确匹配与 * 通配符，如 claude-3-5-* 或 *-haiku-*
+	ResponsesModels []string `json:"responses_models,omitempty"`
+	// FallbackOnError 请求转换失败或 Responses 接口拒绝转换后的请求（400、404、422）时是否回退到原生 Claude 接口，回退失败或关闭时返回原始错误
+	FallbackOnError bool `json:"fallback_on_error,omitempty"`
+}
+
+// IsResponsesModel 判断模型是否需要路由到 Responses 接口，配置为空或未开启时返回 false
+func (c *ClaudeSmartRoutingConfig) IsResponsesModel(modelName string) bool {
+	if c == nil || !c.Enabled || modelName == "" {
+		return false
+	}
+	for _, pattern := range c.ResponsesModels {
+		if matchModelPattern(strings.TrimSpace(pattern), modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldFallbackOnError 智能路由失败后是否回退到原生 Claude 接口
+func (c *ClaudeSmartRoutingConfig) ShouldFallbackOnError() bool {
+	return c != nil && c.FallbackOnError
+}
+
+// matchModelPattern 按通配符匹配模型名称，* 匹配任意长度的字符（包括 /）
+func matchModelPattern(pattern string, name string) bool {
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "*") {
+		return pattern == name
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	rest := name[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		index := strings.Index(rest, part)
+		if index < 0 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[last])
+}
+
+// ChannelSLO 渠道服务目标，值为 0 的项不检查
+type ChannelSLO struct {
+	FirstTokenP95Ms  int64   `json:"first_token_p95_ms,omitempty"` // 首字延迟 P95 上限（毫秒）
+	ErrorRatePercent float64 `json:"error_rate_percent,omitempty"` // 错误率上限（百分比）
+}
+
+// IsSet 判断是否配置了任一服务目标
+func (s *ChannelSLO) IsSet() bool {
+	return s != nil && (s.FirstTokenP95Ms > 0 || s.ErrorRatePercent > 0)
+}
+
+// ChannelMaintenanceWindow 渠道维护窗口
+type ChannelMaintenanceWindow struct {
+	StartTime int64 `json:"start_time"` // 开始时间戳（秒）
+	EndTime   int64 `json:"end_time"`   // 结束时间戳（秒）
+	// DrainSeconds 提前排空的秒数，排空期内不再分配新请求，进行中的请求与流可以正常完成
+	DrainSeconds int64  `json:"drain_seconds,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// IsDraining 判断维护窗口在指定时间是否处于开始前的排空期
+func (w ChannelMaintenanceWindow) IsDraining(now int64) bool {
+	return now < w.StartTime && now >= w.StartTime-w.DrainSeconds
+}
+
+// IsActive 判断指定时间渠道是否因该维护窗口而不参与选择，包括排空期与维护期
+func (w ChannelMaintenanceWindow) IsActive(now int64) bool {
+	return now >= w.StartTime-w.DrainSeconds && now < w.EndTime
+}
+
+const (
+	ResponsesTerminalEventCompleted = "completed"
+	ResponsesTerminalEventDone      = "done"
+	ResponsesTerminalEventBoth      = "both"
+)
+
+// GetActiveMaintenanceWindow 获取指定时间生效的维护窗口，没有时返回 nil
+func (s *ChannelOtherSettings) GetActiveMaintenanceWindow(now int64) *ChannelMaintenanceWindow {
+	if s == nil {
+		return nil
+	}
+	for i := range s.MaintenanceWindows {
+		if s.MaintenanceWindows[i].IsActive(now) {
+			return &s.MaintenanceWindows[i]
+		}
+	}
+	return nil
+}
+
+// 渠道可限制的接口
+const (
+	ChannelEndpointChatCompletions = "chat_completions"
+	ChannelEndpointCompletions     = "completions"
+	ChannelEndpointResponses       = "responses"
+	ChannelEndpointClaudeMessages  = "claude_messages"
+	ChannelEndpointGemini          = "gemini"
+	ChannelEndpointEmbeddings      = "embeddings"
+	ChannelEndpointModerations     = "moderations"
+	ChannelEndpointImages          = "images"
+	ChannelEndpointAudio           = "audio"
+	ChannelEndpointRerank          = "rerank"
+	ChannelEndpointRealtime        = "realtime"
+)
+
+// ChannelEndpoints 所有可限制的接口
+var ChannelEndpoints = []string{
+	ChannelEndpointChatCompletions,
+	ChannelEndpointCompletions,
+	ChannelEndpointResponses,
+	ChannelEndpointClaudeMessages,
+	ChannelEndpointGemini,
+	ChannelEndpointEmbeddings,
+	ChannelEndpointModerations,
+	ChannelEndpointImages,
+	ChannelEndpointAudio,
+	ChannelEndpointRerank,
+	ChannelEndpointRealtime,
+}
+
+// IsValidChannelEndpoint 判断是否为可限制的接口
+func IsValidChannelEndpoint(endpoint string) bool {
+	for _, e := range ChannelEndpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEndpointAllowed 判断渠道是否允许处理指定接口，未配置或无法识别的接口不限制
+func (s *ChannelOtherSettings) IsEndpointAllowed(endpoint string) bool {
+	if s == nil || len(s.AllowedEndpoints) == 0 || endpoint == "" {
+		return true
+	}
+	for _, allowed := range s.AllowedEndpoints {
+		if allowed == endpoint {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
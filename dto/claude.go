@@ -22,6 +22,7 @@ type ClaudeMediaMessage struct {
 	Source       *ClaudeMessageSource `json:"source,omitempty"`
 	Usage        *ClaudeUsage         `json:"usage,omitempty"`
 	StopReason   *string              `json:"stop_reason,omitempty"`
+	StopSequence *string              `json:"stop_sequence,omitempty"`
 	PartialJson  *string              `json:"partial_json,omitempty"`
 	Role         string               `json:"role,omitempty"`
 	Thinking     *string              `json:"thinking,omitempty"`
@@ -206,7 +207,10 @@ type ClaudeRequest struct {
 	ToolChoice        any             `json:"tool_choice,omitempty"`
 	Thinking          *Thinking       `json:"thinking,omitempty"`
 	McpServers        json.RawMessage `json:"mcp_servers,omitempty"`
+	Container         json.RawMessage `json:"container,omitempty"` // 代码执行工具的容器 ID，原生渠道原样透传
 	Metadata          json.RawMessage `json:"metadata,omitempty"`
+	// 较新的 Anthropic SDK 会在请求体中携带 beta 特性列表，与 anthropic-beta 请求头等价，原生渠道原样透传
+	Betas []string `json:"betas,omitempty"`
 	// 服务层级字段，用于指定 API 服务等级。允许透传可能导致实际计费高于预期，默认应过滤
 	ServiceTier string `json:"service_tier,omitempty"`
 }
@@ -218,6 +222,8 @@ func (c *ClaudeRequest) GetTokenCountMeta() *types.TokenCountMeta {
 	}
 
 	var texts = make([]string, 0)
+	var systemTexts = make([]string, 0)
+	var toolsTexts = make([]string, 0)
 	var fileMeta = make([]*types.FileMeta, 0)
 
 	// system
@@ -225,14 +231,14 @@ func (c *ClaudeRequest) GetTokenCountMeta() *types.TokenCountMeta {
 		if c.IsStringSystem() {
 			sys := c.GetStringSystem()
 			if sys != "" {
-				texts = append(texts, sys)
+				systemTexts = append(systemTexts, sys)
 			}
 		} else {
 			systemMedia := c.ParseSystem()
 			for _, media := range systemMedia {
 				switch media.Type {
 				case "text":
-					texts = append(texts, media.GetText())
+					systemTexts = append(systemTexts, media.GetText())
 				case "image":
 					if media.Source != nil {
 						data := media.Source.Url
@@ -248,6 +254,8 @@ func (c *ClaudeRequest) GetTokenCountMeta() *types.TokenCountMeta {
 		}
 	}
 
+	texts = append(texts, systemTexts...)
+
 	// messages
 	for _, message := range c.Messages {
 		tokenCountMeta.MessagesCount++
@@ -295,37 +303,40 @@ func (c *ClaudeRequest) GetTokenCountMeta() *types.TokenCountMeta {
 	// tools
 	if c.Tools != nil {
 		tools := c.GetTools()
-		normalTools, webSearchTools := ProcessTools(tools)
-		if normalTools != nil {
-			for _, t := range normalTools {
-				tokenCountMeta.ToolsCount++
-				if t.Name != "" {
-					texts = append(texts, t.Name)
-				}
-				if t.Description != "" {
-					texts = append(texts, t.Description)
-				}
-				if t.InputSchema != nil {
-					b, _ := common.Marshal(t.InputSchema)
-					texts = append(texts, string(b))
-				}
+		tokenCountMeta.ToolSchemas = c.GetToolSchemas()
+		tokenCountMeta.ToolChoice = c.GetToolChoiceType()
+		for _, t := range tokenCountMeta.ToolSchemas {
+			tokenCountMeta.ToolsCount++
+			if t.Name != "" {
+				toolsTexts = append(toolsTexts, t.Name)
+			}
+			if t.Description != "" {
+				toolsTexts = append(toolsTexts, t.Description)
+			}
+			if t.Parameters != nil {
+				b, _ := common.Marshal(t.Parameters)
+				toolsTexts = append(toolsTexts, string(b))
 			}
 		}
+		_, webSearchTools := ProcessTools(tools)
 		if webSearchTools != nil {
 			for _, t := range webSearchTools {
 				tokenCountMeta.ToolsCount++
 				if t.Name != "" {
-					texts = append(texts, t.Name)
+					toolsTexts = append(toolsTexts, t.Name)
 				}
 				if t.UserLocation != nil {
 					b, _ := common.Marshal(t.UserLocation)
-					texts = append(texts, string(b))
+					toolsTexts = append(toolsTexts, string(b))
 				}
 			}
 		}
+		texts = append(texts, toolsTexts...)
 	}
 
 	tokenCountMeta.CombineText = strings.Join(texts, "\n")
+	tokenCountMeta.SystemText = strings.Join(systemTexts, "\n")
+	tokenCountMeta.ToolsText = strings.Join(toolsTexts, "\n")
 	tokenCountMeta.Files = fileMeta
 	return &tokenCountMeta
 }
@@ -405,6 +416,49 @@ func ProcessTools(tools []any) ([]*Tool, []*ClaudeWebSearchTool) {
 	return normalTools, webSearchTools
 }
 
+// GetToolSchemas 提取请求中的自定义函数工具定义，兼容反序列化得到的 map 形式
+// 服务端工具（如 web_search、code_execution）没有 input_schema，不在返回结果中
+func (c *ClaudeRequest) GetToolSchemas() []types.ToolSchemaMeta {
+	var schemas []types.ToolSchemaMeta
+	for _, tool := range c.GetTools() {
+		switch t := tool.(type) {
+		case *Tool:
+			schemas = append(schemas, types.ToolSchemaMeta{Name: t.Name, Description: t.Description, Parameters: t.InputSchema})
+		case Tool:
+			schemas = append(schemas, types.ToolSchemaMeta{Name: t.Name, Description: t.Description, Parameters: t.InputSchema})
+		case map[string]any:
+			toolType, _ := t["type"].(string)
+			if toolType != "" && toolType != "custom" {
+				continue
+			}
+			name, _ := t["name"].(string)
+			description, _ := t["description"].(string)
+			parameters, _ := t["input_schema"].(map[string]any)
+			schemas = append(schemas, types.ToolSchemaMeta{Name: name, Description: description, Parameters: parameters})
+		}
+	}
+	return schemas
+}
+
+// GetToolChoiceType 获取 tool_choice 的类型，未指定时为 auto
+func (c *ClaudeRequest) GetToolChoiceType() string {
+	switch choice := c.ToolChoice.(type) {
+	case *ClaudeToolChoice:
+		if choice != nil && choice.Type != "" {
+			return choice.Type
+		}
+	case ClaudeToolChoice:
+		if choice.Type != "" {
+			return choice.Type
+		}
+	case map[string]any:
+		if choiceType, ok := choice["type"].(string); ok && choiceType != "" {
+			return choiceType
+		}
+	}
+	return "auto"
+}
+
 type Thinking struct {
 	Type         string `json:"type"`
 	BudgetTokens *int   `json:"budget_tokens,omitempty"`
@@ -451,6 +505,7 @@ type ClaudeResponse struct {
 	Content      []ClaudeMediaMessage `json:"content,omitempty"`
 	Completion   string               `json:"completion,omitempty"`
 	StopReason   string               `json:"stop_reason,omitempty"`
+	StopSequence *string              `json:"stop_sequence,omitempty"`
 	Model        string               `json:"model,omitempty"`
 	Error        any                  `json:"error,omitempty"`
 	Usage        *ClaudeUsage         `json:"usage,omitempty"`
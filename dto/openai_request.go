@@ -50,6 +50,7 @@ type GeneralOpenAIRequest struct {
 	EncodingFormat      json.RawMessage   `json:"encoding_format,omitempty"`
 	Seed                float64           `json:"seed,omitempty"`
 	ParallelToolCalls   *bool             `json:"parallel_tool_calls,omitempty"`
+	MaxToolCalls        uint              `json:"max_tool_calls,omitempty"` // 工具调用次数上限，转换为 Responses 请求时生效
 	Tools               []ToolCallRequest `json:"tools,omitempty"`
 	ToolChoice          any               `json:"tool_choice,omitempty"`
 	User                string            `json:"user,omitempty"`
@@ -128,9 +129,13 @@ func (r *GeneralOpenAIRequest) GetTokenCountMeta() *types.TokenCountMeta {
 		tokenCountMeta.MaxTokens = int(r.MaxTokens)
 	}
 
+	var systemTexts = make([]string, 0)
+	var toolsTexts = make([]string, 0)
+
 	for _, message := range r.Messages {
 		tokenCountMeta.MessagesCount++
 		texts = append(texts, message.Role)
+		isSystem := message.Role == "system" || message.Role == "developer"
 		if message.Content != nil {
 			if message.Name != nil {
 				tokenCountMeta.NameCount++
@@ -179,6 +184,9 @@ func (r *GeneralOpenAIRequest) GetTokenCountMeta() *types.TokenCountMeta {
 					}
 				} else {
 					texts = append(texts, m.Text)
+					if isSystem {
+						systemTexts = append(systemTexts, m.Text)
+					}
 				}
 			}
 		}
@@ -188,19 +196,22 @@ func (r *GeneralOpenAIRequest) GetTokenCountMeta() *types.TokenCountMeta {
 		openaiTools := r.Tools
 		for _, tool := range openaiTools {
 			tokenCountMeta.ToolsCount++
-			texts = append(texts, tool.Function.Name)
+			toolsTexts = append(toolsTexts, tool.Function.Name)
 			if tool.Function.Description != "" {
-				texts = append(texts, tool.Function.Description)
+				toolsTexts = append(toolsTexts, tool.Function.Description)
 			}
 			if tool.Function.Parameters != nil {
-				texts = append(texts, fmt.Sprintf("%v", tool.Function.Parameters))
+				toolsTexts = append(toolsTexts, fmt.Sprintf("%v", tool.Function.Parameters))
 			}
 		}
+		texts = append(texts, toolsTexts...)
 		//toolTokens := CountTokenInput(countStr, request.Model)
 		//tkm += 8
 		//tkm += toolTokens
 	}
 	tokenCountMeta.CombineText = strings.Join(texts, "\n")
+	tokenCountMeta.SystemText = strings.Join(systemTexts, "\n")
+	tokenCountMeta.ToolsText = strings.Join(toolsTexts, "\n")
 	tokenCountMeta.Files = fileMeta
 	return &tokenCountMeta
 }
@@ -288,7 +299,9 @@ type Message struct {
 	Reasoning        string          `json:"reasoning,omitempty"`
 	ToolCalls        json.RawMessage `json:"tool_calls,omitempty"`
 	ToolCallId       string          `json:"tool_call_id,omitempty"`
-	parsedContent    []MediaContent
+	// Refusal 模型拒绝按结构化输出回答时的说明
+	Refusal       *string `json:"refusal,omitempty"`
+	parsedContent []MediaContent
 	//parsedStringContent *string
 }
 
@@ -867,13 +880,35 @@ func (r *OpenAIResponsesRequest) GetTokenCountMeta() *types.TokenCountMeta {
 		texts = append(texts, string(r.Tools))
 	}
 
+	var toolsText string
+	if len(r.Tools) > 0 {
+		toolsText = string(r.Tools)
+	}
+
 	return &types.TokenCountMeta{
 		CombineText: strings.Join(texts, "\n"),
+		SystemText:  r.GetInstructionsText(),
+		ToolsText:   toolsText,
 		Files:       fileMeta,
 		MaxTokens:   int(r.MaxOutputTokens),
 	}
 }
 
+// GetInstructionsText 获取 instructions 中的文本内容，instructions 可以是字符串或与 input 相同结构的消息数组
+func (r *OpenAIResponsesRequest) GetInstructionsText() string {
+	if len(r.Instructions) == 0 {
+		return ""
+	}
+	instructions := &OpenAIResponsesRequest{Input: r.Instructions}
+	var texts []string
+	for _, input := range instructions.ParseInput() {
+		if input.Type == "input_text" && input.Text != "" {
+			texts = append(texts, input.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
 func (r *OpenAIResponsesRequest) IsStream(c *gin.Context) bool {
 	return r.Stream
 }
@@ -901,6 +936,14 @@ type Input struct {
 	Type    string          `json:"type,omitempty"`
 	Role    string          `json:"role,omitempty"`
 	Content json.RawMessage `json:"content,omitempty"`
+	// function_call 与 function_call_output 项的字段
+	CallId    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"`
+	// reasoning 项的字段
+	Summary          json.RawMessage `json:"summary,omitempty"`
+	EncryptedContent string          `json:"encrypted_content,omitempty"`
 }
 
 type MediaInput struct {
@@ -9,6 +9,9 @@ import (
 
 const (
 	ResponsesOutputTypeImageGenerationCall = "image_generation_call"
+	ResponsesOutputTypeMessage             = "message"
+	ResponsesOutputTypeReasoning           = "reasoning"
+	ResponsesOutputTypeFunctionCall        = "function_call"
 )
 
 type SimpleResponse struct {
@@ -90,6 +93,7 @@ type ChatCompletionsStreamResponseChoiceDelta struct {
 	Reasoning        *string            `json:"reasoning,omitempty"`
 	Role             string             `json:"role,omitempty"`
 	ToolCalls        []ToolCallResponse `json:"tool_calls,omitempty"`
+	Refusal          *string            `json:"refusal,omitempty"`
 }
 
 func (c *ChatCompletionsStreamResponseChoiceDelta) SetContentString(s string) {
@@ -331,6 +335,7 @@ func (o *OpenAIResponsesResponse) GetSize() string {
 
 type IncompleteDetails struct {
 	Reasoning string `json:"reasoning"`
+	Reason    string `json:"reason,omitempty"`
 }
 
 type ResponsesOutput struct {
@@ -341,21 +346,37 @@ type ResponsesOutput struct {
 	Content []ResponsesOutputContent `json:"content"`
 	Quality string                   `json:"quality"`
 	Size    string                   `json:"size"`
+	// function_call
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// reasoning
+	Summary []ResponsesOutputContent `json:"summary,omitempty"`
+	// EncryptedContent 推理内容的不透明凭据，Claude 渠道为 thinking 块的 signature，客户端原样回传以延续推理
+	EncryptedContent string `json:"encrypted_content,omitempty"`
+	// image_generation_call
+	Result       string `json:"result,omitempty"`
+	OutputFormat string `json:"output_format,omitempty"`
 }
 
 type ResponsesOutputContent struct {
 	Type        string        `json:"type"`
 	Text        string        `json:"text"`
 	Annotations []interface{} `json:"annotations"`
+	Refusal     string        `json:"refusal,omitempty"`
 }
 
 const (
 	BuildInToolWebSearchPreview = "web_search_preview"
+	BuildInToolWebSearch        = "web_search"
 	BuildInToolFileSearch       = "file_search"
+	BuildInToolCodeInterpreter  = "code_interpreter"
 )
 
 const (
-	BuildInCallWebSearchCall = "web_search_call"
+	BuildInCallWebSearchCall       = "web_search_call"
+	BuildInCallFileSearchCall      = "file_search_call"
+	BuildInCallCodeInterpreterCall = "code_interpreter_call"
 )
 
 const (
@@ -365,10 +386,23 @@ const (
 
 // ResponsesStreamResponse 用于处理 /v1/responses 流式响应
 type ResponsesStreamResponse struct {
-	Type     string                   `json:"type"`
-	Response *OpenAIResponsesResponse `json:"response,omitempty"`
-	Delta    string                   `json:"delta,omitempty"`
-	Item     *ResponsesOutput         `json:"item,omitempty"`
+	Type         string                   `json:"type"`
+	Response     *OpenAIResponsesResponse `json:"response,omitempty"`
+	Delta        string                   `json:"delta,omitempty"`
+	Item         *ResponsesOutput         `json:"item,omitempty"`
+	ItemId       string                   `json:"item_id,omitempty"`
+	OutputIndex  *int                     `json:"output_index,omitempty"`
+	ContentIndex *int                     `json:"content_index,omitempty"`
+	// 推理摘要事件的摘要序号
+	SummaryIndex *int `json:"summary_index,omitempty"`
+	// content_part 与 reasoning_summary_part 事件的内容
+	Part *ResponsesOutputContent `json:"part,omitempty"`
+	// output_text.done 与 reasoning_summary_text.done 事件的完整文本
+	Text string `json:"text,omitempty"`
+	// function_call_arguments.done 事件的完整参数
+	Arguments string `json:"arguments,omitempty"`
+	// 事件在本次响应中的序号，单调递增
+	SequenceNumber *int `json:"sequence_number,omitempty"`
 }
 
 // GetOpenAIError 从动态错误类型中提取OpenAIError结构
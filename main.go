@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -89,6 +93,11 @@ func main() {
 	// 热更新配置
 	go model.SyncOptions(common.SyncFrequency)
 
+	// 检查渠道配置，发现的问题记录到日志并可通过就绪报告查看
+	gopool.Go(func() {
+		_, _ = service.RefreshChannelReadinessReport()
+	})
+
 	// 数据看板
 	go model.UpdateQuotaData()
 
@@ -102,6 +111,11 @@ func main() {
 
 	go controller.AutomaticallyTestChannels()
 
+	if common.IsMasterNode {
+		// 清理过期的短期子令牌并退还未使用的预留额度
+		go model.CleanupExpiredChildTokensPeriodically(5 * time.Minute)
+	}
+
 	if common.IsMasterNode && constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
@@ -115,6 +129,11 @@ func main() {
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
 		model.InitBatchUpdater()
 	}
+	if os.Getenv("ASYNC_WRITE_ENABLED") == "true" {
+		common.AsyncWriteEnabled = true
+		common.SysLog(fmt.Sprintf("async write enabled with %d workers, queue size %d", common.AsyncWriteWorkers, common.AsyncWriteQueueSize))
+		model.InitAsyncWriter()
+	}
 
 	if os.Getenv("ENABLE_PPROF") == "true" {
 		gopool.Go(func() {
@@ -163,10 +182,40 @@ func main() {
 	// Log startup success message
 	common.LogStartupSuccess(startTime, port)
 
-	err = server.Run(":" + port)
-	if err != nil {
+	httpServer := &http.Server{Addr: ":" + port, Handler: server}
+	shutdownDone := make(chan struct{})
+	if common.AsyncWriteEnabled {
+		go gracefulShutdown(httpServer, shutdownDone)
+	} else {
+		close(shutdownDone)
+	}
+	err = httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		common.FatalLog("failed to start HTTP server: " + err.Error())
 	}
+	<-shutdownDone
+}
+
+// gracefulShutdown 收到退出信号时先停止接收新请求并等待进行中的请求完成结算，
+// 再写完写入池中排队的计费与日志并关闭数据库，避免丢失已完成请求的计费
+// 等待进行中请求的最长时间由 SHUTDOWN_TIMEOUT（秒）配置，默认 30 秒
+func gracefulShutdown(httpServer *http.Server, done chan struct{}) {
+	defer close(done)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	common.SysLog("shutting down, waiting for in-flight requests")
+	timeout := time.Duration(common.GetEnvOrDefault("SHUTDOWN_TIMEOUT", 30)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		common.SysError("failed to wait for in-flight requests: " + err.Error())
+	}
+	common.SysLog("flushing async write queue")
+	model.ShutdownAsyncWriter()
+	if err := model.CloseDB(); err != nil {
+		common.SysError("failed to close database: " + err.Error())
+	}
 }
 
 func InjectUmamiAnalytics() {
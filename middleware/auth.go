@@ -308,6 +308,10 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 		c.Set("token_model_limit_enabled", false)
 	}
 	c.Set("token_group", token.Group)
+	common.SetContextKey(c, constant.ContextKeyTokenMaxOutputTokens, token.MaxOutputTokens)
+	common.SetContextKey(c, constant.ContextKeyTokenMaxToolCalls, token.MaxToolCalls)
+	common.SetContextKey(c, constant.ContextKeyTokenFeatureFlags, token.GetFeatureFlags())
+	common.SetContextKey(c, constant.ContextKeyTokenRequestTimeout, token.RequestTimeout)
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -50,6 +51,10 @@ func Distribute() func(c *gin.Context) {
 				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
 				return
 			}
+			if boundGroup, bound := service.GetBoundChannelGroup(c); bound && !slices.Contains(channel.GetGroups(), boundGroup) {
+				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该渠道不属于绑定的分组 %s", boundGroup))
+				return
+			}
 		} else {
 			// Select a channel for the user
 			// check token model mapping
@@ -66,10 +71,20 @@ func Distribute() func(c *gin.Context) {
 				if !ok {
 					tokenModelLimit = map[string]bool{}
 				}
-				matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model) // match gpts & thinking-*
-				if _, ok := tokenModelLimit[matchName]; !ok {
-					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
-					return
+				if policy, isModelGroup := model_setting.GetModelGroupPolicy(modelRequest.Model); isModelGroup {
+					// 模型组按解析得到的具体模型校验，至少有一个模型可访问时由解析过程跳过不可访问的模型
+					if !slices.ContainsFunc(policy.Models, func(modelName string) bool {
+						return tokenModelLimit[ratio_setting.FormatMatchingModelName(modelName)]
+					}) {
+						abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
+						return
+					}
+				} else {
+					matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model) // match gpts & thinking-*
+					if _, ok := tokenModelLimit[matchName]; !ok {
+						abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
+						return
+					}
 				}
 			}
 
@@ -78,6 +93,12 @@ func Distribute() func(c *gin.Context) {
 					abortWithOpenAiMessage(c, http.StatusBadRequest, "未指定模型名称，模型名称不能为空")
 					return
 				}
+				if !service.IsValidationModelAllowed(modelRequest.Model, common.GetContextKeyInt(c, constant.ContextKeyUserRole)) {
+					abortWithOpenAiMessage(c, http.StatusForbidden, "模型 "+modelRequest.Model+" 正在验证中，暂仅允许管理员访问")
+					return
+				}
+				// 分配 A/B 实验分组，分组可能指定优先选择的渠道类型
+				service.AssignExperiment(c, modelRequest.Model)
 				var selectGroup string
 				usingGroup := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
 				// check path is /pg/chat/completions
@@ -97,13 +118,30 @@ func Distribute() func(c *gin.Context) {
 						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
 					}
 				}
-				channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(c, usingGroup, modelRequest.Model, 0)
+				if policy, isModelGroup := model_setting.GetModelGroupPolicy(modelRequest.Model); isModelGroup {
+					// 模型组名称解析为具体模型，后续计费与转发均使用具体模型
+					groupName := modelRequest.Model
+					var resolvedModel string
+					resolvedModel, channel, selectGroup, err = service.ResolveModelGroup(c, usingGroup, groupName, policy)
+					if err == nil {
+						common.SetContextKey(c, constant.ContextKeyModelGroup, groupName)
+						modelRequest.Model = resolvedModel
+					}
+				} else {
+					channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(c, usingGroup, modelRequest.Model, 0)
+				}
+				var unsupportedErr *service.EndpointUnsupportedError
+				if errors.As(err, &unsupportedErr) {
+					abortWithEndpointUnsupported(c, unsupportedErr, unsupportedErr.LocalizedError().Localize(service.GetErrorMessageLanguage(c)))
+					return
+				}
 				if err != nil {
 					showGroup := usingGroup
 					if usingGroup == "auto" {
 						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
 					}
-					message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
+					lang := service.GetErrorMessageLanguage(c)
+					message := types.NewLocalizedError(types.ErrMsgDistributorGetChannelFailed, showGroup, modelRequest.Model, types.LocalizeErrorMessage(err, lang)).Localize(lang)
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
 					//if channel != nil {
 					//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
@@ -113,7 +151,7 @@ func Distribute() func(c *gin.Context) {
 					return
 				}
 				if channel == nil {
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", usingGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
+					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, types.NewLocalizedError(types.ErrMsgDistributorChannelNotFound, usingGroup, modelRequest.Model).Localize(service.GetErrorMessageLanguage(c)), string(types.ErrorCodeModelNotFound))
 					return
 				}
 			}
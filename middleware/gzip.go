@@ -1,39 +1,92 @@
 package middleware
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/constant"
 
 	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
 )
 
+// DecompressRequestMiddleware 解压 Content-Encoding 为 gzip、deflate 或 br 的请求体
+// 请求体在解析与格式转换之前一次性解压，解压后超过 MAX_DECOMPRESSED_REQUEST_BODY_MB 时返回 413，避免压缩炸弹耗尽内存
 func DecompressRequestMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Body == nil || c.Request.Method == http.MethodGet {
 			c.Next()
 			return
 		}
-		switch c.GetHeader("Content-Encoding") {
-		case "gzip":
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		var reader io.Reader
+		switch encoding {
+		case "gzip", "x-gzip":
 			gzipReader, err := gzip.NewReader(c.Request.Body)
 			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
+				abortWithOpenAiMessage(c, http.StatusBadRequest, "invalid gzip request body: "+err.Error(), "invalid_request_body")
 				return
 			}
 			defer gzipReader.Close()
-
-			// Replace the request body with the decompressed data
-			c.Request.Body = io.NopCloser(gzipReader)
-			c.Request.Header.Del("Content-Encoding")
+			reader = gzipReader
+		case "deflate":
+			deflateReader, err := newDeflateReader(c.Request.Body)
+			if err != nil {
+				abortWithOpenAiMessage(c, http.StatusBadRequest, "invalid deflate request body: "+err.Error(), "invalid_request_body")
+				return
+			}
+			defer deflateReader.Close()
+			reader = deflateReader
 		case "br":
-			reader := brotli.NewReader(c.Request.Body)
-			c.Request.Body = io.NopCloser(reader)
-			c.Request.Header.Del("Content-Encoding")
+			reader = brotli.NewReader(c.Request.Body)
+		default:
+			c.Next()
+			return
+		}
+
+		limit := int64(constant.MaxDecompressedRequestBodyMB) << 20
+		if limit <= 0 {
+			limit = 64 << 20
 		}
+		body, err := io.ReadAll(io.LimitReader(reader, limit+1))
+		_ = c.Request.Body.Close()
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, fmt.Sprintf("failed to decompress %s request body: %s", encoding, err.Error()), "invalid_request_body")
+			return
+		}
+		if int64(len(body)) > limit {
+			abortWithOpenAiMessage(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("decompressed request body exceeds the limit of %d MB", limit>>20), "request_body_too_large")
+			return
+		}
+
+		// Replace the request body with the decompressed data
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
 
 		// Continue processing the request
 		c.Next()
 	}
 }
+
+// newDeflateReader 创建 deflate 解压器，按 HTTP 规范优先解析 zlib 封装，兼容部分客户端发送的原始 deflate 数据
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// zlib 头：CM 为 8 且前两字节按大端序可被 31 整除
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
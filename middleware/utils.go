@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -25,6 +28,21 @@ func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string, code
 	logger.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", userId, message))
 }
 
+// abortWithEndpointUnsupported 分组下的渠道均无法处理请求的接口，返回 400 并列出这些渠道支持的接口
+func abortWithEndpointUnsupported(c *gin.Context, err *service.EndpointUnsupportedError, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": gin.H{
+			"message":             common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			"type":                "invalid_request_error",
+			"code":                string(types.ErrorCodeEndpointUnsupported),
+			"endpoint":            err.Endpoint,
+			"supported_endpoints": err.SupportedEndpoints,
+		},
+	})
+	c.Abort()
+	logger.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", c.GetInt("id"), message))
+}
+
 func abortWithMidjourneyMessage(c *gin.Context, statusCode int, code int, description string) {
 	c.JSON(statusCode, gin.H{
 		"description": description,
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// asyncWriteFlushInterval 日志未攒满一批时的最长等待时间
+const asyncWriteFlushInterval = 500 * time.Millisecond
+
+// asyncWriteTask 写入池中的一个任务，billing 与 log 二选一
+type asyncWriteTask struct {
+	billing func()
+	log     *Log
+}
+
+var (
+	asyncWriteQueue   chan asyncWriteTask
+	asyncWriteDropped atomic.Int64
+	// asyncWriteMutex 保护队列的关闭，提交方持读锁发送，关闭时持写锁，避免向已关闭的队列发送
+	asyncWriteMutex   sync.RWMutex
+	asyncWriteClosed  bool
+	asyncWriteWorkers sync.WaitGroup
+)
+
+// InitAsyncWriter 启动日志与计费写入池，日志按批插入
+// 多个 worker 并发消费队列，计费任务之间不保证执行顺序，计费任务只能是可交换的增减操作
+func InitAsyncWriter() {
+	queueSize := common.AsyncWriteQueueSize
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+	workers := common.AsyncWriteWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	asyncWriteQueue = make(chan asyncWriteTask, queueSize)
+	asyncWriteWorkers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go runAsyncWriter()
+	}
+}
+
+// ShutdownAsyncWriter 停止接收新任务，等待队列中的计费任务与日志全部写入后返回
+// 关闭后提交的任务在当前协程直接执行
+func ShutdownAsyncWriter() {
+	if asyncWriteQueue == nil {
+		return
+	}
+	asyncWriteMutex.Lock()
+	if asyncWriteClosed {
+		asyncWriteMutex.Unlock()
+		return
+	}
+	asyncWriteClosed = true
+	close(asyncWriteQueue)
+	asyncWriteMutex.Unlock()
+	asyncWriteWorkers.Wait()
+}
+
+func isAsyncWriterClosed() bool {
+	asyncWriteMutex.RLock()
+	defer asyncWriteMutex.RUnlock()
+	return asyncWriteClosed
+}
+
+// enqueueAsyncWrite 尝试将任务放入队列，写入池已关闭或队列已满时返回 false
+func enqueueAsyncWrite(task asyncWriteTask) bool {
+	asyncWriteMutex.RLock()
+	defer asyncWriteMutex.RUnlock()
+	if asyncWriteClosed {
+		return false
+	}
+	select {
+	case asyncWriteQueue <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// SubmitBillingWrite 提交计费写入，写入池未启用或队列已满时在当前协程执行，计费写入不会被丢弃
+// task 在请求结束后才可能执行，不应再访问请求的 gin.Context
+func SubmitBillingWrite(task func()) {
+	if asyncWriteQueue == nil {
+		task()
+		return
+	}
+	if !enqueueAsyncWrite(asyncWriteTask{billing: task}) {
+		runBillingWrite(task)
+	}
+}
+
+// GetAsyncWriteStats 获取写入池的队列长度与因过载丢弃的日志数
+func GetAsyncWriteStats() (queued int, dropped int64) {
+	if asyncWriteQueue == nil {
+		return 0, 0
+	}
+	return len(asyncWriteQueue), asyncWriteDropped.Load()
+}
+
+// writeLog 写入一条日志，写入池启用时排队批量插入
+// 队列已满时，verbose 日志按 ASYNC_WRITE_DROP_VERBOSE 丢弃，其余日志在当前协程直接插入
+func writeLog(log *Log, verbose bool) error {
+	if asyncWriteQueue == nil {
+		return LOG_DB.Create(log).Error
+	}
+	if enqueueAsyncWrite(asyncWriteTask{log: log}) {
+		return nil
+	}
+	if verbose && common.AsyncWriteDropVerbose && !isAsyncWriterClosed() {
+		// 过载时只偶尔提示，避免系统日志本身成为负担
+		if dropped := asyncWriteDropped.Add(1); dropped%1000 == 1 {
+			common.SysLog(fmt.Sprintf("async write queue is full, %d verbose logs dropped so far", dropped))
+		}
+		return nil
+	}
+	return LOG_DB.Create(log).Error
+}
+
+func runAsyncWriter() {
+	defer asyncWriteWorkers.Done()
+	batchSize := common.AsyncWriteBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	batch := make([]*Log, 0, batchSize)
+	ticker := time.NewTicker(asyncWriteFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case task, ok := <-asyncWriteQueue:
+			if !ok {
+				// 队列已关闭，写入剩余的日志后退出
+				if len(batch) > 0 {
+					flushLogBatch(batch)
+				}
+				return
+			}
+			if task.billing != nil {
+				runBillingWrite(task.billing)
+				continue
+			}
+			batch = append(batch, task.log)
+			if len(batch) >= batchSize {
+				flushLogBatch(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				flushLogBatch(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// runBillingWrite 执行计费任务，任务 panic 时记录错误，不影响写入池的其他任务
+func runBillingWrite(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.SysError(fmt.Sprintf("async billing write panic: %v", r))
+		}
+	}()
+	task()
+}
+
+// flushLogBatch 批量插入日志，批量插入失败时逐条插入，避免一条异常日志导致整批丢失
+func flushLogBatch(batch []*Log) {
+	if err := LOG_DB.CreateInBatches(batch, len(batch)).Error; err == nil {
+		return
+	}
+	for _, log := range batch {
+		log.Id = 0
+		if err := LOG_DB.Create(log).Error; err != nil {
+			common.SysLog("failed to record log: " + err.Error())
+		}
+	}
+}
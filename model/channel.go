@@ -893,6 +893,26 @@ func (channel *Channel) SetOtherSettings(setting dto.ChannelOtherSettings) {
 	channel.OtherSettings = string(settingBytes)
 }
 
+// GetActiveMaintenanceWindow 获取渠道在指定时间生效的维护窗口（含排空期），没有时返回 nil
+func (channel *Channel) GetActiveMaintenanceWindow(now int64) *dto.ChannelMaintenanceWindow {
+	// 渠道选择时对每个候选渠道调用，未配置维护窗口时跳过 JSON 解析
+	if !strings.Contains(channel.OtherSettings, "maintenance_windows") {
+		return nil
+	}
+	settings := channel.GetOtherSettings()
+	return settings.GetActiveMaintenanceWindow(now)
+}
+
+// IsEndpointAllowed 判断渠道是否允许处理指定接口
+func (channel *Channel) IsEndpointAllowed(endpoint string) bool {
+	// 每次请求都会调用，未配置接口限制时跳过 JSON 解析
+	if !strings.Contains(channel.OtherSettings, "allowed_endpoints") {
+		return true
+	}
+	settings := channel.GetOtherSettings()
+	return settings.IsEndpointAllowed(endpoint)
+}
+
 func (channel *Channel) GetParamOverride() map[string]interface{} {
 	paramOverride := make(map[string]interface{})
 	if channel.ParamOverride != nil && *channel.ParamOverride != "" {
@@ -111,6 +111,9 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		channels = group2model2channels[group][normalizedModel]
 	}
 
+	// 维护窗口内（含排空期）的渠道不参与选择
+	channels = filterMaintenanceChannels(channels)
+
 	if len(channels) == 0 {
 		return nil, nil
 	}
@@ -190,6 +193,69 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	return nil, errors.New("channel not found")
 }
 
+// filterMaintenanceChannels 过滤处于维护窗口内的渠道，调用方需持有 channelSyncLock
+func filterMaintenanceChannels(channelIds []int) []int {
+	now := common.GetTimestamp()
+	var available []int
+	for i, channelId := range channelIds {
+		channel, ok := channelsIDM[channelId]
+		if !ok || channel.GetActiveMaintenanceWindow(now) == nil {
+			if available != nil {
+				available = append(available, channelId)
+			}
+			continue
+		}
+		if available == nil {
+			available = append(make([]int, 0, len(channelIds)), channelIds[:i]...)
+		}
+	}
+	if available == nil {
+		return channelIds
+	}
+	return available
+}
+
+// GetSatisfiedChannels 获取分组下支持指定模型的全部启用渠道，用于需要按渠道属性筛选的路由策略
+func GetSatisfiedChannels(group string, model string) ([]*Channel, error) {
+	if !common.MemoryCacheEnabled {
+		var channelIds []int
+		err := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).Pluck("channel_id", &channelIds).Error
+		if err != nil || len(channelIds) == 0 {
+			return nil, err
+		}
+		var channels []*Channel
+		// 能力表的启用状态可能与渠道状态不同步，按渠道状态再次过滤
+		err = DB.Where("id in ? and status = ?", channelIds, common.ChannelStatusEnabled).Find(&channels).Error
+		if err != nil {
+			return nil, err
+		}
+		now := common.GetTimestamp()
+		available := make([]*Channel, 0, len(channels))
+		for _, channel := range channels {
+			if channel.GetActiveMaintenanceWindow(now) == nil {
+				available = append(available, channel)
+			}
+		}
+		return available, nil
+	}
+
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+
+	channelIds := group2model2channels[group][model]
+	if len(channelIds) == 0 {
+		channelIds = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+	}
+	now := common.GetTimestamp()
+	channels := make([]*Channel, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		if channel, ok := channelsIDM[channelId]; ok && channel.GetActiveMaintenanceWindow(now) == nil {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
 func CacheGetChannel(id int) (*Channel, error) {
 	if !common.MemoryCacheEnabled {
 		return GetChannelById(id, true)
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+)
+
+// ConversionTrace 一次请求的转换追踪记录，保存最后一次渠道尝试的请求与响应，写入日志库以便跨节点、重启后查询
+type ConversionTrace struct {
+	Id                 int    `json:"id"`
+	RequestId          string `json:"request_id" gorm:"index;default:''"`
+	UserId             int    `json:"user_id" gorm:"index"`
+	TokenId            int    `json:"token_id" gorm:"default:0"`
+	ChannelId          int    `json:"channel_id"`
+	ModelName          string `json:"model_name"`
+	UpstreamModel      string `json:"upstream_model"`
+	ConvertedFrom      string `json:"converted_from"`
+	ConversionNotes    string `json:"conversion_notes"` // 多条说明以换行分隔
+	IsStream           bool   `json:"is_stream"`
+	StatusCode         int    `json:"status_code"`
+	Error              string `json:"error"`
+	OriginalRequest    string `json:"original_request,omitempty"`
+	UpstreamRequest    string `json:"upstream_request,omitempty"`  // 转换后发送给上游的请求，未转换时为空
+	UpstreamResponse   string `json:"upstream_response,omitempty"` // 上游原始响应，流式请求为原始事件流
+	DownstreamResponse string `json:"downstream_response,omitempty"`
+	Truncated          bool   `json:"truncated"`
+	CreatedAt          int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// conversionTraceSummaryColumns 列表查询只读取摘要字段，不读取请求与响应内容
+var conversionTraceSummaryColumns = []string{
+	"id", "request_id", "user_id", "token_id", "channel_id", "model_name", "upstream_model",
+	"converted_from", "is_stream", "status_code", "error", "truncated", "created_at",
+}
+
+// InsertConversionTrace 保存转换追踪记录，并删除超出保留数量的最早记录
+// 参数:
+//   - trace: 追踪记录
+//   - keep: 保留的记录数，不大于 0 时不清理
+//
+// 返回:
+//   - error: 写入失败时返回错误，清理失败只记录日志
+func InsertConversionTrace(trace *ConversionTrace, keep int) error {
+	if err := LOG_DB.Create(trace).Error; err != nil {
+		return err
+	}
+	if keep > 0 && trace.Id > keep {
+		if err := LOG_DB.Where("id <= ?", trace.Id-keep).Delete(&ConversionTrace{}).Error; err != nil {
+			common.SysError("failed to cleanup conversion traces: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// GetConversionTraces 分页获取转换追踪记录的摘要，按时间从新到旧排列
+func GetConversionTraces(startIdx int, num int) (traces []*ConversionTrace, total int64, err error) {
+	tx := LOG_DB.Model(&ConversionTrace{})
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Select(conversionTraceSummaryColumns).Order("id desc").Limit(num).Offset(startIdx).Find(&traces).Error
+	return traces, total, err
+}
+
+// GetConversionTraceByRequestId 根据请求 ID 获取最近一条转换追踪记录
+func GetConversionTraceByRequestId(requestId string) (*ConversionTrace, error) {
+	trace := &ConversionTrace{}
+	err := LOG_DB.Where("request_id = ?", requestId).Order("id desc").First(trace).Error
+	return trace, err
+}
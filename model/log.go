@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -90,7 +91,8 @@ func RecordLog(userId int, logType int, content string) {
 		Type:      logType,
 		Content:   content,
 	}
-	err := LOG_DB.Create(log).Error
+	// 系统日志在写入池过载时可丢弃，充值、管理等日志始终写入
+	err := writeLog(log, logType == LogTypeSystem)
 	if err != nil {
 		common.SysLog("failed to record log: " + err.Error())
 	}
@@ -98,6 +100,10 @@ func RecordLog(userId int, logType int, content string) {
 
 func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string, tokenName string, content string, tokenId int, useTimeSeconds int,
 	isStream bool, group string, other map[string]interface{}) {
+	if common.GetContextKeyBool(c, constant.ContextKeyValidationMirror) {
+		// 镜像流量由系统发起，不记录用户可见的日志
+		return
+	}
 	logger.LogInfo(c, fmt.Sprintf("record error log: userId=%d, channelId=%d, modelName=%s, tokenName=%s, content=%s", userId, channelId, modelName, tokenName, content))
 	username := c.GetString("username")
 	otherStr := common.MapToJsonStr(other)
@@ -132,7 +138,7 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 		}(),
 		Other: otherStr,
 	}
-	err := LOG_DB.Create(log).Error
+	err := writeLog(log, true)
 	if err != nil {
 		logger.LogError(c, "failed to record log: "+err.Error())
 	}
@@ -154,7 +160,9 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
-	if !common.LogConsumeEnabled {
+	// 未开启消费日志时也记录实际消耗，供请求结束时的实验指标统计使用
+	common.SetContextKey(c, constant.ContextKeyConsumedQuota, params.Quota)
+	if !common.LogConsumeEnabled || common.GetContextKeyBool(c, constant.ContextKeyValidationMirror) {
 		return
 	}
 	logger.LogInfo(c, fmt.Sprintf("record consume log: userId=%d, params=%s", userId, common.GetJsonString(params)))
@@ -191,13 +199,16 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 		}(),
 		Other: otherStr,
 	}
-	err := LOG_DB.Create(log).Error
+	// 消费日志是计费记录，写入池过载时也不丢弃
+	err := writeLog(log, false)
 	if err != nil {
 		logger.LogError(c, "failed to record log: "+err.Error())
 	}
 	if common.DataExportEnabled {
 		gopool.Go(func() {
 			LogQuotaData(userId, username, params.ModelName, params.Quota, common.GetTimestamp(), params.PromptTokens+params.CompletionTokens)
+			convertedFrom, _ := params.Other["converted_from"].(string)
+			LogTokenUsageData(params.TokenId, params.ModelName, convertedFrom, params.PromptTokens, params.CompletionTokens, params.Quota, common.GetTimestamp())
 		})
 	}
 }
@@ -267,6 +278,71 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 	return logs, total, err
 }
 
+// LogExportFilter 日志导出的过滤条件
+type LogExportFilter struct {
+	LogType        int
+	StartTimestamp int64
+	EndTimestamp   int64
+	ModelName      string
+	Channel        int
+	ConvertedFrom  string // 转换路径：chat 或 claude
+	ErrorCode      string
+	MinId          int // 只导出 id 不小于该值的日志，为 0 时不限制
+}
+
+// logExportLikeEscape LIKE 过滤使用的转义字符，各数据库均需通过 ESCAPE 显式指定
+const logExportLikeEscape = "!"
+
+// escapeLikePattern 转义 LIKE 模式中的通配符，使过滤值按字面匹配
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(logExportLikeEscape, logExportLikeEscape+logExportLikeEscape, "%", logExportLikeEscape+"%", "_", logExportLikeEscape+"_").Replace(value)
+}
+
+// logExportQuery 按过滤条件构建导出查询，beforeId 大于 0 时只查询 id 小于该值的日志
+func logExportQuery(filter LogExportFilter, beforeId int) *gorm.DB {
+	tx := LOG_DB.Model(&Log{})
+	if filter.LogType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", filter.LogType)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("logs.model_name like ? escape '"+logExportLikeEscape+"'", escapeLikePattern(filter.ModelName))
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.Channel != 0 {
+		tx = tx.Where("logs.channel_id = ?", filter.Channel)
+	}
+	if filter.ConvertedFrom != "" {
+		tx = tx.Where("logs.other like ? escape '"+logExportLikeEscape+"'", "%\"converted_from\":\""+escapeLikePattern(filter.ConvertedFrom)+"\"%")
+	}
+	if filter.ErrorCode != "" {
+		tx = tx.Where("logs.other like ? escape '"+logExportLikeEscape+"'", "%\"error_code\":\""+escapeLikePattern(filter.ErrorCode)+"\"%")
+	}
+	if filter.MinId > 0 {
+		tx = tx.Where("logs.id >= ?", filter.MinId)
+	}
+	if beforeId > 0 {
+		tx = tx.Where("logs.id < ?", beforeId)
+	}
+	return tx
+}
+
+// ExportLogs 按 id 倒序分批导出日志，beforeId 为上一批最后一条日志的 id，为 0 时从最新日志开始
+func ExportLogs(filter LogExportFilter, beforeId int, num int) (logs []*Log, err error) {
+	err = logExportQuery(filter, beforeId).Order("logs.id desc").Limit(num).Find(&logs).Error
+	return logs, err
+}
+
+// ExportLogIds 按 id 倒序获取一页待导出日志的 id，用于在写出日志前确定页的范围
+func ExportLogIds(filter LogExportFilter, beforeId int, num int) (ids []int, err error) {
+	err = logExportQuery(filter, beforeId).Order("logs.id desc").Limit(num).Pluck("logs.id", &ids).Error
+	return ids, err
+}
+
 func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int, group string) (logs []*Log, total int64, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
@@ -11,6 +11,8 @@ import (
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"gorm.io/gorm"
 )
 
 type Option struct {
@@ -189,6 +191,16 @@ func UpdateOption(key string, value string) error {
 	return updateOptionMap(key, value)
 }
 
+// SaveOptionTx 在事务中保存配置项，不更新内存中的配置，事务提交前需调用 UpdateOptionMap 使其生效
+func SaveOptionTx(tx *gorm.DB, key string, value string) error {
+	return tx.Save(&Option{Key: key, Value: value}).Error
+}
+
+// UpdateOptionMap 更新内存中的配置项，用于配合 SaveOptionTx 在事务中批量修改配置
+func UpdateOptionMap(key string, value string) error {
+	return updateOptionMap(key, value)
+}
+
 func updateOptionMap(key string, value string) (err error) {
 	common.OptionMapRWMutex.Lock()
 	defer common.OptionMapRWMutex.Unlock()
@@ -27,6 +27,12 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	MaxOutputTokens    int            `json:"max_output_tokens" gorm:"default:0"`                 // 单次请求最大输出 token 数，0 表示不限制
+	MaxToolCalls       int            `json:"max_tool_calls" gorm:"default:0"`                    // 单次请求默认最大工具调用次数，0 表示不限制
+	FeatureFlags       string         `json:"feature_flags" gorm:"type:varchar(1024);default:''"` // 实验性转换行为开关，JSON 对象，如 {"enable_stop_emulation":true}
+	RequestTimeout     int            `json:"request_timeout" gorm:"default:0"`                   // 请求默认超时秒数，客户端未通过 X-Request-Timeout 指定时使用，0 表示不限制
+	ParentTokenId      int            `json:"parent_token_id" gorm:"index;default:0"`             // 通过令牌交换签发的短期子令牌所属的父令牌 ID，0 表示普通令牌
+	ReservedQuota      int            `json:"reserved_quota" gorm:"default:0"`                    // 子令牌签发时从父令牌预留的额度，子令牌删除或过期清理时退还未使用部分
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -110,11 +116,41 @@ func ValidateUserToken(key string) (token *Token, err error) {
 			keySuffix := key[len(key)-3:]
 			return token, errors.New(fmt.Sprintf("[sk-%s***%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", keyPrefix, keySuffix, token.RemainQuota))
 		}
+		if token.ParentTokenId != 0 {
+			// 子令牌随父令牌一同失效，父令牌被禁用、删除或过期后子令牌不可再使用
+			if err := validateParentToken(token.ParentTokenId); err != nil {
+				return token, err
+			}
+		}
 		return token, nil
 	}
 	return nil, errors.New("无效的令牌")
 }
 
+// validateParentToken 校验子令牌的父令牌仍然可用，校验结果按 parentTokenCacheSeconds 缓存
+func validateParentToken(parentId int) error {
+	if err, ok := getCachedParentTokenState(parentId); ok {
+		return err
+	}
+	err := loadParentTokenState(parentId)
+	setCachedParentTokenState(parentId, err)
+	return err
+}
+
+func loadParentTokenState(parentId int) error {
+	parent, err := GetTokenById(parentId)
+	if err != nil {
+		return errors.New("该令牌的父令牌不存在")
+	}
+	if parent.Status != common.TokenStatusEnabled {
+		return errors.New("该令牌的父令牌状态不可用")
+	}
+	if parent.ExpiredTime != -1 && parent.ExpiredTime < common.GetTimestamp() {
+		return errors.New("该令牌的父令牌已过期")
+	}
+	return nil
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -184,8 +220,9 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
+	invalidateParentTokenState(token.Id)
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "max_output_tokens", "max_tool_calls", "feature_flags", "request_timeout").Updates(token).Error
 	return err
 }
 
@@ -200,6 +237,7 @@ func (token *Token) SelectUpdate() (err error) {
 			})
 		}
 	}()
+	invalidateParentTokenState(token.Id)
 	// This can update zero values
 	return DB.Model(token).Select("accessed_time", "status").Updates(token).Error
 }
@@ -215,6 +253,7 @@ func (token *Token) Delete() (err error) {
 			})
 		}
 	}()
+	invalidateParentTokenState(token.Id)
 	err = DB.Delete(token).Error
 	return err
 }
@@ -239,6 +278,18 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
 	return limitsMap
 }
 
+// GetFeatureFlags 解析令牌启用的实验性转换行为，未配置或格式错误时返回空集合
+func (token *Token) GetFeatureFlags() map[string]bool {
+	flags := make(map[string]bool)
+	if strings.TrimSpace(token.FeatureFlags) == "" {
+		return flags
+	}
+	if err := common.UnmarshalJsonStr(token.FeatureFlags, &flags); err != nil {
+		return make(map[string]bool)
+	}
+	return flags
+}
+
 func DisableModelLimits(tokenId int) error {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
@@ -259,7 +310,11 @@ func DeleteTokenById(id int, userId int) (err error) {
 	if err != nil {
 		return err
 	}
-	return token.Delete()
+	if err = token.Delete(); err != nil {
+		return err
+	}
+	refundChildTokenQuota(&token)
+	return nil
 }
 
 func IncreaseTokenQuota(id int, key string, quota int) (err error) {
@@ -359,6 +414,10 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 			}
 		})
 	}
+	for i := range tokens {
+		invalidateParentTokenState(tokens[i].Id)
+		refundChildTokenQuota(&tokens[i])
+	}
 
 	return len(tokens), nil
 }
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	// parentTokenCacheSeconds 父令牌校验结果的缓存秒数，本节点修改父令牌时立即失效，其他节点最多延迟该时间生效
+	parentTokenCacheSeconds = 30
+	// childTokenCleanupBatchSize 每轮清理的过期子令牌数量上限
+	childTokenCleanupBatchSize = 500
+)
+
+type parentTokenState struct {
+	err       error
+	checkedAt int64
+}
+
+var parentTokenStates sync.Map // map[int]parentTokenState
+
+func getCachedParentTokenState(parentId int) (error, bool) {
+	value, ok := parentTokenStates.Load(parentId)
+	if !ok {
+		return nil, false
+	}
+	state := value.(parentTokenState)
+	if common.GetTimestamp()-state.checkedAt >= parentTokenCacheSeconds {
+		parentTokenStates.Delete(parentId)
+		return nil, false
+	}
+	return state.err, true
+}
+
+func setCachedParentTokenState(parentId int, err error) {
+	parentTokenStates.Store(parentId, parentTokenState{err: err, checkedAt: common.GetTimestamp()})
+}
+
+// invalidateParentTokenState 令牌被修改或删除时清除其作为父令牌的校验缓存
+func invalidateParentTokenState(tokenId int) {
+	parentTokenStates.Delete(tokenId)
+}
+
+// refundChildTokenQuota 将子令牌未使用的预留额度退还父令牌，仅在子令牌删除后调用，避免退还后子令牌仍可继续消费
+// 父令牌已被删除时不再退还
+func refundChildTokenQuota(child *Token) {
+	if child.ParentTokenId == 0 || child.ReservedQuota <= 0 {
+		return
+	}
+	refund := child.RemainQuota
+	if refund > child.ReservedQuota {
+		refund = child.ReservedQuota
+	}
+	if refund <= 0 {
+		return
+	}
+	parent, err := GetTokenById(child.ParentTokenId)
+	if err != nil {
+		return
+	}
+	if err := IncreaseTokenQuota(parent.Id, parent.Key, refund); err != nil {
+		common.SysError(fmt.Sprintf("failed to refund child token #%d quota to parent token #%d: %s", child.Id, parent.Id, err.Error()))
+	}
+}
+
+// CleanupExpiredChildTokens 删除已过期的子令牌，并将未使用的预留额度退还父令牌
+// 返回:
+//   - int: 删除的子令牌数量
+//   - error: 查询或删除失败时返回错误
+func CleanupExpiredChildTokens() (int, error) {
+	var tokens []Token
+	err := DB.Where("parent_token_id <> ? AND expired_time <> ? AND expired_time < ?", 0, -1, common.GetTimestamp()).
+		Limit(childTokenCleanupBatchSize).Find(&tokens).Error
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range tokens {
+		if err := tokens[i].Delete(); err != nil {
+			common.SysError(fmt.Sprintf("failed to delete expired child token #%d: %s", tokens[i].Id, err.Error()))
+			continue
+		}
+		refundChildTokenQuota(&tokens[i])
+		deleted++
+	}
+	return deleted, nil
+}
+
+// CleanupExpiredChildTokensPeriodically 定期清理过期的子令牌，仅在主节点运行
+func CleanupExpiredChildTokensPeriodically(frequency time.Duration) {
+	ticker := time.NewTicker(frequency)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := CleanupExpiredChildTokens()
+		if err != nil {
+			common.SysError("failed to cleanup expired child tokens: " + err.Error())
+			continue
+		}
+		if deleted > 0 {
+			common.SysLog(fmt.Sprintf("cleaned up %d expired child tokens", deleted))
+		}
+	}
+}
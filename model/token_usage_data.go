@@ -0,0 +1,90 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// TokenUsageData 按令牌、天、模型与转换路径汇总的用量，供令牌持有者自助查询，不包含任何请求内容
+type TokenUsageData struct {
+	Id               int    `json:"id"`
+	TokenId          int    `json:"token_id" gorm:"index:idx_tud_token_day,priority:1"`
+	Day              int64  `json:"day" gorm:"bigint;index:idx_tud_token_day,priority:2"` // 当天 0 点（UTC）的时间戳
+	ModelName        string `json:"model_name" gorm:"size:64;default:''"`
+	ConvertedFrom    string `json:"converted_from" gorm:"size:16;default:''"` // 转换路径：chat 或 claude，原生请求为空
+	Count            int    `json:"count" gorm:"default:0"`
+	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
+	Quota            int    `json:"quota" gorm:"default:0"`
+}
+
+var cacheTokenUsageData = make(map[string]*TokenUsageData)
+var cacheTokenUsageDataLock = sync.Mutex{}
+
+// LogTokenUsageData 将一次消费记录累加到令牌用量缓存，由 SaveTokenUsageDataCache 定期写入数据库
+func LogTokenUsageData(tokenId int, modelName string, convertedFrom string, promptTokens int, completionTokens int, quota int, createdAt int64) {
+	if tokenId == 0 {
+		return
+	}
+	// 只精确到天
+	day := createdAt - (createdAt % 86400)
+	key := fmt.Sprintf("%d-%d-%s-%s", tokenId, day, modelName, convertedFrom)
+
+	cacheTokenUsageDataLock.Lock()
+	defer cacheTokenUsageDataLock.Unlock()
+	usageData, ok := cacheTokenUsageData[key]
+	if !ok {
+		usageData = &TokenUsageData{
+			TokenId:       tokenId,
+			Day:           day,
+			ModelName:     modelName,
+			ConvertedFrom: convertedFrom,
+		}
+		cacheTokenUsageData[key] = usageData
+	}
+	usageData.Count += 1
+	usageData.PromptTokens += promptTokens
+	usageData.CompletionTokens += completionTokens
+	usageData.Quota += quota
+}
+
+// SaveTokenUsageDataCache 将令牌用量缓存合并写入数据库
+func SaveTokenUsageDataCache() {
+	cacheTokenUsageDataLock.Lock()
+	cached := cacheTokenUsageData
+	cacheTokenUsageData = make(map[string]*TokenUsageData)
+	cacheTokenUsageDataLock.Unlock()
+
+	for _, usageData := range cached {
+		result := DB.Model(&TokenUsageData{}).Where("token_id = ? and day = ? and model_name = ? and converted_from = ?",
+			usageData.TokenId, usageData.Day, usageData.ModelName, usageData.ConvertedFrom).Updates(map[string]interface{}{
+			"count":             gorm.Expr("count + ?", usageData.Count),
+			"prompt_tokens":     gorm.Expr("prompt_tokens + ?", usageData.PromptTokens),
+			"completion_tokens": gorm.Expr("completion_tokens + ?", usageData.CompletionTokens),
+			"quota":             gorm.Expr("quota + ?", usageData.Quota),
+		})
+		if result.Error != nil {
+			common.SysLog(fmt.Sprintf("save token usage data error: %s", result.Error))
+			continue
+		}
+		if result.RowsAffected == 0 {
+			if err := DB.Create(usageData).Error; err != nil {
+				common.SysLog(fmt.Sprintf("save token usage data error: %s", err))
+			}
+		}
+	}
+	if len(cached) > 0 {
+		common.SysLog(fmt.Sprintf("保存令牌用量数据成功，共保存%d条数据", len(cached)))
+	}
+}
+
+// GetTokenUsageData 查询令牌在时间范围内的每日用量
+func GetTokenUsageData(tokenId int, startTime int64, endTime int64) ([]*TokenUsageData, error) {
+	var usageData []*TokenUsageData
+	err := DB.Where("token_id = ? and day >= ? and day <= ?", tokenId, startTime-(startTime%86400), endTime).
+		Order("day asc").Find(&usageData).Error
+	return usageData, err
+}
@@ -26,6 +26,7 @@ func UpdateQuotaData() {
 		if common.DataExportEnabled {
 			common.SysLog("正在更新数据看板数据...")
 			SaveQuotaDataCache()
+			SaveTokenUsageDataCache()
 		}
 		time.Sleep(time.Duration(common.DataExportInterval) * time.Minute)
 	}
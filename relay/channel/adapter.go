@@ -29,6 +29,8 @@ type Adaptor interface {
 	GetChannelName() string
 	ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error)
 	ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) (any, error)
+	// Capabilities 适配器支持的接口与特性
+	Capabilities() Capabilities
 }
 
 type TaskAdaptor interface {
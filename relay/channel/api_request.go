@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -61,6 +62,19 @@ func processHeaderOverride(info *common.RelayInfo) (map[string]string, error) {
 }
 
 func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	req, err := NewApiRequest(a, c, info, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doRequest(c, req, info)
+	if err != nil {
+		return nil, fmt.Errorf("do request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// NewApiRequest 按渠道的请求地址、请求头覆盖与适配器的请求头构建上游请求，不发送
+func NewApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Request, error) {
 	fullRequestURL, err := a.GetRequestURL(info)
 	if err != nil {
 		return nil, fmt.Errorf("get request url failed: %w", err)
@@ -84,11 +98,23 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
-	resp, err := doRequest(c, req, info)
+	return req, nil
+}
+
+// NewStoredResponseRequest 构建按响应 ID 获取上游保存的 Responses 响应的请求，不发送
+// 请求地址为适配器的 Responses 请求地址追加响应 ID，认证与请求头覆盖与转发请求一致
+func NewStoredResponseRequest(ctx context.Context, a Adaptor, c *gin.Context, info *common.RelayInfo, responseID string) (*http.Request, error) {
+	req, err := NewApiRequest(a, c, info, nil)
 	if err != nil {
-		return nil, fmt.Errorf("do request failed: %w", err)
+		return nil, err
 	}
-	return resp, nil
+	req = req.WithContext(ctx)
+	req.Method = http.MethodGet
+	req.URL.Path = strings.TrimRight(req.URL.Path, "/") + "/" + url.PathEscape(responseID)
+	req.URL.RawPath = ""
+	req.Header.Del("Content-Type")
+	req.Header.Set("Accept", "application/json")
+	return req, nil
 }
 
 func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
@@ -251,6 +277,24 @@ func sendPingData(c *gin.Context, mutex *sync.Mutex) error {
 	}
 }
 
+// withRequestDeadline 为上游请求设置截止时间，返回的 cancel 需在请求失败或响应体关闭时调用
+func withRequestDeadline(req *http.Request, deadline time.Time) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	return req.WithContext(ctx), cancel
+}
+
+// deadlineBody 关闭响应体时释放上游请求的截止时间上下文
+type deadlineBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *deadlineBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
 	return doRequest(c, req, info)
 }
@@ -286,14 +330,35 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	// 将客户端指定的截止时间设置为上游请求的截止时间，响应体关闭时释放
+	cancelDeadline := context.CancelFunc(func() {})
+	if !info.RequestDeadline.IsZero() {
+		if time.Now().After(info.RequestDeadline) {
+			info.DeadlineExceeded = true
+			return nil, helper.RequestDeadlineExceededError(info)
+		}
+		req, cancelDeadline = withRequestDeadline(req, info.RequestDeadline)
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
+		cancelDeadline()
+		if errors.Is(err, context.DeadlineExceeded) {
+			info.DeadlineExceeded = true
+			logger.LogWarn(c, "upstream request exceeded the request deadline")
+			return nil, helper.RequestDeadlineExceededError(info)
+		}
 		logger.LogError(c, "do request failed: "+err.Error())
 		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
 	}
 	if resp == nil {
+		cancelDeadline()
 		return nil, errors.New("resp is nil")
 	}
+	if !info.RequestDeadline.IsZero() {
+		resp.Body = &deadlineBody{ReadCloser: resp.Body, cancel: cancelDeadline}
+	}
+	helper.ForwardUpstreamResponseHeaders(c, resp)
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
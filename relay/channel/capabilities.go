@@ -0,0 +1,32 @@
+package channel
+
+import "github.com/QuantumNous/new-api/dto"
+
+// Capabilities 适配器支持的接口与特性，用于在转发前筛选渠道，避免请求到达适配器后才返回不支持的错误
+type Capabilities struct {
+	Chat       bool `json:"chat"`       // Chat Completions 接口
+	Responses  bool `json:"responses"`  // OpenAI Responses 接口
+	Claude     bool `json:"claude"`     // Claude Messages 接口
+	Gemini     bool `json:"gemini"`     // Gemini generateContent 接口
+	Embeddings bool `json:"embeddings"` // Embeddings 接口
+	Audio      bool `json:"audio"`      // 语音合成与识别接口
+	Images     bool `json:"images"`     // 图片生成与编辑接口
+	Rerank     bool `json:"rerank"`     // Rerank 接口
+	Streaming  bool `json:"streaming"`  // 流式响应
+	Tools      bool `json:"tools"`      // 函数调用
+	Vision     bool `json:"vision"`     // 图片输入
+}
+
+// EndpointSupport 获取能力矩阵覆盖的渠道接口及是否支持，未覆盖的接口不在结果中
+func (c Capabilities) EndpointSupport() map[string]bool {
+	return map[string]bool{
+		dto.ChannelEndpointChatCompletions: c.Chat,
+		dto.ChannelEndpointResponses:       c.Responses,
+		dto.ChannelEndpointClaudeMessages:  c.Claude,
+		dto.ChannelEndpointGemini:          c.Gemini,
+		dto.ChannelEndpointEmbeddings:      c.Embeddings,
+		dto.ChannelEndpointAudio:           c.Audio,
+		dto.ChannelEndpointImages:          c.Images,
+		dto.ChannelEndpointRerank:          c.Rerank,
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -25,6 +27,8 @@ const (
 
 type Adaptor struct {
 	RequestMode int
+	// relayModeBeforeRouting 智能路由到 Responses 接口前的请求模式，回退到原生 Claude 接口时恢复
+	relayModeBeforeRouting int
 }
 
 func (a *Adaptor) ConvertGeminiRequest(*gin.Context, *relaycommon.RelayInfo, *dto.GeminiChatRequest) (any, error) {
@@ -88,29 +92,22 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *rel
 	return nil
 }
 
-// ClaudeSmartRoutingConfig 智能路由配置
-type ClaudeSmartRoutingConfig struct {
-	Enabled         bool     `json:"enabled"`
-	ResponsesModels []string `json:"responses_models"`
-	FallbackOnError bool     `json:"fallback_on_error"`
-}
-
 // shouldRouteToResponses 根据模型名称判断是否应该路由到 Responses 渠道
-func (a *Adaptor) shouldRouteToResponses(modelName string) bool {
-	// 定义应该路由到 Responses 渠道的模型列表
-	responsesModels := []string{
-		"claude-3.5-sonnet",
-		"claude-3-opus", 
-		"claude-3-haiku",
-		// 可以根据实际情况扩展
-	}
-	
-	for _, model := range responsesModels {
-		if modelName == model {
-			return true
-		}
+func (a *Adaptor) shouldRouteToResponses(info *relaycommon.RelayInfo, modelName string) bool {
+	// 该转换方向被全局关闭时回退到原生 Claude 接口
+	if model_setting.IsConversionDirectionDisabled(model_setting.ConversionDirectionResponsesToClaude) {
+		return false
 	}
-	return false
+	// 本次请求已回退过原生接口，重试时不再路由，避免在两种接口间反复切换
+	if info.SmartRoutingFallback {
+		return false
+	}
+	return IsResponsesRoutedModel(info.ChannelOtherSettings, modelName)
+}
+
+// IsResponsesRoutedModel 判断 Chat 请求在 Claude 渠道上是否会被智能路由到 Responses 接口，模型列表由渠道设置中的 claude_smart_routing 配置
+func IsResponsesRoutedModel(settings dto.ChannelOtherSettings, modelName string) bool {
+	return settings.ClaudeSmartRouting.IsResponsesModel(modelName)
 }
 
 func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
@@ -119,18 +116,19 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 	}
 
 	// 智能路由检测：检查是否应该路由到 Responses 渠道
-	if a.shouldRouteToResponses(info.OriginModelName) {
-		// 标记这是一个转换后的请求，用于响应处理阶段
-		c.Set("converted_from_claude", true)
-		
-		// 保存原始请求，用于响应转换时参考
-		c.Set("original_claude_request", request)
+	if a.shouldRouteToResponses(info, info.OriginModelName) {
+		// 标记这是一个转换后的请求，并保存原始请求，用于响应转换时参考
+		info.MarkConverted(relaycommon.ConversionSourceClaude, request)
 		
 		// 调用转换器进行格式转换 - 这里需要实现 ClaudeMessagesToResponsesRequest
 		responsesReq, err := ClaudeMessagesToResponsesRequest(c, request, info)
 if err != nil {
+			if !info.ChannelOtherSettings.ClaudeSmartRouting.ShouldFallbackOnError() {
+				return nil, err
+			}
 			// 转换失败时回退到原生 Claude 处理，保证服务可用性
 			logger.LogWarn(c, fmt.Sprintf("Smart routing conversion failed for model %s: %v, fallback to native Claude", info.OriginModelName, err))
+			info.ResetConversion()
 			if a.RequestMode == RequestModeCompletion {
 				return RequestOpenAI2ClaudeComplete(*request), nil
 			} else {
@@ -138,7 +136,16 @@ if err != nil {
 			}
 		}
 		
+		// 按对话历史中的工具调用次数施加上限，超出时直接拒绝，不回退到原生 Claude
+		if apiErr := service.ApplyToolCallBudget(c, responsesReq, service.CountChatToolCalls(request.Messages)); apiErr != nil {
+			return nil, apiErr
+		}
+
+		// 按配置以流式请求上游，响应阶段再聚合为非流式结果
+		helper.ApplyUpstreamStreamAggregation(info, responsesReq, false)
+
 		// 更新 RelayMode 为 Responses 模式
+		a.relayModeBeforeRouting = info.RelayMode
 		info.RelayMode = relayconstant.RelayModeResponses
 		
 		return responsesReq, nil
@@ -161,18 +168,27 @@ func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.Rela
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
-	// TODO implement me
-	return nil, errors.New("not implemented")
+	if a.RequestMode == RequestModeCompletion {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "model %s does not support the Responses API on Claude channels", request.Model)
+	}
+	return ResponsesToClaudeRequest(c, info, &request)
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
-	return channel.DoApiRequest(a, c, info, requestBody)
+	resp, err := channel.DoApiRequest(a, c, info, requestBody)
+	if shouldFallbackToNative(c, info, resp, err) {
+		return a.fallbackToNative(c, info, resp)
+	}
+	return resp, err
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
 	// 检查是否是从Claude转换的请求
-	convertedFromClaude, exists := c.Get("converted_from_claude")
-	if exists && convertedFromClaude.(bool) {
+	if info.ConversionSource == relaycommon.ConversionSourceClaude {
+		// 上游按流式返回但客户端请求的是非流式响应，按非流式处理器输出
+		if info.AggregateUpstreamStream {
+			info.IsStream = false
+		}
 		// 如果是转换的请求，使用Responses流处理器
 		if info.IsStream {
 			return ResponsesToClaudeStreamHandler(c, resp, info)
@@ -182,6 +198,14 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		}
 	}
 
+	// 原生 Responses 请求转换为 Claude Messages 请求，响应转换回 Responses 格式
+	if info.RelayFormat == types.RelayFormatOpenAIResponses {
+		if info.IsStream {
+			return ClaudeToResponsesStreamHandler(c, resp, info)
+		}
+		return ClaudeToResponsesHandler(c, resp, info)
+	}
+
 	// 原有的Claude响应处理逻辑
 	if info.IsStream {
 		return ClaudeStreamHandler(c, resp, info, a.RequestMode)
@@ -197,3 +221,15 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// Capabilities 适配器支持的接口与特性
+func (a *Adaptor) Capabilities() channel.Capabilities {
+	return channel.Capabilities{
+		Chat:      true,
+		Responses: true,
+		Claude:    true,
+		Streaming: true,
+		Tools:     true,
+		Vision:    true,
+	}
+}
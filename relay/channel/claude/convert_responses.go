@@ -3,7 +3,6 @@ package claude
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"unicode"
@@ -12,9 +11,11 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel/openai_responses"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -67,10 +68,10 @@ func cleanInvalidUTF8Bytes(b []byte) []byte {
 //   - error: 转换失败时返回错误
 func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) (*dto.OpenAIResponsesRequest, error) {
 	if claudeRequest == nil {
-		return nil, fmt.Errorf("claude request is nil")
+		return nil, types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "claude request is nil")
 	}
 	if claudeRequest.Model == "" {
-		return nil, fmt.Errorf("model is required")
+		return nil, types.NewConvertError(types.ErrorCodeConvertModelMissing, http.StatusBadRequest, "model is required")
 	}
 
 	// 创建Responses请求对象
@@ -105,7 +106,7 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.General
 		// 先序列化为 JSON 字符串，再转换为 RawMessage
 		instructionsBytes, err := json.Marshal(systemMessage)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal instructions: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "failed to marshal instructions: %s", err.Error())
 		}
 		responsesReq.Instructions = json.RawMessage(instructionsBytes)
 	}
@@ -113,14 +114,14 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.General
 	// 转换messages为input格式
 	inputs, err := convertClaudeMessagesToInputs(claudeRequest.Messages)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert claude messages to inputs: %w", err)
+		return nil, err
 	}
 	
 	// 将inputs序列化为JSON RawMessage
 	if len(inputs) > 0 {
 		inputData, err := json.Marshal(inputs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal inputs: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal inputs: %s", err.Error())
 		}
 		responsesReq.Input = json.RawMessage(inputData)
 	}
@@ -129,16 +130,16 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.General
 	if len(claudeRequest.Tools) > 0 {
 		toolsData, err := json.Marshal(claudeRequest.Tools)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal tools: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "failed to marshal tools: %s", err.Error())
 		}
 		responsesReq.Tools = json.RawMessage(toolsData)
 	}
 
 	// 处理tool_choice参数
 	if claudeRequest.ToolChoice != nil {
-		toolChoiceData, err := json.Marshal(claudeRequest.ToolChoice)
+		toolChoiceData, err := json.Marshal(service.ChatToolChoiceToResponses(claudeRequest.ToolChoice))
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal tool_choice: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "failed to marshal tool_choice: %s", err.Error())
 		}
 		responsesReq.ToolChoice = json.RawMessage(toolChoiceData)
 	}
@@ -147,48 +148,73 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.General
 	if claudeRequest.ParallelToolCalls != nil {
 		parallelData, err := json.Marshal(claudeRequest.ParallelToolCalls)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal parallel_tool_calls: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal parallel_tool_calls: %s", err.Error())
 		}
 		responsesReq.ParallelToolCalls = json.RawMessage(parallelData)
 	}
 
+	// 工具调用次数上限，最终值由适配器结合令牌默认值与对话历史确定
+	responsesReq.MaxToolCalls = claudeRequest.MaxToolCalls
+
+	// 按配置注入会话级缓存标识
+	service.ApplyPromptCacheKey(c, responsesReq, claudeRequest.User)
+
+	// 按上游限制提前校验工具定义，避免上游返回不指明工具的 400
+	if err := service.ValidateToolSchemaLimits(responsesReq.GetToolsMap()); err != nil {
+		return nil, err
+	}
+
+	// 客户端未指定推理强度时使用模型配置的默认值
+	service.ApplyDefaultReasoningEffort(info, responsesReq)
+
+	// 按配置压缩内联 base64 图片
+	service.OptimizeInlineImages(c, info, responsesReq)
+
+	// 渠道支持时透传 stop_sequences，否则丢弃并记录
+	if err := service.ApplyResponsesStopSequences(c, info, responsesReq, service.NormalizeStopSequences(claudeRequest.Stop)); err != nil {
+		return nil, err
+	}
+
 	// 处理其他可传递的参数
-	// 注意：stop 和 response_format 参数在 Responses API 中可能不被支持
-	// 这些参数会被忽略，不会传递给上游 API
+	// 注意：response_format 参数在 Responses API 中可能不被支持
+	// 该参数会被忽略，不会传递给上游 API
 
 	return responsesReq, nil
 }
 
-// extractSystemMessageFromClaude 从Claude消息列表中提取系统消息
+// extractSystemMessageFromClaude 从Claude消息列表中提取系统消息，多条系统消息按出现顺序以 responses.system_message_separator 拼接
 // 参数:
 //   - messages: Claude消息列表
 // 返回:
 //   - string: 系统消息内容，如果没有系统消息则返回空字符串
 func extractSystemMessageFromClaude(messages []dto.Message) string {
+	var parts []string
 	for _, message := range messages {
-		if message.Role == "system" {
-			// 处理不同类型的content
-			if str, ok := message.Content.(string); ok {
-				// 检查字符串是否包含无效的UTF-8字符
-				if !isValidUTF8String(str) {
-					// 清理无效字符
-					str = cleanInvalidUTF8Chars(str)
-				}
-				return str
+		if message.Role != "system" || message.Content == nil {
+			continue
+		}
+		// 处理不同类型的content
+		if str, ok := message.Content.(string); ok {
+			// 检查字符串是否包含无效的UTF-8字符
+			if !isValidUTF8String(str) {
+				// 清理无效字符
+				str = cleanInvalidUTF8Chars(str)
 			}
-			
-			// 如果content是复杂类型，尝试转换为字符串
-			if contentBytes, err := json.Marshal(message.Content); err == nil {
-				// 验证生成的JSON是否有效
-				if !isValidUTF8Bytes(contentBytes) {
-					// 清理无效字符
-					contentBytes = cleanInvalidUTF8Bytes(contentBytes)
-				}
-				return string(contentBytes)
+			if str != "" {
+				parts = append(parts, str)
 			}
+			continue
+		}
+
+		// 结构化内容数组只取其中的文本部分，不含文本时跳过该消息
+		if text := message.StringContent(); text != "" {
+			if !isValidUTF8String(text) {
+				text = cleanInvalidUTF8Chars(text)
+			}
+			parts = append(parts, text)
 		}
 	}
-	return ""
+	return strings.Join(parts, model_setting.GetResponsesSettings().SystemMessageSeparator)
 }
 
 // convertClaudeMessagesToInputs 将Claude的messages转换为Responses API的inputs格式
@@ -226,19 +252,19 @@ func convertClaudeMessagesToInputs(messages []dto.Message) ([]dto.Input, error)
 				}
 				contentBytes, err = json.Marshal(str)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal string content: %w", err)
+					return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal string content: %s", err.Error())
 				}
 			} else {
 				// 如果content是复杂类型，先验证再序列化
 				// 使用json.Marshal然后验证结果
 				contentBytes, err = json.Marshal(message.Content)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal complex content: %w", err)
+					return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "failed to marshal complex content: %s", err.Error())
 				}
 				
 				// 验证生成的JSON是否有效
 				if !isValidUTF8Bytes(contentBytes) {
-					return nil, fmt.Errorf("generated JSON contains invalid UTF-8 characters")
+					return nil, types.NewConvertError(types.ErrorCodeConvertContentEncoding, http.StatusBadRequest, "message content contains invalid UTF-8 characters")
 				}
 			}
 			input.Content = json.RawMessage(contentBytes)
@@ -258,7 +284,7 @@ func convertClaudeMessagesToInputs(messages []dto.Message) ([]dto.Input, error)
 //   - error: 转换失败时返回错误
 func ResponsesToClaudeMessagesResponse(responsesResponse *dto.OpenAIResponsesResponse, originalRequest *dto.GeneralOpenAIRequest) (*dto.OpenAITextResponse, error) {
 	if responsesResponse == nil {
-		return nil, fmt.Errorf("responses response is nil")
+		return nil, types.NewConvertError(types.ErrorCodeConvertResponseInvalid, http.StatusInternalServerError, "responses response is nil")
 	}
 
 	// 处理错误响应
@@ -271,20 +297,20 @@ func ResponsesToClaudeMessagesResponse(responsesResponse *dto.OpenAIResponsesRes
 		}, nil
 	}
 
-	// 提取内容
-	content := extractContentFromOutput(responsesResponse.Output)
+	// 按 output 顺序提取推理、文本与工具调用
+	message := openai_responses.ResponsesOutputToChatMessage(responsesResponse.Output)
 	
 	// 确定finish_reason
-	finishReason := extractFinishReasonFromResponses(responsesResponse.Status)
+	finishReason := extractFinishReasonFromResponses(responsesResponse)
+	if len(message.ToolCalls) > 0 && finishReason == "stop" {
+		finishReason = "tool_calls"
+	}
 	
 	// 构建Choices
 	choices := []dto.OpenAITextResponseChoice{
 		{
-			Index: 0,
-			Message: dto.Message{
-				Role:    "assistant",
-				Content: content,
-			},
+			Index:        0,
+			Message:      message,
 			FinishReason: finishReason,
 		},
 	}
@@ -298,51 +324,31 @@ func ResponsesToClaudeMessagesResponse(responsesResponse *dto.OpenAIResponsesRes
 		Choices: choices,
 	}
 
-	// 处理Usage
+	// 处理Usage，与原生 Responses 计费一致：提示 token 包含缓存命中部分，命中部分按缓存倍率计费
 	if responsesResponse.Usage != nil {
 		claudeResponse.Usage = *responsesResponse.Usage
+		claudeResponse.Usage.PromptTokens = responsesResponse.Usage.InputTokens
+		claudeResponse.Usage.CompletionTokens = responsesResponse.Usage.OutputTokens
+		claudeResponse.Usage.TotalTokens = responsesResponse.Usage.TotalTokens
+		if responsesResponse.Usage.InputTokensDetails != nil {
+			claudeResponse.Usage.PromptTokensDetails.CachedTokens = responsesResponse.Usage.InputTokensDetails.CachedTokens
+		}
 	}
 
 	return claudeResponse, nil
 }
 
-// extractContentFromOutput 从Responses API的Output中提取文本内容
-// 参数:
-//   - output: Responses API的Output数组
-// 返回:
-//   - string: 提取的文本内容
-func extractContentFromOutput(output []dto.ResponsesOutput) string {
-	var contentBuilder string
-	for _, item := range output {
-		if item.Type == "message" && item.Role == "assistant" {
-			for _, contentItem := range item.Content {
-				if contentItem.Type == "output_text" {
-					contentBuilder += contentItem.Text
-				}
-			}
-		}
-	}
-	return contentBuilder
-}
-
 // extractFinishReasonFromResponses 根据Responses API的状态确定finish_reason
 // 参数:
-//   - status: Responses API的响应状态
+//   - response: Responses API的响应对象
 // 返回:
-//   - string: Claude Messages的finish_reason
-func extractFinishReasonFromResponses(status string) string {
-	switch status {
-	case "completed":
-		return "stop"
-	case "incomplete":
-		return "length" // 或者 "content_filter" 等，视具体情况而定
-	case "failed":
-		return "error" // Claude Messages API没有error作为finish_reason，但这是最接近的
-	case "cancelled":
-		return "stop"
-	default:
-		return "stop"
+//   - string: 合法的finish_reason，映射关系可通过 responses.finish_reason_mapping 配置
+func extractFinishReasonFromResponses(response *dto.OpenAIResponsesResponse) string {
+	reason := ""
+	if response.IncompleteDetails != nil {
+		reason = response.IncompleteDetails.Reason
 	}
+	return model_setting.GetResponsesSettings().GetChatFinishReason(response.Status, reason)
 }
 
 // ResponsesToClaudeStreamHandler 处理Responses API流式响应并转换为Claude Messages格式
@@ -355,8 +361,7 @@ func extractFinishReasonFromResponses(status string) string {
 //   - err: 错误信息
 func ResponsesToClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
 	// 检查是否是从Claude转换的请求
-	convertedFromClaude, exists := c.Get("converted_from_claude")
-	if !exists || !convertedFromClaude.(bool) {
+	if info.ConversionSource != relaycommon.ConversionSourceClaude {
 		// 如果不是转换的请求，使用原有的Claude流处理器
 		return ClaudeStreamHandler(c, resp, info, RequestModeMessage)
 	}
@@ -373,31 +378,83 @@ func ResponsesToClaudeStreamHandler(c *gin.Context, resp *http.Response, info *r
 	}
 
 	// 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewStreamBodyCapture()
+
+	// 备用 token 计算使用的输出文本
+	responseTextCounter := service.NewStreamTextCounter(info.UpstreamModelName)
+	info.StreamTokenCounter = responseTextCounter
 
+	// 流式增量去重
+	dedupGuard := helper.NewStreamDedupGuard()
 
+	// 网关输出 token 上限
+	outputBudget := service.NewOutputTokenBudget(info)
+
+	// 周期性发送累计输出 token 数
+	usageTracker := helper.NewClaudeUsageDeltaTracker()
+
+	// 按输出项跟踪内容块的转换器，保证流以 message_stop 结束
+	converter := openai_responses.NewClaudeStreamConverter(info.UpstreamModelName)
+	// 按配置校验发出的事件顺序
+	validator := helper.NewClaudeStreamValidator(c, info)
+	send := func(events []dto.ClaudeResponse) {
+		for i := range events {
+			validator.Observe(&events[i])
+			sendClaudeStreamData(c, &events[i])
+		}
+	}
+
+	// 回复文本后处理，按行缓存增量
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	flushPostProcessor := func() {
+		send(converter.Text(nil, postProcessor.Flush()))
+	}
 
 	// 使用helper.StreamScannerHandler处理流式响应
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 // 保留完整响应体以便在请求失败时进行问题排查
 if len(data) > 0 {
-			fullStreamResponse.WriteString(data)
-			fullStreamResponse.WriteString("\n")
+			fullStreamResponse.Append(data)
 		}
 
 		// 解析Responses API流式响应
 		var streamResponse dto.ResponsesStreamResponse
 		if parseErr := common.UnmarshalJsonStr(data, &streamResponse); parseErr == nil {
+			dedupGuard.Observe(&streamResponse)
+			terminal := helper.IsResponsesTerminalEvent(streamResponse.Type) || streamResponse.Type == "response.incomplete"
+			if streamResponse.Type == "response.output_text.delta" {
+				streamResponse.Delta = dedupGuard.Filter(&streamResponse)
+				// 超出输出上限时以 max_tokens 结束并终止流
+				if !outputBudget.Consume(streamResponse.Delta) {
+					flushPostProcessor()
+					send(converter.Finish("max_tokens", "", usageTracker.Final(0, responseTextCounter.TokenCount())))
+					return false
+				}
+			}
+			// 切换到其他输出项或结束前先输出缓存的剩余文本
+			if terminal || streamResponse.Type == "response.reasoning_summary_text.delta" || streamResponse.Type == "response.output_item.done" {
+				flushPostProcessor()
+			}
 			// 转换为Claude Messages流式格式
-			claudeStreamResp := ConvertResponsesStreamToClaudeStream(&streamResponse, claudeInfo.ResponseId, info.UpstreamModelName)
-			if claudeStreamResp != nil {
-				// 发送Claude格式的流式数据
-				sendClaudeStreamData(c, claudeStreamResp)
+			send(converter.Handle(&streamResponse))
+			if streamResponse.Type == "response.output_item.done" {
+				openai_responses.MarkImageGenerationCall(c, streamResponse.Item)
+			}
+			if streamResponse.Type == "response.output_text.delta" {
+				send(converter.Text(&streamResponse, postProcessor.Push(streamResponse.Delta)))
 			}
 
 		// 处理使用量统计
-		switch streamResponse.Type {
-		case "response.done":
+		switch {
+		case terminal:
+			if streamResponse.Response != nil {
+				// 结束事件中的累计输出 token 数不小于已发送值，上游未返回时使用本地统计值
+				upstreamOutputTokens := 0
+				if streamResponse.Response.Usage != nil {
+					upstreamOutputTokens = streamResponse.Response.Usage.OutputTokens
+				}
+				send(converter.Complete(streamResponse.Response, usageTracker.Final(upstreamOutputTokens, responseTextCounter.TokenCount())))
+			}
 			if streamResponse.Response != nil && streamResponse.Response.Usage != nil {
 				if streamResponse.Response.Usage.InputTokens != 0 {
 					claudeInfo.Usage.PromptTokens = streamResponse.Response.Usage.InputTokens
@@ -408,10 +465,20 @@ if len(data) > 0 {
 				if streamResponse.Response.Usage.TotalTokens != 0 {
 					claudeInfo.Usage.TotalTokens = streamResponse.Response.Usage.TotalTokens
 				}
+				if streamResponse.Response.Usage.InputTokensDetails != nil {
+					claudeInfo.Usage.PromptTokensDetails.CachedTokens = streamResponse.Response.Usage.InputTokensDetails.CachedTokens
+				}
 			}
-		case "response.output_text.delta":
+		case streamResponse.Type == "response.reasoning_summary_text.delta":
+			responseTextCounter.WriteString(streamResponse.Delta)
+		case streamResponse.Type == "response.output_item.done" && streamResponse.Item != nil && streamResponse.Item.Type == dto.ResponsesOutputTypeFunctionCall:
+			responseTextCounter.WriteString(streamResponse.Item.Arguments)
+		case streamResponse.Type == "response.output_text.delta":
 			// 处理输出文本用于备用token计算
-			claudeInfo.ResponseText.WriteString(streamResponse.Delta)
+			responseTextCounter.WriteString(streamResponse.Delta)
+			if usageDelta := usageTracker.Next(responseTextCounter.TokenCount()); usageDelta != nil {
+				send([]dto.ClaudeResponse{*usageDelta})
+			}
 		}
 		} else {
 			logger.LogError(c, "failed to unmarshal responses stream response: "+parseErr.Error())
@@ -419,22 +486,24 @@ if len(data) > 0 {
 		return true
 	})
 
+	// 上游未返回完成事件时补发 message_delta 与 message_stop，避免客户端一直等待
+	flushPostProcessor()
+	send(converter.Finish("end_turn", "", usageTracker.Final(0, responseTextCounter.TokenCount())))
+	validator.Finish()
+
 	// 将完整的流式响应体存储到relayInfo中
 	info.ResponseBody = fullStreamResponse.String()
 
 	// 备用token计算
-	if claudeInfo.Usage.CompletionTokens == 0 {
-		tempStr := claudeInfo.ResponseText.String()
-		if len(tempStr) > 0 {
-			completionTokens := service.CountTextToken(tempStr, info.UpstreamModelName)
-			claudeInfo.Usage.CompletionTokens = completionTokens
-		}
+	if claudeInfo.Usage.CompletionTokens == 0 && !responseTextCounter.IsEmpty() {
+		claudeInfo.Usage.CompletionTokens = responseTextCounter.TokenCount()
 	}
 
 	if claudeInfo.Usage.PromptTokens == 0 && claudeInfo.Usage.CompletionTokens != 0 {
 		claudeInfo.Usage.PromptTokens = info.PromptTokens
 	}
 
+	// 该路径按 Chat Completions 计费，提示 token 保持包含缓存命中部分，由计费逻辑按缓存倍率扣减，这里不能再减去缓存命中部分
 	claudeInfo.Usage.TotalTokens = claudeInfo.Usage.PromptTokens + claudeInfo.Usage.CompletionTokens
 
 return claudeInfo.Usage, nil
@@ -451,11 +520,11 @@ return claudeInfo.Usage, nil
 func ResponsesToClaudeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
 	defer service.CloseResponseBodyGracefully(resp)
 
-	// 读取Responses API响应
+	// 读取Responses API响应，上游为流式时先聚合为非流式响应体
 	var responsesResponse dto.OpenAIResponsesResponse
-	responseBody, readErr := io.ReadAll(resp.Body)
+	responseBody, readErr := helper.ReadResponsesBody(info, resp)
 	if readErr != nil {
-		return nil, types.NewOpenAIError(readErr, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+		return nil, readErr
 	}
 
 	// 检查并清理响应体中的无效UTF-8字符
@@ -466,6 +535,14 @@ func ResponsesToClaudeHandler(c *gin.Context, resp *http.Response, info *relayco
 	// 将响应体存储到 relayInfo 中
 	info.ResponseBody = string(responseBody)
 
+	// 严格校验上游响应结构，不符合预期时隔离并原样透传，避免输出不完整的转换结果
+	if model_setting.GetResponsesSettings().StrictSchemaValidation {
+		if schemaErr := service.ValidateResponsesPayload(responseBody); schemaErr != nil {
+			service.QuarantineResponsesPayload(info.ChannelId, schemaErr.Error(), responseBody)
+			return service.PassthroughResponsesPayload(c, resp, info, responseBody), nil
+		}
+	}
+
 	unmarshalErr := common.Unmarshal(responseBody, &responsesResponse)
 	if unmarshalErr != nil {
 		return nil, types.NewOpenAIError(unmarshalErr, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
@@ -476,23 +553,10 @@ func ResponsesToClaudeHandler(c *gin.Context, resp *http.Response, info *relayco
 		return nil, types.WithOpenAIError(*oaiError, resp.StatusCode)
 	}
 
-	// 获取原始请求
-	originalRequest, exists := c.Get("original_claude_request")
-	if !exists {
-		return nil, types.NewErrorWithStatusCode(
-			fmt.Errorf("original claude request not found in context"),
-			types.ErrorCodeConvertRequestFailed,
-			http.StatusInternalServerError,
-		)
-	}
-
-	claudeRequest, ok := originalRequest.(*dto.GeneralOpenAIRequest)
-	if !ok {
-		return nil, types.NewErrorWithStatusCode(
-			fmt.Errorf("invalid original request type"),
-			types.ErrorCodeConvertRequestFailed,
-			http.StatusInternalServerError,
-		)
+	// 获取原始请求，丢失时按 RelayInfo 重建，不因此让整个请求失败
+	claudeRequest, rebuilt := info.OriginalChatRequest()
+	if rebuilt {
+		logger.LogWarn(c, fmt.Sprintf("original chat request not found (%T), rebuilt from relay info", info.OriginalRequest))
 	}
 
 	// 转换为Claude Messages格式
@@ -501,6 +565,15 @@ func ResponsesToClaudeHandler(c *gin.Context, resp *http.Response, info *relayco
 		logger.LogError(c, fmt.Sprintf("Failed to convert responses to claude format: %v", convertErr))
 		return nil, types.NewError(convertErr, types.ErrorCodeBadResponse)
 	}
+	openai_responses.MarkImageGenerationCalls(c, &responsesResponse)
+
+	// 按运营配置对回复文本做后处理
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	for i := range claudeResponse.Choices {
+		if content, ok := claudeResponse.Choices[i].Message.Content.(string); ok && content != "" {
+			claudeResponse.Choices[i].Message.Content = postProcessor.Process(content)
+		}
+	}
 
 	// 序列化Claude响应
 	jsonData, marshalErr := json.Marshal(claudeResponse)
@@ -520,101 +593,7 @@ func ResponsesToClaudeHandler(c *gin.Context, resp *http.Response, info *relayco
 	return &claudeResponse.Usage, nil
 }
 
-// ConvertResponsesStreamToClaudeStream 将Responses API流式响应转换为Claude Messages流式格式
-// 参数:
-//   - responsesStreamResp: Responses API流式响应对象
-//   - responseID: 响应ID
-//   - model: 模型名称
-// 返回:
-//   - *dto.ClaudeResponse: 转换后的Claude流式响应对象，如果是忽略的事件则返回nil
-func ConvertResponsesStreamToClaudeStream(responsesStreamResp *dto.ResponsesStreamResponse, responseID string, model string) *dto.ClaudeResponse {
-	if responsesStreamResp == nil {
-		return nil
-	}
-
-	// 根据不同的事件类型进行处理
-	switch responsesStreamResp.Type {
-	case "response.created":
-		// 响应创建事件 - 对应Claude的message_start
-		if responsesStreamResp.Response != nil {
-			claudeResp := &dto.ClaudeResponse{
-				Type: "message_start",
-				Message: &dto.ClaudeMediaMessage{
-					Type:  "message",
-					Id:    responsesStreamResp.Response.ID,
-					Model: responsesStreamResp.Response.Model,
-					Role:  "assistant",
-				},
-			}
-			// 初始化usage
-			if responsesStreamResp.Response.Usage != nil {
-				claudeResp.Usage = &dto.ClaudeUsage{
-					InputTokens:  responsesStreamResp.Response.Usage.InputTokens,
-					OutputTokens: responsesStreamResp.Response.Usage.OutputTokens,
-				}
-			}
-			return claudeResp
-		}
-
-	case "response.output_item.added":
-		// 输出项添加事件 - 对应Claude的content_block_start
-		if responsesStreamResp.Item != nil && responsesStreamResp.Item.Role == "assistant" {
-			return &dto.ClaudeResponse{
-				Type: "content_block_start",
-				Index: common.GetPointer(0),
-				ContentBlock: &dto.ClaudeMediaMessage{
-					Type: "text",
-					Text: common.GetPointer(""),
-				},
-			}
-		}
-
-	case "response.output_text.delta", "response.content_part.delta":
-		// 内容增量事件 - 对应Claude的content_block_delta
-		if responsesStreamResp.Delta != "" {
-			return &dto.ClaudeResponse{
-				Type:  "content_block_delta",
-				Index: common.GetPointer(0),
-				Delta: &dto.ClaudeMediaMessage{
-					Type: "text_delta",
-					Text: common.GetPointer(responsesStreamResp.Delta),
-				},
-			}
-		}
-
-	case "response.output_item.done":
-		// 输出项完成事件 - 对应Claude的content_block_stop
-		return &dto.ClaudeResponse{
-			Type:  "content_block_stop",
-			Index: common.GetPointer(0),
-		}
-
-case "response.done", "response.completed":
-		// 响应完成事件 - 对应Claude的message_delta和message_stop
-		if responsesStreamResp.Response != nil {
-			// 先发送message_delta包含最终usage
-			stopReason := extractFinishReasonFromResponses(responsesStreamResp.Response.Status)
-			claudeResp := &dto.ClaudeResponse{
-				Type: "message_delta",
-				Delta: &dto.ClaudeMediaMessage{
-					StopReason: &stopReason,
-				},
-			}
-			if responsesStreamResp.Response.Usage != nil {
-				claudeResp.Usage = &dto.ClaudeUsage{
-					InputTokens:  responsesStreamResp.Response.Usage.InputTokens,
-					OutputTokens: responsesStreamResp.Response.Usage.OutputTokens,
-				}
-			}
-			return claudeResp
-		}
-	}
-
-	// 忽略的事件类型返回nil
-	return nil
-}
-
-// sendClaudeStreamData 发送Claude Messages流式数据
+// sendClaudeStreamData 发送Claude Messages流式数据，每个数据帧前带 event 行
 // 参数:
 //   - c: Gin上下文
 //   - claudeResp: Claude响应对象
@@ -622,15 +601,5 @@ func sendClaudeStreamData(c *gin.Context, claudeResp *dto.ClaudeResponse) {
 	if claudeResp == nil {
 		return
 	}
-
-	jsonData, err := json.Marshal(claudeResp)
-	if err != nil {
-		logger.LogError(c, fmt.Sprintf("Failed to marshal claude stream response: %v", err))
-		return
-	}
-
-	// 构建SSE格式
-	data := fmt.Sprintf("data: %s\n\n", string(jsonData))
-	c.Writer.Write([]byte(data))
-	c.Writer.Flush()
+	_ = helper.ClaudeData(c, *claudeResp)
 }
\ No newline at end of file
@@ -0,0 +1,19 @@
+package claude
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestExtractSystemMessageFromClaude(t *testing.T) {
+	var messages []dto.Message
+	raw := `[{"role":"system","content":"first"},{"role":"user","content":"hi"},{"role":"system","content":[{"type":"text","text":"second, "},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},{"type":"text","text":"continued"}]},{"role":"system","content":""}]`
+	if err := common.UnmarshalJsonStr(raw, &messages); err != nil {
+		t.Fatalf("unmarshal messages: %v", err)
+	}
+	if got, want := extractSystemMessageFromClaude(messages), "first\n\nsecond, continued"; got != want {
+		t.Fatalf("extractSystemMessageFromClaude() = %q, want %q", got, want)
+	}
+}
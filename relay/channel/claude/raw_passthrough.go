@@ -0,0 +1,115 @@
+package claude
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+var errInvalidClaudeResponse = errors.New("invalid claude response: body is not valid json")
+
+// useRawResponsePassthrough 判断是否以原样转发模式处理原生 Claude 响应，仅适用于 Messages 接口且客户端请求 Claude 格式
+func useRawResponsePassthrough(info *relaycommon.RelayInfo, requestMode int) bool {
+	return info.RelayFormat == types.RelayFormatClaude && requestMode == RequestModeMessage &&
+		model_setting.GetClaudeSettings().RawResponsePassthrough
+}
+
+// parseRawClaudeUsage 解析 Claude usage，回退时保留缓存读写 token 数
+func parseRawClaudeUsage(usage gjson.Result) *dto.ClaudeUsage {
+	return helper.ParseRawUsage(usage, func(usage gjson.Result) *dto.ClaudeUsage {
+		return &dto.ClaudeUsage{
+			InputTokens:              int(usage.Get("input_tokens").Int()),
+			OutputTokens:             int(usage.Get("output_tokens").Int()),
+			CacheReadInputTokens:     int(usage.Get("cache_read_input_tokens").Int()),
+			CacheCreationInputTokens: int(usage.Get("cache_creation_input_tokens").Int()),
+		}
+	})
+}
+
+// rawClaudeError 读取原始响应中的错误对象
+func rawClaudeError(data gjson.Result) *types.NewAPIError {
+	errorType := data.Get("error.type").String()
+	if data.Get("type").String() != "error" && errorType == "" {
+		return nil
+	}
+	return types.WithClaudeError(types.ClaudeError{
+		Type:    errorType,
+		Message: data.Get("error.message").String(),
+	}, http.StatusInternalServerError)
+}
+
+// handleRawStreamResponseData 原样转发流式事件，只读取计费与日志所需的字段
+func handleRawStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, data string) *types.NewAPIError {
+	if !gjson.Valid(data) {
+		common.SysLog("error unmarshalling stream response: invalid json")
+		return types.NewError(errInvalidClaudeResponse, types.ErrorCodeBadResponseBody)
+	}
+	event := gjson.Parse(data)
+	if apiErr := rawClaudeError(event); apiErr != nil {
+		return apiErr
+	}
+	eventType := event.Get("type").String()
+	switch eventType {
+	case "message_start":
+		claudeInfo.ResponseId = event.Get("message.id").String()
+		claudeInfo.Model = event.Get("message.model").String()
+		if claudeInfo.Model != "" {
+			info.UpstreamModelName = claudeInfo.Model
+		}
+		usage := parseRawClaudeUsage(event.Get("message.usage"))
+		claudeInfo.Usage.PromptTokens = usage.InputTokens
+		claudeInfo.Usage.PromptTokensDetails.CachedTokens = usage.CacheReadInputTokens
+		claudeInfo.Usage.PromptTokensDetails.CachedCreationTokens = usage.CacheCreationInputTokens
+		claudeInfo.Usage.ClaudeCacheCreation5mTokens = usage.GetCacheCreation5mTokens()
+		claudeInfo.Usage.ClaudeCacheCreation1hTokens = usage.GetCacheCreation1hTokens()
+		claudeInfo.Usage.CompletionTokens = usage.OutputTokens
+	case "content_block_delta":
+		claudeInfo.ResponseText.WriteString(event.Get("delta.text").String())
+		claudeInfo.ResponseText.WriteString(event.Get("delta.thinking").String())
+	case "message_delta":
+		usage := parseRawClaudeUsage(event.Get("usage"))
+		if usage.InputTokens > 0 {
+			// 不叠加，只取最新的
+			claudeInfo.Usage.PromptTokens = usage.InputTokens
+		}
+		claudeInfo.Usage.CompletionTokens = usage.OutputTokens
+		claudeInfo.Usage.TotalTokens = claudeInfo.Usage.PromptTokens + claudeInfo.Usage.CompletionTokens
+		claudeInfo.Done = true
+	}
+	helper.ClaudeChunkData(c, dto.ClaudeResponse{Type: eventType}, data)
+	return nil
+}
+
+// handleRawClaudeResponseData 原样转发非流式响应，只读取计费所需的字段
+func handleRawClaudeResponseData(c *gin.Context, claudeInfo *ClaudeResponseInfo, httpResp *http.Response, data []byte) *types.NewAPIError {
+	if !gjson.ValidBytes(data) {
+		return types.NewError(errInvalidClaudeResponse, types.ErrorCodeBadResponseBody)
+	}
+	response := gjson.ParseBytes(data)
+	if apiErr := rawClaudeError(response); apiErr != nil {
+		return apiErr
+	}
+	usage := parseRawClaudeUsage(response.Get("usage"))
+	claudeInfo.Usage.PromptTokens = usage.InputTokens
+	claudeInfo.Usage.CompletionTokens = usage.OutputTokens
+	claudeInfo.Usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	claudeInfo.Usage.PromptTokensDetails.CachedTokens = usage.CacheReadInputTokens
+	claudeInfo.Usage.PromptTokensDetails.CachedCreationTokens = usage.CacheCreationInputTokens
+	claudeInfo.Usage.ClaudeCacheCreation5mTokens = usage.GetCacheCreation5mTokens()
+	claudeInfo.Usage.ClaudeCacheCreation1hTokens = usage.GetCacheCreation1hTokens()
+	if usage.ServerToolUse != nil && usage.ServerToolUse.WebSearchRequests > 0 {
+		c.Set("claude_web_search_requests", usage.ServerToolUse.WebSearchRequests)
+	}
+	service.IOCopyBytesGracefully(c, httpResp, data)
+	return nil
+}
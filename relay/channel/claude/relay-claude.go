@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/relay/channel/openrouter"
@@ -18,6 +19,7 @@ import (
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 )
 
 const (
@@ -633,6 +635,9 @@ func FormatClaudeResponseInfo(requestMode int, claudeResponse *dto.ClaudeRespons
 }
 
 func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, data string, requestMode int) *types.NewAPIError {
+	if useRawResponsePassthrough(info, requestMode) {
+		return handleRawStreamResponseData(c, info, claudeInfo, data)
+	}
 	var claudeResponse dto.ClaudeResponse
 	err := common.UnmarshalJsonStr(data, &claudeResponse)
 	if err != nil {
@@ -710,14 +715,25 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 	}
 	
 	// 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewStreamBodyCapture()
 	
+	// 网关输出 token 上限，仅 Messages 模式统计
+	var outputBudget *service.OutputTokenBudget
+	if requestMode == RequestModeMessage {
+		outputBudget = service.NewOutputTokenBudget(info)
+	}
+
 	var err *types.NewAPIError
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		// 累积完整响应体用于日志记录（不影响转发逻辑）
 		if len(data) > 0 {
-			fullStreamResponse.WriteString(data)
-			fullStreamResponse.WriteString("\n")
+			fullStreamResponse.Append(data)
+		}
+
+		// 超出输出上限时丢弃当前块，以 max_tokens 结束并终止流
+		if index, text, ok := claudeStreamDeltaText(data); ok && !outputBudget.Consume(text) {
+			finishClaudeStreamOnOutputBudget(c, info, claudeInfo, index)
+			return false
 		}
 		
 		err = HandleStreamResponseData(c, info, claudeInfo, data, requestMode)
@@ -737,7 +753,43 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 	return claudeInfo.Usage, nil
 }
 
+// claudeStreamDeltaText 获取 content_block_delta 事件中的输出文本，包括思考内容
+// 返回:
+//   - int: 内容块序号
+//   - string: 输出文本
+//   - bool: 是否为 content_block_delta 事件
+func claudeStreamDeltaText(data string) (int, string, bool) {
+	if gjson.Get(data, "type").String() != "content_block_delta" {
+		return 0, "", false
+	}
+	delta := gjson.Get(data, "delta")
+	return int(gjson.Get(data, "index").Int()), delta.Get("text").String() + delta.Get("thinking").String(), true
+}
+
+// finishClaudeStreamOnOutputBudget 超出网关输出上限时按客户端格式结束流
+// Claude 格式依次发送 content_block_stop、stop_reason 为 max_tokens 的 message_delta 与 message_stop，
+// OpenAI 格式发送 finish_reason 为 length 的结束块，用量块与 [DONE] 由 HandleStreamFinalResponse 发送
+func finishClaudeStreamOnOutputBudget(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, index int) {
+	if info.RelayFormat == types.RelayFormatClaude {
+		stopReason := "max_tokens"
+		sendClaudeStreamData(c, &dto.ClaudeResponse{Type: "content_block_stop", Index: common.GetPointer(index)})
+		sendClaudeStreamData(c, &dto.ClaudeResponse{
+			Type:  "message_delta",
+			Delta: &dto.ClaudeMediaMessage{StopReason: &stopReason},
+		})
+		sendClaudeStreamData(c, &dto.ClaudeResponse{Type: "message_stop"})
+		return
+	}
+	stopResponse := helper.GenerateStopResponse(claudeInfo.ResponseId, claudeInfo.Created, info.UpstreamModelName, constant.FinishReasonLength)
+	if err := helper.ObjectData(c, stopResponse); err != nil {
+		logger.LogError(c, "send_stream_response_failed: "+err.Error())
+	}
+}
+
 func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, httpResp *http.Response, data []byte, requestMode int) *types.NewAPIError {
+	if useRawResponsePassthrough(info, requestMode) {
+		return handleRawClaudeResponseData(c, claudeInfo, httpResp, data)
+	}
 	var claudeResponse dto.ClaudeResponse
 	err := common.Unmarshal(data, &claudeResponse)
 	if err != nil {
@@ -809,50 +861,11 @@ func ClaudeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayI
 	return claudeInfo.Usage, nil
 }
 
+// mapToolChoice 将 Chat Completions 的 tool_choice 与 parallel_tool_calls 转换为 Claude 的 tool_choice，无法识别的取值会被忽略
 func mapToolChoice(toolChoice any, parallelToolCalls *bool) *dto.ClaudeToolChoice {
-	var claudeToolChoice *dto.ClaudeToolChoice
-
-	// 处理 tool_choice 字符串值
-	if toolChoiceStr, ok := toolChoice.(string); ok {
-		switch toolChoiceStr {
-		case "auto":
-			claudeToolChoice = &dto.ClaudeToolChoice{
-				Type: "auto",
-			}
-		case "required":
-			claudeToolChoice = &dto.ClaudeToolChoice{
-				Type: "any",
-			}
-		case "none":
-			claudeToolChoice = &dto.ClaudeToolChoice{
-				Type: "none",
-			}
-		}
-	} else if toolChoiceMap, ok := toolChoice.(map[string]interface{}); ok {
-		// 处理 tool_choice 对象值
-		if function, ok := toolChoiceMap["function"].(map[string]interface{}); ok {
-			if toolName, ok := function["name"].(string); ok {
-				claudeToolChoice = &dto.ClaudeToolChoice{
-					Type: "tool",
-					Name: toolName,
-				}
-			}
-		}
-	}
-
-	// 处理 parallel_tool_calls
-	if parallelToolCalls != nil {
-		if claudeToolChoice == nil {
-			// 如果没有 tool_choice，但有 parallel_tool_calls，创建默认的 auto 类型
-			claudeToolChoice = &dto.ClaudeToolChoice{
-				Type: "auto",
-			}
-		}
-
-		// 设置 disable_parallel_tool_use
-		// 如果 parallel_tool_calls 为 true，则 disable_parallel_tool_use 为 false
-		claudeToolChoice.DisableParallelToolUse = !*parallelToolCalls
+	claudeToolChoice, err := service.ResponsesToolChoiceToClaude(toolChoice, parallelToolCalls)
+	if err != nil {
+		claudeToolChoice, _ = service.ResponsesToolChoiceToClaude(nil, parallelToolCalls)
 	}
-
 	return claudeToolChoice
 }
@@ -0,0 +1,450 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Claude thinking 的最小 budget_tokens
+const minThinkingBudgetTokens = 1024
+
+// responsesContentPart Responses 输入消息与函数调用结果中的内容项
+type responsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Refusal  string `json:"refusal"`
+	ImageUrl string `json:"image_url"`
+	FileId   string `json:"file_id"`
+	FileData string `json:"file_data"`
+	FileUrl  string `json:"file_url"`
+	Filename string `json:"filename"`
+}
+
+// ResponsesToClaudeRequest 将原生 Responses 请求转换为 Claude Messages 请求，使 Responses 客户端可以使用 Claude 渠道
+// instructions 与 system、developer 消息合并为 system，函数调用与调用结果转换为 tool_use 与 tool_result 块，
+// reasoning.effort 转换为 thinking 的 budget_tokens。上游会话状态、结构化输出等 Claude 无法实现的参数返回 400 错误
+// 参数:
+//   - c: Gin 上下文
+//   - info: 请求信息
+//   - request: 模型映射后的 Responses 请求
+//
+// 返回:
+//   - *dto.ClaudeRequest: 转换后的 Claude 请求
+//   - error: 请求包含无法转换的参数时返回转换错误
+func ResponsesToClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) (*dto.ClaudeRequest, error) {
+	if request.PreviousResponseID != "" {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "previous_response_id: stored conversation state is not supported on Claude channels, send the full conversation in input")
+	}
+	if len(request.Prompt) > 0 && string(request.Prompt) != "null" {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "prompt: prompt templates are not supported on Claude channels")
+	}
+	if err := checkResponsesTextFormat(request.Text); err != nil {
+		return nil, err
+	}
+	// 按上游限制提前校验工具定义，避免上游返回不指明工具的 400
+	if err := service.ValidateToolSchemaLimits(request.GetToolsMap()); err != nil {
+		return nil, err
+	}
+
+	claudeRequest := &dto.ClaudeRequest{
+		Model:     request.Model,
+		MaxTokens: request.MaxOutputTokens,
+		TopP:      request.TopP,
+		Stream:    request.Stream,
+	}
+	if request.Temperature != 0 {
+		claudeRequest.Temperature = common.GetPointer[float64](request.Temperature)
+	}
+	if claudeRequest.MaxTokens == 0 {
+		claudeRequest.MaxTokens = uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(request.Model))
+	}
+	if request.User != "" {
+		metadata, err := common.Marshal(dto.ClaudeMetadata{UserId: request.User})
+		if err == nil {
+			claudeRequest.Metadata = metadata
+		}
+	}
+
+	// instructions 与 system、developer 消息合并为 system
+	var system []dto.ClaudeMediaMessage
+	if len(request.Instructions) > 0 && string(request.Instructions) != "null" {
+		var instructions string
+		if err := common.Unmarshal(request.Instructions, &instructions); err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "instructions must be a string: %s", err.Error())
+		}
+		if instructions != "" {
+			system = append(system, dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer[string](instructions)})
+		}
+	}
+	messages, systemBlocks, err := responsesInputToClaudeMessages(request.Input)
+	if err != nil {
+		return nil, err
+	}
+	system = append(system, systemBlocks...)
+	if len(system) > 0 {
+		claudeRequest.System = system
+	}
+	claudeRequest.Messages = messages
+
+	if len(request.Tools) > 0 && string(request.Tools) != "null" {
+		tools, err := responsesToolsToClaude(request.Tools)
+		if err != nil {
+			return nil, err
+		}
+		if len(tools) > 0 {
+			claudeRequest.Tools = tools
+		}
+	}
+
+	if len(request.ToolChoice) > 0 || len(request.ParallelToolCalls) > 0 {
+		var toolChoice any
+		if len(request.ToolChoice) > 0 {
+			if err := common.Unmarshal(request.ToolChoice, &toolChoice); err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "invalid tool_choice: %s", err.Error())
+			}
+		}
+		var parallelToolCalls *bool
+		if len(request.ParallelToolCalls) > 0 {
+			_ = common.Unmarshal(request.ParallelToolCalls, &parallelToolCalls)
+		}
+		claudeToolChoice, apiErr := service.ResponsesToolChoiceToClaude(toolChoice, parallelToolCalls)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if claudeToolChoice != nil {
+			claudeRequest.ToolChoice = claudeToolChoice
+		}
+	}
+
+	// reasoning.effort 转换为 thinking，budget_tokens 必须小于 max_tokens
+	if request.Reasoning != nil {
+		if budget := reasoningEffortToThinkingBudget(request.Reasoning.Effort); budget > 0 {
+			budget = min(budget, int(claudeRequest.MaxTokens)-1)
+			if lastToolUseMissingThinking(claudeRequest.Messages) {
+				logger.LogWarn(c, fmt.Sprintf("reasoning.effort %s dropped: the last tool call in input has no reasoning item with encrypted_content", request.Reasoning.Effort))
+			} else if budget >= minThinkingBudgetTokens {
+				claudeRequest.Thinking = &dto.Thinking{Type: "enabled", BudgetTokens: common.GetPointer[int](budget)}
+				// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#important-considerations-when-using-extended-thinking
+				claudeRequest.TopP = 0
+				claudeRequest.Temperature = common.GetPointer[float64](1.0)
+			} else {
+				logger.LogWarn(c, fmt.Sprintf("reasoning.effort %s dropped: max_output_tokens %d leaves no room for the minimum thinking budget", request.Reasoning.Effort, claudeRequest.MaxTokens))
+			}
+		}
+	}
+
+	return claudeRequest, nil
+}
+
+// reasoningEffortToThinkingBudget 按 Responses 的推理强度选择 Claude thinking 的 budget_tokens，与 Chat 请求的 reasoning_effort 取值一致
+func reasoningEffortToThinkingBudget(effort string) int {
+	switch effort {
+	case "low":
+		return 1280
+	case "medium":
+		return 2048
+	case "high":
+		return 4096
+	}
+	return 0
+}
+
+// checkResponsesTextFormat 检查 text.format，Claude 不支持结构化输出
+func checkResponsesTextFormat(text json.RawMessage) error {
+	if len(text) == 0 {
+		return nil
+	}
+	var textConfig struct {
+		Format struct {
+			Type string `json:"type"`
+		} `json:"format"`
+	}
+	if err := common.Unmarshal(text, &textConfig); err != nil {
+		return types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "invalid text: %s", err.Error())
+	}
+	switch textConfig.Format.Type {
+	case "", "text":
+		return nil
+	}
+	return types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "text.format: %s is not supported on Claude channels", textConfig.Format.Type)
+}
+
+// responsesInputToClaudeMessages 将 Responses 的 input 转换为 Claude 消息，相邻的同角色输入项合并为一条消息
+// 返回:
+//   - []dto.ClaudeMessage: 转换后的消息
+//   - []dto.ClaudeMediaMessage: system 与 developer 消息的文本块
+//   - error: 输入项无法转换时返回转换错误
+func responsesInputToClaudeMessages(input json.RawMessage) ([]dto.ClaudeMessage, []dto.ClaudeMediaMessage, error) {
+	if len(input) == 0 || string(input) == "null" {
+		return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "input is required")
+	}
+	if common.GetJsonType(input) == "string" {
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "invalid input: %s", err.Error())
+		}
+		return []dto.ClaudeMessage{{Role: "user", Content: text}}, nil, nil
+	}
+
+	var items []dto.Input
+	if err := common.Unmarshal(input, &items); err != nil {
+		return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "invalid input: %s", err.Error())
+	}
+	var messages []dto.ClaudeMessage
+	var system []dto.ClaudeMediaMessage
+	for i, item := range items {
+		switch item.Type {
+		case "", "message":
+			blocks, err := responsesContentToClaudeBlocks(item.Content)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch item.Role {
+			case "system", "developer":
+				system = append(system, blocks...)
+			case "user", "assistant":
+				messages = appendClaudeBlocks(messages, item.Role, blocks...)
+			default:
+				return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "input[%d].role: unsupported role %s", i, item.Role)
+			}
+		case "function_call":
+			arguments := map[string]any{}
+			if strings.TrimSpace(item.Arguments) != "" {
+				if err := common.UnmarshalJsonStr(item.Arguments, &arguments); err != nil {
+					return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "input[%d].arguments must be a JSON object: %s", i, err.Error())
+				}
+			}
+			messages = appendClaudeBlocks(messages, "assistant", dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    item.CallId,
+				Name:  item.Name,
+				Input: arguments,
+			})
+		case "function_call_output":
+			content, err := responsesToolOutputToClaude(item.Output)
+			if err != nil {
+				return nil, nil, err
+			}
+			messages = appendClaudeBlocks(messages, "user", dto.ClaudeMediaMessage{
+				Type:      "tool_result",
+				ToolUseId: item.CallId,
+				Content:   content,
+			})
+		case "reasoning":
+			// 只有带签名的推理项可以还原为 thinking 块，其他来源的推理项没有 Claude 对应的输入格式，转发时丢弃
+			if item.EncryptedContent == "" {
+				continue
+			}
+			messages = appendClaudeBlocks(messages, "assistant", dto.ClaudeMediaMessage{
+				Type:      "thinking",
+				Thinking:  common.GetPointer[string](responsesReasoningSummaryText(item.Summary)),
+				Signature: item.EncryptedContent,
+			})
+		case "web_search_call":
+			// 历史中的网页搜索项没有 Claude 对应的输入格式，转发时丢弃
+		default:
+			return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "input[%d].type: %s is not supported on Claude channels", i, item.Type)
+		}
+	}
+	if len(messages) == 0 {
+		return nil, nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "input must contain at least one user or assistant message")
+	}
+	return messages, system, nil
+}
+
+// responsesReasoningSummaryText 拼接推理项的摘要文本
+func responsesReasoningSummaryText(summary json.RawMessage) string {
+	var parts []responsesContentPart
+	if len(summary) == 0 || common.Unmarshal(summary, &parts) != nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// lastToolUseMissingThinking 最后一条 assistant 消息包含 tool_use 但不以 thinking 块开头时返回 true
+// 开启 thinking 时 Claude 要求继续工具调用的 assistant 消息以带签名的 thinking 块开头，历史中缺少签名时无法满足
+func lastToolUseMissingThinking(messages []dto.ClaudeMessage) bool {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "assistant" {
+			continue
+		}
+		blocks, ok := messages[i].Content.([]dto.ClaudeMediaMessage)
+		if !ok || len(blocks) == 0 {
+			return false
+		}
+		hasToolUse := false
+		for _, block := range blocks {
+			if block.Type == "tool_use" {
+				hasToolUse = true
+				break
+			}
+		}
+		return hasToolUse && blocks[0].Type != "thinking"
+	}
+	return false
+}
+
+// appendClaudeBlocks 追加内容块，与上一条消息角色相同时合并到上一条消息
+func appendClaudeBlocks(messages []dto.ClaudeMessage, role string, blocks ...dto.ClaudeMediaMessage) []dto.ClaudeMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		if existing, ok := messages[n-1].Content.([]dto.ClaudeMediaMessage); ok {
+			messages[n-1].Content = append(existing, blocks...)
+			return messages
+		}
+	}
+	return append(messages, dto.ClaudeMessage{Role: role, Content: blocks})
+}
+
+// responsesContentToClaudeBlocks 将消息内容转换为 Claude 内容块，空文本会被 Claude 拒绝，转换时丢弃
+func responsesContentToClaudeBlocks(content json.RawMessage) ([]dto.ClaudeMediaMessage, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+	if common.GetJsonType(content) == "string" {
+		var text string
+		if err := common.Unmarshal(content, &text); err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "invalid message content: %s", err.Error())
+		}
+		if text == "" {
+			return nil, nil
+		}
+		return []dto.ClaudeMediaMessage{{Type: "text", Text: common.GetPointer[string](text)}}, nil
+	}
+
+	var parts []responsesContentPart
+	if err := common.Unmarshal(content, &parts); err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "invalid message content: %s", err.Error())
+	}
+	blocks := make([]dto.ClaudeMediaMessage, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text":
+			if part.Text != "" {
+				blocks = append(blocks, dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer[string](part.Text)})
+			}
+		case "refusal":
+			if part.Refusal != "" {
+				blocks = append(blocks, dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer[string](part.Refusal)})
+			}
+		case "input_image":
+			if part.ImageUrl == "" {
+				return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "input_image: file_id is not supported on Claude channels, use image_url")
+			}
+			source, err := claudeMessageSource(part.ImageUrl)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, dto.ClaudeMediaMessage{Type: "image", Source: source})
+		case "input_file":
+			fileUrl := part.FileData
+			if fileUrl == "" {
+				fileUrl = part.FileUrl
+			}
+			if fileUrl == "" {
+				return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "input_file: file_id is not supported on Claude channels, use file_data or file_url")
+			}
+			source, err := claudeMessageSource(fileUrl)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, dto.ClaudeMediaMessage{Type: "document", Source: source})
+		default:
+			return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "content type %s is not supported on Claude channels", part.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// claudeMessageSource 将图片或文件地址转换为 Claude 的 source，HTTP 地址由 Claude 直接下载，data URI 转换为 base64
+func claudeMessageSource(url string) (*dto.ClaudeMessageSource, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return &dto.ClaudeMessageSource{Type: "url", Url: url}, nil
+	}
+	mimeType, data, err := service.DecodeBase64FileData(url)
+	if err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "invalid base64 data: %s", err.Error())
+	}
+	return &dto.ClaudeMessageSource{Type: "base64", MediaType: mimeType, Data: data}, nil
+}
+
+// responsesToolOutputToClaude 将函数调用结果转换为 tool_result 的 content，文本结果保持字符串
+func responsesToolOutputToClaude(output json.RawMessage) (any, error) {
+	if len(output) == 0 || string(output) == "null" {
+		return "", nil
+	}
+	if common.GetJsonType(output) == "string" {
+		var text string
+		if err := common.Unmarshal(output, &text); err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "invalid function_call_output: %s", err.Error())
+		}
+		return text, nil
+	}
+	return responsesContentToClaudeBlocks(output)
+}
+
+// responsesToolsToClaude 将 Responses 的工具转换为 Claude 工具，网页搜索转换为 Claude 的服务端网页搜索工具
+func responsesToolsToClaude(toolsData json.RawMessage) ([]any, error) {
+	var tools []map[string]any
+	if err := common.Unmarshal(toolsData, &tools); err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "invalid tools: %s", err.Error())
+	}
+	claudeTools := make([]any, 0, len(tools))
+	for i, tool := range tools {
+		toolType, _ := tool["type"].(string)
+		switch {
+		case toolType == "function":
+			name, _ := tool["name"].(string)
+			if name == "" {
+				return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tools[%d].name is required", i)
+			}
+			claudeTool := &dto.Tool{Name: name}
+			claudeTool.Description, _ = tool["description"].(string)
+			claudeTool.InputSchema, _ = tool["parameters"].(map[string]any)
+			if claudeTool.InputSchema == nil {
+				claudeTool.InputSchema = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			claudeTools = append(claudeTools, claudeTool)
+		case strings.HasPrefix(toolType, "web_search"):
+			webSearchTool := &dto.ClaudeWebSearchTool{Type: "web_search_20250305", Name: "web_search"}
+			switch tool["search_context_size"] {
+			case "low":
+				webSearchTool.MaxUses = WebSearchMaxUsesLow
+			case "medium":
+				webSearchTool.MaxUses = WebSearchMaxUsesMedium
+			case "high":
+				webSearchTool.MaxUses = WebSearchMaxUsesHigh
+			}
+			if location, ok := tool["user_location"].(map[string]any); ok {
+				userLocation := &dto.ClaudeWebSearchUserLocation{Type: "approximate"}
+				userLocation.City, _ = location["city"].(string)
+				userLocation.Country, _ = location["country"].(string)
+				userLocation.Region, _ = location["region"].(string)
+				userLocation.Timezone, _ = location["timezone"].(string)
+				webSearchTool.UserLocation = userLocation
+			}
+			claudeTools = append(claudeTools, webSearchTool)
+		default:
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolUnsupported, http.StatusBadRequest, "tools[%d]: %s tool is not supported on Claude channels", i, toolType)
+		}
+	}
+	return claudeTools, nil
+}
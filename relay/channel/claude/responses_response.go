@@ -0,0 +1,449 @@
+package claude
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeToResponsesHandler 将 Claude Messages 非流式响应转换为 Responses 响应
+// 参数:
+//   - c: Gin 上下文
+//   - resp: 上游响应
+//   - info: 请求信息
+//
+// 返回:
+//   - *dto.Usage: 计费用量，提示词用量包含缓存读写的 token
+//   - *types.NewAPIError: 上游返回错误或响应无法解析时返回错误
+func ClaudeToResponsesHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.Usage, *types.NewAPIError) {
+	defer service.CloseResponseBodyGracefully(resp)
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	info.ResponseBody = string(responseBody)
+
+	var claudeResponse dto.ClaudeResponse
+	if err := common.Unmarshal(responseBody, &claudeResponse); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	if claudeError := claudeResponse.GetClaudeError(); claudeError != nil && claudeError.Type != "" {
+		return nil, types.WithClaudeError(*claudeError, http.StatusInternalServerError)
+	}
+
+	usage := claudeUsageToResponsesUsage(claudeResponse.Usage)
+	if claudeResponse.Usage != nil && claudeResponse.Usage.ServerToolUse != nil && claudeResponse.Usage.ServerToolUse.WebSearchRequests > 0 {
+		c.Set("claude_web_search_requests", claudeResponse.Usage.ServerToolUse.WebSearchRequests)
+	}
+
+	response := ClaudeToResponsesResponse(&claudeResponse, info)
+	response.Usage = usage
+	responseData, err := common.Marshal(response)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	service.IOCopyBytesGracefully(c, resp, responseData)
+	return usage, nil
+}
+
+// ClaudeToResponsesResponse 将 Claude Messages 响应的内容块转换为 Responses 输出项
+// thinking 转换为推理摘要，签名放在 encrypted_content 中供客户端回传，text 转换为助手消息，tool_use 转换为函数调用，服务端网页搜索转换为 web_search_call
+func ClaudeToResponsesResponse(claudeResponse *dto.ClaudeResponse, info *relaycommon.RelayInfo) *dto.OpenAIResponsesResponse {
+	response := newClaudeResponsesResponse(claudeResponse.Id, claudeResponse.Model, info)
+	baseId := claudeResponseBaseId(claudeResponse.Id)
+	for i := range claudeResponse.Content {
+		block := &claudeResponse.Content[i]
+		item := newClaudeResponsesOutputItem(block, baseId, i)
+		if item == nil {
+			continue
+		}
+		item.Status = "completed"
+		switch item.Type {
+		case dto.ResponsesOutputTypeMessage:
+			item.Content = []dto.ResponsesOutputContent{{Type: "output_text", Text: block.GetText(), Annotations: []interface{}{}}}
+		case dto.ResponsesOutputTypeReasoning:
+			item.Summary = []dto.ResponsesOutputContent{{Type: "summary_text", Text: claudeThinkingText(block)}}
+			item.EncryptedContent = block.Signature
+		case dto.ResponsesOutputTypeFunctionCall:
+			item.Arguments = "{}"
+			if block.Input != nil {
+				if arguments, err := common.Marshal(block.Input); err == nil {
+					item.Arguments = string(arguments)
+				}
+			}
+		}
+		response.Output = append(response.Output, *item)
+	}
+	setClaudeResponsesStatus(response, claudeResponse.StopReason)
+	return response
+}
+
+// newClaudeResponsesResponse 创建 Responses 响应，响应 ID 由 Claude 消息 ID 转换得到
+func newClaudeResponsesResponse(messageId string, model string, info *relaycommon.RelayInfo) *dto.OpenAIResponsesResponse {
+	if model == "" {
+		model = info.UpstreamModelName
+	}
+	return &dto.OpenAIResponsesResponse{
+		ID:        "resp_" + claudeResponseBaseId(messageId),
+		Object:    "response",
+		CreatedAt: int(info.StartTime.Unix()),
+		Status:    "in_progress",
+		Model:     model,
+		Output:    []dto.ResponsesOutput{},
+	}
+}
+
+// newClaudeResponsesOutputItem 为 Claude 内容块创建对应的 Responses 输出项，没有对应输出项的内容块返回 nil
+func newClaudeResponsesOutputItem(block *dto.ClaudeMediaMessage, baseId string, index int) *dto.ResponsesOutput {
+	switch block.Type {
+	case "text":
+		return &dto.ResponsesOutput{Type: dto.ResponsesOutputTypeMessage, ID: fmt.Sprintf("msg_%s_%d", baseId, index), Status: "in_progress", Role: "assistant", Content: []dto.ResponsesOutputContent{}}
+	case "thinking":
+		return &dto.ResponsesOutput{Type: dto.ResponsesOutputTypeReasoning, ID: fmt.Sprintf("rs_%s_%d", baseId, index), Summary: []dto.ResponsesOutputContent{}}
+	case "tool_use":
+		return &dto.ResponsesOutput{Type: dto.ResponsesOutputTypeFunctionCall, ID: fmt.Sprintf("fc_%s_%d", baseId, index), Status: "in_progress", CallId: block.Id, Name: block.Name}
+	case "server_tool_use":
+		if block.Name == "web_search" {
+			return &dto.ResponsesOutput{Type: dto.BuildInCallWebSearchCall, ID: fmt.Sprintf("ws_%s_%d", baseId, index), Status: "in_progress"}
+		}
+	}
+	return nil
+}
+
+// setClaudeResponsesStatus 按 Claude 的停止原因设置响应状态，达到 max_tokens 时响应未完成
+func setClaudeResponsesStatus(response *dto.OpenAIResponsesResponse, stopReason string) {
+	if stopReason == "max_tokens" {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+		return
+	}
+	response.Status = "completed"
+}
+
+// claudeUsageToResponsesUsage 转换 Claude 用量，Responses 的 input_tokens 与计费的提示词用量都包含缓存读写的 token
+func claudeUsageToResponsesUsage(claudeUsage *dto.ClaudeUsage) *dto.Usage {
+	usage := &dto.Usage{}
+	if claudeUsage == nil {
+		return usage
+	}
+	promptTokens := claudeUsage.InputTokens + claudeUsage.CacheReadInputTokens + claudeUsage.CacheCreationInputTokens
+	usage.PromptTokens = promptTokens
+	usage.CompletionTokens = claudeUsage.OutputTokens
+	usage.TotalTokens = promptTokens + claudeUsage.OutputTokens
+	usage.PromptTokensDetails.CachedTokens = claudeUsage.CacheReadInputTokens
+	usage.PromptTokensDetails.CachedCreationTokens = claudeUsage.CacheCreationInputTokens
+	usage.ClaudeCacheCreation5mTokens = claudeUsage.GetCacheCreation5mTokens()
+	usage.ClaudeCacheCreation1hTokens = claudeUsage.GetCacheCreation1hTokens()
+	usage.InputTokens = promptTokens
+	usage.OutputTokens = claudeUsage.OutputTokens
+	usage.InputTokensDetails = &dto.InputTokenDetails{CachedTokens: claudeUsage.CacheReadInputTokens}
+	return usage
+}
+
+func claudeResponseBaseId(messageId string) string {
+	return strings.TrimPrefix(messageId, "msg_")
+}
+
+func claudeThinkingText(block *dto.ClaudeMediaMessage) string {
+	if block.Thinking == nil {
+		return ""
+	}
+	return *block.Thinking
+}
+
+// claudeResponsesStreamBlock 流式转换中 Claude 内容块对应的输出项
+type claudeResponsesStreamBlock struct {
+	outputIndex int
+	item        *dto.ResponsesOutput
+	text        strings.Builder
+}
+
+// claudeResponsesStreamConverter 将 Claude Messages 流事件转换为 Responses 流事件
+type claudeResponsesStreamConverter struct {
+	c            *gin.Context
+	info         *relaycommon.RelayInfo
+	response     *dto.OpenAIResponsesResponse
+	claudeUsage  dto.ClaudeUsage
+	baseId       string
+	items        []*dto.ResponsesOutput
+	blocks       map[int]*claudeResponsesStreamBlock
+	stopReason   string
+	responseText strings.Builder
+	done         bool
+}
+
+// ClaudeToResponsesStreamHandler 将 Claude Messages 流式响应转换为 Responses 流式响应
+// 参数:
+//   - c: Gin 上下文
+//   - resp: 上游响应
+//   - info: 请求信息
+//
+// 返回:
+//   - *dto.Usage: 计费用量，上游未返回完整用量时按输出文本估算
+//   - *types.NewAPIError: 上游返回错误事件时返回错误
+func ClaudeToResponsesStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.Usage, *types.NewAPIError) {
+	converter := &claudeResponsesStreamConverter{
+		c:      c,
+		info:   info,
+		blocks: make(map[int]*claudeResponsesStreamBlock),
+	}
+	fullStreamResponse := service.NewStreamBodyCapture()
+
+	var apiErr *types.NewAPIError
+	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+		fullStreamResponse.Append(data)
+
+		var claudeResponse dto.ClaudeResponse
+		if err := common.UnmarshalJsonStr(data, &claudeResponse); err != nil {
+			logger.LogError(c, "error unmarshalling stream response: "+err.Error())
+			return true
+		}
+		if claudeError := claudeResponse.GetClaudeError(); claudeError != nil && claudeError.Type != "" {
+			apiErr = types.WithClaudeError(*claudeError, http.StatusInternalServerError)
+			return false
+		}
+		converter.HandleEvent(&claudeResponse)
+		return !converter.done
+	})
+	info.ResponseBody = fullStreamResponse.String()
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// 上游未发送 message_stop 时仍补发终止事件，避免客户端等待
+	if !converter.done {
+		converter.Finish()
+	}
+
+	usage := claudeUsageToResponsesUsage(&converter.claudeUsage)
+	if usage.CompletionTokens == 0 || converter.stopReason == "" {
+		usage = service.ResponseText2Usage(c, converter.responseText.String(), info.UpstreamModelName, usage.PromptTokens)
+	}
+	if converter.claudeUsage.ServerToolUse != nil && converter.claudeUsage.ServerToolUse.WebSearchRequests > 0 {
+		c.Set("claude_web_search_requests", converter.claudeUsage.ServerToolUse.WebSearchRequests)
+	}
+	return usage, nil
+}
+
+// HandleEvent 处理一个 Claude 流事件并发送对应的 Responses 流事件
+func (s *claudeResponsesStreamConverter) HandleEvent(event *dto.ClaudeResponse) {
+	switch event.Type {
+	case "message_start":
+		s.start(event.Message)
+	case "content_block_start":
+		if event.Index != nil && event.ContentBlock != nil {
+			s.startBlock(*event.Index, event.ContentBlock)
+		}
+	case "content_block_delta":
+		if event.Index != nil && event.Delta != nil {
+			s.deltaBlock(*event.Index, event.Delta)
+		}
+	case "content_block_stop":
+		if event.Index != nil {
+			s.stopBlock(*event.Index)
+		}
+	case "message_delta":
+		if event.Delta != nil && event.Delta.StopReason != nil {
+			s.stopReason = *event.Delta.StopReason
+		}
+		if event.Usage != nil {
+			s.mergeUsage(event.Usage)
+		}
+	case "message_stop":
+		s.Finish()
+	}
+}
+
+// Finish 结束仍未结束的输出项，发送 response.completed 或 response.incomplete
+func (s *claudeResponsesStreamConverter) Finish() {
+	if s.done {
+		return
+	}
+	s.done = true
+	if s.response == nil {
+		s.start(nil)
+	}
+	// 按内容块顺序结束未结束的输出项
+	indexes := make([]int, 0, len(s.blocks))
+	for index := range s.blocks {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		s.stopBlock(index)
+	}
+	for _, item := range s.items {
+		s.response.Output = append(s.response.Output, *item)
+	}
+	if s.stopReason == "" {
+		// 上游流中途结束
+		s.response.Status = "incomplete"
+	} else {
+		setClaudeResponsesStatus(s.response, s.stopReason)
+	}
+	s.response.Usage = claudeUsageToResponsesUsage(&s.claudeUsage)
+
+	eventType := "response.completed"
+	if s.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	s.send(dto.ResponsesStreamResponse{Type: eventType, Response: s.response})
+}
+
+func (s *claudeResponsesStreamConverter) start(message *dto.ClaudeMediaMessage) {
+	messageId := helper.GetResponseID(s.c)
+	model := ""
+	if message != nil {
+		if message.Id != "" {
+			messageId = message.Id
+		}
+		model = message.Model
+		if message.Usage != nil {
+			s.mergeUsage(message.Usage)
+		}
+	}
+	s.baseId = claudeResponseBaseId(messageId)
+	s.response = newClaudeResponsesResponse(messageId, model, s.info)
+
+	snapshot := *s.response
+	s.send(dto.ResponsesStreamResponse{Type: "response.created", Response: &snapshot})
+	s.send(dto.ResponsesStreamResponse{Type: "response.in_progress", Response: &snapshot})
+}
+
+func (s *claudeResponsesStreamConverter) startBlock(index int, contentBlock *dto.ClaudeMediaMessage) {
+	if s.response == nil {
+		s.start(nil)
+	}
+	item := newClaudeResponsesOutputItem(contentBlock, s.baseId, index)
+	if item == nil {
+		return
+	}
+	block := &claudeResponsesStreamBlock{outputIndex: len(s.items), item: item}
+	s.blocks[index] = block
+	s.items = append(s.items, item)
+
+	added := *item
+	s.send(dto.ResponsesStreamResponse{Type: dto.ResponsesOutputTypeItemAdded, OutputIndex: common.GetPointer[int](block.outputIndex), Item: &added})
+	switch item.Type {
+	case dto.ResponsesOutputTypeMessage:
+		s.send(dto.ResponsesStreamResponse{
+			Type:         "response.content_part.added",
+			ItemId:       item.ID,
+			OutputIndex:  common.GetPointer[int](block.outputIndex),
+			ContentIndex: common.GetPointer[int](0),
+			Part:         &dto.ResponsesOutputContent{Type: "output_text", Annotations: []interface{}{}},
+		})
+	case dto.ResponsesOutputTypeReasoning:
+		s.send(dto.ResponsesStreamResponse{
+			Type:         "response.reasoning_summary_part.added",
+			ItemId:       item.ID,
+			OutputIndex:  common.GetPointer[int](block.outputIndex),
+			SummaryIndex: common.GetPointer[int](0),
+			Part:         &dto.ResponsesOutputContent{Type: "summary_text"},
+		})
+	}
+}
+
+func (s *claudeResponsesStreamConverter) deltaBlock(index int, delta *dto.ClaudeMediaMessage) {
+	block, ok := s.blocks[index]
+	if !ok {
+		return
+	}
+	event := dto.ResponsesStreamResponse{ItemId: block.item.ID, OutputIndex: common.GetPointer[int](block.outputIndex)}
+	switch {
+	case delta.Type == "text_delta" && delta.Text != nil && block.item.Type == dto.ResponsesOutputTypeMessage:
+		event.Type = "response.output_text.delta"
+		event.ContentIndex = common.GetPointer[int](0)
+		event.Delta = *delta.Text
+	case delta.Type == "thinking_delta" && delta.Thinking != nil && block.item.Type == dto.ResponsesOutputTypeReasoning:
+		event.Type = "response.reasoning_summary_text.delta"
+		event.SummaryIndex = common.GetPointer[int](0)
+		event.Delta = *delta.Thinking
+	case delta.Type == "signature_delta" && block.item.Type == dto.ResponsesOutputTypeReasoning:
+		// 签名没有对应的流事件，在 output_item.done 的 encrypted_content 中返回
+		block.item.EncryptedContent += delta.Signature
+		return
+	case delta.Type == "input_json_delta" && delta.PartialJson != nil && block.item.Type == dto.ResponsesOutputTypeFunctionCall:
+		event.Type = "response.function_call_arguments.delta"
+		event.Delta = *delta.PartialJson
+	default:
+		return
+	}
+	block.text.WriteString(event.Delta)
+	s.responseText.WriteString(event.Delta)
+	s.send(event)
+}
+
+func (s *claudeResponsesStreamConverter) stopBlock(index int) {
+	block, ok := s.blocks[index]
+	if !ok {
+		return
+	}
+	delete(s.blocks, index)
+
+	item := block.item
+	text := block.text.String()
+	outputIndex := common.GetPointer[int](block.outputIndex)
+	switch item.Type {
+	case dto.ResponsesOutputTypeMessage:
+		part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []interface{}{}}
+		item.Content = []dto.ResponsesOutputContent{part}
+		s.send(dto.ResponsesStreamResponse{Type: "response.output_text.done", ItemId: item.ID, OutputIndex: outputIndex, ContentIndex: common.GetPointer[int](0), Text: text})
+		s.send(dto.ResponsesStreamResponse{Type: "response.content_part.done", ItemId: item.ID, OutputIndex: outputIndex, ContentIndex: common.GetPointer[int](0), Part: &part})
+	case dto.ResponsesOutputTypeReasoning:
+		part := dto.ResponsesOutputContent{Type: "summary_text", Text: text}
+		item.Summary = []dto.ResponsesOutputContent{part}
+		s.send(dto.ResponsesStreamResponse{Type: "response.reasoning_summary_text.done", ItemId: item.ID, OutputIndex: outputIndex, SummaryIndex: common.GetPointer[int](0), Text: text})
+		s.send(dto.ResponsesStreamResponse{Type: "response.reasoning_summary_part.done", ItemId: item.ID, OutputIndex: outputIndex, SummaryIndex: common.GetPointer[int](0), Part: &part})
+	case dto.ResponsesOutputTypeFunctionCall:
+		item.Arguments = common.GetStringIfEmpty(text, "{}")
+		s.send(dto.ResponsesStreamResponse{Type: "response.function_call_arguments.done", ItemId: item.ID, OutputIndex: outputIndex, Arguments: item.Arguments})
+	}
+	item.Status = "completed"
+	done := *item
+	s.send(dto.ResponsesStreamResponse{Type: dto.ResponsesOutputTypeItemDone, OutputIndex: outputIndex, Item: &done})
+}
+
+// mergeUsage 合并 message_start 与 message_delta 中的用量，message_delta 的累计值覆盖之前的值
+func (s *claudeResponsesStreamConverter) mergeUsage(usage *dto.ClaudeUsage) {
+	if usage.InputTokens > 0 {
+		s.claudeUsage.InputTokens = usage.InputTokens
+	}
+	if usage.CacheReadInputTokens > 0 {
+		s.claudeUsage.CacheReadInputTokens = usage.CacheReadInputTokens
+	}
+	if usage.CacheCreationInputTokens > 0 {
+		s.claudeUsage.CacheCreationInputTokens = usage.CacheCreationInputTokens
+	}
+	if usage.CacheCreation != nil {
+		s.claudeUsage.CacheCreation = usage.CacheCreation
+	}
+	if usage.OutputTokens > 0 {
+		s.claudeUsage.OutputTokens = usage.OutputTokens
+	}
+	if usage.ServerToolUse != nil {
+		s.claudeUsage.ServerToolUse = usage.ServerToolUse
+	}
+}
+
+func (s *claudeResponsesStreamConverter) send(event dto.ResponsesStreamResponse) {
+	data, err := common.Marshal(event)
+	if err != nil {
+		logger.LogError(s.c, "send_stream_response_failed: "+err.Error())
+		return
+	}
+	helper.ResponseTerminalChunkData(s.c, s.info.ChannelOtherSettings.ResponsesTerminalEvent, event, string(data))
+}
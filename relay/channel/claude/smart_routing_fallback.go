@@ -0,0 +1,107 @@
+package claude
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// smartRoutingFallbackCount 进程启动以来智能路由回退到原生 Claude 接口的次数
+var smartRoutingFallbackCount atomic.Int64
+
+// smartRoutingFallbackStatusCodes Responses 接口拒绝转换后请求的状态码，通常表示参数或特性不受支持，可由原生 Claude 接口处理
+// 限流、鉴权失败与上游故障回退到同一渠道与密钥也会失败，不在其中
+var smartRoutingFallbackStatusCodes = map[int]bool{
+	http.StatusBadRequest:          true,
+	http.StatusNotFound:            true,
+	http.StatusUnprocessableEntity: true,
+}
+
+// shouldFallbackToNative 判断智能路由到 Responses 接口的请求失败后是否回退到原生 Claude 接口
+// 仅在开启 FallbackOnError 且本次请求尚未回退过时，对转换后请求或特性不受支持的错误回退，请求未送达上游等其他错误直接返回
+func shouldFallbackToNative(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response, err error) bool {
+	if info.ConversionSource != relaycommon.ConversionSourceClaude || info.SmartRoutingFallback {
+		return false
+	}
+	if !info.ChannelOtherSettings.ClaudeSmartRouting.ShouldFallbackOnError() {
+		return false
+	}
+	if _, ok := info.OriginalRequest.(*dto.GeneralOpenAIRequest); !ok {
+		return false
+	}
+	if err != nil || resp == nil {
+		return false
+	}
+	return smartRoutingFallbackStatusCodes[resp.StatusCode] && !info.DeadlineExceeded && c.Request.Context().Err() == nil
+}
+
+// fallbackToNative 将原始请求按原生 Claude 格式重新发送到当前渠道，请求模式恢复为智能路由前的模式
+// 回退请求失败时返回 Responses 接口的原始错误响应，避免掩盖真正的失败原因
+func (a *Adaptor) fallbackToNative(c *gin.Context, info *relaycommon.RelayInfo, failedResp *http.Response) (any, error) {
+	request := info.OriginalRequest.(*dto.GeneralOpenAIRequest)
+	failedBody, readErr := io.ReadAll(failedResp.Body)
+	service.CloseResponseBodyGracefully(failedResp)
+	if readErr != nil {
+		return nil, readErr
+	}
+	failedResp.Body = io.NopCloser(bytes.NewReader(failedBody))
+	count := smartRoutingFallbackCount.Add(1)
+	logger.LogWarn(c, fmt.Sprintf("smart routing to Responses failed for model %s on channel #%d: status code %d, body: %s, fallback to native Claude (total fallbacks: %d)", info.OriginModelName, info.ChannelId, failedResp.StatusCode, common.MaskSensitiveInfo(string(failedBody)), count))
+
+	relayMode := info.RelayMode
+	info.ResetConversion()
+	info.SmartRoutingFallback = true
+	info.RelayMode = a.relayModeBeforeRouting
+
+	resp, err := a.doNativeRequest(c, info, request)
+	if err == nil {
+		if httpResp, ok := resp.(*http.Response); ok && httpResp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		if httpResp, ok := resp.(*http.Response); ok {
+			service.CloseResponseBodyGracefully(httpResp)
+		}
+	}
+	logger.LogWarn(c, fmt.Sprintf("native Claude fallback failed for model %s on channel #%d, returning the original Responses error", info.OriginModelName, info.ChannelId))
+	// 回退失败时恢复智能路由的转换状态，按 Responses 接口的错误响应处理
+	info.MarkConverted(relaycommon.ConversionSourceClaude, request)
+	info.RelayMode = relayMode
+	failedResp.Body = io.NopCloser(bytes.NewReader(failedBody))
+	return failedResp, nil
+}
+
+// doNativeRequest 将 Chat 请求转换为原生 Claude 请求并发送到当前渠道
+func (a *Adaptor) doNativeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
+	var claudeRequest any
+	if a.RequestMode == RequestModeCompletion {
+		claudeRequest = RequestOpenAI2ClaudeComplete(*request)
+	} else {
+		messageRequest, err := RequestOpenAI2ClaudeMessage(c, *request)
+		if err != nil {
+			return nil, err
+		}
+		claudeRequest = messageRequest
+	}
+	jsonData, err := common.Marshal(claudeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride, relaycommon.BuildParamOverrideContext(info))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return channel.DoApiRequest(a, c, info, bytes.NewBuffer(jsonData))
+}
@@ -116,6 +116,14 @@ func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
 
+// Capabilities 适配器支持的接口与特性
+func (a *Adaptor) Capabilities() channel.Capabilities {
+	return channel.Capabilities{
+		Chat:      true,
+		Streaming: true,
+	}
+}
+
 // GetModelList implements channel.Adaptor.
 func (a *Adaptor) GetModelList() []string {
 	return ModelList
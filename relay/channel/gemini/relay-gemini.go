@@ -982,13 +982,16 @@ func geminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 	responseText := strings.Builder{}
 	
 	// 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewStreamBodyCapture()
+
+	// 网关输出 token 上限
+	outputBudget := service.NewOutputTokenBudget(info)
+	budgetExceeded := false
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		// 累积完整响应体用于日志记录（不影响转发逻辑）
 		if len(data) > 0 {
-			fullStreamResponse.WriteString(data)
-			fullStreamResponse.WriteString("\n")
+			fullStreamResponse.Append(data)
 		}
 		
 		var geminiResponse dto.GeminiChatResponse
@@ -998,6 +1001,14 @@ func geminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 			return false
 		}
 
+		// 超出输出上限时丢弃当前块，以 MAX_TOKENS 结束并终止流
+		if !outputBudget.Consume(geminiResponseText(&geminiResponse)) {
+			budgetExceeded = true
+			stopResponse, stopData := geminiMaxTokensStopResponse()
+			callback(stopData, stopResponse)
+			return false
+		}
+
 		// 统计图片数量
 		for _, candidate := range geminiResponse.Candidates {
 			for _, part := range candidate.Content.Parts {
@@ -1048,12 +1059,45 @@ func geminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 		}
 	}
 
+	if budgetExceeded {
+		// 流在上游结束前被终止，按已转发的文本计算输出 token
+		promptTokens := usage.PromptTokens
+		if promptTokens == 0 {
+			promptTokens = info.PromptTokens
+		}
+		usage = service.ResponseText2Usage(c, responseText.String(), info.UpstreamModelName, promptTokens)
+	}
+
 	// 将完整的流式响应体存储到 relayInfo 中
 	info.ResponseBody = fullStreamResponse.String()
 
 	return usage, nil
 }
 
+// geminiResponseText 获取流式块中各候选的文本，包括思考内容
+func geminiResponseText(geminiResponse *dto.GeminiChatResponse) string {
+	var text strings.Builder
+	for _, candidate := range geminiResponse.Candidates {
+		for _, part := range candidate.Content.Parts {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// geminiMaxTokensStopResponse 生成超出网关输出上限时结束流的响应块，finishReason 为 MAX_TOKENS
+func geminiMaxTokensStopResponse() (*dto.GeminiChatResponse, string) {
+	finishReason := "MAX_TOKENS"
+	stopResponse := &dto.GeminiChatResponse{
+		Candidates: []dto.GeminiChatCandidate{{
+			Content:      dto.GeminiChatContent{Role: "model", Parts: []dto.GeminiPart{}},
+			FinishReason: &finishReason,
+		}},
+	}
+	stopData, _ := common.Marshal(stopResponse)
+	return stopResponse, string(stopData)
+}
+
 func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	id := helper.GetResponseID(c)
 	createAt := common.GetTimestamp()
@@ -53,6 +53,25 @@ func parseReasoningEffortFromModelSuffix(model string) (string, string) {
 	return "", model
 }
 
+// GetModelParameterRestrictions 获取模型在 ConvertOpenAIRequest 中会被强制调整的参数说明
+// 与 ConvertOpenAIRequest 中 o 系列及 gpt-5 系列模型的处理保持一致，用于渠道保存时提前提示
+func GetModelParameterRestrictions(model string) []string {
+	if !strings.HasPrefix(model, "o") && !strings.HasPrefix(model, "gpt-5") {
+		return nil
+	}
+	restrictions := []string{"max_tokens 会被转换为 max_completion_tokens"}
+	if strings.HasPrefix(model, "o") || (strings.HasPrefix(model, "gpt-5") && model != "gpt-5-chat-latest") {
+		restrictions = append(restrictions, "temperature 参数会被忽略")
+	}
+	if effort, _ := parseReasoningEffortFromModelSuffix(model); effort != "" {
+		restrictions = append(restrictions, fmt.Sprintf("模型后缀会被转换为 reasoning_effort=%s", effort))
+	}
+	if !strings.HasPrefix(model, "o1-mini") && !strings.HasPrefix(model, "o1-preview") {
+		restrictions = append(restrictions, "首条 system 消息会被改为 developer 角色")
+	}
+	return restrictions
+}
+
 func (a *Adaptor) ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) (any, error) {
 	// 使用 service.GeminiToOpenAIRequest 转换请求格式
 	openaiRequest, err := service.GeminiToOpenAIRequest(request, info)
@@ -647,3 +666,20 @@ func (a *Adaptor) GetChannelName() string {
 		return ChannelName
 	}
 }
+
+// Capabilities 适配器支持的接口与特性
+func (a *Adaptor) Capabilities() channel.Capabilities {
+	return channel.Capabilities{
+		Chat:       true,
+		Responses:  true,
+		Claude:     true,
+		Gemini:     true,
+		Embeddings: true,
+		Audio:      true,
+		Images:     true,
+		Rerank:     true,
+		Streaming:  true,
+		Tools:      true,
+		Vision:     true,
+	}
+}
@@ -117,10 +117,11 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 	var createAt int64 = 0
 	var systemFingerprint string
 	var containStreamUsage bool
-	var responseTextBuilder strings.Builder
+	// 随流增量统计输出 token，避免流结束时对全部输出一次性计数
+	responseTextCounter := service.NewStreamTextCounter(info.UpstreamModelName)
+	info.StreamTokenCounter = responseTextCounter
 	var toolCount int
 	var usage = &dto.Usage{}
-	var streamItems []string // store stream items
 	var lastStreamData string
 	var secondLastStreamData string // 存储倒数第二个stream data，用于音频模型
 
@@ -128,13 +129,33 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 	isAudioModel := strings.Contains(strings.ToLower(model), "audio")
 	
 	// 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewStreamBodyCapture()
+
+	// 网关输出 token 上限
+	outputBudget := service.NewOutputTokenBudget(info)
+
+	// 按配置将上游 ID 替换为网关 ID
+	idMapper := service.NewResponseIdMapper(info)
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		// 累积完整响应体用于日志记录（不影响转发逻辑）
 		if len(data) > 0 {
-			fullStreamResponse.WriteString(data)
-			fullStreamResponse.WriteString("\n")
+			fullStreamResponse.Append(data)
+		}
+
+		// 每条响应只解析一次，供输出上限检查与 token 统计共用
+		chatResponse := parseChatStreamItem(info.RelayMode, data)
+
+		// 超出输出上限时丢弃当前块，以 length 结束块替换最后一条响应并终止流
+		if stopData, exceeded := checkOutputBudget(outputBudget, chatResponse); exceeded {
+			if lastStreamData != "" {
+				err := HandleStreamFormat(c, info, lastStreamData, info.ChannelSetting.ForceFormat, info.ChannelSetting.ThinkingToContent)
+				if err != nil {
+					common.SysLog("error handling stream format: " + err.Error())
+				}
+			}
+			lastStreamData = idMapper.RewriteString(stopData)
+			return false
 		}
 		
 		// 原始转发逻辑：延迟一条转发（除了最后一条，在循环结束后单独处理）
@@ -150,8 +171,10 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 				secondLastStreamData = lastStreamData
 			}
 
-			lastStreamData = data
-			streamItems = append(streamItems, data)
+			lastStreamData = idMapper.RewriteString(data)
+			if err := processStreamItemTokens(info.RelayMode, data, chatResponse, responseTextCounter, &toolCount); err != nil {
+				logger.LogError(c, "error processing stream tokens: "+err.Error())
+			}
 		}
 		return true
 	})
@@ -187,13 +210,8 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 		}
 	}
 
-	// 处理token计算
-	if err := processTokens(info.RelayMode, streamItems, &responseTextBuilder, &toolCount); err != nil {
-		logger.LogError(c, "error processing tokens: "+err.Error())
-	}
-
 	if !containStreamUsage {
-		usage = service.ResponseText2Usage(c, responseTextBuilder.String(), info.UpstreamModelName, info.PromptTokens)
+		usage = service.StreamTextCounter2Usage(c, responseTextCounter, info.PromptTokens)
 		usage.CompletionTokens += toolCount * 7
 	}
 
@@ -306,7 +324,8 @@ func OpenaiHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Respo
 		responseBody = geminiRespStr
 	}
 
-	service.IOCopyBytesGracefully(c, resp, responseBody)
+	// 按配置将上游 ID 替换为网关 ID
+	service.IOCopyBytesGracefully(c, resp, service.NewResponseIdMapper(info).Rewrite(responseBody))
 
 	return &simpleResponse.Usage, nil
 }
@@ -719,3 +738,27 @@ func extractCachedTokensFromBody(body []byte) (int, bool) {
 	}
 	return 0, false
 }
+
+// checkOutputBudget 统计已解析的流式块中的输出文本并检查是否超出网关输出上限
+// 返回:
+//   - string: 超出上限时用于结束流的 finish_reason 为 length 的响应块
+//   - bool: 是否超出上限
+func checkOutputBudget(budget *service.OutputTokenBudget, streamResponse *dto.ChatCompletionsStreamResponse) (string, bool) {
+	if budget == nil || streamResponse == nil {
+		return "", false
+	}
+	var text strings.Builder
+	for _, choice := range streamResponse.Choices {
+		text.WriteString(choice.Delta.GetContentString())
+		text.WriteString(choice.Delta.GetReasoningContent())
+	}
+	if budget.Consume(text.String()) {
+		return "", false
+	}
+	stopResponse := helper.GenerateStopResponse(streamResponse.Id, streamResponse.Created, streamResponse.Model, constant.FinishReasonLength)
+	stopData, err := common.Marshal(stopResponse)
+	if err != nil {
+		return "", false
+	}
+	return string(stopData), true
+}
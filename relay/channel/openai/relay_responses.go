@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
@@ -15,13 +14,13 @@ import (
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 )
 
 func OaiResponsesHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	defer service.CloseResponseBodyGracefully(resp)
 
 	// read response body
-	var responsesResponse dto.OpenAIResponsesResponse
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
@@ -30,41 +29,44 @@ func OaiResponsesHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 	// 将响应体存储到 relayInfo 中
 	info.ResponseBody = string(responseBody)
 	
-	err = common.Unmarshal(responseBody, &responsesResponse)
-	if err != nil {
-		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	// 原样转发上游响应，只从副本中读取计费所需的字段，避免 DTO 未定义的输出项与用量字段在重新序列化时丢失
+	if !gjson.ValidBytes(responseBody) {
+		return nil, types.NewOpenAIError(errInvalidResponsesResponse, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
 	}
-	if oaiError := responsesResponse.GetOpenAIError(); oaiError != nil && oaiError.Type != "" {
+	responsesResponse := gjson.ParseBytes(responseBody)
+	if oaiError := rawResponsesError(responsesResponse); oaiError != nil {
 		return nil, types.WithOpenAIError(*oaiError, resp.StatusCode)
 	}
 
-	if responsesResponse.HasImageGenerationCall() {
+	if imageCall, ok := rawImageGenerationCall(responsesResponse); ok {
 		c.Set("image_generation_call", true)
-		c.Set("image_generation_call_quality", responsesResponse.GetQuality())
-		c.Set("image_generation_call_size", responsesResponse.GetSize())
+		c.Set("image_generation_call_quality", imageCall.Get("quality").String())
+		c.Set("image_generation_call_size", imageCall.Get("size").String())
 	}
 
-	// 写入新的 response body
-	service.IOCopyBytesGracefully(c, resp, responseBody)
+	// 写入原始 response body，按配置将上游 ID 替换为网关 ID
+	service.IOCopyBytesGracefully(c, resp, service.NewResponseIdMapper(info).Rewrite(responseBody))
 
 	// compute usage
 	usage := dto.Usage{}
-	if responsesResponse.Usage != nil {
-		usage.PromptTokens = responsesResponse.Usage.InputTokens
-		usage.CompletionTokens = responsesResponse.Usage.OutputTokens
-		usage.TotalTokens = responsesResponse.Usage.TotalTokens
-		if responsesResponse.Usage.InputTokensDetails != nil {
-			usage.PromptTokensDetails.CachedTokens = responsesResponse.Usage.InputTokensDetails.CachedTokens
+	if rawUsage := responsesResponse.Get("usage"); rawUsage.IsObject() {
+		responsesUsage := parseRawResponsesUsage(rawUsage)
+		usage.PromptTokens = responsesUsage.InputTokens
+		usage.CompletionTokens = responsesUsage.OutputTokens
+		usage.TotalTokens = responsesUsage.TotalTokens
+		if responsesUsage.InputTokensDetails != nil {
+			usage.PromptTokensDetails.CachedTokens = responsesUsage.InputTokensDetails.CachedTokens
 		}
 	}
 	if info == nil || info.ResponsesUsageInfo == nil || info.ResponsesUsageInfo.BuiltInTools == nil {
 		return &usage, nil
 	}
 	// 解析 Tools 用量
-	for _, tool := range responsesResponse.Tools {
-		buildToolinfo, ok := info.ResponsesUsageInfo.BuiltInTools[common.Interface2String(tool["type"])]
+	for _, tool := range responsesResponse.Get("tools").Array() {
+		toolType := tool.Get("type").String()
+		buildToolinfo, ok := info.ResponsesUsageInfo.BuiltInTools[toolType]
 		if !ok || buildToolinfo == nil {
-			logger.LogError(c, fmt.Sprintf("BuiltInTools not found for tool type: %v", tool["type"]))
+			logger.LogError(c, fmt.Sprintf("BuiltInTools not found for tool type: %v", toolType))
 			continue
 		}
 		buildToolinfo.CallCount++
@@ -81,24 +83,27 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 	defer service.CloseResponseBodyGracefully(resp)
 
 	var usage = &dto.Usage{}
-	var responseTextBuilder strings.Builder
+	responseTextCounter := service.NewStreamTextCounter(info.UpstreamModelName)
+	info.StreamTokenCounter = responseTextCounter
 	
 	// 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewStreamBodyCapture()
+
+	// 按配置将上游 ID 替换为网关 ID
+	idMapper := service.NewResponseIdMapper(info)
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		// 累积完整响应体用于日志记录（不影响转发逻辑）
 		if len(data) > 0 {
-			fullStreamResponse.WriteString(data)
-			fullStreamResponse.WriteString("\n")
+			fullStreamResponse.Append(data)
 		}
 
 		// 检查当前数据是否包含 completed 状态和 usage 信息
 		var streamResponse dto.ResponsesStreamResponse
 		if err := common.UnmarshalJsonStr(data, &streamResponse); err == nil {
-			sendResponsesStreamData(c, streamResponse, data)
+			sendResponsesStreamData(c, info, streamResponse, idMapper.RewriteString(data))
 			switch streamResponse.Type {
-			case "response.completed":
+			case "response.completed", "response.done":
 				if streamResponse.Response != nil {
 					if streamResponse.Response.Usage != nil {
 						if streamResponse.Response.Usage.InputTokens != 0 {
@@ -122,7 +127,7 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 				}
 			case "response.output_text.delta":
 				// 处理输出文本
-				responseTextBuilder.WriteString(streamResponse.Delta)
+				responseTextCounter.WriteString(streamResponse.Delta)
 			case dto.ResponsesOutputTypeItemDone:
 				// 函数调用处理
 				if streamResponse.Item != nil {
@@ -145,14 +150,9 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 	// 将完整的流式响应体存储到 relayInfo 中
 	info.ResponseBody = fullStreamResponse.String()
 
-	if usage.CompletionTokens == 0 {
-		// 计算输出文本的 token 数量
-		tempStr := responseTextBuilder.String()
-		if len(tempStr) > 0 {
-			// 非正常结束，使用输出文本的 token 数量
-			completionTokens := service.CountTextToken(tempStr, info.UpstreamModelName)
-			usage.CompletionTokens = completionTokens
-		}
+	if usage.CompletionTokens == 0 && !responseTextCounter.IsEmpty() {
+		// 非正常结束，使用输出文本的 token 数量
+		usage.CompletionTokens = responseTextCounter.TokenCount()
 	}
 
 	if usage.PromptTokens == 0 && usage.CompletionTokens != 0 {
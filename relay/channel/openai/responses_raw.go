@@ -0,0 +1,55 @@
+package openai
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/tidwall/gjson"
+)
+
+var errInvalidResponsesResponse = errors.New("invalid responses response: body is not valid json")
+
+// rawResponsesError 读取原始 Responses 响应中的错误对象，没有错误或错误类型为空时返回 nil
+func rawResponsesError(response gjson.Result) *types.OpenAIError {
+	errorField := response.Get("error")
+	if !errorField.IsObject() || errorField.Get("type").String() == "" {
+		return nil
+	}
+	oaiError := &types.OpenAIError{
+		Type:    errorField.Get("type").String(),
+		Message: errorField.Get("message").String(),
+		Param:   errorField.Get("param").String(),
+	}
+	if code := errorField.Get("code"); code.Exists() {
+		oaiError.Code = code.Value()
+	}
+	return oaiError
+}
+
+// rawImageGenerationCall 查找原始输出中的第一个图片生成调用
+func rawImageGenerationCall(response gjson.Result) (gjson.Result, bool) {
+	for _, output := range response.Get("output").Array() {
+		if output.Get("type").String() == dto.ResponsesOutputTypeImageGenerationCall {
+			return output, true
+		}
+	}
+	return gjson.Result{}, false
+}
+
+// parseRawResponsesUsage 解析 Responses usage，回退时保留缓存命中 token 数
+func parseRawResponsesUsage(usage gjson.Result) *dto.Usage {
+	return helper.ParseRawUsage(usage, func(usage gjson.Result) *dto.Usage {
+		responsesUsage := &dto.Usage{
+			InputTokens:  int(usage.Get("input_tokens").Int()),
+			OutputTokens: int(usage.Get("output_tokens").Int()),
+			TotalTokens:  int(usage.Get("total_tokens").Int()),
+		}
+		if cached := usage.Get("input_tokens_details.cached_tokens"); cached.Exists() {
+			responsesUsage.InputTokensDetails = &dto.InputTokenDetails{CachedTokens: int(cached.Int())}
+		}
+		return responsesUsage
+	})
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/QuantumNous/new-api/relay/channel/openai"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -38,7 +41,7 @@ func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 //   - error: 如果不是 Responses API 请求则返回错误
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	if info.RelayMode != relayconstant.RelayModeResponses {
-		return "", fmt.Errorf("OpenAI Responses 渠道仅支持 /v1/responses 接口，当前请求: %s", info.RequestURLPath)
+		return "", types.NewLocalizedError(types.ErrMsgResponsesEndpointOnly, info.RequestURLPath)
 	}
 	return fmt.Sprintf("%s/v1/responses", info.ChannelBaseUrl), nil
 }
@@ -74,17 +77,29 @@ func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayIn
 		return nil, fmt.Errorf("model is required")
 	}
 
-	// 标记这是一个转换后的请求，用于响应处理阶段
-	c.Set("converted_from_claude", true)
-	
-	// 保存原始请求，用于响应转换时参考
-	c.Set("original_claude_request", request)
+	// 标记这是一个转换后的请求，并保存原始请求，用于响应转换时参考
+	info.MarkConverted(relaycommon.ConversionSourceClaude, request)
 	
 	// 调用转换器进行格式转换
 	responsesReq, err := ClaudeMessagesToResponsesRequest(c, request, info)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert claude messages request: %w", err)
 	}
+
+	// 按对话历史中的工具调用次数施加上限，终止失控的 Agent 循环
+	if apiErr := service.ApplyToolCallBudget(c, responsesReq, service.CountClaudeToolCalls(request.Messages)); apiErr != nil {
+		return nil, apiErr
+	}
+
+	// 预计数按 Anthropic 的工具渲染方式估算，Responses 上游按函数命名空间渲染计费，兜底计费需随之修正
+	service.ApplyToolSchemaRendering(info, &types.TokenCountMeta{
+		ToolSchemas: request.GetToolSchemas(),
+		ToolChoice:  request.GetToolChoiceType(),
+	}, service.ToolSchemaRenderingClaude, service.ToolSchemaRenderingResponses)
+
+	// 超长输出的非流式请求必须流式请求上游，响应阶段再聚合为非流式结果
+	threshold := model_setting.GetResponsesSettings().ClaudeStreamRequiredMaxTokens
+	helper.ApplyUpstreamStreamAggregation(info, responsesReq, threshold > 0 && int(responsesReq.MaxOutputTokens) >= threshold)
 	
 	// 更新 RelayMode 为 Responses 模式
 	info.RelayMode = relayconstant.RelayModeResponses
@@ -92,12 +107,35 @@ func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayIn
 	return responsesReq, nil
 }
 
-// ConvertGeminiRequest Gemini 请求转换（不支持）
-// 该渠道不支持 Gemini 格式的请求
+// ConvertGeminiRequest Gemini 请求转换
+// 支持 Gemini generateContent 格式转换为 Responses API 格式
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息
+//   - request: Gemini 请求对象
 // 返回:
-//   - error: 始终返回不支持的错误
+//   - any: 转换后的 Responses API 请求对象
+//   - error: 转换失败时返回错误
 func (a *Adaptor) ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) (any, error) {
-	return nil, fmt.Errorf("OpenAI Responses 渠道不支持 Gemini 请求")
+	if request == nil {
+		return nil, errors.New("gemini request is nil")
+	}
+
+	// 标记这是一个转换后的请求，并保存原始请求，用于响应转换时参考
+	info.MarkConverted(relaycommon.ConversionSourceGemini, request)
+
+	responsesReq, err := GeminiToResponsesRequest(c, request, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert gemini request: %w", err)
+	}
+
+	// 按配置以流式请求上游，响应阶段再聚合为非流式结果
+	helper.ApplyUpstreamStreamAggregation(info, responsesReq, false)
+
+	// 更新 RelayMode 为 Responses 模式
+	info.RelayMode = relayconstant.RelayModeResponses
+
+	return responsesReq, nil
 }
 
 // ConvertOpenAIRequest OpenAI 通用请求转换
@@ -114,17 +152,30 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 
 	// 智能路由检测：如果是 Chat Completions 请求，自动转换为 Responses API 格式
 	if info.RelayMode == relayconstant.RelayModeChatCompletions {
-		// 标记这是一个转换后的请求，用于响应处理阶段
-		c.Set("converted_from_chat", true)
-		
-		// 保存原始请求，用于响应转换时参考
-		c.Set("original_chat_request", request)
+		// 标记这是一个转换后的请求，并保存原始请求，用于响应转换时参考
+		info.MarkConverted(relaycommon.ConversionSourceChat, request)
+
+		// 与 OpenAI 一致，仅在 stream_options.include_usage 为 true 时下发用量分块。
+		// 渠道不支持 stream_options 时请求中的该字段已被清除，以客户端的原始请求为准
+		originalRequest, ok := info.Request.(*dto.GeneralOpenAIRequest)
+		if !ok {
+			originalRequest = request
+		}
+		info.ShouldIncludeUsage = chatStreamIncludeUsage(originalRequest)
 		
 		// 调用转换器进行格式转换
 		responsesReq, err := ChatCompletionsToResponsesRequest(c, request, info)
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert chat completions request: %w", err)
 		}
+
+		// 按对话历史中的工具调用次数施加上限，终止失控的 Agent 循环
+		if apiErr := service.ApplyToolCallBudget(c, responsesReq, service.CountChatToolCalls(request.Messages)); apiErr != nil {
+			return nil, apiErr
+		}
+
+		// 按配置以流式请求上游，响应阶段再聚合为非流式结果
+		helper.ApplyUpstreamStreamAggregation(info, responsesReq, false)
 		
 		// 更新 RelayMode 为 Responses 模式
 		info.RelayMode = relayconstant.RelayModeResponses
@@ -138,7 +189,7 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 	}
 
 	// 不支持的请求模式
-	return nil, fmt.Errorf("OpenAI Responses 渠道仅支持 Chat Completions 和 Responses API 请求")
+	return nil, types.NewLocalizedError(types.ErrMsgResponsesModeUnsupported)
 }
 
 // ConvertOpenAIResponsesRequest Responses API 请求转换
@@ -161,7 +212,7 @@ func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommo
 // 返回:
 //   - error: 始终返回不支持的错误
 func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
-	return nil, fmt.Errorf("OpenAI Responses 渠道不支持 Rerank 接口")
+	return nil, types.NewLocalizedError(types.ErrMsgResponsesAPIUnsupported, "Rerank")
 }
 
 // ConvertEmbeddingRequest Embedding 请求转换（不支持）
@@ -169,7 +220,7 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 // 返回:
 //   - error: 始终返回不支持的错误
 func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest) (any, error) {
-	return nil, fmt.Errorf("OpenAI Responses 渠道不支持 Embedding 接口")
+	return nil, types.NewLocalizedError(types.ErrMsgResponsesAPIUnsupported, "Embedding")
 }
 
 // ConvertAudioRequest Audio 请求转换（不支持）
@@ -177,7 +228,7 @@ func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.Rela
 // 返回:
 //   - error: 始终返回不支持的错误
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
-	return nil, fmt.Errorf("OpenAI Responses 渠道不支持 Audio 接口")
+	return nil, types.NewLocalizedError(types.ErrMsgResponsesAPIUnsupported, "Audio")
 }
 
 // ConvertImageRequest Image 请求转换（不支持）
@@ -185,7 +236,7 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 // 返回:
 //   - error: 始终返回不支持的错误
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	return nil, fmt.Errorf("OpenAI Responses 渠道不支持 Image 接口")
+	return nil, types.NewLocalizedError(types.ErrMsgResponsesAPIUnsupported, "Image")
 }
 
 // DoRequest 执行 HTTP 请求
@@ -211,16 +262,13 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 //   - usage: 使用量统计信息
 //   - err: 处理失败时返回错误
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
-	// 检查是否是从 Chat Completions 转换来的请求
-	convertedFromChat, _ := c.Get("converted_from_chat")
-	isConvertedFromChat := convertedFromChat == true
-
-	// 检查是否是从 Claude Messages 转换来的请求
-	convertedFromClaude, _ := c.Get("converted_from_claude")
-	isConvertedFromClaude := convertedFromClaude == true
+	// 上游按流式返回但客户端请求的是非流式响应，按非流式处理器输出
+	if info.AggregateUpstreamStream {
+		info.IsStream = false
+	}
 
 	// 如果是从 Chat Completions 转换来的请求，需要将响应转换回 Chat Completions 格式
-	if isConvertedFromChat {
+	if info.ConversionSource == relaycommon.ConversionSourceChat {
 		if info.IsStream {
 			// 流式响应转换：调用专用的转换处理器
 			usage, err = ResponsesToChatStreamHandler(c, info, resp)
@@ -232,7 +280,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	}
 
 	// 如果是从 Claude Messages 转换来的请求，需要将响应转换回 Claude Messages 格式
-	if isConvertedFromClaude {
+	if info.ConversionSource == relaycommon.ConversionSourceClaude {
 		if info.IsStream {
 			// 流式响应转换：调用 Claude 专用的转换处理器
 			usage, err = ResponsesToClaudeStreamHandler(c, info, resp)
@@ -243,10 +291,20 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		return
 	}
 
+	// 如果是从 Gemini 转换来的请求，需要将响应转换回 Gemini 格式
+	if info.ConversionSource == relaycommon.ConversionSourceGemini {
+		if info.IsStream {
+			usage, err = ResponsesToGeminiStreamHandler(c, info, resp)
+		} else {
+			usage, err = ResponsesToGeminiHandler(c, info, resp)
+		}
+		return
+	}
+
 	// 原生 Responses API 请求，直接处理
 	if info.RelayMode != relayconstant.RelayModeResponses {
 		return nil, types.NewError(
-			fmt.Errorf("OpenAI Responses 渠道仅支持 /v1/responses 接口"),
+			types.NewLocalizedError(types.ErrMsgResponsesEndpointOnly, info.RequestURLPath),
 			types.ErrorCodeBadResponse,
 		)
 	}
@@ -274,3 +332,16 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// Capabilities 适配器支持的接口与特性
+func (a *Adaptor) Capabilities() channel.Capabilities {
+	return channel.Capabilities{
+		Chat:      true,
+		Responses: true,
+		Claude:    true,
+		Gemini:    true,
+		Streaming: true,
+		Tools:     true,
+		Vision:    true,
+	}
+}
@@ -0,0 +1,130 @@
+package openai_responses
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// chatBuiltInToolTypes Chat 请求可以声明的 Responses 内置工具类型
+var chatBuiltInToolTypes = map[string]bool{
+	dto.BuildInToolWebSearchPreview: true,
+	dto.BuildInToolWebSearch:        true,
+	dto.BuildInToolFileSearch:       true,
+	dto.BuildInToolCodeInterpreter:  true,
+}
+
+// convertChatToolsToResponses 将 Chat Completions 的 tools 转换为 Responses tools，函数工具原样保留
+// 内置工具有两种声明方式：直接使用 Responses 的内置工具声明（如 {"type":"web_search_preview"}），
+// 或名称为内置工具类型且未声明 parameters 的伪函数工具（如 {"type":"function","function":{"name":"web_search_preview"}}），
+// 伪函数工具除 function 外的字段（如 vector_store_ids）作为内置工具的参数。识别到的内置工具登记到用量统计，按调用次数计费
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息
+//   - tools: Chat Completions 请求中的 tools
+//
+// 返回:
+//   - json.RawMessage: Responses tools
+//   - error: 转换失败时返回错误
+func convertChatToolsToResponses(c *gin.Context, info *relaycommon.RelayInfo, tools []dto.ToolCallRequest) (json.RawMessage, error) {
+	// 内置工具的参数不在 ToolCallRequest 中，从请求体中读取原始声明
+	var rawTools []gjson.Result
+	if body, err := common.GetRequestBody(c); err == nil {
+		rawTools = gjson.GetBytes(body, "tools").Array()
+	}
+	if len(rawTools) != len(tools) {
+		rawTools = make([]gjson.Result, len(tools))
+		for i := range tools {
+			raw, err := common.Marshal(tools[i])
+			if err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "failed to marshal tools: %s", err.Error())
+			}
+			rawTools[i] = gjson.ParseBytes(raw)
+		}
+	}
+
+	converted := make([]any, 0, len(tools))
+	for i, tool := range tools {
+		toolType := chatBuiltInToolType(tool, rawTools[i])
+		if toolType == "" {
+			converted = append(converted, tool)
+			continue
+		}
+		declaration := map[string]any{}
+		if rawTools[i].IsObject() {
+			if err := common.Unmarshal([]byte(rawTools[i].Raw), &declaration); err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tools[%d] is invalid: %s", i, err.Error())
+			}
+		}
+		delete(declaration, "function")
+		declaration["type"] = toolType
+		if _, ok := declaration["container"]; !ok && toolType == dto.BuildInToolCodeInterpreter {
+			declaration["container"] = map[string]any{"type": "auto"}
+		}
+		registerChatBuiltInTool(info, toolType, declaration)
+		converted = append(converted, declaration)
+	}
+
+	toolsData, err := common.Marshal(converted)
+	if err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "failed to marshal tools: %s", err.Error())
+	}
+	return toolsData, nil
+}
+
+// chatBuiltInToolType 返回 Chat 工具对应的内置工具类型，不是内置工具时返回空
+func chatBuiltInToolType(tool dto.ToolCallRequest, raw gjson.Result) string {
+	if chatBuiltInToolTypes[tool.Type] {
+		return tool.Type
+	}
+	// 声明了 parameters 的同名函数是客户端自己的函数工具，不视为内置工具
+	if (tool.Type == "" || tool.Type == "function") && chatBuiltInToolTypes[tool.Function.Name] && !raw.Get("function.parameters").Exists() {
+		return tool.Function.Name
+	}
+	return ""
+}
+
+// registerChatBuiltInTool 将内置工具登记到用量统计，web_search 与 web_search_preview 共用同一计数并按 web search 计费
+func registerChatBuiltInTool(info *relaycommon.RelayInfo, toolType string, declaration map[string]any) {
+	if info.ResponsesUsageInfo == nil {
+		info.ResponsesUsageInfo = &relaycommon.ResponsesUsageInfo{
+			BuiltInTools: make(map[string]*relaycommon.BuildInToolInfo),
+		}
+	}
+	key := toolType
+	if toolType == dto.BuildInToolWebSearch {
+		key = dto.BuildInToolWebSearchPreview
+	}
+	toolInfo, ok := info.ResponsesUsageInfo.BuiltInTools[key]
+	if !ok {
+		toolInfo = &relaycommon.BuildInToolInfo{ToolName: key}
+		info.ResponsesUsageInfo.BuiltInTools[key] = toolInfo
+	}
+	info.ResponsesUsageInfo.BuiltInTools[toolType] = toolInfo
+	if key == dto.BuildInToolWebSearchPreview {
+		toolInfo.SearchContextSize = common.Interface2String(declaration["search_context_size"])
+		if toolInfo.SearchContextSize == "" {
+			toolInfo.SearchContextSize = "medium"
+		}
+	}
+}
+
+// builtInToolChoice 指定调用伪函数工具的 tool_choice 转换为对应内置工具的 tool_choice
+func builtInToolChoice(info *relaycommon.RelayInfo, toolChoice any) any {
+	object, ok := toolChoice.(map[string]any)
+	if !ok || object["type"] != "function" || info.ResponsesUsageInfo == nil {
+		return toolChoice
+	}
+	name, _ := object["name"].(string)
+	if _, ok := info.ResponsesUsageInfo.BuiltInTools[name]; !ok || !chatBuiltInToolTypes[name] {
+		return toolChoice
+	}
+	return map[string]any{"type": name}
+}
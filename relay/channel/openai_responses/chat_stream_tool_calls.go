@@ -0,0 +1,283 @@
+package openai_responses
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chatStreamToolCall 流式转换过程中的一个函数调用
+type chatStreamToolCall struct {
+	index     int
+	sentArgs  strings.Builder
+	announced bool
+}
+
+// ChatStreamConverter 有状态的 Responses 到 Chat Completions 流式转换器
+// 在 ConvertResponsesStreamToChatStream 的基础上跟踪函数调用输出项，按出现顺序分配 tool_calls 下标并下发增量
+type ChatStreamConverter struct {
+	responseID string
+	model      string
+	created    int64
+	toolCalls  map[string]*chatStreamToolCall
+	lastKey    string
+	finished   bool
+}
+
+// NewChatStreamConverter 创建流式转换器
+// 参数:
+//   - model: 模型名称
+//
+// 返回:
+//   - *ChatStreamConverter: 流式转换器
+func NewChatStreamConverter(model string) *ChatStreamConverter {
+	return &ChatStreamConverter{
+		model:     model,
+		toolCalls: make(map[string]*chatStreamToolCall),
+	}
+}
+
+// HasToolCalls 是否已下发过工具调用
+func (s *ChatStreamConverter) HasToolCalls() bool {
+	return len(s.toolCalls) > 0
+}
+
+// Convert 转换一个 Responses 流式事件
+// 参数:
+//   - resp: Responses API流式响应对象
+//   - responseID: 响应ID
+//
+// 返回:
+//   - *dto.ChatCompletionsStreamResponse: 转换后的流式响应对象，忽略的事件返回nil
+//   - string: 本次下发的函数参数增量，用于备用 token 计算
+func (s *ChatStreamConverter) Convert(resp *dto.ResponsesStreamResponse, responseID string) (*dto.ChatCompletionsStreamResponse, string) {
+	if resp == nil {
+		return nil, ""
+	}
+	s.responseID = responseID
+	if resp.Response != nil && resp.Response.CreatedAt != 0 && s.created == 0 {
+		s.created = int64(resp.Response.CreatedAt)
+	}
+	chatStreamResp, arguments := s.convert(resp)
+	if chatStreamResp != nil {
+		s.stamp(chatStreamResp)
+	}
+	return chatStreamResp, arguments
+}
+
+// Created 返回所有分块共用的创建时间，取自 response.created，上游未提供时为第一个分块下发的时间
+func (s *ChatStreamConverter) Created() int64 {
+	if s.created == 0 {
+		s.created = common.GetTimestamp()
+	}
+	return s.created
+}
+
+// TextDelta 生成网关自行补发的文本增量分块，例如后处理缓存的剩余文本与恢复的文本
+func (s *ChatStreamConverter) TextDelta(responseID string, text string) *dto.ChatCompletionsStreamResponse {
+	s.responseID = responseID
+	chatStreamResp := ConvertResponsesStreamToChatStream(&dto.ResponsesStreamResponse{Type: "response.output_text.delta", Delta: text}, responseID, s.model)
+	s.stamp(chatStreamResp)
+	return chatStreamResp
+}
+
+// Finish 结束流式输出：请求 include_usage 时先发送仅含用量的分块，再发送 [DONE]，重复调用只生效一次
+// 参数:
+//   - c: Gin 上下文
+//   - info: 请求信息
+//   - usage: 最终用量
+func (s *ChatStreamConverter) Finish(c *gin.Context, info *relaycommon.RelayInfo, usage *dto.Usage) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	// 与 OpenAI 一致，请求 include_usage 时在 [DONE] 之前发送 choices 为空、仅含用量的分块
+	if info.ShouldIncludeUsage && usage != nil {
+		sendChatStreamData(c, *helper.GenerateFinalUsageResponse(s.responseID, s.Created(), s.model, *usage))
+	}
+	helper.Done(c)
+}
+
+// stamp 所有分块使用一致的 ID 与创建时间，终止事件中的上游响应 ID 也替换为网关下发的 ID
+func (s *ChatStreamConverter) stamp(chatStreamResp *dto.ChatCompletionsStreamResponse) {
+	chatStreamResp.Created = s.Created()
+	if s.responseID != "" {
+		chatStreamResp.Id = s.responseID
+	}
+}
+
+func (s *ChatStreamConverter) convert(resp *dto.ResponsesStreamResponse) (*dto.ChatCompletionsStreamResponse, string) {
+	responseID := s.responseID
+	switch resp.Type {
+	case "response.output_item.added":
+		if resp.Item != nil && resp.Item.Type == dto.ResponsesOutputTypeFunctionCall {
+			call := s.getToolCall(resp)
+			chunk := s.announce(call, resp.Item)
+			// 部分上游在 added 事件中已携带完整参数
+			if args := s.pendingArguments(call, resp.Item.Arguments); args != "" {
+				chunk.Choices[0].Delta.ToolCalls[0].Function.Arguments = args
+				return chunk, args
+			}
+			return chunk, ""
+		}
+	case "response.function_call_arguments.delta":
+		if resp.Delta == "" {
+			return nil, ""
+		}
+		call := s.getToolCall(resp)
+		call.sentArgs.WriteString(resp.Delta)
+		if !call.announced {
+			chunk := s.announce(call, &dto.ResponsesOutput{})
+			chunk.Choices[0].Delta.ToolCalls[0].Function.Arguments = resp.Delta
+			return chunk, resp.Delta
+		}
+		return s.toolCallChunk(dto.ToolCallResponse{
+			Index:    &call.index,
+			Function: dto.FunctionResponse{Arguments: resp.Delta},
+		}), resp.Delta
+	case "response.function_call_arguments.done":
+		return nil, ""
+	case "response.refusal.delta":
+		// 结构化输出被拒绝时的说明以 delta.refusal 下发
+		if resp.Delta == "" {
+			return nil, ""
+		}
+		refusal := resp.Delta
+		return &dto.ChatCompletionsStreamResponse{
+			Id:     responseID,
+			Object: "chat.completion.chunk",
+			Model:  s.model,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{
+				{
+					Index: 0,
+					Delta: dto.ChatCompletionsStreamResponseChoiceDelta{Refusal: &refusal},
+				},
+			},
+		}, ""
+	case dto.ResponsesOutputTypeItemDone:
+		if resp.Item != nil && resp.Item.Type == dto.ResponsesOutputTypeFunctionCall {
+			// 上游未下发参数增量时，以 done 事件中的完整参数补齐
+			call := s.getToolCall(resp)
+			var chunk *dto.ChatCompletionsStreamResponse
+			if !call.announced {
+				chunk = s.announce(call, resp.Item)
+			}
+			args := s.pendingArguments(call, resp.Item.Arguments)
+			if args == "" {
+				return chunk, ""
+			}
+			if chunk == nil {
+				chunk = s.toolCallChunk(dto.ToolCallResponse{
+					Index:    &call.index,
+					Function: dto.FunctionResponse{Arguments: args},
+				})
+			} else {
+				chunk.Choices[0].Delta.ToolCalls[0].Function.Arguments = args
+			}
+			return chunk, args
+		}
+		return nil, ""
+	case "response.done", "response.completed", "response.incomplete", "response.failed":
+		chatStreamResp := ConvertResponsesStreamToChatStream(resp, responseID, s.model)
+		if chatStreamResp == nil {
+			return nil, ""
+		}
+		// 有工具调用且正常结束时，finish_reason 应为 tool_calls
+		if s.HasToolCalls() {
+			for i := range chatStreamResp.Choices {
+				reason := chatStreamResp.Choices[i].FinishReason
+				if reason != nil && *reason == constant.FinishReasonStop {
+					toolCalls := constant.FinishReasonToolCalls
+					chatStreamResp.Choices[i].FinishReason = &toolCalls
+				}
+			}
+		}
+		return chatStreamResp, ""
+	}
+	return ConvertResponsesStreamToChatStream(resp, responseID, s.model), ""
+}
+
+// getToolCall 按输出项 ID 或输出下标查找函数调用，不存在时按出现顺序分配新的 tool_calls 下标
+func (s *ChatStreamConverter) getToolCall(resp *dto.ResponsesStreamResponse) *chatStreamToolCall {
+	key := resp.ItemId
+	if key == "" && resp.Item != nil {
+		key = resp.Item.ID
+	}
+	if key == "" && resp.OutputIndex != nil {
+		key = "output_" + strconv.Itoa(*resp.OutputIndex)
+	}
+	if key == "" {
+		// 无法定位输出项时归属到最近的函数调用
+		key = s.lastKey
+	}
+	if call, ok := s.toolCalls[key]; ok {
+		s.lastKey = key
+		return call
+	}
+	call := &chatStreamToolCall{index: len(s.toolCalls)}
+	s.toolCalls[key] = call
+	s.lastKey = key
+	return call
+}
+
+// announce 生成携带调用 ID 与函数名称的首个 tool_calls 增量
+func (s *ChatStreamConverter) announce(call *chatStreamToolCall, item *dto.ResponsesOutput) *dto.ChatCompletionsStreamResponse {
+	call.announced = true
+	callId := item.CallId
+	if callId == "" {
+		callId = item.ID
+	}
+	return s.toolCallChunk(dto.ToolCallResponse{
+		Index: &call.index,
+		ID:    callId,
+		Type:  "function",
+		Function: dto.FunctionResponse{
+			Name:      item.Name,
+			Arguments: "",
+		},
+	})
+}
+
+// pendingArguments 计算完整参数中尚未下发的部分
+func (s *ChatStreamConverter) pendingArguments(call *chatStreamToolCall, arguments string) string {
+	sent := call.sentArgs.String()
+	if arguments == "" || !strings.HasPrefix(arguments, sent) {
+		return ""
+	}
+	pending := arguments[len(sent):]
+	call.sentArgs.WriteString(pending)
+	return pending
+}
+
+func (s *ChatStreamConverter) toolCallChunk(toolCall dto.ToolCallResponse) *dto.ChatCompletionsStreamResponse {
+	return &dto.ChatCompletionsStreamResponse{
+		Id:     s.responseID,
+		Object: "chat.completion.chunk",
+		Model:  s.model,
+		Choices: []dto.ChatCompletionsStreamResponseChoice{
+			{
+				Index: 0,
+				Delta: dto.ChatCompletionsStreamResponseChoiceDelta{
+					ToolCalls: []dto.ToolCallResponse{toolCall},
+				},
+			},
+		},
+	}
+}
+
+// isChatStreamTerminalEvent 判断是否为结束 Chat 流式响应的 Responses 事件
+func isChatStreamTerminalEvent(eventType string) bool {
+	return helper.IsResponsesTerminalEvent(eventType) || eventType == "response.incomplete" || eventType == "response.failed"
+}
+
+// chatStreamIncludeUsage 判断转换后的 Chat 流式响应是否在结束前下发仅含用量的分块，与 OpenAI 一致，未指定 stream_options 时不下发
+func chatStreamIncludeUsage(request *dto.GeneralOpenAIRequest) bool {
+	return request != nil && request.StreamOptions != nil && request.StreamOptions.IncludeUsage
+}
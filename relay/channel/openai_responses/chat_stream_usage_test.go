@@ -0,0 +1,110 @@
+package openai_responses
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// chatUsageTranscript 一次以 response.completed 正常结束的上游 Responses 事件流
+const chatUsageTranscript = `{"type":"response.created","response":{"id":"resp_usage","object":"response","created_at":1700000000,"status":"in_progress","model":"gpt-5","output":[]}}
+{"type":"response.output_text.delta","item_id":"msg_usage","output_index":0,"content_index":0,"delta":"Hello"}
+{"type":"response.output_text.delta","item_id":"msg_usage","output_index":0,"content_index":0,"delta":" world"}
+{"type":"response.completed","response":{"id":"resp_usage","object":"response","created_at":1700000000,"status":"completed","model":"gpt-5","output":[],"usage":{"input_tokens":12,"output_tokens":3,"total_tokens":15}}}`
+
+// chatStreamChunk 客户端收到的 Chat Completions 流式分块，choices 与 usage 保留原始 JSON 以区分缺失、null 与空数组
+type chatStreamChunk struct {
+	Id      string                     `json:"id"`
+	Created int64                      `json:"created"`
+	Choices []map[string]any           `json:"choices"`
+	Usage   map[string]any             `json:"usage"`
+	Raw     map[string]json.RawMessage `json:"-"`
+}
+
+// replayChatUsageStream 回放录制的事件流，返回除 [DONE] 外的分块以及最后一个事件的数据
+func replayChatUsageStream(t *testing.T, originalRequest string) ([]chatStreamChunk, string) {
+	t.Helper()
+	result, err := ReplayStream(relaycommon.ConversionSourceChat, benchModel, originalRequest, chatUsageTranscript)
+	if err != nil {
+		t.Fatalf("replay stream: %v", err)
+	}
+	if result.Error != "" {
+		t.Fatalf("replay stream returned error: %s", result.Error)
+	}
+	if len(result.Events) == 0 {
+		t.Fatal("no events emitted")
+	}
+	chunks := make([]chatStreamChunk, 0, len(result.Events))
+	for _, event := range result.Events[:len(result.Events)-1] {
+		var chunk chatStreamChunk
+		if err := common.UnmarshalJsonStr(event.Data, &chunk); err != nil {
+			t.Fatalf("unmarshal chunk %q: %v", event.Data, err)
+		}
+		if err := common.UnmarshalJsonStr(event.Data, &chunk.Raw); err != nil {
+			t.Fatalf("unmarshal chunk %q: %v", event.Data, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, result.Events[len(result.Events)-1].Data
+}
+
+func TestChatStreamIncludeUsageEmitsUsageChunkBeforeDone(t *testing.T) {
+	chunks, last := replayChatUsageStream(t, `{"model":"gpt-5","stream":true,"stream_options":{"include_usage":true}}`)
+	if last != "[DONE]" {
+		t.Fatalf("last event = %q, want [DONE]", last)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected content chunks and a usage chunk, got %d chunks", len(chunks))
+	}
+
+	usageChunk := chunks[len(chunks)-1]
+	if string(usageChunk.Raw["choices"]) != "[]" {
+		t.Fatalf("usage chunk choices = %s, want []", usageChunk.Raw["choices"])
+	}
+	if usageChunk.Usage == nil {
+		t.Fatal("usage chunk has no usage")
+	}
+	for field, want := range map[string]float64{"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15} {
+		if got, _ := usageChunk.Usage[field].(float64); got != want {
+			t.Fatalf("usage.%s = %v, want %v", field, usageChunk.Usage[field], want)
+		}
+	}
+
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if string(chunk.Raw["usage"]) != "null" {
+			t.Fatalf("chunk %d carries usage %s, only the final usage chunk may", i, chunk.Raw["usage"])
+		}
+		if len(chunk.Choices) == 0 {
+			t.Fatalf("chunk %d has no choices", i)
+		}
+	}
+	for i, chunk := range chunks {
+		if chunk.Id != usageChunk.Id || chunk.Created != usageChunk.Created {
+			t.Fatalf("chunk %d id/created = %s/%d, want %s/%d", i, chunk.Id, chunk.Created, usageChunk.Id, usageChunk.Created)
+		}
+	}
+}
+
+func TestChatStreamWithoutIncludeUsageOmitsUsageChunk(t *testing.T) {
+	for name, request := range map[string]string{
+		"no stream_options": `{"model":"gpt-5","stream":true}`,
+		"include_usage off": `{"model":"gpt-5","stream":true,"stream_options":{"include_usage":false}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			chunks, last := replayChatUsageStream(t, request)
+			if last != "[DONE]" {
+				t.Fatalf("last event = %q, want [DONE]", last)
+			}
+			for i, chunk := range chunks {
+				if string(chunk.Raw["usage"]) != "null" {
+					t.Fatalf("chunk %d carries usage %s", i, chunk.Raw["usage"])
+				}
+				if len(chunk.Choices) == 0 {
+					t.Fatalf("chunk %d has no choices, usage chunk must not be sent", i)
+				}
+			}
+		})
+	}
+}
@@ -3,9 +3,16 @@ package openai_responses
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,10 +26,18 @@ import (
 //   - error: 转换失败时返回错误
 func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.ClaudeRequest, info *relaycommon.RelayInfo) (*dto.OpenAIResponsesRequest, error) {
 	if claudeRequest == nil {
-		return nil, fmt.Errorf("claude request is nil")
+		return nil, types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "claude request is nil")
 	}
 	if claudeRequest.Model == "" {
-		return nil, fmt.Errorf("model is required")
+		return nil, types.NewConvertError(types.ErrorCodeConvertModelMissing, http.StatusBadRequest, "model is required")
+	}
+	// 转换后的输入项按消息顺序排列，首条消息不是 user 或角色未交替时上游会返回难以理解的错误
+	if err := helper.ValidateClaudeMessageOrder(claudeRequest.Messages); err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "%s", err.Error())
+	}
+	// MCP 工具调用、服务端工具结果等内容块没有对应的 Responses 输入项，明确拒绝而不是静默丢弃
+	if blockType := service.FindClaudeNativeOnlyBlock(claudeRequest); blockType != "" {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "messages: %s content blocks require a native Claude channel and cannot be routed to an OpenAI Responses channel", blockType)
 	}
 
 	// 创建 Responses 请求对象
@@ -43,6 +58,21 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.ClaudeR
 	} else if claudeRequest.MaxTokensToSample > 0 {
 		responsesReq.MaxOutputTokens = claudeRequest.MaxTokensToSample
 	}
+	// 长输出请求以配置的长输出上限作为 max_output_tokens 的默认值与上限
+	if limit := uint(model_setting.GetClaudeSettings().LongOutputMaxTokens); info.LongOutput && limit > 0 {
+		if responsesReq.MaxOutputTokens == 0 || responsesReq.MaxOutputTokens > limit {
+			responsesReq.MaxOutputTokens = limit
+		}
+	}
+
+	// thinking 映射为 reasoning，推理摘要在响应中转换为 thinking 块
+	mapThinking := model_setting.GetResponsesSettings().MapClaudeThinking || service.IsTokenFeatureEnabled(c, service.FeatureFlagReasoningToThinking)
+	if mapThinking && claudeRequest.Thinking != nil && claudeRequest.Thinking.Type == "enabled" {
+		responsesReq.Reasoning = &dto.Reasoning{
+			Effort:  thinkingBudgetToReasoningEffort(claudeRequest.Thinking.GetBudgetTokens()),
+			Summary: "auto",
+		}
+	}
 
 	// 处理 Claude 特有的参数
 	if claudeRequest.TopK > 0 {
@@ -51,16 +81,18 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.ClaudeR
 	}
 
 	// 提取系统消息并设置为 instructions
+	var systemMediaContent []any
 	if claudeRequest.System != nil {
-		instructions, err := extractClaudeSystemMessage(claudeRequest.System)
+		instructions, systemMedia, err := extractClaudeSystemMessage(c, claudeRequest.System)
 		if err != nil {
-			return nil, fmt.Errorf("failed to extract system message: %w", err)
+			return nil, err
 		}
+		systemMediaContent = systemMedia
 		if instructions != "" {
 			// 将 instructions 序列化为 JSON RawMessage
 			instructionsBytes, err := json.Marshal(instructions)
 			if err != nil {
-				return nil, fmt.Errorf("failed to marshal instructions: %w", err)
+				return nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "failed to marshal instructions: %s", err.Error())
 			}
 			responsesReq.Instructions = json.RawMessage(instructionsBytes)
 		}
@@ -69,40 +101,64 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.ClaudeR
 	// 转换 messages 为 input 格式
 	inputs, err := convertClaudeMessagesToInputs(claudeRequest.Messages)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert claude messages to inputs: %w", err)
+		return nil, err
+	}
+
+	// system 中的图片与文档块作为首条输入消息发送，instructions 只能承载文本
+	if len(systemMediaContent) > 0 {
+		mediaInputs, err := convertClaudeMessagesToInputs([]dto.ClaudeMessage{{Role: "user", Content: systemMediaContent}})
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(mediaInputs, inputs...)
 	}
 
 	// 将 inputs 序列化为 JSON RawMessage
 	if len(inputs) > 0 {
 		inputData, err := json.Marshal(inputs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal inputs: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal inputs: %s", err.Error())
 		}
 		responsesReq.Input = json.RawMessage(inputData)
 	}
 
+	// container 对应 Anthropic 侧的代码执行容器，无法在 Responses 渠道复用
+	if len(claudeRequest.Container) > 0 && string(claudeRequest.Container) != "null" {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "container: container reuse is not supported when the request is routed to an OpenAI Responses channel")
+	}
+
 	// 处理 tools 参数
 	if claudeRequest.Tools != nil {
-		toolsData, err := json.Marshal(claudeRequest.Tools)
+		toolsData, err := convertClaudeToolsWithCache(c, claudeRequest.Tools)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal tools: %w", err)
+			return nil, err
 		}
-		responsesReq.Tools = json.RawMessage(toolsData)
+		responsesReq.Tools = toolsData
 	}
 
 	// 处理 tool_choice 参数
 	if claudeRequest.ToolChoice != nil {
-		toolChoiceData, err := json.Marshal(claudeRequest.ToolChoice)
+		toolChoice, parallelToolCalls, apiErr := service.ClaudeToolChoiceToResponses(claudeRequest.ToolChoice)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		toolChoiceData, err := json.Marshal(toolChoice)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal tool_choice: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "failed to marshal tool_choice: %s", err.Error())
 		}
 		responsesReq.ToolChoice = json.RawMessage(toolChoiceData)
+		if parallelToolCalls != nil {
+			parallelData, err := json.Marshal(parallelToolCalls)
+			if err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal parallel_tool_calls: %s", err.Error())
+			}
+			responsesReq.ParallelToolCalls = json.RawMessage(parallelData)
+		}
 	}
 
-	// 处理 stop_sequences 参数
-	if len(claudeRequest.StopSequences) > 0 {
-		// Responses API 可能使用不同的 stop 参数格式
-		// 这里可以转换为适当的格式或忽略
+	// 处理 stop_sequences 参数，渠道支持 text.stop 时透传，否则丢弃并记录
+	if err := service.ApplyResponsesStopSequences(c, info, responsesReq, claudeRequest.StopSequences); err != nil {
+		return nil, err
 	}
 
 	// 处理其他参数
@@ -110,19 +166,101 @@ func ClaudeMessagesToResponsesRequest(c *gin.Context, claudeRequest *dto.ClaudeR
 		responsesReq.Metadata = claudeRequest.Metadata
 	}
 
+	// Claude Code 的 metadata.user_id 包含会话 ID，作为会话级缓存标识的来源
+	var metadata dto.ClaudeMetadata
+	if len(claudeRequest.Metadata) > 0 {
+		_ = json.Unmarshal(claudeRequest.Metadata, &metadata)
+	}
+	service.ApplyPromptCacheKey(c, responsesReq, metadata.UserId)
+
+	// Responses 没有 cache_control，按断点推导缓存标识与保留时间
+	service.ApplyClaudeCacheControl(c, info, responsesReq)
+
+	// 按配置以 previous_response_id 代替重复发送的历史
+	service.ApplyManagedConversationState(c, info, responsesReq)
+
+	// 按配置将超出 token 预算的较早轮次丢弃或摘要
+	service.TrimConversationToBudget(c, info, responsesReq)
+
+	// 按配置压缩内联 base64 图片
+	service.OptimizeInlineImages(c, info, responsesReq)
+
+	// 处理 ?beta=true 与 anthropic-beta，Responses 渠道不会转发这些标记
+	if err := applyClaudeBetaPolicy(c, responsesReq); err != nil {
+		return nil, err
+	}
+
+	// 按上游限制提前校验工具定义，避免上游返回不指明工具的 400
+	if err := service.ValidateToolSchemaLimits(responsesReq.GetToolsMap()); err != nil {
+		return nil, err
+	}
+
+	// 客户端未通过 thinking 指定推理强度时使用模型配置的默认值
+	service.ApplyDefaultReasoningEffort(info, responsesReq)
+
+	// 请求体中的 betas 是 Anthropic 专有字段，Responses 上游无法识别，转发会导致 400，这里丢弃并记录警告
+	if len(claudeRequest.Betas) > 0 {
+		logger.LogWarn(c, fmt.Sprintf("claude request betas %v stripped: not supported when routed to an OpenAI Responses channel", claudeRequest.Betas))
+	}
+
 	return responsesReq, nil
 }
 
+// thinkingBudgetToReasoningEffort 按 Claude thinking 的 budget_tokens 选择 Responses 的推理强度
+func thinkingBudgetToReasoningEffort(budgetTokens int) string {
+	switch {
+	case budgetTokens <= 0:
+		return "medium"
+	case budgetTokens < 4096:
+		return "low"
+	case budgetTokens < 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// applyClaudeBetaPolicy 按 claude_beta_policy 处理 Claude beta 请求
+// 参数:
+//   - c: Gin 上下文
+//   - responsesReq: 转换后的 Responses 请求对象，map 模式下会被补充等价参数
+// 返回:
+//   - error: native 模式下请求无法由 Responses 渠道处理时返回错误
+func applyClaudeBetaPolicy(c *gin.Context, responsesReq *dto.OpenAIResponsesRequest) error {
+	if !service.IsClaudeBetaRequest(c) {
+		return nil
+	}
+	switch model_setting.GetResponsesSettings().GetClaudeBetaPolicy() {
+	case model_setting.ClaudeBetaPolicyNative:
+		return types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "anthropic-beta: beta features require a native Claude channel, but the request was routed to an OpenAI Responses channel")
+	case model_setting.ClaudeBetaPolicyMap:
+		for _, feature := range service.GetClaudeBetaFeatures(c) {
+			// interleaved-thinking 对应 Responses 的推理摘要输出
+			if strings.HasPrefix(feature, "interleaved-thinking") {
+				if responsesReq.Reasoning == nil {
+					responsesReq.Reasoning = &dto.Reasoning{}
+				}
+				if responsesReq.Reasoning.Summary == "" {
+					responsesReq.Reasoning.Summary = "auto"
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // extractClaudeSystemMessage 从 Claude 的 system 字段提取系统消息
-// Claude 的 system 字段可能是字符串或复杂结构
+// Claude 的 system 字段可能是字符串或内容块数组：文本块合并为 instructions，图片与文档块无法放入 instructions，作为非文本内容返回，其他无法展示的块丢弃并记录警告
 // 参数:
+//   - c: Gin 上下文，用于记录警告
 //   - system: Claude 请求的 system 字段
 // 返回:
 //   - string: 提取的系统消息内容
+//   - []any: system 中的图片与文档块，需要作为输入消息发送
 //   - error: 提取失败时返回错误
-func extractClaudeSystemMessage(system any) (string, error) {
+func extractClaudeSystemMessage(c *gin.Context, system any) (string, []any, error) {
 	if system == nil {
-		return "", nil
+		return "", nil, nil
 	}
 
 	// 如果是字符串，直接返回
@@ -131,21 +269,51 @@ func extractClaudeSystemMessage(system any) (string, error) {
 		if !isValidUTF8String(str) {
 			str = cleanInvalidUTF8Chars(str)
 		}
-		return str, nil
+		return str, nil, nil
 	}
 
-	// 如果是复杂类型，尝试转换为字符串
+	// 复杂类型统一解析为内容块数组，单个对象视为只有一个块
 	systemBytes, err := json.Marshal(system)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal system message: %w", err)
+		return "", nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "failed to marshal system message: %s", err.Error())
+	}
+	var blocks []any
+	if err := json.Unmarshal(systemBytes, &blocks); err != nil {
+		var block map[string]any
+		if err := json.Unmarshal(systemBytes, &block); err != nil {
+			return "", nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "system must be a string or an array of content blocks")
+		}
+		blocks = []any{block}
 	}
 
-	// 验证生成的JSON是否有效
-	if !isValidUTF8Bytes(systemBytes) {
-		systemBytes = cleanInvalidUTF8Bytes(systemBytes)
+	var texts []string
+	var media []any
+	for i, item := range blocks {
+		switch block := item.(type) {
+		case string:
+			texts = append(texts, block)
+		case map[string]any:
+			blockType, _ := block["type"].(string)
+			switch blockType {
+			case "text":
+				if text, _ := block["text"].(string); text != "" {
+					texts = append(texts, text)
+				}
+			case "image", "document":
+				media = append(media, block)
+			default:
+				logger.LogWarn(c, fmt.Sprintf("system[%d]: content block type %q cannot be displayed on an OpenAI Responses channel, dropped", i, blockType))
+			}
+		default:
+			logger.LogWarn(c, fmt.Sprintf("system[%d]: unexpected content block %T, dropped", i, item))
+		}
 	}
 
-	return string(systemBytes), nil
+	instructions := strings.Join(texts, "\n\n")
+	if !isValidUTF8String(instructions) {
+		instructions = cleanInvalidUTF8Chars(instructions)
+	}
+	return instructions, media, nil
 }
 
 // convertClaudeMessagesToInputs 将 Claude Messages API 的 messages 转换为 Responses API 的 inputs 格式
@@ -177,22 +345,22 @@ func convertClaudeMessagesToInputs(messages []dto.ClaudeMessage) ([]dto.Input, e
 				}
 				contentBytes, err = json.Marshal(str)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal string content: %w", err)
+					return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal string content: %s", err.Error())
 				}
 			} else {
 				// 如果 content 是复杂类型，需要转换 Claude 的 content type 到 Responses 格式
 				convertedContent, err := convertClaudeContentToResponses(message.Content)
 				if err != nil {
-					return nil, fmt.Errorf("failed to convert claude content to responses format: %w", err)
+					return nil, err
 				}
 				contentBytes, err = json.Marshal(convertedContent)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal converted content: %w", err)
+					return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "failed to marshal converted content: %s", err.Error())
 				}
 
 				// 验证生成的JSON是否有效
 				if !isValidUTF8Bytes(contentBytes) {
-					return nil, fmt.Errorf("generated JSON contains invalid UTF-8 characters")
+					return nil, types.NewConvertError(types.ErrorCodeConvertContentEncoding, http.StatusBadRequest, "message content contains invalid UTF-8 characters")
 				}
 			}
 			input.Content = json.RawMessage(contentBytes)
@@ -220,9 +388,21 @@ func convertClaudeContentToResponses(content any) (any, error) {
 				// 转换 type 字段
 				if typeVal, ok := newItem["type"].(string); ok {
 					switch typeVal {
+					case "thinking":
+						// 不带签名的思考块由本网关从 Responses 推理摘要转换而来，推理状态保存在上游，转发时丢弃；
+						// 带签名的思考块来自 Claude 上游，签名无法在 Responses 中延续推理，明确拒绝
+						if signature, _ := newItem["signature"].(string); signature != "" {
+							return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "content block type thinking with a signature cannot be sent to an OpenAI Responses channel")
+						}
+						continue
+					case "redacted_thinking":
+						return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "content block type redacted_thinking cannot be sent to an OpenAI Responses channel")
 					case "text":
 						newItem["type"] = "input_text"
 					case "image":
+						if err := checkClaudeImageSize(newItem); err != nil {
+							return nil, err
+						}
 						newItem["type"] = "input_image"
 					// 可以在这里添加其他类型的映射
 					}
@@ -238,4 +418,53 @@ func convertClaudeContentToResponses(content any) (any, error) {
 	
 	// 如果不是数组，直接返回（可能是字符串或其他格式，虽然通常是数组）
 	return content, nil
-}
\ No newline at end of file
+}
+// maxConvertImageBytes 转换到 Responses 渠道时单张内联图片允许的最大字节数
+const maxConvertImageBytes = 20 * 1024 * 1024
+
+// checkClaudeImageSize 校验 Claude base64 图片的解码后大小
+// 返回:
+//   - error: 超过 maxConvertImageBytes 时返回 CONVERT_IMAGE_TOO_LARGE 错误
+func checkClaudeImageSize(item map[string]interface{}) error {
+	source, ok := item["source"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	data, _ := source["data"].(string)
+	if size := len(data) / 4 * 3; size > maxConvertImageBytes {
+		return types.NewConvertError(types.ErrorCodeConvertImageTooLarge, http.StatusRequestEntityTooLarge, "image exceeds the maximum size of %d bytes (got about %d bytes)", maxConvertImageBytes, size)
+	}
+	return nil
+}
+
+// convertClaudeCodeExecutionTools 将 Claude 代码执行工具模拟为 Responses 的 code_interpreter 工具
+// 参数:
+//   - tools: Claude 请求中的 tools
+// 返回:
+//   - any: 转换后的 tools，不含代码执行工具时原样返回
+//   - error: 未启用模拟且包含代码执行工具时返回错误
+func convertClaudeCodeExecutionTools(tools any) (any, error) {
+	toolList, ok := tools.([]any)
+	if !ok {
+		return tools, nil
+	}
+	converted := make([]any, 0, len(toolList))
+	for _, tool := range toolList {
+		toolMap, ok := tool.(map[string]any)
+		toolType, _ := toolMap["type"].(string)
+		if !ok || !strings.HasPrefix(toolType, "code_execution_") {
+			converted = append(converted, tool)
+			continue
+		}
+		if !model_setting.GetResponsesSettings().EmulateCodeExecution {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolUnsupported, http.StatusBadRequest, "tools: %s is not supported when the request is routed to an OpenAI Responses channel", toolType)
+		}
+		converted = append(converted, map[string]any{
+			"type": "code_interpreter",
+			"container": map[string]any{
+				"type": "auto",
+			},
+		})
+	}
+	return converted, nil
+}
@@ -3,7 +3,6 @@ package openai_responses
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"unicode/utf8"
@@ -14,6 +13,7 @@ import (
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -24,22 +24,17 @@ import (
 func ResponsesToClaudeHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	defer service.CloseResponseBodyGracefully(resp)
 
-	// 获取原始请求（用于转换时参考）
-	originalRequest, exists := c.Get("original_claude_request")
-	if !exists {
-		return nil, types.NewError(fmt.Errorf("original claude request not found"), types.ErrorCodeInvalidRequest)
+	// 获取原始请求（用于转换时参考），丢失时按 RelayInfo 重建，不因此让整个请求失败
+	claudeRequest, rebuilt := info.OriginalClaudeRequest()
+	if rebuilt {
+		logger.LogWarn(c, fmt.Sprintf("original claude request not found (%T), rebuilt from relay info", info.OriginalRequest))
 	}
 
-	claudeRequest, ok := originalRequest.(*dto.ClaudeRequest)
-	if !ok {
-		return nil, types.NewError(fmt.Errorf("invalid original request type"), types.ErrorCodeInvalidRequest)
-	}
-
-	// 读取 Responses API 响应
+	// 读取 Responses API 响应，上游为流式时先聚合为非流式响应体
 	var responsesResponse dto.OpenAIResponsesResponse
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	responseBody, apiErr := helper.ReadResponsesBody(info, resp)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
 	// 检查并清理响应体中的无效UTF-8字符
@@ -50,7 +45,15 @@ func ResponsesToClaudeHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 	// 将响应体存储到 relayInfo 中
 	info.ResponseBody = string(responseBody)
 
-	err = common.Unmarshal(responseBody, &responsesResponse)
+	// 严格校验上游响应结构，不符合预期时隔离并原样透传，避免输出不完整的转换结果
+	if model_setting.GetResponsesSettings().StrictSchemaValidation {
+		if schemaErr := service.ValidateResponsesPayload(responseBody); schemaErr != nil {
+			service.QuarantineResponsesPayload(info.ChannelId, schemaErr.Error(), responseBody)
+			return service.PassthroughResponsesPayload(c, resp, info, responseBody), nil
+		}
+	}
+
+	err := common.Unmarshal(responseBody, &responsesResponse)
 	if err != nil {
 		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
 	}
@@ -66,6 +69,20 @@ func ResponsesToClaudeHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		logger.LogError(c, fmt.Sprintf("Failed to convert responses to claude format: %v", err))
 		return nil, types.NewError(err, types.ErrorCodeBadResponse)
 	}
+	MarkImageGenerationCalls(c, &responsesResponse)
+
+	// 实验性：Responses 不支持 stop_sequences，由网关按停止序列截断输出
+	if service.IsTokenFeatureEnabled(c, service.FeatureFlagStopEmulation) {
+		applyClaudeStopSequences(claudeResponse, claudeRequest.StopSequences)
+	}
+
+	// 按运营配置对回复文本做后处理
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	for i := range claudeResponse.Content {
+		if claudeResponse.Content[i].Type == "text" && claudeResponse.Content[i].GetText() != "" {
+			claudeResponse.Content[i].SetText(postProcessor.Process(claudeResponse.Content[i].GetText()))
+		}
+	}
 
 	// 序列化 Claude 响应
 	jsonData, err := json.Marshal(claudeResponse)
@@ -78,7 +95,8 @@ func ResponsesToClaudeHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		jsonData = cleanInvalidUTF8Bytes(jsonData)
 	}
 
-	// 写入转换后的响应体
+	// 按配置将上游 ID 替换为网关 ID 后写入转换后的响应体
+	jsonData = service.NewResponseIdMapper(info).Rewrite(jsonData)
 	service.IOCopyBytesGracefully(c, resp, jsonData)
 
 	// 计算使用量
@@ -87,6 +105,14 @@ func ResponsesToClaudeHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		usage.PromptTokens = responsesResponse.Usage.InputTokens
 		usage.CompletionTokens = responsesResponse.Usage.OutputTokens
 		usage.TotalTokens = responsesResponse.Usage.TotalTokens
+		// 按 Claude 计费口径，提示 token 不含缓存命中部分，命中部分按缓存倍率单独计费
+		claudeUsage := claudeUsageFromResponses(responsesResponse.Usage)
+		usage.PromptTokens = claudeUsage.InputTokens
+		usage.PromptTokensDetails.CachedTokens = claudeUsage.CacheReadInputTokens
+	}
+
+	if responsesResponse.Status == "completed" {
+		service.SaveManagedConversationState(info, responsesResponse.ID)
 	}
 
 	return &usage, nil
@@ -103,54 +129,136 @@ func ResponsesToClaudeStreamHandler(c *gin.Context, info *relaycommon.RelayInfo,
 	defer service.CloseResponseBodyGracefully(resp)
 
 	var usage = &dto.Usage{}
-	var responseTextBuilder strings.Builder
+	responseTextCounter := service.NewStreamTextCounter(info.UpstreamModelName)
+	info.StreamTokenCounter = responseTextCounter
 
 	// 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewRelayStreamBodyCapture(info)
+
+	// 流式增量去重
+	dedupGuard := helper.NewStreamDedupGuard()
+
+	// 网关输出 token 上限
+	outputBudget := service.NewOutputTokenBudget(info)
 
-	// 获取响应ID，用于流式响应
-	var responseID string
+	// 周期性发送累计输出 token 数
+	usageTracker := helper.NewClaudeUsageDeltaTracker()
 
-	// 用于跟踪是否已发送 message_start 事件
-	messageStartSent := false
+	// 回复文本后处理，按行缓存增量
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+
+	// 实验性：由网关模拟 stop_sequences，并合并细碎的文本增量
+	var stopMatcher *helper.StopSequenceMatcher
+	if service.IsTokenFeatureEnabled(c, service.FeatureFlagStopEmulation) {
+		claudeRequest, _ := info.OriginalClaudeRequest()
+		stopMatcher = helper.NewStopSequenceMatcher(claudeRequest.StopSequences)
+	}
+	coalescer := helper.NewDeltaCoalescer(service.IsTokenFeatureEnabled(c, service.FeatureFlagDeltaCoalescing))
+
+	// 按输出项跟踪内容块的转换器，推理摘要、文本与工具调用依次转换为独立的 Claude 内容块
+	converter := NewClaudeStreamConverter(info.UpstreamModelName)
+	// 上游流中途中断时从上游保存的响应恢复
+	resume := newStreamResumeTracker()
+	// 按配置校验发出的事件顺序
+	validator := helper.NewClaudeStreamValidator(c, info)
+	// 按配置将上游 ID 替换为网关 ID
+	idMapper := service.NewResponseIdMapper(info)
+	send := func(events []dto.ClaudeResponse) {
+		for _, event := range events {
+			if event.Message != nil {
+				event.Message.Id = idMapper.GatewayId(event.Message.Id)
+			}
+			validator.Observe(&event)
+			sendClaudeStreamData(c, event)
+		}
+	}
+
+	// 依次经过后处理与增量合并后下发文本
+	sendText := func(event *dto.ResponsesStreamResponse, text string) {
+		if processed := coalescer.Push(postProcessor.Push(text)); processed != "" {
+			send(converter.Text(event, processed))
+		}
+	}
+	// 等待超时的合并文本追加到当前的文本块
+	coalescer.OnTimeout(c, func(text string) {
+		send(converter.Text(nil, text))
+	})
+	// 缓存的剩余文本追加到当前的文本块
+	flushPostProcessor := func() {
+		rest := coalescer.Push(postProcessor.Push(stopMatcher.Flush()))
+		rest += coalescer.Push(postProcessor.Flush())
+		rest += coalescer.Flush()
+		send(converter.Text(nil, rest))
+	}
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		// 收集流式响应数据
-		fullStreamResponse.WriteString(data)
-		fullStreamResponse.WriteString("\n")
+		fullStreamResponse.Append(data)
 
 		// 解析 Responses API 流式响应
 		var streamResponse dto.ResponsesStreamResponse
 		if err := common.UnmarshalJsonStr(data, &streamResponse); err == nil {
-			// 获取响应ID
-			if streamResponse.Response != nil && streamResponse.Response.ID != "" {
-				responseID = streamResponse.Response.ID
+			terminal := helper.IsResponsesTerminalEvent(streamResponse.Type) || streamResponse.Type == "response.incomplete"
+			resume.Observe(&streamResponse)
+			dedupGuard.Observe(&streamResponse)
+
+			// 切换到其他输出项或结束前先输出缓存的剩余文本
+			if terminal || streamResponse.Type == "response.reasoning_summary_text.delta" || streamResponse.Type == "response.output_item.done" {
+				flushPostProcessor()
 			}
 
-			// 如果是第一次收到有效数据，发送 message_start 事件
-			if !messageStartSent && responseID != "" {
-				// 发送 message_start 事件
-				sendClaudeMessageStart(c, responseID, info.UpstreamModelName)
-				// 发送 content_block_start 事件
-				sendClaudeContentBlockStart(c, 0)
-				messageStartSent = true
+			// 首个携带响应 ID 的事件发送 message_start，推理摘要、工具调用与生成的图片转换为对应的内容块
+			send(converter.Handle(&streamResponse))
+			if streamResponse.Type == "response.output_item.done" {
+				MarkImageGenerationCall(c, streamResponse.Item)
+			}
+			if streamResponse.Type == "response.reasoning_summary_text.delta" {
+				responseTextCounter.WriteString(streamResponse.Delta)
+			}
+			if streamResponse.Type == "response.output_item.done" && streamResponse.Item != nil && streamResponse.Item.Type == dto.ResponsesOutputTypeFunctionCall {
+				responseTextCounter.WriteString(streamResponse.Item.Arguments)
 			}
 
 			// 处理输出文本增量
+			if streamResponse.Type == "response.output_text.delta" {
+				streamResponse.Delta = dedupGuard.Filter(&streamResponse)
+				// 超出输出上限时以 max_tokens 结束并终止流
+				if !outputBudget.Consume(streamResponse.Delta) {
+					flushPostProcessor()
+					send(converter.Finish("max_tokens", "", usageTracker.Final(0, responseTextCounter.TokenCount())))
+					resume.End()
+					return false
+				}
+			}
 			if streamResponse.Type == "response.output_text.delta" && streamResponse.Delta != "" {
+				responseTextCounter.WriteString(streamResponse.Delta)
+				resume.Deliver(streamResponse.Delta)
+				text, stopped := stopMatcher.Push(streamResponse.Delta)
 				// 发送 content_block_delta 事件
-				sendClaudeContentBlockDelta(c, 0, streamResponse.Delta)
-				responseTextBuilder.WriteString(streamResponse.Delta)
+				sendText(&streamResponse, text)
+				// 命中停止序列时以 stop_sequence 结束并终止流
+				if stopped {
+					flushPostProcessor()
+					send(converter.Finish("stop_sequence", stopMatcher.Matched(), usageTracker.Final(0, responseTextCounter.TokenCount())))
+					resume.End()
+					return false
+				}
+				if usageDelta := usageTracker.Next(responseTextCounter.TokenCount()); usageDelta != nil {
+					send([]dto.ClaudeResponse{*usageDelta})
+				}
 			}
 
 			// 处理使用量统计
-			if streamResponse.Type == "response.done" && streamResponse.Response != nil {
-				// 发送 content_block_stop 事件
-				sendClaudeContentBlockStop(c, 0)
-				// 发送 message_delta 事件 (包含 stop_reason)
-				sendClaudeMessageDelta(c, "end_turn", streamResponse.Response.Usage)
-				// 发送 message_stop 事件
-				sendClaudeMessageStop(c)
+			if terminal && streamResponse.Response != nil {
+				if streamResponse.Response.Status == "completed" {
+					service.SaveManagedConversationState(info, streamResponse.Response.ID)
+				}
+				// 关闭内容块并发送 message_delta (包含 stop_reason) 与 message_stop 事件
+				upstreamOutputTokens := 0
+				if streamResponse.Response.Usage != nil {
+					upstreamOutputTokens = streamResponse.Response.Usage.OutputTokens
+				}
+				send(converter.Complete(streamResponse.Response, usageTracker.Final(upstreamOutputTokens, responseTextCounter.TokenCount())))
 
 				// 更新使用量
 				if streamResponse.Response.Usage != nil {
@@ -163,6 +271,9 @@ func ResponsesToClaudeStreamHandler(c *gin.Context, info *relaycommon.RelayInfo,
 					if streamResponse.Response.Usage.TotalTokens != 0 {
 						usage.TotalTokens = streamResponse.Response.Usage.TotalTokens
 					}
+					if streamResponse.Response.Usage.InputTokensDetails != nil {
+						usage.PromptTokensDetails.CachedTokens = streamResponse.Response.Usage.InputTokensDetails.CachedTokens
+					}
 				}
 			}
 		} else {
@@ -170,55 +281,95 @@ func ResponsesToClaudeStreamHandler(c *gin.Context, info *relaycommon.RelayInfo,
 		}
 		return true
 	})
+	coalescer.Stop()
+
+	// 上游流中途中断时补发最终响应中尚未下发的文本与函数调用并正常结束
+	if result, ok := resume.Recover(c, info); ok {
+		recovered := result.Response
+		if result.Tail != "" {
+			responseTextCounter.WriteString(result.Tail)
+			text, _ := stopMatcher.Push(result.Tail)
+			sendText(nil, text)
+		}
+		flushPostProcessor()
+		for _, call := range result.ToolCalls {
+			for _, event := range call.Events() {
+				send(converter.Handle(&event))
+			}
+			responseTextCounter.WriteString(call.Item.Arguments)
+		}
+		upstreamOutputTokens := 0
+		if recovered.Usage != nil {
+			upstreamOutputTokens = recovered.Usage.OutputTokens
+			usage.PromptTokens = recovered.Usage.InputTokens
+			usage.CompletionTokens = recovered.Usage.OutputTokens
+			if recovered.Usage.InputTokensDetails != nil {
+				usage.PromptTokensDetails.CachedTokens = recovered.Usage.InputTokensDetails.CachedTokens
+			}
+		}
+		send(converter.Complete(recovered, usageTracker.Final(upstreamOutputTokens, responseTextCounter.TokenCount())))
+	}
+
+	// 上游未返回完成事件时补发 message_delta 与 message_stop，避免客户端一直等待
+	flushPostProcessor()
+	send(converter.Finish("end_turn", "", usageTracker.Final(0, responseTextCounter.TokenCount())))
+	validator.Finish()
 
 	// 将完整的流式响应体存储到 relayInfo 中
 	info.ResponseBody = fullStreamResponse.String()
 
 	// 备用 token 计算
-	if usage.CompletionTokens == 0 {
-		tempStr := responseTextBuilder.String()
-		if len(tempStr) > 0 {
-			completionTokens := service.CountTextToken(tempStr, info.UpstreamModelName)
-			usage.CompletionTokens = completionTokens
-		}
+	if usage.CompletionTokens == 0 && !responseTextCounter.IsEmpty() {
+		usage.CompletionTokens = responseTextCounter.TokenCount()
 	}
 
 	if usage.PromptTokens == 0 && usage.CompletionTokens != 0 {
 		usage.PromptTokens = info.PromptTokens
 	}
 
+	// 按 Claude 计费口径，提示 token 不含缓存命中部分
+	usage.PromptTokens = max(usage.PromptTokens-usage.PromptTokensDetails.CachedTokens, 0)
 	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 
 	return usage, nil
 }
 
+// claudeUsageFromResponses 将 Responses 的输入用量转换为 Claude 用量
+// Claude 的 input_tokens 不含缓存命中部分，命中部分计入 cache_read_input_tokens；Responses 不区分缓存写入，cache_creation_input_tokens 为 0
+func claudeUsageFromResponses(usage *dto.Usage) *dto.ClaudeUsage {
+	claudeUsage := &dto.ClaudeUsage{InputTokens: usage.InputTokens}
+	if usage.InputTokensDetails != nil && usage.InputTokensDetails.CachedTokens > 0 {
+		claudeUsage.CacheReadInputTokens = usage.InputTokensDetails.CachedTokens
+		claudeUsage.InputTokens = max(usage.InputTokens-usage.InputTokensDetails.CachedTokens, 0)
+	}
+	return claudeUsage
+}
+
 // ResponsesToClaudeResponse 将 Responses API 响应转换为 Claude Messages 格式
 func ResponsesToClaudeResponse(responsesResponse *dto.OpenAIResponsesResponse, originalRequest *dto.ClaudeRequest) (*dto.ClaudeResponse, error) {
 	if responsesResponse == nil {
-		return nil, fmt.Errorf("responses response is nil")
+		return nil, types.NewConvertError(types.ErrorCodeConvertResponseInvalid, http.StatusInternalServerError, "responses response is nil")
 	}
 
-	// 提取内容
-	content := extractContentFromOutput(responsesResponse.Output)
+	// 按 output 顺序构建 content 数组
+	contentList := responsesOutputToClaudeContent(responsesResponse.Output)
 
-	// 确定 finish_reason
+	// 确定 stop_reason，正常结束且包含工具调用时为 tool_use
 	stopReason := extractClaudeStopReason(responsesResponse.Status)
-
-	// 构建 content 数组
-	contentList := []dto.ClaudeMediaMessage{
-		{
-			Type: "text",
-			Text: &content,
-		},
+	if stopReason == "end_turn" {
+		for _, block := range contentList {
+			if block.Type == "tool_use" {
+				stopReason = "tool_use"
+				break
+			}
+		}
 	}
 
 	// 构建使用量
 	var usage *dto.ClaudeUsage
 	if responsesResponse.Usage != nil {
-		usage = &dto.ClaudeUsage{
-			InputTokens:  responsesResponse.Usage.InputTokens,
-			OutputTokens: responsesResponse.Usage.OutputTokens,
-		}
+		usage = claudeUsageFromResponses(responsesResponse.Usage)
+		usage.OutputTokens = responsesResponse.Usage.OutputTokens
 	}
 
 	// 构建 Claude 响应
@@ -235,109 +386,111 @@ func ResponsesToClaudeResponse(responsesResponse *dto.OpenAIResponsesResponse, o
 	return claudeResponse, nil
 }
 
-// extractClaudeStopReason 根据 Responses API 的状态确定 Claude 的 stop_reason
-func extractClaudeStopReason(status string) string {
-	switch status {
-	case "completed":
-		return "end_turn"
-	case "incomplete":
-		return "max_tokens"
-	default:
-		return "end_turn"
+// applyClaudeStopSequences 在 text 块中查找最早出现的停止序列，截断该处之后的全部内容并以 stop_sequence 结束
+func applyClaudeStopSequences(claudeResponse *dto.ClaudeResponse, stops []string) {
+	if len(stops) == 0 {
+		return
+	}
+	for i := range claudeResponse.Content {
+		if claudeResponse.Content[i].Type != "text" {
+			continue
+		}
+		index, stop := helper.FindStopSequence(claudeResponse.Content[i].GetText(), stops)
+		if index < 0 {
+			continue
+		}
+		claudeResponse.Content[i].SetText(claudeResponse.Content[i].GetText()[:index])
+		claudeResponse.Content = claudeResponse.Content[:i+1]
+		claudeResponse.StopReason = "stop_sequence"
+		claudeResponse.StopSequence = &stop
+		return
 	}
 }
 
-// sendClaudeMessageStart 发送 message_start 事件
-func sendClaudeMessageStart(c *gin.Context, id string, model string) {
-	usage := &dto.ClaudeUsage{
-		InputTokens:  0,
-		OutputTokens: 0,
-	}
-	message := &dto.ClaudeMediaMessage{
-		Type:  "message",
-		Model: model,
-		Role:  "assistant",
-		Usage: usage, // 添加 usage 字段到 message 对象
+// responsesOutputToClaudeContent 按 output 数组顺序将 Responses 输出转换为 Claude content 块
+// reasoning 摘要转换为 thinking 块，message 文本转换为 text 块，function_call 转换为 tool_use 块
+func responsesOutputToClaudeContent(output []dto.ResponsesOutput) []dto.ClaudeMediaMessage {
+	var contentList []dto.ClaudeMediaMessage
+	for _, item := range output {
+		switch item.Type {
+		case dto.ResponsesOutputTypeReasoning:
+			var summaries []string
+			for _, summary := range item.Summary {
+				if summary.Text != "" {
+					summaries = append(summaries, summary.Text)
+				}
+			}
+			if len(summaries) == 0 {
+				continue
+			}
+			thinking := strings.Join(summaries, "\n\n")
+			contentList = append(contentList, dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: &thinking,
+			})
+		case dto.ResponsesOutputTypeMessage:
+			if item.Role != "assistant" {
+				continue
+			}
+			for _, contentItem := range item.Content {
+				if contentItem.Type != "output_text" {
+					continue
+				}
+				block := dto.ClaudeMediaMessage{Type: "text"}
+				block.SetText(contentItem.Text)
+				contentList = append(contentList, block)
+			}
+		case dto.ResponsesOutputTypeFunctionCall:
+			// arguments 不是合法 JSON 对象时使用空对象，保证 input 字段结构正确
+			input := map[string]any{}
+			if item.Arguments != "" {
+				if err := common.UnmarshalJsonStr(item.Arguments, &input); err != nil {
+					input = map[string]any{}
+				}
+			}
+			contentList = append(contentList, dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    claudeToolUseId(item.CallId),
+				Name:  item.Name,
+				Input: input,
+			})
+		case dto.ResponsesOutputTypeImageGenerationCall:
+			if item.Result != "" {
+				contentList = append(contentList, generatedImageClaudeBlock(&item))
+			}
+		}
 	}
-	resp := dto.ClaudeResponse{
-		Type:    "message_start",
-		Message: message,
-		Usage:   usage,
+	// 没有任何输出时保留一个空文本块，与原有响应结构一致
+	if len(contentList) == 0 {
+		block := dto.ClaudeMediaMessage{Type: "text"}
+		block.SetText("")
+		contentList = append(contentList, block)
 	}
-	sendClaudeStreamData(c, resp)
-}
-
-// sendClaudeContentBlockStart 发送 content_block_start 事件
-func sendClaudeContentBlockStart(c *gin.Context, index int) {
-	text := ""
-	resp := dto.ClaudeResponse{
-		Type: "content_block_start",
-		ContentBlock: &dto.ClaudeMediaMessage{
-			Type: "text",
-			Text: &text,
-		},
-	}
-	resp.SetIndex(index)
-	sendClaudeStreamData(c, resp)
-}
-
-// sendClaudeContentBlockDelta 发送 content_block_delta 事件
-func sendClaudeContentBlockDelta(c *gin.Context, index int, delta string) {
-	resp := dto.ClaudeResponse{
-		Type: "content_block_delta",
-		Delta: &dto.ClaudeMediaMessage{
-			Type: "text_delta",
-			Text: &delta,
-		},
-	}
-	resp.SetIndex(index)
-	sendClaudeStreamData(c, resp)
+	return contentList
 }
 
-// sendClaudeContentBlockStop 发送 content_block_stop 事件
-func sendClaudeContentBlockStop(c *gin.Context, index int) {
-	resp := dto.ClaudeResponse{
-		Type: "content_block_stop",
+// claudeToolUseId 使用 function_call 的 call_id 作为 tool_use 的 id，上游未返回 call_id 时生成一个
+// 后续请求中 tool_result 的 tool_use_id 会原样转换为 function_call_output 的 call_id
+func claudeToolUseId(callId string) string {
+	if callId != "" {
+		return callId
 	}
-	resp.SetIndex(index)
-	sendClaudeStreamData(c, resp)
+	return "toolu_" + common.GetRandomString(24)
 }
 
-// sendClaudeMessageDelta 发送 message_delta 事件
-func sendClaudeMessageDelta(c *gin.Context, stopReason string, usage *dto.Usage) {
-	outputTokens := 0
-	if usage != nil {
-		outputTokens = usage.OutputTokens
-	}
-	resp := dto.ClaudeResponse{
-		Type: "message_delta",
-		Delta: &dto.ClaudeMediaMessage{
-			StopReason: &stopReason,
-		},
-		Usage: &dto.ClaudeUsage{
-			OutputTokens: outputTokens,
-		},
-	}
-	sendClaudeStreamData(c, resp)
-}
-
-// sendClaudeMessageStop 发送 message_stop 事件
-func sendClaudeMessageStop(c *gin.Context) {
-	resp := dto.ClaudeResponse{
-		Type: "message_stop",
+// extractClaudeStopReason 根据 Responses API 的状态确定 Claude 的 stop_reason
+func extractClaudeStopReason(status string) string {
+	switch status {
+	case "completed":
+		return "end_turn"
+	case "incomplete":
+		return "max_tokens"
+	default:
+		return "end_turn"
 	}
-	sendClaudeStreamData(c, resp)
 }
 
-// sendClaudeStreamData 发送 Claude 流式数据
+// sendClaudeStreamData 发送 Claude 流式数据，每个数据帧前带 event 行
 func sendClaudeStreamData(c *gin.Context, response dto.ClaudeResponse) {
-	jsonData, err := json.Marshal(response)
-	if err != nil {
-		logger.LogError(c, fmt.Sprintf("Failed to marshal claude stream response: %v", err))
-		return
-	}
-	// Claude 流式格式：event: type\ndata: json\n\n
-	c.Writer.WriteString(fmt.Sprintf("event: %s\n", response.Type))
-	c.Writer.WriteString(fmt.Sprintf("data: %s\n\n", string(jsonData)))
-	c.Writer.Flush()
+	_ = helper.ClaudeData(c, response)
 }
\ No newline at end of file
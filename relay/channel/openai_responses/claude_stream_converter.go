@@ -0,0 +1,273 @@
+package openai_responses
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+const (
+	claudeBlockText     = "text"
+	claudeBlockThinking = "thinking"
+)
+
+// ClaudeStreamConverter Responses 流式事件到 Claude Messages 流式事件的有状态转换器
+// 按 output_index / content_index 跟踪当前打开的内容块，推理摘要、文本与工具调用可以任意交替出现，
+// 每个输出项对应独立的 Claude 内容块，块索引按出现顺序递增。转换器只生成事件，由调用方负责发送，
+// 文本增量需要调用方处理（后处理、停止序列等）后通过 Text 转换
+type ClaudeStreamConverter struct {
+	model string
+
+	started bool
+	stopped bool
+
+	nextIndex int
+	// 当前打开的块，openIndex 为 -1 时没有打开的块
+	openIndex   int
+	openKind    string
+	openKey     string // 所属输出项
+	openContent int    // 所属内容分段，仅文本块使用
+	openHasText bool
+
+	hasToolUse bool
+}
+
+// NewClaudeStreamConverter 创建转换器，model 为 message_start 中返回的模型名
+func NewClaudeStreamConverter(model string) *ClaudeStreamConverter {
+	return &ClaudeStreamConverter{model: model, openIndex: -1}
+}
+
+// Started 是否已生成 message_start
+func (s *ClaudeStreamConverter) Started() bool {
+	return s.started
+}
+
+// Stopped 是否已生成 message_stop
+func (s *ClaudeStreamConverter) Stopped() bool {
+	return s.stopped
+}
+
+// Handle 转换推理摘要、工具调用与输出项完成等结构性事件，首个携带响应 ID 的事件生成 message_start
+// 文本增量不在此处理，见 Text
+func (s *ClaudeStreamConverter) Handle(event *dto.ResponsesStreamResponse) []dto.ClaudeResponse {
+	if event == nil || s.stopped {
+		return nil
+	}
+	var events []dto.ClaudeResponse
+	if !s.started && event.Response != nil && event.Response.ID != "" {
+		events = append(events, s.start(event.Response)...)
+	}
+	if !s.started {
+		return events
+	}
+	switch event.Type {
+	case "response.reasoning_summary_text.delta":
+		if event.Delta != "" {
+			events = append(events, s.delta(claudeBlockThinking, streamBlockKey(event), 0, event.Delta)...)
+		}
+	case "response.reasoning_summary_part.added":
+		// 同一推理项的多段摘要以空行分隔
+		if s.openKind == claudeBlockThinking && s.openKey == streamBlockKey(event) && s.openHasText {
+			events = append(events, s.delta(claudeBlockThinking, s.openKey, 0, "\n\n")...)
+		}
+	case "response.output_item.done":
+		if event.Item != nil && event.Item.Type == dto.ResponsesOutputTypeFunctionCall {
+			events = append(events, s.toolUse(event.Item)...)
+		} else if event.Item != nil && event.Item.Type == dto.ResponsesOutputTypeImageGenerationCall && event.Item.Result != "" {
+			events = append(events, s.image(event.Item)...)
+		} else if event.OutputIndex != nil && s.openIndex >= 0 && s.openKey == streamBlockKey(event) {
+			events = append(events, s.closeBlock()...)
+		}
+	}
+	return events
+}
+
+// Text 转换文本增量，event 为增量所属的 Responses 事件，用于确定所属的输出项；
+// event 为 nil 或不带 output_index 时（如缓存文本在结束时输出）追加到当前打开的文本块
+func (s *ClaudeStreamConverter) Text(event *dto.ResponsesStreamResponse, text string) []dto.ClaudeResponse {
+	if !s.started || s.stopped || text == "" {
+		return nil
+	}
+	key, content := "", 0
+	if event != nil && event.OutputIndex != nil {
+		key = streamBlockKey(event)
+		if event.Type == "response.output_text.delta" && event.ContentIndex != nil {
+			content = *event.ContentIndex
+		} else if s.openKind == claudeBlockText && s.openKey == key {
+			content = s.openContent
+		}
+	} else if s.openKind == claudeBlockText {
+		key, content = s.openKey, s.openContent
+	}
+	return s.delta(claudeBlockText, key, content, text)
+}
+
+// Finish 关闭打开的块并生成 message_delta 与 message_stop，重复调用不再生成事件
+// 正常结束且包含工具调用时 stop_reason 为 tool_use；没有任何内容块时保留一个空文本块，与非流式响应结构一致
+// 参数:
+//   - stopReason: Claude 的 stop_reason
+//   - stopSequence: 命中的停止序列，stop_reason 为 stop_sequence 时有效
+//   - outputTokens: 累计输出 token 数
+func (s *ClaudeStreamConverter) Finish(stopReason string, stopSequence string, outputTokens int) []dto.ClaudeResponse {
+	if !s.started || s.stopped {
+		return nil
+	}
+	events := s.closeBlock()
+	if s.nextIndex == 0 {
+		events = append(events, s.openBlock(claudeBlockText, "", 0))
+		events = append(events, s.closeBlock()...)
+	}
+	if stopReason == "end_turn" && s.hasToolUse {
+		stopReason = "tool_use"
+	}
+	delta := &dto.ClaudeMediaMessage{StopReason: &stopReason}
+	if stopReason == "stop_sequence" {
+		delta.StopSequence = &stopSequence
+	}
+	events = append(events,
+		dto.ClaudeResponse{Type: "message_delta", Delta: delta, Usage: &dto.ClaudeUsage{OutputTokens: outputTokens}},
+		dto.ClaudeResponse{Type: "message_stop"},
+	)
+	s.stopped = true
+	return events
+}
+
+// Complete 按上游最终响应的状态结束流，见 Finish；上游返回用量时 message_delta 同时携带输入与缓存命中用量
+func (s *ClaudeStreamConverter) Complete(response *dto.OpenAIResponsesResponse, outputTokens int) []dto.ClaudeResponse {
+	events := s.Finish(extractClaudeStopReason(response.Status), "", outputTokens)
+	if response.Usage != nil {
+		for i := range events {
+			if events[i].Type == "message_delta" {
+				events[i].Usage = claudeUsageFromResponses(response.Usage)
+				events[i].Usage.OutputTokens = outputTokens
+			}
+		}
+	}
+	return events
+}
+
+// start 生成 message_start 与随后的 ping 事件
+func (s *ClaudeStreamConverter) start(response *dto.OpenAIResponsesResponse) []dto.ClaudeResponse {
+	s.started = true
+	usage := &dto.ClaudeUsage{}
+	if response.Usage != nil {
+		usage = claudeUsageFromResponses(response.Usage)
+	}
+	return []dto.ClaudeResponse{
+		{
+			Type: "message_start",
+			Message: &dto.ClaudeMediaMessage{
+				Id:    response.ID,
+				Type:  "message",
+				Model: s.model,
+				Role:  "assistant",
+				Usage: usage,
+			},
+			Usage: usage,
+		},
+		{Type: "ping"},
+	}
+}
+
+// delta 向指定类型与输出项的块追加增量，当前打开的块不匹配时先关闭再打开新块
+func (s *ClaudeStreamConverter) delta(kind string, key string, content int, text string) []dto.ClaudeResponse {
+	var events []dto.ClaudeResponse
+	if s.openIndex < 0 || s.openKind != kind || s.openKey != key || s.openContent != content {
+		events = append(events, s.closeBlock()...)
+		events = append(events, s.openBlock(kind, key, content))
+	}
+	delta := &dto.ClaudeMediaMessage{Type: "text_delta", Text: &text}
+	if kind == claudeBlockThinking {
+		delta = &dto.ClaudeMediaMessage{Type: "thinking_delta", Thinking: &text}
+	}
+	event := dto.ClaudeResponse{Type: "content_block_delta", Delta: delta}
+	event.SetIndex(s.openIndex)
+	s.openHasText = true
+	return append(events, event)
+}
+
+// toolUse 将完成的 function_call 输出项作为完整的 tool_use 块生成，arguments 通过一次 input_json_delta 下发
+func (s *ClaudeStreamConverter) toolUse(item *dto.ResponsesOutput) []dto.ClaudeResponse {
+	events := s.closeBlock()
+	index := s.nextIndex
+	s.nextIndex++
+	s.hasToolUse = true
+
+	arguments := item.Arguments
+	if arguments == "" {
+		arguments = "{}"
+	}
+	start := dto.ClaudeResponse{
+		Type: "content_block_start",
+		ContentBlock: &dto.ClaudeMediaMessage{
+			Type:  "tool_use",
+			Id:    claudeToolUseId(item.CallId),
+			Name:  item.Name,
+			Input: map[string]any{},
+		},
+	}
+	start.SetIndex(index)
+	delta := dto.ClaudeResponse{
+		Type: "content_block_delta",
+		Delta: &dto.ClaudeMediaMessage{
+			Type:        "input_json_delta",
+			PartialJson: &arguments,
+		},
+	}
+	delta.SetIndex(index)
+	stop := dto.ClaudeResponse{Type: "content_block_stop"}
+	stop.SetIndex(index)
+	return append(events, start, delta, stop)
+}
+
+// image 将生成的图片作为完整的 image 块生成，图片数据在 content_block_start 中一次下发
+func (s *ClaudeStreamConverter) image(item *dto.ResponsesOutput) []dto.ClaudeResponse {
+	events := s.closeBlock()
+	index := s.nextIndex
+	s.nextIndex++
+
+	block := generatedImageClaudeBlock(item)
+	start := dto.ClaudeResponse{Type: "content_block_start", ContentBlock: &block}
+	start.SetIndex(index)
+	stop := dto.ClaudeResponse{Type: "content_block_stop"}
+	stop.SetIndex(index)
+	return append(events, start, stop)
+}
+
+func (s *ClaudeStreamConverter) openBlock(kind string, key string, content int) dto.ClaudeResponse {
+	s.openIndex = s.nextIndex
+	s.nextIndex++
+	s.openKind = kind
+	s.openKey = key
+	s.openContent = content
+	s.openHasText = false
+
+	empty := ""
+	block := &dto.ClaudeMediaMessage{Type: kind, Text: &empty}
+	if kind == claudeBlockThinking {
+		block = &dto.ClaudeMediaMessage{Type: kind, Thinking: &empty}
+	}
+	event := dto.ClaudeResponse{Type: "content_block_start", ContentBlock: block}
+	event.SetIndex(s.openIndex)
+	return event
+}
+
+func (s *ClaudeStreamConverter) closeBlock() []dto.ClaudeResponse {
+	if s.openIndex < 0 {
+		return nil
+	}
+	event := dto.ClaudeResponse{Type: "content_block_stop"}
+	event.SetIndex(s.openIndex)
+	s.openIndex = -1
+	s.openKind = ""
+	s.openKey = ""
+	return []dto.ClaudeResponse{event}
+}
+
+// streamBlockKey 事件所属输出项的标识，优先使用 output_index
+func streamBlockKey(event *dto.ResponsesStreamResponse) string {
+	if event.OutputIndex == nil {
+		return event.ItemId
+	}
+	return strconv.Itoa(*event.OutputIndex)
+}
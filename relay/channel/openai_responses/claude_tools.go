@@ -0,0 +1,59 @@
+package openai_responses
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// convertClaudeToolsWithCache 转换 Claude tools，入站工具集相同时复用缓存的转换结果
+func convertClaudeToolsWithCache(c *gin.Context, tools any) (json.RawMessage, error) {
+	scope := "claude_to_responses"
+	// 代码执行工具的转换结果取决于配置，配置不同时不复用
+	if model_setting.GetResponsesSettings().EmulateCodeExecution {
+		scope += ":emulate_code_execution"
+	}
+	return service.ConvertToolsWithCache(scope, claudeRawTools(c, tools), func() (json.RawMessage, error) {
+		return convertClaudeToolsToResponses(tools)
+	})
+}
+
+// claudeRawTools 获取入站 tools 的原始 JSON 作为缓存 key，优先从请求体中直接截取以免重复序列化
+func claudeRawTools(c *gin.Context, tools any) []byte {
+	if body, err := common.GetRequestBody(c); err == nil {
+		if raw := gjson.GetBytes(body, "tools"); raw.Exists() {
+			return []byte(raw.Raw)
+		}
+	}
+	raw, err := common.Marshal(tools)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// convertClaudeToolsToResponses 将 Claude tools 转换为 Responses tools，代码执行工具按配置模拟，其余工具原样保留
+// 参数:
+//   - tools: Claude 请求中的 tools
+//
+// 返回:
+//   - json.RawMessage: 转换后的 tools
+//   - error: 包含不支持的工具或序列化失败时返回错误
+func convertClaudeToolsToResponses(tools any) (json.RawMessage, error) {
+	converted, err := convertClaudeCodeExecutionTools(tools)
+	if err != nil {
+		return nil, err
+	}
+	toolsData, err := common.Marshal(converted)
+	if err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "failed to marshal tools: %s", err.Error())
+	}
+	return toolsData, nil
+}
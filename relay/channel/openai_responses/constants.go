@@ -10,4 +10,14 @@ var ModelList = []string{
 	"gpt-5.1",
 	"gpt-5-codex",
 	"gpt-5.1-codex",
-}
\ No newline at end of file
+}
+
+// IsKnownModel 判断模型是否在内置的 Responses 模型列表中
+func IsKnownModel(model string) bool {
+	for _, m := range ModelList {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
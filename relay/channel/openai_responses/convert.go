@@ -2,13 +2,16 @@ package openai_responses
 
 import (
 	"encoding/json"
-	"fmt"
+	"net/http"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -68,10 +71,10 @@ func cleanInvalidUTF8Bytes(b []byte) []byte {
 //   - error: 转换失败时返回错误
 func ChatCompletionsToResponsesRequest(c *gin.Context, chatRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) (*dto.OpenAIResponsesRequest, error) {
 	if chatRequest == nil {
-		return nil, fmt.Errorf("chat request is nil")
+		return nil, types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "chat request is nil")
 	}
 	if chatRequest.Model == "" {
-		return nil, fmt.Errorf("model is required")
+		return nil, types.NewConvertError(types.ErrorCodeConvertModelMissing, http.StatusBadRequest, "model is required")
 	}
 
 	// 创建Responses请求对象
@@ -117,7 +120,7 @@ func ChatCompletionsToResponsesRequest(c *gin.Context, chatRequest *dto.GeneralO
 			// systemMessage是普通字符串，需要编码为JSON字符串
 			encodedBytes, err := json.Marshal(systemMessage)
 			if err != nil {
-				return nil, fmt.Errorf("failed to encode system message: %w", err)
+				return nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "failed to encode system message: %s", err.Error())
 			}
 			instructions = json.RawMessage(encodedBytes)
 		}
@@ -127,32 +130,36 @@ func ChatCompletionsToResponsesRequest(c *gin.Context, chatRequest *dto.GeneralO
 	// 转换messages为input格式
 	inputs, err := convertMessagesToInputs(chatRequest.Messages)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert messages to inputs: %w", err)
+		return nil, err
 	}
 	
 	// 将inputs序列化为JSON RawMessage
 	if len(inputs) > 0 {
 		inputData, err := json.Marshal(inputs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal inputs: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal inputs: %s", err.Error())
 		}
 		responsesReq.Input = json.RawMessage(inputData)
 	}
 
 	// 处理tools参数
 	if len(chatRequest.Tools) > 0 {
-		toolsData, err := json.Marshal(chatRequest.Tools)
+		if err := validateChatTools(chatRequest.Tools); err != nil {
+			return nil, err
+		}
+		// 内置工具转换为 Responses 内置工具声明，函数工具原样保留
+		toolsData, err := convertChatToolsToResponses(c, info, chatRequest.Tools)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal tools: %w", err)
+			return nil, err
 		}
-		responsesReq.Tools = json.RawMessage(toolsData)
+		responsesReq.Tools = toolsData
 	}
 
 	// 处理tool_choice参数
 	if chatRequest.ToolChoice != nil {
-		toolChoiceData, err := json.Marshal(chatRequest.ToolChoice)
+		toolChoiceData, err := json.Marshal(builtInToolChoice(info, service.ChatToolChoiceToResponses(chatRequest.ToolChoice)))
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal tool_choice: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "failed to marshal tool_choice: %s", err.Error())
 		}
 		responsesReq.ToolChoice = json.RawMessage(toolChoiceData)
 	}
@@ -161,38 +168,107 @@ func ChatCompletionsToResponsesRequest(c *gin.Context, chatRequest *dto.GeneralO
 	if chatRequest.ParallelToolCalls != nil {
 		parallelData, err := json.Marshal(chatRequest.ParallelToolCalls)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal parallel_tool_calls: %w", err)
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal parallel_tool_calls: %s", err.Error())
 		}
 		responsesReq.ParallelToolCalls = json.RawMessage(parallelData)
 	}
 
-	// 处理其他可传递的参数
-	// 注意：stop 和 response_format 参数在 Responses API 中可能不被支持
-	// 这些参数会被忽略，不会传递给上游 API
+	// 工具调用次数上限，最终值由适配器结合令牌默认值与对话历史确定
+	responsesReq.MaxToolCalls = chatRequest.MaxToolCalls
+
+	// 透传客户端提供的 prompt_cache_key，未提供时按配置注入会话级缓存标识
+	if chatRequest.PromptCacheKey != "" {
+		promptCacheKey, err := json.Marshal(chatRequest.PromptCacheKey)
+		if err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal prompt_cache_key: %s", err.Error())
+		}
+		responsesReq.PromptCacheKey = json.RawMessage(promptCacheKey)
+	}
+	service.ApplyPromptCacheKey(c, responsesReq, chatRequest.User)
+
+	// 按配置以 previous_response_id 代替重复发送的历史
+	service.ApplyManagedConversationState(c, info, responsesReq)
+
+	// 按配置将超出 token 预算的较早轮次丢弃或摘要
+	service.TrimConversationToBudget(c, info, responsesReq)
+
+	// 按配置压缩内联 base64 图片
+	service.OptimizeInlineImages(c, info, responsesReq)
+
+	// 按上游限制提前校验工具定义，避免上游返回不指明工具的 400
+	if err := service.ValidateToolSchemaLimits(responsesReq.GetToolsMap()); err != nil {
+		return nil, err
+	}
+
+	// 客户端未指定推理强度时使用模型配置的默认值
+	service.ApplyDefaultReasoningEffort(info, responsesReq)
+
+	// 渠道支持时透传 stop，否则丢弃并记录
+	if err := service.ApplyResponsesStopSequences(c, info, responsesReq, service.NormalizeStopSequences(chatRequest.Stop)); err != nil {
+		return nil, err
+	}
+
+	// response_format 转换为 Responses 的 text.format
+	if chatRequest.ResponseFormat != nil {
+		text, err := convertResponseFormatToText(responsesReq.Text, chatRequest.ResponseFormat)
+		if err != nil {
+			return nil, err
+		}
+		responsesReq.Text = text
+	}
 
 	return responsesReq, nil
 }
 
-// extractSystemMessage 从消息列表中提取系统消息
+// validateChatTools 校验 Chat Completions 的函数工具定义
+// 参数:
+//   - tools: Chat Completions 请求中的 tools
+// 返回:
+//   - error: 函数名缺失或 parameters 不是 JSON 对象时返回 CONVERT_TOOL_SCHEMA_INVALID 错误
+func validateChatTools(tools []dto.ToolCallRequest) error {
+	for i, tool := range tools {
+		if tool.Type != "" && tool.Type != "function" {
+			continue
+		}
+		if tool.Function.Name == "" {
+			return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tools[%d].function.name is required", i)
+		}
+		if tool.Function.Parameters == nil {
+			continue
+		}
+		if _, ok := tool.Function.Parameters.(map[string]any); !ok {
+			return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tools[%d].function.parameters must be a JSON object", i)
+		}
+	}
+	return nil
+}
+
+// extractSystemMessage 从消息列表中提取系统消息，多条系统消息按出现顺序以 responses.system_message_separator 拼接
 // 参数:
 //   - messages: 消息列表
 // 返回:
 //   - string: 系统消息内容，如果没有系统消息则返回空字符串
 func extractSystemMessage(messages []dto.Message) string {
+	var parts []string
 	for _, message := range messages {
 		if message.Role == "system" {
-			// 处理不同类型的content
-			if str, ok := message.Content.(string); ok {
-				return str
-			}
-			
-			// 如果content是复杂类型，尝试转换为字符串
-			if contentBytes, err := json.Marshal(message.Content); err == nil {
-				return string(contentBytes)
+			if content := systemMessageContent(message); content != "" {
+				parts = append(parts, content)
 			}
 		}
 	}
-	return ""
+	return strings.Join(parts, model_setting.GetResponsesSettings().SystemMessageSeparator)
+}
+
+// systemMessageContent 获取单条系统消息的文本，结构化内容数组只取其中的文本部分，不含文本时返回空字符串
+func systemMessageContent(message dto.Message) string {
+	if message.Content == nil {
+		return ""
+	}
+	if str, ok := message.Content.(string); ok {
+		return str
+	}
+	return message.StringContent()
 }
 
 // convertMessagesToInputs 将Chat Completions的messages转换为Responses API的inputs格式
@@ -210,6 +286,20 @@ func convertMessagesToInputs(messages []dto.Message) ([]dto.Input, error) {
 			continue
 		}
 		
+		// 工具结果转换为 function_call_output，通过 call_id 与对应的 function_call 关联
+		if message.Role == "tool" {
+			output, err := json.Marshal(message.StringContent())
+			if err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal tool output: %s", err.Error())
+			}
+			inputs = append(inputs, dto.Input{
+				Type:   "function_call_output",
+				CallId: message.ToolCallId,
+				Output: json.RawMessage(output),
+			})
+			continue
+		}
+
 		input := dto.Input{
 			Type:    "message",
 			Role:    message.Role,
@@ -230,25 +320,45 @@ func convertMessagesToInputs(messages []dto.Message) ([]dto.Input, error) {
 				}
 				contentBytes, err = json.Marshal(str)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal string content: %w", err)
+					return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal string content: %s", err.Error())
 				}
 			} else {
 				// 如果content是复杂类型，先验证再序列化
 				// 使用json.Marshal然后验证结果
 				contentBytes, err = json.Marshal(message.Content)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal complex content: %w", err)
+					return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "failed to marshal complex content: %s", err.Error())
 				}
 				
 				// 验证生成的JSON是否有效
 				if !isValidUTF8Bytes(contentBytes) {
-					return nil, fmt.Errorf("generated JSON contains invalid UTF-8 characters")
+					return nil, types.NewConvertError(types.ErrorCodeConvertContentEncoding, http.StatusBadRequest, "message content contains invalid UTF-8 characters")
 				}
 			}
 			input.Content = json.RawMessage(contentBytes)
 		}
 		
-		inputs = append(inputs, input)
+		toolCalls := message.ParseToolCalls()
+		// 只有工具调用的 assistant 消息 content 为 null 或空，不生成空消息项
+		if len(toolCalls) == 0 || (message.Content != nil && message.StringContent() != "") {
+			inputs = append(inputs, input)
+		}
+		// assistant 的工具调用转换为 function_call，保留 call_id 以便与后续的工具结果对应
+		for _, toolCall := range toolCalls {
+			if toolCall.Type != "" && toolCall.Type != "function" {
+				return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "unsupported tool call type: %s", toolCall.Type)
+			}
+			arguments := toolCall.Function.Arguments
+			if arguments == "" {
+				arguments = "{}"
+			}
+			inputs = append(inputs, dto.Input{
+				Type:      "function_call",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: arguments,
+			})
+		}
 	}
 	return inputs, nil
 }
@@ -262,7 +372,7 @@ func convertMessagesToInputs(messages []dto.Message) ([]dto.Input, error) {
 //   - error: 转换失败时返回错误
 func ResponsesToChatCompletionsResponse(responsesResponse *dto.OpenAIResponsesResponse, originalRequest *dto.GeneralOpenAIRequest) (*dto.OpenAITextResponse, error) {
 	if responsesResponse == nil {
-		return nil, fmt.Errorf("responses response is nil")
+		return nil, types.NewConvertError(types.ErrorCodeConvertResponseInvalid, http.StatusInternalServerError, "responses response is nil")
 	}
 
 	// 处理错误响应
@@ -275,20 +385,20 @@ func ResponsesToChatCompletionsResponse(responsesResponse *dto.OpenAIResponsesRe
 		}, nil
 	}
 
-	// 提取内容
-	content := extractContentFromOutput(responsesResponse.Output)
+	// 按 output 顺序提取推理、文本与工具调用
+	message := ResponsesOutputToChatMessage(responsesResponse.Output)
 	
 	// 确定finish_reason
-	finishReason := extractFinishReason(responsesResponse.Status)
+	finishReason := extractFinishReason(responsesResponse)
+	if len(message.ToolCalls) > 0 && finishReason == "stop" {
+		finishReason = "tool_calls"
+	}
 	
 	// 构建Choices
 	choices := []dto.OpenAITextResponseChoice{
 		{
-			Index: 0,
-			Message: dto.Message{
-				Role:    "assistant",
-				Content: content,
-			},
+			Index:        0,
+			Message:      message,
 			FinishReason: finishReason,
 		},
 	}
@@ -302,6 +412,11 @@ func ResponsesToChatCompletionsResponse(responsesResponse *dto.OpenAIResponsesRe
 		Choices: choices,
 	}
 
+	// failed 状态下 finish_reason 为合法值，同时通过 error 字段暴露失败原因
+	if responsesResponse.Status == "failed" {
+		chatResponse.Error = buildFailedResponseError(responsesResponse)
+	}
+
 	// 处理Usage
 	if responsesResponse.Usage != nil {
 		chatResponse.Usage = *responsesResponse.Usage
@@ -310,42 +425,120 @@ func ResponsesToChatCompletionsResponse(responsesResponse *dto.OpenAIResponsesRe
 	return chatResponse, nil
 }
 
-// extractContentFromOutput 从Responses API的Output中提取文本内容
+// ResponsesOutputToChatMessage 按 output 数组顺序将 Responses 输出转换为 Chat Completions 的 assistant 消息
 // 参数:
 //   - output: Responses API的Output数组
 // 返回:
-//   - string: 提取的文本内容
-func extractContentFromOutput(output []dto.ResponsesOutput) string {
-	var contentBuilder string
+//   - dto.Message: reasoning 摘要写入 reasoning_content，文本按顺序拼接为 content，function_call 按顺序转换为 tool_calls
+func ResponsesOutputToChatMessage(output []dto.ResponsesOutput) dto.Message {
+	var content strings.Builder
+	var refusal strings.Builder
+	var reasoning []string
+	var toolCalls []dto.ToolCallResponse
+	var images []dto.ResponsesOutput
 	for _, item := range output {
-		if item.Type == "message" && item.Role == "assistant" {
+		switch item.Type {
+		case dto.ResponsesOutputTypeReasoning:
+			for _, summary := range item.Summary {
+				if summary.Text != "" {
+					reasoning = append(reasoning, summary.Text)
+				}
+			}
+		case dto.ResponsesOutputTypeMessage:
+			if item.Role != "assistant" {
+				continue
+			}
 			for _, contentItem := range item.Content {
-				if contentItem.Type == "output_text" {
-					contentBuilder += contentItem.Text
+				switch contentItem.Type {
+				case "output_text":
+					content.WriteString(contentItem.Text)
+				case "refusal":
+					// 结构化输出被拒绝时写入 refusal
+					refusal.WriteString(contentItem.Refusal)
 				}
 			}
+		case dto.ResponsesOutputTypeFunctionCall:
+			toolCalls = append(toolCalls, dto.ToolCallResponse{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionResponse{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			})
+		case dto.ResponsesOutputTypeImageGenerationCall:
+			if item.Result != "" {
+				images = append(images, item)
+			}
+		}
+	}
+
+	// 生成的图片按配置转换为 image_url 内容数组，或以 Markdown 图片追加到文本
+	useContentParts := len(images) > 0 && model_setting.GetResponsesSettings().ChatImageOutput == model_setting.ChatImageOutputContentParts
+	if !useContentParts {
+		for i := range images {
+			content.WriteString(generatedImageMarkdown(&images[i]))
 		}
 	}
-	return contentBuilder
+
+	message := dto.Message{
+		Role:             "assistant",
+		Content:          content.String(),
+		ReasoningContent: strings.Join(reasoning, "\n\n"),
+	}
+	if useContentParts {
+		parts := make([]dto.MediaContent, 0, len(images)+1)
+		if content.Len() > 0 {
+			parts = append(parts, dto.MediaContent{Type: dto.ContentTypeText, Text: content.String()})
+		}
+		for i := range images {
+			parts = append(parts, dto.MediaContent{
+				Type:     dto.ContentTypeImageURL,
+				ImageUrl: map[string]string{"url": generatedImageDataURI(&images[i])},
+			})
+		}
+		message.Content = parts
+	}
+	if refusal.Len() > 0 {
+		refusalText := refusal.String()
+		message.Refusal = &refusalText
+	}
+	if len(toolCalls) > 0 {
+		message.SetToolCalls(toolCalls)
+		// 仅有工具调用时 content 按规范为 null
+		if content.Len() == 0 && !useContentParts {
+			message.Content = nil
+		}
+	}
+	return message
 }
 
 // extractFinishReason 根据Responses API的状态确定finish_reason
 // 参数:
-//   - status: Responses API的响应状态
+//   - response: Responses API的响应对象
 // 返回:
-//   - string: Chat Completions的finish_reason
-func extractFinishReason(status string) string {
-	switch status {
-	case "completed":
-		return "stop"
-	case "incomplete":
-		return "length" // 或者 "content_filter" 等，视具体情况而定
-	case "failed":
-		return "error" // Chat Completions API没有error作为finish_reason，但这是最接近的
-	case "cancelled":
-		return "stop"
-	default:
-		return "stop"
+//   - string: Chat Completions的finish_reason，映射关系可通过 responses.finish_reason_mapping 配置
+func extractFinishReason(response *dto.OpenAIResponsesResponse) string {
+	reason := ""
+	if response.IncompleteDetails != nil {
+		reason = response.IncompleteDetails.Reason
+	}
+	return model_setting.GetResponsesSettings().GetChatFinishReason(response.Status, reason)
+}
+
+// buildFailedResponseError 为 failed 状态的响应构造错误对象
+// 参数:
+//   - response: Responses API的响应对象
+// 返回:
+//   - any: 上游返回的错误对象，上游未提供时生成默认错误
+func buildFailedResponseError(response *dto.OpenAIResponsesResponse) any {
+	if response.Error != nil {
+		return response.Error
+	}
+	return types.OpenAIError{
+		Message: "upstream response failed",
+		Type:    "upstream_error",
+		Code:    "response_failed",
 	}
 }
 
@@ -412,22 +605,16 @@ func ConvertResponsesStreamToChatStream(responsesStreamResp *dto.ResponsesStream
 			return chatStreamResp
 		}
 
-	case "response.done":
-		// 响应完成事件，包含最终的使用量和状态
+	case "response.done", "response.completed", "response.incomplete", "response.failed":
+		// 响应结束事件，与 OpenAI 一致，结束分块不携带用量，用量由 stream_options.include_usage 控制的独立分块下发
 		if responsesStreamResp.Response != nil {
-			finishReason := extractFinishReason(responsesStreamResp.Response.Status)
+			finishReason := extractFinishReason(responsesStreamResp.Response)
 			choice := dto.ChatCompletionsStreamResponseChoice{
 				Index:        0,
 				FinishReason: &finishReason,
 				Delta:        dto.ChatCompletionsStreamResponseChoiceDelta{}, // 空Delta
 			}
 			chatStreamResp.Choices = append(chatStreamResp.Choices, choice)
-			
-			// 如果有使用量信息，也包含进去
-			if responsesStreamResp.Response.Usage != nil {
-				chatStreamResp.Usage = responsesStreamResp.Response.Usage
-			}
-			
 			return chatStreamResp
 		}
 		
@@ -0,0 +1,299 @@
+package openai_responses
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 转换热路径的基准测试
+//
+// 用法：
+//
+//	go test -run '^$' -bench . -benchmem ./relay/channel/openai_responses/
+//	CONVERT_BENCH_WRITE_BUDGET=budget.json go test -run TestConvertAllocBudget ./relay/channel/openai_responses/
+//	CONVERT_BENCH_BUDGET=budget.json go test -run TestConvertAllocBudget ./relay/channel/openai_responses/
+//
+// 预算文件为 {"基准名": {"allocs_per_op": N, "bytes_per_op": N}}，未配置的基准不检查
+
+const benchModel = "gpt-5"
+
+var (
+	benchChatBody   = buildChatRequestBody(200)
+	benchClaudeBody = buildClaudeRequestBody(200)
+	benchStreamBody = buildResponsesStreamBody(2000)
+)
+
+func TestMain(m *testing.M) {
+	// 读取与服务端一致的环境配置，例如 STREAMING_TIMEOUT、STREAM_MAX_ACCUMULATED_BYTES
+	common.InitEnv()
+	// 基准不连接 Redis，活跃流只登记在内存中
+	common.RedisEnabled = false
+	gin.SetMode(gin.ReleaseMode)
+	// 屏蔽转换过程中的日志输出，只保留基准结果
+	gin.DefaultWriter = io.Discard
+	gin.DefaultErrorWriter = io.Discard
+	service.InitTokenEncoders()
+	os.Exit(m.Run())
+}
+
+func BenchmarkChatCompletionsToResponsesRequest(b *testing.B) {
+	benchmarkRequestConversion(b, benchChatBody, func(c *gin.Context, data []byte, info *relaycommon.RelayInfo) error {
+		request := &dto.GeneralOpenAIRequest{}
+		if err := common.Unmarshal(data, request); err != nil {
+			return err
+		}
+		b.StartTimer()
+		_, err := ChatCompletionsToResponsesRequest(c, request, info)
+		return err
+	})
+}
+
+func BenchmarkClaudeMessagesToResponsesRequest(b *testing.B) {
+	benchmarkRequestConversion(b, benchClaudeBody, func(c *gin.Context, data []byte, info *relaycommon.RelayInfo) error {
+		request := &dto.ClaudeRequest{}
+		if err := common.Unmarshal(data, request); err != nil {
+			return err
+		}
+		b.StartTimer()
+		_, err := ClaudeMessagesToResponsesRequest(c, request, info)
+		return err
+	})
+}
+
+func BenchmarkResponsesToChatStreamHandler(b *testing.B) {
+	benchmarkStreamConversion(b, benchStreamBody, ResponsesToChatStreamHandler)
+}
+
+func BenchmarkResponsesToClaudeStreamHandler(b *testing.B) {
+	benchmarkStreamConversion(b, benchStreamBody, ResponsesToClaudeStreamHandler)
+}
+
+// allocBudget 单个基准允许的每次操作分配上限，0 表示不限制
+type allocBudget struct {
+	AllocsPerOp int64 `json:"allocs_per_op"`
+	BytesPerOp  int64 `json:"bytes_per_op"`
+}
+
+// TestConvertAllocBudget 按本地预算文件检查转换热路径的分配回退，未设置环境变量时跳过，不在 CI 中运行
+// CONVERT_BENCH_BUDGET 指定预算文件，超出预算时失败；CONVERT_BENCH_WRITE_BUDGET 将本次结果放宽 10% 后写入预算文件
+func TestConvertAllocBudget(t *testing.T) {
+	budgetFile := os.Getenv("CONVERT_BENCH_BUDGET")
+	writeBudgetFile := os.Getenv("CONVERT_BENCH_WRITE_BUDGET")
+	if budgetFile == "" && writeBudgetFile == "" {
+		t.Skip("set CONVERT_BENCH_BUDGET or CONVERT_BENCH_WRITE_BUDGET to check converter allocations")
+	}
+
+	budgets := map[string]allocBudget{}
+	if budgetFile != "" {
+		data, err := os.ReadFile(budgetFile)
+		if err != nil {
+			t.Fatalf("read budget file: %v", err)
+		}
+		if err := common.Unmarshal(data, &budgets); err != nil {
+			t.Fatalf("parse budget file: %v", err)
+		}
+	}
+
+	benchmarks := map[string]func(b *testing.B){
+		"ChatCompletionsToResponsesRequest": BenchmarkChatCompletionsToResponsesRequest,
+		"ClaudeMessagesToResponsesRequest":  BenchmarkClaudeMessagesToResponsesRequest,
+		"ResponsesToChatStreamHandler":      BenchmarkResponsesToChatStreamHandler,
+		"ResponsesToClaudeStreamHandler":    BenchmarkResponsesToClaudeStreamHandler,
+	}
+	results := map[string]allocBudget{}
+	for name, fn := range benchmarks {
+		result := testing.Benchmark(fn)
+		allocs, bytes := result.AllocsPerOp(), result.AllocedBytesPerOp()
+		results[name] = allocBudget{AllocsPerOp: allocs + allocs/10, BytesPerOp: bytes + bytes/10}
+		t.Logf("%-40s %s %s", name, result.String(), result.MemString())
+		budget, ok := budgets[name]
+		if !ok {
+			continue
+		}
+		if (budget.AllocsPerOp > 0 && allocs > budget.AllocsPerOp) || (budget.BytesPerOp > 0 && bytes > budget.BytesPerOp) {
+			t.Errorf("%s over budget: %d allocs/op, %d B/op (budget %d allocs/op, %d B/op)", name, allocs, bytes, budget.AllocsPerOp, budget.BytesPerOp)
+		}
+	}
+
+	if writeBudgetFile != "" {
+		data, err := common.Marshal(results)
+		if err != nil {
+			t.Fatalf("marshal budget: %v", err)
+		}
+		if err := os.WriteFile(writeBudgetFile, data, 0644); err != nil {
+			t.Fatalf("write budget file: %v", err)
+		}
+	}
+}
+
+// benchmarkRequestConversion 每次迭代重新解析请求体，解析过程不计入耗时与分配
+func benchmarkRequestConversion(b *testing.B, body []byte, convert func(c *gin.Context, data []byte, info *relaycommon.RelayInfo) error) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if err := convert(c, body, newBenchRelayInfo()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkStreamConversion(b *testing.B, body string, handler func(*gin.Context, *relaycommon.RelayInfo, *http.Response) (*dto.Usage, *types.NewAPIError)) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+		info := newBenchRelayInfo()
+		info.IsStream = true
+		b.StartTimer()
+		if _, err := handler(c, info, resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newBenchRelayInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		OriginModelName: benchModel,
+		ChannelMeta:     &relaycommon.ChannelMeta{UpstreamModelName: benchModel},
+	}
+}
+
+// buildChatRequestBody 构造包含多轮文本、图片与工具调用的 Chat Completions 请求
+func buildChatRequestBody(turns int) []byte {
+	messages := []map[string]any{
+		{"role": "system", "content": strings.Repeat("You are a careful assistant. ", 40)},
+	}
+	for i := 0; i < turns; i++ {
+		messages = append(messages,
+			map[string]any{"role": "user", "content": []map[string]any{
+				{"type": "text", "text": strings.Repeat(fmt.Sprintf("question %d about the attached chart. ", i), 8)},
+				{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/chart.png", "detail": "low"}},
+			}},
+			map[string]any{"role": "assistant", "content": "", "tool_calls": []map[string]any{
+				{"id": fmt.Sprintf("call_%d", i), "type": "function", "function": map[string]any{
+					"name": "lookup", "arguments": fmt.Sprintf(`{"query":"item %d","limit":10}`, i),
+				}},
+			}},
+			map[string]any{"role": "tool", "tool_call_id": fmt.Sprintf("call_%d", i), "content": strings.Repeat("result row; ", 30)},
+			map[string]any{"role": "assistant", "content": strings.Repeat(fmt.Sprintf("answer %d based on the lookup. ", i), 10)},
+		)
+	}
+	return mustMarshal(map[string]any{
+		"model":      benchModel,
+		"stream":     true,
+		"max_tokens": 4096,
+		"messages":   messages,
+		"tools": []map[string]any{
+			{"type": "function", "function": map[string]any{
+				"name":        "lookup",
+				"description": "Look up records",
+				"parameters": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"query": map[string]any{"type": "string"},
+						"limit": map[string]any{"type": "integer"},
+					},
+					"required": []string{"query"},
+				},
+			}},
+		},
+	})
+}
+
+// buildClaudeRequestBody 构造包含多轮文本、图片与工具调用的 Claude Messages 请求
+func buildClaudeRequestBody(turns int) []byte {
+	var messages []map[string]any
+	for i := 0; i < turns; i++ {
+		toolID := fmt.Sprintf("toolu_%d", i)
+		messages = append(messages,
+			map[string]any{"role": "user", "content": []map[string]any{
+				{"type": "text", "text": strings.Repeat(fmt.Sprintf("question %d about the attached chart. ", i), 8)},
+				{"type": "image", "source": map[string]any{"type": "url", "url": "https://example.com/chart.png"}},
+			}},
+			map[string]any{"role": "assistant", "content": []map[string]any{
+				{"type": "text", "text": "Let me look that up."},
+				{"type": "tool_use", "id": toolID, "name": "lookup", "input": map[string]any{"query": fmt.Sprintf("item %d", i), "limit": 10}},
+			}},
+			map[string]any{"role": "user", "content": []map[string]any{
+				{"type": "tool_result", "tool_use_id": toolID, "content": strings.Repeat("result row; ", 30)},
+			}},
+			map[string]any{"role": "assistant", "content": strings.Repeat(fmt.Sprintf("answer %d based on the lookup. ", i), 10)},
+		)
+	}
+	messages = append(messages, map[string]any{"role": "user", "content": "Summarize everything."})
+	return mustMarshal(map[string]any{
+		"model":      benchModel,
+		"stream":     true,
+		"max_tokens": 4096,
+		"system":     strings.Repeat("You are a careful assistant. ", 40),
+		"messages":   messages,
+		"tools": []map[string]any{
+			{"name": "lookup", "description": "Look up records", "input_schema": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string"},
+					"limit": map[string]any{"type": "integer"},
+				},
+				"required": []string{"query"},
+			}},
+		},
+	})
+}
+
+// buildResponsesStreamBody 构造包含大量文本增量的 Responses API SSE 流
+func buildResponsesStreamBody(deltas int) string {
+	var sb strings.Builder
+	writeEvent := func(event map[string]any) {
+		sb.WriteString("data: ")
+		sb.Write(mustMarshal(event))
+		sb.WriteString("\n\n")
+	}
+	response := map[string]any{"id": "resp_bench", "object": "response", "model": benchModel, "status": "in_progress"}
+	writeEvent(map[string]any{"type": "response.created", "response": response})
+	for i := 0; i < deltas; i++ {
+		writeEvent(map[string]any{
+			"type":          "response.output_text.delta",
+			"item_id":       "msg_bench",
+			"output_index":  0,
+			"content_index": 0,
+			"delta":         fmt.Sprintf("token %d of the streamed answer, ", i),
+		})
+	}
+	writeEvent(map[string]any{"type": "response.done", "response": map[string]any{
+		"id": "resp_bench", "object": "response", "model": benchModel, "status": "completed",
+		"usage": map[string]any{"input_tokens": 12000, "output_tokens": deltas * 8, "total_tokens": 12000 + deltas*8},
+	}})
+	sb.WriteString("data: [DONE]\n\n")
+	return sb.String()
+}
+
+func mustMarshal(v any) []byte {
+	data, err := common.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
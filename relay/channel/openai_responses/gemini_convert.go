@@ -0,0 +1,460 @@
+package openai_responses
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiToResponsesRequest 将 Gemini generateContent 请求转换为 Responses API 格式
+// Gemini 的函数调用没有 ID，转换时按出现顺序生成 call_id，functionResponse 按名称依次对应到尚未返回结果的 functionCall
+// 参数:
+//   - c: Gin 上下文
+//   - geminiRequest: Gemini 请求对象
+//   - info: 转发信息，包含模型映射等信息
+//
+// 返回:
+//   - *dto.OpenAIResponsesRequest: 转换后的 Responses API 请求对象
+//   - error: 转换失败时返回错误
+func GeminiToResponsesRequest(c *gin.Context, geminiRequest *dto.GeminiChatRequest, info *relaycommon.RelayInfo) (*dto.OpenAIResponsesRequest, error) {
+	if geminiRequest == nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "gemini request is nil")
+	}
+	if info.UpstreamModelName == "" {
+		return nil, types.NewConvertError(types.ErrorCodeConvertModelMissing, http.StatusBadRequest, "model is required")
+	}
+	if geminiRequest.CachedContent != "" {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "cachedContent is not supported when the request is routed to an OpenAI Responses channel")
+	}
+
+	config := geminiRequest.GenerationConfig
+	if config.CandidateCount > 1 {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "generationConfig.candidateCount > 1 is not supported when the request is routed to an OpenAI Responses channel")
+	}
+
+	responsesReq := &dto.OpenAIResponsesRequest{
+		Model:           info.UpstreamModelName,
+		Stream:          info.IsStream,
+		TopP:            config.TopP,
+		MaxOutputTokens: config.MaxOutputTokens,
+	}
+	if config.Temperature != nil {
+		responsesReq.Temperature = *config.Temperature
+	}
+
+	// thinkingConfig 映射为 reasoning，thinkingBudget 为 0 表示关闭思考
+	if thinking := config.ThinkingConfig; thinking != nil {
+		effort := ""
+		var level string
+		if len(thinking.ThinkingLevel) > 0 && common.Unmarshal(thinking.ThinkingLevel, &level) == nil && level != "" {
+			effort = strings.ToLower(level)
+		} else if thinking.ThinkingBudget != nil && *thinking.ThinkingBudget != 0 {
+			effort = thinkingBudgetToReasoningEffort(*thinking.ThinkingBudget)
+		}
+		if effort != "" {
+			responsesReq.Reasoning = &dto.Reasoning{Effort: effort}
+			if thinking.IncludeThoughts {
+				responsesReq.Reasoning.Summary = "auto"
+			}
+		}
+	}
+
+	// systemInstruction 的文本作为 instructions
+	if geminiRequest.SystemInstructions != nil {
+		var texts []string
+		for _, part := range geminiRequest.SystemInstructions.Parts {
+			if part.Text != "" {
+				texts = append(texts, cleanInvalidUTF8Chars(part.Text))
+			}
+		}
+		if len(texts) > 0 {
+			instructions, err := json.Marshal(strings.Join(texts, "\n"))
+			if err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertSystemInvalid, http.StatusBadRequest, "failed to marshal instructions: %s", err.Error())
+			}
+			responsesReq.Instructions = instructions
+		}
+	}
+
+	// 转换 contents 为 input 格式
+	inputs, err := convertGeminiContentsToInputs(geminiRequest.Contents)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) > 0 {
+		inputData, err := json.Marshal(inputs)
+		if err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal inputs: %s", err.Error())
+		}
+		responsesReq.Input = inputData
+	}
+
+	// 处理 tools 参数
+	if len(geminiRequest.Tools) > 0 {
+		toolsData, err := convertGeminiToolsToResponses(info, geminiRequest.Tools)
+		if err != nil {
+			return nil, err
+		}
+		responsesReq.Tools = toolsData
+	}
+
+	// 处理 toolConfig 参数
+	if geminiRequest.ToolConfig != nil && geminiRequest.ToolConfig.FunctionCallingConfig != nil {
+		toolChoice, err := convertGeminiFunctionCallingConfig(geminiRequest.ToolConfig.FunctionCallingConfig)
+		if err != nil {
+			return nil, err
+		}
+		if toolChoice != nil {
+			toolChoiceData, err := json.Marshal(toolChoice)
+			if err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "failed to marshal tool_choice: %s", err.Error())
+			}
+			responsesReq.ToolChoice = toolChoiceData
+		}
+	}
+
+	// 渠道支持时透传 stopSequences，否则丢弃并记录
+	if err := service.ApplyResponsesStopSequences(c, info, responsesReq, config.StopSequences); err != nil {
+		return nil, err
+	}
+
+	// responseMimeType 与 responseSchema 转换为 Responses 的 text.format
+	if responseFormat := geminiResponseFormat(config); responseFormat != nil {
+		text, err := convertResponseFormatToText(responsesReq.Text, responseFormat)
+		if err != nil {
+			return nil, err
+		}
+		responsesReq.Text = text
+	}
+
+	// 按配置注入会话级缓存标识
+	service.ApplyPromptCacheKey(c, responsesReq, "")
+
+	// 按配置以 previous_response_id 代替重复发送的历史
+	service.ApplyManagedConversationState(c, info, responsesReq)
+
+	// 按配置将超出 token 预算的较早轮次丢弃或摘要
+	service.TrimConversationToBudget(c, info, responsesReq)
+
+	// 按配置压缩内联 base64 图片
+	service.OptimizeInlineImages(c, info, responsesReq)
+
+	// 按上游限制提前校验工具定义，避免上游返回不指明工具的 400
+	if err := service.ValidateToolSchemaLimits(responsesReq.GetToolsMap()); err != nil {
+		return nil, err
+	}
+
+	// 客户端未通过 thinkingConfig 指定推理强度时使用模型配置的默认值
+	service.ApplyDefaultReasoningEffort(info, responsesReq)
+
+	return responsesReq, nil
+}
+
+// convertGeminiContentsToInputs 将 Gemini 的 contents 转换为 Responses API 的 inputs 格式
+// 文本与媒体 part 合并为一条消息，functionCall 与 functionResponse 转换为独立的 function_call 与 function_call_output 项，
+// 思考 part 没有 Responses 对应的输入格式，转发时丢弃
+func convertGeminiContentsToInputs(contents []dto.GeminiChatContent) ([]dto.Input, error) {
+	var inputs []dto.Input
+	// 按函数名记录尚未返回结果的 call_id
+	pendingCalls := make(map[string][]string)
+	callCount := 0
+
+	for _, content := range contents {
+		role := "user"
+		textType := "input_text"
+		if content.Role == "model" {
+			role = "assistant"
+			textType = "output_text"
+		}
+
+		var parts []map[string]any
+		flush := func() error {
+			if len(parts) == 0 {
+				return nil
+			}
+			contentData, err := json.Marshal(parts)
+			if err != nil {
+				return types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "failed to marshal content: %s", err.Error())
+			}
+			inputs = append(inputs, dto.Input{Type: "message", Role: role, Content: contentData})
+			parts = nil
+			return nil
+		}
+
+		for _, part := range content.Parts {
+			switch {
+			case part.Thought:
+				continue
+			case part.FunctionCall != nil:
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				callCount++
+				callId := fmt.Sprintf("call_%d", callCount)
+				pendingCalls[part.FunctionCall.FunctionName] = append(pendingCalls[part.FunctionCall.FunctionName], callId)
+				arguments := "{}"
+				if part.FunctionCall.Arguments != nil {
+					argumentsData, err := common.Marshal(part.FunctionCall.Arguments)
+					if err != nil {
+						return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "failed to marshal functionCall.args: %s", err.Error())
+					}
+					arguments = string(argumentsData)
+				}
+				inputs = append(inputs, dto.Input{
+					Type:      "function_call",
+					CallId:    callId,
+					Name:      part.FunctionCall.FunctionName,
+					Arguments: arguments,
+				})
+			case part.FunctionResponse != nil:
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				name := part.FunctionResponse.Name
+				if len(pendingCalls[name]) == 0 {
+					return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "functionResponse %s has no matching functionCall", name)
+				}
+				callId := pendingCalls[name][0]
+				pendingCalls[name] = pendingCalls[name][1:]
+				responseData, err := common.Marshal(part.FunctionResponse.Response)
+				if err != nil {
+					return nil, types.NewConvertError(types.ErrorCodeConvertMessageInvalid, http.StatusBadRequest, "failed to marshal functionResponse.response: %s", err.Error())
+				}
+				output, err := json.Marshal(string(responseData))
+				if err != nil {
+					return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal function output: %s", err.Error())
+				}
+				inputs = append(inputs, dto.Input{
+					Type:   "function_call_output",
+					CallId: callId,
+					Output: output,
+				})
+			case part.Text != "":
+				parts = append(parts, map[string]any{"type": textType, "text": cleanInvalidUTF8Chars(part.Text)})
+			case part.InlineData != nil:
+				item, err := geminiMediaPart(part.InlineData.MimeType, "data:"+part.InlineData.MimeType+";base64,"+part.InlineData.Data, true)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, item)
+			case part.FileData != nil:
+				item, err := geminiMediaPart(part.FileData.MimeType, part.FileData.FileUri, false)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, item)
+			case part.ExecutableCode != nil:
+				parts = append(parts, map[string]any{"type": textType, "text": fmt.Sprintf("```%s\n%s\n```", strings.ToLower(part.ExecutableCode.Language), part.ExecutableCode.Code)})
+			case part.CodeExecutionResult != nil:
+				parts = append(parts, map[string]any{"type": textType, "text": part.CodeExecutionResult.Output})
+			}
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
+
+// geminiMediaPart 将 Gemini 的 inlineData 或 fileData 转换为 Responses 的 input_image 或 input_file
+// 参数:
+//   - mimeType: 媒体类型
+//   - url: 内联数据为 data URL，文件数据为文件地址
+//   - inline: 是否为内联数据
+func geminiMediaPart(mimeType string, url string, inline bool) (map[string]any, error) {
+	if strings.HasPrefix(mimeType, "image/") {
+		if inline && len(url)/4*3 > maxConvertImageBytes {
+			return nil, types.NewConvertError(types.ErrorCodeConvertImageTooLarge, http.StatusBadRequest, "inline image exceeds %d bytes", maxConvertImageBytes)
+		}
+		return map[string]any{"type": "input_image", "image_url": url}, nil
+	}
+	if strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/") {
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "%s parts are not supported when the request is routed to an OpenAI Responses channel", mimeType)
+	}
+	if inline {
+		return map[string]any{"type": "input_file", "file_data": url, "filename": "file" + geminiFileExtension(mimeType)}, nil
+	}
+	return map[string]any{"type": "input_file", "file_url": url}, nil
+}
+
+// geminiFileExtension 按媒体类型推断内联文件的扩展名，Responses 的 input_file 需要文件名
+func geminiFileExtension(mimeType string) string {
+	switch mimeType {
+	case "application/pdf":
+		return ".pdf"
+	case "text/plain":
+		return ".txt"
+	case "text/csv":
+		return ".csv"
+	case "text/html":
+		return ".html"
+	case "text/markdown":
+		return ".md"
+	}
+	return ""
+}
+
+// geminiChatToolDeclaration 单个 Gemini tools 项，与 dto.GeminiChatTool 相比补充了 parametersJsonSchema
+type geminiChatToolDeclaration struct {
+	FunctionDeclarations []struct {
+		Name                 string `json:"name"`
+		Description          string `json:"description,omitempty"`
+		Parameters           any    `json:"parameters,omitempty"`
+		ParametersJsonSchema any    `json:"parametersJsonSchema,omitempty"`
+	} `json:"functionDeclarations,omitempty"`
+	GoogleSearch          any `json:"googleSearch,omitempty"`
+	GoogleSearchRetrieval any `json:"googleSearchRetrieval,omitempty"`
+	CodeExecution         any `json:"codeExecution,omitempty"`
+	URLContext            any `json:"urlContext,omitempty"`
+}
+
+// convertGeminiToolsToResponses 将 Gemini 的 tools 转换为 Responses tools
+// functionDeclarations 转换为函数工具，googleSearch 与 codeExecution 转换为对应的内置工具并登记到用量统计
+func convertGeminiToolsToResponses(info *relaycommon.RelayInfo, rawTools json.RawMessage) (json.RawMessage, error) {
+	var declarations []geminiChatToolDeclaration
+	if strings.HasPrefix(strings.TrimSpace(string(rawTools)), "{") {
+		var declaration geminiChatToolDeclaration
+		if err := common.Unmarshal(rawTools, &declaration); err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "invalid tools: %s", err.Error())
+		}
+		declarations = append(declarations, declaration)
+	} else if err := common.Unmarshal(rawTools, &declarations); err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "invalid tools: %s", err.Error())
+	}
+
+	var tools []map[string]any
+	for _, declaration := range declarations {
+		for _, function := range declaration.FunctionDeclarations {
+			if function.Name == "" {
+				return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "functionDeclarations: name is required")
+			}
+			parameters := function.ParametersJsonSchema
+			if parameters == nil {
+				parameters = geminiSchemaToJSONSchema(function.Parameters)
+			}
+			if parameters == nil {
+				parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			tool := map[string]any{
+				"type":       "function",
+				"name":       function.Name,
+				"parameters": parameters,
+			}
+			if function.Description != "" {
+				tool["description"] = function.Description
+			}
+			tools = append(tools, tool)
+		}
+		if declaration.GoogleSearch != nil || declaration.GoogleSearchRetrieval != nil {
+			tool := map[string]any{"type": dto.BuildInToolWebSearchPreview}
+			registerChatBuiltInTool(info, dto.BuildInToolWebSearchPreview, tool)
+			tools = append(tools, tool)
+		}
+		if declaration.CodeExecution != nil {
+			tool := map[string]any{"type": dto.BuildInToolCodeInterpreter, "container": map[string]any{"type": "auto"}}
+			registerChatBuiltInTool(info, dto.BuildInToolCodeInterpreter, tool)
+			tools = append(tools, tool)
+		}
+		if declaration.URLContext != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolUnsupported, http.StatusBadRequest, "urlContext tool is not supported when the request is routed to an OpenAI Responses channel")
+		}
+	}
+	if len(tools) == 0 {
+		return nil, nil
+	}
+	toolsData, err := common.Marshal(tools)
+	if err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "failed to marshal tools: %s", err.Error())
+	}
+	return toolsData, nil
+}
+
+// geminiSchemaToJSONSchema 将 Gemini 的 OpenAPI 风格 Schema 转换为 JSON Schema
+// Gemini 的 type 使用大写（如 OBJECT、STRING），nullable 表示可为 null
+func geminiSchemaToJSONSchema(schema any) any {
+	switch value := schema.(type) {
+	case map[string]any:
+		converted := make(map[string]any, len(value))
+		for key, item := range value {
+			switch key {
+			case "type":
+				if typeName, ok := item.(string); ok {
+					converted[key] = strings.ToLower(typeName)
+					continue
+				}
+				converted[key] = item
+			case "nullable":
+				// 与 type 合并，见下方处理
+			default:
+				converted[key] = geminiSchemaToJSONSchema(item)
+			}
+		}
+		if nullable, _ := value["nullable"].(bool); nullable {
+			if typeName, ok := converted["type"].(string); ok {
+				converted["type"] = []any{typeName, "null"}
+			}
+		}
+		return converted
+	case []any:
+		converted := make([]any, len(value))
+		for i, item := range value {
+			converted[i] = geminiSchemaToJSONSchema(item)
+		}
+		return converted
+	}
+	return schema
+}
+
+// convertGeminiFunctionCallingConfig 将 functionCallingConfig 转换为 Responses 的 tool_choice
+// ANY 限定了 allowedFunctionNames 时，单个函数转换为指定函数，多个函数转换为 allowed_tools
+func convertGeminiFunctionCallingConfig(config *dto.FunctionCallingConfig) (any, error) {
+	switch strings.ToUpper(string(config.Mode)) {
+	case "", "MODE_UNSPECIFIED", "AUTO", "VALIDATED":
+		return nil, nil
+	case "NONE":
+		return "none", nil
+	case "ANY":
+		switch len(config.AllowedFunctionNames) {
+		case 0:
+			return "required", nil
+		case 1:
+			return map[string]any{"type": "function", "name": config.AllowedFunctionNames[0]}, nil
+		}
+		allowed := make([]map[string]any, 0, len(config.AllowedFunctionNames))
+		for _, name := range config.AllowedFunctionNames {
+			allowed = append(allowed, map[string]any{"type": "function", "name": name})
+		}
+		return map[string]any{"type": "allowed_tools", "mode": "required", "tools": allowed}, nil
+	}
+	return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "unsupported functionCallingConfig.mode: %s", config.Mode)
+}
+
+// geminiResponseFormat 将 responseMimeType 与 responseSchema 转换为 Chat 的 response_format，未要求 JSON 输出时返回 nil
+func geminiResponseFormat(config dto.GeminiChatGenerationConfig) *dto.ResponseFormat {
+	if config.ResponseMimeType != "application/json" {
+		return nil
+	}
+	var schema any
+	if len(config.ResponseJsonSchema) > 0 {
+		schema = config.ResponseJsonSchema
+	} else if config.ResponseSchema != nil {
+		schema = geminiSchemaToJSONSchema(config.ResponseSchema)
+	}
+	if schema == nil {
+		return &dto.ResponseFormat{Type: "json_object"}
+	}
+	jsonSchema, err := common.Marshal(map[string]any{"name": "response", "schema": schema})
+	if err != nil {
+		return &dto.ResponseFormat{Type: "json_object"}
+	}
+	return &dto.ResponseFormat{Type: "json_schema", JsonSchema: jsonSchema}
+}
@@ -0,0 +1,393 @@
+package openai_responses
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponsesToGeminiHandler 处理从 Responses API 到 Gemini generateContent 的响应转换
+func ResponsesToGeminiHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	defer service.CloseResponseBodyGracefully(resp)
+
+	// 读取 Responses API 响应，上游为流式时先聚合为非流式响应体
+	responseBody, apiErr := helper.ReadResponsesBody(info, resp)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if !utf8.Valid(responseBody) {
+		responseBody = []byte(strings.ToValidUTF8(string(responseBody), ""))
+	}
+	info.ResponseBody = string(responseBody)
+
+	// 严格校验上游响应结构，不符合预期时隔离并原样透传，避免输出不完整的转换结果
+	if model_setting.GetResponsesSettings().StrictSchemaValidation {
+		if schemaErr := service.ValidateResponsesPayload(responseBody); schemaErr != nil {
+			service.QuarantineResponsesPayload(info.ChannelId, schemaErr.Error(), responseBody)
+			return service.PassthroughResponsesPayload(c, resp, info, responseBody), nil
+		}
+	}
+
+	var responsesResponse dto.OpenAIResponsesResponse
+	if err := common.Unmarshal(responseBody, &responsesResponse); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	if oaiError := responsesResponse.GetOpenAIError(); oaiError != nil && oaiError.Type != "" {
+		return nil, types.WithOpenAIError(*oaiError, resp.StatusCode)
+	}
+
+	// 转换为 Gemini 格式
+	geminiResponse := ResponsesToGeminiResponse(&responsesResponse)
+	MarkImageGenerationCalls(c, &responsesResponse)
+
+	// 按运营配置对回复文本做后处理
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	for i := range geminiResponse.Candidates {
+		parts := geminiResponse.Candidates[i].Content.Parts
+		for j := range parts {
+			if parts[j].Text != "" && !parts[j].Thought {
+				parts[j].Text = postProcessor.Process(parts[j].Text)
+			}
+		}
+	}
+
+	jsonData, err := common.Marshal(geminiResponse)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeJsonMarshalFailed, http.StatusInternalServerError)
+	}
+	if !isValidUTF8Bytes(jsonData) {
+		jsonData = cleanInvalidUTF8Bytes(jsonData)
+	}
+	service.IOCopyBytesGracefully(c, resp, jsonData)
+
+	// 计算使用量
+	usage := dto.Usage{}
+	if responsesResponse.Usage != nil {
+		usage.PromptTokens = responsesResponse.Usage.InputTokens
+		usage.CompletionTokens = responsesResponse.Usage.OutputTokens
+		usage.TotalTokens = responsesResponse.Usage.TotalTokens
+		if responsesResponse.Usage.InputTokensDetails != nil {
+			usage.PromptTokensDetails.CachedTokens = responsesResponse.Usage.InputTokensDetails.CachedTokens
+		}
+	}
+	countGeminiBuiltInToolCalls(info, responsesResponse.Output)
+
+	if responsesResponse.Status == "completed" {
+		service.SaveManagedConversationState(info, responsesResponse.ID)
+	}
+	return &usage, nil
+}
+
+// ResponsesToGeminiStreamHandler 处理从 Responses API 流式到 Gemini 流式（alt=sse）的响应转换
+// 文本、思考摘要、函数调用与生成的图片分别作为单独的分块发送，结束时发送带 finishReason 与完整 usageMetadata 的分块
+func ResponsesToGeminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	if resp == nil || resp.Body == nil {
+		logger.LogError(c, "invalid response or response body")
+		return nil, types.NewError(fmt.Errorf("invalid response"), types.ErrorCodeBadResponse)
+	}
+
+	defer service.CloseResponseBodyGracefully(resp)
+
+	var usage = &dto.Usage{}
+	responseTextCounter := service.NewStreamTextCounter(info.UpstreamModelName)
+	info.StreamTokenCounter = responseTextCounter
+
+	// 用于收集完整的流式响应体
+	fullStreamResponse := service.NewStreamBodyCapture()
+
+	// 流式增量去重
+	dedupGuard := helper.NewStreamDedupGuard()
+
+	// 网关输出 token 上限
+	outputBudget := service.NewOutputTokenBudget(info)
+
+	// 回复文本后处理，按行缓存增量
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	// 上游流中途中断时从上游保存的响应恢复
+	resume := newStreamResumeTracker()
+	sendParts := func(parts ...dto.GeminiPart) {
+		sendGeminiStreamData(c, geminiStreamChunk(parts, nil, geminiUsageMetadata(info.PromptTokens, 0, 0)))
+	}
+	flushPostProcessor := func() {
+		if rest := postProcessor.Flush(); rest != "" {
+			sendParts(dto.GeminiPart{Text: rest})
+		}
+	}
+	finished := false
+	finish := func(finishReason string) {
+		flushPostProcessor()
+		completionTokens := usage.CompletionTokens
+		if completionTokens == 0 {
+			completionTokens = responseTextCounter.TokenCount()
+		}
+		promptTokens := usage.PromptTokens
+		if promptTokens == 0 {
+			promptTokens = info.PromptTokens
+		}
+		sendGeminiStreamData(c, geminiStreamChunk(nil, &finishReason, geminiUsageMetadata(promptTokens, completionTokens, usage.PromptTokensDetails.CachedTokens)))
+		finished = true
+	}
+
+	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+		// 收集流式响应数据
+		fullStreamResponse.Append(data)
+
+		var streamResponse dto.ResponsesStreamResponse
+		if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+			logger.LogError(c, "failed to unmarshal stream response: "+err.Error())
+			return true
+		}
+		resume.Observe(&streamResponse)
+		dedupGuard.Observe(&streamResponse)
+
+		switch streamResponse.Type {
+		case "response.output_text.delta":
+			delta := dedupGuard.Filter(&streamResponse)
+			// 超出输出上限时以 MAX_TOKENS 结束并终止流
+			if !outputBudget.Consume(delta) {
+				finish(geminiFinishReason(constant.FinishReasonLength))
+				resume.End()
+				return false
+			}
+			responseTextCounter.WriteString(delta)
+			resume.Deliver(delta)
+			if text := postProcessor.Push(delta); text != "" {
+				sendParts(dto.GeminiPart{Text: text})
+			}
+		case "response.reasoning_summary_text.delta":
+			if streamResponse.Delta != "" {
+				responseTextCounter.WriteString(streamResponse.Delta)
+				flushPostProcessor()
+				sendParts(dto.GeminiPart{Text: streamResponse.Delta, Thought: true})
+			}
+		case dto.ResponsesOutputTypeItemDone:
+			if streamResponse.Item == nil {
+				break
+			}
+			countGeminiBuiltInToolCalls(info, []dto.ResponsesOutput{*streamResponse.Item})
+			if part, ok := responsesOutputToGeminiPart(streamResponse.Item); ok {
+				flushPostProcessor()
+				if streamResponse.Item.Type == dto.ResponsesOutputTypeFunctionCall {
+					responseTextCounter.WriteString(streamResponse.Item.Arguments)
+				}
+				MarkImageGenerationCall(c, streamResponse.Item)
+				sendParts(part)
+			}
+		}
+
+		if (helper.IsResponsesTerminalEvent(streamResponse.Type) || streamResponse.Type == "response.incomplete") && streamResponse.Response != nil {
+			if streamResponse.Response.Status == "completed" {
+				service.SaveManagedConversationState(info, streamResponse.Response.ID)
+			}
+			if responseUsage := streamResponse.Response.Usage; responseUsage != nil {
+				usage.PromptTokens = responseUsage.InputTokens
+				usage.CompletionTokens = responseUsage.OutputTokens
+				usage.TotalTokens = responseUsage.TotalTokens
+				if responseUsage.InputTokensDetails != nil {
+					usage.PromptTokensDetails.CachedTokens = responseUsage.InputTokensDetails.CachedTokens
+				}
+			}
+			finish(geminiFinishReason(extractFinishReason(streamResponse.Response)))
+		}
+		return true
+	})
+
+	// 上游流中途中断时补发最终响应中尚未下发的文本与函数调用并正常结束
+	if result, ok := resume.Recover(c, info); ok {
+		recovered := result.Response
+		if result.Tail != "" {
+			responseTextCounter.WriteString(result.Tail)
+			if text := postProcessor.Push(result.Tail); text != "" {
+				sendParts(dto.GeminiPart{Text: text})
+			}
+		}
+		flushPostProcessor()
+		for _, call := range result.ToolCalls {
+			if part, ok := responsesOutputToGeminiPart(&call.Item); ok {
+				responseTextCounter.WriteString(call.Item.Arguments)
+				sendParts(part)
+			}
+		}
+		if recovered.Usage != nil {
+			usage.PromptTokens = recovered.Usage.InputTokens
+			usage.CompletionTokens = recovered.Usage.OutputTokens
+			usage.TotalTokens = recovered.Usage.TotalTokens
+			if recovered.Usage.InputTokensDetails != nil {
+				usage.PromptTokensDetails.CachedTokens = recovered.Usage.InputTokensDetails.CachedTokens
+			}
+		}
+		finish(geminiFinishReason(extractFinishReason(recovered)))
+	}
+
+	// 上游未返回完成事件时补发结束分块
+	if !finished && !info.DeadlineExceeded {
+		finish(geminiFinishReason(constant.FinishReasonStop))
+	}
+
+	// 将完整的流式响应体存储到 relayInfo 中
+	info.ResponseBody = fullStreamResponse.String()
+
+	// 备用 token 计算
+	if usage.CompletionTokens == 0 && !responseTextCounter.IsEmpty() {
+		usage.CompletionTokens = responseTextCounter.TokenCount()
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens != 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return usage, nil
+}
+
+// ResponsesToGeminiResponse 将 Responses API 响应转换为 Gemini generateContent 响应
+// 推理摘要转换为 thought part，文本、函数调用与生成的图片按输出顺序转换为对应的 part
+func ResponsesToGeminiResponse(responsesResponse *dto.OpenAIResponsesResponse) *dto.GeminiChatResponse {
+	parts := make([]dto.GeminiPart, 0, len(responsesResponse.Output))
+	for i := range responsesResponse.Output {
+		item := &responsesResponse.Output[i]
+		switch item.Type {
+		case dto.ResponsesOutputTypeReasoning:
+			var summaries []string
+			for _, summary := range item.Summary {
+				if summary.Text != "" {
+					summaries = append(summaries, summary.Text)
+				}
+			}
+			if len(summaries) > 0 {
+				parts = append(parts, dto.GeminiPart{Text: strings.Join(summaries, "\n\n"), Thought: true})
+			}
+		case dto.ResponsesOutputTypeMessage:
+			if item.Role != "assistant" {
+				continue
+			}
+			for _, content := range item.Content {
+				if content.Type == "output_text" && content.Text != "" {
+					parts = append(parts, dto.GeminiPart{Text: content.Text})
+				}
+			}
+		default:
+			if part, ok := responsesOutputToGeminiPart(item); ok {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	finishReason := geminiFinishReason(extractFinishReason(responsesResponse))
+	usageMetadata := dto.GeminiUsageMetadata{}
+	if responsesResponse.Usage != nil {
+		cachedTokens := 0
+		if responsesResponse.Usage.InputTokensDetails != nil {
+			cachedTokens = responsesResponse.Usage.InputTokensDetails.CachedTokens
+		}
+		usageMetadata = geminiUsageMetadata(responsesResponse.Usage.InputTokens, responsesResponse.Usage.OutputTokens, cachedTokens)
+	}
+	response := geminiStreamChunk(parts, &finishReason, usageMetadata)
+	return &response
+}
+
+// responsesOutputToGeminiPart 将函数调用与生成的图片输出项转换为 Gemini part，其他输出项返回 false
+func responsesOutputToGeminiPart(item *dto.ResponsesOutput) (dto.GeminiPart, bool) {
+	switch item.Type {
+	case dto.ResponsesOutputTypeFunctionCall:
+		// arguments 不是合法 JSON 对象时使用空对象，保证 args 字段结构正确
+		args := map[string]any{}
+		if item.Arguments != "" {
+			if err := common.UnmarshalJsonStr(item.Arguments, &args); err != nil {
+				args = map[string]any{}
+			}
+		}
+		return dto.GeminiPart{FunctionCall: &dto.FunctionCall{FunctionName: item.Name, Arguments: args}}, true
+	case dto.ResponsesOutputTypeImageGenerationCall:
+		if item.Result == "" {
+			return dto.GeminiPart{}, false
+		}
+		return dto.GeminiPart{InlineData: &dto.GeminiInlineData{MimeType: generatedImageMimeType(item), Data: item.Result}}, true
+	}
+	return dto.GeminiPart{}, false
+}
+
+// geminiFinishReason 将 Chat Completions 的 finish_reason 转换为 Gemini 的 finishReason
+// Responses 的状态先按 finish_reason_mapping 映射为 Chat 的取值，保证各转换方向的结束原因一致
+func geminiFinishReason(chatFinishReason string) string {
+	switch chatFinishReason {
+	case constant.FinishReasonLength:
+		return "MAX_TOKENS"
+	case constant.FinishReasonContentFilter:
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+// geminiUsageMetadata 生成 Gemini 的 usageMetadata，Gemini 的 promptTokenCount 包含缓存命中部分
+func geminiUsageMetadata(promptTokens int, completionTokens int, cachedTokens int) dto.GeminiUsageMetadata {
+	metadata := dto.GeminiUsageMetadata{
+		PromptTokenCount:     promptTokens,
+		CandidatesTokenCount: completionTokens,
+		TotalTokenCount:      promptTokens + completionTokens,
+	}
+	if cachedTokens > 0 {
+		metadata.CachedContentTokenCount = cachedTokens
+	}
+	return metadata
+}
+
+// geminiStreamChunk 生成只有一个候选的 Gemini 响应
+func geminiStreamChunk(parts []dto.GeminiPart, finishReason *string, usageMetadata dto.GeminiUsageMetadata) dto.GeminiChatResponse {
+	if parts == nil {
+		parts = []dto.GeminiPart{}
+	}
+	return dto.GeminiChatResponse{
+		Candidates: []dto.GeminiChatCandidate{{
+			Content:       dto.GeminiChatContent{Role: "model", Parts: parts},
+			FinishReason:  finishReason,
+			SafetyRatings: []dto.GeminiChatSafetyRating{},
+		}},
+		UsageMetadata: usageMetadata,
+	}
+}
+
+// countGeminiBuiltInToolCalls 按上游实际执行的内置工具调用计数，用于按次计费
+func countGeminiBuiltInToolCalls(info *relaycommon.RelayInfo, output []dto.ResponsesOutput) {
+	if info.ResponsesUsageInfo == nil || info.ResponsesUsageInfo.BuiltInTools == nil {
+		return
+	}
+	for _, item := range output {
+		toolType := ""
+		switch item.Type {
+		case dto.BuildInCallWebSearchCall:
+			toolType = dto.BuildInToolWebSearchPreview
+		case dto.BuildInCallCodeInterpreterCall:
+			toolType = dto.BuildInToolCodeInterpreter
+		}
+		if builtInTool, ok := info.ResponsesUsageInfo.BuiltInTools[toolType]; ok && builtInTool != nil {
+			builtInTool.CallCount++
+		}
+	}
+}
+
+// sendGeminiStreamData 发送 Gemini 流式数据
+func sendGeminiStreamData(c *gin.Context, response dto.GeminiChatResponse) {
+	jsonData, err := common.Marshal(response)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("Failed to marshal gemini stream response: %v", err))
+		return
+	}
+	if !isValidUTF8Bytes(jsonData) {
+		jsonData = cleanInvalidUTF8Bytes(jsonData)
+	}
+	c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonData)})
+	_ = helper.FlushWriter(c)
+}
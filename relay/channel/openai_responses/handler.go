@@ -3,17 +3,18 @@ package openai_responses
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -23,22 +24,17 @@ import (
 func ResponsesToChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	defer service.CloseResponseBodyGracefully(resp)
 
-	// 获取原始请求（用于转换时参考）
-	originalRequest, exists := c.Get("original_chat_request")
-	if !exists {
-		return nil, types.NewError(fmt.Errorf("original chat request not found"), types.ErrorCodeInvalidRequest)
+	// 获取原始请求（用于转换时参考），丢失时按 RelayInfo 重建，不因此让整个请求失败
+	chatRequest, rebuilt := info.OriginalChatRequest()
+	if rebuilt {
+		logger.LogWarn(c, fmt.Sprintf("original chat request not found (%T), rebuilt from relay info", info.OriginalRequest))
 	}
 
-	chatRequest, ok := originalRequest.(*dto.GeneralOpenAIRequest)
-	if !ok {
-		return nil, types.NewError(fmt.Errorf("invalid original request type"), types.ErrorCodeInvalidRequest)
-	}
-
-	// 读取 Responses API 响应
+	// 读取 Responses API 响应，上游为流式时先聚合为非流式响应体
 	var responsesResponse dto.OpenAIResponsesResponse
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	responseBody, apiErr := helper.ReadResponsesBody(info, resp)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 // 检查并清理响应体中的无效UTF-8字符
 	if !utf8.Valid(responseBody) {
@@ -48,7 +44,15 @@ func ResponsesToChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 	// 将响应体存储到 relayInfo 中
 	info.ResponseBody = string(responseBody)
 
-	err = common.Unmarshal(responseBody, &responsesResponse)
+	// 严格校验上游响应结构，不符合预期时隔离并原样透传，避免输出不完整的转换结果
+	if model_setting.GetResponsesSettings().StrictSchemaValidation {
+		if schemaErr := service.ValidateResponsesPayload(responseBody); schemaErr != nil {
+			service.QuarantineResponsesPayload(info.ChannelId, schemaErr.Error(), responseBody)
+			return service.PassthroughResponsesPayload(c, resp, info, responseBody), nil
+		}
+	}
+
+	err := common.Unmarshal(responseBody, &responsesResponse)
 	if err != nil {
 		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
 	}
@@ -64,6 +68,15 @@ func ResponsesToChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 		logger.LogError(c, fmt.Sprintf("Failed to convert responses to chat format: %v", err))
 		return nil, types.NewError(err, types.ErrorCodeBadResponse)
 	}
+	MarkImageGenerationCalls(c, &responsesResponse)
+
+	// 按运营配置对回复文本做后处理
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	for i := range chatResponse.Choices {
+		if content, ok := chatResponse.Choices[i].Message.Content.(string); ok && content != "" {
+			chatResponse.Choices[i].Message.Content = postProcessor.Process(content)
+		}
+	}
 
 	// 序列化 Chat Completions 响应
 	jsonData, err := json.Marshal(chatResponse)
@@ -76,7 +89,8 @@ func ResponsesToChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 		jsonData = cleanInvalidUTF8Bytes(jsonData)
 	}
 
-	// 写入转换后的响应体
+	// 按配置将上游 ID 替换为网关 ID 后写入转换后的响应体
+	jsonData = service.NewResponseIdMapper(info).Rewrite(jsonData)
 	service.IOCopyBytesGracefully(c, resp, jsonData)
 
 	// 计算使用量
@@ -90,16 +104,13 @@ func ResponsesToChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 		}
 	}
 
-	// 处理内置工具用量统计
-	if info != nil && info.ResponsesUsageInfo != nil && info.ResponsesUsageInfo.BuiltInTools != nil {
-		for _, tool := range responsesResponse.Tools {
-			buildToolinfo, ok := info.ResponsesUsageInfo.BuiltInTools[common.Interface2String(tool["type"])]
-			if !ok || buildToolinfo == nil {
-				logger.LogError(c, fmt.Sprintf("BuiltInTools not found for tool type: %v", tool["type"]))
-				continue
-			}
-			buildToolinfo.CallCount++
-		}
+	// 处理内置工具用量统计，按输出中实际执行的 *_call 项计数，与流式处理一致
+	for _, output := range responsesResponse.Output {
+		countBuiltInToolCall(info, output.Type)
+	}
+
+	if responsesResponse.Status == "completed" {
+		service.SaveManagedConversationState(info, responsesResponse.ID)
 	}
 
 	return &usage, nil
@@ -116,38 +127,99 @@ func ResponsesToChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, r
 	defer service.CloseResponseBodyGracefully(resp)
 
 	var usage = &dto.Usage{}
-	var responseTextBuilder strings.Builder
+	responseTextCounter := service.NewStreamTextCounter(info.UpstreamModelName)
+	info.StreamTokenCounter = responseTextCounter
 
 // 用于收集完整的流式响应体
-	var fullStreamResponse strings.Builder
+	fullStreamResponse := service.NewStreamBodyCapture()
 
 	// 获取响应ID，用于流式响应
 	var responseID string
 
+	// 流式增量去重
+	dedupGuard := helper.NewStreamDedupGuard()
+
+	// 网关输出 token 上限
+	outputBudget := service.NewOutputTokenBudget(info)
+
+	// 有状态转换器，跟踪函数调用输出项
+	chatConverter := NewChatStreamConverter(info.UpstreamModelName)
+
+	// 按配置将上游 ID 替换为网关 ID
+	idMapper := service.NewResponseIdMapper(info)
+
+	// 上游流中途中断时从上游保存的响应恢复
+	resume := newStreamResumeTracker()
+
+	// 回复文本后处理，按行缓存增量
+	postProcessor := service.NewResponsePostProcessor(info.ChannelId, info.OriginModelName, info.UpstreamModelName)
+	// 实验性：合并细碎的文本增量
+	coalescer := helper.NewDeltaCoalescer(service.IsTokenFeatureEnabled(c, service.FeatureFlagDeltaCoalescing))
+	coalescer.OnTimeout(c, func(text string) {
+		sendChatStreamData(c, *chatConverter.TextDelta(responseID, text))
+	})
+	flushPostProcessor := func() {
+		if rest := coalescer.Push(postProcessor.Flush()) + coalescer.Flush(); rest != "" {
+			sendChatStreamData(c, *chatConverter.TextDelta(responseID, rest))
+		}
+	}
+
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		// 收集流式响应数据
-		fullStreamResponse.WriteString(data)
-		fullStreamResponse.WriteString("\n")
+		fullStreamResponse.Append(data)
 
 		// 解析 Responses API 流式响应
 		var streamResponse dto.ResponsesStreamResponse
 		if err := common.UnmarshalJsonStr(data, &streamResponse); err == nil {
 			// 获取响应ID
 			if streamResponse.Response != nil && streamResponse.Response.ID != "" {
-				responseID = streamResponse.Response.ID
+				responseID = idMapper.GatewayId(streamResponse.Response.ID)
+			}
+			resume.Observe(&streamResponse)
+			dedupGuard.Observe(&streamResponse)
+
+			// 上游原始增量，用于备用 token 计算
+			rawDelta := ""
+			if streamResponse.Type == "response.output_text.delta" {
+				streamResponse.Delta = dedupGuard.Filter(&streamResponse)
+				// 超出输出上限时以 length 结束并终止流
+				if !outputBudget.Consume(streamResponse.Delta) {
+					flushPostProcessor()
+					sendChatStreamData(c, *helper.GenerateStopResponse(responseID, chatConverter.Created(), info.UpstreamModelName, constant.FinishReasonLength))
+					resume.End()
+					return false
+				}
+				rawDelta = streamResponse.Delta
+				resume.Deliver(rawDelta)
+				streamResponse.Delta = coalescer.Push(postProcessor.Push(streamResponse.Delta))
+			}
+			// 上游可能以 response.completed、response.incomplete 或旧版的 response.done 结束
+			terminal := isChatStreamTerminalEvent(streamResponse.Type)
+			if terminal {
+				flushPostProcessor()
 			}
 
 			// 转换为 Chat Completions 流式格式
-			chatStreamResp := ConvertResponsesStreamToChatStream(&streamResponse, responseID, info.UpstreamModelName)
+			chatStreamResp, toolArgsDelta := chatConverter.Convert(&streamResponse, responseID)
 			if chatStreamResp != nil {
 				// 发送转换后的流式数据
 				sendChatStreamData(c, *chatStreamResp)
 			}
+			if toolArgsDelta != "" {
+				responseTextCounter.WriteString(toolArgsDelta)
+			}
 
 			// 处理使用量统计
-			switch streamResponse.Type {
-			case "response.done":
+			switch {
+			case terminal:
 				if streamResponse.Response != nil {
+					// failed 状态额外发送错误块，finish_reason 已映射为合法值
+					if streamResponse.Response.Status == "failed" {
+						_ = helper.ObjectData(c, gin.H{"error": buildFailedResponseError(streamResponse.Response)})
+					}
+					if streamResponse.Response.Status == "completed" {
+						service.SaveManagedConversationState(info, streamResponse.Response.ID)
+					}
 					if streamResponse.Response.Usage != nil {
 						if streamResponse.Response.Usage.InputTokens != 0 {
 							usage.PromptTokens = streamResponse.Response.Usage.InputTokens
@@ -163,19 +235,18 @@ func ResponsesToChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, r
 						}
 					}
 				}
-			case "response.output_text.delta":
+			case streamResponse.Type == "response.output_text.delta":
 				// 处理输出文本用于备用 token 计算
-				responseTextBuilder.WriteString(streamResponse.Delta)
-			case dto.ResponsesOutputTypeItemDone:
+				responseTextCounter.WriteString(rawDelta)
+			case streamResponse.Type == dto.ResponsesOutputTypeItemDone:
 				// 函数调用处理
 				if streamResponse.Item != nil {
-					switch streamResponse.Item.Type {
-					case dto.BuildInCallWebSearchCall:
-						if info != nil && info.ResponsesUsageInfo != nil && info.ResponsesUsageInfo.BuiltInTools != nil {
-							if webSearchTool, exists := info.ResponsesUsageInfo.BuiltInTools[dto.BuildInToolWebSearchPreview]; exists && webSearchTool != nil {
-								webSearchTool.CallCount++
-							}
-						}
+					countBuiltInToolCall(info, streamResponse.Item.Type)
+					// 生成的图片以 Markdown 图片作为文本增量发送
+					if streamResponse.Item.Type == dto.ResponsesOutputTypeImageGenerationCall && streamResponse.Item.Result != "" {
+						MarkImageGenerationCall(c, streamResponse.Item)
+						flushPostProcessor()
+						sendChatStreamData(c, *chatConverter.TextDelta(responseID, generatedImageMarkdown(streamResponse.Item)))
 					}
 				}
 			}
@@ -184,17 +255,47 @@ func ResponsesToChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, r
 		}
 		return true
 	})
+	coalescer.Stop()
+
+	// 上游流中途中断时补发最终响应中尚未下发的文本与函数调用并正常结束
+	if result, ok := resume.Recover(c, info); ok {
+		recovered := result.Response
+		if result.Tail != "" {
+			responseTextCounter.WriteString(result.Tail)
+			if text := coalescer.Push(postProcessor.Push(result.Tail)); text != "" {
+				sendChatStreamData(c, *chatConverter.TextDelta(responseID, text))
+			}
+		}
+		flushPostProcessor()
+		for _, call := range result.ToolCalls {
+			for _, event := range call.Events() {
+				chatStreamResp, toolArgsDelta := chatConverter.Convert(&event, responseID)
+				if chatStreamResp != nil {
+					sendChatStreamData(c, *chatStreamResp)
+				}
+				responseTextCounter.WriteString(toolArgsDelta)
+			}
+		}
+		finishReason := extractFinishReason(recovered)
+		if finishReason == constant.FinishReasonStop && chatConverter.HasToolCalls() {
+			finishReason = constant.FinishReasonToolCalls
+		}
+		sendChatStreamData(c, *helper.GenerateStopResponse(responseID, chatConverter.Created(), info.UpstreamModelName, finishReason))
+		if recovered.Usage != nil {
+			usage.PromptTokens = recovered.Usage.InputTokens
+			usage.CompletionTokens = recovered.Usage.OutputTokens
+			if recovered.Usage.InputTokensDetails != nil {
+				usage.PromptTokensDetails.CachedTokens = recovered.Usage.InputTokensDetails.CachedTokens
+			}
+		}
+	}
 
 	// 将完整的流式响应体存储到 relayInfo 中
 	info.ResponseBody = fullStreamResponse.String()
 
 	// 备用 token 计算
-	if usage.CompletionTokens == 0 {
-		tempStr := responseTextBuilder.String()
-		if len(tempStr) > 0 {
-			completionTokens := service.CountTextToken(tempStr, info.UpstreamModelName)
-			usage.CompletionTokens = completionTokens
-		}
+	if usage.CompletionTokens == 0 && !responseTextCounter.IsEmpty() {
+		usage.CompletionTokens = responseTextCounter.TokenCount()
 	}
 
 	if usage.PromptTokens == 0 && usage.CompletionTokens != 0 {
@@ -203,6 +304,11 @@ func ResponsesToChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, r
 
 	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 
+	// 超过截止时间时已发送超时错误事件作为结束，其余情况（包括上游未发送终止事件）都以 [DONE] 结束
+	if !info.DeadlineExceeded {
+		chatConverter.Finish(c, info, usage)
+	}
+
 	return usage, nil
 }
 
@@ -223,4 +329,24 @@ func sendChatStreamData(c *gin.Context, response dto.ChatCompletionsStreamRespon
 	data := fmt.Sprintf("data: %s\n\n", string(jsonData))
 	c.Writer.Write([]byte(data))
 	c.Writer.Flush()
-}
\ No newline at end of file
+}
+// countBuiltInToolCall 输出项为内置工具调用时累加对应工具的调用次数，用于按次计费
+func countBuiltInToolCall(info *relaycommon.RelayInfo, itemType string) {
+	if info == nil || info.ResponsesUsageInfo == nil || info.ResponsesUsageInfo.BuiltInTools == nil {
+		return
+	}
+	toolType := ""
+	switch itemType {
+	case dto.BuildInCallWebSearchCall:
+		toolType = dto.BuildInToolWebSearchPreview
+	case dto.BuildInCallFileSearchCall:
+		toolType = dto.BuildInToolFileSearch
+	case dto.BuildInCallCodeInterpreterCall:
+		toolType = dto.BuildInToolCodeInterpreter
+	default:
+		return
+	}
+	if builtInTool, exists := info.ResponsesUsageInfo.BuiltInTools[toolType]; exists && builtInTool != nil {
+		builtInTool.CallCount++
+	}
+}
@@ -0,0 +1,60 @@
+package openai_responses
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MarkImageGenerationCall 记录上游的图片生成调用，按质量与尺寸计费，与原生 Responses 请求一致
+func MarkImageGenerationCall(c *gin.Context, item *dto.ResponsesOutput) {
+	if item == nil || item.Type != dto.ResponsesOutputTypeImageGenerationCall {
+		return
+	}
+	c.Set("image_generation_call", true)
+	c.Set("image_generation_call_quality", item.Quality)
+	c.Set("image_generation_call_size", item.Size)
+}
+
+// MarkImageGenerationCalls 记录响应中的图片生成调用，见 MarkImageGenerationCall
+func MarkImageGenerationCalls(c *gin.Context, response *dto.OpenAIResponsesResponse) {
+	for i := range response.Output {
+		if response.Output[i].Type == dto.ResponsesOutputTypeImageGenerationCall {
+			MarkImageGenerationCall(c, &response.Output[i])
+			return
+		}
+	}
+}
+
+// generatedImageMimeType 生成图片的 MIME 类型，上游未返回 output_format 时为 PNG
+func generatedImageMimeType(item *dto.ResponsesOutput) string {
+	switch item.OutputFormat {
+	case "jpeg", "jpg":
+		return "image/jpeg"
+	case "webp":
+		return "image/webp"
+	}
+	return "image/png"
+}
+
+// generatedImageDataURI 生成图片的 data URI
+func generatedImageDataURI(item *dto.ResponsesOutput) string {
+	return "data:" + generatedImageMimeType(item) + ";base64," + item.Result
+}
+
+// generatedImageMarkdown 以 Markdown 图片表示生成的图片，前后空行与相邻文本分隔
+func generatedImageMarkdown(item *dto.ResponsesOutput) string {
+	return "\n\n![image](" + generatedImageDataURI(item) + ")\n\n"
+}
+
+// generatedImageClaudeBlock 将生成的图片转换为 Claude 的 base64 图片块
+func generatedImageClaudeBlock(item *dto.ResponsesOutput) dto.ClaudeMediaMessage {
+	return dto.ClaudeMediaMessage{
+		Type: "image",
+		Source: &dto.ClaudeMessageSource{
+			Type:      "base64",
+			MediaType: generatedImageMimeType(item),
+			Data:      item.Result,
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package openai_responses
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamReplayEvent 回放过程中转换器下发给客户端的一个 SSE 事件
+type StreamReplayEvent struct {
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+}
+
+// StreamReplayResult 流式转换回放结果
+type StreamReplayResult struct {
+	ConvertedFrom relaycommon.ConversionSource `json:"converted_from"`
+	Events        []StreamReplayEvent          `json:"events"`
+	Usage         *dto.Usage                   `json:"usage,omitempty"`
+	Error         string                       `json:"error,omitempty"`
+	Output        string                       `json:"output"` // 下发给客户端的原始事件流
+}
+
+// ReplayStream 在进程内将录制的上游 Responses 事件流重新送入流式转换状态机，捕获转换器下发给客户端的事件，用于对照真实流验证转换器修复
+// 不会请求上游，也不会计费或写入日志
+// 参数:
+//   - source: 转换来源，chat 或 claude，决定使用的流式转换处理器
+//   - modelName: 模型名称，同时作为原始模型与上游模型
+//   - originalRequest: 转换前的原始请求 JSON，可以为空；为空时按模型名称重建最简请求
+//   - transcript: 录制的上游事件流，支持 RelayInfo.ResponseBody 记录的每行一个 data 负载，也支持带 data: 前缀的原始 SSE
+//
+// 返回:
+//   - *StreamReplayResult: 捕获的客户端事件与用量
+//   - error: 转换来源不支持或原始请求无法解析时返回错误
+func ReplayStream(source relaycommon.ConversionSource, modelName string, originalRequest string, transcript string) (*StreamReplayResult, error) {
+	info := &relaycommon.RelayInfo{
+		IsStream:        true,
+		DisablePing:     true,
+		StartTime:       time.Now(),
+		OriginModelName: modelName,
+		ChannelMeta: &relaycommon.ChannelMeta{
+			UpstreamModelName: modelName,
+		},
+	}
+	switch source {
+	case relaycommon.ConversionSourceChat:
+		info.RelayFormat = types.RelayFormatOpenAI
+		request := &dto.GeneralOpenAIRequest{Model: modelName, Stream: true}
+		if originalRequest != "" {
+			if err := common.UnmarshalJsonStr(originalRequest, request); err != nil {
+				return nil, fmt.Errorf("invalid original chat request: %w", err)
+			}
+		}
+		// 与 Chat 请求转换一致，仅在 stream_options.include_usage 为 true 时下发用量分块
+		info.ShouldIncludeUsage = chatStreamIncludeUsage(request)
+		info.MarkConverted(source, request)
+	case relaycommon.ConversionSourceClaude:
+		info.RelayFormat = types.RelayFormatClaude
+		request := &dto.ClaudeRequest{Model: modelName, Stream: true}
+		if originalRequest != "" {
+			if err := common.UnmarshalJsonStr(originalRequest, request); err != nil {
+				return nil, fmt.Errorf("invalid original claude request: %w", err)
+			}
+		}
+		info.MarkConverted(source, request)
+	default:
+		return nil, fmt.Errorf("unsupported conversion source: %q", source)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(normalizeReplayTranscript(transcript))),
+	}
+
+	var usage *dto.Usage
+	var apiErr *types.NewAPIError
+	if source == relaycommon.ConversionSourceChat {
+		usage, apiErr = ResponsesToChatStreamHandler(c, info, resp)
+	} else {
+		usage, apiErr = ResponsesToClaudeStreamHandler(c, info, resp)
+	}
+
+	result := &StreamReplayResult{
+		ConvertedFrom: source,
+		Events:        parseReplayEvents(recorder.Body.String()),
+		Usage:         usage,
+		Output:        recorder.Body.String(),
+	}
+	if apiErr != nil {
+		result.Error = apiErr.Error()
+	}
+	return result, nil
+}
+
+// normalizeReplayTranscript 将每行一个 data 负载的录制内容补全为 SSE 格式，已是 SSE 的行原样保留
+func normalizeReplayTranscript(transcript string) string {
+	var builder strings.Builder
+	for _, line := range strings.Split(transcript, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") && !strings.HasPrefix(line, "event:") {
+			line = "data: " + line
+		}
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// parseReplayEvents 将捕获的客户端输出按空行拆分为事件
+func parseReplayEvents(output string) []StreamReplayEvent {
+	events := make([]StreamReplayEvent, 0)
+	var current StreamReplayEvent
+	hasData := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64<<10), 10<<20)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if hasData || current.Event != "" {
+				events = append(events, current)
+			}
+			current = StreamReplayEvent{}
+			hasData = false
+		case strings.HasPrefix(line, "event:"):
+			current.Event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			if hasData {
+				current.Data += "\n" + data
+			} else {
+				current.Data = data
+			}
+			hasData = true
+		}
+	}
+	if hasData || current.Event != "" {
+		events = append(events, current)
+	}
+	return events
+}
@@ -0,0 +1,58 @@
+package openai_responses
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// convertResponseFormatToText 将 Chat Completions 的 response_format 转换为 Responses 的 text.format，并保留 text 中已有的其他参数
+// 参数:
+//   - text: Responses 请求中已有的 text 参数，可以为空
+//   - responseFormat: Chat Completions 请求中的 response_format
+//
+// 返回:
+//   - json.RawMessage: 合并后的 text 参数
+//   - error: json_schema 缺少 name 或无法解析时返回错误
+func convertResponseFormatToText(text json.RawMessage, responseFormat *dto.ResponseFormat) (json.RawMessage, error) {
+	format := map[string]any{"type": responseFormat.Type}
+	switch responseFormat.Type {
+	case "", "text":
+		return text, nil
+	case "json_object":
+	case "json_schema":
+		// Chat 的 json_schema 对象（name、description、schema、strict）在 Responses 中平铺到 format 上
+		var jsonSchema map[string]any
+		if len(responseFormat.JsonSchema) > 0 {
+			if err := common.Unmarshal(responseFormat.JsonSchema, &jsonSchema); err != nil {
+				return nil, types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "invalid response_format.json_schema: %s", err.Error())
+			}
+		}
+		if name, _ := jsonSchema["name"].(string); name == "" {
+			return nil, types.NewConvertError(types.ErrorCodeConvertRequestInvalid, http.StatusBadRequest, "response_format.json_schema.name is required")
+		}
+		for key, value := range jsonSchema {
+			if key != "type" {
+				format[key] = value
+			}
+		}
+	default:
+		return nil, types.NewConvertError(types.ErrorCodeConvertParamUnsupported, http.StatusBadRequest, "unsupported response_format type: %s", responseFormat.Type)
+	}
+
+	textMap := make(map[string]any)
+	if len(text) > 0 {
+		if err := common.Unmarshal(text, &textMap); err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to parse text: %s", err.Error())
+		}
+	}
+	textMap["format"] = format
+	textData, err := common.Marshal(textMap)
+	if err != nil {
+		return nil, types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal text: %s", err.Error())
+	}
+	return textData, nil
+}
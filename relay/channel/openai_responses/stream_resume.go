@@ -0,0 +1,236 @@
+package openai_responses
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 轮询上游存储响应的间隔
+const streamResumePollInterval = 2 * time.Second
+
+// streamResumeTracker 跟踪转换后的流式响应，上游流在完成前中断且上游保存了响应时，
+// 按响应 ID 获取最终响应，计算客户端尚未收到的剩余文本与函数调用
+type streamResumeTracker struct {
+	responseID string
+	stored     bool
+	ended      bool
+	delivered  strings.Builder
+	// startedCalls 已开始下发的函数调用输出项，doneCalls 已完整下发的函数调用输出项
+	startedCalls map[string]bool
+	doneCalls    map[string]bool
+}
+
+// streamResumeResult 从上游保存的响应恢复的内容
+type streamResumeResult struct {
+	Response *dto.OpenAIResponsesResponse
+	// Tail 客户端尚未收到的剩余文本
+	Tail string
+	// ToolCalls 客户端尚未收到的函数调用，按输出顺序排列
+	ToolCalls []streamResumeToolCall
+}
+
+// streamResumeToolCall 需要补发的函数调用
+type streamResumeToolCall struct {
+	OutputIndex int
+	Item        dto.ResponsesOutput
+}
+
+// Events 生成补发函数调用的流事件：携带完整参数的 output_item.added 与 output_item.done
+func (t streamResumeToolCall) Events() []dto.ResponsesStreamResponse {
+	item := t.Item
+	return []dto.ResponsesStreamResponse{
+		{Type: "response.output_item.added", OutputIndex: common.GetPointer[int](t.OutputIndex), ItemId: item.ID, Item: &item},
+		{Type: dto.ResponsesOutputTypeItemDone, OutputIndex: common.GetPointer[int](t.OutputIndex), ItemId: item.ID, Item: &item},
+	}
+}
+
+// newStreamResumeTracker 创建流式恢复跟踪器
+func newStreamResumeTracker() *streamResumeTracker {
+	return &streamResumeTracker{
+		startedCalls: make(map[string]bool),
+		doneCalls:    make(map[string]bool),
+	}
+}
+
+// Observe 记录流事件中的响应 ID、上游是否保存响应、函数调用的下发进度以及流是否已正常结束
+func (t *streamResumeTracker) Observe(event *dto.ResponsesStreamResponse) {
+	if event.Response != nil {
+		if event.Response.ID != "" {
+			t.responseID = event.Response.ID
+		}
+		if event.Type == "response.created" {
+			t.stored = event.Response.Store
+		}
+	}
+	if event.Item != nil && event.Item.Type == dto.ResponsesOutputTypeFunctionCall && event.Item.ID != "" {
+		switch event.Type {
+		case "response.output_item.added":
+			t.startedCalls[event.Item.ID] = true
+		case dto.ResponsesOutputTypeItemDone:
+			t.doneCalls[event.Item.ID] = true
+		}
+	}
+	if event.Type == "response.function_call_arguments.delta" && event.ItemId != "" {
+		t.startedCalls[event.ItemId] = true
+	}
+	switch event.Type {
+	case "response.incomplete", "response.failed", "error":
+		t.ended = true
+	default:
+		if helper.IsResponsesTerminalEvent(event.Type) {
+			t.ended = true
+		}
+	}
+}
+
+// Deliver 记录已下发给客户端的上游原始文本
+func (t *streamResumeTracker) Deliver(text string) {
+	t.delivered.WriteString(text)
+}
+
+// End 标记流由网关主动结束（如输出上限、停止序列），不需要恢复
+func (t *streamResumeTracker) End() {
+	t.ended = true
+}
+
+// Recover 上游流中途中断时获取上游保存的最终响应
+// 已下发的文本不是最终文本的前缀，或有函数调用只下发了一部分时无法无缝续接，放弃恢复，避免客户端收到重复或残缺的内容
+// 参数:
+//   - c: Gin 上下文
+//   - info: 请求信息
+//
+// 返回:
+//   - *streamResumeResult: 需要补发的剩余文本、函数调用与最终响应
+//   - bool: 是否恢复成功
+func (t *streamResumeTracker) Recover(c *gin.Context, info *relaycommon.RelayInfo) (*streamResumeResult, bool) {
+	settings := model_setting.GetResponsesSettings()
+	if !settings.StreamResumeEnabled || t.ended || t.responseID == "" || !t.stored {
+		return nil, false
+	}
+	if info.DeadlineExceeded || c.Request.Context().Err() != nil {
+		return nil, false
+	}
+
+	waitSeconds := settings.StreamResumeWaitSeconds
+	if waitSeconds <= 0 {
+		waitSeconds = 30
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(waitSeconds)*time.Second)
+	defer cancel()
+
+	for {
+		response, err := fetchStoredResponse(ctx, c, info, t.responseID)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("failed to resume interrupted stream %s: %s", t.responseID, err.Error()))
+			return nil, false
+		}
+		switch response.Status {
+		case "completed", "incomplete":
+			t.ended = true
+			result, reason := t.resumeResult(response)
+			if result == nil {
+				logger.LogWarn(c, fmt.Sprintf("failed to resume interrupted stream %s: %s", t.responseID, reason))
+				return nil, false
+			}
+			info.AddConversionNote("stream resumed from stored response")
+			logger.LogInfo(c, fmt.Sprintf("resumed interrupted stream %s from stored response, %d chars and %d tool calls recovered", t.responseID, len(result.Tail), len(result.ToolCalls)))
+			return result, true
+		case "queued", "in_progress":
+		default:
+			logger.LogWarn(c, fmt.Sprintf("failed to resume interrupted stream %s: stored response status %s", t.responseID, response.Status))
+			return nil, false
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.LogWarn(c, fmt.Sprintf("failed to resume interrupted stream %s: stored response not finished in %ds", t.responseID, waitSeconds))
+			return nil, false
+		case <-time.After(streamResumePollInterval):
+		}
+	}
+}
+
+// resumeResult 按已下发的内容计算需要补发的部分，无法续接时返回 nil 与原因
+func (t *streamResumeTracker) resumeResult(response *dto.OpenAIResponsesResponse) (*streamResumeResult, string) {
+	delivered := t.delivered.String()
+	text := responsesOutputText(response)
+	if !strings.HasPrefix(text, delivered) {
+		return nil, "delivered text is not a prefix of the stored response"
+	}
+	result := &streamResumeResult{Response: response, Tail: strings.TrimPrefix(text, delivered)}
+	for i, output := range response.Output {
+		if output.Type != dto.ResponsesOutputTypeFunctionCall || t.doneCalls[output.ID] {
+			continue
+		}
+		if t.startedCalls[output.ID] {
+			return nil, fmt.Sprintf("tool call %s was partially delivered", output.CallId)
+		}
+		result.ToolCalls = append(result.ToolCalls, streamResumeToolCall{OutputIndex: i, Item: output})
+	}
+	return result, ""
+}
+
+// fetchStoredResponse 按响应 ID 获取上游保存的响应，请求通过适配器构建，与转发请求使用相同的地址、认证与请求头覆盖
+func fetchStoredResponse(ctx context.Context, c *gin.Context, info *relaycommon.RelayInfo, responseID string) (*dto.OpenAIResponsesResponse, error) {
+	req, err := channel.NewStoredResponseRequest(ctx, &Adaptor{}, c, info, responseID)
+	if err != nil {
+		return nil, err
+	}
+
+	client := service.GetHttpClient()
+	if info.ChannelSetting.Proxy != "" {
+		client, err = service.NewProxyHttpClient(info.ChannelSetting.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	var response dto.OpenAIResponsesResponse
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// responsesOutputText 拼接响应中助手消息的输出文本
+func responsesOutputText(response *dto.OpenAIResponsesResponse) string {
+	var text strings.Builder
+	for _, output := range response.Output {
+		if output.Type != dto.ResponsesOutputTypeMessage {
+			continue
+		}
+		for _, content := range output.Content {
+			if content.Type == "output_text" {
+				text.WriteString(content.Text)
+			}
+		}
+	}
+	return text.String()
+}
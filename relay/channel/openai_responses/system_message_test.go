@@ -0,0 +1,79 @@
+package openai_responses
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// unmarshalMessages 按请求体反序列化后的形式构造消息列表，结构化内容为 []any
+func unmarshalMessages(t *testing.T, raw string) []dto.Message {
+	t.Helper()
+	var messages []dto.Message
+	if err := common.UnmarshalJsonStr(raw, &messages); err != nil {
+		t.Fatalf("unmarshal messages: %v", err)
+	}
+	return messages
+}
+
+func TestExtractSystemMessage(t *testing.T) {
+	cases := []struct {
+		name     string
+		messages string
+		want     string
+	}{
+		{
+			name:     "single",
+			messages: `[{"role":"system","content":"You are helpful."},{"role":"user","content":"hi"}]`,
+			want:     "You are helpful.",
+		},
+		{
+			name:     "multiple in order",
+			messages: `[{"role":"system","content":"first"},{"role":"user","content":"hi"},{"role":"system","content":"second"}]`,
+			want:     "first\n\nsecond",
+		},
+		{
+			name:     "structured content keeps only text parts",
+			messages: `[{"role":"system","content":[{"type":"text","text":"part one, "},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},{"type":"text","text":"part two"}]},{"role":"system","content":"plain"}]`,
+			want:     "part one, part two\n\nplain",
+		},
+		{
+			name:     "empty system messages are skipped",
+			messages: `[{"role":"system","content":""},{"role":"system","content":null},{"role":"system","content":"kept"}]`,
+			want:     "kept",
+		},
+		{
+			name:     "image-only and empty structured content are skipped",
+			messages: `[{"role":"system","content":[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]},{"role":"system","content":[]},{"role":"system","content":"kept"}]`,
+			want:     "kept",
+		},
+		{
+			name:     "no system message",
+			messages: `[{"role":"user","content":"hi"}]`,
+			want:     "",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := extractSystemMessage(unmarshalMessages(t, tc.messages)); got != tc.want {
+				t.Fatalf("extractSystemMessage() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExtractSystemMessageSeparator(t *testing.T) {
+	settings := model_setting.GetResponsesSettings()
+	original := settings.SystemMessageSeparator
+	settings.SystemMessageSeparator = "\n---\n"
+	defer func() {
+		settings.SystemMessageSeparator = original
+	}()
+
+	messages := unmarshalMessages(t, `[{"role":"system","content":"first"},{"role":"system","content":[{"type":"text","text":"second"}]}]`)
+	if got, want := extractSystemMessage(messages), "first\n---\nsecond"; got != want {
+		t.Fatalf("extractSystemMessage() = %q, want %q", got, want)
+	}
+}
@@ -298,6 +298,13 @@ func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
 
+// Capabilities 适配器支持的接口与特性
+func (a *Adaptor) Capabilities() channel.Capabilities {
+	return channel.Capabilities{
+		Images: true,
+	}
+}
+
 func downloadImagesToBase64(urls []string) ([]string, error) {
 	results := make([]string, 0, len(urls))
 	for _, url := range urls {
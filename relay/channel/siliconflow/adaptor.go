@@ -125,3 +125,18 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// Capabilities 适配器支持的接口与特性
+func (a *Adaptor) Capabilities() channel.Capabilities {
+	return channel.Capabilities{
+		Chat:       true,
+		Claude:     true,
+		Embeddings: true,
+		Audio:      true,
+		Images:     true,
+		Rerank:     true,
+		Streaming:  true,
+		Tools:      true,
+		Vision:     true,
+	}
+}
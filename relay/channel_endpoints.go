@@ -0,0 +1,23 @@
+package relay
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+)
+
+func init() {
+	service.RegisterChannelTypeEndpointSupport(channelTypeEndpointSupport)
+}
+
+// channelTypeEndpointSupport 按渠道类型对应适配器的能力矩阵获取各接口是否支持
+// 返回:
+//   - map[string]bool: 能力矩阵覆盖的接口及是否支持
+//   - bool: 渠道类型没有对应的适配器时返回 false，不限制接口
+func channelTypeEndpointSupport(channelType int) (map[string]bool, bool) {
+	apiType, _ := common.ChannelType2APIType(channelType)
+	adaptor := GetAdaptor(apiType)
+	if adaptor == nil {
+		return nil, false
+	}
+	return adaptor.Capabilities().EndpointSupport(), true
+}
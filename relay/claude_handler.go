@@ -99,6 +99,8 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 	}
 
 	var requestBody io.Reader
+	// convertedBody 实际发送给上游的请求体，用于转换质量抽样
+	var convertedBody []byte
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
@@ -133,6 +135,8 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 			println("requestBody: ", string(jsonData))
 		}
 		requestBody = bytes.NewBuffer(jsonData)
+		convertedBody = jsonData
+		info.UpstreamRequestBody = jsonData
 	}
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
@@ -146,13 +150,16 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		httpResp = resp.(*http.Response)
 		info.IsStream = info.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
 		if httpResp.StatusCode != http.StatusOK {
-			newAPIError = service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			newAPIError = service.RelayErrorHandler(c.Request.Context(), httpResp, service.ShouldShowConvertedErrorBody(info, httpResp))
+			newAPIError = service.EnrichConvertedUpstreamError(info, newAPIError)
 			// reset status code 重置状态码
 			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 			return newAPIError
 		}
 	}
 
+	helper.SetRoutingHeaders(c, info)
+
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
 	//log.Printf("usage: %v", usage)
 	if newAPIError != nil {
@@ -162,5 +169,6 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 	}
 
 	service.PostClaudeConsumeQuota(c, info, usage.(*dto.Usage))
+	service.SampleConvertedExchange(info, convertedBody)
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -53,6 +55,39 @@ type ResponsesUsageInfo struct {
 	BuiltInTools map[string]*BuildInToolInfo
 }
 
+// StreamTokenCounter 流式输出过程中可在其他 goroutine 中读取已输出 token 数的计数器
+type StreamTokenCounter interface {
+	CountedTokens() int
+}
+
+// ConversionSource 请求经由 Responses 转换前的原始格式
+type ConversionSource string
+
+const (
+	ConversionSourceNone   ConversionSource = ""       // 未经过转换
+	ConversionSourceChat   ConversionSource = "chat"   // Chat Completions 请求转换为 Responses 请求
+	ConversionSourceClaude ConversionSource = "claude" // Claude Messages 请求转换为 Responses 请求
+	ConversionSourceGemini ConversionSource = "gemini" // Gemini generateContent 请求转换为 Responses 请求
+)
+
+// RequestTimeoutHeader 客户端指定请求超时时长的请求头
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// RelayAttempt 记录一次失败的渠道尝试，用于审计多次重试的成本
+type RelayAttempt struct {
+	ChannelId     int    `json:"channel_id"`
+	ChannelName   string `json:"channel_name"`
+	StatusCode    int    `json:"status_code"`
+	ErrorCode     string `json:"error_code"`
+	Error         string `json:"error"`
+	StreamStarted bool   `json:"stream_started"` // 失败前是否已向客户端输出流式内容
+	UseTimeMs     int64  `json:"use_time_ms"`
+	// 以下为按本次请求价格估算的该次尝试成本拆分，仅用于审计，不向用户扣费
+	PromptTokens     int `json:"prompt_tokens"`     // 上游已开始输出时计入的输入 token
+	CompletionTokens int `json:"completion_tokens"` // 按已转发的输出文本估算的输出 token
+	Quota            int `json:"quota"`             // 估算的该次尝试成本
+}
+
 type ChannelMeta struct {
 	ChannelType          int
 	ChannelId            int
@@ -92,6 +127,8 @@ type RelayInfo struct {
 	OriginModelName        string
 	RequestURLPath         string
 	PromptTokens           int
+	PromptTokenBreakdown   *types.PromptTokenBreakdown // 预计数阶段的提示词 token 构成
+	SkipRemoteMediaFetch   bool                        // 估算提示词 token 时不下载远程媒体，远程图片按基础 token 估算
 	ShouldIncludeUsage     bool
 	DisablePing            bool // 是否禁止向下游发送自定义 Ping
 	ClientWs               *websocket.Conn
@@ -107,8 +144,31 @@ type RelayInfo struct {
 	UserQuota              int
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
-	FinalPreConsumedQuota  int  // 最终预消耗的配额
-	IsClaudeBetaQuery      bool // /v1/messages?beta=true
+	FinalPreConsumedQuota  int                // 最终预消耗的配额
+	IsClaudeBetaQuery      bool               // /v1/messages?beta=true
+	Attempts               []RelayAttempt     // 本次请求中失败的渠道尝试
+	StreamAborted          bool               // 流式响应因超时或读取错误中途中断
+	StreamTerminated       bool               // 流式响应被管理员终止或超出令牌 TPM 限制，与渠道故障无关，不计入渠道健康度
+	TokenRateReported      int                // 本次尝试已计入令牌 TPM 用量的输出 token 数
+	StreamErrored          bool               // 流式响应中出现错误事件或以非 completed 状态结束
+	attemptOutput          *strings.Builder   // 当前渠道尝试已转发的流式输出文本，用于估算失败尝试的 token
+	OutputTokenBudget      int                // 网关强制的输出 token 上限，0 表示不限制
+	StreamTokenCounter     StreamTokenCounter // 流式输出 token 计数器，由流式处理器设置，用于活跃流登记
+	RequestDeadline        time.Time          // 客户端通过 X-Request-Timeout 或令牌默认值指定的截止时间，零值表示不限制
+	DeadlineExceeded       bool               // 上游请求因超过截止时间被取消
+	LongOutput             bool               // 客户端通过 anthropic-beta 请求长输出，放宽网关侧的输出上限、流式空闲超时与响应体记录上限
+	SmartRoutingFallback   bool               // Claude 渠道智能路由已回退到原生接口，后续重试不再路由到 Responses
+	Experiment             string             // 请求参与的 A/B 实验，未参与时为空
+	ExperimentArm          string             // 请求被分配到的实验分组
+
+	// 以下为本次渠道尝试的 Responses 转换状态，由适配器在请求转换阶段设置，重试前需调用 ResetConversion
+	ConversionSource          ConversionSource   // 转换前的原始格式，未转换时为 ConversionSourceNone
+	OriginalRequest           any                // 转换前的原始请求：Chat 为 *dto.GeneralOpenAIRequest，Claude 为 *dto.ClaudeRequest 或 *dto.GeneralOpenAIRequest
+	AggregateUpstreamStream   bool               // 客户端为非流式请求、但上游以流式请求发送，响应需聚合为非流式结果
+	ToolSchemaTokenAdjustment int                // 按实际上游的工具渲染方式对提示词 token 的修正量
+	ConversionNotes           []string           // 转换时网关自动做出的调整说明，记录到消费日志
+	ConversationState         *ConversationState // 网关模拟 Responses 会话状态时本次请求的会话信息，请求成功后记录响应 ID
+	UpstreamRequestBody       []byte             // 转换后实际发送给上游的请求体，用于转换追踪
 
 	PriceData types.PriceData
 
@@ -292,6 +352,10 @@ func GenRelayInfoClaude(c *gin.Context, request dto.Request) *RelayInfo {
 	if c.Query("beta") == "true" {
 		info.IsClaudeBetaQuery = true
 	}
+	if model_setting.GetClaudeSettings().IsLongOutputRequest(c.Request.Header.Values("anthropic-beta")) {
+		info.LongOutput = true
+		info.OutputTokenBudget = getOutputTokenBudget(c, true)
+	}
 	return info
 }
 
@@ -401,6 +465,9 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 
+		Experiment:    common.GetContextKeyString(c, constant.ContextKeyExperiment),
+		ExperimentArm: common.GetContextKeyString(c, constant.ContextKeyExperimentArm),
+
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
 		RequestURLPath:  c.Request.URL.String(),
@@ -429,9 +496,48 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		info.UserSetting = userSetting
 	}
 
+	info.OutputTokenBudget = getOutputTokenBudget(c, false)
+	if timeout := getRequestTimeout(c); timeout > 0 {
+		info.RequestDeadline = startTime.Add(timeout)
+	}
+
 	return info
 }
 
+// getRequestTimeout 获取请求的超时时长，优先使用 X-Request-Timeout 请求头（秒数或 30s 形式的时长），否则使用令牌默认值
+func getRequestTimeout(c *gin.Context) time.Duration {
+	if header := strings.TrimSpace(c.GetHeader(RequestTimeoutHeader)); header != "" {
+		if seconds, err := strconv.ParseFloat(header, 64); err == nil {
+			if seconds > 0 {
+				return time.Duration(seconds * float64(time.Second))
+			}
+		} else if duration, err := time.ParseDuration(header); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	if seconds := common.GetContextKeyInt(c, constant.ContextKeyTokenRequestTimeout); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// getOutputTokenBudget 取全局与令牌输出上限中较小的非零值
+func getOutputTokenBudget(c *gin.Context, longOutput bool) int {
+	budget := model_setting.GetGlobalSettings().MaxOutputTokens
+	// 长输出请求放宽全局上限，令牌自身的上限仍然生效
+	if longOutput && budget > 0 {
+		budget = max(budget, model_setting.GetClaudeSettings().LongOutputMaxTokens)
+	}
+	tokenBudget := common.GetContextKeyInt(c, constant.ContextKeyTokenMaxOutputTokens)
+	if tokenBudget > 0 && (budget <= 0 || tokenBudget < budget) {
+		budget = tokenBudget
+	}
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
 func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Request, ws *websocket.Conn) (*RelayInfo, error) {
 	switch relayFormat {
 	case types.RelayFormatOpenAI:
@@ -467,10 +573,124 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
 	}
 }
 
+// MarkConverted 标记本次请求经由 Responses 转换，并保存转换前的原始请求
+func (info *RelayInfo) MarkConverted(source ConversionSource, originalRequest any) {
+	info.ConversionSource = source
+	info.OriginalRequest = originalRequest
+}
+
+// ConversationState 网关模拟 Responses 会话状态时的会话信息
+type ConversationState struct {
+	Key        string // 会话标识
+	InputCount int    // 本次请求完整历史的输入项数量
+	InputHash  string // 本次请求完整历史的哈希，下一轮请求的历史前缀与之一致时才能复用响应 ID
+}
+
+// AddConversionNote 记录一条转换时网关自动做出的调整说明
+func (info *RelayInfo) AddConversionNote(note string) {
+	info.ConversionNotes = append(info.ConversionNotes, note)
+}
+
+// IsConverted 判断本次请求是否经由 Responses 转换
+func (info *RelayInfo) IsConverted() bool {
+	return info.ConversionSource != ConversionSourceNone
+}
+
+// OriginalClaudeRequest 获取转换前的 Claude 请求
+// 跨适配器重试等情况下原始请求可能丢失，此时按 RelayInfo 重建仅包含模型与流式标记的请求，保证响应仍能完成转换
+// 返回:
+//   - *dto.ClaudeRequest: 原始请求或重建的请求
+//   - bool: 是否为重建的请求
+func (info *RelayInfo) OriginalClaudeRequest() (*dto.ClaudeRequest, bool) {
+	if request, ok := info.OriginalRequest.(*dto.ClaudeRequest); ok && request != nil {
+		return request, false
+	}
+	return &dto.ClaudeRequest{Model: info.OriginModelName, Stream: info.clientStream()}, true
+}
+
+// OriginalChatRequest 获取转换前的 Chat Completions 请求，原始请求丢失时的处理同 OriginalClaudeRequest
+func (info *RelayInfo) OriginalChatRequest() (*dto.GeneralOpenAIRequest, bool) {
+	if request, ok := info.OriginalRequest.(*dto.GeneralOpenAIRequest); ok && request != nil {
+		return request, false
+	}
+	return &dto.GeneralOpenAIRequest{Model: info.OriginModelName, Stream: info.clientStream()}, true
+}
+
+// clientStream 客户端是否请求流式响应，上游被强制流式请求时 IsStream 可能已被响应头改写
+func (info *RelayInfo) clientStream() bool {
+	return info.IsStream && !info.AggregateUpstreamStream
+}
+
+// ResetConversion 清除上一次渠道尝试留下的转换状态
+func (info *RelayInfo) ResetConversion() {
+	info.ConversionSource = ConversionSourceNone
+	info.OriginalRequest = nil
+	info.AggregateUpstreamStream = false
+	info.ConversionNotes = nil
+	info.ConversationState = nil
+	info.UpstreamRequestBody = nil
+	info.AdjustToolSchemaTokens(-info.ToolSchemaTokenAdjustment)
+}
+
+// AdjustToolSchemaTokens 按工具渲染方式修正提示词 token 数，修正量累计记录以便切换渠道时撤销
+func (info *RelayInfo) AdjustToolSchemaTokens(adjustment int) {
+	if adjustment == 0 {
+		return
+	}
+	info.ToolSchemaTokenAdjustment += adjustment
+	info.PromptTokens += adjustment
+	if info.PromptTokenBreakdown != nil {
+		info.PromptTokenBreakdown.Tools += adjustment
+	}
+}
+
+// DeadlineRemaining 获取距截止时间的剩余时长
+// 返回值 ok 为 false 表示请求没有截止时间
+func (info *RelayInfo) DeadlineRemaining() (time.Duration, bool) {
+	if info.RequestDeadline.IsZero() {
+		return 0, false
+	}
+	return time.Until(info.RequestDeadline), true
+}
+
 func (info *RelayInfo) SetPromptTokens(promptTokens int) {
 	info.PromptTokens = promptTokens
 }
 
+// AppendAttemptOutput 记录当前渠道尝试已转发的流式输出文本，仅用于没有流式 token 计数器的处理器
+// 累计长度达到 limit 后不再记录，limit 不大于 0 时不限制
+func (info *RelayInfo) AppendAttemptOutput(text string, limit int) {
+	if text == "" || info.AttemptOutputFull(limit) {
+		return
+	}
+	if info.attemptOutput == nil {
+		info.attemptOutput = &strings.Builder{}
+	}
+	if limit > 0 && info.attemptOutput.Len()+len(text) > limit {
+		text = string(common.TruncateUTF8Bytes(common.StringToByteSlice(text), limit-info.attemptOutput.Len()))
+	}
+	info.attemptOutput.WriteString(text)
+}
+
+// AttemptOutputFull 判断已记录的输出文本是否达到上限
+func (info *RelayInfo) AttemptOutputFull(limit int) bool {
+	return limit > 0 && info.attemptOutput != nil && info.attemptOutput.Len() >= limit
+}
+
+// AttemptOutputText 返回当前渠道尝试已转发的流式输出文本
+func (info *RelayInfo) AttemptOutputText() string {
+	if info.attemptOutput == nil {
+		return ""
+	}
+	return info.attemptOutput.String()
+}
+
+// ResetAttemptOutput 在开始新的渠道尝试前清空已记录的输出文本与上一次尝试的流式 token 计数器
+func (info *RelayInfo) ResetAttemptOutput() {
+	info.attemptOutput = nil
+	info.StreamTokenCounter = nil
+}
+
 func (info *RelayInfo) SetFirstResponseTime() {
 	if info.isFirstResponse {
 		info.FirstResponseTime = time.Now()
@@ -73,6 +73,8 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	}
 	adaptor.Init(info)
 	var requestBody io.Reader
+	// convertedBody 实际发送给上游的请求体，用于转换质量抽样
+	var convertedBody []byte
 
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
 		body, err := common.GetRequestBody(c)
@@ -153,6 +155,8 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		logger.LogDebug(c, fmt.Sprintf("text request body: %s", string(jsonData)))
 
 		requestBody = bytes.NewBuffer(jsonData)
+		convertedBody = jsonData
+		info.UpstreamRequestBody = jsonData
 	}
 
 	var httpResp *http.Response
@@ -167,13 +171,16 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		httpResp = resp.(*http.Response)
 		info.IsStream = info.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
 		if httpResp.StatusCode != http.StatusOK {
-			newApiErr := service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			newApiErr := service.RelayErrorHandler(c.Request.Context(), httpResp, service.ShouldShowConvertedErrorBody(info, httpResp))
+			newApiErr = service.EnrichConvertedUpstreamError(info, newApiErr)
 			// reset status code 重置状态码
 			service.ResetStatusCode(newApiErr, statusCodeMappingStr)
 			return newApiErr
 		}
 	}
 
+	helper.SetRoutingHeaders(c, info)
+
 	usage, newApiErr := adaptor.DoResponse(c, httpResp, info)
 	if newApiErr != nil {
 		// reset status code 重置状态码
@@ -186,6 +193,7 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	} else {
 		postConsumeQuota(c, info, usage.(*dto.Usage), "")
 	}
+	service.SampleConvertedExchange(info, convertedBody)
 	return nil
 }
 
@@ -198,6 +206,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		}
 		extraContent += "（可能是请求出错）"
 	}
+	service.RecordTokenRateUsage(relayInfo, usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -367,8 +376,11 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		if !ratio.IsZero() && quota == 0 {
 			quota = 1
 		}
-		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		userId, channelId, usedQuota := relayInfo.UserId, relayInfo.ChannelId, quota
+		model.SubmitBillingWrite(func() {
+			model.UpdateUserUsedQuotaAndRequestCount(userId, usedQuota)
+			model.UpdateChannelUsedQuota(channelId, usedQuota)
+		})
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -390,10 +402,13 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 	}
 
 	if quotaDelta != 0 {
-		err := service.PostConsumeQuota(relayInfo, quotaDelta, relayInfo.FinalPreConsumedQuota, true)
-		if err != nil {
-			logger.LogError(ctx, "error consuming token remain quota: "+err.Error())
-		}
+		// 写入池启用时异步扣费，请求结束后执行，不能再使用 ctx 记录日志
+		preConsumedQuota := relayInfo.FinalPreConsumedQuota
+		model.SubmitBillingWrite(func() {
+			if err := service.PostConsumeQuota(relayInfo, quotaDelta, preConsumedQuota, true); err != nil {
+				common.SysError(fmt.Sprintf("error consuming token remain quota: user_id=%d, token_id=%d, error=%v", relayInfo.UserId, relayInfo.TokenId, err))
+			}
+		})
 	}
 
 	logModel := modelName
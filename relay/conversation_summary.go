@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func init() {
+	service.RegisterConversationSummaryRequester(requestConversationSummary)
+}
+
+// requestConversationSummary 通过摘要渠道的适配器发送 Chat Completions 摘要请求，按摘要模型的价格向请求所属用户计费并记录消费日志
+// 摘要请求使用独立的上下文，不影响原请求的渠道信息与响应
+// 参数:
+//   - ctx: 摘要请求的超时上下文
+//   - c: 原请求的 Gin 上下文，用户、令牌与分组信息从中复制
+//   - parent: 原请求的转发信息
+//   - channel: 摘要渠道
+//   - request: 摘要请求
+//
+// 返回:
+//   - string: 摘要文本
+//   - error: 请求失败或上游返回错误时返回错误
+func requestConversationSummary(ctx context.Context, c *gin.Context, parent *relaycommon.RelayInfo, channel *model.Channel, request *dto.GeneralOpenAIRequest) (string, error) {
+	w := httptest.NewRecorder()
+	sc, _ := gin.CreateTestContext(w)
+	sc.Keys = c.Copy().Keys
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sc.Request = req
+
+	if apiErr := middleware.SetupContextForSelectedChannel(sc, channel, request.Model); apiErr != nil {
+		return "", apiErr
+	}
+	info, err := relaycommon.GenRelayInfo(sc, types.RelayFormatOpenAI, request, nil)
+	if err != nil {
+		return "", err
+	}
+	info.InitChannelMeta(sc)
+	if err := helper.ModelMappedHelper(sc, info, request); err != nil {
+		return "", err
+	}
+	request.SetModelName(info.UpstreamModelName)
+	priceData, err := helper.ModelPriceHelper(sc, info, 0, request.GetTokenCountMeta())
+	if err != nil {
+		return "", err
+	}
+	info.PriceData = priceData
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return "", fmt.Errorf("invalid api type: %d, adaptor is nil", info.ApiType)
+	}
+	adaptor.Init(info)
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(sc, info, request)
+	if err != nil {
+		return "", err
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return "", err
+	}
+	resp, err := adaptor.DoRequest(sc, info, bytes.NewReader(jsonData))
+	if err != nil {
+		return "", err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return "", errors.New("summary request returned no response")
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return "", service.RelayErrorHandler(ctx, httpResp, false)
+	}
+	usage, apiErr := adaptor.DoResponse(sc, httpResp, info)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	summaryUsage, _ := usage.(*dto.Usage)
+	postConsumeQuota(sc, info, summaryUsage, fmt.Sprintf("对话裁剪摘要，原请求模型 %s", parent.OriginModelName))
+	return gjson.GetBytes(w.Body.Bytes(), "choices.0.message.content").String(), nil
+}
@@ -0,0 +1,245 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// embeddingBatchResponse 单个分批请求的上游响应，向量原样保留，兼容 float 与 base64 两种编码
+type embeddingBatchResponse struct {
+	Data []struct {
+		Object    string          `json:"object"`
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	} `json:"data"`
+	Model string             `json:"model"`
+	Usage dto.Usage          `json:"usage"`
+	Error *types.OpenAIError `json:"error,omitempty"`
+}
+
+// splitEmbeddingInputs 输入数超过上游单次请求上限时按上限拆分输入，无需拆分时返回 nil
+// 仅处理 OpenAI 兼容的上游，单个 token 数组输入不拆分
+func splitEmbeddingInputs(info *relaycommon.RelayInfo, request *dto.EmbeddingRequest) [][]any {
+	if info.ApiType != constant.APITypeOpenAI {
+		return nil
+	}
+	inputs, ok := request.Input.([]any)
+	limit := model_setting.GetEmbeddingBatchSettings().GetMaxInputs(request.Model)
+	if !ok || limit <= 0 || len(inputs) <= limit {
+		return nil
+	}
+	for _, input := range inputs {
+		switch input.(type) {
+		case string, []any:
+		default:
+			return nil
+		}
+	}
+	batches := make([][]any, 0, (len(inputs)+limit-1)/limit)
+	for start := 0; start < len(inputs); start += limit {
+		end := min(start+limit, len(inputs))
+		batches = append(batches, inputs[start:end])
+	}
+	return batches
+}
+
+// relayEmbeddingBatches 将拆分后的输入并发发送给上游，按原顺序合并向量并累加用量后返回给客户端
+// 任一分批失败时取消其余请求并返回最先失败的分批的错误
+func relayEmbeddingBatches(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.EmbeddingRequest, batches [][]any) *types.NewAPIError {
+	// 请求在当前协程构建，并发的协程只负责发送与解析
+	requests := make([]*http.Request, len(batches))
+	for i, batch := range batches {
+		batchRequest := *request
+		batchRequest.Input = batch
+		convertedRequest, err := adaptor.ConvertEmbeddingRequest(c, info, batchRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+		}
+		jsonData, err := common.Marshal(convertedRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+		}
+		if len(info.ParamOverride) > 0 {
+			jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride, relaycommon.BuildParamOverrideContext(info))
+			if err != nil {
+				return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+			}
+		}
+		requests[i], err = channel.NewApiRequest(adaptor, c, info, bytes.NewReader(jsonData))
+		if err != nil {
+			return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+		}
+	}
+
+	client := service.GetHttpClient()
+	if info.ChannelSetting.Proxy != "" {
+		proxyClient, err := service.NewProxyHttpClient(info.ChannelSetting.Proxy)
+		if err != nil {
+			return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+		}
+		client = proxyClient
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	if !info.RequestDeadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, info.RequestDeadline)
+		defer cancelDeadline()
+	}
+	concurrency := model_setting.GetEmbeddingBatchSettings().MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+
+	logger.LogInfo(c, fmt.Sprintf("embedding request split into %d batches", len(batches)))
+	responses := make([]*embeddingBatchResponse, len(batches))
+	// 记录最先失败的分批错误，其余分批因取消而失败的错误不返回
+	var firstErr *types.NewAPIError
+	var failOnce sync.Once
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if ctx.Err() != nil {
+				return
+			}
+			response, err := doEmbeddingBatchRequest(ctx, client, req.WithContext(ctx))
+			if err != nil {
+				failOnce.Do(func() {
+					service.ResetStatusCode(err, statusCodeMappingStr)
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			responses[i] = response
+		}(i, req)
+	}
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = types.NewError(ctx.Err(), types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
+	}
+	if firstErr != nil {
+		if !info.RequestDeadline.IsZero() && ctx.Err() == context.DeadlineExceeded {
+			info.DeadlineExceeded = true
+		}
+		billCompletedEmbeddingBatches(c, info, batches, responses, firstErr)
+		return firstErr
+	}
+
+	// 按原顺序合并向量，index 为在原始输入中的位置
+	merged := dto.FlexibleEmbeddingResponse{Object: "list", Data: make([]dto.FlexibleEmbeddingResponseItem, 0, len(request.Input.([]any)))}
+	offset := 0
+	for i, response := range responses {
+		if merged.Model == "" {
+			merged.Model = response.Model
+		}
+		for _, item := range response.Data {
+			merged.Data = append(merged.Data, dto.FlexibleEmbeddingResponseItem{
+				Object:    item.Object,
+				Index:     offset + item.Index,
+				Embedding: item.Embedding,
+			})
+		}
+		offset += len(batches[i])
+		merged.PromptTokens += response.Usage.PromptTokens
+		merged.TotalTokens += response.Usage.TotalTokens
+	}
+	// 上游未返回用量时使用预估值
+	if merged.PromptTokens == 0 {
+		merged.PromptTokens = info.PromptTokens
+		merged.TotalTokens = info.PromptTokens
+	}
+
+	responseBody, err := common.Marshal(merged)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeJsonMarshalFailed, http.StatusInternalServerError)
+	}
+	c.Data(http.StatusOK, "application/json", responseBody)
+
+	usage := merged.Usage
+	postConsumeQuota(c, info, &usage, "")
+	return nil
+}
+
+// billCompletedEmbeddingBatches 部分分批失败时按已成功分批的上游用量计费
+// 已成功的分批已产生上游成本，计费后预扣费额度已结算、不再返还，错误标记为不重试以免重复计费
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息
+//   - batches: 拆分后的输入
+//   - responses: 各分批的响应，失败或未发送的分批为 nil
+//   - apiErr: 返回给客户端的错误
+func billCompletedEmbeddingBatches(c *gin.Context, info *relaycommon.RelayInfo, batches [][]any, responses []*embeddingBatchResponse, apiErr *types.NewAPIError) {
+	var usage dto.Usage
+	completed, completedInputs, totalInputs := 0, 0, 0
+	for i, response := range responses {
+		totalInputs += len(batches[i])
+		if response == nil {
+			continue
+		}
+		completed++
+		completedInputs += len(batches[i])
+		usage.PromptTokens += response.Usage.PromptTokens
+		usage.TotalTokens += response.Usage.TotalTokens
+	}
+	if completed == 0 {
+		return
+	}
+	// 上游未返回用量时按已完成输入占比分摊预估值
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens * completedInputs / totalInputs
+		usage.TotalTokens = usage.PromptTokens
+	}
+	logger.LogInfo(c, fmt.Sprintf("embedding batch failed after %d of %d batches completed, billing %d prompt tokens", completed, len(responses), usage.PromptTokens))
+	postConsumeQuota(c, info, &usage, fmt.Sprintf("分批请求部分失败，按已完成的 %d/%d 个分批计费", completed, len(responses)))
+	info.FinalPreConsumedQuota = 0
+	types.ErrOptionWithSkipRetry()(apiErr)
+}
+
+// doEmbeddingBatchRequest 发送单个分批请求并解析响应
+func doEmbeddingBatchRequest(ctx context.Context, client *http.Client, req *http.Request) (*embeddingBatchResponse, *types.NewAPIError) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, service.RelayErrorHandler(ctx, resp, false)
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	var response embeddingBatchResponse
+	if err := common.Unmarshal(responseBody, &response); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	if response.Error != nil && (response.Error.Type != "" || response.Error.Message != "") {
+		return nil, types.WithOpenAIError(*response.Error, resp.StatusCode)
+	}
+	return &response, nil
+}
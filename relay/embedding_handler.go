@@ -41,6 +41,11 @@ func EmbeddingHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 	}
 	adaptor.Init(info)
 
+	// 输入数超过上游单次请求上限时拆分为多个请求
+	if batches := splitEmbeddingInputs(info, request); len(batches) > 1 {
+		return relayEmbeddingBatches(c, info, adaptor, request, batches)
+	}
+
 	convertedRequest, err := adaptor.ConvertEmbeddingRequest(c, info, *request)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
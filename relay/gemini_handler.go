@@ -165,6 +165,7 @@ func GeminiHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		logger.LogDebug(c, "Gemini request body: "+string(jsonData))
 
 		requestBody = bytes.NewReader(jsonData)
+		info.UpstreamRequestBody = jsonData
 	}
 
 	resp, err := adaptor.DoRequest(c, info, requestBody)
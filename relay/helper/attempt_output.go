@@ -0,0 +1,48 @@
+package helper
+
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// attemptOutputTextPaths 各流式格式中携带输出文本的字段路径
+var attemptOutputTextPaths = []string{
+	"choices.#.delta.content",           // OpenAI chat completions
+	"choices.#.delta.reasoning_content", // OpenAI 兼容推理内容
+	"delta.text",                        // Claude content_block_delta 文本
+	"delta.thinking",                    // Claude content_block_delta 思考
+	"candidates.#.content.parts.#.text", // Gemini
+}
+
+// StreamOutputText 提取一条流式事件中的输出文本，用于估算失败尝试已产生的 token
+// 参数:
+//   - data: 去掉 "data:" 前缀后的事件数据
+//
+// 返回:
+//   - string: 事件中的输出文本，无文本时返回空字符串
+func StreamOutputText(data string) string {
+	if !gjson.Valid(data) {
+		return ""
+	}
+	var sb strings.Builder
+	if gjson.Get(data, "type").String() == "response.output_text.delta" {
+		sb.WriteString(gjson.Get(data, "delta").String())
+	}
+	for _, path := range attemptOutputTextPaths {
+		appendStreamOutputText(&sb, gjson.Get(data, path))
+	}
+	return sb.String()
+}
+
+func appendStreamOutputText(sb *strings.Builder, result gjson.Result) {
+	if result.IsArray() {
+		for _, item := range result.Array() {
+			appendStreamOutputText(sb, item)
+		}
+		return
+	}
+	if result.Type == gjson.String {
+		sb.WriteString(result.String())
+	}
+}
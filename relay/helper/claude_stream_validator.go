@@ -0,0 +1,157 @@
+package helper
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// claudeStreamValidatorMaxViolations 单个流记录的违规数上限，避免同一问题在长流中刷屏
+const claudeStreamValidatorMaxViolations = 10
+
+// ClaudeStreamValidator 调试用的 Claude 流式事件顺序校验器，按 Anthropic 的事件约定检查网关发出的事件序列：
+// message_start 必须最先发送且只发送一次；内容块索引从 0 开始连续递增，同一时刻最多打开一个块，增量只能发往打开的块；
+// 带 stop_reason 的 message_delta 之前所有块已关闭；message_stop 是最后一个事件。error 事件之后不再校验。
+// 违规只记录日志与转换说明，不修改发送的事件。未开启 claude_stream_conformance_check 时为 nil，所有方法均可安全调用
+type ClaudeStreamValidator struct {
+	c    *gin.Context
+	info *relaycommon.RelayInfo
+
+	started   bool
+	stopped   bool
+	hasStop   bool // 已发送带 stop_reason 的 message_delta
+	aborted   bool // 已发送 error 事件
+	nextIndex int
+	// 当前打开的块，openIndex 为 -1 时没有打开的块
+	openIndex int
+	openType  string
+
+	events     int
+	violations []string
+}
+
+// NewClaudeStreamValidator 按 responses 配置创建校验器，未开启时返回 nil
+func NewClaudeStreamValidator(c *gin.Context, info *relaycommon.RelayInfo) *ClaudeStreamValidator {
+	if !model_setting.GetResponsesSettings().ClaudeStreamConformanceCheck {
+		return nil
+	}
+	return &ClaudeStreamValidator{c: c, info: info, openIndex: -1}
+}
+
+// Observe 校验即将发送的事件
+func (v *ClaudeStreamValidator) Observe(event *dto.ClaudeResponse) {
+	if v == nil || event == nil || v.aborted {
+		return
+	}
+	v.events++
+	if v.stopped {
+		v.violate("%s after message_stop", event.Type)
+		return
+	}
+	if !v.started && event.Type != "message_start" && event.Type != "error" {
+		v.violate("%s before message_start", event.Type)
+	}
+
+	switch event.Type {
+	case "message_start":
+		if v.started {
+			v.violate("duplicate message_start")
+		}
+		v.started = true
+	case "content_block_start":
+		index := event.GetIndex()
+		if v.openIndex >= 0 {
+			v.violate("content_block_start index %d while block %d is still open", index, v.openIndex)
+		}
+		if index != v.nextIndex {
+			v.violate("content_block_start index %d, expected %d", index, v.nextIndex)
+		}
+		if v.hasStop {
+			v.violate("content_block_start index %d after final message_delta", index)
+		}
+		v.openIndex = index
+		v.nextIndex = max(v.nextIndex, index+1)
+		v.openType = ""
+		if event.ContentBlock != nil {
+			v.openType = event.ContentBlock.Type
+		}
+	case "content_block_delta":
+		index := event.GetIndex()
+		if index != v.openIndex {
+			v.violate("content_block_delta index %d, open block is %d", index, v.openIndex)
+		} else if event.Delta != nil && !claudeDeltaMatchesBlock(v.openType, event.Delta.Type) {
+			v.violate("%s in %s block %d", event.Delta.Type, v.openType, index)
+		}
+	case "content_block_stop":
+		index := event.GetIndex()
+		if index != v.openIndex {
+			v.violate("content_block_stop index %d, open block is %d", index, v.openIndex)
+		}
+		v.openIndex = -1
+		v.openType = ""
+	case "message_delta":
+		// 仅携带累计 usage 的 message_delta 可以在块内发送，带 stop_reason 的必须在所有块关闭之后
+		if event.Delta != nil && event.Delta.StopReason != nil {
+			if v.openIndex >= 0 {
+				v.violate("final message_delta while block %d is still open", v.openIndex)
+			}
+			if v.hasStop {
+				v.violate("duplicate final message_delta")
+			}
+			v.hasStop = true
+		}
+	case "message_stop":
+		if v.openIndex >= 0 {
+			v.violate("message_stop while block %d is still open", v.openIndex)
+		}
+		if !v.hasStop {
+			v.violate("message_stop without final message_delta")
+		}
+		v.stopped = true
+	case "error":
+		v.aborted = true
+	}
+}
+
+// Finish 在流结束时检查是否以 message_stop 结束，并汇总违规记录为转换说明
+func (v *ClaudeStreamValidator) Finish() {
+	if v == nil {
+		return
+	}
+	if v.started && !v.stopped && !v.aborted {
+		v.violate("stream ended without message_stop")
+	}
+	if len(v.violations) == 0 {
+		return
+	}
+	v.info.AddConversionNote(fmt.Sprintf("claude stream conformance: %d violations, first: %s", len(v.violations), v.violations[0]))
+}
+
+// violate 记录一条违规
+func (v *ClaudeStreamValidator) violate(format string, args ...any) {
+	if len(v.violations) >= claudeStreamValidatorMaxViolations {
+		return
+	}
+	message := fmt.Sprintf("event #%d: ", v.events) + fmt.Sprintf(format, args...)
+	v.violations = append(v.violations, message)
+	logger.LogWarn(v.c, "claude stream conformance violation: "+message)
+}
+
+// claudeDeltaMatchesBlock 判断增量类型是否与块类型匹配
+func claudeDeltaMatchesBlock(blockType string, deltaType string) bool {
+	switch blockType {
+	case "text":
+		return deltaType == "text_delta" || deltaType == "citations_delta"
+	case "thinking":
+		return deltaType == "thinking_delta" || deltaType == "signature_delta"
+	case "tool_use", "server_tool_use":
+		return deltaType == "input_json_delta"
+	}
+	// 未知块类型不校验
+	return true
+}
@@ -0,0 +1,54 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// ClaudeUsageDeltaTracker 在转换为 Claude 流式响应时周期性生成携带累计 output_tokens 的 message_delta
+// Claude 的 message_delta usage 为累计值，客户端据此实时展示输出 token 数
+type ClaudeUsageDeltaTracker struct {
+	interval int
+	reported int
+}
+
+// NewClaudeUsageDeltaTracker 根据 responses 配置创建 usage 增量跟踪器
+func NewClaudeUsageDeltaTracker() *ClaudeUsageDeltaTracker {
+	return &ClaudeUsageDeltaTracker{
+		interval: model_setting.GetResponsesSettings().ClaudeUsageDeltaInterval,
+	}
+}
+
+// Next 根据当前累计输出 token 数判断是否需要发送 usage 更新
+// 参数:
+//   - outputTokens: 当前累计输出 token 数
+// 返回:
+//   - *dto.ClaudeResponse: 需要发送的 message_delta 事件，无需发送时返回 nil
+func (t *ClaudeUsageDeltaTracker) Next(outputTokens int) *dto.ClaudeResponse {
+	if t == nil || t.interval <= 0 || outputTokens-t.reported < t.interval {
+		return nil
+	}
+	t.reported = outputTokens
+	return &dto.ClaudeResponse{
+		Type:  "message_delta",
+		Delta: &dto.ClaudeMediaMessage{},
+		Usage: &dto.ClaudeUsage{OutputTokens: outputTokens},
+	}
+}
+
+// Final 计算结束事件中的累计输出 token 数
+// 参数:
+//   - upstreamTokens: 上游返回的输出 token 数，为 0 时使用本地统计值
+//   - countedTokens: 本地增量统计的输出 token 数
+// 返回:
+//   - int: 不小于已发送值的累计输出 token 数，保证客户端计数单调不减
+func (t *ClaudeUsageDeltaTracker) Final(upstreamTokens int, countedTokens int) int {
+	outputTokens := upstreamTokens
+	if outputTokens == 0 {
+		outputTokens = countedTokens
+	}
+	if t != nil && outputTokens < t.reported {
+		outputTokens = t.reported
+	}
+	return outputTokens
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// deltaCoalesceMinBytes 合并后的文本增量达到该长度时下发
+	deltaCoalesceMinBytes = 64
+	// deltaCoalesceMaxDelay 缓存的文本增量最长等待时间，避免慢速输出时客户端长时间看不到内容
+	deltaCoalesceMaxDelay = 200 * time.Millisecond
+)
+
+// DeltaCoalescer 合并流式响应中的细碎文本增量，减少下发的事件数
+// 设置了 OnTimeout 时，缓存的文本等待超过 deltaCoalesceMaxDelay 后由定时器主动下发，不依赖下一个增量到达
+type DeltaCoalescer struct {
+	buffer  strings.Builder
+	since   time.Time
+	c       *gin.Context
+	emit    func(text string)
+	timer   *time.Timer
+	stopped bool
+}
+
+// NewDeltaCoalescer 创建增量合并器，未启用时返回 nil，Push 原样返回
+func NewDeltaCoalescer(enabled bool) *DeltaCoalescer {
+	if !enabled {
+		return nil
+	}
+	return &DeltaCoalescer{}
+}
+
+// OnTimeout 设置定时下发缓存文本的回调
+// 回调在流式响应的写锁内执行，Push 与 Flush 需在 StreamScannerHandler 的数据处理函数中调用，以与定时下发互斥
+// 参数:
+//   - c: 请求上下文，用于获取流式响应的写锁
+//   - emit: 下发缓存文本的回调
+func (d *DeltaCoalescer) OnTimeout(c *gin.Context, emit func(text string)) {
+	if d == nil {
+		return
+	}
+	d.c = c
+	d.emit = emit
+}
+
+// Push 缓存文本增量，累计长度或等待时间达到阈值时返回合并后的文本，否则返回空字符串
+func (d *DeltaCoalescer) Push(delta string) string {
+	if d == nil {
+		return delta
+	}
+	if delta == "" {
+		return ""
+	}
+	if d.buffer.Len() == 0 {
+		d.since = time.Now()
+		if d.emit != nil && !d.stopped {
+			d.timer = time.AfterFunc(deltaCoalesceMaxDelay, d.flushOnTimeout)
+		}
+	}
+	d.buffer.WriteString(delta)
+	if d.buffer.Len() < deltaCoalesceMinBytes && time.Since(d.since) < deltaCoalesceMaxDelay {
+		return ""
+	}
+	return d.Flush()
+}
+
+// Flush 返回并清空缓存的文本
+func (d *DeltaCoalescer) Flush() string {
+	if d == nil {
+		return ""
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	rest := d.buffer.String()
+	d.buffer.Reset()
+	return rest
+}
+
+// Stop 停止定时下发，StreamScannerHandler 返回后调用，之后缓存的文本只能通过 Flush 取出
+func (d *DeltaCoalescer) Stop() {
+	if d == nil || d.emit == nil {
+		return
+	}
+	lock := d.writeLock()
+	if lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// flushOnTimeout 定时器触发时在写锁内下发缓存的文本，定时器触发后缓存已被 Push 取出时不下发
+func (d *DeltaCoalescer) flushOnTimeout() {
+	lock := d.writeLock()
+	if lock == nil {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if d.stopped || d.buffer.Len() == 0 {
+		return
+	}
+	d.emit(d.Flush())
+}
+
+// writeLock 获取 StreamScannerHandler 登记的写锁，尚未开始流式处理时返回 nil
+func (d *DeltaCoalescer) writeLock() *sync.Mutex {
+	lock, _ := common.GetContextKeyType[*sync.Mutex](d.c, constant.ContextKeyStreamWriteLock)
+	return lock
+}
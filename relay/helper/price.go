@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
@@ -112,6 +113,11 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		}
 	}
 
+	if common.GetContextKeyBool(c, constant.ContextKeyValidationMirror) {
+		// 发往验证期模型的镜像流量由系统发起，不向用户计费
+		modelPrice, modelRatio, preConsumedQuota, freeModel = 0, 0, 0, true
+	}
+
 	priceData := types.PriceData{
 		FreeModel:            freeModel,
 		ModelPrice:           modelPrice,
@@ -0,0 +1,26 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/tidwall/gjson"
+)
+
+// ParseRawUsage 从原样转发的响应中解析 usage 对象，供 Claude 与 Responses 原样转发模式计费使用
+// 上游 usage 结构变化导致整体解析失败时调用 fallback 只读取 token 数，保证仍能计费
+// 参数:
+//   - usage: 原始 usage 对象，不是对象时返回零值
+//   - fallback: 整体解析失败时逐字段读取 token 数
+//
+// 返回:
+//   - *T: 解析后的 usage
+func ParseRawUsage[T any](usage gjson.Result, fallback func(usage gjson.Result) *T) *T {
+	parsed := new(T)
+	if !usage.IsObject() {
+		return parsed
+	}
+	if err := common.UnmarshalJsonStr(usage.Raw, parsed); err != nil {
+		return fallback(usage)
+	}
+	return parsed
+}
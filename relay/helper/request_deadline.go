@@ -0,0 +1,79 @@
+package helper
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestDeadlineExceededError 生成请求超过截止时间的 504 错误，Claude 请求使用 Anthropic 的 timeout_error 格式，其他请求使用 OpenAI 格式
+func RequestDeadlineExceededError(info *relaycommon.RelayInfo) *types.NewAPIError {
+	timeout := info.RequestDeadline.Sub(info.StartTime).Round(time.Millisecond)
+	err := types.NewLocalizedError(types.ErrMsgRequestDeadlineExceeded, timeout.String())
+	var apiErr *types.NewAPIError
+	if info.RelayFormat == types.RelayFormatClaude {
+		apiErr = types.WithClaudeError(types.ClaudeError{
+			Type:    "timeout_error",
+			Message: err.Error(),
+		}, http.StatusGatewayTimeout, types.ErrOptionWithSkipRetry())
+	} else {
+		apiErr = types.WithOpenAIError(types.OpenAIError{
+			Message: err.Error(),
+			Type:    "timeout_error",
+			Code:    string(types.ErrorCodeRequestTimeout),
+		}, http.StatusGatewayTimeout, types.ErrOptionWithSkipRetry())
+	}
+	// 保留可本地化的错误，由 Localize 按客户端语言改写消息
+	apiErr.Err = err
+	return apiErr
+}
+
+// SendStreamDeadlineExceeded 流式输出过程中超过截止时间时，按客户端请求格式发送超时错误事件，并附带截止时已产生的用量
+func SendStreamDeadlineExceeded(c *gin.Context, info *relaycommon.RelayInfo) {
+	apiErr := RequestDeadlineExceededError(info)
+	apiErr.Localize(service.GetErrorMessageLanguage(c))
+	completionTokens := 0
+	if info.StreamTokenCounter != nil {
+		completionTokens = info.StreamTokenCounter.CountedTokens()
+	}
+
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		_ = ClaudeData(c, dto.ClaudeResponse{
+			Type:  "error",
+			Error: apiErr.ToClaudeError(),
+			Usage: &dto.ClaudeUsage{
+				InputTokens:  info.PromptTokens,
+				OutputTokens: completionTokens,
+			},
+		})
+	case types.RelayFormatOpenAIResponses:
+		openaiErr := apiErr.ToOpenAIError()
+		data, err := common.Marshal(map[string]any{
+			"type":    "error",
+			"code":    openaiErr.Code,
+			"message": openaiErr.Message,
+			"param":   nil,
+		})
+		if err != nil {
+			return
+		}
+		ResponseChunkData(c, dto.ResponsesStreamResponse{Type: "error"}, string(data))
+	default:
+		_ = ObjectData(c, map[string]any{
+			"error": apiErr.ToOpenAIError(),
+			"usage": dto.Usage{
+				PromptTokens:     info.PromptTokens,
+				CompletionTokens: completionTokens,
+				TotalTokens:      info.PromptTokens + completionTokens,
+			},
+		})
+	}
+}
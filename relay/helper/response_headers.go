@@ -0,0 +1,39 @@
+package helper
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamResponseHeaderDenylist 由网关自行生成、不能从上游转发的响应头
+var upstreamResponseHeaderDenylist = map[string]bool{
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Set-Cookie":        true,
+}
+
+// ForwardUpstreamResponseHeaders 将白名单中的上游响应头转发给客户端，需在写出响应体之前调用
+// 仅转发成功响应的响应头，避免重试时失败渠道的响应头残留到最终响应
+func ForwardUpstreamResponseHeaders(c *gin.Context, resp *http.Response) {
+	if resp == nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return
+	}
+	if len(model_setting.GetGlobalSettings().ResponseHeaderPassthrough) == 0 {
+		return
+	}
+	for key, values := range resp.Header {
+		if len(values) == 0 || upstreamResponseHeaderDenylist[key] {
+			continue
+		}
+		if model_setting.IsResponseHeaderPassthroughAllowed(key) {
+			c.Writer.Header()[key] = append([]string(nil), values...)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ApplyUpstreamStreamAggregation 将转换后的非流式请求改为流式请求上游，响应阶段再聚合为非流式结果
+// 参数:
+//   - info: 转发信息，需聚合时设置 AggregateUpstreamStream
+//   - responsesReq: 转换后的 Responses 请求对象
+//   - required: 请求本身必须以流式发送（例如超长输出），为 false 时按 aggregate_upstream_stream 配置决定
+func ApplyUpstreamStreamAggregation(info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest, required bool) {
+	if responsesReq.Stream {
+		return
+	}
+	if !required && !model_setting.GetResponsesSettings().AggregateUpstreamStream {
+		return
+	}
+	responsesReq.Stream = true
+	info.AggregateUpstreamStream = true
+}
+
+// ReadResponsesBody 读取上游 Responses 非流式响应体，上游以流式返回时先聚合为等价的非流式响应体
+func ReadResponsesBody(info *relaycommon.RelayInfo, resp *http.Response) ([]byte, *types.NewAPIError) {
+	if info.AggregateUpstreamStream {
+		return readAggregatedResponsesStream(resp)
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	return responseBody, nil
+}
+
+// aggregatedStreamEvent 聚合时关心的流式事件字段，response 与 item 保留原始 JSON，避免丢失 DTO 未定义的字段
+type aggregatedStreamEvent struct {
+	Type     string          `json:"type"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Item     json.RawMessage `json:"item,omitempty"`
+	Delta    string          `json:"delta,omitempty"`
+	Code     string          `json:"code,omitempty"`
+	Message  string          `json:"message,omitempty"`
+}
+
+// isResponsesTerminalEvent 判断是否为携带最终响应的结束事件
+func isResponsesTerminalEvent(eventType string) bool {
+	switch eventType {
+	case "response.done", "response.completed", "response.incomplete", "response.failed":
+		return true
+	}
+	return false
+}
+
+// readAggregatedResponsesStream 读取上游 Responses 流式响应并组装为等价的非流式响应体
+// 参数:
+//   - resp: 上游流式响应，读取后响应头会被改写为 JSON，便于后续按非流式响应写出
+//
+// 返回:
+//   - []byte: 非流式 Responses 响应体
+//   - *types.NewAPIError: 上游返回错误事件或流在结束事件前中断时返回错误
+func readAggregatedResponsesStream(resp *http.Response) ([]byte, *types.NewAPIError) {
+	var (
+		finalResponse json.RawMessage
+		completed     bool
+		outputItems   []json.RawMessage
+		outputText    strings.Builder
+	)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, InitialScannerBufferSize), MaxScannerBufferSize)
+	for scanner.Scan() && !completed {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" || strings.HasPrefix(data, "[DONE]") {
+			continue
+		}
+
+		var event aggregatedStreamEvent
+		if err := common.UnmarshalJsonStr(data, &event); err != nil {
+			return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+		}
+		switch {
+		case event.Type == "error":
+			return nil, types.WithOpenAIError(types.OpenAIError{
+				Message: event.Message,
+				Type:    "upstream_error",
+				Code:    event.Code,
+			}, http.StatusInternalServerError)
+		case event.Type == "response.output_text.delta":
+			outputText.WriteString(event.Delta)
+		case event.Type == "response.output_item.done" && len(event.Item) > 0:
+			outputItems = append(outputItems, event.Item)
+		}
+		if len(event.Response) > 0 {
+			finalResponse = event.Response
+		}
+		completed = isResponsesTerminalEvent(event.Type)
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	if !completed || len(finalResponse) == 0 {
+		return nil, types.NewOpenAIError(fmt.Errorf("upstream stream ended before the response completed"), types.ErrorCodeBadResponse, http.StatusInternalServerError)
+	}
+
+	responseBody, err := fillAggregatedResponseOutput(finalResponse, outputItems, outputText.String())
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeJsonMarshalFailed, http.StatusInternalServerError)
+	}
+
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Cache-Control")
+	return responseBody, nil
+}
+
+// fillAggregatedResponseOutput 结束事件中的 output 为空时，使用流中收到的输出项或文本增量补全
+func fillAggregatedResponseOutput(finalResponse json.RawMessage, outputItems []json.RawMessage, outputText string) ([]byte, error) {
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(finalResponse, &response); err != nil {
+		return nil, err
+	}
+	var output []json.RawMessage
+	if raw, ok := response["output"]; ok {
+		_ = common.Unmarshal(raw, &output)
+	}
+	if len(output) > 0 {
+		return finalResponse, nil
+	}
+
+	if len(outputItems) == 0 && outputText != "" {
+		item, err := common.Marshal(map[string]any{
+			"type":   "message",
+			"status": "completed",
+			"role":   "assistant",
+			"content": []map[string]any{
+				{"type": "output_text", "text": outputText, "annotations": []any{}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		outputItems = append(outputItems, item)
+	}
+	if len(outputItems) == 0 {
+		return finalResponse, nil
+	}
+	outputData, err := common.Marshal(outputItems)
+	if err != nil {
+		return nil, err
+	}
+	response["output"] = outputData
+	return common.Marshal(response)
+}
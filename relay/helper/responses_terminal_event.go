@@ -0,0 +1,59 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	responsesEventCompleted = "response.completed"
+	responsesEventDone      = "response.done"
+)
+
+// IsResponsesTerminalEvent 判断是否为 Responses 流的正常结束事件，上游可能使用 response.completed 或旧版的 response.done
+func IsResponsesTerminalEvent(eventType string) bool {
+	return eventType == responsesEventCompleted || eventType == responsesEventDone
+}
+
+// ResponseTerminalChunkData 按渠道兼容设置发送 Responses 流事件，终止事件会被重命名或同时以两种名称发送
+// 参数:
+//   - c: Gin 上下文
+//   - mode: 渠道的终止事件兼容设置，为空时原样转发
+//   - resp: 解析后的流事件
+//   - data: 上游原始事件数据
+func ResponseTerminalChunkData(c *gin.Context, mode string, resp dto.ResponsesStreamResponse, data string) {
+	if mode == "" || !IsResponsesTerminalEvent(resp.Type) {
+		ResponseChunkData(c, resp, data)
+		return
+	}
+	var names []string
+	switch mode {
+	case dto.ResponsesTerminalEventCompleted:
+		names = []string{responsesEventCompleted}
+	case dto.ResponsesTerminalEventDone:
+		names = []string{responsesEventDone}
+	case dto.ResponsesTerminalEventBoth:
+		// 先发送上游原始名称，再补发另一名称，两种客户端都能识别
+		names = []string{responsesEventCompleted, responsesEventDone}
+		if resp.Type == responsesEventDone {
+			names = []string{responsesEventDone, responsesEventCompleted}
+		}
+	default:
+		ResponseChunkData(c, resp, data)
+		return
+	}
+	upstreamType := resp.Type
+	for _, name := range names {
+		renamed := data
+		if name != upstreamType {
+			var err error
+			if renamed, err = sjson.Set(data, "type", name); err != nil {
+				renamed = data
+			}
+		}
+		resp.Type = name
+		ResponseChunkData(c, resp, renamed)
+	}
+}
@@ -0,0 +1,30 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoutingHeaderChannelType   = "X-NewAPI-Channel-Type"
+	RoutingHeaderConvertedFrom = "X-NewAPI-Converted-From"
+	RoutingHeaderUpstreamModel = "X-NewAPI-Upstream-Model"
+)
+
+// SetRoutingHeaders 在响应头中返回本次请求的路由信息，需在写出响应体之前调用
+// X-NewAPI-Converted-From 取值为 chat、claude 或 native（未经过 Responses 转换）
+func SetRoutingHeaders(c *gin.Context, info *relaycommon.RelayInfo) {
+	if !model_setting.GetGlobalSettings().RoutingHeadersEnabled || info == nil {
+		return
+	}
+	convertedFrom := "native"
+	if info.IsConverted() {
+		convertedFrom = string(info.ConversionSource)
+	}
+	c.Header(RoutingHeaderChannelType, constant.GetChannelTypeName(info.ChannelType))
+	c.Header(RoutingHeaderConvertedFrom, convertedFrom)
+	c.Header(RoutingHeaderUpstreamModel, info.UpstreamModelName)
+}
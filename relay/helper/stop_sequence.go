@@ -0,0 +1,92 @@
+package helper
+
+import (
+	"strings"
+)
+
+// StopSequenceMatcher 在流式文本增量中模拟停止序列
+// 停止序列可能跨越多个增量，末尾可能构成停止序列前缀的文本会暂缓下发，直到能确定是否命中
+type StopSequenceMatcher struct {
+	stops   []string
+	pending string
+	matched string
+}
+
+// NewStopSequenceMatcher 创建停止序列匹配器，没有有效的停止序列时返回 nil
+func NewStopSequenceMatcher(stops []string) *StopSequenceMatcher {
+	var valid []string
+	for _, stop := range stops {
+		if stop != "" {
+			valid = append(valid, stop)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return &StopSequenceMatcher{stops: valid}
+}
+
+// Push 处理文本增量
+// 参数:
+//   - delta: 上游返回的文本增量
+//
+// 返回:
+//   - string: 可以下发的文本，命中时为停止序列之前的文本
+//   - bool: 是否命中停止序列，命中后调用方应结束输出
+func (m *StopSequenceMatcher) Push(delta string) (string, bool) {
+	if m == nil {
+		return delta, false
+	}
+	text := m.pending + delta
+	m.pending = ""
+	if index, stop := FindStopSequence(text, m.stops); index >= 0 {
+		m.matched = stop
+		return text[:index], true
+	}
+	hold := 0
+	for _, stop := range m.stops {
+		for length := min(len(stop)-1, len(text)); length > hold; length-- {
+			if strings.HasSuffix(text, stop[:length]) {
+				hold = length
+				break
+			}
+		}
+	}
+	m.pending = text[len(text)-hold:]
+	return text[:len(text)-hold], false
+}
+
+// Flush 输出结束时返回暂缓下发的文本
+func (m *StopSequenceMatcher) Flush() string {
+	if m == nil {
+		return ""
+	}
+	rest := m.pending
+	m.pending = ""
+	return rest
+}
+
+// Matched 返回命中的停止序列，未命中时为空
+func (m *StopSequenceMatcher) Matched() string {
+	if m == nil {
+		return ""
+	}
+	return m.matched
+}
+
+// FindStopSequence 查找文本中最早出现的停止序列
+// 返回:
+//   - int: 停止序列的起始位置，未找到时为 -1
+//   - string: 命中的停止序列
+func FindStopSequence(text string, stops []string) (int, string) {
+	index, matched := -1, ""
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if i := strings.Index(text, stop); i >= 0 && (index < 0 || i < index) {
+			index, matched = i, stop
+		}
+	}
+	return index, matched
+}
@@ -0,0 +1,60 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// StreamDedupGuard 检测并抑制上游重连后重放的流式事件
+// 按事件的 sequence_number 判断重放，内容相同但序号递增的增量属于正常输出，不会被丢弃
+// 上游开始新的响应（response.created 携带不同的响应 ID）时序号重新计数，已记录的序号随之清空
+type StreamDedupGuard struct {
+	enabled      bool
+	seen         bool
+	lastSequence int
+	responseId   string
+}
+
+// NewStreamDedupGuard 根据 responses 配置创建流式去重器，未启用时 Filter 原样返回
+func NewStreamDedupGuard() *StreamDedupGuard {
+	return &StreamDedupGuard{
+		enabled: model_setting.GetResponsesSettings().StreamDedupEnabled,
+	}
+}
+
+// Observe 观察上游的每个流式事件，收到新响应的 response.created 时重置已记录的序号
+// 同一响应重放的 response.created 不会重置，其后重放的增量仍按序号丢弃
+func (g *StreamDedupGuard) Observe(event *dto.ResponsesStreamResponse) {
+	if g == nil || !g.enabled || event.Type != "response.created" {
+		return
+	}
+	responseId := ""
+	if event.Response != nil {
+		responseId = event.Response.ID
+	}
+	if responseId != "" && responseId == g.responseId {
+		return
+	}
+	g.responseId = responseId
+	g.seen = false
+	g.lastSequence = 0
+}
+
+// Filter 过滤文本增量事件
+// 参数:
+//   - event: 上游返回的文本增量事件
+//
+// 返回:
+//   - string: 事件的文本增量，序号不大于已处理的最大序号时视为重放，返回空字符串；事件不带序号时原样返回
+func (g *StreamDedupGuard) Filter(event *dto.ResponsesStreamResponse) string {
+	if g == nil || !g.enabled || event.SequenceNumber == nil {
+		return event.Delta
+	}
+	sequence := *event.SequenceNumber
+	if g.seen && sequence <= g.lastSequence {
+		return ""
+	}
+	g.seen = true
+	g.lastSequence = sequence
+	return event.Delta
+}
@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"github.com/tidwall/gjson"
+)
+
+// IsStreamErrorEvent 判断一条流式事件是否表示上游出错或未正常完成
+// 包括 Claude 与 Responses 的 error 事件、带 error 字段的 Chat Completions 数据块，
+// 以及 response.failed、response.incomplete 等状态不是 completed 的 Responses 事件
+// 参数:
+//   - data: 去掉 "data:" 前缀后的事件数据
+//
+// 返回:
+//   - bool: 是否为错误事件
+func IsStreamErrorEvent(data string) bool {
+	results := gjson.GetMany(data, "type", "error", "response.status")
+	eventType, errField, status := results[0].String(), results[1], results[2].String()
+	switch eventType {
+	case "error", "response.failed", "response.incomplete", "response.cancelled":
+		return true
+	}
+	if errField.Exists() && errField.Type != gjson.Null {
+		return true
+	}
+	// response.completed、response.done 等终止事件携带的状态不是 completed 时同样视为未正常完成
+	return IsResponsesTerminalEvent(eventType) && status != "" && status != "completed"
+}
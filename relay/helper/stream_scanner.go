@@ -3,6 +3,7 @@ package helper
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +15,8 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -41,6 +44,10 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	}()
 
 	streamingTimeout := time.Duration(constant.StreamingTimeout) * time.Second
+	// 长输出请求的生成时间更长，思考阶段可能长时间没有增量，按配置放宽空闲超时
+	if info.LongOutput {
+		streamingTimeout = max(streamingTimeout, time.Duration(model_setting.GetClaudeSettings().LongOutputStreamingTimeout)*time.Second)
+	}
 
 	var (
 		stopChan   = make(chan bool, 3) // 增加缓冲区避免阻塞
@@ -61,6 +68,8 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	if pingEnabled {
 		pingTicker = time.NewTicker(pingInterval)
 	}
+	// 定时下发缓存内容的协程与数据处理、ping 共用同一把写锁
+	common.SetContextKey(c, constant.ContextKeyStreamWriteLock, &writeMutex)
 
 	if common.DebugEnabled {
 		// print timeout and ping interval for debugging
@@ -95,6 +104,10 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		close(stopChan)
 	}()
 
+	// 登记为活跃流，管理员可通过接口查看与终止
+	terminated, unregister := service.RegisterActiveStream(c, info)
+	defer unregister()
+
 	scanner.Buffer(make([]byte, InitialScannerBufferSize), MaxScannerBufferSize)
 	scanner.Split(bufio.ScanLines)
 	SetEventStreamHeaders(c)
@@ -181,6 +194,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			}
 		}()
 
+		eventCount := 0
 		for scanner.Scan() {
 			// 检查是否需要停止
 			select {
@@ -210,6 +224,22 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			data = strings.TrimSuffix(data, "\r")
 			if !strings.HasPrefix(data, "[DONE]") {
 				info.SetFirstResponseTime()
+				if !info.StreamErrored && IsStreamErrorEvent(data) {
+					info.StreamErrored = true
+				}
+				// 失败后可能重试，需要估算本次尝试的输出 token 以便审计各次尝试的成本；
+				// 处理器设置了流式 token 计数器时直接读取计数，否则按累积上限记录输出文本
+				if common.RetryTimes > 0 && info.StreamTokenCounter == nil && !info.AttemptOutputFull(constant.StreamMaxAccumulatedBytes) {
+					info.AppendAttemptOutput(StreamOutputText(data), constant.StreamMaxAccumulatedBytes)
+				}
+
+				// 防止失控的上游无限推送事件
+				eventCount++
+				if constant.StreamMaxEvents > 0 && eventCount > constant.StreamMaxEvents {
+					logger.LogError(c, fmt.Sprintf("stream exceeded max events %d, stopping scanner", constant.StreamMaxEvents))
+					info.StreamAborted = true
+					return
+				}
 
 				// 使用超时机制防止写操作阻塞
 				done := make(chan bool, 1)
@@ -224,6 +254,12 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 					if !success {
 						return
 					}
+					// 实时计入输出 token，超出令牌 TPM 限制时终止流，已输出的内容按实际用量计费
+					if counter := info.StreamTokenCounter; counter != nil && !service.ConsumeStreamTokenRate(info, counter.CountedTokens()) {
+						logger.LogWarn(c, "stream stopped: token rate limit exceeded")
+						info.StreamTerminated = true
+						return
+					}
 				case <-time.After(10 * time.Second):
 					logger.LogError(c, "data handler timeout")
 					return
@@ -244,6 +280,16 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		if err := scanner.Err(); err != nil {
 			if err != io.EOF {
 				logger.LogError(c, "scanner error: "+err.Error())
+				info.StreamAborted = true
+			}
+			// 上游请求因超过客户端指定的截止时间被取消，向客户端发送超时错误事件，已输出的内容按实际用量计费
+			if errors.Is(err, context.DeadlineExceeded) && !info.RequestDeadline.IsZero() {
+				info.DeadlineExceeded = true
+				if !info.AggregateUpstreamStream {
+					writeMutex.Lock()
+					SendStreamDeadlineExceeded(c, info)
+					writeMutex.Unlock()
+				}
 			}
 		}
 	})
@@ -253,11 +299,16 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	case <-ticker.C:
 		// 超时处理逻辑
 		logger.LogError(c, "streaming timeout")
+		info.StreamAborted = true
 	case <-stopChan:
 		// 正常结束
 		logger.LogInfo(c, "streaming finished")
 	case <-c.Request.Context().Done():
 		// 客户端断开连接
 		logger.LogInfo(c, "client disconnected")
+	case <-terminated:
+		// 管理员终止
+		logger.LogWarn(c, "stream terminated by administrator")
+		info.StreamTerminated = true
 	}
 }
@@ -220,12 +220,12 @@ func GetAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest
 	if err != nil {
 		return nil, err
 	}
-	if textRequest.Messages == nil || len(textRequest.Messages) == 0 {
-		return nil, errors.New("field messages is required")
-	}
 	if textRequest.Model == "" {
 		return nil, errors.New("field model is required")
 	}
+	if err = validateClaudeMessages(textRequest.Messages); err != nil {
+		return nil, err
+	}
 
 	//if textRequest.Stream {
 	//	relayInfo.IsStream = true
@@ -234,6 +234,78 @@ func GetAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest
 	return textRequest, nil
 }
 
+// ClaudeRequestValidationError Claude Messages 请求校验错误，以 Anthropic 风格的 invalid_request_error 返回给客户端
+type ClaudeRequestValidationError struct {
+	Message string
+}
+
+func (e *ClaudeRequestValidationError) Error() string {
+	return e.Message
+}
+
+// validateClaudeMessages 校验 Claude Messages 的角色与内容非空，原生 Claude 渠道同样拒绝这些请求
+// 角色交替与首条消息角色只在转换为其他格式时校验，见 ValidateClaudeMessageOrder
+func validateClaudeMessages(messages []dto.ClaudeMessage) error {
+	if len(messages) == 0 {
+		return &ClaudeRequestValidationError{Message: "messages: at least one message is required"}
+	}
+	for i, message := range messages {
+		if message.Role != "user" && message.Role != "assistant" {
+			return &ClaudeRequestValidationError{Message: fmt.Sprintf("messages.%d.role: Input should be 'user' or 'assistant'", i)}
+		}
+		if message.Content == nil {
+			return &ClaudeRequestValidationError{Message: fmt.Sprintf("messages.%d.content: Field required", i)}
+		}
+		// 最后一条 assistant 消息作为预填充时允许为空
+		if i == len(messages)-1 && message.Role == "assistant" {
+			continue
+		}
+		if isClaudeContentEmpty(message.Content) {
+			return &ClaudeRequestValidationError{Message: fmt.Sprintf("messages.%d: all messages must have non-empty content except for the optional final assistant message", i)}
+		}
+	}
+	return nil
+}
+
+// isClaudeContentEmpty 判断消息内容是否为空，内容块数组中只有空白文本块时同样视为空
+func isClaudeContentEmpty(content any) bool {
+	switch content := content.(type) {
+	case string:
+		return strings.TrimSpace(content) == ""
+	case []any:
+		for _, block := range content {
+			item, ok := block.(map[string]any)
+			if !ok || item["type"] != "text" {
+				return false
+			}
+			if text, _ := item["text"].(string); strings.TrimSpace(text) != "" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ValidateClaudeMessageOrder 校验 Claude Messages 的首条消息为 user 且角色交替出现
+// Anthropic 会合并连续的同角色消息，原生 Claude 渠道不需要该校验；转换为 Responses 等格式前调用，给出明确的错误而不是上游的转换错误
+// 参数:
+//   - messages: Claude 请求的消息列表
+//
+// 返回:
+//   - error: 顺序不符合要求时返回 *ClaudeRequestValidationError
+func ValidateClaudeMessageOrder(messages []dto.ClaudeMessage) error {
+	for i, message := range messages {
+		if i == 0 && message.Role != "user" {
+			return &ClaudeRequestValidationError{Message: "messages: first message must use the \"user\" role"}
+		}
+		if i > 0 && messages[i-1].Role == message.Role {
+			return &ClaudeRequestValidationError{Message: fmt.Sprintf("messages: roles must alternate between \"user\" and \"assistant\", but found multiple \"%s\" roles in a row", message.Role)}
+		}
+	}
+	return nil
+}
+
 func GetAndValidateTextRequest(c *gin.Context, relayMode int) (*dto.GeneralOpenAIRequest, error) {
 	textRequest := &dto.GeneralOpenAIRequest{}
 	err := common.UnmarshalBodyReusable(c, textRequest)
@@ -36,6 +36,16 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
 	}
 
+	// A/B 实验分组指定的推理强度覆盖客户端的设置
+	service.ApplyExperimentReasoningEffort(info, request)
+
+	// 客户端引用的网关 ID 还原为上游 ID
+	request.PreviousResponseID = service.RestoreUpstreamResponseId(c, request.PreviousResponseID)
+	request.Input = service.RestoreUpstreamResponseIds(c, request.Input)
+
+	// 请求未指定或超过令牌默认上限时使用令牌的工具调用上限
+	request.MaxToolCalls = service.ResolveMaxToolCalls(c, request.MaxToolCalls)
+
 	adaptor := GetAdaptor(info.ApiType)
 	if adaptor == nil {
 		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
@@ -76,6 +86,7 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 			println("requestBody: ", string(jsonData))
 		}
 		requestBody = bytes.NewBuffer(jsonData)
+		info.UpstreamRequestBody = jsonData
 	}
 
 	var httpResp *http.Response
@@ -97,6 +108,8 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		}
 	}
 
+	helper.SetRoutingHeaders(c, info)
+
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
 	if newAPIError != nil {
 		// reset status code 重置状态码
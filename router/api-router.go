@@ -26,6 +26,7 @@ func SetApiRouter(router *gin.Engine) {
 		//apiRouter.GET("/midjourney", controller.GetMidjourney)
 		apiRouter.GET("/home_page_content", controller.GetHomePageContent)
 		apiRouter.GET("/pricing", middleware.TryUserAuth(), controller.GetPricing)
+		apiRouter.POST("/pricing/estimate", middleware.UserAuth(), controller.EstimatePricing)
 		apiRouter.GET("/verification", middleware.EmailVerificationRateLimit(), middleware.TurnstileCheck(), controller.SendEmailVerification)
 		apiRouter.GET("/reset_password", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.SendPasswordResetEmail)
 		apiRouter.POST("/user/reset", middleware.CriticalRateLimit(), controller.ResetPassword)
@@ -122,12 +123,40 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
 			optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
 		}
+		conversionKillSwitchRoute := apiRouter.Group("/conversion_kill_switch")
+		conversionKillSwitchRoute.Use(middleware.AdminAuth())
+		{
+			conversionKillSwitchRoute.GET("", controller.GetConversionKillSwitch)
+			conversionKillSwitchRoute.PUT("", controller.UpdateConversionKillSwitch)
+		}
+		channelGroupBindingRoute := apiRouter.Group("/channel_group_binding")
+		channelGroupBindingRoute.Use(middleware.AdminAuth())
+		{
+			channelGroupBindingRoute.GET("", controller.GetChannelGroupBindings)
+			channelGroupBindingRoute.PUT("", controller.UpdateChannelGroupBinding)
+			channelGroupBindingRoute.DELETE("/:scope/:id", controller.DeleteChannelGroupBinding)
+		}
+		experimentRoute := apiRouter.Group("/experiment")
+		experimentRoute.Use(middleware.AdminAuth())
+		{
+			experimentRoute.GET("", controller.GetExperiments)
+			experimentRoute.PUT("", controller.UpdateExperiments)
+			experimentRoute.GET("/metrics", controller.GetExperimentMetrics)
+			experimentRoute.DELETE("/metrics/:name", controller.ResetExperimentMetrics)
+		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
 		ratioSyncRoute.Use(middleware.RootAuth())
 		{
 			ratioSyncRoute.GET("/channels", controller.GetSyncableChannels)
 			ratioSyncRoute.POST("/fetch", controller.FetchUpstreamRatios)
 		}
+		pricingConfigRoute := apiRouter.Group("/pricing_config")
+		pricingConfigRoute.Use(middleware.RootAuth())
+		{
+			pricingConfigRoute.GET("/export", controller.ExportPricingConfig)
+			pricingConfigRoute.POST("/import/preview", controller.PreviewPricingConfigImport)
+			pricingConfigRoute.POST("/import", controller.ImportPricingConfig)
+		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
 		{
@@ -135,7 +164,22 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/responses_quarantine", controller.GetResponsesQuarantine)
+			channelRoute.GET("/quality_samples", controller.GetQualitySamples)
+			channelRoute.GET("/quality_samples/:id", controller.GetQualitySample)
+			channelRoute.PUT("/quality_samples/:id", controller.ReviewQualitySample)
+			channelRoute.DELETE("/quality_samples/:id", controller.DeleteQualitySample)
+			channelRoute.GET("/conversion_traces", controller.GetConversionTraces)
+			channelRoute.GET("/conversion_traces/:request_id", controller.GetConversionTrace)
+			channelRoute.POST("/stream_replay", controller.ReplayConversionStream)
+			channelRoute.GET("/health", controller.GetChannelHealth)
+			channelRoute.GET("/slo", controller.GetChannelSLO)
+			channelRoute.GET("/readiness", controller.GetChannelReadiness)
+			channelRoute.GET("/maintenance", controller.GetChannelMaintenance)
+			channelRoute.GET("/route_simulate", controller.SimulateChannelRoute)
 			channelRoute.GET("/:id", controller.GetChannel)
+			channelRoute.GET("/:id/capabilities", controller.GetChannelCapabilities)
+			channelRoute.POST("/:id/claude_smart_routing/reload", controller.ReloadClaudeSmartRouting)
 			channelRoute.POST("/:id/key", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
@@ -162,6 +206,8 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
+			tokenRoute.GET("/abuse", middleware.AdminAuth(), controller.GetTokenAbuseEvents)
+			tokenRoute.DELETE("/abuse/:id", middleware.AdminAuth(), controller.UnblockAbuseToken)
 			tokenRoute.GET("/:id", controller.GetToken)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
@@ -169,6 +215,18 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
 		}
 
+		tokenCountRoute := apiRouter.Group("/token/count")
+		tokenCountRoute.Use(middleware.CriticalRateLimit(), middleware.TokenAuth(), middleware.ModelRequestRateLimit())
+		{
+			tokenCountRoute.POST("", controller.CountTokens)
+		}
+
+		tokenExchangeRoute := apiRouter.Group("/token/exchange")
+		tokenExchangeRoute.Use(middleware.CriticalRateLimit(), middleware.TokenAuth())
+		{
+			tokenExchangeRoute.POST("", controller.ExchangeToken)
+		}
+
 		usageRoute := apiRouter.Group("/usage")
 		usageRoute.Use(middleware.CriticalRateLimit())
 		{
@@ -176,6 +234,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenUsageRoute.Use(middleware.TokenAuth())
 			{
 				tokenUsageRoute.GET("/", controller.GetTokenUsage)
+				tokenUsageRoute.GET("/daily", controller.GetTokenDailyUsage)
 			}
 		}
 
@@ -194,6 +253,10 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/archive", middleware.AdminAuth(), controller.GetLogArchiveURL)
+		logRoute.GET("/export", middleware.AdminAuth(), middleware.CriticalRateLimit(), controller.ExportLogs)
+		logRoute.GET("/export/:id", middleware.AdminAuth(), controller.GetLogExportJob)
+		logRoute.GET("/export/:id/download", middleware.AdminAuth(), controller.DownloadLogExport)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
@@ -213,6 +276,13 @@ func SetApiRouter(router *gin.Engine) {
 			groupRoute.GET("/", controller.GetGroups)
 		}
 
+		streamRoute := apiRouter.Group("/stream")
+		streamRoute.Use(middleware.AdminAuth())
+		{
+			streamRoute.GET("/", controller.GetActiveStreams)
+			streamRoute.DELETE("/:id", controller.TerminateActiveStream)
+		}
+
 		prefillGroupRoute := apiRouter.Group("/prefill_group")
 		prefillGroupRoute.Use(middleware.AdminAuth())
 		{
@@ -55,6 +55,20 @@ func SetRelayRouter(router *gin.Engine) {
 		})
 	}
 
+	asyncRouter := router.Group("/v1/async")
+	asyncRouter.Use(middleware.TokenAuth())
+	{
+		asyncRouter.GET("/jobs/:id", controller.GetAsyncRelayJob)
+	}
+
+	// 按网关 ID 查询与取消响应，不经过渠道分发，由 ID 映射找回生成响应的渠道
+	storedResponsesRouter := router.Group("/v1/responses")
+	storedResponsesRouter.Use(middleware.TokenAuth())
+	{
+		storedResponsesRouter.GET("/:id", controller.RetrieveResponse)
+		storedResponsesRouter.POST("/:id/cancel", controller.CancelResponse)
+	}
+
 	playgroundRouter := router.Group("/pg")
 	playgroundRouter.Use(middleware.UserAuth(), middleware.Distribute())
 	{
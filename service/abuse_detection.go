@@ -0,0 +1,259 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// abuseEventLimit 保留的异常事件最大条数
+	abuseEventLimit = 500
+	// abusePromptSweepSize 提示词计数表超过该条目数时清理过期条目
+	abusePromptSweepSize = 10000
+)
+
+// 异常类型
+const (
+	AbuseKindRepeatedPrompt = "repeated_prompt"
+	AbuseKindToolLoop       = "tool_loop"
+)
+
+// AbuseEvent 令牌异常流量事件
+type AbuseEvent struct {
+	Id        int64  `json:"id"`
+	TokenId   int    `json:"token_id"`
+	TokenName string `json:"token_name"`
+	UserId    int    `json:"user_id"`
+	ModelName string `json:"model_name"`
+	Kind      string `json:"kind"`
+	Count     int    `json:"count"`
+	Detail    string `json:"detail"`
+	Action    string `json:"action"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+var (
+	abuseMutex sync.Mutex
+	// abusePromptHits key 为 令牌ID:提示词摘要，value 为窗口内的请求时间
+	abusePromptHits   = make(map[string][]int64)
+	abuseBlockedUntil = make(map[int]int64)
+	abuseEvents       []AbuseEvent
+	abuseNextEventId  int64
+)
+
+// CheckRequestAbuse 检测令牌是否在高频发送相同提示词，或请求的对话历史中存在重复相同工具调用的 Agent 循环
+// 按配置的处理方式记录事件、拒绝请求或临时封禁令牌
+// 参数:
+//   - c: Gin 上下文
+//   - request: 解析后的请求，仅 Chat Completions 与 Claude Messages 请求检测工具调用循环
+//   - meta: 请求的 token 计数元数据，使用其中的提示词文本计算摘要
+//
+// 返回:
+//   - *types.NewAPIError: 需要拒绝请求时返回 429 错误，不会重试
+func CheckRequestAbuse(c *gin.Context, request dto.Request, meta *types.TokenCountMeta) *types.NewAPIError {
+	settings := operation_setting.GetAbuseDetectionSetting()
+	tokenId := c.GetInt("token_id")
+	if !settings.Enabled || tokenId == 0 {
+		return nil
+	}
+	now := time.Now()
+	action := settings.GetAction()
+
+	abuseMutex.Lock()
+	if until, ok := abuseBlockedUntil[tokenId]; ok {
+		if now.Unix() < until {
+			abuseMutex.Unlock()
+			remaining := time.Duration(until-now.Unix()) * time.Second
+			return types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgAbuseTokenBlocked, remaining.String()),
+				types.ErrorCodeAbuseDetected, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+		}
+		delete(abuseBlockedUntil, tokenId)
+	}
+	abuseMutex.Unlock()
+
+	modelName := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+	if settings.ToolLoopThreshold > 0 {
+		if signature, count := trailingToolCallRepeat(request); count >= settings.ToolLoopThreshold {
+			recordAbuseEvent(c, AbuseKindToolLoop, count, signature, action, modelName)
+			if action != operation_setting.AbuseActionLog {
+				return types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgAbuseToolLoop, signature, count),
+					types.ErrorCodeAbuseDetected, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+			}
+		}
+	}
+
+	if settings.RepeatedPromptThreshold > 0 && settings.RepeatedPromptWindowSeconds > 0 && meta != nil && meta.CombineText != "" {
+		digest := sha256.Sum256([]byte(modelName + "\n" + meta.CombineText))
+		key := fmt.Sprintf("%d:%s", tokenId, hex.EncodeToString(digest[:16]))
+		count := countRepeatedPrompt(key, now.Unix(), int64(settings.RepeatedPromptWindowSeconds))
+		if count >= settings.RepeatedPromptThreshold {
+			// 仅在首次达到阈值时记录事件，避免持续的重复请求刷满事件列表
+			if count == settings.RepeatedPromptThreshold {
+				recordAbuseEvent(c, AbuseKindRepeatedPrompt, count, fmt.Sprintf("%d requests in %ds", count, settings.RepeatedPromptWindowSeconds), action, modelName)
+			}
+			if action != operation_setting.AbuseActionLog {
+				return types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgAbuseRepeatedPrompt, count, settings.RepeatedPromptWindowSeconds),
+					types.ErrorCodeAbuseDetected, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+			}
+		}
+	}
+	return nil
+}
+
+// countRepeatedPrompt 记录一次请求并返回窗口内相同提示词的请求次数
+func countRepeatedPrompt(key string, now int64, window int64) int {
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	if len(abusePromptHits) > abusePromptSweepSize {
+		for k, hits := range abusePromptHits {
+			if len(hits) == 0 || hits[len(hits)-1] <= now-window {
+				delete(abusePromptHits, k)
+			}
+		}
+	}
+	hits := abusePromptHits[key]
+	start := 0
+	for start < len(hits) && hits[start] <= now-window {
+		start++
+	}
+	hits = append(hits[start:], now)
+	abusePromptHits[key] = hits
+	return len(hits)
+}
+
+// recordAbuseEvent 记录异常事件，处理方式为 block 时同时封禁令牌
+func recordAbuseEvent(c *gin.Context, kind string, count int, detail string, action string, modelName string) {
+	settings := operation_setting.GetAbuseDetectionSetting()
+	event := AbuseEvent{
+		TokenId:   c.GetInt("token_id"),
+		TokenName: c.GetString("token_name"),
+		UserId:    c.GetInt("id"),
+		ModelName: modelName,
+		Kind:      kind,
+		Count:     count,
+		Detail:    detail,
+		Action:    action,
+		CreatedAt: time.Now().Unix(),
+	}
+	logger.LogWarn(c, fmt.Sprintf("abuse detected: token_id=%d, kind=%s, count=%d, detail=%s, action=%s", event.TokenId, kind, count, detail, action))
+
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	abuseNextEventId++
+	event.Id = abuseNextEventId
+	abuseEvents = append(abuseEvents, event)
+	if len(abuseEvents) > abuseEventLimit {
+		abuseEvents = abuseEvents[len(abuseEvents)-abuseEventLimit:]
+	}
+	if action == operation_setting.AbuseActionBlock && settings.BlockDurationSeconds > 0 {
+		abuseBlockedUntil[event.TokenId] = event.CreatedAt + int64(settings.BlockDurationSeconds)
+	}
+}
+
+// trailingToolCallRepeat 统计对话历史末尾连续出现的相同工具调用次数
+// 返回:
+//   - string: 重复的工具调用名称
+//   - int: 连续重复次数，没有工具调用时为 0
+func trailingToolCallRepeat(request dto.Request) (string, int) {
+	type toolCall struct {
+		name      string
+		signature string
+	}
+	var calls []toolCall
+	switch req := request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		for i := range req.Messages {
+			if req.Messages[i].Role != "assistant" {
+				continue
+			}
+			for _, call := range req.Messages[i].ParseToolCalls() {
+				calls = append(calls, toolCall{name: call.Function.Name, signature: call.Function.Name + "\n" + call.Function.Arguments})
+			}
+		}
+	case *dto.ClaudeRequest:
+		for i := range req.Messages {
+			if req.Messages[i].Role != "assistant" || req.Messages[i].IsStringContent() {
+				continue
+			}
+			contents, err := req.Messages[i].ParseContent()
+			if err != nil {
+				continue
+			}
+			for _, content := range contents {
+				if content.Type != "tool_use" {
+					continue
+				}
+				input, _ := common.Marshal(content.Input)
+				calls = append(calls, toolCall{name: content.Name, signature: content.Name + "\n" + string(input)})
+			}
+		}
+	}
+	if len(calls) == 0 {
+		return "", 0
+	}
+	last := calls[len(calls)-1]
+	count := 0
+	for i := len(calls) - 1; i >= 0 && calls[i].signature == last.signature; i-- {
+		count++
+	}
+	return last.name, count
+}
+
+// GetAbuseEvents 获取异常事件，按时间从新到旧排列
+// 参数:
+//   - tokenId: 按令牌筛选，为 0 时不筛选
+func GetAbuseEvents(tokenId int) []AbuseEvent {
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	events := make([]AbuseEvent, 0, len(abuseEvents))
+	for i := len(abuseEvents) - 1; i >= 0; i-- {
+		if tokenId != 0 && abuseEvents[i].TokenId != tokenId {
+			continue
+		}
+		events = append(events, abuseEvents[i])
+	}
+	return events
+}
+
+// GetAbuseBlockedTokens 获取当前被临时封禁的令牌及封禁截止时间
+func GetAbuseBlockedTokens() map[int]int64 {
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	now := time.Now().Unix()
+	blocked := make(map[int]int64, len(abuseBlockedUntil))
+	for tokenId, until := range abuseBlockedUntil {
+		if until > now {
+			blocked[tokenId] = until
+		}
+	}
+	return blocked
+}
+
+// UnblockAbuseToken 解除令牌的临时封禁，并清除其重复提示词计数
+func UnblockAbuseToken(tokenId int) bool {
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	prefix := fmt.Sprintf("%d:", tokenId)
+	for key := range abusePromptHits {
+		if strings.HasPrefix(key, prefix) {
+			delete(abusePromptHits, key)
+		}
+	}
+	_, ok := abuseBlockedUntil[tokenId]
+	delete(abuseBlockedUntil, tokenId)
+	return ok
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// AsyncRelayHeader 客户端请求异步处理时携带的请求头
+	AsyncRelayHeader = "X-NewAPI-Async"
+	// AsyncRelayCallbackHeader 客户端接收结果回调的地址
+	AsyncRelayCallbackHeader = "X-NewAPI-Callback-Url"
+	// AsyncRelaySignatureHeader 回调请求的签名，为使用令牌密钥对回调内容计算的 HMAC-SHA256
+	AsyncRelaySignatureHeader = "X-NewAPI-Signature"
+
+	AsyncJobStatusQueued    = "queued"
+	AsyncJobStatusRunning   = "running"
+	AsyncJobStatusSucceeded = "succeeded"
+	AsyncJobStatusFailed    = "failed"
+
+	// asyncJobKeyPrefix Redis 中保存任务的 key 前缀，多节点部署时可从任意节点轮询
+	asyncJobKeyPrefix = "async_relay_job:"
+	// asyncJobPruneInterval 清理内存中已过期任务的间隔
+	asyncJobPruneInterval = time.Minute
+)
+
+var ErrAsyncRelayQueueFull = errors.New("async job queue is full")
+
+// AsyncRelayJob 异步请求任务
+type AsyncRelayJob struct {
+	Id          string          `json:"id"`
+	Object      string          `json:"object"`
+	Status      string          `json:"status"`
+	Model       string          `json:"model"`
+	UserId      int             `json:"-"`
+	TokenId     int             `json:"-"`
+	CallbackUrl string          `json:"-"`
+	StatusCode  int             `json:"status_code,omitempty"` // 请求完成后上游转换结果的 HTTP 状态码
+	Result      json.RawMessage `json:"result,omitempty"`      // 与同步请求相同格式的响应体
+	CreatedAt   int64           `json:"created_at"`
+	CompletedAt int64           `json:"completed_at,omitempty"`
+	ExpiresAt   int64           `json:"-"`
+}
+
+// asyncRelayJobRecord Redis 中保存的任务记录，包含不对外返回的归属信息
+type asyncRelayJobRecord struct {
+	AsyncRelayJob
+	UserId  int `json:"user_id"`
+	TokenId int `json:"token_id"`
+}
+
+var (
+	asyncRelayJobs      = make(map[string]*AsyncRelayJob)
+	asyncRelayJobsMutex sync.RWMutex
+	asyncRelayQueue     chan func()
+	asyncRelayOnce      sync.Once
+)
+
+// IsAsyncRelayRequested 判断客户端是否请求以异步模式处理
+func IsAsyncRelayRequested(c *gin.Context) bool {
+	value := strings.ToLower(strings.TrimSpace(c.GetHeader(AsyncRelayHeader)))
+	return value == "true" || value == "1"
+}
+
+// ValidateAsyncRelayCallbackUrl 校验回调地址，与其他外部请求一样受 SSRF 防护配置约束
+func ValidateAsyncRelayCallbackUrl(callbackUrl string) error {
+	if callbackUrl == "" {
+		return nil
+	}
+	if !strings.HasPrefix(callbackUrl, "http://") && !strings.HasPrefix(callbackUrl, "https://") {
+		return errors.New("callback url must be http or https")
+	}
+	fetchSetting := system_setting.GetFetchSetting()
+	return common.ValidateURLWithFetchSetting(callbackUrl, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain)
+}
+
+// startAsyncRelayWorkers 按配置启动 worker，worker 数量与队列长度在首次使用时确定，修改后需重启生效
+func startAsyncRelayWorkers() {
+	asyncRelayOnce.Do(func() {
+		settings := model_setting.GetAsyncRelaySettings()
+		workers := settings.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		queueSize := settings.QueueSize
+		if queueSize <= 0 {
+			queueSize = 1
+		}
+		asyncRelayQueue = make(chan func(), queueSize)
+		for i := 0; i < workers; i++ {
+			go func() {
+				for task := range asyncRelayQueue {
+					task()
+				}
+			}()
+		}
+		go func() {
+			ticker := time.NewTicker(asyncJobPruneInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				pruneExpiredAsyncRelayJobs()
+			}
+		}()
+	})
+}
+
+// runAsyncRelayRequest 执行任务的请求，请求处理 panic 时返回 500 错误，避免 worker 退出导致进程崩溃、任务一直处于 running 状态
+func runAsyncRelayRequest(jobId string, run func() (int, []byte)) (statusCode int, body []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.SysError(fmt.Sprintf("async job %s panic: %v", jobId, r))
+			statusCode = http.StatusInternalServerError
+			body, _ = common.Marshal(gin.H{
+				"error": gin.H{
+					"message": "internal error while processing async job",
+					"type":    "new_api_error",
+				},
+			})
+		}
+	}()
+	return run()
+}
+
+// SubmitAsyncRelayJob 创建异步任务并放入队列
+// 参数:
+//   - job: 待提交的任务，需填写模型、归属用户与令牌以及回调地址
+//   - run: 执行请求的函数，返回响应的 HTTP 状态码与响应体
+//   - callbackSecret: 回调签名使用的密钥
+//
+// 返回:
+//   - error: 队列已满时返回 ErrAsyncRelayQueueFull
+func SubmitAsyncRelayJob(job *AsyncRelayJob, run func() (int, []byte), callbackSecret string) error {
+	startAsyncRelayWorkers()
+	now := time.Now()
+	job.Id = "job_" + common.GetUUID()
+	job.Object = "async_job"
+	job.Status = AsyncJobStatusQueued
+	job.CreatedAt = now.Unix()
+	job.ExpiresAt = now.Add(model_setting.GetAsyncRelaySettings().GetJobTTL()).Unix()
+
+	task := func() {
+		updateAsyncRelayJob(job.Id, func(j *AsyncRelayJob) {
+			j.Status = AsyncJobStatusRunning
+		})
+		statusCode, body := runAsyncRelayRequest(job.Id, run)
+		completed := updateAsyncRelayJob(job.Id, func(j *AsyncRelayJob) {
+			j.StatusCode = statusCode
+			if json.Valid(body) {
+				j.Result = body
+			} else {
+				j.Result, _ = common.Marshal(string(body))
+			}
+			if statusCode >= 200 && statusCode < 300 {
+				j.Status = AsyncJobStatusSucceeded
+			} else {
+				j.Status = AsyncJobStatusFailed
+			}
+			j.CompletedAt = time.Now().Unix()
+		})
+		if completed != nil && completed.CallbackUrl != "" {
+			if err := sendAsyncRelayCallback(completed, callbackSecret); err != nil {
+				common.SysLog(fmt.Sprintf("async job %s callback failed: %s", completed.Id, err.Error()))
+			}
+		}
+	}
+
+	saveAsyncRelayJob(job)
+	select {
+	case asyncRelayQueue <- task:
+		return nil
+	default:
+		deleteAsyncRelayJob(job.Id)
+		return ErrAsyncRelayQueueFull
+	}
+}
+
+// GetAsyncRelayJob 查询异步任务，只能查询同一用户提交的任务
+// 参数:
+//   - id: 任务 ID
+//   - userId: 查询者的用户 ID
+//
+// 返回:
+//   - *AsyncRelayJob: 任务，不存在、已过期或不属于该用户时返回 nil
+func GetAsyncRelayJob(id string, userId int) *AsyncRelayJob {
+	job := loadAsyncRelayJob(id)
+	if job == nil || job.UserId != userId {
+		return nil
+	}
+	return job
+}
+
+func updateAsyncRelayJob(id string, update func(job *AsyncRelayJob)) *AsyncRelayJob {
+	asyncRelayJobsMutex.Lock()
+	job, ok := asyncRelayJobs[id]
+	if !ok {
+		asyncRelayJobsMutex.Unlock()
+		return nil
+	}
+	update(job)
+	snapshot := *job
+	asyncRelayJobsMutex.Unlock()
+	if common.RedisEnabled {
+		saveAsyncRelayJobToRedis(&snapshot)
+	}
+	return &snapshot
+}
+
+func saveAsyncRelayJob(job *AsyncRelayJob) {
+	asyncRelayJobsMutex.Lock()
+	asyncRelayJobs[job.Id] = job
+	snapshot := *job
+	asyncRelayJobsMutex.Unlock()
+	if common.RedisEnabled {
+		saveAsyncRelayJobToRedis(&snapshot)
+	}
+}
+
+// pruneExpiredAsyncRelayJobs 清理内存中已过期的任务，Redis 中的任务按 TTL 自动过期
+func pruneExpiredAsyncRelayJobs() {
+	now := time.Now().Unix()
+	asyncRelayJobsMutex.Lock()
+	defer asyncRelayJobsMutex.Unlock()
+	for id, job := range asyncRelayJobs {
+		if job.ExpiresAt < now {
+			delete(asyncRelayJobs, id)
+		}
+	}
+}
+
+func deleteAsyncRelayJob(id string) {
+	asyncRelayJobsMutex.Lock()
+	delete(asyncRelayJobs, id)
+	asyncRelayJobsMutex.Unlock()
+	if common.RedisEnabled {
+		_ = common.RedisDel(asyncJobKeyPrefix + id)
+	}
+}
+
+func loadAsyncRelayJob(id string) *AsyncRelayJob {
+	asyncRelayJobsMutex.RLock()
+	job, ok := asyncRelayJobs[id]
+	var snapshot AsyncRelayJob
+	if ok {
+		snapshot = *job
+	}
+	asyncRelayJobsMutex.RUnlock()
+	if ok {
+		if snapshot.ExpiresAt < time.Now().Unix() {
+			return nil
+		}
+		return &snapshot
+	}
+	if !common.RedisEnabled {
+		return nil
+	}
+	data, err := common.RedisGet(asyncJobKeyPrefix + id)
+	if err != nil || data == "" {
+		return nil
+	}
+	var record asyncRelayJobRecord
+	if err := common.UnmarshalJsonStr(data, &record); err != nil {
+		return nil
+	}
+	record.AsyncRelayJob.UserId = record.UserId
+	record.AsyncRelayJob.TokenId = record.TokenId
+	return &record.AsyncRelayJob
+}
+
+func saveAsyncRelayJobToRedis(job *AsyncRelayJob) {
+	ttl := time.Until(time.Unix(job.ExpiresAt, 0))
+	if ttl <= 0 {
+		return
+	}
+	data, err := common.Marshal(asyncRelayJobRecord{AsyncRelayJob: *job, UserId: job.UserId, TokenId: job.TokenId})
+	if err != nil {
+		return
+	}
+	if err := common.RedisSet(asyncJobKeyPrefix+job.Id, string(data), ttl); err != nil {
+		common.SysLog(fmt.Sprintf("save async job %s to redis failed: %s", job.Id, err.Error()))
+	}
+}
+
+// sendAsyncRelayCallback 将任务结果推送到客户端的回调地址
+func sendAsyncRelayCallback(job *AsyncRelayJob, secret string) error {
+	if err := ValidateAsyncRelayCallbackUrl(job.CallbackUrl); err != nil {
+		return fmt.Errorf("request reject: %v", err)
+	}
+	payload, err := common.Marshal(job)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, job.CallbackUrl, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(AsyncRelaySignatureHeader, generateSignature(secret, payload))
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -44,6 +44,13 @@ func EnableChannel(channelId int, usingKey string, channelName string) {
 	}
 }
 
+// NotifyChannelLowBalance 渠道余额低于告警阈值时通知管理员
+func NotifyChannelLowBalance(channelId int, channelName string, balance float64, threshold float64) {
+	subject := fmt.Sprintf("通道「%s」（#%d）余额不足", channelName, channelId)
+	content := fmt.Sprintf("通道「%s」（#%d）当前余额 %.2f，已低于告警阈值 %.2f", channelName, channelId, balance, threshold)
+	NotifyRootUser(fmt.Sprintf("%s_%d", dto.NotifyTypeChannelBalance, channelId), subject, content)
+}
+
 func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
 	if !common.AutomaticDisableChannelEnabled {
 		return false
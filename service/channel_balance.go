@@ -0,0 +1,135 @@
+package service
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+var (
+	channelInFlight      = make(map[int]int)
+	channelInFlightMutex sync.Mutex
+)
+
+// IncreaseChannelInFlight 记录渠道开始处理一个请求
+func IncreaseChannelInFlight(channelId int) {
+	channelInFlightMutex.Lock()
+	defer channelInFlightMutex.Unlock()
+	channelInFlight[channelId]++
+}
+
+// DecreaseChannelInFlight 记录渠道结束处理一个请求
+func DecreaseChannelInFlight(channelId int) {
+	channelInFlightMutex.Lock()
+	defer channelInFlightMutex.Unlock()
+	if channelInFlight[channelId] <= 1 {
+		delete(channelInFlight, channelId)
+		return
+	}
+	channelInFlight[channelId]--
+}
+
+// GetChannelInFlight 获取渠道当前进行中的请求数
+func GetChannelInFlight(channelId int) int {
+	channelInFlightMutex.Lock()
+	defer channelInFlightMutex.Unlock()
+	return channelInFlight[channelId]
+}
+
+// selectChannelByStrategy 按模型配置的负载均衡策略选择渠道
+// 返回:
+//   - *model.Channel: 选中的渠道，没有可用渠道时为 nil
+//   - bool: 是否由策略处理，priority 策略返回 false，由原有的优先级选择逻辑处理
+//   - error: 读取渠道失败时返回错误
+//
+// 按得分选择的策略在重试时依次选择得分次优的渠道集合，weighted_random 策略每次重试重新按权重随机
+func selectChannelByStrategy(group string, modelName string, retry int) (*model.Channel, bool, error) {
+	strategy := model_setting.GetLoadBalanceSettings().GetStrategy(group, modelName)
+	if strategy == model_setting.LoadBalanceStrategyPriority {
+		return nil, false, nil
+	}
+	channels, err := model.GetSatisfiedChannels(group, modelName)
+	if err != nil || len(channels) == 0 {
+		return nil, true, err
+	}
+	return pickChannelByStrategy(strategy, channels, retry), true, nil
+}
+
+// pickChannelByStrategy 按非 priority 的负载均衡策略在候选渠道中选择，存在达标渠道时不选择违反 SLO 的渠道
+func pickChannelByStrategy(strategy string, channels []*model.Channel, retry int) *model.Channel {
+	return pickChannelByWeight(strategyCandidateChannels(strategy, channels, retry))
+}
+
+// strategyCandidateChannels 返回非 priority 策略第 retry 次尝试时参与按权重随机选择的渠道
+func strategyCandidateChannels(strategy string, channels []*model.Channel, retry int) []*model.Channel {
+	settings := model_setting.GetLoadBalanceSettings()
+	channels = preferSLOCompliantChannels(channels)
+	switch strategy {
+	case model_setting.LoadBalanceStrategyLeastConnections:
+		// 进行中请求数按权重折算，权重越高可承担的并发越多
+		channels = filterChannelsByScoreRank(channels, retry, func(channel *model.Channel) float64 {
+			return float64(GetChannelInFlight(channel.Id)) / float64(channel.GetWeight()+1)
+		})
+	case model_setting.LoadBalanceStrategyLowestErrorRate:
+		window := time.Duration(settings.ErrorRateWindowSeconds) * time.Second
+		if window <= 0 {
+			window = 5 * time.Minute
+		}
+		channels = filterChannelsByScoreRank(channels, retry, func(channel *model.Channel) float64 {
+			rate, _ := GetChannelErrorRate(channel.Id, window)
+			return rate
+		})
+	}
+	return channels
+}
+
+// filterChannelsByScoreRank 按得分从低到高分层，返回第 rank 层的渠道集合，rank 超出层数时返回得分最高的一层
+// 重试时传入重试次数，使每次重试选择得分次优的渠道，而不是重复选择已失败的渠道
+func filterChannelsByScoreRank(channels []*model.Channel, rank int, score func(*model.Channel) float64) []*model.Channel {
+	scores := make(map[int]float64, len(channels))
+	distinct := make([]float64, 0, len(channels))
+	for _, channel := range channels {
+		s := score(channel)
+		scores[channel.Id] = s
+		if !slices.Contains(distinct, s) {
+			distinct = append(distinct, s)
+		}
+	}
+	if len(distinct) == 0 {
+		return nil
+	}
+	slices.Sort(distinct)
+	if rank >= len(distinct) {
+		rank = len(distinct) - 1
+	}
+	var result []*model.Channel
+	for _, channel := range channels {
+		if scores[channel.Id] == distinct[rank] {
+			result = append(result, channel)
+		}
+	}
+	return result
+}
+
+// pickChannelByWeight 在渠道间按权重随机选择
+func pickChannelByWeight(channels []*model.Channel) *model.Channel {
+	if len(channels) == 0 {
+		return nil
+	}
+	sumWeight := 0
+	for _, channel := range channels {
+		sumWeight += channel.GetWeight() + 10
+	}
+	randomWeight := rand.Intn(sumWeight)
+	for _, channel := range channels {
+		randomWeight -= channel.GetWeight() + 10
+		if randomWeight < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
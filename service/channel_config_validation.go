@@ -0,0 +1,343 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+)
+
+// 渠道配置问题的严重程度
+const (
+	ChannelConfigIssueError   = "error"   // 会导致请求失败或被错误路由，报告整体视为未就绪
+	ChannelConfigIssueWarning = "warning" // 可能导致计费或路由不符合预期，需要管理员确认
+)
+
+// 渠道配置问题类型
+const (
+	ChannelConfigIssueInvalidBaseURL    = "invalid_base_url"                 // Base URL 无法解析或缺少协议、主机
+	ChannelConfigIssueBaseURLPath       = "base_url_path"                    // Base URL 包含接口路径，转发时路径会重复
+	ChannelConfigIssueModelCollision    = "model_collision"                  // 同一分组内同名模型被不同渠道映射到不同的上游模型
+	ChannelConfigIssueMissingPricing    = "missing_pricing"                  // 模型未设置倍率或价格
+	ChannelConfigIssueGroupModelMissing = "model_group_no_channel"           // 模型组引用的模型没有任何启用的渠道
+	ChannelConfigIssueGroupTypeMissing  = "model_group_no_channel_type"      // 模型组的优先渠道类型没有提供组内模型的渠道
+	ChannelConfigIssueGroupNoResponses  = "model_group_no_responses_channel" // 模型组开启智能路由但没有可用的 Responses 渠道
+)
+
+// ChannelConfigIssue 渠道配置检查发现的问题
+type ChannelConfigIssue struct {
+	Level       string `json:"level"`
+	Code        string `json:"code"`
+	ChannelId   int    `json:"channel_id,omitempty"`
+	ChannelName string `json:"channel_name,omitempty"`
+	ModelGroup  string `json:"model_group,omitempty"`
+	ModelName   string `json:"model_name,omitempty"`
+	Message     string `json:"message"`
+}
+
+// ChannelReadinessReport 渠道配置就绪报告，存在 error 级别问题时 Ready 为 false
+type ChannelReadinessReport struct {
+	Ready        bool                 `json:"ready"`
+	CheckedAt    int64                `json:"checked_at"`
+	ChannelCount int                  `json:"channel_count"` // 参与检查的 OpenAI Responses 与 Claude 渠道数
+	Errors       int                  `json:"errors"`
+	Warnings     int                  `json:"warnings"`
+	Issues       []ChannelConfigIssue `json:"issues"`
+}
+
+var (
+	channelReadinessReport      *ChannelReadinessReport
+	channelReadinessReportMutex sync.RWMutex
+)
+
+// isReadinessCheckedChannelType 判断渠道类型是否参与配置检查
+func isReadinessCheckedChannelType(channelType int) bool {
+	return channelType == constant.ChannelTypeOpenAIResponses || channelType == constant.ChannelTypeAnthropic
+}
+
+// ValidateChannelBaseURL 校验渠道 Base URL 的格式，仅检查 OpenAI Responses 与 Claude 渠道
+// 返回:
+//   - []ChannelConfigIssue: 发现的问题，error 级别的问题应阻止渠道保存
+func ValidateChannelBaseURL(channel *model.Channel) []ChannelConfigIssue {
+	if channel == nil || !isReadinessCheckedChannelType(channel.Type) || channel.BaseURL == nil || *channel.BaseURL == "" {
+		return nil
+	}
+	newIssue := func(level string, code string, message string) ChannelConfigIssue {
+		return ChannelConfigIssue{
+			Level:       level,
+			Code:        code,
+			ChannelId:   channel.Id,
+			ChannelName: channel.Name,
+			Message:     message,
+		}
+	}
+	baseURL := strings.TrimSpace(*channel.BaseURL)
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return []ChannelConfigIssue{newIssue(ChannelConfigIssueError, ChannelConfigIssueInvalidBaseURL, fmt.Sprintf("Base URL 无法解析: %s", err.Error()))}
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return []ChannelConfigIssue{newIssue(ChannelConfigIssueError, ChannelConfigIssueInvalidBaseURL, fmt.Sprintf("Base URL 必须以 http:// 或 https:// 开头: %s", baseURL))}
+	}
+	if parsed.Host == "" {
+		return []ChannelConfigIssue{newIssue(ChannelConfigIssueError, ChannelConfigIssueInvalidBaseURL, fmt.Sprintf("Base URL 缺少主机名: %s", baseURL))}
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return []ChannelConfigIssue{newIssue(ChannelConfigIssueError, ChannelConfigIssueInvalidBaseURL, fmt.Sprintf("Base URL 不能包含查询参数或片段: %s", baseURL))}
+	}
+	// 转发时会在 Base URL 后拼接 /v1/responses 或 /v1/messages
+	path := strings.TrimRight(parsed.Path, "/")
+	for _, suffix := range []string{"/v1", "/v1/responses", "/v1/messages", "/v1/complete"} {
+		if strings.HasSuffix(path, suffix) {
+			return []ChannelConfigIssue{newIssue(ChannelConfigIssueWarning, ChannelConfigIssueBaseURLPath, fmt.Sprintf("Base URL 以 %s 结尾，转发时接口路径会重复", suffix))}
+		}
+	}
+	return nil
+}
+
+// ValidateChannelConfig 保存渠道前校验渠道配置
+// 返回:
+//   - error: 存在会导致请求失败的配置问题时返回第一个问题
+func ValidateChannelConfig(channel *model.Channel) error {
+	for _, issue := range ValidateChannelBaseURL(channel) {
+		if issue.Level == ChannelConfigIssueError {
+			return fmt.Errorf("%s", issue.Message)
+		}
+	}
+	return nil
+}
+
+// getChannelUpstreamModel 按渠道的模型映射获取模型实际请求的上游模型名，映射格式错误时返回原名
+func getChannelUpstreamModel(mapping map[string]string, modelName string) string {
+	if upstream, ok := mapping[modelName]; ok && upstream != "" {
+		return upstream
+	}
+	return modelName
+}
+
+// BuildChannelReadinessReport 检查全部启用的 OpenAI Responses 与 Claude 渠道配置以及模型组路由规则，生成就绪报告
+func BuildChannelReadinessReport() (*ChannelReadinessReport, error) {
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		return nil, err
+	}
+	report := &ChannelReadinessReport{
+		CheckedAt: time.Now().Unix(),
+		Issues:    make([]ChannelConfigIssue, 0),
+	}
+
+	enabledChannels := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Status == common.ChannelStatusEnabled {
+			enabledChannels = append(enabledChannels, channel)
+		}
+	}
+	sort.Slice(enabledChannels, func(i, j int) bool {
+		return enabledChannels[i].Id < enabledChannels[j].Id
+	})
+
+	type modelOwner struct {
+		channel  *model.Channel
+		upstream string
+	}
+	// key 为 分组:模型名
+	owners := make(map[string][]modelOwner)
+	pricingChecked := make(map[string]bool)
+	for _, channel := range enabledChannels {
+		if !isReadinessCheckedChannelType(channel.Type) {
+			continue
+		}
+		report.ChannelCount++
+		report.Issues = append(report.Issues, ValidateChannelBaseURL(channel)...)
+
+		mapping := make(map[string]string)
+		if modelMapping := channel.GetModelMapping(); modelMapping != "" && modelMapping != "{}" {
+			_ = common.Unmarshal([]byte(modelMapping), &mapping)
+		}
+		for _, modelName := range channel.GetModels() {
+			modelName = strings.TrimSpace(modelName)
+			if modelName == "" {
+				continue
+			}
+			for _, group := range channel.GetGroups() {
+				key := group + ":" + modelName
+				owners[key] = append(owners[key], modelOwner{channel: channel, upstream: getChannelUpstreamModel(mapping, modelName)})
+			}
+			if operation_setting.SelfUseModeEnabled || pricingChecked[modelName] {
+				continue
+			}
+			pricingChecked[modelName] = true
+			if _, _, exist := ratio_setting.GetModelRatioOrPrice(modelName); !exist {
+				report.Issues = append(report.Issues, ChannelConfigIssue{
+					Level:       ChannelConfigIssueWarning,
+					Code:        ChannelConfigIssueMissingPricing,
+					ChannelId:   channel.Id,
+					ChannelName: channel.Name,
+					ModelName:   modelName,
+					Message:     fmt.Sprintf("模型 %s 未设置倍率或价格，未开启“接受未设置价格模型”的用户请求将失败", modelName),
+				})
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(owners))
+	for key := range owners {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		list := owners[key]
+		upstreams := make(map[string][]string)
+		for _, owner := range list {
+			upstreams[owner.upstream] = append(upstreams[owner.upstream], fmt.Sprintf("#%d", owner.channel.Id))
+		}
+		if len(upstreams) < 2 {
+			continue
+		}
+		parts := make([]string, 0, len(upstreams))
+		for upstream, ids := range upstreams {
+			parts = append(parts, fmt.Sprintf("%s(%s)", upstream, strings.Join(ids, ",")))
+		}
+		sort.Strings(parts)
+		group, modelName, _ := strings.Cut(key, ":")
+		report.Issues = append(report.Issues, ChannelConfigIssue{
+			Level:     ChannelConfigIssueWarning,
+			Code:      ChannelConfigIssueModelCollision,
+			ModelName: modelName,
+			Message:   fmt.Sprintf("分组 %s 中的模型 %s 被不同渠道映射到不同的上游模型: %s", group, modelName, strings.Join(parts, ", ")),
+		})
+	}
+
+	report.Issues = append(report.Issues, validateModelGroupRouting(enabledChannels)...)
+
+	for _, issue := range report.Issues {
+		if issue.Level == ChannelConfigIssueError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	report.Ready = report.Errors == 0
+	return report, nil
+}
+
+// validateModelGroupRouting 检查模型组的路由规则是否引用了不存在的渠道
+func validateModelGroupRouting(enabledChannels []*model.Channel) []ChannelConfigIssue {
+	issues := make([]ChannelConfigIssue, 0)
+	// 模型名 -> 提供该模型的启用渠道类型
+	modelChannelTypes := make(map[string]map[int]bool)
+	for _, channel := range enabledChannels {
+		for _, modelName := range channel.GetModels() {
+			modelName = strings.TrimSpace(modelName)
+			if modelChannelTypes[modelName] == nil {
+				modelChannelTypes[modelName] = make(map[int]bool)
+			}
+			modelChannelTypes[modelName][channel.Type] = true
+		}
+	}
+
+	groups := model_setting.GetModelGroupSettings().Groups
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, groupName := range groupNames {
+		policy := groups[groupName]
+		if len(policy.Models) == 0 {
+			continue
+		}
+		availableTypes := make(map[int]bool)
+		missing := make([]string, 0)
+		for _, modelName := range policy.Models {
+			channelTypes, ok := modelChannelTypes[modelName]
+			if !ok {
+				missing = append(missing, modelName)
+				continue
+			}
+			for channelType := range channelTypes {
+				availableTypes[channelType] = true
+			}
+		}
+		if len(missing) == len(policy.Models) {
+			issues = append(issues, ChannelConfigIssue{
+				Level:      ChannelConfigIssueError,
+				Code:       ChannelConfigIssueGroupModelMissing,
+				ModelGroup: groupName,
+				Message:    fmt.Sprintf("模型组 %s 的所有模型均没有启用的渠道: %s", groupName, strings.Join(missing, ", ")),
+			})
+			continue
+		}
+		for _, modelName := range missing {
+			issues = append(issues, ChannelConfigIssue{
+				Level:      ChannelConfigIssueWarning,
+				Code:       ChannelConfigIssueGroupModelMissing,
+				ModelGroup: groupName,
+				ModelName:  modelName,
+				Message:    fmt.Sprintf("模型组 %s 引用的模型 %s 没有启用的渠道，回退时将被跳过", groupName, modelName),
+			})
+		}
+		for _, channelType := range policy.PreferredChannelTypes {
+			if availableTypes[channelType] {
+				continue
+			}
+			issues = append(issues, ChannelConfigIssue{
+				Level:      ChannelConfigIssueWarning,
+				Code:       ChannelConfigIssueGroupTypeMissing,
+				ModelGroup: groupName,
+				Message:    fmt.Sprintf("模型组 %s 的优先渠道类型 %s 没有提供组内模型的启用渠道", groupName, constant.GetChannelTypeName(channelType)),
+			})
+		}
+		if policy.SmartRoutingEnabled && !availableTypes[constant.ChannelTypeOpenAIResponses] {
+			issues = append(issues, ChannelConfigIssue{
+				Level:      ChannelConfigIssueWarning,
+				Code:       ChannelConfigIssueGroupNoResponses,
+				ModelGroup: groupName,
+				Message:    fmt.Sprintf("模型组 %s 开启了智能路由，但组内模型没有启用的 OpenAI Responses 渠道", groupName),
+			})
+		}
+	}
+	return issues
+}
+
+// RefreshChannelReadinessReport 重新生成渠道就绪报告并记录发现的问题，在启动及渠道保存后调用
+func RefreshChannelReadinessReport() (*ChannelReadinessReport, error) {
+	report, err := BuildChannelReadinessReport()
+	if err != nil {
+		common.SysError("failed to build channel readiness report: " + err.Error())
+		return nil, err
+	}
+	channelReadinessReportMutex.Lock()
+	channelReadinessReport = report
+	channelReadinessReportMutex.Unlock()
+
+	for _, issue := range report.Issues {
+		if issue.Level == ChannelConfigIssueError {
+			common.SysError(fmt.Sprintf("channel readiness: [%s] %s", issue.Code, issue.Message))
+		}
+	}
+	if report.Errors > 0 || report.Warnings > 0 {
+		common.SysLog(fmt.Sprintf("channel readiness: %d errors, %d warnings across %d channels", report.Errors, report.Warnings, report.ChannelCount))
+	}
+	return report, nil
+}
+
+// GetChannelReadinessReport 获取最近一次生成的渠道就绪报告，尚未生成时立即生成
+func GetChannelReadinessReport() (*ChannelReadinessReport, error) {
+	channelReadinessReportMutex.RLock()
+	report := channelReadinessReport
+	channelReadinessReportMutex.RUnlock()
+	if report != nil {
+		return report, nil
+	}
+	return RefreshChannelReadinessReport()
+}
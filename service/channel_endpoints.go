@@ -0,0 +1,150 @@
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChannelTypeEndpointSupport 按渠道类型对应适配器的能力矩阵获取各接口是否支持
+// 返回:
+//   - map[string]bool: 能力矩阵覆盖的接口及是否支持，未覆盖的接口不限制
+//   - bool: 渠道类型没有对应的适配器时返回 false，不限制接口
+type ChannelTypeEndpointSupport func(channelType int) (map[string]bool, bool)
+
+var channelTypeEndpointSupport ChannelTypeEndpointSupport
+
+// RegisterChannelTypeEndpointSupport 注册渠道类型的接口能力查询，由 relay 包在初始化时注册，未注册时不按接口筛选渠道
+func RegisterChannelTypeEndpointSupport(support ChannelTypeEndpointSupport) {
+	channelTypeEndpointSupport = support
+}
+
+// EndpointUnsupportedError 分组下支持该模型的渠道均无法处理请求的接口
+type EndpointUnsupportedError struct {
+	Model              string
+	Endpoint           string
+	SupportedEndpoints []string
+}
+
+func (e *EndpointUnsupportedError) Error() string {
+	return e.LocalizedError().Error()
+}
+
+// LocalizedError 返回可本地化的错误信息
+func (e *EndpointUnsupportedError) LocalizedError() *types.LocalizedError {
+	return types.NewLocalizedError(types.ErrMsgEndpointNoSupportedChannel, e.Model, e.Endpoint, strings.Join(e.SupportedEndpoints, ", "))
+}
+
+// GetChannelTypeSupportedEndpoints 获取渠道类型支持的接口，没有对应适配器的渠道类型返回 nil
+func GetChannelTypeSupportedEndpoints(channelType int) []string {
+	if channelTypeEndpointSupport == nil {
+		return nil
+	}
+	support, ok := channelTypeEndpointSupport(channelType)
+	if !ok {
+		return nil
+	}
+	endpoints := make([]string, 0, len(support))
+	for endpoint, supported := range support {
+		if supported {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// IsChannelTypeEndpointSupported 判断渠道类型能否处理指定接口，无法识别或能力矩阵未覆盖的接口不限制
+func IsChannelTypeEndpointSupported(channelType int, endpoint string) bool {
+	if endpoint == "" || channelTypeEndpointSupport == nil {
+		return true
+	}
+	support, ok := channelTypeEndpointSupport(channelType)
+	if !ok {
+		return true
+	}
+	supported, covered := support[endpoint]
+	return !covered || supported
+}
+
+// GetRequestEndpoint 按请求路径获取渠道接口，用于选择渠道时排除无法处理该接口的渠道类型
+func GetRequestEndpoint(c *gin.Context) string {
+	if c.Request == nil || c.Request.URL == nil {
+		return ""
+	}
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/v1/messages") {
+		return dto.ChannelEndpointClaudeMessages
+	}
+	return GetRelayEndpoint(types.RelayFormatOpenAI, relayconstant.Path2RelayMode(path))
+}
+
+// filterEndpointSupportedChannels 筛选能处理指定接口的渠道
+// 返回:
+//   - []*model.Channel: 能处理该接口的渠道
+//   - *EndpointUnsupportedError: 存在渠道但均无法处理该接口时返回，列出这些渠道支持的接口
+func filterEndpointSupportedChannels(channels []*model.Channel, modelName string, endpoint string) ([]*model.Channel, *EndpointUnsupportedError) {
+	supported := make([]*model.Channel, 0, len(channels))
+	var supportedEndpoints []string
+	for _, channel := range channels {
+		if IsChannelTypeEndpointSupported(channel.Type, endpoint) {
+			supported = append(supported, channel)
+			continue
+		}
+		for _, e := range GetChannelTypeSupportedEndpoints(channel.Type) {
+			if !common.StringsContains(supportedEndpoints, e) {
+				supportedEndpoints = append(supportedEndpoints, e)
+			}
+		}
+	}
+	if len(supported) == 0 && len(channels) > 0 {
+		return nil, &EndpointUnsupportedError{Model: modelName, Endpoint: endpoint, SupportedEndpoints: supportedEndpoints}
+	}
+	return supported, nil
+}
+
+// GetRelayEndpoint 获取请求对应的渠道接口，用于渠道允许接口的校验
+// 参数:
+//   - relayFormat: 客户端请求格式
+//   - relayMode: 请求模式
+//
+// 返回:
+//   - string: 渠道接口，无法识别时返回空字符串
+func GetRelayEndpoint(relayFormat types.RelayFormat, relayMode int) string {
+	switch relayFormat {
+	case types.RelayFormatClaude:
+		return dto.ChannelEndpointClaudeMessages
+	case types.RelayFormatGemini:
+		return dto.ChannelEndpointGemini
+	}
+	switch relayMode {
+	case relayconstant.RelayModeChatCompletions:
+		return dto.ChannelEndpointChatCompletions
+	case relayconstant.RelayModeCompletions:
+		return dto.ChannelEndpointCompletions
+	case relayconstant.RelayModeResponses:
+		return dto.ChannelEndpointResponses
+	case relayconstant.RelayModeEmbeddings:
+		return dto.ChannelEndpointEmbeddings
+	case relayconstant.RelayModeModerations:
+		return dto.ChannelEndpointModerations
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeEdits:
+		return dto.ChannelEndpointImages
+	case relayconstant.RelayModeAudioSpeech, relayconstant.RelayModeAudioTranscription, relayconstant.RelayModeAudioTranslation:
+		return dto.ChannelEndpointAudio
+	case relayconstant.RelayModeRerank:
+		return dto.ChannelEndpointRerank
+	case relayconstant.RelayModeRealtime:
+		return dto.ChannelEndpointRealtime
+	case relayconstant.RelayModeGemini:
+		return dto.ChannelEndpointGemini
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/types"
+)
+
+const (
+	// channelHealthBucketSeconds 渠道健康统计的时间桶粒度
+	channelHealthBucketSeconds = 60
+	// channelHealthRetention 渠道健康统计保留的时长
+	channelHealthRetention = 24 * time.Hour
+)
+
+// ChannelHealthBucket 单个渠道在一个时间桶内的请求结果统计
+type ChannelHealthBucket struct {
+	Timestamp      int64          `json:"timestamp"`
+	Requests       int            `json:"requests"`
+	Errors         int            `json:"errors"`
+	UpstreamErrors int            `json:"upstream_errors"` // 上游服务商导致的错误
+	GatewayErrors  int            `json:"gateway_errors"`  // 网关自身导致的错误
+	StreamAborts   int            `json:"stream_aborts"`   // 流式响应中途中断（超时或读取失败）
+	ErrorCodes     map[string]int `json:"error_codes"`
+}
+
+var (
+	channelHealthBuckets = make(map[int]map[int64]*ChannelHealthBucket)
+	channelHealthMutex   sync.Mutex
+)
+
+// upstreamErrorCodes 由上游服务商导致的 new api 错误码
+var upstreamErrorCodes = map[types.ErrorCode]bool{
+	types.ErrorCodeDoRequestFailed:        true,
+	types.ErrorCodeReadResponseBodyFailed: true,
+	types.ErrorCodeBadResponseStatusCode:  true,
+	types.ErrorCodeBadResponse:            true,
+	types.ErrorCodeBadResponseBody:        true,
+	types.ErrorCodeEmptyResponse:          true,
+	types.ErrorCodeAwsInvokeError:         true,
+}
+
+// IsUpstreamError 判断错误是否由上游服务商导致
+func IsUpstreamError(err *types.NewAPIError) bool {
+	if err == nil || types.IsConvertError(err) {
+		return false
+	}
+	if err.GetErrorType() != types.ErrorTypeNewAPIError || types.IsChannelError(err) {
+		return true
+	}
+	return upstreamErrorCodes[err.GetErrorCode()]
+}
+
+// RecordChannelHealth 记录渠道的一次请求结果
+func RecordChannelHealth(channelId int, err *types.NewAPIError, streamAborted bool) {
+	if channelId == 0 {
+		return
+	}
+	now := time.Now()
+	timestamp := now.Unix() - now.Unix()%channelHealthBucketSeconds
+
+	channelHealthMutex.Lock()
+	defer channelHealthMutex.Unlock()
+	buckets, ok := channelHealthBuckets[channelId]
+	if !ok {
+		buckets = make(map[int64]*ChannelHealthBucket)
+		channelHealthBuckets[channelId] = buckets
+	}
+	bucket, ok := buckets[timestamp]
+	if !ok {
+		bucket = &ChannelHealthBucket{Timestamp: timestamp, ErrorCodes: make(map[string]int)}
+		buckets[timestamp] = bucket
+		expireBefore := now.Add(-channelHealthRetention).Unix()
+		for ts := range buckets {
+			if ts < expireBefore {
+				delete(buckets, ts)
+			}
+		}
+	}
+
+	bucket.Requests++
+	if streamAborted {
+		bucket.StreamAborts++
+	}
+	if err != nil {
+		bucket.Errors++
+		bucket.ErrorCodes[string(err.GetErrorCode())]++
+		if IsUpstreamError(err) {
+			bucket.UpstreamErrors++
+		} else {
+			bucket.GatewayErrors++
+		}
+	}
+}
+
+// GetChannelHealthSeries 获取渠道在指定时间之后的健康统计序列，channelId 为 0 时返回全部渠道
+// bucketSeconds 为聚合粒度，会向上取整为 60 秒的整数倍
+func GetChannelHealthSeries(channelId int, since int64, bucketSeconds int64) map[int][]ChannelHealthBucket {
+	if bucketSeconds < channelHealthBucketSeconds {
+		bucketSeconds = channelHealthBucketSeconds
+	}
+	bucketSeconds = (bucketSeconds + channelHealthBucketSeconds - 1) / channelHealthBucketSeconds * channelHealthBucketSeconds
+
+	channelHealthMutex.Lock()
+	defer channelHealthMutex.Unlock()
+	result := make(map[int][]ChannelHealthBucket)
+	for id, buckets := range channelHealthBuckets {
+		if channelId != 0 && id != channelId {
+			continue
+		}
+		merged := make(map[int64]*ChannelHealthBucket)
+		for ts, bucket := range buckets {
+			if ts < since {
+				continue
+			}
+			key := ts - ts%bucketSeconds
+			target, ok := merged[key]
+			if !ok {
+				target = &ChannelHealthBucket{Timestamp: key, ErrorCodes: make(map[string]int)}
+				merged[key] = target
+			}
+			target.Requests += bucket.Requests
+			target.Errors += bucket.Errors
+			target.UpstreamErrors += bucket.UpstreamErrors
+			target.GatewayErrors += bucket.GatewayErrors
+			target.StreamAborts += bucket.StreamAborts
+			for code, count := range bucket.ErrorCodes {
+				target.ErrorCodes[code] += count
+			}
+		}
+		series := make([]ChannelHealthBucket, 0, len(merged))
+		for _, bucket := range merged {
+			series = append(series, *bucket)
+		}
+		sort.Slice(series, func(i, j int) bool {
+			return series[i].Timestamp < series[j].Timestamp
+		})
+		if len(series) > 0 {
+			result[id] = series
+		}
+	}
+	return result
+}
+
+// GetChannelErrorRate 获取渠道在最近 window 时长内的错误率及请求数，无请求时错误率为 0
+func GetChannelErrorRate(channelId int, window time.Duration) (float64, int) {
+	since := time.Now().Add(-window).Unix()
+	channelHealthMutex.Lock()
+	defer channelHealthMutex.Unlock()
+	requests, errorCount := 0, 0
+	for ts, bucket := range channelHealthBuckets[channelId] {
+		if ts+channelHealthBucketSeconds <= since {
+			continue
+		}
+		requests += bucket.Requests
+		errorCount += bucket.Errors
+	}
+	if requests == 0 {
+		return 0, 0
+	}
+	return float64(errorCount) / float64(requests), requests
+}
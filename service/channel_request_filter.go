@@ -0,0 +1,81 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelRequestFilter 按请求内容排除无法处理该请求的渠道，在选择渠道时完成筛选，不消耗重试次数
+type channelRequestFilter struct {
+	c           *gin.Context
+	endpoint    string
+	relayFormat types.RelayFormat
+	// Claude 请求中只有原生 Claude 渠道能保留的内容块，首次遇到 Responses 渠道时才解析请求体
+	nativeOnlyBlock       string
+	nativeOnlyBlockParsed bool
+}
+
+// newChannelRequestFilter 按请求路径构建渠道筛选条件
+func newChannelRequestFilter(c *gin.Context) *channelRequestFilter {
+	endpoint := GetRequestEndpoint(c)
+	return &channelRequestFilter{
+		c:           c,
+		endpoint:    endpoint,
+		relayFormat: getEndpointRelayFormat(endpoint),
+	}
+}
+
+// accepts 判断渠道能否处理该请求
+// 包括渠道类型的适配器能否处理请求接口、渠道配置的允许接口是否包含请求接口、需要的转换方向是否已被关闭，
+// 以及 Claude 请求是否只能由原生 Claude 渠道处理
+func (f *channelRequestFilter) accepts(channel *model.Channel) bool {
+	if !IsChannelTypeEndpointSupported(channel.Type, f.endpoint) {
+		return false
+	}
+	if !channel.IsEndpointAllowed(f.endpoint) {
+		return false
+	}
+	if _, disabled := IsChannelConversionDisabled(channel.Type, f.relayFormat); disabled {
+		return false
+	}
+	if f.relayFormat == types.RelayFormatClaude && channel.Type == constant.ChannelTypeOpenAIResponses {
+		if ShouldRouteClaudeBetaNatively(f.c, channel.Type) || f.claudeNativeOnlyBlock() != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// claudeNativeOnlyBlock 获取 Claude 请求中只有原生 Claude 渠道能保留的内容块类型，请求体只解析一次，解析失败时不排除渠道
+func (f *channelRequestFilter) claudeNativeOnlyBlock() string {
+	if f.nativeOnlyBlockParsed {
+		return f.nativeOnlyBlock
+	}
+	f.nativeOnlyBlockParsed = true
+	var request dto.ClaudeRequest
+	if err := common.UnmarshalBodyReusable(f.c, &request); err != nil {
+		return ""
+	}
+	f.nativeOnlyBlock = FindClaudeNativeOnlyBlock(&request)
+	return f.nativeOnlyBlock
+}
+
+// getEndpointRelayFormat 获取渠道接口对应的客户端请求格式，仅区分会经过格式转换的接口，其他接口返回空字符串
+func getEndpointRelayFormat(endpoint string) types.RelayFormat {
+	switch endpoint {
+	case dto.ChannelEndpointChatCompletions, dto.ChannelEndpointCompletions:
+		return types.RelayFormatOpenAI
+	case dto.ChannelEndpointResponses:
+		return types.RelayFormatOpenAIResponses
+	case dto.ChannelEndpointClaudeMessages:
+		return types.RelayFormatClaude
+	case dto.ChannelEndpointGemini:
+		return types.RelayFormatGemini
+	}
+	return ""
+}
@@ -8,22 +8,41 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/gin-gonic/gin"
 )
 
+// GetBoundChannelGroup 获取当前请求的用户或令牌绑定的渠道分组，存在绑定时渠道只能从该分组中选择
+func GetBoundChannelGroup(c *gin.Context) (string, bool) {
+	return model_setting.GetBoundChannelGroup(c.GetInt("id"), c.GetInt("token_id"))
+}
+
 func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, modelName string, retry int) (*model.Channel, string, error) {
 	var channel *model.Channel
 	var err error
+	filter := newChannelRequestFilter(c)
+	experimentChannelType := GetExperimentChannelType(c)
+	// 绑定了渠道分组时只在绑定的分组内选择，不使用自动分组
+	if boundGroup, ok := GetBoundChannelGroup(c); ok {
+		channel, err = getRandomSatisfiedChannel(boundGroup, modelName, filter, experimentChannelType, retry)
+		if channel != nil && group == "auto" {
+			// 自动分组请求按实际选择的绑定分组计费
+			c.Set("auto_group", boundGroup)
+		}
+		return channel, boundGroup, err
+	}
 	selectGroup := group
 	userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
 	if group == "auto" {
 		if len(setting.GetAutoGroups()) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
 		}
+		var unsupportedErr *EndpointUnsupportedError
 		for _, autoGroup := range GetUserAutoGroup(userGroup) {
 			logger.LogDebug(c, "Auto selecting group:", autoGroup)
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, modelName, retry)
+			channel, err = getRandomSatisfiedChannel(autoGroup, modelName, filter, experimentChannelType, retry)
 			if channel == nil {
+				errors.As(err, &unsupportedErr)
 				continue
 			} else {
 				c.Set("auto_group", autoGroup)
@@ -32,11 +51,106 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, modelName stri
 				break
 			}
 		}
+		if channel == nil && unsupportedErr != nil {
+			// 自动分组中只有无法处理该接口的渠道
+			return nil, selectGroup, unsupportedErr
+		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(group, modelName, retry)
+		channel, err = getRandomSatisfiedChannel(group, modelName, filter, experimentChannelType, retry)
 		if err != nil {
 			return nil, group, err
 		}
 	}
 	return channel, selectGroup, nil
 }
+
+// getRandomSatisfiedChannel 按模型配置的负载均衡策略选择渠道，priority 策略沿用按优先级分层的选择逻辑，
+// 违反 SLO 的渠道排在达标渠道之后。无法处理该请求的渠道不参与选择，A/B 实验分组指定的渠道类型优先
+func getRandomSatisfiedChannel(group string, modelName string, filter *channelRequestFilter, experimentChannelType int, retry int) (*model.Channel, error) {
+	channel, handled, err := selectChannelByExperiment(group, modelName, filter, experimentChannelType, retry)
+	if handled {
+		return channel, err
+	}
+	channel, handled, err = selectChannelByRequest(group, modelName, filter, retry)
+	if handled {
+		return channel, err
+	}
+	channel, handled, err = selectChannelByStrategy(group, modelName, retry)
+	if handled {
+		return channel, err
+	}
+	channel, handled, err = selectChannelBySLO(group, modelName, retry)
+	if handled {
+		return channel, err
+	}
+	return model.GetRandomSatisfiedChannel(group, modelName, retry)
+}
+
+// selectChannelByRequest 分组中存在无法处理该请求的渠道时，只在能处理的渠道中按负载均衡策略与 SLO 选择
+// 返回:
+//   - *model.Channel: 选中的渠道，没有能处理该请求的渠道时为 nil
+//   - bool: 是否已处理，所有渠道都能处理该请求时返回 false，由负载均衡策略选择
+//   - error: 存在渠道但渠道类型均无法处理该接口时返回 *EndpointUnsupportedError
+func selectChannelByRequest(group string, modelName string, filter *channelRequestFilter, retry int) (*model.Channel, bool, error) {
+	channels, err := model.GetSatisfiedChannels(group, modelName)
+	if err != nil {
+		return nil, true, err
+	}
+	if _, unsupportedErr := filterEndpointSupportedChannels(channels, modelName, filter.endpoint); unsupportedErr != nil {
+		return nil, true, unsupportedErr
+	}
+	accepted := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if filter.accepts(channel) {
+			accepted = append(accepted, channel)
+		}
+	}
+	if len(accepted) == len(channels) {
+		return nil, false, nil
+	}
+	return selectChannelFromCandidates(group, modelName, accepted, retry), true, nil
+}
+
+// selectChannelByExperiment 请求所在 A/B 实验分组指定了渠道类型时，优先在该类型且能处理该请求的渠道中按负载均衡策略与 SLO 选择
+// 返回:
+//   - *model.Channel: 选中的渠道
+//   - bool: 是否已处理，未指定渠道类型或分组中没有该类型的可用渠道时返回 false，由后续逻辑在所有渠道中选择
+//   - error: 查询渠道失败时返回
+func selectChannelByExperiment(group string, modelName string, filter *channelRequestFilter, channelType int, retry int) (*model.Channel, bool, error) {
+	if channelType == 0 {
+		return nil, false, nil
+	}
+	channels, err := model.GetSatisfiedChannels(group, modelName)
+	if err != nil {
+		return nil, true, err
+	}
+	preferred := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Type == channelType && filter.accepts(channel) {
+			preferred = append(preferred, channel)
+		}
+	}
+	if len(preferred) == 0 {
+		return nil, false, nil
+	}
+	return selectChannelFromCandidates(group, modelName, preferred, retry), true, nil
+}
+
+// selectChannelFromCandidates 在筛选后的候选渠道中按与完整分组相同的规则选择：非 priority 策略按策略选择，
+// priority 策略按优先级分层，违反 SLO 的渠道排在达标渠道之后
+func selectChannelFromCandidates(group string, modelName string, channels []*model.Channel, retry int) *model.Channel {
+	return pickChannelByWeight(candidateChannelsForAttempt(group, modelName, channels, retry))
+}
+
+// candidateChannelsForAttempt 返回第 retry 次尝试时参与按权重随机选择的渠道，路由模拟使用同一规则计算候选渠道
+func candidateChannelsForAttempt(group string, modelName string, channels []*model.Channel, retry int) []*model.Channel {
+	if strategy := model_setting.GetLoadBalanceSettings().GetStrategy(group, modelName); strategy != model_setting.LoadBalanceStrategyPriority {
+		return strategyCandidateChannels(strategy, channels, retry)
+	}
+	if hasChannelSLOBreaches() {
+		if healthy, breaching := splitChannelsBySLO(channels); len(healthy) > 0 && len(breaching) > 0 {
+			return sloTierCandidateChannels(healthy, breaching, retry)
+		}
+	}
+	return channelsAtPriorityRank(channels, retry)
+}
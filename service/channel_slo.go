@@ -0,0 +1,279 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+const (
+	// channelLatencySampleLimit 每个渠道保留的首字延迟样本上限
+	channelLatencySampleLimit = 1000
+	// channelSLOEvaluateInterval 同一渠道两次 SLO 评估的最小间隔
+	channelSLOEvaluateInterval = 10 * time.Second
+)
+
+// ChannelSLOStatus 渠道在统计窗口内的 SLO 达标情况
+type ChannelSLOStatus struct {
+	ChannelId          int            `json:"channel_id"`
+	ChannelName        string         `json:"channel_name"`
+	Objective          dto.ChannelSLO `json:"objective"`
+	WindowSeconds      int            `json:"window_seconds"`
+	Requests           int            `json:"requests"`
+	ErrorRatePercent   float64        `json:"error_rate_percent"`
+	LatencySamples     int            `json:"latency_samples"`
+	FirstTokenP95Ms    int64          `json:"first_token_p95_ms"`
+	ErrorRateBreached  bool           `json:"error_rate_breached"`
+	FirstTokenBreached bool           `json:"first_token_breached"`
+	Breaching          bool           `json:"breaching"`
+	BreachingSince     int64          `json:"breaching_since,omitempty"` // 开始违反 SLO 的时间戳（秒）
+	EvaluatedAt        int64          `json:"evaluated_at"`
+}
+
+type channelLatencySample struct {
+	timestamp int64
+	latencyMs int64
+}
+
+var (
+	channelLatencySamples = make(map[int][]channelLatencySample)
+	// channelSLOBreaches 当前违反 SLO 的渠道及开始违反的时间
+	channelSLOBreaches    = make(map[int]int64)
+	channelSLOEvaluatedAt = make(map[int]time.Time)
+	channelSLOMutex       sync.Mutex
+	// channelSLOObjectives 已解析的渠道服务目标，渠道设置变化时重新解析
+	channelSLOObjectives = make(map[int]channelSLOObjective)
+)
+
+type channelSLOObjective struct {
+	otherSettings string
+	slo           *dto.ChannelSLO
+}
+
+// getChannelSLO 获取渠道配置的服务目标，按渠道设置原文缓存解析结果，避免每次请求都解析设置 JSON
+func getChannelSLO(channel *model.Channel) *dto.ChannelSLO {
+	channelSLOMutex.Lock()
+	cached, ok := channelSLOObjectives[channel.Id]
+	channelSLOMutex.Unlock()
+	if ok && cached.otherSettings == channel.OtherSettings {
+		return cached.slo
+	}
+	slo := channel.GetOtherSettings().SLO
+	channelSLOMutex.Lock()
+	channelSLOObjectives[channel.Id] = channelSLOObjective{otherSettings: channel.OtherSettings, slo: slo}
+	channelSLOMutex.Unlock()
+	return slo
+}
+
+// ObserveChannelSLO 记录渠道一次请求的结果并重新评估 SLO，成功的请求记录首字延迟
+// 参数:
+//   - channel: 处理请求的渠道
+//   - err: 请求错误，成功时为 nil
+//   - attemptStart: 本次尝试开始的时间
+//   - firstResponse: 首次向客户端返回数据的时间，早于 attemptStart 时视为本次尝试未返回数据
+func ObserveChannelSLO(channel *model.Channel, err *types.NewAPIError, attemptStart time.Time, firstResponse time.Time) {
+	if channel == nil || !model_setting.GetChannelSLOSettings().Enabled {
+		return
+	}
+	if err == nil {
+		latency := time.Since(attemptStart)
+		if firstResponse.After(attemptStart) {
+			latency = firstResponse.Sub(attemptStart)
+		}
+		recordChannelLatency(channel.Id, latency.Milliseconds())
+	}
+	if !getChannelSLO(channel).IsSet() && !isChannelSLOTracked(channel.Id) {
+		return
+	}
+	channelSLOMutex.Lock()
+	evaluatedAt := channelSLOEvaluatedAt[channel.Id]
+	channelSLOMutex.Unlock()
+	if time.Since(evaluatedAt) >= channelSLOEvaluateInterval {
+		EvaluateChannelSLO(channel)
+	}
+}
+
+func recordChannelLatency(channelId int, latencyMs int64) {
+	now := time.Now().Unix()
+	window := int64(model_setting.GetChannelSLOSettings().WindowSeconds)
+	if window <= 0 {
+		window = 300
+	}
+	channelSLOMutex.Lock()
+	defer channelSLOMutex.Unlock()
+	samples := append(channelLatencySamples[channelId], channelLatencySample{timestamp: now, latencyMs: latencyMs})
+	// 丢弃窗口外及超出上限的旧样本
+	start := 0
+	for start < len(samples) && (samples[start].timestamp <= now-window || len(samples)-start > channelLatencySampleLimit) {
+		start++
+	}
+	if start > 0 {
+		samples = append(samples[:0], samples[start:]...)
+	}
+	channelLatencySamples[channelId] = samples
+}
+
+func isChannelSLOTracked(channelId int) bool {
+	channelSLOMutex.Lock()
+	defer channelSLOMutex.Unlock()
+	_, ok := channelSLOBreaches[channelId]
+	return ok
+}
+
+// EvaluateChannelSLO 计算渠道在统计窗口内的首字延迟 P95 与错误率，并更新渠道的违反状态
+// 请求数少于配置的最小请求数时视为达标，渠道恢复达标后不再降级
+func EvaluateChannelSLO(channel *model.Channel) ChannelSLOStatus {
+	settings := model_setting.GetChannelSLOSettings()
+	window := time.Duration(settings.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	status := ChannelSLOStatus{
+		ChannelId:     channel.Id,
+		ChannelName:   channel.Name,
+		WindowSeconds: int(window.Seconds()),
+		EvaluatedAt:   time.Now().Unix(),
+	}
+	slo := getChannelSLO(channel)
+	if slo != nil {
+		status.Objective = *slo
+	}
+
+	errorRate, requests := GetChannelErrorRate(channel.Id, window)
+	status.Requests = requests
+	status.ErrorRatePercent = errorRate * 100
+
+	since := time.Now().Add(-window).Unix()
+	channelSLOMutex.Lock()
+	latencies := make([]int64, 0, len(channelLatencySamples[channel.Id]))
+	for _, sample := range channelLatencySamples[channel.Id] {
+		if sample.timestamp > since {
+			latencies = append(latencies, sample.latencyMs)
+		}
+	}
+	channelSLOMutex.Unlock()
+	status.LatencySamples = len(latencies)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		status.FirstTokenP95Ms = latencies[(len(latencies)*95+99)/100-1]
+	}
+
+	if slo.IsSet() && requests >= settings.MinRequests {
+		status.ErrorRateBreached = slo.ErrorRatePercent > 0 && status.ErrorRatePercent > slo.ErrorRatePercent
+		status.FirstTokenBreached = slo.FirstTokenP95Ms > 0 && len(latencies) >= settings.MinRequests && status.FirstTokenP95Ms > slo.FirstTokenP95Ms
+		status.Breaching = status.ErrorRateBreached || status.FirstTokenBreached
+	}
+
+	channelSLOMutex.Lock()
+	defer channelSLOMutex.Unlock()
+	channelSLOEvaluatedAt[channel.Id] = time.Now()
+	breachingSince, wasBreaching := channelSLOBreaches[channel.Id]
+	switch {
+	case status.Breaching && !wasBreaching:
+		breachingSince = status.EvaluatedAt
+		channelSLOBreaches[channel.Id] = breachingSince
+		common.SysLog(fmt.Sprintf("channel #%d breaching SLO: error rate %.2f%%, first token p95 %dms, de-prioritized", channel.Id, status.ErrorRatePercent, status.FirstTokenP95Ms))
+	case !status.Breaching && wasBreaching:
+		delete(channelSLOBreaches, channel.Id)
+		common.SysLog(fmt.Sprintf("channel #%d recovered SLO compliance after %ds", channel.Id, status.EvaluatedAt-breachingSince))
+	}
+	if status.Breaching {
+		status.BreachingSince = breachingSince
+	}
+	return status
+}
+
+// isChannelBreachingSLO 判断渠道当前是否违反 SLO，状态过期时重新评估以便渠道在流量减少后也能恢复
+func isChannelBreachingSLO(channel *model.Channel) bool {
+	channelSLOMutex.Lock()
+	_, breaching := channelSLOBreaches[channel.Id]
+	stale := time.Since(channelSLOEvaluatedAt[channel.Id]) >= channelSLOEvaluateInterval
+	channelSLOMutex.Unlock()
+	if !breaching {
+		return false
+	}
+	if stale {
+		return EvaluateChannelSLO(channel).Breaching
+	}
+	return true
+}
+
+// hasChannelSLOBreaches 是否存在违反 SLO 的渠道，不存在时选择渠道无需加载候选渠道
+func hasChannelSLOBreaches() bool {
+	if !model_setting.GetChannelSLOSettings().Enabled {
+		return false
+	}
+	channelSLOMutex.Lock()
+	defer channelSLOMutex.Unlock()
+	return len(channelSLOBreaches) > 0
+}
+
+// splitChannelsBySLO 将渠道分为达标与违反 SLO 两组
+func splitChannelsBySLO(channels []*model.Channel) ([]*model.Channel, []*model.Channel) {
+	var healthy, breaching []*model.Channel
+	for _, channel := range channels {
+		if isChannelBreachingSLO(channel) {
+			breaching = append(breaching, channel)
+		} else {
+			healthy = append(healthy, channel)
+		}
+	}
+	return healthy, breaching
+}
+
+// selectChannelBySLO 存在违反 SLO 的候选渠道时，将其排在全部达标渠道的优先级层级之后选择
+// 返回:
+//   - *model.Channel: 选中的渠道
+//   - bool: 是否由该逻辑处理，没有违反 SLO 的候选渠道或全部违反时返回 false
+//   - error: 读取渠道失败时返回错误
+func selectChannelBySLO(group string, modelName string, retry int) (*model.Channel, bool, error) {
+	if !hasChannelSLOBreaches() {
+		return nil, false, nil
+	}
+	channels, err := model.GetSatisfiedChannels(group, modelName)
+	if err != nil {
+		return nil, true, err
+	}
+	healthy, breaching := splitChannelsBySLO(channels)
+	if len(healthy) == 0 || len(breaching) == 0 {
+		return nil, false, nil
+	}
+	return pickChannelBySLOTier(healthy, breaching, retry), true, nil
+}
+
+// pickChannelBySLOTier 先按优先级在达标渠道中选择，重试次数用尽达标渠道的优先级层后再选择违反 SLO 的渠道
+func pickChannelBySLOTier(healthy []*model.Channel, breaching []*model.Channel, retry int) *model.Channel {
+	return pickChannelByWeight(sloTierCandidateChannels(healthy, breaching, retry))
+}
+
+// sloTierCandidateChannels 返回第 retry 次尝试时参与按权重随机选择的渠道，违反 SLO 的渠道排在达标渠道的全部优先级层之后
+func sloTierCandidateChannels(healthy []*model.Channel, breaching []*model.Channel, retry int) []*model.Channel {
+	tiers := make(map[int64]bool)
+	for _, channel := range healthy {
+		tiers[channel.GetPriority()] = true
+	}
+	if retry < len(tiers) {
+		return channelsAtPriorityRank(healthy, retry)
+	}
+	return channelsAtPriorityRank(breaching, retry-len(tiers))
+}
+
+// preferSLOCompliantChannels 存在达标渠道时只保留达标渠道
+func preferSLOCompliantChannels(channels []*model.Channel) []*model.Channel {
+	if !hasChannelSLOBreaches() {
+		return channels
+	}
+	if healthy, _ := splitChannelsBySLO(channels); len(healthy) > 0 {
+		return healthy
+	}
+	return channels
+}
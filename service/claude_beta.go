@@ -0,0 +1,109 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetClaudeBetaFeatures 获取请求通过 anthropic-beta 请求头声明的 beta 特性列表
+func GetClaudeBetaFeatures(c *gin.Context) []string {
+	var features []string
+	for _, value := range c.Request.Header.Values("anthropic-beta") {
+		for _, feature := range strings.Split(value, ",") {
+			if feature = strings.TrimSpace(feature); feature != "" {
+				features = append(features, feature)
+			}
+		}
+	}
+	return features
+}
+
+// IsClaudeBetaRequest 判断 Claude 请求是否携带 ?beta=true 查询参数或 anthropic-beta 请求头
+func IsClaudeBetaRequest(c *gin.Context) bool {
+	return c.Query("beta") == "true" || len(GetClaudeBetaFeatures(c)) > 0
+}
+
+// ShouldRouteClaudeBetaNatively 判断 Claude beta 请求是否不能由该类型的渠道处理
+// 仅在 claude_beta_policy 为 native 时，Responses 渠道会被跳过，交由原生 Claude 渠道处理
+func ShouldRouteClaudeBetaNatively(c *gin.Context, channelType int) bool {
+	if channelType != constant.ChannelTypeOpenAIResponses {
+		return false
+	}
+	if model_setting.GetResponsesSettings().GetClaudeBetaPolicy() != model_setting.ClaudeBetaPolicyNative {
+		return false
+	}
+	return IsClaudeBetaRequest(c)
+}
+
+// claudeNativeOnlyBlockTypes 只有原生 Claude 渠道能保留的内容块，Claude Code 的 MCP 工具调用与服务端工具依赖这些块，
+// 转换为 Responses 格式时没有对应的输入项，会被上游拒绝或丢失
+var claudeNativeOnlyBlockTypes = map[string]bool{
+	"server_tool_use":                        true,
+	"web_search_tool_result":                 true,
+	"web_fetch_tool_result":                  true,
+	"code_execution_tool_result":             true,
+	"bash_code_execution_tool_result":        true,
+	"text_editor_code_execution_tool_result": true,
+	"mcp_tool_use":                           true,
+	"mcp_tool_result":                        true,
+	"container_upload":                       true,
+}
+
+// FindClaudeNativeOnlyBlock 查找请求消息中只有原生 Claude 渠道能保留的内容块，包括 tool_result 中嵌套的内容块
+// 返回:
+//   - string: 第一个找到的内容块类型，不存在时为空字符串
+func FindClaudeNativeOnlyBlock(request *dto.ClaudeRequest) string {
+	if request == nil {
+		return ""
+	}
+	for _, message := range request.Messages {
+		if blockType := findNativeOnlyBlock(message.Content); blockType != "" {
+			return blockType
+		}
+	}
+	return ""
+}
+
+func findNativeOnlyBlock(content any) string {
+	blocks, ok := content.([]any)
+	if !ok {
+		return ""
+	}
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+		blockType, _ := blockMap["type"].(string)
+		if claudeNativeOnlyBlockTypes[blockType] {
+			return blockType
+		}
+		if blockType == "tool_result" {
+			if nested := findNativeOnlyBlock(blockMap["content"]); nested != "" {
+				return nested
+			}
+		}
+	}
+	return ""
+}
+
+// ShouldRouteClaudeBlocksNatively 判断 Claude 请求是否包含该类型渠道无法保留的内容块，Responses 渠道会被跳过，交由原生 Claude 渠道处理
+// 返回:
+//   - string: 无法保留的内容块类型
+//   - bool: 是否需要跳过该渠道
+func ShouldRouteClaudeBlocksNatively(channelType int, request dto.Request) (string, bool) {
+	if channelType != constant.ChannelTypeOpenAIResponses {
+		return "", false
+	}
+	claudeRequest, ok := request.(*dto.ClaudeRequest)
+	if !ok {
+		return "", false
+	}
+	blockType := FindClaudeNativeOnlyBlock(claudeRequest)
+	return blockType, blockType != ""
+}
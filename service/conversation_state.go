@@ -0,0 +1,220 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationStateKeyPrefix Redis 中保存会话状态的 key 前缀
+const conversationStateKeyPrefix = "responses_conversation:"
+
+// conversationStatePruneInterval 内存模式下清理过期会话的间隔
+const conversationStatePruneInterval = 10 * time.Minute
+
+// conversationStateRecord 会话最近一次成功响应的记录
+type conversationStateRecord struct {
+	ResponseId string `json:"response_id"`
+	ChannelId  int    `json:"channel_id"` // 响应 ID 只在创建它的上游账号下有效
+	InputCount int    `json:"input_count"`
+	InputHash  string `json:"input_hash"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+var (
+	conversationStates      = make(map[string]conversationStateRecord)
+	conversationStatesMutex sync.RWMutex
+	conversationStatesOnce  sync.Once
+)
+
+// ApplyManagedConversationState 为转换后的 Responses 请求模拟会话状态
+// 上一轮请求在同一渠道成功且本次历史以上一轮的完整历史开头时，设置 previous_response_id 并只发送新增的输入，
+// 上一轮的回复已保存在上游，因此紧随历史之后的助手输出项一并省略。客户端已指定 previous_response_id 或关闭 store 时不处理
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息，记录本次请求的会话信息供请求成功后保存
+//   - responsesReq: 转换后的 Responses 请求
+func ApplyManagedConversationState(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) {
+	if !model_setting.GetResponsesSettings().ManagedConversationState {
+		return
+	}
+	if responsesReq.PreviousResponseID != "" || strings.TrimSpace(string(responsesReq.Store)) == "false" {
+		return
+	}
+	var inputs []json.RawMessage
+	if err := common.Unmarshal(responsesReq.Input, &inputs); err != nil || len(inputs) == 0 {
+		return
+	}
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	key := common.GenerateHMAC(fmt.Sprintf("%d:%s:%s:%s", tokenId, responsesReq.Model, string(responsesReq.Instructions), string(inputs[0])))
+	info.ConversationState = &relaycommon.ConversationState{
+		Key:        key,
+		InputCount: len(inputs),
+		InputHash:  hashConversationInputs(inputs),
+	}
+
+	record := loadConversationState(key)
+	if record != nil && record.ChannelId != info.ChannelId && isConversationChannelDraining(record.ChannelId) {
+		// 会话绑定的渠道进入维护窗口或已删除，丢弃旧响应 ID，请求成功后会话改为绑定当前渠道
+		deleteConversationState(key)
+		info.AddConversionNote(fmt.Sprintf("conversation re-pinned from unavailable channel #%d to channel #%d", record.ChannelId, info.ChannelId))
+		return
+	}
+	if record == nil || record.ChannelId != info.ChannelId || record.InputCount >= len(inputs) {
+		return
+	}
+	if hashConversationInputs(inputs[:record.InputCount]) != record.InputHash {
+		return
+	}
+	newInputs := inputs[record.InputCount:]
+	for len(newInputs) > 0 && !isClientInputItem(newInputs[0]) {
+		newInputs = newInputs[1:]
+	}
+	if len(newInputs) == 0 {
+		return
+	}
+	inputData, err := common.Marshal(newInputs)
+	if err != nil {
+		return
+	}
+	responsesReq.Input = inputData
+	responsesReq.PreviousResponseID = record.ResponseId
+	info.AddConversionNote(fmt.Sprintf("previous_response_id %s used, %d of %d input items sent", record.ResponseId, len(newInputs), len(inputs)))
+}
+
+// SaveManagedConversationState 请求成功后记录会话最近一次的响应 ID，未启用会话状态模拟时不处理
+func SaveManagedConversationState(info *relaycommon.RelayInfo, responseId string) {
+	state := info.ConversationState
+	if state == nil || responseId == "" {
+		return
+	}
+	ttl := time.Duration(model_setting.GetResponsesSettings().ManagedConversationStateTTL) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	record := conversationStateRecord{
+		ResponseId: responseId,
+		ChannelId:  info.ChannelId,
+		InputCount: state.InputCount,
+		InputHash:  state.InputHash,
+		ExpiresAt:  time.Now().Add(ttl).Unix(),
+	}
+	if common.RedisEnabled {
+		data, err := common.Marshal(record)
+		if err != nil {
+			return
+		}
+		if err := common.RedisSet(conversationStateKeyPrefix+state.Key, string(data), ttl); err != nil {
+			common.SysLog("save conversation state to redis failed: " + err.Error())
+		}
+		return
+	}
+	// 过期会话由后台定期清理，保存时不遍历
+	conversationStatesOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(conversationStatePruneInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				pruneExpiredConversationStates()
+			}
+		}()
+	})
+	conversationStatesMutex.Lock()
+	conversationStates[state.Key] = record
+	conversationStatesMutex.Unlock()
+}
+
+// pruneExpiredConversationStates 删除内存中已过期的会话
+func pruneExpiredConversationStates() {
+	now := time.Now().Unix()
+	conversationStatesMutex.Lock()
+	defer conversationStatesMutex.Unlock()
+	for key, record := range conversationStates {
+		if record.ExpiresAt < now {
+			delete(conversationStates, key)
+		}
+	}
+}
+
+func loadConversationState(key string) *conversationStateRecord {
+	if common.RedisEnabled {
+		data, err := common.RedisGet(conversationStateKeyPrefix + key)
+		if err != nil || data == "" {
+			return nil
+		}
+		var record conversationStateRecord
+		if err := common.UnmarshalJsonStr(data, &record); err != nil {
+			return nil
+		}
+		return &record
+	}
+	conversationStatesMutex.RLock()
+	record, ok := conversationStates[key]
+	conversationStatesMutex.RUnlock()
+	if !ok || record.ExpiresAt < time.Now().Unix() {
+		return nil
+	}
+	return &record
+}
+
+func deleteConversationState(key string) {
+	if common.RedisEnabled {
+		if err := common.RedisDel(conversationStateKeyPrefix + key); err != nil {
+			common.SysLog("delete conversation state from redis failed: " + err.Error())
+		}
+		return
+	}
+	conversationStatesMutex.Lock()
+	delete(conversationStates, key)
+	conversationStatesMutex.Unlock()
+}
+
+// isConversationChannelDraining 判断会话绑定的渠道是否处于维护窗口或维护前的排空期，渠道不存在时同样视为需要重新绑定
+func isConversationChannelDraining(channelId int) bool {
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return true
+	}
+	return channel.GetActiveMaintenanceWindow(common.GetTimestamp()) != nil
+}
+
+func hashConversationInputs(inputs []json.RawMessage) string {
+	h := sha256.New()
+	for _, input := range inputs {
+		h.Write(input)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isClientInputItem 判断输入项是否由客户端提供，而不是上一轮响应的输出
+func isClientInputItem(item json.RawMessage) bool {
+	var peek struct {
+		Type string `json:"type"`
+		Role string `json:"role"`
+	}
+	if err := common.Unmarshal(item, &peek); err != nil {
+		return true
+	}
+	if strings.HasSuffix(peek.Type, "_output") {
+		return true
+	}
+	switch peek.Role {
+	case "user", "system", "developer":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const conversationSummaryPrompt = "Summarize the following earlier part of a conversation between a user and an assistant. " +
+	"Keep facts, decisions, open tasks, file names, identifiers and tool results that later turns may rely on. " +
+	"Reply with the summary only."
+
+// TrimConversationToBudget 转换后请求的提示 token 估算值超出预算时，丢弃较早的轮次，配置了摘要渠道时以摘要代替，对客户端透明
+// 开头的 system / developer 消息与最近的输入项始终保留，裁剪位置只落在用户消息上，避免拆开工具调用与结果。
+// 已使用 previous_response_id 的请求不处理，裁剪结果记录为转换说明
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息
+//   - responsesReq: 转换后的 Responses 请求
+func TrimConversationToBudget(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) {
+	settings := model_setting.GetConversationTrimSettings()
+	if !settings.Enabled || settings.MaxPromptTokens <= 0 || responsesReq.PreviousResponseID != "" {
+		return
+	}
+	var inputs []json.RawMessage
+	if err := common.Unmarshal(responsesReq.Input, &inputs); err != nil || len(inputs) <= settings.KeepRecentItems+1 {
+		return
+	}
+
+	itemTokens := make([]int, len(inputs))
+	totalTokens := CountTextToken(string(responsesReq.Instructions), responsesReq.Model)
+	for i, input := range inputs {
+		itemTokens[i] = CountTextToken(string(input), responsesReq.Model)
+		totalTokens += itemTokens[i]
+	}
+	if totalTokens <= settings.MaxPromptTokens {
+		return
+	}
+
+	pinned := 0
+	for pinned < len(inputs) && isInstructionInputItem(inputs[pinned]) {
+		pinned++
+	}
+	// suffixTokens[i] 为第 i 项及之后全部输入项的 token 数
+	suffixTokens := make([]int, len(inputs)+1)
+	for i := len(inputs) - 1; i >= 0; i-- {
+		suffixTokens[i] = suffixTokens[i+1] + itemTokens[i]
+	}
+	// 指令与开头的 system / developer 消息不参与裁剪
+	fixedTokens := totalTokens - suffixTokens[pinned]
+	reserve := 0
+	if settings.SummaryChannelId > 0 {
+		reserve = settings.SummaryMaxTokens
+	}
+
+	// 选择满足预算的最早裁剪位置，都不满足时尽量多地裁剪
+	cut := -1
+	for i := pinned + 1; i < len(inputs) && i <= len(inputs)-settings.KeepRecentItems; i++ {
+		if !isUserMessageInputItem(inputs[i]) {
+			continue
+		}
+		cut = i
+		if fixedTokens+suffixTokens[i]+reserve <= settings.MaxPromptTokens {
+			break
+		}
+	}
+	if cut < 0 {
+		return
+	}
+
+	dropped := inputs[pinned:cut]
+	kept := make([]json.RawMessage, 0, len(inputs)-len(dropped)+1)
+	kept = append(kept, inputs[:pinned]...)
+	method := "dropped"
+	summaryTokens := 0
+	if settings.SummaryChannelId > 0 {
+		summary, err := summarizeConversationItems(c, info, settings, dropped)
+		if err != nil {
+			logger.LogWarn(c, "summarize trimmed conversation failed, dropping instead: "+err.Error())
+			method = "dropped (summary failed)"
+		} else if summaryItem, err := common.Marshal(map[string]any{
+			"type":    "message",
+			"role":    "system",
+			"content": "Summary of the earlier conversation:\n" + summary,
+		}); err == nil {
+			kept = append(kept, summaryItem)
+			summaryTokens = CountTextToken(string(summaryItem), responsesReq.Model)
+			method = fmt.Sprintf("summarized by %s on channel #%d", settings.SummaryModel, settings.SummaryChannelId)
+		}
+	}
+	kept = append(kept, inputs[cut:]...)
+
+	inputData, err := common.Marshal(kept)
+	if err != nil {
+		return
+	}
+	responsesReq.Input = inputData
+	trimmedTokens := fixedTokens + suffixTokens[cut] + summaryTokens
+	info.AddConversionNote(fmt.Sprintf("conversation trimmed to %d token budget: %d of %d input items %s, ~%d -> ~%d prompt tokens",
+		settings.MaxPromptTokens, len(dropped), len(inputs), method, totalTokens, trimmedTokens))
+}
+
+// isInstructionInputItem 判断输入项是否为 system / developer 消息
+func isInstructionInputItem(item json.RawMessage) bool {
+	role := gjson.GetBytes(item, "role").String()
+	return role == "system" || role == "developer"
+}
+
+// isUserMessageInputItem 判断输入项是否为用户消息，即一轮对话的开始
+func isUserMessageInputItem(item json.RawMessage) bool {
+	itemType := gjson.GetBytes(item, "type").String()
+	return (itemType == "" || itemType == "message") && gjson.GetBytes(item, "role").String() == "user"
+}
+
+// renderConversationItems 将输入项渲染为用于生成摘要的纯文本
+func renderConversationItems(items []json.RawMessage) string {
+	var b strings.Builder
+	for _, item := range items {
+		parsed := gjson.ParseBytes(item)
+		switch parsed.Get("type").String() {
+		case "function_call":
+			fmt.Fprintf(&b, "[tool call] %s(%s)\n", parsed.Get("name").String(), parsed.Get("arguments").String())
+		case "function_call_output":
+			fmt.Fprintf(&b, "[tool result] %s\n", parsed.Get("output").String())
+		case "", "message":
+			content := parsed.Get("content")
+			text := content.String()
+			if content.IsArray() {
+				var parts []string
+				for _, part := range content.Array() {
+					if t := part.Get("text"); t.Exists() {
+						parts = append(parts, t.String())
+					}
+				}
+				text = strings.Join(parts, "\n")
+			}
+			if text != "" {
+				fmt.Fprintf(&b, "[%s] %s\n", parsed.Get("role").String(), text)
+			}
+		}
+	}
+	return b.String()
+}
+
+// ConversationSummaryRequester 通过渠道适配器发送摘要请求并向请求所属用户计费，返回摘要文本
+// 由 relay 包注册，service 包不能直接依赖渠道适配器
+type ConversationSummaryRequester func(ctx context.Context, c *gin.Context, info *relaycommon.RelayInfo, channel *model.Channel, request *dto.GeneralOpenAIRequest) (string, error)
+
+var conversationSummaryRequester ConversationSummaryRequester
+
+// RegisterConversationSummaryRequester 注册摘要请求的发送方式
+func RegisterConversationSummaryRequester(requester ConversationSummaryRequester) {
+	conversationSummaryRequester = requester
+}
+
+// conversationSummaryCacheSize 摘要缓存的最大条数
+const conversationSummaryCacheSize = 1024
+
+var (
+	conversationSummaryCache      = make(map[string]string)
+	conversationSummaryCacheMutex sync.RWMutex
+)
+
+// summarizeConversationItems 使用配置的摘要渠道与模型为被裁剪的输入项生成摘要
+// 摘要按渠道、模型与被裁剪历史的哈希缓存，Agent 后续轮次与重试裁剪到同一位置时不再重复请求
+// 摘要渠道需要在请求所属分组下提供摘要模型，请求通过渠道适配器发送并计入用户的消耗
+func summarizeConversationItems(c *gin.Context, info *relaycommon.RelayInfo, settings *model_setting.ConversationTrimSettings, items []json.RawMessage) (string, error) {
+	transcript := renderConversationItems(items)
+	if transcript == "" {
+		return "", fmt.Errorf("no text to summarize")
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s", settings.SummaryChannelId, settings.SummaryModel, transcript)))
+	key := hex.EncodeToString(hash[:])
+	conversationSummaryCacheMutex.RLock()
+	cached, ok := conversationSummaryCache[key]
+	conversationSummaryCacheMutex.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	if conversationSummaryRequester == nil {
+		return "", fmt.Errorf("summary requester is not registered")
+	}
+	channel, err := model.CacheGetChannel(settings.SummaryChannelId)
+	if err != nil {
+		return "", err
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return "", fmt.Errorf("summary channel #%d is disabled", channel.Id)
+	}
+	channels, err := model.GetSatisfiedChannels(info.UsingGroup, settings.SummaryModel)
+	if err != nil {
+		return "", err
+	}
+	if !slices.ContainsFunc(channels, func(candidate *model.Channel) bool { return candidate.Id == channel.Id }) {
+		return "", fmt.Errorf("summary channel #%d does not serve %s in group %s", channel.Id, settings.SummaryModel, info.UsingGroup)
+	}
+
+	timeout := time.Duration(settings.SummaryTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 20 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	request := &dto.GeneralOpenAIRequest{
+		Model: settings.SummaryModel,
+		Messages: []dto.Message{
+			{Role: "system", Content: conversationSummaryPrompt},
+			{Role: "user", Content: transcript},
+		},
+		MaxTokens: uint(settings.SummaryMaxTokens),
+	}
+	summary, err := conversationSummaryRequester(ctx, c, info, channel, request)
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", fmt.Errorf("summary response is empty")
+	}
+
+	conversationSummaryCacheMutex.Lock()
+	// 超出容量时随机淘汰一条
+	for len(conversationSummaryCache) >= conversationSummaryCacheSize {
+		for k := range conversationSummaryCache {
+			delete(conversationSummaryCache, k)
+			break
+		}
+	}
+	conversationSummaryCache[key] = summary
+	conversationSummaryCacheMutex.Unlock()
+	return summary, nil
+}
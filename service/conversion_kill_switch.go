@@ -0,0 +1,40 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// GetChannelConversionDirection 获取请求在指定类型渠道上会经过的转换方向，原生处理时返回空字符串
+// Claude 渠道的智能路由按模型判断，在适配器内单独处理并在关闭时回退到原生 Claude 接口
+func GetChannelConversionDirection(channelType int, relayFormat types.RelayFormat) string {
+	if channelType == constant.ChannelTypeAnthropic && relayFormat == types.RelayFormatOpenAIResponses {
+		return model_setting.ConversionDirectionResponsesToMessages
+	}
+	if channelType != constant.ChannelTypeOpenAIResponses {
+		return ""
+	}
+	switch relayFormat {
+	case types.RelayFormatOpenAI:
+		return model_setting.ConversionDirectionChatToResponses
+	case types.RelayFormatClaude:
+		return model_setting.ConversionDirectionClaudeToResponses
+	case types.RelayFormatGemini:
+		return model_setting.ConversionDirectionGeminiToResponses
+	}
+	return ""
+}
+
+// IsChannelConversionDisabled 判断请求在指定类型渠道上需要的转换方向是否已被关闭
+//
+// 返回:
+//   - string: 需要的转换方向
+//   - bool: 该方向是否已关闭
+func IsChannelConversionDisabled(channelType int, relayFormat types.RelayFormat) (string, bool) {
+	direction := GetChannelConversionDirection(channelType, relayFormat)
+	if direction == "" {
+		return "", false
+	}
+	return direction, model_setting.IsConversionDirectionDisabled(direction)
+}
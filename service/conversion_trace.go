@@ -0,0 +1,150 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ConversionDebugHeader 客户端开启单次请求转换追踪的请求头
+	ConversionDebugHeader = "X-New-API-Debug"
+	// ConversionDebugHeaderValue 开启转换追踪时请求头的取值
+	ConversionDebugHeaderValue = "conversion"
+	// conversionTraceBodyLimit 追踪记录中单个请求/响应体保留的最大字节数
+	conversionTraceBodyLimit = 256 * 1024
+)
+
+// conversionTraceWriter 在写给客户端的同时记录响应内容
+type conversionTraceWriter struct {
+	gin.ResponseWriter
+	mutex     sync.Mutex
+	body      []byte
+	truncated bool
+}
+
+func (w *conversionTraceWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *conversionTraceWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *conversionTraceWriter) capture(data []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	remaining := conversionTraceBodyLimit - len(w.body)
+	if len(data) > remaining {
+		data = common.TruncateUTF8Bytes(data, remaining)
+		w.truncated = true
+	}
+	w.body = append(w.body, data...)
+}
+
+func (w *conversionTraceWriter) snapshot() (string, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return string(w.body), w.truncated
+}
+
+// ConversionTraceRecorder 单次请求的转换追踪，未开启追踪时为 nil，方法均可在 nil 上调用
+type ConversionTraceRecorder struct {
+	c      *gin.Context
+	writer *conversionTraceWriter
+	info   *relaycommon.RelayInfo
+}
+
+// StartConversionTrace 请求携带 X-New-API-Debug: conversion、管理员开启了转换追踪且用户或令牌在允许名单中时，开始记录返回给客户端的响应
+// 需要在写出任何响应之前调用
+// 返回:
+//   - *ConversionTraceRecorder: 转换追踪，未开启时为 nil
+func StartConversionTrace(c *gin.Context) *ConversionTraceRecorder {
+	settings := model_setting.GetResponsesSettings()
+	if !settings.ConversionDebugEnabled || settings.ConversionTraceQueueSize <= 0 {
+		return nil
+	}
+	if c.GetHeader(ConversionDebugHeader) != ConversionDebugHeaderValue {
+		return nil
+	}
+	if !settings.IsConversionDebugAllowed(c.GetInt("id"), common.GetContextKeyInt(c, constant.ContextKeyTokenId)) {
+		return nil
+	}
+	writer := &conversionTraceWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return &ConversionTraceRecorder{c: c, writer: writer}
+}
+
+// Attach 关联请求信息，请求在生成请求信息之前失败时追踪只包含错误响应
+func (r *ConversionTraceRecorder) Attach(info *relaycommon.RelayInfo) {
+	if r == nil {
+		return
+	}
+	r.info = info
+}
+
+// Finish 请求结束时将追踪记录异步写入日志库，应在错误响应写出之后调用
+// 参数:
+//   - err: 请求最终的错误，成功时为 nil
+func (r *ConversionTraceRecorder) Finish(err *types.NewAPIError) {
+	if r == nil {
+		return
+	}
+	downstream, truncated := r.writer.snapshot()
+	trace := &model.ConversionTrace{
+		RequestId:          r.c.GetString(common.RequestIdKey),
+		UserId:             r.c.GetInt("id"),
+		TokenId:            common.GetContextKeyInt(r.c, constant.ContextKeyTokenId),
+		StatusCode:         r.writer.Status(),
+		DownstreamResponse: downstream,
+		Truncated:          truncated,
+		CreatedAt:          time.Now().Unix(),
+	}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	if info := r.info; info != nil {
+		trace.ModelName = info.OriginModelName
+		trace.UpstreamModel = info.UpstreamModelName
+		trace.ConvertedFrom = string(info.ConversionSource)
+		trace.ConversionNotes = strings.Join(info.ConversionNotes, "\n")
+		trace.IsStream = info.IsStream
+		if info.ChannelMeta != nil {
+			trace.ChannelId = info.ChannelId
+		}
+		var cut bool
+		trace.OriginalRequest, cut = truncateConversionTraceBody(info.RequestBody)
+		trace.Truncated = trace.Truncated || cut
+		trace.UpstreamRequest, cut = truncateConversionTraceBody(string(info.UpstreamRequestBody))
+		trace.Truncated = trace.Truncated || cut
+		trace.UpstreamResponse, cut = truncateConversionTraceBody(info.ResponseBody)
+		trace.Truncated = trace.Truncated || cut
+	}
+
+	keep := model_setting.GetResponsesSettings().ConversionTraceQueueSize
+	gopool.Go(func() {
+		if err := model.InsertConversionTrace(trace, keep); err != nil {
+			common.SysError("failed to save conversion trace: " + err.Error())
+		}
+	})
+}
+
+func truncateConversionTraceBody(body string) (string, bool) {
+	if len(body) > conversionTraceBodyLimit {
+		// 按字符边界截断，避免写入日志库时出现非法 UTF-8
+		return string(common.TruncateUTF8Bytes([]byte(body), conversionTraceBodyLimit)), true
+	}
+	return body, false
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// convertedParamFields Responses 请求参数到原始请求字段的映射，未列出的参数与原始字段同名
+var convertedParamFields = map[relaycommon.ConversionSource]map[string]string{
+	relaycommon.ConversionSourceChat: {
+		"input":             "messages",
+		"instructions":      "messages",
+		"max_output_tokens": "max_tokens",
+		"text":              "response_format",
+		"reasoning":         "reasoning_effort",
+	},
+	relaycommon.ConversionSourceClaude: {
+		"input":             "messages",
+		"instructions":      "system",
+		"max_output_tokens": "max_tokens",
+		"reasoning":         "thinking",
+	},
+	relaycommon.ConversionSourceGemini: {
+		"input":             "contents",
+		"instructions":      "systemInstruction",
+		"max_output_tokens": "generationConfig.maxOutputTokens",
+		"temperature":       "generationConfig.temperature",
+		"top_p":             "generationConfig.topP",
+		"reasoning":         "generationConfig.thinkingConfig",
+		"text":              "generationConfig.responseSchema",
+		"tool_choice":       "toolConfig",
+	},
+}
+
+// ShouldShowConvertedErrorBody 判断是否向客户端返回转换请求的上游非 JSON 错误响应体
+// 仅返回 4xx 响应体以便用户修正请求，5xx 响应体通常是上游网关的错误页面或内部信息，不返回给客户端
+func ShouldShowConvertedErrorBody(info *relaycommon.RelayInfo, resp *http.Response) bool {
+	return info.IsConverted() && resp.StatusCode < http.StatusInternalServerError
+}
+
+// mapConvertedParam 将上游 Responses 参数路径映射为原始请求中的字段名
+func mapConvertedParam(convertedFrom relaycommon.ConversionSource, param string) string {
+	root := param
+	if index := strings.IndexAny(param, ".["); index > 0 {
+		root = param[:index]
+	}
+	if field, ok := convertedParamFields[convertedFrom][root]; ok {
+		return field
+	}
+	return root
+}
+
+// EnrichConvertedUpstreamError 为经 Responses 转换的请求补充上游 4xx 错误的字段映射提示
+// 上游报错的参数是转换后的 Responses 字段，提示中给出对应的原始请求字段，便于用户修正请求；
+// Claude 请求的错误转换为 Claude 错误格式
+func EnrichConvertedUpstreamError(info *relaycommon.RelayInfo, newApiErr *types.NewAPIError) *types.NewAPIError {
+	if newApiErr == nil || !info.IsConverted() || newApiErr.StatusCode < 400 || newApiErr.StatusCode >= 500 {
+		return newApiErr
+	}
+	openAIError, ok := newApiErr.RelayError.(types.OpenAIError)
+	if !ok {
+		return newApiErr
+	}
+	if openAIError.Param != "" {
+		field := mapConvertedParam(info.ConversionSource, openAIError.Param)
+		openAIError.Message = fmt.Sprintf("%s (upstream parameter '%s' was converted from '%s' in your %s request)",
+			openAIError.Message, openAIError.Param, field, info.ConversionSource)
+		openAIError.Param = field
+	}
+
+	var enriched *types.NewAPIError
+	if info.ConversionSource == relaycommon.ConversionSourceClaude {
+		enriched = types.WithClaudeError(types.ClaudeError{
+			Type:    openAIError.Type,
+			Message: openAIError.Message,
+		}, newApiErr.StatusCode)
+	} else {
+		enriched = types.WithOpenAIError(openAIError, newApiErr.StatusCode)
+	}
+	enriched.RetryAfter = newApiErr.RetryAfter
+	return enriched
+}
@@ -12,9 +12,21 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
 )
 
+// GetErrorMessageLanguage 获取转发错误信息使用的语言，全局设置为 auto 时按请求的 Accept-Language 选择
+func GetErrorMessageLanguage(c *gin.Context) string {
+	switch lang := model_setting.GetGlobalSettings().ErrorMessageLanguage; lang {
+	case types.ErrorLanguageEnglish, types.ErrorLanguageChinese:
+		return lang
+	}
+	return types.ParseErrorLanguage(c.GetHeader("Accept-Language"))
+}
+
 func MidjourneyErrorWrapper(code int, desc string) *dto.MidjourneyResponse {
 	return &dto.MidjourneyResponse{
 		Code:        code,
@@ -81,8 +93,17 @@ func ClaudeErrorWrapperLocal(err error, code string, statusCode int) *dto.Claude
 	return claudeErr
 }
 
+// relayErrorBodyMaxLength 错误信息中展示的上游响应体最大字节数
+const relayErrorBodyMaxLength = 2048
+
 func RelayErrorHandler(ctx context.Context, resp *http.Response, showBodyWhenFail bool) (newApiErr *types.NewAPIError) {
 	newApiErr = types.InitOpenAIError(types.ErrorCodeBadResponseStatusCode, resp.StatusCode)
+	retryAfter := ParseRetryAfter(resp.StatusCode, resp.Header)
+	defer func() {
+		if newApiErr != nil {
+			newApiErr.RetryAfter = retryAfter
+		}
+	}()
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -94,7 +115,9 @@ func RelayErrorHandler(ctx context.Context, resp *http.Response, showBodyWhenFai
 	err = common.Unmarshal(responseBody, &errResponse)
 	if err != nil {
 		if showBodyWhenFail {
-			newApiErr.Err = fmt.Errorf("bad response status code %d, body: %s", resp.StatusCode, string(responseBody))
+			// 截断过长的响应体并隐藏其中的地址等敏感信息，避免将上游错误页面整体返回给客户端
+			body := common.MaskSensitiveInfo(string(common.TruncateUTF8Bytes(responseBody, relayErrorBodyMaxLength)))
+			newApiErr.Err = fmt.Errorf("bad response status code %d, body: %s", resp.StatusCode, body)
 		} else {
 			if common.DebugEnabled {
 				logger.LogInfo(ctx, fmt.Sprintf("bad response status code %d, body: %s", resp.StatusCode, string(responseBody)))
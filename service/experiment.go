@@ -0,0 +1,269 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// experimentLatencySampleLimit 每个实验分组保留的延迟样本上限
+const experimentLatencySampleLimit = 1000
+
+// ExperimentArmMetrics 实验分组的聚合指标
+type ExperimentArmMetrics struct {
+	Arm                  string  `json:"arm"`
+	Requests             int     `json:"requests"`
+	Errors               int     `json:"errors"`
+	StreamAborts         int     `json:"stream_aborts"`
+	ChannelTypeFallbacks int     `json:"channel_type_fallbacks"` // 未能使用分组指定渠道类型的请求数
+	SuccessRatePercent   float64 `json:"success_rate_percent"`
+	AvgLatencyMs         int64   `json:"avg_latency_ms"`
+	P95LatencyMs         int64   `json:"p95_latency_ms"`
+	AvgFirstTokenMs      int64   `json:"avg_first_token_ms"`
+	TotalQuota           int     `json:"total_quota"`
+	AvgQuota             float64 `json:"avg_quota"`
+}
+
+// ExperimentMetrics 实验各分组的聚合指标，统计自本节点启动或指标重置以来的请求，多节点部署时各节点分别统计
+type ExperimentMetrics struct {
+	Experiment string                 `json:"experiment"`
+	Enabled    bool                   `json:"enabled"`
+	Since      int64                  `json:"since"` // 开始统计的时间戳（秒）
+	Arms       []ExperimentArmMetrics `json:"arms"`
+}
+
+type experimentArmStats struct {
+	requests             int
+	errors               int
+	streamAborts         int
+	channelTypeFallbacks int
+	totalLatencyMs       int64
+	latencyMs            []int64
+	totalFirstTokenMs    int64
+	firstTokenSamples    int
+	totalQuota           int
+}
+
+var (
+	experimentStats      = make(map[string]map[string]*experimentArmStats)
+	experimentStatsSince = make(map[string]int64)
+	experimentStatsMutex sync.Mutex
+)
+
+// AssignExperiment 为请求分配实验分组，请求只参与第一个命中模型的启用实验
+// 同一令牌在同一实验中总是分到同一分组，没有令牌的请求按用户分配
+func AssignExperiment(c *gin.Context, modelName string) {
+	subject := fmt.Sprintf("user:%d", c.GetInt("id"))
+	if tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId); tokenId != 0 {
+		subject = fmt.Sprintf("token:%d", tokenId)
+	}
+	experiments := model_setting.GetExperimentSettings().Experiments
+	for i := range experiments {
+		experiment := &experiments[i]
+		if !experiment.Enabled || !experiment.MatchesModel(modelName) {
+			continue
+		}
+		arm := pickExperimentArm(experiment, subject)
+		if arm == nil {
+			continue
+		}
+		common.SetContextKey(c, constant.ContextKeyExperiment, experiment.Name)
+		common.SetContextKey(c, constant.ContextKeyExperimentArm, arm.Name)
+		return
+	}
+}
+
+// pickExperimentArm 按分组权重将对象哈希到一个分组，权重均为 0 时返回 nil
+func pickExperimentArm(experiment *model_setting.Experiment, subject string) *model_setting.ExperimentArm {
+	totalWeight := 0
+	for _, arm := range experiment.Arms {
+		if arm.Weight > 0 {
+			totalWeight += arm.Weight
+		}
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(experiment.Name + ":" + subject))
+	point := int(hash.Sum32() % uint32(totalWeight))
+	for i := range experiment.Arms {
+		arm := &experiment.Arms[i]
+		if arm.Weight <= 0 {
+			continue
+		}
+		if point < arm.Weight {
+			return arm
+		}
+		point -= arm.Weight
+	}
+	return nil
+}
+
+// getAssignedExperimentArm 获取请求被分配到的实验分组，实验已删除或分组已不存在时返回 nil
+func getAssignedExperimentArm(c *gin.Context) *model_setting.ExperimentArm {
+	experiment := common.GetContextKeyString(c, constant.ContextKeyExperiment)
+	if experiment == "" {
+		return nil
+	}
+	arm, ok := model_setting.GetExperimentArm(experiment, common.GetContextKeyString(c, constant.ContextKeyExperimentArm))
+	if !ok {
+		return nil
+	}
+	return arm
+}
+
+// GetExperimentChannelType 获取请求所在实验分组优先选择的渠道类型，0 表示不限制
+func GetExperimentChannelType(c *gin.Context) int {
+	if arm := getAssignedExperimentArm(c); arm != nil {
+		return arm.ChannelType
+	}
+	return 0
+}
+
+// ApplyExperimentReasoningEffort 按请求所在实验分组覆盖 reasoning.effort，并记录为转换说明
+// 返回:
+//   - bool: 实验分组是否指定了推理强度，指定时不再使用默认推理强度
+func ApplyExperimentReasoningEffort(info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) bool {
+	if info.Experiment == "" {
+		return false
+	}
+	arm, ok := model_setting.GetExperimentArm(info.Experiment, info.ExperimentArm)
+	if !ok || arm.ReasoningEffort == "" {
+		return false
+	}
+	if responsesReq.Reasoning == nil {
+		responsesReq.Reasoning = &dto.Reasoning{}
+	}
+	if responsesReq.Reasoning.Effort != arm.ReasoningEffort {
+		responsesReq.Reasoning.Effort = arm.ReasoningEffort
+		info.AddConversionNote(fmt.Sprintf("reasoning.effort set to %s by experiment %s arm %s", arm.ReasoningEffort, info.Experiment, info.ExperimentArm))
+	}
+	return true
+}
+
+// ObserveExperiment 请求结束时记录所在实验分组的结果、延迟与消耗
+// 参数:
+//   - c: Gin 上下文，记录消费日志时写入实际消耗的额度
+//   - info: 请求信息，未参与实验时不记录
+//   - err: 请求最终的错误，成功时为 nil
+func ObserveExperiment(c *gin.Context, info *relaycommon.RelayInfo, err *types.NewAPIError) {
+	if info == nil || info.Experiment == "" {
+		return
+	}
+	arm, ok := model_setting.GetExperimentArm(info.Experiment, info.ExperimentArm)
+	if !ok {
+		return
+	}
+
+	experimentStatsMutex.Lock()
+	defer experimentStatsMutex.Unlock()
+	arms, ok := experimentStats[info.Experiment]
+	if !ok {
+		arms = make(map[string]*experimentArmStats)
+		experimentStats[info.Experiment] = arms
+		experimentStatsSince[info.Experiment] = time.Now().Unix()
+	}
+	stats, ok := arms[info.ExperimentArm]
+	if !ok {
+		stats = &experimentArmStats{}
+		arms[info.ExperimentArm] = stats
+	}
+
+	stats.requests++
+	if arm.ChannelType != 0 && info.ChannelMeta != nil && info.ChannelType != arm.ChannelType {
+		stats.channelTypeFallbacks++
+	}
+	if info.StreamAborted {
+		stats.streamAborts++
+	}
+	if err != nil {
+		stats.errors++
+		return
+	}
+	latency := time.Since(info.StartTime).Milliseconds()
+	stats.totalLatencyMs += latency
+	stats.latencyMs = append(stats.latencyMs, latency)
+	if len(stats.latencyMs) > experimentLatencySampleLimit {
+		stats.latencyMs = stats.latencyMs[len(stats.latencyMs)-experimentLatencySampleLimit:]
+	}
+	if info.FirstResponseTime.After(info.StartTime) {
+		stats.totalFirstTokenMs += info.FirstResponseTime.Sub(info.StartTime).Milliseconds()
+		stats.firstTokenSamples++
+	}
+	stats.totalQuota += common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota)
+}
+
+// GetExperimentMetrics 获取实验的聚合指标，分组按配置顺序排列
+// 参数:
+//   - name: 实验名称，为空时返回所有已配置的实验
+func GetExperimentMetrics(name string) []ExperimentMetrics {
+	experimentStatsMutex.Lock()
+	defer experimentStatsMutex.Unlock()
+	experiments := model_setting.GetExperimentSettings().Experiments
+	result := make([]ExperimentMetrics, 0, len(experiments))
+	for _, experiment := range experiments {
+		if name != "" && experiment.Name != name {
+			continue
+		}
+		metrics := ExperimentMetrics{
+			Experiment: experiment.Name,
+			Enabled:    experiment.Enabled,
+			Since:      experimentStatsSince[experiment.Name],
+			Arms:       make([]ExperimentArmMetrics, 0, len(experiment.Arms)),
+		}
+		for _, arm := range experiment.Arms {
+			metrics.Arms = append(metrics.Arms, buildExperimentArmMetrics(arm.Name, experimentStats[experiment.Name][arm.Name]))
+		}
+		result = append(result, metrics)
+	}
+	return result
+}
+
+func buildExperimentArmMetrics(arm string, stats *experimentArmStats) ExperimentArmMetrics {
+	metrics := ExperimentArmMetrics{Arm: arm}
+	if stats == nil || stats.requests == 0 {
+		return metrics
+	}
+	successes := stats.requests - stats.errors
+	metrics.Requests = stats.requests
+	metrics.Errors = stats.errors
+	metrics.StreamAborts = stats.streamAborts
+	metrics.ChannelTypeFallbacks = stats.channelTypeFallbacks
+	metrics.SuccessRatePercent = float64(successes) * 100 / float64(stats.requests)
+	metrics.TotalQuota = stats.totalQuota
+	if successes > 0 {
+		metrics.AvgLatencyMs = stats.totalLatencyMs / int64(successes)
+		metrics.AvgQuota = float64(stats.totalQuota) / float64(successes)
+	}
+	if stats.firstTokenSamples > 0 {
+		metrics.AvgFirstTokenMs = stats.totalFirstTokenMs / int64(stats.firstTokenSamples)
+	}
+	if len(stats.latencyMs) > 0 {
+		latencies := append([]int64(nil), stats.latencyMs...)
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		metrics.P95LatencyMs = latencies[(len(latencies)*95+99)/100-1]
+	}
+	return metrics
+}
+
+// ResetExperimentMetrics 清空实验已收集的指标，修改分组配置后用于重新开始统计
+func ResetExperimentMetrics(name string) {
+	experimentStatsMutex.Lock()
+	defer experimentStatsMutex.Unlock()
+	delete(experimentStats, name)
+	delete(experimentStatsSince, name)
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// optimizedImage 压缩后的图片
+type optimizedImage struct {
+	data     string // base64 数据，不含 data URL 前缀
+	mimeType string
+}
+
+// OptimizeInlineImages 按配置缩小并重新编码转换后请求中的内联 base64 图片，URL 图片不处理
+// 支持 Responses 的 input_image（image_url 为 data URL）、Chat 的 image_url 与 Claude 的 base64 source 三种形式，
+// 处理失败或压缩后更大的图片保持原样，原始与压缩后的尺寸写入日志
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息
+//   - responsesReq: 转换后的 Responses 请求
+func OptimizeInlineImages(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) {
+	settings := model_setting.GetImageOptimizeSettings()
+	if info.ChannelMeta == nil || !settings.IsEnabledForChannel(info.ChannelOtherSettings.OptimizeInlineImages) || len(responsesReq.Input) == 0 {
+		return
+	}
+	var inputs []map[string]any
+	if err := common.Unmarshal(responsesReq.Input, &inputs); err != nil {
+		return
+	}
+
+	count, originalBytes, optimizedBytes := 0, 0, 0
+	optimize := func(data string) (optimizedImage, bool) {
+		result, ok := optimizeInlineImage(c, data, settings)
+		if ok {
+			count++
+			originalBytes += len(data) / 4 * 3
+			optimizedBytes += len(result.data) / 4 * 3
+		}
+		return result, ok
+	}
+	for _, input := range inputs {
+		blocks, ok := input["content"].([]any)
+		if !ok {
+			continue
+		}
+		for _, block := range blocks {
+			if blockMap, ok := block.(map[string]any); ok {
+				optimizeImageBlock(blockMap, optimize)
+			}
+		}
+	}
+	if count == 0 {
+		return
+	}
+	inputData, err := common.Marshal(inputs)
+	if err != nil {
+		return
+	}
+	responsesReq.Input = inputData
+	info.AddConversionNote(fmt.Sprintf("%d inline images optimized: %d -> %d bytes", count, originalBytes, optimizedBytes))
+}
+
+// optimizeImageBlock 压缩单个内容块中的内联图片，非图片块与 URL 图片不变
+func optimizeImageBlock(block map[string]any, optimize func(data string) (optimizedImage, bool)) {
+	// Claude: {"type":"image","source":{"type":"base64","media_type":"...","data":"..."}}
+	if source, ok := block["source"].(map[string]any); ok {
+		data, _ := source["data"].(string)
+		if source["type"] != "base64" || data == "" {
+			return
+		}
+		if result, ok := optimize(data); ok {
+			source["data"] = result.data
+			source["media_type"] = result.mimeType
+		}
+		return
+	}
+	// Responses: {"type":"input_image","image_url":"data:..."}
+	if url, ok := block["image_url"].(string); ok {
+		if result, ok := optimizeDataURL(url, optimize); ok {
+			block["image_url"] = result
+		}
+		return
+	}
+	// Chat: {"type":"image_url","image_url":{"url":"data:..."}}
+	if imageURL, ok := block["image_url"].(map[string]any); ok {
+		if url, ok := imageURL["url"].(string); ok {
+			if result, ok := optimizeDataURL(url, optimize); ok {
+				imageURL["url"] = result
+			}
+		}
+	}
+}
+
+// optimizeDataURL 压缩 base64 data URL 中的图片，返回新的 data URL
+func optimizeDataURL(url string, optimize func(data string) (optimizedImage, bool)) (string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", false
+	}
+	idx := strings.Index(url, ";base64,")
+	if idx < 0 {
+		return "", false
+	}
+	result, ok := optimize(url[idx+len(";base64,"):])
+	if !ok {
+		return "", false
+	}
+	return "data:" + result.mimeType + ";base64," + result.data, true
+}
+
+// imageOptimizeMaxDecodePixels 允许完整解码的最大像素数，约 160MB 的 RGBA 内存
+const imageOptimizeMaxDecodePixels = 40 * 1000 * 1000
+
+// optimizeInlineImage 按像素上限缩小图片并重新编码，带透明通道的图片编码为 PNG，其余编码为 JPEG
+// 未超出像素上限且小于 MinBytes 的图片、无法解码的图片以及重新编码后没有变小且未缩小尺寸的图片不处理
+func optimizeInlineImage(c *gin.Context, data string, settings *model_setting.ImageOptimizeSettings) (optimizedImage, bool) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return optimizedImage{}, false
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		if config, err = webp.DecodeConfig(bytes.NewReader(raw)); err != nil {
+			return optimizedImage{}, false
+		}
+		format = "webp"
+	}
+	// 声明了超大尺寸的小文件（解压炸弹）解码时会分配大量内存，超过解码像素上限的图片不解码，原样转发
+	if config.Width <= 0 || config.Height <= 0 || config.Width > imageOptimizeMaxDecodePixels/config.Height {
+		return optimizedImage{}, false
+	}
+	pixels := config.Width * config.Height
+	oversized := settings.MaxPixels > 0 && pixels > settings.MaxPixels
+	if !oversized && len(raw) < settings.MinBytes {
+		return optimizedImage{}, false
+	}
+
+	var img image.Image
+	if format == "webp" {
+		img, err = webp.Decode(bytes.NewReader(raw))
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(raw))
+	}
+	if err != nil {
+		return optimizedImage{}, false
+	}
+	if oversized {
+		scale := math.Sqrt(float64(settings.MaxPixels) / float64(pixels))
+		width := max(int(float64(config.Width)*scale), 1)
+		height := max(int(float64(config.Height)*scale), 1)
+		resized := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(resized, resized.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = resized
+	}
+
+	var buf bytes.Buffer
+	mimeType := "image/jpeg"
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		mimeType = "image/png"
+		err = png.Encode(&buf, img)
+	} else {
+		quality := settings.JPEGQuality
+		if quality <= 0 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil || (!oversized && buf.Len() >= len(raw)) {
+		return optimizedImage{}, false
+	}
+
+	bounds := img.Bounds()
+	logger.LogInfo(c, fmt.Sprintf("inline image optimized: %s %dx%d %d bytes -> %s %dx%d %d bytes",
+		format, config.Width, config.Height, len(raw), strings.TrimPrefix(mimeType, "image/"), bounds.Dx(), bounds.Dy(), buf.Len()))
+	return optimizedImage{
+		data:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+		mimeType: mimeType,
+	}, true
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// logArchiveKeyPrefix 归档对象的统一前缀
+const logArchiveKeyPrefix = "logs/"
+
+// applyLogBodyStorageLimit 按配置限制日志中保存的请求体/响应体大小
+// 超出限制时在字符边界截断保存，并在启用归档时将完整内容异步上传到对象存储，归档键记录在 field_archive 中
+// multiplier 为上限的放大倍数，长输出请求的响应体按配置放大
+func applyLogBodyStorageLimit(other map[string]interface{}, field string, body string, multiplier int) {
+	setting := system_setting.GetLogArchiveSetting()
+	limit := setting.BodyStorageLimit * multiplier
+	if limit <= 0 || len(body) <= limit {
+		other[field] = body
+		return
+	}
+	other[field] = string(common.TruncateUTF8Bytes(common.StringToByteSlice(body), limit))
+	other[field+"_truncated"] = true
+	if !setting.Enabled || setting.Bucket == "" || setting.Endpoint == "" {
+		return
+	}
+
+	key := fmt.Sprintf("%s%s/%s_%s.json", logArchiveKeyPrefix, time.Now().Format("20060102"), common.GetUUID(), field)
+	other[field+"_archive"] = key
+	gopool.Go(func() {
+		if err := putLogArchiveObject(key, []byte(body)); err != nil {
+			common.SysError(fmt.Sprintf("failed to archive log %s: %s", field, err.Error()))
+		}
+	})
+}
+
+func logArchiveObjectURL(setting *system_setting.LogArchiveSetting, key string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(setting.Endpoint, "/"), url.PathEscape(setting.Bucket), key)
+}
+
+func logArchiveCredentials(setting *system_setting.LogArchiveSetting) aws.Credentials {
+	return aws.Credentials{
+		AccessKeyID:     setting.AccessKeyId,
+		SecretAccessKey: setting.SecretAccessKey,
+	}
+}
+
+// putLogArchiveObject 使用 SigV4 签名将内容上传到 S3 兼容存储
+func putLogArchiveObject(key string, body []byte) error {
+	setting := system_setting.GetLogArchiveSetting()
+	req, err := http.NewRequest(http.MethodPut, logArchiveObjectURL(setting, key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signer := v4.NewSigner()
+	err = signer.SignHTTP(context.Background(), logArchiveCredentials(setting), req, payloadHash, "s3", setting.Region, time.Now())
+	if err != nil {
+		return err
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// GetLogArchiveSignedURL 生成归档内容的限时下载链接
+func GetLogArchiveSignedURL(key string) (string, error) {
+	setting := system_setting.GetLogArchiveSetting()
+	if !strings.HasPrefix(key, logArchiveKeyPrefix) || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid archive key")
+	}
+	if setting.Endpoint == "" || setting.Bucket == "" {
+		return "", fmt.Errorf("log archive storage is not configured")
+	}
+	req, err := http.NewRequest(http.MethodGet, logArchiveObjectURL(setting, key), nil)
+	if err != nil {
+		return "", err
+	}
+	expires := setting.SignedURLExpireSeconds
+	if expires <= 0 {
+		expires = 900
+	}
+	query := req.URL.Query()
+	query.Set("X-Amz-Expires", strconv.Itoa(expires))
+	req.URL.RawQuery = query.Encode()
+
+	signer := v4.NewSigner()
+	signedURL, _, err := signer.PresignHTTP(context.Background(), logArchiveCredentials(setting), req, "UNSIGNED-PAYLOAD", "s3", setting.Region, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return signedURL, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	// logExportBatchSize 导出时每批读取的日志条数
+	logExportBatchSize = 1000
+	// LogExportMaxSyncRows 同步导出单次允许的最大条数
+	LogExportMaxSyncRows = 10000
+	// logExportMaxAsyncRows 异步导出任务允许的最大条数
+	logExportMaxAsyncRows = 1000000
+	// logExportJobRetention 异步导出任务及文件的保留时长
+	logExportJobRetention = time.Hour
+)
+
+var logExportCSVHeader = []string{"id", "created_at", "type", "username", "token_name", "model_name", "channel", "group", "quota", "prompt_tokens", "completion_tokens", "use_time", "is_stream", "content", "other"}
+
+type LogExportJob struct {
+	Id        string `json:"id"`
+	Format    string `json:"format"`
+	Status    string `json:"status"` // running, completed, failed
+	Rows      int    `json:"rows"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	filePath  string
+}
+
+var (
+	logExportJobs      = make(map[string]*LogExportJob)
+	logExportJobsMutex sync.RWMutex
+)
+
+// LogExportWriter 按 NDJSON 或 CSV 格式逐条写出日志
+type LogExportWriter struct {
+	format    string
+	w         io.Writer
+	csvWriter *csv.Writer
+}
+
+func NewLogExportWriter(format string, w io.Writer) *LogExportWriter {
+	writer := &LogExportWriter{format: format, w: w}
+	if format == "csv" {
+		writer.csvWriter = csv.NewWriter(w)
+	}
+	return writer
+}
+
+func (l *LogExportWriter) WriteHeader() error {
+	if l.csvWriter == nil {
+		return nil
+	}
+	return l.csvWriter.Write(logExportCSVHeader)
+}
+
+func (l *LogExportWriter) Write(log *model.Log) error {
+	if l.csvWriter == nil {
+		data, err := common.Marshal(log)
+		if err != nil {
+			return err
+		}
+		_, err = l.w.Write(append(data, '\n'))
+		return err
+	}
+	return l.csvWriter.Write([]string{
+		strconv.Itoa(log.Id),
+		strconv.FormatInt(log.CreatedAt, 10),
+		strconv.Itoa(log.Type),
+		log.Username,
+		log.TokenName,
+		log.ModelName,
+		strconv.Itoa(log.ChannelId),
+		log.Group,
+		strconv.Itoa(log.Quota),
+		strconv.Itoa(log.PromptTokens),
+		strconv.Itoa(log.CompletionTokens),
+		strconv.Itoa(log.UseTime),
+		strconv.FormatBool(log.IsStream),
+		log.Content,
+		log.Other,
+	})
+}
+
+// Flush 写出缓冲的内容，底层为 HTTP 响应时同时推送给客户端
+func (l *LogExportWriter) Flush() error {
+	if l.csvWriter != nil {
+		l.csvWriter.Flush()
+		if err := l.csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := l.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// ExportLogsTo 将满足条件的日志写出，最多 maxRows 条，返回写出条数及下一页游标（0 表示没有更多数据）
+func ExportLogsTo(writer *LogExportWriter, filter model.LogExportFilter, beforeId int, maxRows int) (int, int, error) {
+	rows := 0
+	for rows < maxRows {
+		batchSize := logExportBatchSize
+		if maxRows-rows < batchSize {
+			batchSize = maxRows - rows
+		}
+		logs, err := model.ExportLogs(filter, beforeId, batchSize)
+		if err != nil {
+			return rows, beforeId, err
+		}
+		for _, log := range logs {
+			if err = writer.Write(log); err != nil {
+				return rows, beforeId, err
+			}
+			rows++
+			beforeId = log.Id
+		}
+		// 每批写出后立即推送，不在内存中累积整页
+		if err = writer.Flush(); err != nil {
+			return rows, beforeId, err
+		}
+		if len(logs) < batchSize {
+			return rows, 0, nil
+		}
+	}
+	return rows, beforeId, nil
+}
+
+// LogExportPage 同步导出的一页日志范围
+type LogExportPage struct {
+	Filter     model.LogExportFilter // 已限定本页最小 id 的过滤条件
+	BeforeId   int                   // 本页首条日志的 id 加一
+	Rows       int
+	NextCursor int // 下一页游标，0 表示没有更多数据
+}
+
+// PrepareLogExportPage 只读取 id 确定一页日志的范围，写出响应前即可得到下一页游标，日志内容随后按批流式写出
+// 参数:
+//   - filter: 过滤条件
+//   - beforeId: 上一页游标，为 0 时从最新日志开始
+//   - limit: 本页最大条数
+//
+// 返回:
+//   - LogExportPage: 本页范围，没有数据时 Rows 为 0
+//   - error: 查询失败时返回错误
+func PrepareLogExportPage(filter model.LogExportFilter, beforeId int, limit int) (LogExportPage, error) {
+	ids, err := model.ExportLogIds(filter, beforeId, limit)
+	if err != nil || len(ids) == 0 {
+		return LogExportPage{Filter: filter, BeforeId: beforeId}, err
+	}
+	// 固定本页的 id 范围，避免写出期间新增的日志使本页与下一页错位
+	filter.MinId = ids[len(ids)-1]
+	page := LogExportPage{Filter: filter, BeforeId: ids[0] + 1, Rows: len(ids)}
+	if len(ids) == limit {
+		page.NextCursor = ids[len(ids)-1]
+	}
+	return page, nil
+}
+
+// StartLogExportJob 创建异步导出任务，导出结果写入临时文件
+func StartLogExportJob(format string, filter model.LogExportFilter) *LogExportJob {
+	cleanupLogExportJobs()
+	job := &LogExportJob{
+		Id:        common.GetUUID(),
+		Format:    format,
+		Status:    "running",
+		CreatedAt: time.Now().Unix(),
+	}
+	job.filePath = filepath.Join(os.TempDir(), fmt.Sprintf("new-api-log-export-%s.%s", job.Id, format))
+
+	logExportJobsMutex.Lock()
+	logExportJobs[job.Id] = job
+	logExportJobsMutex.Unlock()
+
+	gopool.Go(func() {
+		rows, err := runLogExportJob(job, filter)
+		logExportJobsMutex.Lock()
+		defer logExportJobsMutex.Unlock()
+		job.Rows = rows
+		if err != nil {
+			job.Status = "failed"
+			job.Error = err.Error()
+			common.SysError(fmt.Sprintf("log export job %s failed: %s", job.Id, err.Error()))
+			return
+		}
+		job.Status = "completed"
+	})
+	return job
+}
+
+func runLogExportJob(job *LogExportJob, filter model.LogExportFilter) (int, error) {
+	file, err := os.Create(job.filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	writer := NewLogExportWriter(job.Format, file)
+	if err = writer.WriteHeader(); err != nil {
+		return 0, err
+	}
+	rows, _, err := ExportLogsTo(writer, filter, 0, logExportMaxAsyncRows)
+	return rows, err
+}
+
+// GetLogExportJob 获取异步导出任务，返回任务副本及结果文件路径
+func GetLogExportJob(id string) (LogExportJob, string, bool) {
+	logExportJobsMutex.RLock()
+	defer logExportJobsMutex.RUnlock()
+	job, ok := logExportJobs[id]
+	if !ok {
+		return LogExportJob{}, "", false
+	}
+	return *job, job.filePath, true
+}
+
+// cleanupLogExportJobs 清理过期的导出任务及其文件
+func cleanupLogExportJobs() {
+	expireBefore := time.Now().Add(-logExportJobRetention).Unix()
+	logExportJobsMutex.Lock()
+	defer logExportJobsMutex.Unlock()
+	for id, job := range logExportJobs {
+		if job.Status != "running" && job.CreatedAt < expireBefore {
+			_ = os.Remove(job.filePath)
+			delete(logExportJobs, id)
+		}
+	}
+}
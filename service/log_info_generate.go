@@ -45,11 +45,30 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	if relayInfo.ReasoningEffort != "" {
 		other["reasoning_effort"] = relayInfo.ReasoningEffort
 	}
+	if relayInfo.PromptTokenBreakdown != nil {
+		other["prompt_tokens_breakdown"] = relayInfo.PromptTokenBreakdown
+	}
 	if relayInfo.IsModelMapped {
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
 
+	if relayInfo.Experiment != "" {
+		other["experiment"] = relayInfo.Experiment
+		other["experiment_arm"] = relayInfo.ExperimentArm
+	}
+
+	if relayInfo.IsConverted() {
+		other["converted_from"] = string(relayInfo.ConversionSource)
+		if len(relayInfo.ConversionNotes) > 0 {
+			other["conversion_notes"] = relayInfo.ConversionNotes
+		}
+	}
+
+	if relayInfo.SmartRoutingFallback {
+		other["smart_routing_fallback"] = true
+	}
+
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
 		other["is_system_prompt_overwritten"] = true
@@ -57,14 +76,23 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 
 	// 添加请求体和响应体到日志中
 	if relayInfo.RequestBody != "" {
-		other["request_body"] = relayInfo.RequestBody
+		applyLogBodyStorageLimit(other, "request_body", relayInfo.RequestBody, 1)
 	}
-	if relayInfo.ResponseBody != "" {
-		other["response_body"] = relayInfo.ResponseBody
+	if relayInfo.ResponseBody != "" && !skipStreamResponseBody(relayInfo) {
+		applyLogBodyStorageLimit(other, "response_body", relayInfo.ResponseBody, bodyLimitMultiplier(relayInfo))
 	}
 
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
+	if len(relayInfo.Attempts) > 0 {
+		adminInfo["attempts"] = relayInfo.Attempts
+		// 失败尝试的估算成本合计，与本次实际扣费的额度分开记录
+		attemptsQuota := 0
+		for _, attempt := range relayInfo.Attempts {
+			attemptsQuota += attempt.Quota
+		}
+		adminInfo["attempts_quota"] = attemptsQuota
+	}
 	isMultiKey := common.GetContextKeyBool(ctx, constant.ContextKeyChannelIsMultiKey)
 	if isMultiKey {
 		adminInfo["is_multi_key"] = true
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResolveModelGroup 将模型组名称解析为具体模型并选择渠道
+// 按模型组的回退阶梯依次尝试，跳过令牌无权访问的模型，返回第一个存在可用渠道的模型
+// 返回:
+//   - string: 解析得到的具体模型
+//   - *model.Channel: 选中的渠道
+//   - string: 实际使用的分组
+//   - error: 所有模型均无可用渠道时返回错误
+func ResolveModelGroup(c *gin.Context, group string, groupName string, policy *model_setting.ModelGroupPolicy) (string, *model.Channel, string, error) {
+	role := common.GetContextKeyInt(c, constant.ContextKeyUserRole)
+	for _, modelName := range policy.Models {
+		if !IsValidationModelAllowed(modelName, role) || !isTokenModelAllowed(c, modelName) {
+			continue
+		}
+		channel, selectGroup, err := CacheGetModelGroupChannel(c, group, modelName, policy, 0)
+		if err != nil {
+			return "", nil, selectGroup, err
+		}
+		if channel != nil {
+			logger.LogDebug(c, fmt.Sprintf("model group %s resolved to %s", groupName, modelName))
+			return modelName, channel, selectGroup, nil
+		}
+	}
+	return "", nil, group, types.NewLocalizedError(types.ErrMsgModelGroupNoAvailableModel, groupName, strings.Join(policy.Models, ", "))
+}
+
+// isTokenModelAllowed 判断令牌的模型限制是否允许访问指定模型，未启用模型限制时允许
+func isTokenModelAllowed(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		return false
+	}
+	tokenModelLimit, _ := s.(map[string]bool)
+	return tokenModelLimit[ratio_setting.FormatMatchingModelName(modelName)]
+}
+
+// CacheGetModelGroupChannel 按模型组的路由策略为具体模型选择渠道，分组为 auto 时依次尝试用户的自动分组
+// 用户或令牌绑定了渠道分组时只在绑定的分组内选择
+func CacheGetModelGroupChannel(c *gin.Context, group string, modelName string, policy *model_setting.ModelGroupPolicy, retry int) (*model.Channel, string, error) {
+	if boundGroup, ok := GetBoundChannelGroup(c); ok {
+		channel, err := selectModelGroupChannel(c, boundGroup, modelName, policy, retry)
+		if channel != nil && group == "auto" {
+			// 自动分组请求按实际选择的绑定分组计费
+			c.Set("auto_group", boundGroup)
+		}
+		return channel, boundGroup, err
+	}
+	if group != "auto" {
+		channel, err := selectModelGroupChannel(c, group, modelName, policy, retry)
+		return channel, group, err
+	}
+	if len(setting.GetAutoGroups()) == 0 {
+		return nil, group, errors.New("auto groups is not enabled")
+	}
+	userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	for _, autoGroup := range GetUserAutoGroup(userGroup) {
+		channel, _ := selectModelGroupChannel(c, autoGroup, modelName, policy, retry)
+		if channel != nil {
+			c.Set("auto_group", autoGroup)
+			return channel, autoGroup, nil
+		}
+	}
+	return nil, group, nil
+}
+
+// selectModelGroupChannel 在分组内按优先渠道类型筛选渠道，再按优先级与权重随机选择
+func selectModelGroupChannel(c *gin.Context, group string, modelName string, policy *model_setting.ModelGroupPolicy, retry int) (*model.Channel, error) {
+	channels, err := model.GetSatisfiedChannels(group, modelName)
+	if err != nil {
+		return nil, err
+	}
+	allowResponses := policy.SmartRoutingEnabled || strings.HasPrefix(c.Request.URL.Path, "/v1/responses")
+	preferredChannelTypes := policy.PreferredChannelTypes
+	// A/B 实验分组指定的渠道类型优先于模型组配置的渠道类型
+	if experimentChannelType := GetExperimentChannelType(c); experimentChannelType != 0 {
+		preferredChannelTypes = append([]int{experimentChannelType}, preferredChannelTypes...)
+	}
+	// 无法处理该请求的渠道不参与选择，回退阶梯中的下一个模型继续尝试
+	candidates := modelGroupCandidateChannels(channels, allowResponses, preferredChannelTypes, newChannelRequestFilter(c).accepts)
+	return pickChannelByPriority(candidates, retry), nil
+}
+
+// modelGroupCandidateChannels 按模型组的路由策略筛选参与选择的渠道
+// 参数:
+//   - channels: 分组下支持该模型的渠道
+//   - allowResponses: 是否允许选择 Responses 渠道
+//   - preferredChannelTypes: 优先渠道类型，按顺序取第一个存在可用渠道的类型
+//   - accepts: 判断渠道能否处理该请求，为 nil 时不按请求筛选
+//
+// 返回:
+//   - []*model.Channel: 参与选择的渠道，存在优先类型的渠道时只包含该类型
+func modelGroupCandidateChannels(channels []*model.Channel, allowResponses bool, preferredChannelTypes []int, accepts func(*model.Channel) bool) []*model.Channel {
+	candidates := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Type == constant.ChannelTypeOpenAIResponses && !allowResponses {
+			continue
+		}
+		if accepts != nil && !accepts(channel) {
+			continue
+		}
+		candidates = append(candidates, channel)
+	}
+	for _, channelType := range preferredChannelTypes {
+		var preferred []*model.Channel
+		for _, channel := range candidates {
+			if channel.Type == channelType {
+				preferred = append(preferred, channel)
+			}
+		}
+		if len(preferred) > 0 {
+			return preferred
+		}
+	}
+	return candidates
+}
+
+// pickChannelByPriority 按重试次数选择优先级层级，并在该层级内按权重随机选择渠道
+func pickChannelByPriority(channels []*model.Channel, retry int) *model.Channel {
+	return pickChannelByWeight(channelsAtPriorityRank(channels, retry))
+}
+
+// channelsAtPriorityRank 返回优先级从高到低第 rank 层的渠道，rank 超出层数时返回最低的一层
+func channelsAtPriorityRank(channels []*model.Channel, rank int) []*model.Channel {
+	if len(channels) == 0 {
+		return nil
+	}
+	uniquePriorities := make(map[int64]bool)
+	for _, channel := range channels {
+		uniquePriorities[channel.GetPriority()] = true
+	}
+	priorities := make([]int64, 0, len(uniquePriorities))
+	for priority := range uniquePriorities {
+		priorities = append(priorities, priority)
+	}
+	sort.Slice(priorities, func(i, j int) bool {
+		return priorities[i] > priorities[j]
+	})
+	if rank >= len(priorities) {
+		rank = len(priorities) - 1
+	}
+	targetPriority := priorities[rank]
+
+	var targetChannels []*model.Channel
+	for _, channel := range channels {
+		if channel.GetPriority() == targetPriority {
+			targetChannels = append(targetChannels, channel)
+		}
+	}
+	return targetChannels
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// OutputTokenBudget 流式输出过程中实时统计已输出的 token 数，达到上限后由调用方终止流
+type OutputTokenBudget struct {
+	limit int
+	used  int
+	model string
+}
+
+// NewOutputTokenBudget 根据 RelayInfo 创建输出预算，未设置上限时返回 nil
+func NewOutputTokenBudget(info *relaycommon.RelayInfo) *OutputTokenBudget {
+	if info == nil || info.OutputTokenBudget <= 0 {
+		return nil
+	}
+	return &OutputTokenBudget{
+		limit: info.OutputTokenBudget,
+		model: info.UpstreamModelName,
+	}
+}
+
+// Consume 累计一段输出文本的 token 数
+// 返回:
+//   - bool: 累计后是否仍未超出上限，返回 false 时调用方应停止转发该段文本并终止流
+func (b *OutputTokenBudget) Consume(text string) bool {
+	if b == nil {
+		return true
+	}
+	tokens := CountTextToken(text, b.model)
+	if b.used+tokens > b.limit {
+		return false
+	}
+	b.used += tokens
+	return true
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// PricingEstimateRequest 费用预估请求
+type PricingEstimateRequest struct {
+	Model        string          `json:"model"`          // 目标模型，为空时使用 request 中的模型
+	Group        string          `json:"group"`          // 计费分组，为空时使用用户分组
+	Format       string          `json:"format"`         // request 的格式：openai（默认）、claude、openai_responses
+	Request      json.RawMessage `json:"request"`        // 请求体，用于统计提示词 token 及读取 max_tokens
+	PromptTokens int             `json:"prompt_tokens"`  // 指定提示词 token 数，大于 0 时不再从 request 统计
+	MaxTokens    int             `json:"max_tokens"`     // 指定补全 token 数，大于 0 时覆盖 request 中的 max_tokens
+	CacheHitRate float64         `json:"cache_hit_rate"` // 假设命中缓存的提示词比例，0-1
+	Images       int             `json:"images"`         // 按次计费模型的生成张数，默认 1
+}
+
+// PricingEstimateItem 费用预估的单项明细
+type PricingEstimateItem struct {
+	Tokens int     `json:"tokens"`
+	Ratio  float64 `json:"ratio"` // 相对模型倍率的附加倍率，例如补全倍率、缓存倍率
+	Quota  int     `json:"quota"`
+	Cost   float64 `json:"cost"` // 美元
+}
+
+// PricingEstimate 费用预估结果
+type PricingEstimate struct {
+	Model           string              `json:"model"`
+	Group           string              `json:"group"`
+	GroupRatio      float64             `json:"group_ratio"`
+	UsePrice        bool                `json:"use_price"`
+	ModelPrice      float64             `json:"model_price,omitempty"`
+	ModelRatio      float64             `json:"model_ratio,omitempty"`
+	CompletionRatio float64             `json:"completion_ratio,omitempty"`
+	Prompt          PricingEstimateItem `json:"prompt"`
+	CachedPrompt    PricingEstimateItem `json:"cached_prompt"`
+	Images          PricingEstimateItem `json:"images"`
+	Completion      PricingEstimateItem `json:"completion"`
+	TotalQuota      int                 `json:"total_quota"`
+	TotalCost       float64             `json:"total_cost"`
+	// MaxTokensDefaulted 请求未指定 max_tokens，补全部分按模型默认的 max_tokens 估算
+	MaxTokensDefaulted bool `json:"max_tokens_defaulted,omitempty"`
+}
+
+// EstimatePricing 使用当前定价配置预估一次请求的费用
+// 补全部分按 max_tokens 全部用满计算，为费用上限；未指定 max_tokens 时按模型默认的 max_tokens 估算。
+// 提示词 token 始终按请求内容估算，不受 CountToken 开关影响
+func EstimatePricing(c *gin.Context, userGroup string, req *PricingEstimateRequest) (*PricingEstimate, error) {
+	if req.CacheHitRate < 0 || req.CacheHitRate > 1 {
+		return nil, errors.New("cache_hit_rate 必须在 0 到 1 之间")
+	}
+	promptTokens, maxTokens, breakdown, modelName, err := countEstimateRequestTokens(c, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Model != "" {
+		modelName = req.Model
+	}
+	if modelName == "" {
+		return nil, errors.New("model 不能为空")
+	}
+	if req.PromptTokens > 0 {
+		promptTokens = req.PromptTokens
+		breakdown = nil
+	}
+	if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
+	}
+	maxTokensDefaulted := false
+	if maxTokens <= 0 {
+		maxTokens = model_setting.GetClaudeSettings().GetDefaultMaxTokens(modelName)
+		if limit := model_setting.GetGlobalSettings().MaxOutputTokens; limit > 0 {
+			maxTokens = min(maxTokens, limit)
+		}
+		maxTokensDefaulted = true
+	}
+
+	group := req.Group
+	if group == "" {
+		group = userGroup
+	}
+	if _, ok := GetUserUsableGroups(userGroup)[group]; !ok {
+		return nil, fmt.Errorf("分组 %s 不可用", group)
+	}
+	groupRatio, ok := ratio_setting.GetGroupGroupRatio(userGroup, group)
+	if !ok {
+		groupRatio = ratio_setting.GetGroupRatio(group)
+	}
+
+	estimate := &PricingEstimate{
+		Model:              modelName,
+		Group:              group,
+		GroupRatio:         groupRatio,
+		MaxTokensDefaulted: maxTokensDefaulted,
+	}
+	dGroupRatio := decimal.NewFromFloat(groupRatio)
+
+	modelPrice, usePrice := ratio_setting.GetModelPrice(modelName, false)
+	if usePrice {
+		images := req.Images
+		if images <= 0 {
+			images = 1
+		}
+		estimate.UsePrice = true
+		estimate.ModelPrice = modelPrice
+		estimate.TotalQuota = int(decimal.NewFromFloat(modelPrice).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).
+			Mul(dGroupRatio).Mul(decimal.NewFromInt(int64(images))).Round(0).IntPart())
+		estimate.TotalCost = quotaToCost(estimate.TotalQuota)
+		return estimate, nil
+	}
+
+	modelRatio, success, matchName := ratio_setting.GetModelRatio(modelName)
+	if !success {
+		return nil, fmt.Errorf("模型 %s 倍率或价格未配置", matchName)
+	}
+	estimate.ModelRatio = modelRatio
+	estimate.CompletionRatio = ratio_setting.GetCompletionRatio(modelName)
+	ratio := decimal.NewFromFloat(modelRatio).Mul(dGroupRatio)
+
+	cachedTokens := int(float64(promptTokens) * req.CacheHitRate)
+	baseTokens := promptTokens - cachedTokens
+	// 配置了图片倍率时，图片 token 单独计价
+	imageRatio, hasImageRatio := ratio_setting.GetImageRatio(modelName)
+	if hasImageRatio && breakdown != nil && breakdown.Images > 0 {
+		imageTokens := min(breakdown.Images, baseTokens)
+		estimate.Images = newPricingEstimateItem(imageTokens, imageRatio, ratio)
+		baseTokens -= imageTokens
+	}
+	cacheRatio, _ := ratio_setting.GetCacheRatio(modelName)
+	estimate.CachedPrompt = newPricingEstimateItem(cachedTokens, cacheRatio, ratio)
+	estimate.Prompt = newPricingEstimateItem(baseTokens, 1, ratio)
+	estimate.Completion = newPricingEstimateItem(maxTokens, estimate.CompletionRatio, ratio)
+
+	estimate.TotalQuota = estimate.Prompt.Quota + estimate.CachedPrompt.Quota + estimate.Images.Quota + estimate.Completion.Quota
+	estimate.TotalCost = quotaToCost(estimate.TotalQuota)
+	return estimate, nil
+}
+
+// countEstimateRequestTokens 按请求格式统计提示词 token 数并读取 max_tokens 与模型名
+func countEstimateRequestTokens(c *gin.Context, req *PricingEstimateRequest) (int, int, *types.PromptTokenBreakdown, string, error) {
+	if len(req.Request) == 0 {
+		return 0, 0, nil, "", nil
+	}
+	format := types.RelayFormat(req.Format)
+	if format == "" {
+		format = types.RelayFormatOpenAI
+	}
+	var request dto.Request
+	var modelName string
+	switch format {
+	case types.RelayFormatOpenAI:
+		openaiRequest := &dto.GeneralOpenAIRequest{}
+		if err := common.Unmarshal(req.Request, openaiRequest); err != nil {
+			return 0, 0, nil, "", err
+		}
+		request, modelName = openaiRequest, openaiRequest.Model
+	case types.RelayFormatClaude:
+		claudeRequest := &dto.ClaudeRequest{}
+		if err := common.Unmarshal(req.Request, claudeRequest); err != nil {
+			return 0, 0, nil, "", err
+		}
+		request, modelName = claudeRequest, claudeRequest.Model
+	case types.RelayFormatOpenAIResponses:
+		responsesRequest := &dto.OpenAIResponsesRequest{}
+		if err := common.Unmarshal(req.Request, responsesRequest); err != nil {
+			return 0, 0, nil, "", err
+		}
+		request, modelName = responsesRequest, responsesRequest.Model
+	default:
+		return 0, 0, nil, "", fmt.Errorf("不支持的请求格式 %s", req.Format)
+	}
+	if req.Model != "" {
+		modelName = req.Model
+	}
+
+	meta := request.GetTokenCountMeta()
+	info := &relaycommon.RelayInfo{RelayFormat: format}
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	// 关闭 CountToken 时转发不统计提示词，预估仍需按请求内容估算
+	promptTokens, err := countRequestToken(c, meta, info)
+	if err != nil {
+		return 0, 0, nil, "", err
+	}
+	return promptTokens, meta.MaxTokens, info.PromptTokenBreakdown, modelName, nil
+}
+
+func newPricingEstimateItem(tokens int, itemRatio float64, ratio decimal.Decimal) PricingEstimateItem {
+	quota := decimal.NewFromInt(int64(tokens)).Mul(decimal.NewFromFloat(itemRatio)).Mul(ratio).Round(0).IntPart()
+	return PricingEstimateItem{
+		Tokens: tokens,
+		Ratio:  itemRatio,
+		Quota:  int(quota),
+		Cost:   quotaToCost(int(quota)),
+	}
+}
+
+func quotaToCost(quota int) float64 {
+	return decimal.NewFromInt(int64(quota)).Div(decimal.NewFromFloat(common.QuotaPerUnit)).InexactFloat64()
+}
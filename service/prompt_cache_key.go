@@ -0,0 +1,159 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const promptCacheKeyPrefix = "nx-"
+
+// ApplyPromptCacheKey 按 prompt_cache_key_mode 为转换后的 Responses 请求注入会话级缓存标识
+// 客户端已提供对应字段时保持不变。sessionHint 为客户端提供的会话标识，例如 Claude 的 metadata.user_id，
+// 为空时使用 instructions 与首条输入推导，同一会话的多轮请求前缀一致，因此得到相同的标识
+func ApplyPromptCacheKey(c *gin.Context, responsesReq *dto.OpenAIResponsesRequest, sessionHint string) {
+	mode := model_setting.GetResponsesSettings().PromptCacheKeyMode
+	switch mode {
+	case model_setting.PromptCacheKeyModePromptCacheKey:
+		if len(responsesReq.PromptCacheKey) > 0 {
+			return
+		}
+	case model_setting.PromptCacheKeyModeUser:
+		if responsesReq.User != "" {
+			return
+		}
+	default:
+		return
+	}
+
+	key := buildPromptCacheKey(c, responsesReq, sessionHint)
+	if key == "" {
+		return
+	}
+	if mode == model_setting.PromptCacheKeyModeUser {
+		responsesReq.User = key
+		return
+	}
+	keyData, err := common.Marshal(key)
+	if err != nil {
+		return
+	}
+	responsesReq.PromptCacheKey = keyData
+}
+
+// buildPromptCacheKey 生成不可逆的缓存标识，按令牌隔离，避免不同用户共享同一标识
+func buildPromptCacheKey(c *gin.Context, responsesReq *dto.OpenAIResponsesRequest, sessionHint string) string {
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	source := sessionHint
+	if source == "" {
+		var inputs []json.RawMessage
+		if err := common.Unmarshal(responsesReq.Input, &inputs); err != nil || len(inputs) == 0 {
+			return ""
+		}
+		source = string(responsesReq.Instructions) + "\n" + string(inputs[0])
+	}
+	hash := common.GenerateHMAC(fmt.Sprintf("%d:%s:%s", tokenId, responsesReq.Model, source))
+	return promptCacheKeyPrefix + hash[:32]
+}
+
+// ApplyClaudeCacheControl 将 Claude 请求中的 cache_control 标记转换为 Responses 的提示词缓存参数
+// Responses 按前缀自动缓存，无法指定缓存断点，因此以首个断点之前的内容（tools、system、messages 依次排列）
+// 推导缓存标识，使共享同一缓存前缀的请求落到同一缓存；断点要求 1 小时缓存时请求延长缓存保留时间。
+// 客户端或 prompt_cache_key_mode 已设置对应字段时保持不变
+// 参数:
+//   - c: Gin 上下文
+//   - info: 转发信息
+//   - responsesReq: 转换后的 Responses 请求
+func ApplyClaudeCacheControl(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return
+	}
+	prefix, extended := claudeCachePrefix(body)
+	if prefix == "" {
+		return
+	}
+
+	var applied []string
+	useUser := model_setting.GetResponsesSettings().PromptCacheKeyMode == model_setting.PromptCacheKeyModeUser
+	if (useUser && responsesReq.User == "") || (!useUser && len(responsesReq.PromptCacheKey) == 0) {
+		tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+		hash := common.GenerateHMAC(fmt.Sprintf("%d:%s:cache_control:%s", tokenId, responsesReq.Model, prefix))
+		key := promptCacheKeyPrefix + hash[:32]
+		if useUser {
+			responsesReq.User = key
+			applied = append(applied, "user")
+		} else if keyData, err := common.Marshal(key); err == nil {
+			responsesReq.PromptCacheKey = keyData
+			applied = append(applied, "prompt_cache_key")
+		}
+	}
+	if extended && len(responsesReq.PromptCacheRetention) == 0 {
+		responsesReq.PromptCacheRetention = json.RawMessage(`"24h"`)
+		applied = append(applied, "prompt_cache_retention")
+	}
+	if len(applied) > 0 {
+		info.AddConversionNote("cache_control mapped to " + strings.Join(applied, ", "))
+	}
+}
+
+// claudeCachePrefix 按 Claude 缓存前缀的顺序拼接首个 cache_control 断点及之前的内容，没有断点时返回空
+// extended 表示任一断点要求 1 小时缓存
+func claudeCachePrefix(body []byte) (prefix string, extended bool) {
+	var parts []string
+	found := false
+	visit := func(value gjson.Result) {
+		if found {
+			return
+		}
+		parts = append(parts, value.Raw)
+		if value.Get("cache_control").Exists() {
+			found = true
+		}
+	}
+	request := gjson.ParseBytes(body)
+	request.Get("tools").ForEach(func(_, tool gjson.Result) bool {
+		visit(tool)
+		return true
+	})
+	if system := request.Get("system"); system.IsArray() {
+		system.ForEach(func(_, block gjson.Result) bool {
+			visit(block)
+			return true
+		})
+	} else if system.Exists() {
+		visit(system)
+	}
+	request.Get("messages").ForEach(func(_, message gjson.Result) bool {
+		content := message.Get("content")
+		if !content.IsArray() {
+			visit(message)
+			return true
+		}
+		visit(gjson.Parse(`{"role":` + message.Get("role").Raw + `}`))
+		content.ForEach(func(_, block gjson.Result) bool {
+			visit(block)
+			return true
+		})
+		return true
+	})
+	if !found {
+		return "", false
+	}
+	// 任一断点要求 1 小时缓存即可，不限于首个断点
+	for _, path := range []string{"tools.#.cache_control.ttl", "system.#.cache_control.ttl", "messages.#.content.#.cache_control.ttl"} {
+		if strings.Contains(request.Get(path).Raw, `"1h"`) {
+			extended = true
+		}
+	}
+	return strings.Join(parts, "\n"), extended
+}
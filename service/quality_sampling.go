@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+const (
+	// qualitySampleBodyLimit 样本中单个请求/响应体保留的最大字节数
+	qualitySampleBodyLimit = 64 * 1024
+)
+
+const (
+	QualitySampleVerdictPending  = "pending"
+	QualitySampleVerdictGood     = "good"
+	QualitySampleVerdictDegraded = "degraded"
+)
+
+// QualitySample 转换请求的质量抽样样本，内容已脱敏，不记录用户与令牌信息
+type QualitySample struct {
+	Id               int64                        `json:"id"`
+	ChannelId        int                          `json:"channel_id"`
+	ModelName        string                       `json:"model_name"`
+	UpstreamModel    string                       `json:"upstream_model"`
+	ConvertedFrom    relaycommon.ConversionSource `json:"converted_from"`
+	IsStream         bool                         `json:"is_stream"`
+	OriginalRequest  string                       `json:"original_request"`
+	ConvertedRequest string                       `json:"converted_request"`
+	Response         string                       `json:"response"` // 上游 Responses 响应，流式请求为原始事件流
+	Verdict          string                       `json:"verdict"`
+	Note             string                       `json:"note"`
+	CreatedAt        int64                        `json:"created_at"`
+	ReviewedAt       int64                        `json:"reviewed_at"`
+}
+
+var (
+	qualitySamples      []QualitySample
+	qualitySampleNextId int64
+	qualitySampleMutex  sync.RWMutex
+)
+
+var (
+	// qualitySampleIdentityPattern 匹配请求中标识终端用户或会话的字段
+	qualitySampleIdentityPattern = regexp.MustCompile(`"(user|user_id|safety_identifier|prompt_cache_key)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	qualitySampleEmailPattern    = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	qualitySampleKeyPattern      = regexp.MustCompile(`\b(sk|pk|rk)-[A-Za-z0-9_\-]{16,}`)
+	qualitySamplePhonePattern    = regexp.MustCompile(`\+\d[\d\- ]{7,}\d|\b\d{3}[\- ]\d{3,4}[\- ]\d{4}\b`)
+	qualitySampleIPPattern       = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+)
+
+// anonymizeQualitySample 移除样本中的用户标识、邮箱、密钥、电话与 IP 地址，并截断过长的内容
+func anonymizeQualitySample(body string) string {
+	if len(body) > qualitySampleBodyLimit {
+		body = body[:qualitySampleBodyLimit]
+	}
+	body = qualitySampleIdentityPattern.ReplaceAllString(body, `"$1"$2"[redacted]"`)
+	body = qualitySampleEmailPattern.ReplaceAllString(body, "[email]")
+	body = qualitySampleKeyPattern.ReplaceAllString(body, "[key]")
+	body = qualitySampleIPPattern.ReplaceAllString(body, "[ip]")
+	body = qualitySamplePhonePattern.ReplaceAllString(body, "[phone]")
+	return body
+}
+
+// SampleConvertedExchange 按配置的比例抽样转换成功的请求，将脱敏后的原始请求、转换后请求与上游响应加入审核队列
+// 参数:
+//   - info: 请求上下文信息，未经过格式转换的请求不会被抽样
+//   - convertedRequest: 实际发送给上游的请求体
+func SampleConvertedExchange(info *relaycommon.RelayInfo, convertedRequest []byte) {
+	settings := model_setting.GetResponsesSettings()
+	if !info.IsConverted() || settings.QualitySamplingPercent <= 0 || settings.QualitySamplingQueueSize <= 0 {
+		return
+	}
+	if rand.Float64()*100 >= settings.QualitySamplingPercent {
+		return
+	}
+
+	sample := QualitySample{
+		ModelName:        info.OriginModelName,
+		UpstreamModel:    info.UpstreamModelName,
+		ConvertedFrom:    info.ConversionSource,
+		IsStream:         info.IsStream,
+		OriginalRequest:  anonymizeQualitySample(info.RequestBody),
+		ConvertedRequest: anonymizeQualitySample(string(convertedRequest)),
+		Response:         anonymizeQualitySample(info.ResponseBody),
+		Verdict:          QualitySampleVerdictPending,
+		CreatedAt:        time.Now().Unix(),
+	}
+	if info.ChannelMeta != nil {
+		sample.ChannelId = info.ChannelId
+	}
+
+	qualitySampleMutex.Lock()
+	defer qualitySampleMutex.Unlock()
+	qualitySampleNextId++
+	sample.Id = qualitySampleNextId
+	qualitySamples = append(qualitySamples, sample)
+	if len(qualitySamples) > settings.QualitySamplingQueueSize {
+		qualitySamples = qualitySamples[len(qualitySamples)-settings.QualitySamplingQueueSize:]
+	}
+}
+
+// GetQualitySamples 获取审核队列中的样本，按时间从新到旧排列
+// 参数:
+//   - verdict: 按审核结论筛选，为空时返回全部样本
+//   - channelId: 按渠道筛选，为 0 时不筛选
+func GetQualitySamples(verdict string, channelId int) []QualitySample {
+	qualitySampleMutex.RLock()
+	defer qualitySampleMutex.RUnlock()
+	samples := make([]QualitySample, 0, len(qualitySamples))
+	for i := len(qualitySamples) - 1; i >= 0; i-- {
+		sample := qualitySamples[i]
+		if verdict != "" && sample.Verdict != verdict {
+			continue
+		}
+		if channelId != 0 && sample.ChannelId != channelId {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// GetQualitySample 根据 ID 获取单个样本
+func GetQualitySample(id int64) (QualitySample, bool) {
+	qualitySampleMutex.RLock()
+	defer qualitySampleMutex.RUnlock()
+	for _, sample := range qualitySamples {
+		if sample.Id == id {
+			return sample, true
+		}
+	}
+	return QualitySample{}, false
+}
+
+// ReviewQualitySample 记录人工审核结论
+func ReviewQualitySample(id int64, verdict string, note string) (QualitySample, error) {
+	switch verdict {
+	case QualitySampleVerdictPending, QualitySampleVerdictGood, QualitySampleVerdictDegraded:
+	default:
+		return QualitySample{}, fmt.Errorf("invalid verdict: %s", verdict)
+	}
+	qualitySampleMutex.Lock()
+	defer qualitySampleMutex.Unlock()
+	for i := range qualitySamples {
+		if qualitySamples[i].Id == id {
+			qualitySamples[i].Verdict = verdict
+			qualitySamples[i].Note = note
+			qualitySamples[i].ReviewedAt = time.Now().Unix()
+			return qualitySamples[i], nil
+		}
+	}
+	return QualitySample{}, fmt.Errorf("sample %d not found", id)
+}
+
+// DeleteQualitySample 从审核队列中删除样本
+func DeleteQualitySample(id int64) bool {
+	qualitySampleMutex.Lock()
+	defer qualitySampleMutex.Unlock()
+	for i := range qualitySamples {
+		if qualitySamples[i].Id == id {
+			qualitySamples = append(qualitySamples[:i], qualitySamples[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GetQualitySampleSummary 按“渠道ID:模型”统计各审核结论的样本数
+func GetQualitySampleSummary() map[string]map[string]int {
+	qualitySampleMutex.RLock()
+	defer qualitySampleMutex.RUnlock()
+	summary := make(map[string]map[string]int)
+	for _, sample := range qualitySamples {
+		key := fmt.Sprintf("%d:%s", sample.ChannelId, sample.ModelName)
+		if summary[key] == nil {
+			summary[key] = make(map[string]int)
+		}
+		summary[key][sample.Verdict]++
+	}
+	return summary
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
 	"github.com/QuantumNous/new-api/types"
@@ -209,8 +210,11 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 		logger.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, relayInfo.FinalPreConsumedQuota))
 	} else {
-		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		userId, channelId, usedQuota := relayInfo.UserId, relayInfo.ChannelId, quota
+		model.SubmitBillingWrite(func() {
+			model.UpdateUserUsedQuotaAndRequestCount(userId, usedQuota)
+			model.UpdateChannelUsedQuota(channelId, usedQuota)
+		})
 	}
 
 	logModel := modelName
@@ -237,6 +241,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
 
+	RecordTokenRateUsage(relayInfo, usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
@@ -289,6 +294,13 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		calculateQuota = 1
 	}
 
+	// 转换请求中上游调用了 image_generation 工具时按次计费，与 OpenAI 格式请求一致
+	var imageGenerationCallPrice float64
+	if ctx.GetBool("image_generation_call") {
+		imageGenerationCallPrice = operation_setting.GetGPTImage1PriceOnceCall(ctx.GetString("image_generation_call_quality"), ctx.GetString("image_generation_call_size"))
+		calculateQuota += imageGenerationCallPrice * groupRatio * common.QuotaPerUnit
+	}
+
 	quota := int(calculateQuota)
 
 	totalTokens := promptTokens + completionTokens
@@ -303,8 +315,11 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		logger.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, relayInfo.FinalPreConsumedQuota))
 	} else {
-		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		userId, channelId, usedQuota := relayInfo.UserId, relayInfo.ChannelId, quota
+		model.SubmitBillingWrite(func() {
+			model.UpdateUserUsedQuotaAndRequestCount(userId, usedQuota)
+			model.UpdateChannelUsedQuota(channelId, usedQuota)
+		})
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -324,10 +339,13 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	}
 
 	if quotaDelta != 0 {
-		err := PostConsumeQuota(relayInfo, quotaDelta, relayInfo.FinalPreConsumedQuota, true)
-		if err != nil {
-			logger.LogError(ctx, "error consuming token remain quota: "+err.Error())
-		}
+		// 写入池启用时异步扣费，请求结束后执行，不能再使用 ctx 记录日志
+		preConsumedQuota := relayInfo.FinalPreConsumedQuota
+		model.SubmitBillingWrite(func() {
+			if err := PostConsumeQuota(relayInfo, quotaDelta, preConsumedQuota, true); err != nil {
+				common.SysError(fmt.Sprintf("error consuming token remain quota: user_id=%d, token_id=%d, error=%v", relayInfo.UserId, relayInfo.TokenId, err))
+			}
+		})
 	}
 
 	other := GenerateClaudeOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio,
@@ -336,6 +354,10 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		cacheCreationTokens5m, cacheCreationRatio5m,
 		cacheCreationTokens1h, cacheCreationRatio1h,
 		modelPrice, relayInfo.PriceData.GroupRatioInfo.GroupSpecialRatio)
+	if imageGenerationCallPrice > 0 {
+		other["image_generation_call"] = true
+		other["image_generation_call_price"] = imageGenerationCallPrice
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
@@ -428,8 +450,11 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 		logger.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, relayInfo.OriginModelName, relayInfo.FinalPreConsumedQuota))
 	} else {
-		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		userId, channelId, usedQuota := relayInfo.UserId, relayInfo.ChannelId, quota
+		model.SubmitBillingWrite(func() {
+			model.UpdateUserUsedQuotaAndRequestCount(userId, usedQuota)
+			model.UpdateChannelUsedQuota(channelId, usedQuota)
+		})
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -449,10 +474,13 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	}
 
 	if quotaDelta != 0 {
-		err := PostConsumeQuota(relayInfo, quotaDelta, relayInfo.FinalPreConsumedQuota, true)
-		if err != nil {
-			logger.LogError(ctx, "error consuming token remain quota: "+err.Error())
-		}
+		// 写入池启用时异步扣费，请求结束后执行，不能再使用 ctx 记录日志
+		preConsumedQuota := relayInfo.FinalPreConsumedQuota
+		model.SubmitBillingWrite(func() {
+			if err := PostConsumeQuota(relayInfo, quotaDelta, preConsumedQuota, true); err != nil {
+				common.SysError(fmt.Sprintf("error consuming token remain quota: user_id=%d, token_id=%d, error=%v", relayInfo.UserId, relayInfo.TokenId, err))
+			}
+		})
 	}
 
 	logModel := relayInfo.OriginModelName
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// ApplyDefaultReasoningEffort 客户端未指定推理强度时，按 default_reasoning_effort 为上游模型补全 reasoning.effort，并记录为转换说明
+// A/B 实验分组指定了推理强度时以实验分组为准
+func ApplyDefaultReasoningEffort(info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) {
+	if ApplyExperimentReasoningEffort(info, responsesReq) {
+		return
+	}
+	if responsesReq.Reasoning != nil && responsesReq.Reasoning.Effort != "" {
+		return
+	}
+	effort, ok := model_setting.GetResponsesSettings().GetDefaultReasoningEffort(responsesReq.Model)
+	if !ok {
+		return
+	}
+	if responsesReq.Reasoning == nil {
+		responsesReq.Reasoning = &dto.Reasoning{}
+	}
+	responsesReq.Reasoning.Effort = effort
+	info.AddConversionNote(fmt.Sprintf("reasoning.effort defaulted to %s for model %s", effort, responsesReq.Model))
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// FillRelayAttemptUsage 估算一次失败渠道尝试的 token 用量及成本，写入尝试记录
+// 上游未开始输出时视为未产生用量；已开始输出时输入 token 全额计入，输出 token 优先取流式计数器的计数，否则按已转发的文本估算
+// 成本按本次请求的价格计算，仅用于审计各次尝试的成本拆分，不向用户扣费
+// 参数:
+//   - info: 当前请求的 RelayInfo，需已完成价格计算
+//   - attempt: 待填充的尝试记录，StreamStarted 需已设置
+func FillRelayAttemptUsage(info *relaycommon.RelayInfo, attempt *relaycommon.RelayAttempt) {
+	if !attempt.StreamStarted {
+		return
+	}
+	attempt.PromptTokens = info.PromptTokens
+	if counter := info.StreamTokenCounter; counter != nil {
+		attempt.CompletionTokens = counter.CountedTokens()
+	} else {
+		attempt.CompletionTokens = CountTextToken(info.AttemptOutputText(), info.OriginModelName)
+	}
+
+	priceData := info.PriceData
+	groupRatio := priceData.GroupRatioInfo.GroupRatio
+	var quota float64
+	if priceData.UsePrice {
+		quota = priceData.ModelPrice * common.QuotaPerUnit * groupRatio
+	} else {
+		quota = (float64(attempt.PromptTokens) + float64(attempt.CompletionTokens)*priceData.CompletionRatio) * priceData.ModelRatio * groupRatio
+	}
+	attempt.Quota = int(quota)
+}
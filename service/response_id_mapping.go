@@ -0,0 +1,239 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseIdMappingKeyPrefix Redis 中保存 ID 映射的 key 前缀
+const responseIdMappingKeyPrefix = "response_id_mapping:"
+
+// responseIdMappingPruneInterval 内存模式下清理过期映射的间隔
+const responseIdMappingPruneInterval = 10 * time.Minute
+
+// responseIdMappingMaxEntries 内存模式下保存的映射数量上限，达到上限后新的上游 ID 不再映射，原样返回给客户端
+const responseIdMappingMaxEntries = 100000
+
+// 参与映射的上游 ID 类型前缀
+var responseIdTypePrefixes = []string{"resp_", "msg_", "chatcmpl-"}
+
+// responseIdFieldPattern 匹配 JSON 中引用响应、消息与输出项 ID 的字段
+var responseIdFieldPattern = regexp.MustCompile(`"(id|response_id|item_id|previous_response_id|responseId)"(\s*:\s*)"((?:resp_|msg_|chatcmpl-)[A-Za-z0-9_-]+)"`)
+
+// ResponseIdMapping 网关 ID 对应的上游 ID 及生成它的渠道，上游 ID 只在创建它的上游账号下有效
+type ResponseIdMapping struct {
+	UpstreamId string `json:"upstream_id"`
+	UserId     int    `json:"user_id"`
+	ChannelId  int    `json:"channel_id"`
+	KeyIndex   int    `json:"key_index"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+var (
+	responseIdMappings      = make(map[string]ResponseIdMapping)
+	responseIdMappingsMutex sync.RWMutex
+	responseIdMappingsOnce  sync.Once
+)
+
+// ResponseIdMapper 将响应中的上游 ID 替换为网关 ID 并保存映射
+// 网关 ID 由上游 ID 签名生成，同一上游 ID 总是得到同一网关 ID，查询已保存的响应时返回的 ID 与创建时一致
+type ResponseIdMapper struct {
+	owner ResponseIdMapping
+	saved map[string]string
+}
+
+// NewResponseIdMapper 为本次请求创建 ID 映射器，未启用 ID 映射时返回 nil，nil 映射器原样返回上游 ID
+func NewResponseIdMapper(info *relaycommon.RelayInfo) *ResponseIdMapper {
+	if !model_setting.GetResponsesSettings().ResponseIdMappingEnabled {
+		return nil
+	}
+	return &ResponseIdMapper{
+		owner: ResponseIdMapping{UserId: info.UserId, ChannelId: info.ChannelId, KeyIndex: info.ChannelMultiKeyIndex},
+		saved: make(map[string]string),
+	}
+}
+
+// NewResponseIdMapperFor 创建与已有映射属于同一用户与渠道的 ID 映射器，用于转发查询与取消响应的结果
+func NewResponseIdMapperFor(mapping *ResponseIdMapping) *ResponseIdMapper {
+	if !model_setting.GetResponsesSettings().ResponseIdMappingEnabled {
+		return nil
+	}
+	owner := *mapping
+	owner.UpstreamId = ""
+	return &ResponseIdMapper{owner: owner, saved: make(map[string]string)}
+}
+
+// GatewayId 获取上游 ID 对应的网关 ID，首次出现时保存映射
+func (m *ResponseIdMapper) GatewayId(upstreamId string) string {
+	if m == nil || upstreamId == "" {
+		return upstreamId
+	}
+	if gatewayId, ok := m.saved[upstreamId]; ok {
+		return gatewayId
+	}
+	typePrefix := responseIdTypePrefix(upstreamId)
+	if typePrefix == "" || isGatewayResponseId(upstreamId) {
+		return upstreamId
+	}
+	gatewayId := typePrefix + model_setting.GetResponsesSettings().ResponseIdPrefix + common.GenerateHMAC("response_id:" + upstreamId)[:48]
+	mapping := m.owner
+	mapping.UpstreamId = upstreamId
+	if !saveResponseIdMapping(gatewayId, mapping) {
+		return upstreamId
+	}
+	m.saved[upstreamId] = gatewayId
+	return gatewayId
+}
+
+// Rewrite 将 JSON 数据中 ID 字段引用的上游 ID 替换为网关 ID
+func (m *ResponseIdMapper) Rewrite(data []byte) []byte {
+	if m == nil {
+		return data
+	}
+	return []byte(m.RewriteString(string(data)))
+}
+
+// RewriteString 见 Rewrite
+func (m *ResponseIdMapper) RewriteString(data string) string {
+	if m == nil {
+		return data
+	}
+	return responseIdFieldPattern.ReplaceAllStringFunc(data, func(field string) string {
+		match := responseIdFieldPattern.FindStringSubmatch(field)
+		return `"` + match[1] + `"` + match[2] + `"` + m.GatewayId(match[3]) + `"`
+	})
+}
+
+// GetResponseIdMapping 获取网关 ID 对应的映射，映射不存在、已过期或不属于该用户时返回 nil
+func GetResponseIdMapping(gatewayId string, userId int) *ResponseIdMapping {
+	if !isGatewayResponseId(gatewayId) {
+		return nil
+	}
+	mapping := loadResponseIdMapping(gatewayId)
+	if mapping == nil || mapping.UserId != userId {
+		return nil
+	}
+	return mapping
+}
+
+// RestoreUpstreamResponseIds 将请求 JSON 中引用的网关 ID 还原为上游 ID，未知或不属于当前用户的 ID 保持不变
+func RestoreUpstreamResponseIds(c *gin.Context, data []byte) []byte {
+	if !model_setting.GetResponsesSettings().ResponseIdMappingEnabled || len(data) == 0 {
+		return data
+	}
+	userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+	return []byte(responseIdFieldPattern.ReplaceAllStringFunc(string(data), func(field string) string {
+		match := responseIdFieldPattern.FindStringSubmatch(field)
+		if mapping := GetResponseIdMapping(match[3], userId); mapping != nil {
+			return `"` + match[1] + `"` + match[2] + `"` + mapping.UpstreamId + `"`
+		}
+		return field
+	}))
+}
+
+// RestoreUpstreamResponseId 将单个网关 ID 还原为上游 ID，见 RestoreUpstreamResponseIds
+func RestoreUpstreamResponseId(c *gin.Context, id string) string {
+	if !model_setting.GetResponsesSettings().ResponseIdMappingEnabled {
+		return id
+	}
+	if mapping := GetResponseIdMapping(id, common.GetContextKeyInt(c, constant.ContextKeyUserId)); mapping != nil {
+		return mapping.UpstreamId
+	}
+	return id
+}
+
+func responseIdTypePrefix(id string) string {
+	for _, prefix := range responseIdTypePrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+func isGatewayResponseId(id string) bool {
+	typePrefix := responseIdTypePrefix(id)
+	return typePrefix != "" && strings.HasPrefix(id, typePrefix+model_setting.GetResponsesSettings().ResponseIdPrefix)
+}
+
+// saveResponseIdMapping 保存网关 ID 对应的映射
+// 启用 Redis 时保存到 Redis，多实例共享；未启用时保存在进程内存中，仅本实例可查，数量达到上限后不再保存
+// 返回:
+//   - bool: 是否保存成功，未保存时调用方应原样返回上游 ID，避免客户端拿到无法还原的网关 ID
+func saveResponseIdMapping(gatewayId string, mapping ResponseIdMapping) bool {
+	ttl := time.Duration(model_setting.GetResponsesSettings().ResponseIdMappingTTL) * time.Second
+	if ttl <= 0 {
+		return false
+	}
+	mapping.ExpiresAt = time.Now().Add(ttl).Unix()
+	if common.RedisEnabled {
+		data, err := common.Marshal(mapping)
+		if err != nil {
+			return false
+		}
+		if err := common.RedisSet(responseIdMappingKeyPrefix+gatewayId, string(data), ttl); err != nil {
+			common.SysLog("save response id mapping to redis failed: " + err.Error())
+			return false
+		}
+		return true
+	}
+	// 过期映射由后台定期清理，保存时不遍历
+	responseIdMappingsOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(responseIdMappingPruneInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				pruneExpiredResponseIdMappings()
+			}
+		}()
+	})
+	responseIdMappingsMutex.Lock()
+	defer responseIdMappingsMutex.Unlock()
+	if _, exists := responseIdMappings[gatewayId]; !exists && len(responseIdMappings) >= responseIdMappingMaxEntries {
+		return false
+	}
+	responseIdMappings[gatewayId] = mapping
+	return true
+}
+
+// pruneExpiredResponseIdMappings 删除内存中已过期的映射
+func pruneExpiredResponseIdMappings() {
+	now := time.Now().Unix()
+	responseIdMappingsMutex.Lock()
+	defer responseIdMappingsMutex.Unlock()
+	for key, mapping := range responseIdMappings {
+		if mapping.ExpiresAt < now {
+			delete(responseIdMappings, key)
+		}
+	}
+}
+
+func loadResponseIdMapping(gatewayId string) *ResponseIdMapping {
+	if common.RedisEnabled {
+		data, err := common.RedisGet(responseIdMappingKeyPrefix + gatewayId)
+		if err != nil || data == "" {
+			return nil
+		}
+		var mapping ResponseIdMapping
+		if err := common.UnmarshalJsonStr(data, &mapping); err != nil {
+			return nil
+		}
+		return &mapping
+	}
+	responseIdMappingsMutex.RLock()
+	mapping, ok := responseIdMappings[gatewayId]
+	responseIdMappingsMutex.RUnlock()
+	if !ok || mapping.ExpiresAt < time.Now().Unix() {
+		return nil
+	}
+	return &mapping
+}
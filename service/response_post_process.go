@@ -0,0 +1,196 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+const (
+	// postProcessMaxHoldBytes 流式处理时单行最多缓存的字节数，超过后即使没有换行也会输出
+	postProcessMaxHoldBytes = 1024
+	codeFence               = "```"
+)
+
+// postProcessRegexCache 缓存编译后的正则表达式，编译失败的表达式缓存为 nil
+var postProcessRegexCache sync.Map
+
+type postProcessRule struct {
+	model_setting.PostProcessRule
+	regex *regexp.Regexp
+}
+
+// ResponsePostProcessor 按运营配置对转换后的响应文本做后处理
+// 非流式响应使用 Process 处理完整文本；流式响应使用 Push/Flush 按行处理，
+// 未结束的行会被缓存，因此流式场景下正则匹配不会跨行，trim_suffix 只作用于最后一行
+type ResponsePostProcessor struct {
+	rules []postProcessRule
+
+	pending    string // 尚未输出的不完整行
+	heldFence  string // 可能是结尾代码块标记的行，等到确认不是结尾时再输出
+	started    bool   // 是否已经输出过内容，开头相关的规则只作用于首段
+	stripFence bool
+}
+
+// NewResponsePostProcessor 获取对指定渠道与模型生效的后处理器，没有生效的规则时返回 nil
+func NewResponsePostProcessor(channelId int, modelNames ...string) *ResponsePostProcessor {
+	settings := model_setting.GetPostProcessSettings()
+	var rules []postProcessRule
+	stripFence := false
+	for _, rule := range settings.Rules {
+		if !rule.Matches(channelId, modelNames...) {
+			continue
+		}
+		compiled := postProcessRule{PostProcessRule: rule}
+		switch rule.Type {
+		case model_setting.PostProcessTypeRegexReplace:
+			if compiled.regex = compilePostProcessRegex(rule.Pattern); compiled.regex == nil {
+				continue
+			}
+		case model_setting.PostProcessTypeStripCodeFence:
+			stripFence = true
+		case model_setting.PostProcessTypeTrimPrefix, model_setting.PostProcessTypeTrimSuffix:
+			if rule.Pattern == "" {
+				continue
+			}
+		default:
+			continue
+		}
+		rules = append(rules, compiled)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &ResponsePostProcessor{rules: rules, stripFence: stripFence}
+}
+
+func compilePostProcessRegex(pattern string) *regexp.Regexp {
+	if cached, ok := postProcessRegexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid post process regex %q: %s", pattern, err.Error()))
+		regex = nil
+	}
+	postProcessRegexCache.Store(pattern, regex)
+	return regex
+}
+
+// Process 处理非流式响应的完整文本
+func (p *ResponsePostProcessor) Process(text string) string {
+	if p == nil {
+		return text
+	}
+	return p.apply(text, true, true)
+}
+
+// Push 处理流式文本增量，返回可以立即输出的文本，可能为空
+func (p *ResponsePostProcessor) Push(delta string) string {
+	if p == nil {
+		return delta
+	}
+	p.pending += delta
+	cut := strings.LastIndexByte(p.pending, '\n') + 1
+	if cut == 0 {
+		if len(p.pending) < postProcessMaxHoldBytes {
+			return ""
+		}
+		cut = len(p.pending)
+	}
+	chunk := p.pending[:cut]
+	p.pending = p.pending[cut:]
+	return p.emit(chunk, false)
+}
+
+// Flush 在流结束时输出缓存的剩余文本
+func (p *ResponsePostProcessor) Flush() string {
+	if p == nil {
+		return ""
+	}
+	chunk := p.pending
+	p.pending = ""
+	return p.emit(chunk, true)
+}
+
+// emit 处理一段完整行文本，结尾处可能是代码块结束标记的行会被暂存
+func (p *ResponsePostProcessor) emit(chunk string, final bool) string {
+	if p.heldFence != "" {
+		// 结束时只剩空白，说明暂存的标记就是结尾代码块标记
+		if final && strings.TrimSpace(chunk) == "" {
+			p.heldFence = ""
+			return ""
+		}
+		chunk = p.heldFence + chunk
+		p.heldFence = ""
+	}
+	if p.stripFence && !final {
+		lastLineStart := strings.LastIndexByte(strings.TrimSuffix(chunk, "\n"), '\n') + 1
+		if strings.TrimSpace(chunk[lastLineStart:]) == codeFence {
+			p.heldFence = chunk[lastLineStart:]
+			chunk = chunk[:lastLineStart]
+		}
+	}
+	if chunk == "" {
+		return ""
+	}
+	output := p.apply(chunk, !p.started, final)
+	p.started = true
+	return output
+}
+
+// apply 依次应用规则，atStart 与 atEnd 表示文本是否位于响应的开头与结尾
+func (p *ResponsePostProcessor) apply(text string, atStart bool, atEnd bool) string {
+	for _, rule := range p.rules {
+		switch rule.Type {
+		case model_setting.PostProcessTypeRegexReplace:
+			text = rule.regex.ReplaceAllString(text, rule.Replacement)
+		case model_setting.PostProcessTypeStripCodeFence:
+			text = stripCodeFence(text, atStart, atEnd)
+		case model_setting.PostProcessTypeTrimPrefix:
+			if atStart {
+				text = strings.TrimPrefix(text, rule.Pattern)
+			}
+		case model_setting.PostProcessTypeTrimSuffix:
+			if atEnd {
+				text = strings.TrimSuffix(text, rule.Pattern)
+			}
+		}
+	}
+	return text
+}
+
+// stripCodeFence 去除包裹整段回复的 markdown 代码块标记，atStart 时去除开头的 ```lang 行，atEnd 时去除结尾的 ``` 行
+// 完整文本只在首尾均为代码块标记时处理，避免误删回复中间的代码块
+func stripCodeFence(text string, atStart bool, atEnd bool) string {
+	if atStart && atEnd {
+		trimmed := strings.TrimSpace(text)
+		if !strings.HasPrefix(trimmed, codeFence) || !strings.HasSuffix(trimmed, codeFence) || !strings.Contains(trimmed, "\n") {
+			return text
+		}
+	}
+	if atStart {
+		trimmed := strings.TrimLeft(text, " \t\r\n")
+		if strings.HasPrefix(trimmed, codeFence) {
+			if newline := strings.IndexByte(trimmed, '\n'); newline >= 0 {
+				text = trimmed[newline+1:]
+			} else if atEnd {
+				text = ""
+			}
+		}
+	}
+	if atEnd {
+		trimmed := strings.TrimRight(text, " \t\r\n")
+		if strings.HasSuffix(trimmed, codeFence) {
+			body := strings.TrimSuffix(trimmed, codeFence)
+			if body == "" || strings.HasSuffix(body, "\n") {
+				text = strings.TrimSuffix(body, "\n")
+			}
+		}
+	}
+	return text
+}
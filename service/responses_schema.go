@@ -0,0 +1,147 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// responsesQuarantineSize 隔离区保留的最大响应数
+	responsesQuarantineSize = 50
+	// responsesQuarantineBodyLimit 单个隔离响应体保留的最大字节数
+	responsesQuarantineBodyLimit = 64 * 1024
+)
+
+type ResponsesQuarantineEntry struct {
+	ChannelId int    `json:"channel_id"`
+	Reason    string `json:"reason"`
+	Body      string `json:"body"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+var (
+	responsesQuarantine      []ResponsesQuarantineEntry
+	responsesIncompatibility = make(map[int]int64)
+	responsesQuarantineMutex sync.RWMutex
+)
+
+// ValidateResponsesPayload 校验上游 Responses 响应体是否符合预期结构
+func ValidateResponsesPayload(body []byte) error {
+	var payload map[string]any
+	if err := common.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("body is not a json object: %v", err)
+	}
+	if object, ok := payload["object"]; ok && object != "response" {
+		return fmt.Errorf("unexpected object type: %v", object)
+	}
+	if status, ok := payload["status"].(string); !ok || status == "" {
+		return fmt.Errorf("status is missing or not a string")
+	}
+	output, ok := payload["output"].([]any)
+	if !ok {
+		return fmt.Errorf("output is missing or not an array")
+	}
+	for i, rawItem := range output {
+		item, ok := rawItem.(map[string]any)
+		if !ok {
+			return fmt.Errorf("output[%d] is not an object", i)
+		}
+		itemType, ok := item["type"].(string)
+		if !ok || itemType == "" {
+			return fmt.Errorf("output[%d].type is missing", i)
+		}
+		if itemType != "message" {
+			continue
+		}
+		content, ok := item["content"].([]any)
+		if !ok {
+			return fmt.Errorf("output[%d].content is not an array", i)
+		}
+		for j, rawPart := range content {
+			part, ok := rawPart.(map[string]any)
+			if !ok {
+				return fmt.Errorf("output[%d].content[%d] is not an object", i, j)
+			}
+			partType, ok := part["type"].(string)
+			if !ok || partType == "" {
+				return fmt.Errorf("output[%d].content[%d].type is missing", i, j)
+			}
+			if partType == "output_text" {
+				if _, ok := part["text"].(string); !ok {
+					return fmt.Errorf("output[%d].content[%d].text is not a string", i, j)
+				}
+			}
+		}
+	}
+	if rawUsage, ok := payload["usage"]; ok && rawUsage != nil {
+		usage, ok := rawUsage.(map[string]any)
+		if !ok {
+			return fmt.Errorf("usage is not an object")
+		}
+		for _, key := range []string{"input_tokens", "output_tokens"} {
+			if value, exists := usage[key]; exists {
+				if _, ok := value.(float64); !ok {
+					return fmt.Errorf("usage.%s is not a number", key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// QuarantineResponsesPayload 隔离不符合预期结构的上游响应，并累加渠道的不兼容次数
+func QuarantineResponsesPayload(channelId int, reason string, body []byte) {
+	body = common.TruncateUTF8Bytes(body, responsesQuarantineBodyLimit)
+	common.SysError(fmt.Sprintf("channel #%d returned incompatible responses payload: %s", channelId, reason))
+
+	responsesQuarantineMutex.Lock()
+	defer responsesQuarantineMutex.Unlock()
+	responsesIncompatibility[channelId]++
+	responsesQuarantine = append(responsesQuarantine, ResponsesQuarantineEntry{
+		ChannelId: channelId,
+		Reason:    reason,
+		Body:      string(body),
+		CreatedAt: time.Now().Unix(),
+	})
+	if len(responsesQuarantine) > responsesQuarantineSize {
+		responsesQuarantine = responsesQuarantine[len(responsesQuarantine)-responsesQuarantineSize:]
+	}
+}
+
+// GetResponsesQuarantine 获取隔离区中的响应及各渠道的不兼容次数
+func GetResponsesQuarantine() ([]ResponsesQuarantineEntry, map[int]int64) {
+	responsesQuarantineMutex.RLock()
+	defer responsesQuarantineMutex.RUnlock()
+	entries := make([]ResponsesQuarantineEntry, len(responsesQuarantine))
+	copy(entries, responsesQuarantine)
+	counts := make(map[int]int64, len(responsesIncompatibility))
+	for channelId, count := range responsesIncompatibility {
+		counts[channelId] = count
+	}
+	return entries, counts
+}
+
+// PassthroughResponsesPayload 将未通过校验的上游响应原样返回给客户端，并尽量提取使用量
+func PassthroughResponsesPayload(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, body []byte) *dto.Usage {
+	IOCopyBytesGracefully(c, resp, body)
+
+	usage := &dto.Usage{}
+	var responsesResponse dto.OpenAIResponsesResponse
+	if err := common.Unmarshal(body, &responsesResponse); err == nil && responsesResponse.Usage != nil {
+		usage.PromptTokens = responsesResponse.Usage.InputTokens
+		usage.CompletionTokens = responsesResponse.Usage.OutputTokens
+	}
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
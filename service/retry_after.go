@@ -0,0 +1,182 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retryAfterMaxDuration 单次解析得到的最大冷却时长，避免异常响应头导致渠道长时间不可用
+const retryAfterMaxDuration = time.Hour
+
+// rateLimitResetHeaders 服务商返回的限流重置时间响应头与对应的剩余额度响应头，剩余额度为 0 时重置时间才表示需要等待
+var rateLimitResetHeaders = []struct {
+	reset     string
+	remaining string
+}{
+	{"x-ratelimit-reset-requests", "x-ratelimit-remaining-requests"},
+	{"x-ratelimit-reset-tokens", "x-ratelimit-remaining-tokens"},
+	{"anthropic-ratelimit-requests-reset", "anthropic-ratelimit-requests-remaining"},
+	{"anthropic-ratelimit-tokens-reset", "anthropic-ratelimit-tokens-remaining"},
+	{"anthropic-ratelimit-input-tokens-reset", "anthropic-ratelimit-input-tokens-remaining"},
+	{"anthropic-ratelimit-output-tokens-reset", "anthropic-ratelimit-output-tokens-remaining"},
+}
+
+// channelCooldownKey 冷却状态的键，多 key 渠道按 key 分别冷却，单 key 渠道的 keyIndex 固定为 0
+type channelCooldownKey struct {
+	channelId int
+	keyIndex  int
+}
+
+var (
+	channelCooldowns      = make(map[channelCooldownKey]time.Time)
+	channelCooldownsMutex sync.RWMutex
+)
+
+// ParseRetryAfter 从上游响应头中解析建议的重试等待时长，只有 429 与 503 响应会使渠道进入冷却
+// 依次识别 retry-after-ms、Retry-After（秒数或 HTTP 日期），都没有时使用剩余额度已为 0 的限流维度的重置时间
+// 参数:
+//   - statusCode: 上游响应状态码
+//   - header: 上游响应头
+//
+// 返回:
+//   - time.Duration: 建议等待时长，无法解析或状态码不是 429/503 时返回 0
+func ParseRetryAfter(statusCode int, header http.Header) time.Duration {
+	if header == nil || (statusCode != http.StatusTooManyRequests && statusCode != http.StatusServiceUnavailable) {
+		return 0
+	}
+	if value := strings.TrimSpace(header.Get("retry-after-ms")); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms > 0 {
+			return clampRetryAfter(time.Duration(ms * float64(time.Millisecond)))
+		}
+	}
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if d := parseRetryAfterValue(value); d > 0 {
+			return clampRetryAfter(d)
+		}
+	}
+	var result time.Duration
+	for _, pair := range rateLimitResetHeaders {
+		value := strings.TrimSpace(header.Get(pair.reset))
+		if value == "" || strings.TrimSpace(header.Get(pair.remaining)) != "0" {
+			continue
+		}
+		if d := parseRetryAfterValue(value); d > result {
+			result = d
+		}
+	}
+	return clampRetryAfter(result)
+}
+
+// parseRetryAfterValue 解析单个重试时间值，支持秒数、Go 时长（如 6m0s、20ms）、RFC3339 与 HTTP 日期
+func parseRetryAfterValue(value string) time.Duration {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return time.Until(t)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func clampRetryAfter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	if d > retryAfterMaxDuration {
+		return retryAfterMaxDuration
+	}
+	return d
+}
+
+// GetChannelCooldownKeyIndex 获取当前请求所选渠道 key 的冷却键序号，单 key 渠道返回 0
+func GetChannelCooldownKeyIndex(c *gin.Context) int {
+	if !common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return 0
+	}
+	return common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+}
+
+// SetChannelCooldown 标记渠道 key 在指定时长内处于冷却状态，已有更晚的冷却时间时保持不变
+// 多 key 渠道只冷却触发限流的 key，其余 key 仍可使用
+// 参数:
+//   - channelId: 渠道 ID
+//   - keyIndex: 多 key 渠道的 key 序号，单 key 渠道传 0
+//   - d: 冷却时长
+func SetChannelCooldown(channelId int, keyIndex int, d time.Duration) {
+	if channelId == 0 || d <= 0 {
+		return
+	}
+	key := channelCooldownKey{channelId: channelId, keyIndex: keyIndex}
+	until := time.Now().Add(d)
+	channelCooldownsMutex.Lock()
+	defer channelCooldownsMutex.Unlock()
+	if current, ok := channelCooldowns[key]; ok && current.After(until) {
+		return
+	}
+	channelCooldowns[key] = until
+}
+
+// GetChannelCooldown 获取渠道 key 剩余的冷却时长
+// 参数:
+//   - channelId: 渠道 ID
+//   - keyIndex: 多 key 渠道的 key 序号，单 key 渠道传 0
+//
+// 返回:
+//   - time.Duration: 剩余冷却时长，未处于冷却状态时返回 0
+func GetChannelCooldown(channelId int, keyIndex int) time.Duration {
+	key := channelCooldownKey{channelId: channelId, keyIndex: keyIndex}
+	channelCooldownsMutex.RLock()
+	until, ok := channelCooldowns[key]
+	channelCooldownsMutex.RUnlock()
+	if !ok {
+		return 0
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		channelCooldownsMutex.Lock()
+		if current, exists := channelCooldowns[key]; exists && !current.After(time.Now()) {
+			delete(channelCooldowns, key)
+		}
+		channelCooldownsMutex.Unlock()
+		return 0
+	}
+	return remaining
+}
+
+// WaitChannelCooldown 在渠道 key 冷却时长不超过 maxWait 时等待冷却结束
+// 返回:
+//   - bool: 是否可以立即请求该渠道 key；冷却时长超过 maxWait 或等待期间 done 被关闭时返回 false
+func WaitChannelCooldown(done <-chan struct{}, channelId int, keyIndex int, maxWait time.Duration) bool {
+	remaining := GetChannelCooldown(channelId, keyIndex)
+	if remaining <= 0 {
+		return true
+	}
+	if remaining > maxWait {
+		return false
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
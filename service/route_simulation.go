@@ -0,0 +1,380 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// 渠道被排除的原因
+const (
+	RouteExcludedModelGroupResponses = "model_group_responses_disabled" // 模型组未开启智能路由，不选择 Responses 渠道
+	RouteExcludedModelGroupPreferred = "model_group_not_preferred"      // 模型组存在可用的优先渠道类型，该渠道不会被选择
+	RouteExcludedUnsupportedApiType  = "unsupported_api_type"           // 渠道无法处理该入站接口类型，选中后请求失败
+	RouteExcludedClaudeBetaNative    = "claude_beta_native"             // beta 请求按配置只能由原生 Claude 渠道处理，选中后跳过
+	RouteExcludedCoolingDown         = "cooling_down"                   // 冷却剩余时间超过最长等待时间，选中后跳过
+)
+
+// RouteSimulationRequest 路由模拟参数
+type RouteSimulationRequest struct {
+	ModelName  string
+	TokenId    int
+	ApiType    types.RelayFormat
+	ClaudeBeta bool // 是否按 Claude beta 请求模拟
+	// ClaudeChannelResponsesRouted 该模型在指定 Claude 渠道上是否会被智能路由到 Responses 接口，为空时视为不路由
+	ClaudeChannelResponsesRouted func(channel *model.Channel) bool
+}
+
+// RouteSimulationChannel 参与路由的渠道及其当前状态
+type RouteSimulationChannel struct {
+	ChannelId       int                          `json:"channel_id"`
+	ChannelName     string                       `json:"channel_name"`
+	ChannelType     int                          `json:"channel_type"`
+	ChannelTypeName string                       `json:"channel_type_name"`
+	Priority        int64                        `json:"priority"`
+	Weight          int                          `json:"weight"`
+	ConvertedFrom   relaycommon.ConversionSource `json:"converted_from,omitempty"` // 智能路由转换前的原始格式，原生处理时为空
+	CooldownSeconds int64                        `json:"cooldown_seconds,omitempty"`
+	ErrorRate       float64                      `json:"error_rate"`
+	ErrorSamples    int                          `json:"error_samples"`
+	InFlight        int                          `json:"in_flight"`
+	SLOBreaching    bool                         `json:"slo_breaching"` // 违反 SLO 的渠道排在达标渠道之后选择
+	// ExclusionReason 非空时表示渠道被排除：模型组相关原因在选择阶段排除，其余原因的渠道仍可能被选中，但会失败或被跳过并占用一次重试
+	ExclusionReason string `json:"exclusion_reason,omitempty"`
+}
+
+// RouteSimulationCandidate 某次尝试中可能被选中的渠道及其概率
+type RouteSimulationCandidate struct {
+	ChannelId   int     `json:"channel_id"`
+	Probability float64 `json:"probability"`
+}
+
+// RouteSimulationAttempt 第 N 次尝试的候选渠道，重试仅在上一次错误可重试时发生
+type RouteSimulationAttempt struct {
+	Attempt    int                        `json:"attempt"`
+	Priority   int64                      `json:"priority"`
+	Candidates []RouteSimulationCandidate `json:"candidates"`
+}
+
+// RouteSimulation 路由模拟结果
+type RouteSimulation struct {
+	ModelName     string                   `json:"model_name"`
+	ResolvedModel string                   `json:"resolved_model"` // 模型组解析得到的具体模型，非模型组时与 model_name 相同
+	ModelGroup    bool                     `json:"model_group"`
+	ApiType       types.RelayFormat        `json:"api_type"`
+	UsingGroup    string                   `json:"using_group"`
+	SelectedGroup string                   `json:"selected_group"` // 分组为 auto 时实际命中的分组
+	Strategy      string                   `json:"strategy"`
+	RetryTimes    int                      `json:"retry_times"`
+	Channels      []RouteSimulationChannel `json:"channels"`
+	Attempts      []RouteSimulationAttempt `json:"attempts"`
+}
+
+// SimulateRoute 模拟指定令牌以指定入站接口请求模型时的路由决策，不会修改任何渠道状态
+// 结果按优先级从高到低列出渠道，并给出每次尝试的候选渠道与选中概率，用于排查请求为何落到某个渠道
+func SimulateRoute(req RouteSimulationRequest) (*RouteSimulation, error) {
+	token, err := model.GetTokenById(req.TokenId)
+	if err != nil {
+		return nil, fmt.Errorf("token not found: %w", err)
+	}
+	usingGroup, err := resolveTokenUsingGroup(token)
+	if err != nil {
+		return nil, err
+	}
+	if token.ModelLimitsEnabled {
+		if _, ok := token.GetModelLimitsMap()[ratio_setting.FormatMatchingModelName(req.ModelName)]; !ok {
+			return nil, fmt.Errorf("token is not allowed to access model %s", req.ModelName)
+		}
+	}
+	userCache, err := model.GetUserCache(token.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if !IsValidationModelAllowed(req.ModelName, userCache.Role) {
+		return nil, fmt.Errorf("model %s is in validation and only available to administrators", req.ModelName)
+	}
+
+	result := &RouteSimulation{
+		ModelName:     req.ModelName,
+		ResolvedModel: req.ModelName,
+		ApiType:       req.ApiType,
+		UsingGroup:    usingGroup,
+		RetryTimes:    common.RetryTimes,
+		Channels:      []RouteSimulationChannel{},
+		Attempts:      []RouteSimulationAttempt{},
+	}
+
+	policy, isModelGroup := model_setting.GetModelGroupPolicy(req.ModelName)
+	var channels []*model.Channel
+	var excluded map[int]string
+	if isModelGroup {
+		result.ModelGroup = true
+		result.Strategy = model_setting.LoadBalanceStrategyPriority
+		result.ResolvedModel = ""
+		for _, modelName := range policy.Models {
+			if !IsValidationModelAllowed(modelName, userCache.Role) {
+				continue
+			}
+			group, groupChannels, groupExcluded, err := findSimulationChannels(usingGroup, token, func(group string) ([]*model.Channel, map[int]string, error) {
+				return filterModelGroupChannels(group, modelName, policy, req.ApiType)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(groupChannels) > len(groupExcluded) {
+				result.ResolvedModel = modelName
+				result.SelectedGroup = group
+				channels, excluded = groupChannels, groupExcluded
+				break
+			}
+		}
+		if result.ResolvedModel == "" {
+			return result, nil
+		}
+	} else {
+		group, groupChannels, _, err := findSimulationChannels(usingGroup, token, func(group string) ([]*model.Channel, map[int]string, error) {
+			groupChannels, err := model.GetSatisfiedChannels(group, req.ModelName)
+			return filterEnabledChannels(groupChannels), nil, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.SelectedGroup = group
+		result.Strategy = model_setting.GetLoadBalanceSettings().GetStrategy(group, req.ModelName)
+		channels = groupChannels
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].GetPriority() > channels[j].GetPriority()
+	})
+	for _, channel := range channels {
+		result.Channels = append(result.Channels, describeSimulationChannel(channel, req, excluded[channel.Id]))
+	}
+	selectable := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if _, ok := excluded[channel.Id]; !ok {
+			selectable = append(selectable, channel)
+		}
+	}
+	result.Attempts = buildSimulationAttempts(result.SelectedGroup, result.ResolvedModel, selectable, result.Strategy, isModelGroup)
+	return result, nil
+}
+
+// resolveTokenUsingGroup 按鉴权中间件的规则确定令牌实际使用的分组
+func resolveTokenUsingGroup(token *model.Token) (string, error) {
+	userGroup, err := model.GetUserGroup(token.UserId, false)
+	if err != nil {
+		return "", err
+	}
+	if token.Group == "" {
+		return userGroup, nil
+	}
+	if _, ok := GetUserUsableGroups(userGroup)[token.Group]; !ok {
+		return "", fmt.Errorf("token group %s is not usable by user group %s", token.Group, userGroup)
+	}
+	if !ratio_setting.ContainsGroupRatio(token.Group) && token.Group != "auto" {
+		return "", fmt.Errorf("token group %s is deprecated", token.Group)
+	}
+	return token.Group, nil
+}
+
+// findSimulationChannels 获取分组下的候选渠道，分组为 auto 时返回用户自动分组中第一个存在可选渠道的分组
+// 用户或令牌绑定了渠道分组时只返回绑定分组的候选渠道
+func findSimulationChannels(usingGroup string, token *model.Token, load func(group string) ([]*model.Channel, map[int]string, error)) (string, []*model.Channel, map[int]string, error) {
+	if boundGroup, ok := model_setting.GetBoundChannelGroup(token.UserId, token.Id); ok {
+		channels, excluded, err := load(boundGroup)
+		return boundGroup, channels, excluded, err
+	}
+	if usingGroup != "auto" {
+		channels, excluded, err := load(usingGroup)
+		return usingGroup, channels, excluded, err
+	}
+	if len(setting.GetAutoGroups()) == 0 {
+		return usingGroup, nil, nil, errors.New("auto groups is not enabled")
+	}
+	userGroup, err := model.GetUserGroup(token.UserId, false)
+	if err != nil {
+		return usingGroup, nil, nil, err
+	}
+	for _, autoGroup := range GetUserAutoGroup(userGroup) {
+		channels, excluded, err := load(autoGroup)
+		if err != nil {
+			continue
+		}
+		if len(channels) > len(excluded) {
+			return autoGroup, channels, excluded, nil
+		}
+	}
+	return usingGroup, nil, nil, nil
+}
+
+// filterModelGroupChannels 按模型组的路由策略标记选择阶段会被排除的渠道，筛选规则与 selectModelGroupChannel 共用 modelGroupCandidateChannels
+func filterModelGroupChannels(group string, modelName string, policy *model_setting.ModelGroupPolicy, apiType types.RelayFormat) ([]*model.Channel, map[int]string, error) {
+	channels, err := model.GetSatisfiedChannels(group, modelName)
+	if err != nil {
+		return nil, nil, err
+	}
+	channels = filterEnabledChannels(channels)
+	allowResponses := policy.SmartRoutingEnabled || apiType == types.RelayFormatOpenAIResponses
+	candidates := modelGroupCandidateChannels(channels, allowResponses, policy.PreferredChannelTypes, nil)
+	selected := make(map[int]bool, len(candidates))
+	for _, channel := range candidates {
+		selected[channel.Id] = true
+	}
+	excluded := make(map[int]string)
+	for _, channel := range channels {
+		if selected[channel.Id] {
+			continue
+		}
+		if channel.Type == constant.ChannelTypeOpenAIResponses && !allowResponses {
+			excluded[channel.Id] = RouteExcludedModelGroupResponses
+		} else {
+			excluded[channel.Id] = RouteExcludedModelGroupPreferred
+		}
+	}
+	return channels, excluded, nil
+}
+
+// filterEnabledChannels 只保留启用状态的渠道，渠道缓存中的状态可能晚于能力表更新
+func filterEnabledChannels(channels []*model.Channel) []*model.Channel {
+	enabled := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Status == common.ChannelStatusEnabled {
+			enabled = append(enabled, channel)
+		}
+	}
+	return enabled
+}
+
+// describeSimulationChannel 汇总渠道的智能路由转换、冷却与健康状态，并给出选中后会被跳过的原因
+func describeSimulationChannel(channel *model.Channel, req RouteSimulationRequest, exclusionReason string) RouteSimulationChannel {
+	item := RouteSimulationChannel{
+		ChannelId:       channel.Id,
+		ChannelName:     channel.Name,
+		ChannelType:     channel.Type,
+		ChannelTypeName: constant.GetChannelTypeName(channel.Type),
+		Priority:        channel.GetPriority(),
+		Weight:          channel.GetWeight(),
+		InFlight:        GetChannelInFlight(channel.Id),
+		ExclusionReason: exclusionReason,
+	}
+	item.ErrorRate, item.ErrorSamples = GetChannelErrorRate(channel.Id, getErrorRateWindow())
+	item.SLOBreaching = hasChannelSLOBreaches() && isChannelBreachingSLO(channel)
+	// 多 key 渠道按 key 冷却，模拟时无法确定使用的 key，不计入冷却状态
+	var cooldown time.Duration
+	if !channel.ChannelInfo.IsMultiKey {
+		cooldown = GetChannelCooldown(channel.Id, 0)
+	}
+	if cooldown > 0 {
+		item.CooldownSeconds = int64(cooldown.Round(time.Second) / time.Second)
+	}
+
+	switch channel.Type {
+	case constant.ChannelTypeOpenAIResponses:
+		switch req.ApiType {
+		case types.RelayFormatOpenAI:
+			item.ConvertedFrom = relaycommon.ConversionSourceChat
+		case types.RelayFormatClaude:
+			item.ConvertedFrom = relaycommon.ConversionSourceClaude
+		case types.RelayFormatGemini:
+			item.ConvertedFrom = relaycommon.ConversionSourceGemini
+		case types.RelayFormatOpenAIResponses:
+		default:
+			setExclusionReason(&item, RouteExcludedUnsupportedApiType)
+		}
+	case constant.ChannelTypeAnthropic:
+		if req.ApiType == types.RelayFormatOpenAI && req.ClaudeChannelResponsesRouted != nil && req.ClaudeChannelResponsesRouted(channel) {
+			item.ConvertedFrom = relaycommon.ConversionSourceClaude
+		}
+	}
+	if req.ApiType == types.RelayFormatClaude && req.ClaudeBeta && channel.Type == constant.ChannelTypeOpenAIResponses &&
+		model_setting.GetResponsesSettings().GetClaudeBetaPolicy() == model_setting.ClaudeBetaPolicyNative {
+		setExclusionReason(&item, RouteExcludedClaudeBetaNative)
+	}
+	if maxWait := model_setting.GetRetryAfterMaxWait(); maxWait > 0 && cooldown > maxWait {
+		setExclusionReason(&item, RouteExcludedCoolingDown)
+	}
+	return item
+}
+
+// setExclusionReason 只记录第一个排除原因，选择阶段的排除优先于选中后的跳过
+func setExclusionReason(item *RouteSimulationChannel, reason string) {
+	if item.ExclusionReason == "" {
+		item.ExclusionReason = reason
+	}
+}
+
+// getErrorRateWindow 获取 lowest_error_rate 策略统计错误率的时间窗口
+func getErrorRateWindow() time.Duration {
+	window := time.Duration(model_setting.GetLoadBalanceSettings().ErrorRateWindowSeconds) * time.Second
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	return window
+}
+
+// buildSimulationAttempts 按重试次数列出每次尝试的候选渠道，候选渠道与实际选择使用相同的规则计算
+// 模型组按优先级分层；普通模型按负载均衡策略与 SLO 计算，见 candidateChannelsForAttempt
+func buildSimulationAttempts(group string, modelName string, channels []*model.Channel, strategy string, isModelGroup bool) []RouteSimulationAttempt {
+	attempts := make([]RouteSimulationAttempt, 0, common.RetryTimes+1)
+	if len(channels) == 0 {
+		return attempts
+	}
+	// pickChannelByWeight 的权重额外加 10；priority 策略且无需按 SLO 分层时由 model.GetRandomSatisfiedChannel 按原始权重选择，全部为 0 时均分
+	weightOffset := 10
+	if !isModelGroup && strategy == model_setting.LoadBalanceStrategyPriority {
+		weightOffset = 0
+		if hasChannelSLOBreaches() {
+			if healthy, breaching := splitChannelsBySLO(channels); len(healthy) > 0 && len(breaching) > 0 {
+				weightOffset = 10
+			}
+		}
+	}
+	for i := 0; i <= common.RetryTimes; i++ {
+		var candidates []*model.Channel
+		if isModelGroup {
+			candidates = channelsAtPriorityRank(channels, i)
+		} else {
+			candidates = candidateChannelsForAttempt(group, modelName, channels, i)
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		attempts = append(attempts, RouteSimulationAttempt{
+			Attempt:    i,
+			Priority:   candidates[0].GetPriority(),
+			Candidates: weightedCandidates(candidates, weightOffset),
+		})
+	}
+	return attempts
+}
+
+// weightedCandidates 计算按权重随机选择时每个渠道被选中的概率
+func weightedCandidates(channels []*model.Channel, weightOffset int) []RouteSimulationCandidate {
+	sumWeight := 0
+	for _, channel := range channels {
+		sumWeight += channel.GetWeight() + weightOffset
+	}
+	candidates := make([]RouteSimulationCandidate, 0, len(channels))
+	for _, channel := range channels {
+		probability := 1 / float64(len(channels))
+		if sumWeight > 0 {
+			probability = float64(channel.GetWeight()+weightOffset) / float64(sumWeight)
+		}
+		candidates = append(candidates, RouteSimulationCandidate{
+			ChannelId:   channel.Id,
+			Probability: probability,
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NormalizeStopSequences 将 Chat Completions 的 stop 参数统一为字符串数组，支持字符串与字符串数组
+func NormalizeStopSequences(stop any) []string {
+	switch v := stop.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		stops := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				stops = append(stops, s)
+			}
+		}
+		return stops
+	}
+	return nil
+}
+
+// ApplyResponsesStopSequences 渠道声明支持 text.stop 时将停止序列写入 Responses 请求，否则丢弃并记录警告与转换说明
+// 参数:
+//   - c: 请求上下文
+//   - info: 请求信息，根据渠道的 supports_responses_stop 判断上游是否支持
+//   - responsesReq: 转换后的 Responses 请求
+//   - stops: 停止序列
+//
+// 返回:
+//   - error: 写入 text 参数失败时返回错误
+func ApplyResponsesStopSequences(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest, stops []string) error {
+	if len(stops) == 0 {
+		return nil
+	}
+	if info.ChannelMeta == nil || !info.ChannelOtherSettings.SupportsResponsesStop {
+		logger.LogWarn(c, fmt.Sprintf("stop sequences %q dropped: channel does not declare Responses text.stop support", stops))
+		info.AddConversionNote("stop sequences dropped: channel does not support Responses text.stop")
+		return nil
+	}
+
+	text := make(map[string]any)
+	if len(responsesReq.Text) > 0 {
+		if err := common.Unmarshal(responsesReq.Text, &text); err != nil {
+			return types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to parse text: %s", err.Error())
+		}
+	}
+	text["stop"] = stops
+	textData, err := common.Marshal(text)
+	if err != nil {
+		return types.NewConvertError(types.ErrorCodeConvertEncodeFailed, http.StatusInternalServerError, "failed to marshal text: %s", err.Error())
+	}
+	responsesReq.Text = json.RawMessage(textData)
+	return nil
+}
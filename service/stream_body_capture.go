@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// StreamBodyCapture 为日志记录流式响应原文，内存占用有上限
+// 超过上限后保留开头与结尾各一半，中间以截断标记代替，流的开头与最后的结束事件、错误事件都不会丢失
+type StreamBodyCapture struct {
+	headLimit int
+	tailLimit int
+	head      strings.Builder
+	tail      []byte
+	dropped   int
+}
+
+// NewStreamBodyCapture 创建流式响应原文记录，上限为 constant.StreamMaxAccumulatedBytes，0 表示不限制
+func NewStreamBodyCapture() *StreamBodyCapture {
+	return newStreamBodyCapture(constant.StreamMaxAccumulatedBytes)
+}
+
+// NewRelayStreamBodyCapture 与 NewStreamBodyCapture 相同，长输出请求按 long_output_body_limit_multiplier 放宽上限
+func NewRelayStreamBodyCapture(info *relaycommon.RelayInfo) *StreamBodyCapture {
+	return newStreamBodyCapture(constant.StreamMaxAccumulatedBytes * bodyLimitMultiplier(info))
+}
+
+func newStreamBodyCapture(limit int) *StreamBodyCapture {
+	if limit <= 0 {
+		return &StreamBodyCapture{headLimit: -1}
+	}
+	headLimit := limit / 2
+	return &StreamBodyCapture{headLimit: headLimit, tailLimit: limit - headLimit}
+}
+
+// Append 追加一行流式数据
+func (s *StreamBodyCapture) Append(data string) {
+	if s.headLimit < 0 || (len(s.tail) == 0 && s.head.Len()+len(data)+1 <= s.headLimit) {
+		s.head.WriteString(data)
+		s.head.WriteString("\n")
+		return
+	}
+	s.tail = append(s.tail, data...)
+	s.tail = append(s.tail, '\n')
+	// 尾部缓冲超过两倍上限时整体前移，避免每次追加都复制
+	if len(s.tail) > 2*s.tailLimit {
+		excess := len(s.tail) - s.tailLimit
+		s.dropped += excess
+		s.tail = append(s.tail[:0], s.tail[excess:]...)
+	}
+}
+
+// Truncated 是否有内容因超过上限被丢弃
+func (s *StreamBodyCapture) Truncated() bool {
+	return s.dropped > 0 || len(s.tail) > s.tailLimit
+}
+
+// String 返回记录的原文，截断时开头与结尾之间插入截断标记，结尾从完整的一行开始
+func (s *StreamBodyCapture) String() string {
+	if len(s.tail) == 0 {
+		return s.head.String()
+	}
+	tail := s.tail
+	dropped := s.dropped
+	if len(tail) > s.tailLimit {
+		dropped += len(tail) - s.tailLimit
+		tail = tail[len(tail)-s.tailLimit:]
+	}
+	if dropped > 0 {
+		if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+			dropped += i + 1
+			tail = tail[i+1:]
+		}
+	}
+	if dropped == 0 {
+		return s.head.String() + string(tail)
+	}
+	return fmt.Sprintf("%s... [%d bytes truncated] ...\n%s", s.head.String(), dropped, tail)
+}
+
+// skipStreamResponseBody 开启 STREAM_BODY_CAPTURE_ON_ERROR_ONLY 时，正常结束的流式请求不在日志中记录响应原文
+// 中途中断、出现错误事件或以非 completed 状态结束的流视为出错，被管理员终止的流式请求同样保留响应原文，便于事后审计
+func skipStreamResponseBody(info *relaycommon.RelayInfo) bool {
+	return constant.StreamBodyCaptureOnErrorOnly && info.IsStream && !info.StreamAborted && !info.StreamTerminated && !info.StreamErrored
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// activeStreamsKey Redis 中保存各节点活跃流的 hash，field 为请求 ID
+	activeStreamsKey = "active_streams"
+	// activeStreamsTerminateKey Redis 中待终止流的请求 ID 集合，由持有该流的节点在心跳时处理
+	activeStreamsTerminateKey = "active_streams:terminate"
+	// activeStreamHeartbeat 节点向 Redis 同步活跃流状态的间隔
+	activeStreamHeartbeat = 5 * time.Second
+	// activeStreamStaleAfter 超过该时长未更新的记录视为节点已退出，列出时会被清理
+	activeStreamStaleAfter = 3 * activeStreamHeartbeat
+)
+
+// ActiveStream 正在进行中的流式请求
+type ActiveStream struct {
+	Id            string                       `json:"id"` // 请求 ID
+	Node          string                       `json:"node"`
+	UserId        int                          `json:"user_id"`
+	TokenId       int                          `json:"token_id"`
+	TokenName     string                       `json:"token_name"`
+	ModelName     string                       `json:"model_name"`
+	ChannelId     int                          `json:"channel_id"`
+	ConvertedFrom relaycommon.ConversionSource `json:"converted_from,omitempty"`
+	StartTime     int64                        `json:"start_time"`
+	OutputTokens  int                          `json:"output_tokens"` // 已输出的 token 数，处理器未提供计数器时为 0
+	UpdatedTime   int64                        `json:"updated_time"`
+}
+
+type activeStreamEntry struct {
+	stream     ActiveStream
+	info       *relaycommon.RelayInfo
+	terminated chan struct{}
+	once       sync.Once
+}
+
+func (e *activeStreamEntry) terminate() {
+	e.once.Do(func() {
+		close(e.terminated)
+	})
+}
+
+// snapshot 返回带有最新 token 数的记录
+func (e *activeStreamEntry) snapshot() ActiveStream {
+	stream := e.stream
+	if counter := e.info.StreamTokenCounter; counter != nil {
+		stream.OutputTokens = counter.CountedTokens()
+	}
+	stream.UpdatedTime = time.Now().Unix()
+	return stream
+}
+
+var (
+	activeStreams         = make(map[string]*activeStreamEntry)
+	activeStreamsMutex    sync.RWMutex
+	activeStreamsSyncOnce sync.Once
+	activeStreamNodeName  string
+	activeStreamNodeOnce  sync.Once
+)
+
+// getActiveStreamNode 获取当前节点名称，优先使用 NODE_NAME 环境变量
+func getActiveStreamNode() string {
+	activeStreamNodeOnce.Do(func() {
+		hostname, _ := os.Hostname()
+		activeStreamNodeName = common.GetEnvOrDefaultString("NODE_NAME", fmt.Sprintf("%s-%d", hostname, os.Getpid()))
+	})
+	return activeStreamNodeName
+}
+
+// RegisterActiveStream 登记一个开始转发的流式请求
+// 返回:
+//   - <-chan struct{}: 管理员终止该流时被关闭，调用方应随即结束转发
+//   - func(): 流结束时调用以注销登记
+func RegisterActiveStream(c *gin.Context, info *relaycommon.RelayInfo) (<-chan struct{}, func()) {
+	id := c.GetString(common.RequestIdKey)
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", getActiveStreamNode(), time.Now().UnixNano())
+	}
+	entry := &activeStreamEntry{
+		stream: ActiveStream{
+			Id:            id,
+			Node:          getActiveStreamNode(),
+			UserId:        info.UserId,
+			TokenId:       info.TokenId,
+			TokenName:     c.GetString("token_name"),
+			ModelName:     info.OriginModelName,
+			ConvertedFrom: info.ConversionSource,
+			StartTime:     info.StartTime.Unix(),
+		},
+		info:       info,
+		terminated: make(chan struct{}),
+	}
+	if info.ChannelMeta != nil {
+		entry.stream.ChannelId = info.ChannelId
+	}
+
+	activeStreamsMutex.Lock()
+	activeStreams[id] = entry
+	activeStreamsMutex.Unlock()
+
+	if common.RedisEnabled {
+		activeStreamsSyncOnce.Do(startActiveStreamSync)
+		stream := entry.snapshot()
+		gopool.Go(func() {
+			saveActiveStream(stream)
+		})
+	}
+
+	return entry.terminated, func() {
+		activeStreamsMutex.Lock()
+		if activeStreams[id] == entry {
+			delete(activeStreams, id)
+		}
+		activeStreamsMutex.Unlock()
+		if common.RedisEnabled {
+			// 异步写入不阻塞转发，与登记写入乱序时残留的记录会在列出时按过期清理
+			gopool.Go(func() {
+				if err := common.RDB.HDel(context.Background(), activeStreamsKey, id).Err(); err != nil {
+					common.SysError("failed to remove active stream: " + err.Error())
+				}
+			})
+		}
+	}
+}
+
+// ListActiveStreams 列出所有节点的活跃流，按开始时间排序；未启用 Redis 时只包含当前节点
+func ListActiveStreams() ([]ActiveStream, error) {
+	if !common.RedisEnabled {
+		activeStreamsMutex.RLock()
+		streams := make([]ActiveStream, 0, len(activeStreams))
+		for _, entry := range activeStreams {
+			streams = append(streams, entry.snapshot())
+		}
+		activeStreamsMutex.RUnlock()
+		sortActiveStreams(streams)
+		return streams, nil
+	}
+
+	ctx := context.Background()
+	values, err := common.RDB.HGetAll(ctx, activeStreamsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	streams := make([]ActiveStream, 0, len(values))
+	staleBefore := time.Now().Add(-activeStreamStaleAfter).Unix()
+	for id, value := range values {
+		var stream ActiveStream
+		if err := common.UnmarshalJsonStr(value, &stream); err != nil || stream.UpdatedTime < staleBefore {
+			// 节点异常退出后残留的记录
+			common.RDB.HDel(ctx, activeStreamsKey, id)
+			continue
+		}
+		streams = append(streams, stream)
+	}
+	sortActiveStreams(streams)
+	return streams, nil
+}
+
+// TerminateActiveStream 终止指定请求 ID 的活跃流，流在其他节点上时由该节点在下一次心跳时终止
+// 返回:
+//   - bool: 是否找到该流
+//   - error: 访问 Redis 失败时返回错误
+func TerminateActiveStream(id string) (bool, error) {
+	activeStreamsMutex.RLock()
+	entry, ok := activeStreams[id]
+	activeStreamsMutex.RUnlock()
+	if ok {
+		entry.terminate()
+		return true, nil
+	}
+	if !common.RedisEnabled {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	exists, err := common.RDB.HExists(ctx, activeStreamsKey, id).Result()
+	if err != nil || !exists {
+		return false, err
+	}
+	pipe := common.RDB.TxPipeline()
+	pipe.SAdd(ctx, activeStreamsTerminateKey, id)
+	pipe.Expire(ctx, activeStreamsTerminateKey, activeStreamStaleAfter)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func sortActiveStreams(streams []ActiveStream) {
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].StartTime < streams[j].StartTime
+	})
+}
+
+func saveActiveStream(stream ActiveStream) {
+	data, err := common.Marshal(stream)
+	if err != nil {
+		return
+	}
+	if err := common.RDB.HSet(context.Background(), activeStreamsKey, stream.Id, data).Err(); err != nil {
+		common.SysError("failed to save active stream: " + err.Error())
+	}
+}
+
+// startActiveStreamSync 定期将本节点活跃流的最新状态同步到 Redis，并处理其他节点发起的终止请求
+func startActiveStreamSync() {
+	gopool.Go(func() {
+		ticker := time.NewTicker(activeStreamHeartbeat)
+		defer ticker.Stop()
+		for range ticker.C {
+			syncActiveStreams()
+		}
+	})
+}
+
+func syncActiveStreams() {
+	activeStreamsMutex.RLock()
+	entries := make([]*activeStreamEntry, 0, len(activeStreams))
+	for _, entry := range activeStreams {
+		entries = append(entries, entry)
+	}
+	activeStreamsMutex.RUnlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	terminateIds, err := common.RDB.SMembers(ctx, activeStreamsTerminateKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		common.SysError("failed to read active stream terminations: " + err.Error())
+	}
+	terminateSet := make(map[string]bool, len(terminateIds))
+	for _, id := range terminateIds {
+		terminateSet[id] = true
+	}
+
+	pipe := common.RDB.Pipeline()
+	for _, entry := range entries {
+		if terminateSet[entry.stream.Id] {
+			entry.terminate()
+			pipe.SRem(ctx, activeStreamsTerminateKey, entry.stream.Id)
+			continue
+		}
+		data, err := common.Marshal(entry.snapshot())
+		if err != nil {
+			continue
+		}
+		pipe.HSet(ctx, activeStreamsKey, entry.stream.Id, data)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && common.DebugEnabled {
+		common.SysLog("failed to sync active streams: " + err.Error())
+	}
+}
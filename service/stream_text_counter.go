@@ -0,0 +1,81 @@
+package service
+
+import (
+	"strings"
+	"sync/atomic"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+const (
+	// streamTokenCountChunkBytes 待计数文本达到该长度后在最后一个空白处切分并计数
+	streamTokenCountChunkBytes = 512
+	// streamTokenCountMaxPendingBytes 待计数文本中一直没有空白时，超过该长度强制计数
+	streamTokenCountMaxPendingBytes = 8 * streamTokenCountChunkBytes
+)
+
+// StreamTextCounter 随流式输出增量统计 token 数
+// 输出文本按块在空白处切分后立即计数，只保留尚未计数的尾部文本，
+// 避免流结束时一次性对全部输出计数带来的 CPU 峰值，同时可随时获取当前已输出的 token 数
+type StreamTextCounter struct {
+	model   string
+	pending strings.Builder
+	tokens  atomic.Int64 // 已计数的 token 数，可被其他 goroutine 读取
+	written bool
+}
+
+func NewStreamTextCounter(model string) *StreamTextCounter {
+	return &StreamTextCounter{model: model}
+}
+
+// WriteString 追加一段输出文本，实现 io.StringWriter
+func (s *StreamTextCounter) WriteString(text string) (int, error) {
+	if text == "" {
+		return 0, nil
+	}
+	s.written = true
+	s.pending.WriteString(text)
+	if s.pending.Len() < streamTokenCountChunkBytes {
+		return len(text), nil
+	}
+	pending := s.pending.String()
+	// 在空白处切分，尽量不把一个单词拆到两次计数中
+	cut := strings.LastIndexAny(pending, " \t\n")
+	if cut <= 0 {
+		if len(pending) < streamTokenCountMaxPendingBytes {
+			return len(text), nil
+		}
+		cut = len(pending)
+	}
+	s.tokens.Add(int64(CountTextToken(pending[:cut], s.model)))
+	s.pending.Reset()
+	s.pending.WriteString(pending[cut:])
+	return len(text), nil
+}
+
+// IsEmpty 判断是否没有任何输出文本
+func (s *StreamTextCounter) IsEmpty() bool {
+	return !s.written
+}
+
+// TokenCount 返回当前已输出的 token 数
+func (s *StreamTextCounter) TokenCount() int {
+	if s.pending.Len() == 0 {
+		return int(s.tokens.Load())
+	}
+	return int(s.tokens.Load()) + CountTextToken(s.pending.String(), s.model)
+}
+
+// CountedTokens 返回已完成计数的 token 数，不包含尚未计数的尾部文本，可在其他 goroutine 中调用
+func (s *StreamTextCounter) CountedTokens() int {
+	return int(s.tokens.Load())
+}
+
+// bodyLimitMultiplier 响应体大小上限的放大倍数，仅长输出请求放大
+func bodyLimitMultiplier(info *relaycommon.RelayInfo) int {
+	if info == nil || !info.LongOutput {
+		return 1
+	}
+	return max(model_setting.GetClaudeSettings().LongOutputBodyLimitMultiplier, 1)
+}
@@ -81,7 +81,7 @@ func getTokenNum(tokenEncoder tokenizer.Codec, text string) int {
 	return tkm
 }
 
-func getImageToken(fileMeta *types.FileMeta, model string, stream bool) (int, error) {
+func getImageToken(fileMeta *types.FileMeta, model string, stream bool, fetchRemote bool) (int, error) {
 	if fileMeta == nil {
 		return 0, fmt.Errorf("image_url_is_nil")
 	}
@@ -152,6 +152,11 @@ func getImageToken(fileMeta *types.FileMeta, model string, stream bool) (int, er
 	if !constant.GetMediaTokenNotStream && !stream {
 		return 3 * baseTokens, nil
 	}
+
+	// 不允许下载远程图片时按基础 token 估算
+	if !fetchRemote && fileMeta.ParsedData == nil && strings.HasPrefix(fileMeta.OriginData, "http") {
+		return 3 * baseTokens, nil
+	}
 	// Normalize detail
 	if fileMeta.Detail == "auto" || fileMeta.Detail == "" {
 		fileMeta.Detail = "high"
@@ -262,7 +267,16 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 	if !constant.CountToken {
 		return 0, nil
 	}
+	return countRequestToken(c, meta, info)
+}
 
+// EstimateRequestToken 统计请求的提示词 token 数，不受 CountToken 开关影响，供令牌计数接口等需要估算值的场景使用
+func EstimateRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relaycommon.RelayInfo) (int, error) {
+	return countRequestToken(c, meta, info)
+}
+
+// countRequestToken 统计请求的提示词 token 数，不受 CountToken 开关影响，供费用预估等需要估算值的场景使用
+func countRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relaycommon.RelayInfo) (int, error) {
 	if meta == nil {
 		return 0, errors.New("token count meta is nil")
 	}
@@ -295,17 +309,32 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 		return totalAudioToken, nil
 	}
 
-	model := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+	model := info.OriginModelName
+	if model == "" {
+		model = common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+	}
 	tkm := 0
+	breakdown := &types.PromptTokenBreakdown{}
 
 	if meta.TokenType == types.TokenTypeTextNumber {
 		tkm += utf8.RuneCountInString(meta.CombineText)
+		breakdown.System = utf8.RuneCountInString(meta.SystemText)
+		breakdown.Tools = utf8.RuneCountInString(meta.ToolsText)
 	} else {
 		tkm += CountTextToken(meta.CombineText, model)
+		breakdown.System = CountTextToken(meta.SystemText, model)
+		breakdown.Tools = CountTextToken(meta.ToolsText, model)
+		if info.RelayFormat == types.RelayFormatClaude && len(meta.ToolSchemas) > 0 {
+			// 函数工具按 Anthropic 的渲染方式估算，替换按拼接文本计算的部分；转换到其他上游时再按其渲染方式修正
+			adjustment := EstimateToolSchemaTokens(meta, ToolSchemaRenderingClaude, model) - countPlainToolSchemaTokens(meta.ToolSchemas, model)
+			tkm += adjustment
+			breakdown.Tools += adjustment
+		}
 	}
 
 	if info.RelayFormat == types.RelayFormatOpenAI {
 		tkm += meta.ToolsCount * 8
+		breakdown.Tools += meta.ToolsCount * 8
 		tkm += meta.MessagesCount * 3 // 每条消息的格式化token数量
 		tkm += meta.NameCount * 3
 		tkm += 3
@@ -327,6 +356,10 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 		shouldFetchFiles = false
 	}
 
+	if info.SkipRemoteMediaFetch {
+		shouldFetchFiles = false
+	}
+
 	for _, file := range meta.Files {
 		if strings.HasPrefix(file.OriginData, "http") {
 			if shouldFetchFiles {
@@ -377,24 +410,37 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 		case types.FileTypeImage:
 			if info.RelayFormat == types.RelayFormatGemini {
 				tkm += 520 // gemini per input image tokens
+				breakdown.Images += 520
 			} else {
-				token, err := getImageToken(file, model, info.IsStream)
+				token, err := getImageToken(file, model, info.IsStream, !info.SkipRemoteMediaFetch)
 				if err != nil {
 					return 0, fmt.Errorf("error counting image token, media index[%d], original data[%s], err: %v", i, file.OriginData, err)
 				}
 				tkm += token
+				breakdown.Images += token
 			}
 		case types.FileTypeAudio:
 			tkm += 256
+			breakdown.Media += 256
 		case types.FileTypeVideo:
 			tkm += 4096 * 2
+			breakdown.Media += 4096 * 2
 		case types.FileTypeFile:
 			tkm += 4096
+			breakdown.Media += 4096
 		default:
 			tkm += 4096 // Default case for unknown file types
+			breakdown.Media += 4096
 		}
 	}
 
+	// 剩余部分计入历史消息，单独分词可能与整体分词存在少量误差
+	breakdown.History = tkm - breakdown.System - breakdown.Tools - breakdown.Images - breakdown.Media
+	if breakdown.History < 0 {
+		breakdown.History = 0
+	}
+	info.PromptTokenBreakdown = breakdown
+
 	common.SetContextKey(c, constant.ContextKeyPromptTokens, tkm)
 	return tkm, nil
 }
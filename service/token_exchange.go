@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+const (
+	// childTokenDefaultTTLSeconds 未指定有效期时子令牌的默认有效秒数
+	childTokenDefaultTTLSeconds = 15 * 60
+	// childTokenMaxTTLSeconds 子令牌允许的最长有效秒数
+	childTokenMaxTTLSeconds = 24 * 60 * 60
+)
+
+// TokenExchangeRequest 令牌交换请求
+type TokenExchangeRequest struct {
+	Name       string   `json:"name"`
+	TTLSeconds int64    `json:"ttl_seconds"` // 有效秒数，默认 15 分钟，最长 24 小时
+	Models     []string `json:"models"`      // 可用模型，必须是父令牌可用模型的子集，为空时继承父令牌的模型限制
+	Quota      int      `json:"quota"`       // 额度上限，必须大于 0
+}
+
+// ExchangeChildToken 从父令牌签发短期子令牌，子令牌继承父令牌的分组、IP 限制与请求限制
+// 父令牌为有限额度时，子令牌的额度上限会从父令牌剩余额度中预留扣除，避免通过签发子令牌绕过父令牌的额度限制，
+// 子令牌被删除或过期清理时未使用的预留额度退还父令牌
+// 参数:
+//   - parent: 父令牌，不能是子令牌
+//   - req: 交换请求
+//
+// 返回:
+//   - *model.Token: 已创建的子令牌
+//   - error: 参数不合法或父令牌额度不足时返回错误
+func ExchangeChildToken(parent *model.Token, req TokenExchangeRequest) (*model.Token, error) {
+	if parent.ParentTokenId != 0 {
+		return nil, errors.New("子令牌不能再签发子令牌")
+	}
+	if req.Quota <= 0 {
+		return nil, errors.New("子令牌额度上限必须大于 0")
+	}
+	if !parent.UnlimitedQuota && parent.RemainQuota < req.Quota {
+		return nil, fmt.Errorf("父令牌剩余额度不足，剩余 %d，需要 %d", parent.RemainQuota, req.Quota)
+	}
+
+	ttl := req.TTLSeconds
+	if ttl <= 0 {
+		ttl = childTokenDefaultTTLSeconds
+	}
+	if ttl > childTokenMaxTTLSeconds {
+		return nil, fmt.Errorf("子令牌有效期不能超过 %d 秒", childTokenMaxTTLSeconds)
+	}
+	now := common.GetTimestamp()
+	expiredTime := now + ttl
+	if parent.ExpiredTime != -1 && parent.ExpiredTime < expiredTime {
+		expiredTime = parent.ExpiredTime
+	}
+
+	modelLimitsEnabled := parent.ModelLimitsEnabled
+	modelLimits := parent.ModelLimits
+	if len(req.Models) > 0 {
+		parentModels := parent.GetModelLimitsMap()
+		models := make([]string, 0, len(req.Models))
+		for _, modelName := range req.Models {
+			modelName = strings.TrimSpace(modelName)
+			if modelName == "" {
+				continue
+			}
+			if parent.ModelLimitsEnabled && !parentModels[modelName] {
+				return nil, fmt.Errorf("父令牌无权访问模型 %s", modelName)
+			}
+			models = append(models, modelName)
+		}
+		if len(models) > 0 {
+			modelLimitsEnabled = true
+			modelLimits = strings.Join(models, ",")
+		}
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "exchange"
+	}
+	if len(name) > 30 {
+		return nil, errors.New("令牌名称过长")
+	}
+	key, err := common.GenerateKey()
+	if err != nil {
+		return nil, errors.New("生成令牌失败")
+	}
+	child := &model.Token{
+		UserId:             parent.UserId,
+		Name:               name,
+		Key:                key,
+		CreatedTime:        now,
+		AccessedTime:       now,
+		ExpiredTime:        expiredTime,
+		RemainQuota:        req.Quota,
+		ModelLimitsEnabled: modelLimitsEnabled,
+		ModelLimits:        modelLimits,
+		AllowIps:           parent.AllowIps,
+		Group:              parent.Group,
+		MaxOutputTokens:    parent.MaxOutputTokens,
+		MaxToolCalls:       parent.MaxToolCalls,
+		FeatureFlags:       parent.FeatureFlags,
+		RequestTimeout:     parent.RequestTimeout,
+		ParentTokenId:      parent.Id,
+	}
+	if !parent.UnlimitedQuota {
+		child.ReservedQuota = req.Quota
+	}
+	if err := child.Insert(); err != nil {
+		return nil, err
+	}
+	if !parent.UnlimitedQuota {
+		if err := model.DecreaseTokenQuota(parent.Id, parent.Key, req.Quota); err != nil {
+			_ = child.Delete()
+			return nil, err
+		}
+	}
+	return child, nil
+}
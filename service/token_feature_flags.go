@@ -0,0 +1,53 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 令牌级实验性转换行为开关，先对部分调用方灰度，稳定后再改为默认行为
+const (
+	// FeatureFlagReasoningToThinking 将 Claude 请求的 thinking 配置映射为 Responses 的 reasoning，推理摘要以 thinking 块返回
+	FeatureFlagReasoningToThinking = "enable_reasoning_to_thinking"
+	// FeatureFlagStopEmulation Responses 不支持 stop_sequences，由网关在输出文本中匹配并截断
+	FeatureFlagStopEmulation = "enable_stop_emulation"
+	// FeatureFlagDeltaCoalescing 合并流式响应中的细碎文本增量，减少下发的事件数
+	FeatureFlagDeltaCoalescing = "enable_delta_coalescing"
+)
+
+var tokenFeatureFlags = map[string]bool{
+	FeatureFlagReasoningToThinking: true,
+	FeatureFlagStopEmulation:       true,
+	FeatureFlagDeltaCoalescing:     true,
+}
+
+// ValidateTokenFeatureFlags 校验令牌的实验性行为开关配置，必须为 JSON 对象且只包含已知的开关
+func ValidateTokenFeatureFlags(raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	var flags map[string]bool
+	if err := common.UnmarshalJsonStr(raw, &flags); err != nil {
+		return fmt.Errorf("实验性功能开关格式错误，应为 JSON 对象: %v", err)
+	}
+	for flag := range flags {
+		if !tokenFeatureFlags[flag] {
+			return fmt.Errorf("未知的实验性功能开关: %s", flag)
+		}
+	}
+	return nil
+}
+
+// IsTokenFeatureEnabled 判断当前请求的令牌是否启用了指定的实验性转换行为
+func IsTokenFeatureEnabled(c *gin.Context, flag string) bool {
+	if c == nil {
+		return false
+	}
+	flags, ok := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenFeatureFlags)
+	return ok && flags[flag]
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// tokenRateKeyPrefix Redis 中保存令牌每分钟用量的 key 前缀
+const tokenRateKeyPrefix = "token_rate:"
+
+// tokenRateWindow 令牌在某一分钟内已使用的 token 数
+type tokenRateWindow struct {
+	minute int64
+	tokens int
+}
+
+var (
+	tokenRateWindows = make(map[int]tokenRateWindow)
+	tokenRateMutex   sync.Mutex
+)
+
+// tokenRateLimit 返回令牌每分钟的 token 上限，未启用时返回 0
+func tokenRateLimit(info *relaycommon.RelayInfo) int {
+	setting := operation_setting.GetTokenRateLimitSetting()
+	if !setting.Enabled || info == nil || info.TokenId == 0 {
+		return 0
+	}
+	return setting.TokensPerMinute
+}
+
+// CheckTokenRateLimit 请求开始时检查令牌当前分钟的 token 用量，未超出上限时计入本次请求的输入 token
+// 参数:
+//   - info: 转发信息，需已设置输入 token 数
+//
+// 返回:
+//   - *types.NewAPIError: 当前分钟用量已达上限时返回 429 错误，不会重试
+func CheckTokenRateLimit(info *relaycommon.RelayInfo) *types.NewAPIError {
+	limit := tokenRateLimit(info)
+	if limit <= 0 {
+		return nil
+	}
+	if used := addTokenRateUsage(info.TokenId, 0); used >= limit {
+		return types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgTokenRateLimited, used, limit),
+			types.ErrorCodeTokenRateLimited, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+	}
+	addTokenRateUsage(info.TokenId, info.PromptTokens)
+	return nil
+}
+
+// ConsumeStreamTokenRate 流式输出过程中计入本次尝试新增的输出 token
+// 参数:
+//   - info: 转发信息
+//   - countedTokens: 本次尝试已输出的 token 数
+//
+// 返回:
+//   - bool: 计入后是否仍未超出上限，返回 false 时调用方应终止流
+func ConsumeStreamTokenRate(info *relaycommon.RelayInfo, countedTokens int) bool {
+	limit := tokenRateLimit(info)
+	if limit <= 0 || countedTokens <= info.TokenRateReported {
+		return true
+	}
+	delta := countedTokens - info.TokenRateReported
+	info.TokenRateReported = countedTokens
+	return addTokenRateUsage(info.TokenId, delta) <= limit
+}
+
+// RecordTokenRateUsage 计费时计入尚未在流式输出过程中计入的输出 token
+func RecordTokenRateUsage(info *relaycommon.RelayInfo, completionTokens int) {
+	if tokenRateLimit(info) <= 0 || completionTokens <= info.TokenRateReported {
+		return
+	}
+	addTokenRateUsage(info.TokenId, completionTokens-info.TokenRateReported)
+	info.TokenRateReported = completionTokens
+}
+
+// addTokenRateUsage 计入令牌当前分钟的 token 用量并返回累计值，tokens 为 0 时只查询
+func addTokenRateUsage(tokenId int, tokens int) int {
+	minute := time.Now().Unix() / 60
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := fmt.Sprintf("%s%d:%d", tokenRateKeyPrefix, tokenId, minute)
+		used, err := common.RDB.IncrBy(ctx, key, int64(tokens)).Result()
+		if err != nil {
+			common.SysError("failed to update token rate usage: " + err.Error())
+			return 0
+		}
+		if tokens > 0 {
+			common.RDB.Expire(ctx, key, 2*time.Minute)
+		}
+		return int(used)
+	}
+	tokenRateMutex.Lock()
+	defer tokenRateMutex.Unlock()
+	window := tokenRateWindows[tokenId]
+	if window.minute != minute {
+		window = tokenRateWindow{minute: minute}
+	}
+	window.tokens += tokens
+	tokenRateWindows[tokenId] = window
+	return window.tokens
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+const (
+	// tokenUsageMaxRangeDays 单次查询允许的最大天数
+	tokenUsageMaxRangeDays = 90
+	// tokenUsageNativePath 未经过格式转换的请求在转换路径统计中的名称
+	tokenUsageNativePath = "native"
+)
+
+// TokenUsageDay 令牌单日用量
+type TokenUsageDay struct {
+	Date             string  `json:"date"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Quota            int     `json:"quota"`
+	Cost             float64 `json:"cost"` // 按额度换算的美元金额
+}
+
+// TokenUsageShare 按模型或转换路径统计的用量占比
+type TokenUsageShare struct {
+	Name     string  `json:"name"`
+	Requests int     `json:"requests"`
+	Quota    int     `json:"quota"`
+	Share    float64 `json:"share"` // 请求数占比
+}
+
+// TokenUsageSummary 令牌在时间范围内的用量汇总，各项均为汇总表中的精确值
+type TokenUsageSummary struct {
+	Object          string            `json:"object"`
+	StartDate       string            `json:"start_date"`
+	EndDate         string            `json:"end_date"`
+	TotalRequests   int               `json:"total_requests"`
+	TotalQuota      int               `json:"total_quota"`
+	TotalCost       float64           `json:"total_cost"`
+	Days            []TokenUsageDay   `json:"days"`
+	Models          []TokenUsageShare `json:"models"`
+	ConversionPaths []TokenUsageShare `json:"conversion_paths"`
+}
+
+// GetTokenUsageSummary 从令牌用量汇总表统计令牌的每日用量、模型构成与转换路径占比
+// 参数:
+//   - tokenId: 令牌 ID，只会查询该令牌自身的数据
+//   - startTime: 开始时间戳，为 0 时取结束时间前 30 天
+//   - endTime: 结束时间戳，为 0 时取当前时间；时间范围超过 90 天时开始时间会被截断
+func GetTokenUsageSummary(tokenId int, startTime int64, endTime int64) (*TokenUsageSummary, error) {
+	if endTime <= 0 {
+		endTime = common.GetTimestamp()
+	}
+	if startTime <= 0 {
+		startTime = endTime - 30*86400
+	}
+	if endTime-startTime > tokenUsageMaxRangeDays*86400 {
+		startTime = endTime - tokenUsageMaxRangeDays*86400
+	}
+	usageData, err := model.GetTokenUsageData(tokenId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &TokenUsageSummary{
+		Object:          "token_daily_usage",
+		StartDate:       time.Unix(startTime, 0).UTC().Format(time.DateOnly),
+		EndDate:         time.Unix(endTime, 0).UTC().Format(time.DateOnly),
+		Days:            make([]TokenUsageDay, 0),
+		Models:          make([]TokenUsageShare, 0),
+		ConversionPaths: make([]TokenUsageShare, 0),
+	}
+	days := make(map[int64]*TokenUsageDay)
+	dayKeys := make([]int64, 0)
+	models := make(map[string]*TokenUsageShare)
+	paths := make(map[string]*TokenUsageShare)
+	for _, data := range usageData {
+		day, ok := days[data.Day]
+		if !ok {
+			day = &TokenUsageDay{Date: time.Unix(data.Day, 0).UTC().Format(time.DateOnly)}
+			days[data.Day] = day
+			dayKeys = append(dayKeys, data.Day)
+		}
+		day.Requests += data.Count
+		day.PromptTokens += data.PromptTokens
+		day.CompletionTokens += data.CompletionTokens
+		day.Quota += data.Quota
+
+		addTokenUsageShare(models, data.ModelName, data)
+		path := data.ConvertedFrom
+		if path == "" {
+			path = tokenUsageNativePath
+		}
+		addTokenUsageShare(paths, path, data)
+
+		summary.TotalRequests += data.Count
+		summary.TotalQuota += data.Quota
+	}
+
+	sort.Slice(dayKeys, func(i, j int) bool {
+		return dayKeys[i] < dayKeys[j]
+	})
+	for _, key := range dayKeys {
+		day := days[key]
+		day.Cost = float64(day.Quota) / common.QuotaPerUnit
+		summary.Days = append(summary.Days, *day)
+	}
+	summary.TotalCost = float64(summary.TotalQuota) / common.QuotaPerUnit
+	summary.Models = sortTokenUsageShares(models, summary.TotalRequests)
+	summary.ConversionPaths = sortTokenUsageShares(paths, summary.TotalRequests)
+	return summary, nil
+}
+
+func addTokenUsageShare(shares map[string]*TokenUsageShare, name string, data *model.TokenUsageData) {
+	share, ok := shares[name]
+	if !ok {
+		share = &TokenUsageShare{Name: name}
+		shares[name] = share
+	}
+	share.Requests += data.Count
+	share.Quota += data.Quota
+}
+
+// sortTokenUsageShares 计算请求数占比并按请求数从多到少排序
+func sortTokenUsageShares(shares map[string]*TokenUsageShare, totalRequests int) []TokenUsageShare {
+	result := make([]TokenUsageShare, 0, len(shares))
+	for _, share := range shares {
+		if totalRequests > 0 {
+			share.Share = float64(share.Requests) / float64(totalRequests)
+		}
+		result = append(result, *share)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResolveMaxToolCalls 取请求与令牌默认工具调用上限中较小的非零值，0 表示不限制
+// 令牌上限同时作为请求未指定时的默认值与请求值的上界，避免客户端通过传入更大的值绕过限制
+func ResolveMaxToolCalls(c *gin.Context, requested uint) uint {
+	limit := requested
+	tokenLimit := common.GetContextKeyInt(c, constant.ContextKeyTokenMaxToolCalls)
+	if tokenLimit > 0 && (limit == 0 || uint(tokenLimit) < limit) {
+		limit = uint(tokenLimit)
+	}
+	return limit
+}
+
+// ApplyToolCallBudget 为 Chat/Claude 转换后的 Responses 请求施加工具调用上限
+// Agent 循环会把本轮的工具调用追加到对话历史，当前轮次的调用次数达到上限时直接拒绝请求以终止失控的循环，
+// 未达到上限时将剩余次数作为 max_tool_calls 发送给上游
+// 参数:
+//   - c: Gin 上下文
+//   - responsesReq: 转换后的 Responses 请求对象，max_tool_calls 为原始请求指定的上限
+//   - usedToolCalls: 原始请求当前轮次（最后一条用户消息之后）已发生的工具调用次数
+//
+// 返回:
+//   - *types.NewAPIError: 超出上限时返回 400 错误，不会重试
+func ApplyToolCallBudget(c *gin.Context, responsesReq *dto.OpenAIResponsesRequest, usedToolCalls int) *types.NewAPIError {
+	limit := ResolveMaxToolCalls(c, responsesReq.MaxToolCalls)
+	if limit == 0 {
+		return nil
+	}
+	if uint(usedToolCalls) >= limit {
+		return types.NewErrorWithStatusCode(types.NewLocalizedError(types.ErrMsgToolCallBudgetExceeded, usedToolCalls, limit),
+			types.ErrorCodeToolCallBudgetExceeded, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	responsesReq.MaxToolCalls = limit - uint(usedToolCalls)
+	return nil
+}
+
+// CountChatToolCalls 统计 Chat Completions 当前轮次中 assistant 发起的工具调用次数
+// 当前轮次从最后一条 user 消息之后开始，之前轮次的工具调用不计入本轮的上限
+func CountChatToolCalls(messages []dto.Message) int {
+	start := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			start = i + 1
+			break
+		}
+	}
+	count := 0
+	for i := start; i < len(messages); i++ {
+		if messages[i].Role == "assistant" {
+			count += len(messages[i].ParseToolCalls())
+		}
+	}
+	return count
+}
+
+// CountClaudeToolCalls 统计 Claude Messages 当前轮次中 assistant 发起的 tool_use 次数
+// Claude 的 tool_result 放在 user 消息中回传，当前轮次从最后一条不只包含 tool_result 的 user 消息之后开始
+func CountClaudeToolCalls(messages []dto.ClaudeMessage) int {
+	start := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" && !isClaudeToolResultMessage(&messages[i]) {
+			start = i + 1
+			break
+		}
+	}
+	count := 0
+	for i := start; i < len(messages); i++ {
+		if messages[i].Role != "assistant" || messages[i].IsStringContent() {
+			continue
+		}
+		contents, err := messages[i].ParseContent()
+		if err != nil {
+			continue
+		}
+		for _, content := range contents {
+			if content.Type == "tool_use" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// isClaudeToolResultMessage 判断 user 消息是否只包含工具调用结果
+func isClaudeToolResultMessage(message *dto.ClaudeMessage) bool {
+	if message.IsStringContent() {
+		return false
+	}
+	contents, err := message.ParseContent()
+	if err != nil || len(contents) == 0 {
+		return false
+	}
+	for _, content := range contents {
+		if content.Type != "tool_result" {
+			return false
+		}
+	}
+	return true
+}
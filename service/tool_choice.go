@@ -0,0 +1,157 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// claudeToResponsesToolChoice Claude tool_choice 类型与 Responses tool_choice 取值的对应关系，tool 类型对应 function 对象
+var claudeToResponsesToolChoice = map[string]string{
+	"auto": "auto",
+	"any":  "required",
+	"none": "none",
+}
+
+// responsesToClaudeToolChoice claudeToResponsesToolChoice 的反向映射
+var responsesToClaudeToolChoice = map[string]string{
+	"auto":     "auto",
+	"required": "any",
+	"none":     "none",
+}
+
+// ClaudeToolChoiceToResponses 将 Claude Messages 的 tool_choice 转换为 Responses 的 tool_choice
+// auto/any/none 分别对应 auto/required/none，tool{name} 对应 function{name}，
+// disable_parallel_tool_use 对应 parallel_tool_calls=false
+// 参数:
+//   - toolChoice: Claude 请求中的 tool_choice，可以是对象或非标准的字符串
+//
+// 返回:
+//   - any: Responses 的 tool_choice，字符串或 function 对象
+//   - *bool: 需要设置的 parallel_tool_calls，为 nil 时不设置
+//   - *types.NewAPIError: tool_choice 无法识别时返回 400 错误
+func ClaudeToolChoiceToResponses(toolChoice any) (any, *bool, *types.NewAPIError) {
+	var choice dto.ClaudeToolChoice
+	switch value := toolChoice.(type) {
+	case string:
+		choice.Type = value
+	case *dto.ClaudeToolChoice:
+		if value == nil {
+			return nil, nil, nil
+		}
+		choice = *value
+	case dto.ClaudeToolChoice:
+		choice = value
+	default:
+		data, err := common.Marshal(toolChoice)
+		if err == nil {
+			err = common.Unmarshal(data, &choice)
+		}
+		if err != nil {
+			return nil, nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "invalid tool_choice: %s", err.Error())
+		}
+	}
+
+	var parallelToolCalls *bool
+	if choice.DisableParallelToolUse {
+		parallelToolCalls = common.GetPointer(false)
+	}
+	// 省略 type 但指定了工具名称时按 tool 处理
+	if choice.Type == "" && choice.Name != "" {
+		choice.Type = "tool"
+	}
+	if choice.Type == "tool" {
+		if choice.Name == "" {
+			return nil, nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "tool_choice.name is required when tool_choice.type is tool")
+		}
+		return map[string]any{"type": "function", "name": choice.Name}, parallelToolCalls, nil
+	}
+	if mapped, ok := claudeToResponsesToolChoice[choice.Type]; ok {
+		return mapped, parallelToolCalls, nil
+	}
+	return nil, nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "unsupported tool_choice.type: %s", choice.Type)
+}
+
+// ResponsesToolChoiceToClaude 将 Responses 的 tool_choice 转换为 Claude Messages 的 tool_choice，为 ClaudeToolChoiceToResponses 的逆过程
+// 参数:
+//   - toolChoice: Responses 请求中的 tool_choice，为空时返回 nil
+//   - parallelToolCalls: Responses 请求中的 parallel_tool_calls，为 false 时设置 disable_parallel_tool_use
+//
+// 返回:
+//   - *dto.ClaudeToolChoice: Claude 的 tool_choice
+//   - *types.NewAPIError: tool_choice 无法识别或指定了 Claude 不支持的内置工具时返回 400 错误
+func ResponsesToolChoiceToClaude(toolChoice any, parallelToolCalls *bool) (*dto.ClaudeToolChoice, *types.NewAPIError) {
+	var choice *dto.ClaudeToolChoice
+	switch value := toolChoice.(type) {
+	case nil:
+	case string:
+		mapped, ok := responsesToClaudeToolChoice[value]
+		if !ok {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "unsupported tool_choice: %s", value)
+		}
+		choice = &dto.ClaudeToolChoice{Type: mapped}
+	default:
+		var object struct {
+			Type     string `json:"type"`
+			Name     string `json:"name"`
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		data, err := common.Marshal(toolChoice)
+		if err == nil {
+			err = common.Unmarshal(data, &object)
+		}
+		if err != nil {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "invalid tool_choice: %s", err.Error())
+		}
+		// 兼容 Chat Completions 形式的 {"type":"function","function":{"name":...}}
+		name := object.Name
+		if name == "" {
+			name = object.Function.Name
+		}
+		// 部分客户端省略 type，只指定了函数名称的对象按 function 处理
+		if object.Type != "function" && (object.Type != "" || name == "") {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "unsupported tool_choice.type: %s", object.Type)
+		}
+		if name == "" {
+			return nil, types.NewConvertError(types.ErrorCodeConvertToolChoiceInvalid, http.StatusBadRequest, "tool_choice.name is required when tool_choice.type is function")
+		}
+		choice = &dto.ClaudeToolChoice{Type: "tool", Name: name}
+	}
+
+	if parallelToolCalls != nil && !*parallelToolCalls {
+		if choice == nil {
+			choice = &dto.ClaudeToolChoice{Type: "auto"}
+		}
+		choice.DisableParallelToolUse = true
+	}
+	return choice, nil
+}
+
+// ChatToolChoiceToResponses 将 Chat Completions 的 tool_choice 转换为 Responses 的 tool_choice
+// 字符串取值两者一致；Chat 的 {"type":"function","function":{"name":...}} 转换为 Responses 的 {"type":"function","name":...}，
+// 省略 type 的函数对象同样按 function 处理，其他对象原样保留
+func ChatToolChoiceToResponses(toolChoice any) any {
+	object, ok := toolChoice.(map[string]any)
+	if !ok {
+		return toolChoice
+	}
+	if choiceType, hasType := object["type"]; hasType && choiceType != "function" {
+		return toolChoice
+	}
+	if name, ok := object["name"].(string); ok && name != "" {
+		return map[string]any{"type": "function", "name": name}
+	}
+	function, ok := object["function"].(map[string]any)
+	if !ok {
+		return toolChoice
+	}
+	name, ok := function["name"].(string)
+	if !ok || name == "" {
+		return toolChoice
+	}
+	return map[string]any{"type": "function", "name": name}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// unmarshalToolChoice 按请求体反序列化后的形式构造 tool_choice
+func unmarshalToolChoice(t *testing.T, raw string) any {
+	t.Helper()
+	var toolChoice any
+	if err := common.UnmarshalJsonStr(raw, &toolChoice); err != nil {
+		t.Fatalf("unmarshal tool_choice: %v", err)
+	}
+	return toolChoice
+}
+
+func TestClaudeToolChoiceToResponses(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		want     any
+		parallel *bool
+	}{
+		{name: "auto", input: `{"type":"auto"}`, want: "auto"},
+		{name: "any", input: `{"type":"any"}`, want: "required"},
+		{name: "none", input: `{"type":"none"}`, want: "none"},
+		{name: "string", input: `"any"`, want: "required"},
+		{name: "tool", input: `{"type":"tool","name":"get_weather"}`, want: map[string]any{"type": "function", "name": "get_weather"}},
+		{name: "untyped tool", input: `{"name":"get_weather"}`, want: map[string]any{"type": "function", "name": "get_weather"}},
+		{name: "disable parallel", input: `{"type":"auto","disable_parallel_tool_use":true}`, want: "auto", parallel: common.GetPointer(false)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, parallel, err := ClaudeToolChoiceToResponses(unmarshalToolChoice(t, tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("tool_choice = %#v, want %#v", got, tc.want)
+			}
+			if !reflect.DeepEqual(parallel, tc.parallel) {
+				t.Fatalf("parallel_tool_calls = %v, want %v", parallel, tc.parallel)
+			}
+		})
+	}
+}
+
+func TestClaudeToolChoiceToResponsesInvalid(t *testing.T) {
+	for _, input := range []string{`{"type":"tool"}`, `{"type":"unknown"}`, `{}`} {
+		if _, _, err := ClaudeToolChoiceToResponses(unmarshalToolChoice(t, input)); err == nil {
+			t.Fatalf("expected error for %s", input)
+		}
+	}
+}
+
+func TestResponsesToolChoiceToClaude(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		parallel *bool
+		want     *dto.ClaudeToolChoice
+	}{
+		{name: "auto", input: `"auto"`, want: &dto.ClaudeToolChoice{Type: "auto"}},
+		{name: "required", input: `"required"`, want: &dto.ClaudeToolChoice{Type: "any"}},
+		{name: "none", input: `"none"`, want: &dto.ClaudeToolChoice{Type: "none"}},
+		{name: "responses function", input: `{"type":"function","name":"get_weather"}`, want: &dto.ClaudeToolChoice{Type: "tool", Name: "get_weather"}},
+		{name: "chat function", input: `{"type":"function","function":{"name":"get_weather"}}`, want: &dto.ClaudeToolChoice{Type: "tool", Name: "get_weather"}},
+		{name: "untyped chat function", input: `{"function":{"name":"get_weather"}}`, want: &dto.ClaudeToolChoice{Type: "tool", Name: "get_weather"}},
+		{name: "untyped name", input: `{"name":"get_weather"}`, want: &dto.ClaudeToolChoice{Type: "tool", Name: "get_weather"}},
+		{name: "parallel disabled", input: `null`, parallel: common.GetPointer(false), want: &dto.ClaudeToolChoice{Type: "auto", DisableParallelToolUse: true}},
+		{name: "parallel enabled", input: `null`, parallel: common.GetPointer(true)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ResponsesToolChoiceToClaude(unmarshalToolChoice(t, tc.input), tc.parallel)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("tool_choice = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResponsesToolChoiceToClaudeInvalid(t *testing.T) {
+	for _, input := range []string{`"unknown"`, `{"type":"web_search_preview"}`, `{"type":"function"}`, `{}`} {
+		if _, err := ResponsesToolChoiceToClaude(unmarshalToolChoice(t, input), nil); err == nil {
+			t.Fatalf("expected error for %s", input)
+		}
+	}
+}
+
+func TestChatToolChoiceToResponses(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  any
+	}{
+		{name: "string", input: `"required"`, want: "required"},
+		{name: "chat function", input: `{"type":"function","function":{"name":"get_weather"}}`, want: map[string]any{"type": "function", "name": "get_weather"}},
+		{name: "responses function", input: `{"type":"function","name":"get_weather"}`, want: map[string]any{"type": "function", "name": "get_weather"}},
+		{name: "untyped function", input: `{"function":{"name":"get_weather"}}`, want: map[string]any{"type": "function", "name": "get_weather"}},
+		{name: "built-in tool", input: `{"type":"file_search"}`, want: map[string]any{"type": "file_search"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ChatToolChoiceToResponses(unmarshalToolChoice(t, tc.input))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("tool_choice = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+var (
+	toolSchemaCache      = make(map[string]json.RawMessage)
+	toolSchemaCacheMutex sync.RWMutex
+)
+
+// ConvertToolsWithCache 按入站 tools 的哈希缓存转换结果，Agent 每轮请求携带相同的工具集时跳过重复转换
+// 参数:
+//   - scope: 转换方向及影响转换结果的配置，不同 scope 的结果互不复用
+//   - rawTools: 入站 tools 的原始 JSON
+//   - convert: 缓存未命中时执行的转换
+//
+// 返回:
+//   - json.RawMessage: 转换后的 tools，多个请求共享同一份数据，调用方不得修改
+//   - error: 转换失败时返回错误，失败结果不缓存
+func ConvertToolsWithCache(scope string, rawTools []byte, convert func() (json.RawMessage, error)) (json.RawMessage, error) {
+	size := model_setting.GetResponsesSettings().ToolSchemaCacheSize
+	if size <= 0 || len(rawTools) == 0 {
+		return convert()
+	}
+	hash := sha256.Sum256(rawTools)
+	key := scope + ":" + hex.EncodeToString(hash[:])
+
+	toolSchemaCacheMutex.RLock()
+	cached, ok := toolSchemaCache[key]
+	toolSchemaCacheMutex.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	converted, err := convert()
+	if err != nil {
+		return nil, err
+	}
+	toolSchemaCacheMutex.Lock()
+	// 超出容量时随机淘汰一条，工具集种类通常远小于容量
+	for len(toolSchemaCache) >= size {
+		for k := range toolSchemaCache {
+			delete(toolSchemaCache, k)
+			break
+		}
+	}
+	toolSchemaCache[key] = converted
+	toolSchemaCacheMutex.Unlock()
+	return converted, nil
+}
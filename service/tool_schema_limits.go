@@ -0,0 +1,111 @@
+package service
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// toolNamePattern 上游允许的函数工具名称格式
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ValidateToolSchemaLimits 在转发前按上游限制校验转换后的工具定义：工具数量、函数名称格式、parameters 的嵌套层数与大小
+// 上游对非法函数定义返回的 400 通常不指明具体工具，这里提前拒绝并在错误中指出出错的工具
+// 参数:
+//   - tools: Responses 格式的工具定义
+//
+// 返回:
+//   - *types.NewAPIError: 超出限制时返回 CONVERT_TOOL_SCHEMA_INVALID 错误
+func ValidateToolSchemaLimits(tools []map[string]any) *types.NewAPIError {
+	settings := model_setting.GetResponsesSettings()
+	if settings.ToolMaxCount > 0 && len(tools) > settings.ToolMaxCount {
+		return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "request has %d tools, the upstream allows at most %d", len(tools), settings.ToolMaxCount)
+	}
+	for i, tool := range tools {
+		toolType, _ := tool["type"].(string)
+		if toolType != "function" && toolType != "custom" {
+			continue
+		}
+		// Chat 转换而来的函数工具保持 {"type":"function","function":{...}} 的嵌套形式
+		definition := tool
+		if _, ok := tool["name"]; !ok {
+			if nested, ok := tool[toolType].(map[string]any); ok {
+				definition = nested
+			}
+		}
+		name, _ := definition["name"].(string)
+		if !toolNamePattern.MatchString(name) {
+			return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tools[%d] name %q is invalid: it must be 1-64 characters of letters, digits, underscores or hyphens", i, name)
+		}
+		parameters, ok := definition["parameters"]
+		if !ok || parameters == nil {
+			continue
+		}
+		if settings.ToolSchemaMaxDepth > 0 {
+			if depth := toolSchemaDepth(parameters); depth > settings.ToolSchemaMaxDepth {
+				return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tool %q parameters are nested %d levels deep, the upstream allows at most %d", name, depth, settings.ToolSchemaMaxDepth)
+			}
+		}
+		if settings.ToolSchemaMaxBytes > 0 {
+			data, err := common.Marshal(parameters)
+			if err != nil {
+				return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tool %q parameters cannot be encoded: %s", name, err.Error())
+			}
+			if len(data) > settings.ToolSchemaMaxBytes {
+				return types.NewConvertError(types.ErrorCodeConvertToolSchemaInvalid, http.StatusBadRequest, "tool %q parameters are %d bytes, the upstream allows at most %d", name, len(data), settings.ToolSchemaMaxBytes)
+			}
+		}
+	}
+	return nil
+}
+
+// toolSchemaDepth 计算 JSON Schema 的嵌套层数，根 schema 为第 1 层，properties、items 与定义中的子 schema 各加一层，
+// anyOf 等组合关键字中的 schema 与所在 schema 视为同一层
+func toolSchemaDepth(schema any) int {
+	node, ok := schema.(map[string]any)
+	if !ok {
+		return 0
+	}
+	maxChild := 0
+	visit := func(child any) {
+		if depth := toolSchemaDepth(child); depth > maxChild {
+			maxChild = depth
+		}
+	}
+	for _, key := range []string{"properties", "patternProperties", "$defs", "definitions"} {
+		if children, ok := node[key].(map[string]any); ok {
+			for _, child := range children {
+				visit(child)
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not", "contains"} {
+		switch child := node[key].(type) {
+		case map[string]any:
+			visit(child)
+		case []any:
+			for _, item := range child {
+				visit(item)
+			}
+		}
+	}
+	if children, ok := node["prefixItems"].([]any); ok {
+		for _, child := range children {
+			visit(child)
+		}
+	}
+	depth := maxChild + 1
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		if children, ok := node[key].([]any); ok {
+			for _, child := range children {
+				if childDepth := toolSchemaDepth(child); childDepth > depth {
+					depth = childDepth
+				}
+			}
+		}
+	}
+	return depth
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// chatToolsToResponsesMap 按 Chat→Responses 转换后的形式构造工具定义：函数工具保持 Chat 的嵌套形式
+func chatToolsToResponsesMap(t *testing.T, tools []dto.ToolCallRequest) []map[string]any {
+	t.Helper()
+	data, err := common.Marshal(tools)
+	if err != nil {
+		t.Fatalf("marshal tools: %v", err)
+	}
+	request := &dto.OpenAIResponsesRequest{Tools: data}
+	return request.GetToolsMap()
+}
+
+func TestValidateToolSchemaLimitsChatFunctionTool(t *testing.T) {
+	tools := chatToolsToResponsesMap(t, []dto.ToolCallRequest{{
+		Type: "function",
+		Function: dto.FunctionRequest{
+			Name:        "get_weather",
+			Description: "Get the current weather",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"location": map[string]any{"type": "string"},
+				},
+				"required": []any{"location"},
+			},
+		},
+	}})
+	if err := ValidateToolSchemaLimits(tools); err != nil {
+		t.Fatalf("expected chat function tool to pass, got %v", err)
+	}
+}
+
+func TestValidateToolSchemaLimitsChatFunctionToolInvalidName(t *testing.T) {
+	tools := chatToolsToResponsesMap(t, []dto.ToolCallRequest{{
+		Type:     "function",
+		Function: dto.FunctionRequest{Name: "get weather"},
+	}})
+	err := ValidateToolSchemaLimits(tools)
+	if err == nil || !strings.Contains(err.Error(), `"get weather"`) {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
+}
+
+func TestValidateToolSchemaLimitsChatFunctionToolDepth(t *testing.T) {
+	schema := map[string]any{"type": "string"}
+	for i := 0; i < 12; i++ {
+		schema = map[string]any{"type": "object", "properties": map[string]any{"nested": schema}}
+	}
+	tools := chatToolsToResponsesMap(t, []dto.ToolCallRequest{{
+		Type:     "function",
+		Function: dto.FunctionRequest{Name: "deep", Parameters: schema},
+	}})
+	err := ValidateToolSchemaLimits(tools)
+	if err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Fatalf("expected depth error, got %v", err)
+	}
+}
+
+func TestValidateToolSchemaLimitsResponsesFunctionTool(t *testing.T) {
+	tools := []map[string]any{{
+		"type":       "function",
+		"name":       "get_weather",
+		"parameters": map[string]any{"type": "object"},
+	}}
+	if err := ValidateToolSchemaLimits(tools); err != nil {
+		t.Fatalf("expected responses function tool to pass, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ToolSchemaRendering 上游将函数工具定义渲染进提示词的方式，不同方式计费的 token 数不同
+type ToolSchemaRendering string
+
+const (
+	// ToolSchemaRenderingClaude Anthropic 原生渲染：工具定义以 JSON 形式附加在工具使用系统提示词之后
+	ToolSchemaRenderingClaude ToolSchemaRendering = "claude"
+	// ToolSchemaRenderingResponses OpenAI Responses 渲染：函数定义转换为 TypeScript 风格的命名空间
+	ToolSchemaRenderingResponses ToolSchemaRendering = "responses"
+)
+
+const (
+	// claudeToolSystemPromptTokens 请求携带工具时 Anthropic 额外注入的工具使用系统提示词 token 数（tool_choice 为 auto/none）
+	claudeToolSystemPromptTokens = 346
+	// claudeToolSystemPromptForcedTokens tool_choice 为 any/tool 时工具使用系统提示词的 token 数
+	claudeToolSystemPromptForcedTokens = 313
+	// responsesToolHeader Responses 渠道渲染函数工具时使用的固定前缀
+	responsesToolHeader = "# Tools\n\n## functions\n\nnamespace functions {\n\n"
+	// responsesToolFooter Responses 渠道渲染函数工具时使用的固定后缀
+	responsesToolFooter = "} // namespace functions"
+)
+
+// EstimateToolSchemaTokens 按上游的渲染方式估算函数工具定义消耗的 token 数
+// 参数:
+//   - meta: 请求的 token 计数元数据，使用其中的 ToolSchemas 与 ToolChoice
+//   - rendering: 上游的工具渲染方式
+//   - model: 用于选择分词器的模型名称
+//
+// 返回:
+//   - int: 估算的工具 token 数，没有函数工具时为 0
+func EstimateToolSchemaTokens(meta *types.TokenCountMeta, rendering ToolSchemaRendering, model string) int {
+	if meta == nil || len(meta.ToolSchemas) == 0 {
+		return 0
+	}
+	switch rendering {
+	case ToolSchemaRenderingResponses:
+		return CountTextToken(renderResponsesToolSchemas(meta.ToolSchemas), model)
+	default:
+		overhead := claudeToolSystemPromptTokens
+		if meta.ToolChoice == "any" || meta.ToolChoice == "tool" {
+			overhead = claudeToolSystemPromptForcedTokens
+		}
+		return overhead + CountTextToken(renderClaudeToolSchemas(meta.ToolSchemas), model)
+	}
+}
+
+// countPlainToolSchemaTokens 按拼接文本计算函数工具的 token 数，与 GetTokenCountMeta 中 ToolsText 的拼接方式一致
+func countPlainToolSchemaTokens(schemas []types.ToolSchemaMeta, model string) int {
+	texts := make([]string, 0, len(schemas)*3)
+	for _, schema := range schemas {
+		if schema.Name != "" {
+			texts = append(texts, schema.Name)
+		}
+		if schema.Description != "" {
+			texts = append(texts, schema.Description)
+		}
+		if schema.Parameters != nil {
+			b, _ := common.Marshal(schema.Parameters)
+			texts = append(texts, string(b))
+		}
+	}
+	return CountTextToken(strings.Join(texts, "\n"), model)
+}
+
+// ApplyToolSchemaRendering 请求转换后按实际上游的渲染方式修正预计数的函数工具 token
+// 预计数阶段尚未选定渠道，按请求格式的原生渲染方式估算；转换为 Responses 请求后以两种渲染方式的差值修正提示词 token，
+// 使上游未返回用量时的兜底计费与上游实际计费一致。修正量记录在 info 中，重试时由 ResetConversion 撤销
+// 参数:
+//   - info: 转发信息
+//   - meta: 原始请求的 token 计数元数据
+//   - from: 预计数时使用的渲染方式
+//   - to: 实际上游的渲染方式
+func ApplyToolSchemaRendering(info *relaycommon.RelayInfo, meta *types.TokenCountMeta, from ToolSchemaRendering, to ToolSchemaRendering) {
+	if from == to || info.PromptTokens == 0 || meta == nil || len(meta.ToolSchemas) == 0 || meta.TokenType == types.TokenTypeTextNumber {
+		return
+	}
+	adjustment := EstimateToolSchemaTokens(meta, to, info.OriginModelName) - EstimateToolSchemaTokens(meta, from, info.OriginModelName)
+	if info.PromptTokens+adjustment < 0 {
+		adjustment = -info.PromptTokens
+	}
+	info.AdjustToolSchemaTokens(adjustment)
+}
+
+// renderClaudeToolSchemas 按 Anthropic 的方式渲染工具定义
+func renderClaudeToolSchemas(schemas []types.ToolSchemaMeta) string {
+	var builder strings.Builder
+	for _, schema := range schemas {
+		tool := map[string]any{
+			"name":         schema.Name,
+			"input_schema": schema.Parameters,
+		}
+		if schema.Description != "" {
+			tool["description"] = schema.Description
+		}
+		b, _ := common.Marshal(tool)
+		builder.Write(b)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// renderResponsesToolSchemas 按 OpenAI 的方式将函数定义渲染为 TypeScript 风格的声明
+func renderResponsesToolSchemas(schemas []types.ToolSchemaMeta) string {
+	var builder strings.Builder
+	builder.WriteString(responsesToolHeader)
+	for _, schema := range schemas {
+		if schema.Description != "" {
+			builder.WriteString(fmt.Sprintf("// %s\n", schema.Description))
+		}
+		properties, _ := schema.Parameters["properties"].(map[string]any)
+		if len(properties) == 0 {
+			builder.WriteString(fmt.Sprintf("type %s = () => any;\n\n", schema.Name))
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("type %s = (_: %s) => any;\n\n", schema.Name, renderTypeScriptObject(schema.Parameters)))
+	}
+	builder.WriteString(responsesToolFooter)
+	return builder.String()
+}
+
+// renderTypeScriptObject 将 object 类型的 JSON Schema 渲染为 TypeScript 对象类型，属性按名称排序以保证结果稳定
+func renderTypeScriptObject(schema map[string]any) string {
+	properties, _ := schema["properties"].(map[string]any)
+	if len(properties) == 0 {
+		return "object"
+	}
+	required := make(map[string]bool)
+	if requiredList, ok := schema["required"].([]any); ok {
+		for _, name := range requiredList {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	builder.WriteString("{\n")
+	for _, name := range names {
+		property, _ := properties[name].(map[string]any)
+		if description, ok := property["description"].(string); ok && description != "" {
+			builder.WriteString(fmt.Sprintf("// %s\n", description))
+		}
+		optional := "?"
+		if required[name] {
+			optional = ""
+		}
+		builder.WriteString(fmt.Sprintf("%s%s: %s,\n", name, optional, renderTypeScriptType(property)))
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
+
+// renderTypeScriptType 将 JSON Schema 渲染为 TypeScript 类型表达式
+func renderTypeScriptType(schema map[string]any) string {
+	if schema == nil {
+		return "any"
+	}
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		values := make([]string, 0, len(enum))
+		for _, value := range enum {
+			b, _ := common.Marshal(value)
+			values = append(values, string(b))
+		}
+		return strings.Join(values, " | ")
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if variants, ok := schema[key].([]any); ok && len(variants) > 0 {
+			unionTypes := make([]string, 0, len(variants))
+			for _, variant := range variants {
+				variantSchema, _ := variant.(map[string]any)
+				unionTypes = append(unionTypes, renderTypeScriptType(variantSchema))
+			}
+			return strings.Join(unionTypes, " | ")
+		}
+	}
+	switch schemaType := schema["type"].(type) {
+	case string:
+		return renderTypeScriptPrimitive(schemaType, schema)
+	case []any:
+		unionTypes := make([]string, 0, len(schemaType))
+		for _, t := range schemaType {
+			if s, ok := t.(string); ok {
+				unionTypes = append(unionTypes, renderTypeScriptPrimitive(s, schema))
+			}
+		}
+		if len(unionTypes) > 0 {
+			return strings.Join(unionTypes, " | ")
+		}
+	}
+	return "any"
+}
+
+func renderTypeScriptPrimitive(schemaType string, schema map[string]any) string {
+	switch schemaType {
+	case "string", "boolean", "null":
+		return schemaType
+	case "number", "integer":
+		return "number"
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		return renderTypeScriptType(items) + "[]"
+	case "object":
+		return renderTypeScriptObject(schema)
+	default:
+		return "any"
+	}
+}
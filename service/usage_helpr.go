@@ -29,6 +29,16 @@ func ResponseText2Usage(c *gin.Context, responseText string, modeName string, pr
 	return usage
 }
 
+// StreamTextCounter2Usage 使用流式过程中增量统计的 token 数构造 usage
+func StreamTextCounter2Usage(c *gin.Context, counter *StreamTextCounter, promptTokens int) *dto.Usage {
+	common.SetContextKey(c, constant.ContextKeyLocalCountTokens, true)
+	usage := &dto.Usage{}
+	usage.PromptTokens = promptTokens
+	usage.CompletionTokens = counter.TokenCount()
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
 func ValidUsage(usage *dto.Usage) bool {
 	return usage != nil && (usage.PromptTokens != 0 || usage.CompletionTokens != 0)
 }
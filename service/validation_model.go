@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// validationMirrorMaxInFlight 同时进行的镜像请求数量上限，超过时丢弃新的镜像，避免镜像流量挤占正常请求
+const validationMirrorMaxInFlight = 16
+
+var (
+	validationSuccessCount = make(map[string]int)
+	validationMutex        sync.Mutex
+	validationMirrorCount  atomic.Int32
+)
+
+// IsValidationModelAllowed 判断用户是否可以访问模型
+// 处于验证期的模型仅允许管理员访问，镜像流量由系统直接发起，不经过该检查
+// 参数:
+//   - modelName: 请求的模型名称
+//   - role: 用户角色，由鉴权中间件写入上下文或取自用户缓存
+func IsValidationModelAllowed(modelName string, role int) bool {
+	if _, ok := model_setting.GetResponsesSettings().GetValidationThreshold(modelName); !ok {
+		return true
+	}
+	return role >= common.RoleAdminUser
+}
+
+// RecordValidationModelSuccess 记录验证期模型的一次成功转换请求，达到阈值后自动转正
+func RecordValidationModelSuccess(info *relaycommon.RelayInfo, modelName string) {
+	if !info.IsConverted() {
+		return
+	}
+	settings := model_setting.GetResponsesSettings()
+	threshold, ok := settings.GetValidationThreshold(modelName)
+	if !ok {
+		return
+	}
+
+	validationMutex.Lock()
+	defer validationMutex.Unlock()
+	validationSuccessCount[modelName]++
+	if validationSuccessCount[modelName] < threshold {
+		return
+	}
+
+	validationModels := make(map[string]int, len(settings.ValidationModels))
+	for name, count := range settings.ValidationModels {
+		if name != modelName {
+			validationModels[name] = count
+		}
+	}
+	jsonBytes, err := common.Marshal(validationModels)
+	if err != nil {
+		common.SysError("failed to marshal validation models: " + err.Error())
+		return
+	}
+	if err := model.UpdateOption("responses.validation_models", string(jsonBytes)); err != nil {
+		common.SysError("failed to promote validation model: " + err.Error())
+		return
+	}
+	delete(validationSuccessCount, modelName)
+	common.SysLog(fmt.Sprintf("模型 %s 已完成 %d 次成功转换请求，自动结束验证期", modelName, threshold))
+}
+
+// AcquireValidationMirror 判断来源模型的请求是否需要镜像到验证期模型，按配置比例抽样并限制同时进行的镜像数量
+// 返回 ok 为 true 时调用方需在镜像请求结束后调用 ReleaseValidationMirror
+// 参数:
+//   - modelName: 来源请求的模型名称
+//
+// 返回:
+//   - string: 镜像目标的验证期模型
+//   - bool: 是否需要镜像
+func AcquireValidationMirror(modelName string) (string, bool) {
+	settings := model_setting.GetResponsesSettings()
+	target, ok := settings.GetValidationMirrorTarget(modelName)
+	if !ok || target == modelName || rand.Float64() >= settings.ValidationMirrorRate {
+		return "", false
+	}
+	if validationMirrorCount.Add(1) > validationMirrorMaxInFlight {
+		validationMirrorCount.Add(-1)
+		return "", false
+	}
+	return target, true
+}
+
+// ReleaseValidationMirror 释放镜像请求占用的并发名额
+func ReleaseValidationMirror() {
+	validationMirrorCount.Add(-1)
+}
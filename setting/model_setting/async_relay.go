@@ -0,0 +1,48 @@
+package model_setting
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// AsyncRelaySettings 异步非流式请求配置
+type AsyncRelaySettings struct {
+	// Enabled 是否允许客户端通过 X-NewAPI-Async 请求头以异步模式提交非流式请求
+	Enabled bool `json:"enabled"`
+	// Workers 并发处理异步任务的 worker 数量
+	Workers int `json:"workers"`
+	// QueueSize 等待处理的任务上限，队列已满时拒绝新的异步请求
+	QueueSize int `json:"queue_size"`
+	// JobTTLSeconds 任务结果的保留秒数，超过后无法再轮询
+	JobTTLSeconds int `json:"job_ttl_seconds"`
+}
+
+// 默认配置
+var defaultAsyncRelaySettings = AsyncRelaySettings{
+	Enabled:       false,
+	Workers:       4,
+	QueueSize:     100,
+	JobTTLSeconds: 3600,
+}
+
+// 全局实例
+var asyncRelaySettings = defaultAsyncRelaySettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("async_relay", &asyncRelaySettings)
+}
+
+// GetAsyncRelaySettings 获取异步请求配置
+func GetAsyncRelaySettings() *AsyncRelaySettings {
+	return &asyncRelaySettings
+}
+
+// GetJobTTL 获取任务结果的保留时长
+func (s *AsyncRelaySettings) GetJobTTL() time.Duration {
+	if s.JobTTLSeconds <= 0 {
+		return time.Duration(defaultAsyncRelaySettings.JobTTLSeconds) * time.Second
+	}
+	return time.Duration(s.JobTTLSeconds) * time.Second
+}
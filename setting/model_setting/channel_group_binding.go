@@ -0,0 +1,66 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 渠道分组绑定的范围
+const (
+	ChannelGroupBindingScopeUser  = "user"
+	ChannelGroupBindingScopeToken = "token"
+)
+
+// ChannelGroupBinding 将用户或令牌绑定到指定的渠道分组
+type ChannelGroupBinding struct {
+	// Scope 绑定范围，user 或 token
+	Scope string `json:"scope"`
+	// Id 用户 ID 或令牌 ID
+	Id int `json:"id"`
+	// Group 绑定的渠道分组
+	Group string `json:"group"`
+}
+
+// ChannelGroupBindingSettings 用户与令牌的渠道分组绑定，绑定后渠道选择（包括重试、自动分组与智能路由）只在绑定的分组内进行，
+// 用于数据驻留等需要限定上游范围的场景（例如 GDPR 客户只使用欧盟渠道）。绑定不影响计费分组
+type ChannelGroupBindingSettings struct {
+	Bindings []ChannelGroupBinding `json:"bindings"`
+}
+
+// 默认配置
+var defaultChannelGroupBindingSettings = ChannelGroupBindingSettings{
+	Bindings: []ChannelGroupBinding{},
+}
+
+// 全局实例
+var channelGroupBindingSettings = defaultChannelGroupBindingSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_group_binding", &channelGroupBindingSettings)
+}
+
+// GetChannelGroupBindingSettings 获取渠道分组绑定配置
+func GetChannelGroupBindingSettings() *ChannelGroupBindingSettings {
+	return &channelGroupBindingSettings
+}
+
+// GetBoundChannelGroup 获取用户或令牌绑定的渠道分组，令牌的绑定优先于用户的绑定
+// 参数:
+//   - userId: 用户 ID
+//   - tokenId: 令牌 ID，为 0 时只查找用户的绑定
+//
+// 返回:
+//   - string: 绑定的渠道分组
+//   - bool: 是否存在绑定
+func GetBoundChannelGroup(userId int, tokenId int) (string, bool) {
+	userGroup := ""
+	for _, binding := range channelGroupBindingSettings.Bindings {
+		switch {
+		case binding.Scope == ChannelGroupBindingScopeToken && tokenId != 0 && binding.Id == tokenId:
+			return binding.Group, true
+		case binding.Scope == ChannelGroupBindingScopeUser && binding.Id == userId:
+			userGroup = binding.Group
+		}
+	}
+	return userGroup, userGroup != ""
+}
@@ -0,0 +1,35 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ChannelSLOSettings 渠道 SLO 跟踪配置，各渠道的目标值在渠道设置中单独配置
+type ChannelSLOSettings struct {
+	// Enabled 是否根据 SLO 达标情况调整渠道选择，违反 SLO 的渠道排在达标渠道之后
+	Enabled bool `json:"enabled"`
+	// WindowSeconds 统计首字延迟与错误率的时间窗口
+	WindowSeconds int `json:"window_seconds"`
+	// MinRequests 窗口内请求数少于该值时不判定违反 SLO，避免少量请求造成误判
+	MinRequests int `json:"min_requests"`
+}
+
+// 默认配置
+var defaultChannelSLOSettings = ChannelSLOSettings{
+	Enabled:       false,
+	WindowSeconds: 300,
+	MinRequests:   20,
+}
+
+// 全局实例
+var channelSLOSettings = defaultChannelSLOSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_slo", &channelSLOSettings)
+}
+
+// GetChannelSLOSettings 获取渠道 SLO 跟踪配置
+func GetChannelSLOSettings() *ChannelSLOSettings {
+	return &channelSLOSettings
+}
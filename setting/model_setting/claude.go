@@ -2,6 +2,7 @@ package model_setting
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/QuantumNous/new-api/setting/config"
 )
@@ -18,6 +19,17 @@ type ClaudeSettings struct {
 	DefaultMaxTokens                      map[string]int                 `json:"default_max_tokens"`
 	ThinkingAdapterEnabled                bool                           `json:"thinking_adapter_enabled"`
 	ThinkingAdapterBudgetTokensPercentage float64                        `json:"thinking_adapter_budget_tokens_percentage"`
+	// RawResponsePassthrough 原生 Claude 渠道以 Claude 格式返回时，仅解析计费所需的字段并原样转发上游响应，
+	// 新增的顶层字段（如 context_management）或字段结构变化不会导致解析失败
+	RawResponsePassthrough bool `json:"raw_response_passthrough"`
+	// LongOutputBetas 表示客户端请求长输出的 anthropic-beta 特性，按前缀匹配，如 output-128k 可匹配 output-128k-2025-02-19
+	LongOutputBetas []string `json:"long_output_betas"`
+	// LongOutputMaxTokens 长输出请求的输出 token 上限，放宽网关全局输出上限，路由到 Responses 渠道时作为 max_output_tokens 的上限与默认值
+	LongOutputMaxTokens int `json:"long_output_max_tokens"`
+	// LongOutputStreamingTimeout 长输出请求的流式空闲超时（秒），低于全局 STREAMING_TIMEOUT 时不生效
+	LongOutputStreamingTimeout int `json:"long_output_streaming_timeout"`
+	// LongOutputBodyLimitMultiplier 长输出请求记录响应原文与日志保存响应体时，大小上限放大的倍数
+	LongOutputBodyLimitMultiplier int `json:"long_output_body_limit_multiplier"`
 }
 
 // 默认配置
@@ -28,6 +40,10 @@ var defaultClaudeSettings = ClaudeSettings{
 		"default": 8192,
 	},
 	ThinkingAdapterBudgetTokensPercentage: 0.8,
+	LongOutputBetas:                       []string{"output-128k"},
+	LongOutputMaxTokens:                   128000,
+	LongOutputStreamingTimeout:            600,
+	LongOutputBodyLimitMultiplier:         8,
 }
 
 // 全局实例
@@ -67,6 +83,21 @@ func (c *ClaudeSettings) WriteHeaders(originModel string, httpHeader *http.Heade
 	}
 }
 
+// IsLongOutputRequest 判断 anthropic-beta 请求头中是否包含长输出特性
+func (c *ClaudeSettings) IsLongOutputRequest(anthropicBetas []string) bool {
+	for _, value := range anthropicBetas {
+		for _, feature := range strings.Split(value, ",") {
+			feature = strings.TrimSpace(feature)
+			for _, beta := range c.LongOutputBetas {
+				if beta != "" && strings.HasPrefix(feature, beta) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (c *ClaudeSettings) GetDefaultMaxTokens(model string) int {
 	if maxTokens, ok := c.DefaultMaxTokens[model]; ok {
 		return maxTokens
@@ -0,0 +1,46 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ConversationTrimSettings 转换请求的多轮对话裁剪配置，历史超出 token 预算时丢弃或摘要较早的轮次
+type ConversationTrimSettings struct {
+	Enabled bool `json:"enabled"`
+	// MaxPromptTokens 转换后请求的提示 token 预算（估算值）
+	MaxPromptTokens int `json:"max_prompt_tokens"`
+	// KeepRecentItems 始终保留的最近输入项数量
+	KeepRecentItems int `json:"keep_recent_items"`
+	// SummaryChannelId 生成摘要使用的渠道，需在请求所属分组下提供摘要模型，摘要费用计入请求所属用户，为 0 时直接丢弃较早的轮次
+	SummaryChannelId int `json:"summary_channel_id"`
+	// SummaryModel 生成摘要使用的模型，建议使用低成本模型
+	SummaryModel string `json:"summary_model"`
+	// SummaryMaxTokens 摘要的最大输出 token 数，同时作为裁剪时为摘要预留的预算
+	SummaryMaxTokens int `json:"summary_max_tokens"`
+	// SummaryTimeoutSeconds 生成摘要的超时时间，超时后退回直接丢弃
+	SummaryTimeoutSeconds int `json:"summary_timeout_seconds"`
+}
+
+// 默认配置
+var defaultConversationTrimSettings = ConversationTrimSettings{
+	Enabled:               false,
+	MaxPromptTokens:       100000,
+	KeepRecentItems:       4,
+	SummaryChannelId:      0,
+	SummaryModel:          "gpt-4o-mini",
+	SummaryMaxTokens:      1024,
+	SummaryTimeoutSeconds: 20,
+}
+
+// 全局实例
+var conversationTrimSettings = defaultConversationTrimSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("conversation_trim", &conversationTrimSettings)
+}
+
+// GetConversationTrimSettings 获取对话裁剪配置
+func GetConversationTrimSettings() *ConversationTrimSettings {
+	return &conversationTrimSettings
+}
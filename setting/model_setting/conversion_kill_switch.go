@@ -0,0 +1,90 @@
+package model_setting
+
+import (
+	"os"
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	// ConversionDirectionChatToResponses Chat Completions 请求转换为 Responses 请求
+	ConversionDirectionChatToResponses = "chat_to_responses"
+	// ConversionDirectionClaudeToResponses Claude Messages 请求转换为 Responses 请求
+	ConversionDirectionClaudeToResponses = "claude_to_responses"
+	// ConversionDirectionGeminiToResponses Gemini generateContent 请求转换为 Responses 请求
+	ConversionDirectionGeminiToResponses = "gemini_to_responses"
+	// ConversionDirectionResponsesToClaude Claude 渠道智能路由到 Responses 接口，并将 Responses 响应转换为 Claude 格式
+	ConversionDirectionResponsesToClaude = "responses_to_claude"
+	// ConversionDirectionResponsesToMessages 原生 Responses 请求在 Claude 渠道上转换为 Claude Messages 请求
+	ConversionDirectionResponsesToMessages = "responses_to_messages"
+)
+
+// ConversionDirections 所有可单独关闭的转换方向
+var ConversionDirections = []string{
+	ConversionDirectionChatToResponses,
+	ConversionDirectionClaudeToResponses,
+	ConversionDirectionGeminiToResponses,
+	ConversionDirectionResponsesToClaude,
+	ConversionDirectionResponsesToMessages,
+}
+
+// ConversionKillSwitchSettings 转换方向全局开关，用于转换器出现问题时快速止损
+type ConversionKillSwitchSettings struct {
+	// DisabledDirections 已关闭的转换方向，关闭后请求回退到原生渠道，无原生渠道时直接返回错误
+	DisabledDirections []string `json:"disabled_directions"`
+}
+
+// 默认配置
+var defaultConversionKillSwitchSettings = ConversionKillSwitchSettings{
+	DisabledDirections: []string{},
+}
+
+// 全局实例
+var conversionKillSwitchSettings = defaultConversionKillSwitchSettings
+
+// envDisabledConversionDirections 通过 DISABLED_CONVERSION_DIRECTIONS 环境变量关闭的转换方向，无法通过接口重新开启
+var envDisabledConversionDirections = map[string]bool{}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("conversion_kill_switch", &conversionKillSwitchSettings)
+	for _, direction := range strings.Split(os.Getenv("DISABLED_CONVERSION_DIRECTIONS"), ",") {
+		if direction = strings.TrimSpace(direction); direction != "" {
+			envDisabledConversionDirections[direction] = true
+		}
+	}
+}
+
+// GetConversionKillSwitchSettings 获取转换方向开关配置
+func GetConversionKillSwitchSettings() *ConversionKillSwitchSettings {
+	return &conversionKillSwitchSettings
+}
+
+// IsValidConversionDirection 判断是否为可识别的转换方向
+func IsValidConversionDirection(direction string) bool {
+	for _, d := range ConversionDirections {
+		if d == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// IsConversionDirectionDisabledByEnv 判断转换方向是否由环境变量关闭
+func IsConversionDirectionDisabledByEnv(direction string) bool {
+	return envDisabledConversionDirections[direction]
+}
+
+// IsConversionDirectionDisabled 判断转换方向是否已关闭，环境变量与运行时配置任一关闭即生效
+func IsConversionDirectionDisabled(direction string) bool {
+	if envDisabledConversionDirections[direction] {
+		return true
+	}
+	for _, d := range conversionKillSwitchSettings.DisabledDirections {
+		if d == direction {
+			return true
+		}
+	}
+	return false
+}
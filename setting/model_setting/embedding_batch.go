@@ -0,0 +1,48 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// EmbeddingBatchSettings 向量请求的自动分批配置，输入数超过上游单次请求上限时拆分为多个请求并发发送，按原顺序合并结果
+type EmbeddingBatchSettings struct {
+	Enabled bool `json:"enabled"`
+	// MaxInputsPerRequest 单次上游请求的最大输入数
+	MaxInputsPerRequest int `json:"max_inputs_per_request"`
+	// ModelMaxInputs 按上游模型单独配置的最大输入数，优先于 MaxInputsPerRequest
+	ModelMaxInputs map[string]int `json:"model_max_inputs"`
+	// MaxConcurrency 同时发送的分批请求数
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+// 默认配置
+var defaultEmbeddingBatchSettings = EmbeddingBatchSettings{
+	Enabled:             true,
+	MaxInputsPerRequest: 2048,
+	ModelMaxInputs:      map[string]int{},
+	MaxConcurrency:      4,
+}
+
+// 全局实例
+var embeddingBatchSettings = defaultEmbeddingBatchSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("embedding_batch", &embeddingBatchSettings)
+}
+
+// GetEmbeddingBatchSettings 获取向量请求分批配置
+func GetEmbeddingBatchSettings() *EmbeddingBatchSettings {
+	return &embeddingBatchSettings
+}
+
+// GetMaxInputs 获取上游模型单次请求的最大输入数，未启用分批时返回 0
+func (s *EmbeddingBatchSettings) GetMaxInputs(modelName string) int {
+	if !s.Enabled {
+		return 0
+	}
+	if limit, ok := s.ModelMaxInputs[modelName]; ok && limit > 0 {
+		return limit
+	}
+	return s.MaxInputsPerRequest
+}
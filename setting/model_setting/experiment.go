@@ -0,0 +1,134 @@
+package model_setting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ExperimentArm 实验的一个分组，未设置的参数保持请求原样
+type ExperimentArm struct {
+	// Name 分组名称，同一实验内唯一
+	Name string `json:"name"`
+	// Weight 流量权重，按权重占比分配令牌
+	Weight int `json:"weight"`
+	// ReasoningEffort 覆盖 Responses 请求（含转换为 Responses 的请求）的 reasoning.effort
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// ChannelType 优先选择的渠道类型（例如原生 Claude 渠道与 Responses 渠道对比），分组内没有该类型的渠道时使用其他渠道
+	ChannelType int `json:"channel_type,omitempty"`
+}
+
+// Experiment 转发层 A/B 实验，命中的请求按令牌固定分配到一个分组
+type Experiment struct {
+	// Name 实验名称，唯一
+	Name string `json:"name"`
+	// Enabled 是否启用，停用的实验保留配置与已收集的指标
+	Enabled bool `json:"enabled"`
+	// Models 参与实验的模型，为空时所有模型参与
+	Models []string `json:"models"`
+	// Arms 实验分组
+	Arms []ExperimentArm `json:"arms"`
+}
+
+// ExperimentSettings 转发层 A/B 实验配置，一个请求只参与第一个命中的启用实验
+type ExperimentSettings struct {
+	Experiments []Experiment `json:"experiments"`
+}
+
+// 默认配置
+var defaultExperimentSettings = ExperimentSettings{
+	Experiments: []Experiment{},
+}
+
+// 全局实例
+var experimentSettings = defaultExperimentSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("experiment", &experimentSettings)
+}
+
+// GetExperimentSettings 获取 A/B 实验配置
+func GetExperimentSettings() *ExperimentSettings {
+	return &experimentSettings
+}
+
+// GetExperiment 按名称获取实验
+func GetExperiment(name string) (*Experiment, bool) {
+	for i := range experimentSettings.Experiments {
+		if experimentSettings.Experiments[i].Name == name {
+			return &experimentSettings.Experiments[i], true
+		}
+	}
+	return nil, false
+}
+
+// GetExperimentArm 按实验与分组名称获取分组
+func GetExperimentArm(experiment string, arm string) (*ExperimentArm, bool) {
+	e, ok := GetExperiment(experiment)
+	if !ok {
+		return nil, false
+	}
+	for i := range e.Arms {
+		if e.Arms[i].Name == arm {
+			return &e.Arms[i], true
+		}
+	}
+	return nil, false
+}
+
+// MatchesModel 判断模型是否参与实验
+func (e *Experiment) MatchesModel(modelName string) bool {
+	if len(e.Models) == 0 {
+		return true
+	}
+	for _, m := range e.Models {
+		if m == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExperiments 校验实验配置：实验与分组名称唯一且非空，权重非负且至少一个分组有流量，推理强度合法
+func ValidateExperiments(experiments []Experiment) error {
+	names := make(map[string]bool, len(experiments))
+	for _, e := range experiments {
+		if strings.TrimSpace(e.Name) == "" {
+			return fmt.Errorf("实验名称不能为空")
+		}
+		if names[e.Name] {
+			return fmt.Errorf("实验名称重复: %s", e.Name)
+		}
+		names[e.Name] = true
+		if len(e.Arms) < 2 {
+			return fmt.Errorf("实验 %s 至少需要两个分组", e.Name)
+		}
+		armNames := make(map[string]bool, len(e.Arms))
+		totalWeight := 0
+		for _, arm := range e.Arms {
+			if strings.TrimSpace(arm.Name) == "" {
+				return fmt.Errorf("实验 %s 的分组名称不能为空", e.Name)
+			}
+			if armNames[arm.Name] {
+				return fmt.Errorf("实验 %s 的分组名称重复: %s", e.Name, arm.Name)
+			}
+			armNames[arm.Name] = true
+			if arm.Weight < 0 {
+				return fmt.Errorf("实验 %s 分组 %s 的权重不能为负数", e.Name, arm.Name)
+			}
+			totalWeight += arm.Weight
+			if arm.ReasoningEffort != "" && !validReasoningEfforts[arm.ReasoningEffort] {
+				return fmt.Errorf("实验 %s 分组 %s 的推理强度无效: %s", e.Name, arm.Name, arm.ReasoningEffort)
+			}
+			if arm.ChannelType < 0 {
+				return fmt.Errorf("实验 %s 分组 %s 的渠道类型无效: %d", e.Name, arm.Name, arm.ChannelType)
+			}
+		}
+		if totalWeight == 0 {
+			return fmt.Errorf("实验 %s 的分组权重之和必须大于 0", e.Name)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package model_setting
 
 import (
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/setting/config"
 )
@@ -9,6 +10,17 @@ import (
 type GlobalSettings struct {
 	PassThroughRequestEnabled bool     `json:"pass_through_request_enabled"`
 	ThinkingModelBlacklist    []string `json:"thinking_model_blacklist"`
+	// RoutingHeadersEnabled 是否在响应头中返回渠道类型、转换来源与上游模型等路由信息
+	RoutingHeadersEnabled bool `json:"routing_headers_enabled"`
+	// MaxOutputTokens 网关对单次请求流式输出 token 数的上限，0 表示不限制，令牌设置了更小的上限时以令牌为准
+	// 适用于 OpenAI、Responses、原生 Claude Messages 与 Gemini 的流式响应，非流式响应不截断
+	MaxOutputTokens int `json:"max_output_tokens"`
+	// RetryAfterMaxWaitSeconds 重试时遵循上游 Retry-After 的最长等待秒数，超过则不再等待该渠道
+	RetryAfterMaxWaitSeconds int `json:"retry_after_max_wait_seconds"`
+	// ErrorMessageLanguage 转发错误信息的语言，auto 按请求的 Accept-Language 选择，也可固定为 en 或 zh
+	ErrorMessageLanguage string `json:"error_message_language"`
+	// ResponseHeaderPassthrough 转发给客户端的上游响应头白名单，不区分大小写，支持以 * 结尾的前缀匹配，原生与转换请求均生效
+	ResponseHeaderPassthrough []string `json:"response_header_passthrough"`
 }
 
 // 默认配置
@@ -18,6 +30,8 @@ var defaultOpenaiSettings = GlobalSettings{
 		"moonshotai/kimi-k2-thinking",
 		"kimi-k2-thinking",
 	},
+	RetryAfterMaxWaitSeconds: 5,
+	ErrorMessageLanguage:     "auto",
 }
 
 // 全局实例
@@ -32,6 +46,36 @@ func GetGlobalSettings() *GlobalSettings {
 	return &globalSettings
 }
 
+// GetRetryAfterMaxWait 获取重试时遵循上游 Retry-After 的最长等待时长
+func GetRetryAfterMaxWait() time.Duration {
+	if globalSettings.RetryAfterMaxWaitSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(globalSettings.RetryAfterMaxWaitSeconds) * time.Second
+}
+
+// IsResponseHeaderPassthroughAllowed 判断上游响应头是否在转发白名单中
+func IsResponseHeaderPassthroughAllowed(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return false
+	}
+	for _, entry := range globalSettings.ResponseHeaderPassthrough {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if entry == name {
+			return true
+		}
+	}
+	return false
+}
+
 // ShouldPreserveThinkingSuffix 判断模型是否配置为保留 thinking/-nothinking 后缀
 func ShouldPreserveThinkingSuffix(modelName string) bool {
 	target := strings.TrimSpace(modelName)
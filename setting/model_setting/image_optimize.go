@@ -0,0 +1,46 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ImageOptimizeSettings 转换请求中内联 base64 图片的压缩配置，发送上游前缩小尺寸并重新编码，降低图片 token 成本并避免超出上游大小限制
+// 渠道可通过 optimize_inline_images 单独开启或关闭
+type ImageOptimizeSettings struct {
+	Enabled bool `json:"enabled"`
+	// MaxPixels 图片像素总数上限，超出时按比例缩小
+	MaxPixels int `json:"max_pixels"`
+	// JPEGQuality 重新编码为 JPEG 时的质量（1-100），带透明通道的图片保持 PNG
+	JPEGQuality int `json:"jpeg_quality"`
+	// MinBytes 小于该字节数且未超出像素上限的图片不处理
+	MinBytes int `json:"min_bytes"`
+}
+
+// 默认配置
+var defaultImageOptimizeSettings = ImageOptimizeSettings{
+	Enabled:     false,
+	MaxPixels:   1536 * 1536,
+	JPEGQuality: 85,
+	MinBytes:    512 * 1024,
+}
+
+// 全局实例
+var imageOptimizeSettings = defaultImageOptimizeSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("image_optimize", &imageOptimizeSettings)
+}
+
+// GetImageOptimizeSettings 获取内联图片压缩配置
+func GetImageOptimizeSettings() *ImageOptimizeSettings {
+	return &imageOptimizeSettings
+}
+
+// IsEnabledForChannel 判断渠道是否压缩内联图片，渠道未单独配置时跟随全局开关
+func (s *ImageOptimizeSettings) IsEnabledForChannel(channelToggle *bool) bool {
+	if channelToggle != nil {
+		return *channelToggle
+	}
+	return s.Enabled
+}
@@ -0,0 +1,66 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	// LoadBalanceStrategyPriority 按优先级分层，同层内按权重随机（默认行为）
+	LoadBalanceStrategyPriority = "priority"
+	// LoadBalanceStrategyWeightedRandom 忽略优先级，在全部渠道间按权重随机
+	LoadBalanceStrategyWeightedRandom = "weighted_random"
+	// LoadBalanceStrategyLeastConnections 选择按权重折算后进行中请求最少的渠道
+	LoadBalanceStrategyLeastConnections = "least_connections"
+	// LoadBalanceStrategyLowestErrorRate 选择近期错误率最低的渠道
+	LoadBalanceStrategyLowestErrorRate = "lowest_error_rate"
+)
+
+// LoadBalanceSettings 渠道负载均衡策略配置
+type LoadBalanceSettings struct {
+	// DefaultStrategy 未单独配置的模型使用的策略
+	DefaultStrategy string `json:"default_strategy"`
+	// Strategies 按模型配置的策略，key 为模型名或 分组:模型名，后者优先
+	Strategies map[string]string `json:"strategies"`
+	// ErrorRateWindowSeconds lowest_error_rate 策略统计错误率的时间窗口
+	ErrorRateWindowSeconds int `json:"error_rate_window_seconds"`
+}
+
+// 默认配置
+var defaultLoadBalanceSettings = LoadBalanceSettings{
+	DefaultStrategy:        LoadBalanceStrategyPriority,
+	Strategies:             map[string]string{},
+	ErrorRateWindowSeconds: 300,
+}
+
+// 全局实例
+var loadBalanceSettings = defaultLoadBalanceSettings
+
+var validLoadBalanceStrategies = map[string]bool{
+	LoadBalanceStrategyPriority:         true,
+	LoadBalanceStrategyWeightedRandom:   true,
+	LoadBalanceStrategyLeastConnections: true,
+	LoadBalanceStrategyLowestErrorRate:  true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("load_balance", &loadBalanceSettings)
+}
+
+// GetLoadBalanceSettings 获取负载均衡配置
+func GetLoadBalanceSettings() *LoadBalanceSettings {
+	return &loadBalanceSettings
+}
+
+// GetStrategy 获取分组下模型使用的负载均衡策略，配置非法时回退为 priority
+func (s *LoadBalanceSettings) GetStrategy(group string, modelName string) string {
+	for _, key := range []string{group + ":" + modelName, modelName} {
+		if strategy, ok := s.Strategies[key]; ok && validLoadBalanceStrategies[strategy] {
+			return strategy
+		}
+	}
+	if validLoadBalanceStrategies[s.DefaultStrategy] {
+		return s.DefaultStrategy
+	}
+	return LoadBalanceStrategyPriority
+}
@@ -0,0 +1,48 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ModelGroupPolicy 模型组的路由策略
+type ModelGroupPolicy struct {
+	// Models 模型回退阶梯，按顺序选择第一个存在可用渠道的模型
+	Models []string `json:"models"`
+	// PreferredChannelTypes 优先选择的渠道类型，按顺序尝试，均无可用渠道时再使用其他类型的渠道
+	PreferredChannelTypes []int `json:"preferred_channel_types"`
+	// SmartRoutingEnabled 是否允许将 Chat/Claude 请求路由到需要格式转换的 OpenAI Responses 渠道
+	SmartRoutingEnabled bool `json:"smart_routing_enabled"`
+}
+
+// ModelGroupSettings 管理员定义的模型组，令牌可以将模型组名称作为模型名请求，由网关解析为具体模型
+type ModelGroupSettings struct {
+	Groups map[string]ModelGroupPolicy `json:"groups"`
+}
+
+// 默认配置
+var defaultModelGroupSettings = ModelGroupSettings{
+	Groups: map[string]ModelGroupPolicy{},
+}
+
+// 全局实例
+var modelGroupSettings = defaultModelGroupSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_group", &modelGroupSettings)
+}
+
+// GetModelGroupSettings 获取模型组配置
+func GetModelGroupSettings() *ModelGroupSettings {
+	return &modelGroupSettings
+}
+
+// GetModelGroupPolicy 获取模型组的路由策略
+// 返回值 ok 为 false 表示该名称不是模型组
+func GetModelGroupPolicy(name string) (*ModelGroupPolicy, bool) {
+	policy, ok := modelGroupSettings.Groups[name]
+	if !ok || len(policy.Models) == 0 {
+		return nil, false
+	}
+	return &policy, true
+}
@@ -0,0 +1,86 @@
+package model_setting
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 响应后处理规则类型
+const (
+	PostProcessTypeRegexReplace   = "regex_replace"
+	PostProcessTypeStripCodeFence = "strip_code_fence"
+	PostProcessTypeTrimPrefix     = "trim_prefix"
+	PostProcessTypeTrimSuffix     = "trim_suffix"
+)
+
+// PostProcessRule 响应文本后处理规则，在格式转换之后、写出响应之前按顺序应用
+type PostProcessRule struct {
+	// Channels 生效的渠道 ID，为空表示所有渠道
+	Channels []int `json:"channels"`
+	// Models 生效的模型，支持以 * 结尾的前缀匹配，为空表示所有模型
+	Models []string `json:"models"`
+	// Type 规则类型：regex_replace、strip_code_fence、trim_prefix、trim_suffix
+	Type string `json:"type"`
+	// Pattern regex_replace 的正则表达式，或 trim_prefix/trim_suffix 要去除的固定文本
+	Pattern string `json:"pattern"`
+	// Replacement regex_replace 的替换文本，支持 $1 等分组引用
+	Replacement string `json:"replacement"`
+}
+
+// PostProcessSettings 响应后处理配置
+type PostProcessSettings struct {
+	Rules []PostProcessRule `json:"rules"`
+}
+
+// 默认配置
+var defaultPostProcessSettings = PostProcessSettings{
+	Rules: []PostProcessRule{},
+}
+
+// 全局实例
+var postProcessSettings = defaultPostProcessSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("post_process", &postProcessSettings)
+}
+
+// GetPostProcessSettings 获取响应后处理配置
+func GetPostProcessSettings() *PostProcessSettings {
+	return &postProcessSettings
+}
+
+// Matches 判断规则是否对指定渠道与模型生效，modelNames 中任一名称匹配即可
+func (r *PostProcessRule) Matches(channelId int, modelNames ...string) bool {
+	if len(r.Channels) > 0 {
+		matched := false
+		for _, id := range r.Channels {
+			if id == channelId {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.Models) == 0 {
+		return true
+	}
+	for _, pattern := range r.Models {
+		for _, name := range modelNames {
+			if name == "" {
+				continue
+			}
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+				if strings.HasPrefix(name, prefix) {
+					return true
+				}
+			} else if pattern == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package model_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ResponsesSettings 定义 OpenAI Responses 渠道格式转换相关的配置
+type ResponsesSettings struct {
+	// FinishReasonMapping Responses 状态到 Chat Completions finish_reason 的映射
+	// key 可以是 status（如 failed），也可以是 status:reason（如 incomplete:content_filter）
+	FinishReasonMapping map[string]string `json:"finish_reason_mapping"`
+	// ValidationModels 处于验证期的模型及其自动转正所需的成功转换请求数
+	// 验证期内仅管理员令牌可以访问，达到次数后自动从列表中移除
+	ValidationModels map[string]int `json:"validation_models"`
+	// ValidationMirrorSources 验证期模型的镜像流量来源，key 为验证期模型，value 为来源模型
+	// 来源模型的请求成功后按 ValidationMirrorRate 的比例在后台复制一份发往验证期模型，镜像请求不向用户计费，其成功转换同样计入转正次数
+	ValidationMirrorSources map[string]string `json:"validation_mirror_sources"`
+	// ValidationMirrorRate 来源模型请求被镜像的比例，0-1，0 表示不镜像
+	ValidationMirrorRate float64 `json:"validation_mirror_rate"`
+	// StreamDedupEnabled 是否启用流式增量去重，按事件序号丢弃上游重连后重放的增量
+	StreamDedupEnabled bool `json:"stream_dedup_enabled"`
+	// StrictSchemaValidation 是否严格校验上游 Responses 响应结构，校验失败时隔离响应并原样透传
+	StrictSchemaValidation bool `json:"strict_schema_validation"`
+	// EmulateCodeExecution Claude 代码执行工具路由到 Responses 渠道时是否模拟为 code_interpreter，关闭时直接拒绝
+	EmulateCodeExecution bool `json:"emulate_code_execution"`
+	// ClaudeBetaPolicy 带 ?beta=true 或 anthropic-beta 的 Claude 请求路由到 Responses 渠道时的处理方式
+	// strip: 丢弃 beta 标记；map: 将有等价能力的 beta 特性映射为 Responses 参数；native: 仅允许原生 Claude 渠道处理
+	ClaudeBetaPolicy string `json:"claude_beta_policy"`
+	// ClaudeUsageDeltaInterval 转换为 Claude 流式响应时，输出 token 每增长该数量发送一次携带累计 usage 的 message_delta，0 表示仅在结束时发送
+	ClaudeUsageDeltaInterval int `json:"claude_usage_delta_interval"`
+	// PromptCacheKeyMode 多轮请求转换为 Responses 格式时为同一会话注入稳定的缓存标识，提升上游提示词缓存命中率
+	// off: 不注入；prompt_cache_key: 写入 prompt_cache_key；user: 写入 user
+	PromptCacheKeyMode string `json:"prompt_cache_key_mode"`
+	// ClaudeStreamRequiredMaxTokens 非流式 Claude 请求的 max_tokens 达到该值时，网关以流式请求上游并聚合为非流式响应返回，避免长时间生成超时，0 表示关闭
+	ClaudeStreamRequiredMaxTokens int `json:"claude_stream_required_max_tokens"`
+	// AggregateUpstreamStream 转换后的非流式请求始终以流式请求上游，再由网关聚合为非流式响应，用于改善超时表现并尽早发现上游错误
+	AggregateUpstreamStream bool `json:"aggregate_upstream_stream"`
+	// QualitySamplingPercent 转换请求的抽样百分比（0-100），命中的请求/响应脱敏后进入人工审核队列，0 表示关闭
+	QualitySamplingPercent float64 `json:"quality_sampling_percent"`
+	// QualitySamplingQueueSize 审核队列保留的最大样本数，超出后丢弃最早的样本
+	QualitySamplingQueueSize int `json:"quality_sampling_queue_size"`
+	// DefaultReasoningEffort 按上游模型强制的默认推理强度，客户端未指定推理强度时生效，如 {"gpt-5.1":"low"}
+	DefaultReasoningEffort map[string]string `json:"default_reasoning_effort"`
+	// SystemMessageSeparator 请求包含多条系统消息时，按顺序拼接为 instructions 使用的分隔符
+	SystemMessageSeparator string `json:"system_message_separator"`
+	// ToolSchemaCacheSize 工具定义转换结果的缓存条目数，Agent 每轮请求携带相同的工具集时复用转换结果，0 表示关闭
+	ToolSchemaCacheSize int `json:"tool_schema_cache_size"`
+	// ToolMaxCount 转换后的请求允许携带的最大工具数，超出时在转发前拒绝，0 表示不校验
+	ToolMaxCount int `json:"tool_max_count"`
+	// ToolSchemaMaxDepth 函数工具 parameters 允许的最大嵌套层数，0 表示不校验
+	ToolSchemaMaxDepth int `json:"tool_schema_max_depth"`
+	// ToolSchemaMaxBytes 单个函数工具 parameters 序列化后允许的最大字节数，0 表示不校验
+	ToolSchemaMaxBytes int `json:"tool_schema_max_bytes"`
+	// ManagedConversationState 为 Chat / Claude 客户端模拟 Responses 的会话状态：网关记录每个会话最近一次的响应 ID，
+	// 下一轮请求的历史与上一轮一致时以 previous_response_id 代替重复发送的历史，仅发送新增的输入
+	ManagedConversationState bool `json:"managed_conversation_state"`
+	// ManagedConversationStateTTL 会话状态的保存时长（秒），应不超过上游保存响应的时长
+	ManagedConversationStateTTL int `json:"managed_conversation_state_ttl"`
+	// MapClaudeThinking Claude 请求开启 thinking 时按 budget_tokens 映射为 Responses 的 reasoning.effort 并请求推理摘要，摘要以 thinking 块返回
+	// 默认关闭以保持原有的转换行为，关闭时仍可通过令牌的 enable_reasoning_to_thinking 实验性开关单独开启
+	MapClaudeThinking bool `json:"map_claude_thinking"`
+	// ClaudeStreamConformanceCheck 调试用，校验转换后 Claude 流式事件的顺序（message_start 在前、块索引连续、message_stop 结束），违规时记录日志与转换说明
+	ClaudeStreamConformanceCheck bool `json:"claude_stream_conformance_check"`
+	// ChatImageOutput 上游 image_generation_call 生成的图片在 Chat Completions 响应中的表示方式
+	// markdown: 以 data URI 的 Markdown 图片追加到文本；content_parts: 非流式响应的 content 改为包含 image_url 的内容数组，流式响应始终使用 markdown
+	ChatImageOutput string `json:"chat_image_output"`
+	// StreamResumeEnabled 转换后的流式请求在上游流中途中断（未收到完成事件）且上游保存了响应时，按响应 ID 获取最终响应，
+	// 将尚未下发的剩余文本与函数调用补发给客户端并正常结束，已下发的内容无法续接时放弃恢复
+	StreamResumeEnabled bool `json:"stream_resume_enabled"`
+	// StreamResumeWaitSeconds 获取最终响应时等待上游完成生成的最长时间（秒）
+	StreamResumeWaitSeconds int `json:"stream_resume_wait_seconds"`
+	// ResponseIdMappingEnabled 启用后响应中的上游 ID（resp_、msg_、chatcmpl-）替换为网关签发的 ID，
+	// 客户端只会看到网关 ID，无法得知响应由哪个上游生成；查询与取消响应时按映射找回上游 ID 与渠道
+	// 映射保存在 Redis 中，未启用 Redis 时无法开启
+	ResponseIdMappingEnabled bool `json:"response_id_mapping_enabled"`
+	// ResponseIdPrefix 网关 ID 在类型前缀之后追加的标识，例如 resp_gw...，用于识别请求中引用的网关 ID
+	ResponseIdPrefix string `json:"response_id_prefix"`
+	// ResponseIdMappingTTL ID 映射的保存时间（秒）
+	ResponseIdMappingTTL int `json:"response_id_mapping_ttl"`
+	// ConversionDebugEnabled 允许客户端通过请求头 X-New-API-Debug: conversion 开启单次请求的转换追踪，
+	// 记录原始请求、发送给上游的请求、上游原始响应与返回给客户端的响应，写入日志库，管理员可按请求 ID 查询
+	// 仅 ConversionDebugUserIds 或 ConversionDebugTokenIds 中的用户或令牌可以开启，名单均为空时不记录任何请求
+	ConversionDebugEnabled bool `json:"conversion_debug_enabled"`
+	// ConversionDebugUserIds 允许开启转换追踪的用户 ID
+	ConversionDebugUserIds []int `json:"conversion_debug_user_ids"`
+	// ConversionDebugTokenIds 允许开启转换追踪的令牌 ID
+	ConversionDebugTokenIds []int `json:"conversion_debug_token_ids"`
+	// ConversionTraceQueueSize 日志库中保留的转换追踪记录数，超出后删除最早的记录
+	ConversionTraceQueueSize int `json:"conversion_trace_queue_size"`
+}
+
+const (
+	ClaudeBetaPolicyStrip  = "strip"
+	ClaudeBetaPolicyMap    = "map"
+	ClaudeBetaPolicyNative = "native"
+)
+
+const (
+	ChatImageOutputMarkdown     = "markdown"
+	ChatImageOutputContentParts = "content_parts"
+)
+
+const (
+	PromptCacheKeyModeOff            = "off"
+	PromptCacheKeyModePromptCacheKey = "prompt_cache_key"
+	PromptCacheKeyModeUser           = "user"
+)
+
+// 默认配置
+var defaultResponsesSettings = ResponsesSettings{
+	FinishReasonMapping: map[string]string{
+		"completed":                    "stop",
+		"incomplete":                   "length",
+		"incomplete:max_output_tokens": "length",
+		"incomplete:content_filter":    "content_filter",
+		"failed":                       "stop",
+		"cancelled":                    "stop",
+		"default":                      "stop",
+	},
+	ValidationModels:         map[string]int{},
+	ValidationMirrorSources:  map[string]string{},
+	ValidationMirrorRate:     0,
+	StreamDedupEnabled:       false,
+	StrictSchemaValidation:   false,
+	EmulateCodeExecution:     true,
+	ClaudeBetaPolicy:         ClaudeBetaPolicyStrip,
+	ClaudeUsageDeltaInterval: 50,
+	PromptCacheKeyMode:       PromptCacheKeyModeOff,
+	// 与 Anthropic SDK 要求必须使用流式请求的 max_tokens 阈值一致
+	ClaudeStreamRequiredMaxTokens: 21333,
+	AggregateUpstreamStream:       false,
+	QualitySamplingPercent:        0,
+	QualitySamplingQueueSize:      200,
+	DefaultReasoningEffort:        map[string]string{},
+	SystemMessageSeparator:        "\n\n",
+	ToolSchemaCacheSize:           1024,
+	ManagedConversationState:      false,
+	ManagedConversationStateTTL:   3600,
+	MapClaudeThinking:             false,
+	ClaudeStreamConformanceCheck:  false,
+	ChatImageOutput:               ChatImageOutputMarkdown,
+	StreamResumeEnabled:           false,
+	StreamResumeWaitSeconds:       30,
+	ResponseIdMappingEnabled:      false,
+	ResponseIdPrefix:              "gw",
+	ResponseIdMappingTTL:          2592000,
+	// 与 OpenAI 函数调用的上游限制一致
+	ToolMaxCount:       128,
+	ToolSchemaMaxDepth: 10,
+	ToolSchemaMaxBytes: 100 * 1024,
+	// 转换追踪默认关闭
+	ConversionDebugEnabled:   false,
+	ConversionDebugUserIds:   []int{},
+	ConversionDebugTokenIds:  []int{},
+	ConversionTraceQueueSize: 100,
+}
+
+// 全局实例
+var responsesSettings = defaultResponsesSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses", &responsesSettings)
+}
+
+// GetResponsesSettings 获取 Responses 转换配置
+func GetResponsesSettings() *ResponsesSettings {
+	return &responsesSettings
+}
+
+// validChatFinishReasons Chat Completions 规范允许的 finish_reason
+var validChatFinishReasons = map[string]bool{
+	"stop":           true,
+	"length":         true,
+	"tool_calls":     true,
+	"content_filter": true,
+	"function_call":  true,
+}
+
+// GetChatFinishReason 根据 Responses 的状态及未完成原因获取 Chat Completions 的 finish_reason
+// 配置中的非法取值会被忽略，保证返回值始终符合规范
+func (s *ResponsesSettings) GetChatFinishReason(status string, reason string) string {
+	candidates := []string{status, "default"}
+	if reason != "" {
+		candidates = append([]string{status + ":" + reason}, candidates...)
+	}
+	for _, key := range candidates {
+		if value, ok := s.FinishReasonMapping[key]; ok && validChatFinishReasons[value] {
+			return value
+		}
+	}
+	return "stop"
+}
+
+// GetValidationMirrorTarget 获取来源模型的请求需要镜像到的验证期模型
+// 返回值 ok 为 false 表示该模型的请求不需要镜像
+func (s *ResponsesSettings) GetValidationMirrorTarget(sourceModel string) (string, bool) {
+	if s.ValidationMirrorRate <= 0 {
+		return "", false
+	}
+	for target, source := range s.ValidationMirrorSources {
+		if source != sourceModel {
+			continue
+		}
+		if _, ok := s.ValidationModels[target]; ok {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// GetValidationThreshold 获取验证期模型自动转正所需的成功次数
+// 返回值 ok 为 false 表示该模型不在验证期
+func (s *ResponsesSettings) GetValidationThreshold(modelName string) (int, bool) {
+	threshold, ok := s.ValidationModels[modelName]
+	return threshold, ok
+}
+
+// GetClaudeBetaPolicy 获取 Claude beta 请求的处理方式，未配置或配置非法时按 strip 处理
+func (s *ResponsesSettings) GetClaudeBetaPolicy() string {
+	switch s.ClaudeBetaPolicy {
+	case ClaudeBetaPolicyMap, ClaudeBetaPolicyNative:
+		return s.ClaudeBetaPolicy
+	default:
+		return ClaudeBetaPolicyStrip
+	}
+}
+
+// validReasoningEfforts Responses 接口支持的推理强度
+var validReasoningEfforts = map[string]bool{
+	"minimal": true,
+	"low":     true,
+	"medium":  true,
+	"high":    true,
+}
+
+// GetDefaultReasoningEffort 获取上游模型配置的默认推理强度，未配置或配置非法时返回 false
+func (s *ResponsesSettings) GetDefaultReasoningEffort(upstreamModel string) (string, bool) {
+	effort, ok := s.DefaultReasoningEffort[upstreamModel]
+	if !ok || !validReasoningEfforts[effort] {
+		return "", false
+	}
+	return effort, true
+}
+
+// IsConversionDebugAllowed 判断用户或令牌是否在转换追踪的允许名单中
+func (s *ResponsesSettings) IsConversionDebugAllowed(userId int, tokenId int) bool {
+	return slices.Contains(s.ConversionDebugUserIds, userId) || (tokenId != 0 && slices.Contains(s.ConversionDebugTokenIds, tokenId))
+}
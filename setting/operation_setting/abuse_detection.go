@@ -0,0 +1,59 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	// AbuseActionLog 仅记录异常事件，不影响请求
+	AbuseActionLog = "log"
+	// AbuseActionThrottle 拒绝判定为异常的请求，令牌的其他请求不受影响
+	AbuseActionThrottle = "throttle"
+	// AbuseActionBlock 拒绝判定为异常的请求，并在 BlockDurationSeconds 内拒绝该令牌的全部请求
+	AbuseActionBlock = "block"
+)
+
+// AbuseDetectionSetting 令牌异常流量检测配置，用于发现高频重复提示词与失控的 Agent 工具调用循环
+type AbuseDetectionSetting struct {
+	Enabled bool `json:"enabled"`
+	// Action 检测到异常时的处理方式：log、throttle 或 block
+	Action string `json:"action"`
+	// RepeatedPromptThreshold 同一令牌在时间窗口内发送相同提示词的次数达到该值时判定为异常，0 表示不检测
+	RepeatedPromptThreshold int `json:"repeated_prompt_threshold"`
+	// RepeatedPromptWindowSeconds 统计相同提示词次数的时间窗口
+	RepeatedPromptWindowSeconds int `json:"repeated_prompt_window_seconds"`
+	// ToolLoopThreshold 对话历史末尾连续出现相同工具调用（名称与参数均相同）的次数达到该值时判定为循环，0 表示不检测
+	ToolLoopThreshold int `json:"tool_loop_threshold"`
+	// BlockDurationSeconds block 处理方式下令牌被临时封禁的时长
+	BlockDurationSeconds int `json:"block_duration_seconds"`
+}
+
+// 默认配置
+var abuseDetectionSetting = AbuseDetectionSetting{
+	Enabled:                     false,
+	Action:                      AbuseActionLog,
+	RepeatedPromptThreshold:     20,
+	RepeatedPromptWindowSeconds: 60,
+	ToolLoopThreshold:           5,
+	BlockDurationSeconds:        600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("abuse_detection", &abuseDetectionSetting)
+}
+
+// GetAbuseDetectionSetting 获取异常流量检测配置
+func GetAbuseDetectionSetting() *AbuseDetectionSetting {
+	return &abuseDetectionSetting
+}
+
+// GetAction 获取检测到异常时的处理方式，配置非法时按 log 处理
+func (s *AbuseDetectionSetting) GetAction() string {
+	switch s.Action {
+	case AbuseActionThrottle, AbuseActionBlock:
+		return s.Action
+	default:
+		return AbuseActionLog
+	}
+}
@@ -0,0 +1,29 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// TokenRateLimitSetting 令牌每分钟 token 用量限制（TPM）
+// 输入 token 在请求开始时计入，流式输出 token 随输出实时计入，超出上限时拒绝新请求并终止正在输出的流
+type TokenRateLimitSetting struct {
+	Enabled bool `json:"enabled"`
+	// TokensPerMinute 单个令牌每分钟允许的输入与输出 token 总数，0 表示不限制
+	TokensPerMinute int `json:"tokens_per_minute"`
+}
+
+// 默认配置
+var tokenRateLimitSetting = TokenRateLimitSetting{
+	Enabled:         false,
+	TokensPerMinute: 0,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("token_rate_limit", &tokenRateLimitSetting)
+}
+
+// GetTokenRateLimitSetting 获取令牌 TPM 限制配置
+func GetTokenRateLimitSetting() *TokenRateLimitSetting {
+	return &tokenRateLimitSetting
+}
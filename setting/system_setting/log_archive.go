@@ -0,0 +1,30 @@
+package system_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type LogArchiveSetting struct {
+	BodyStorageLimit       int    `json:"body_storage_limit"`        // 日志中请求体/响应体的最大保存字节数，0 表示不限制
+	Enabled                bool   `json:"enabled"`                   // 超出限制时是否将完整内容归档到对象存储
+	Endpoint               string `json:"endpoint"`                  // S3 兼容存储地址，例如 https://s3.us-east-1.amazonaws.com
+	Region                 string `json:"region"`                    // 签名使用的区域
+	Bucket                 string `json:"bucket"`                    // 存储桶名称
+	AccessKeyId            string `json:"access_key_id"`             // 访问密钥 ID
+	SecretAccessKey        string `json:"secret_access_key"`         // 访问密钥
+	SignedURLExpireSeconds int    `json:"signed_url_expire_seconds"` // 下载链接有效期（秒）
+}
+
+var defaultLogArchiveSetting = LogArchiveSetting{
+	BodyStorageLimit:       0,
+	Enabled:                false,
+	Region:                 "us-east-1",
+	SignedURLExpireSeconds: 900,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("log_archive", &defaultLogArchiveSetting)
+}
+
+func GetLogArchiveSetting() *LogArchiveSetting {
+	return &defaultLogArchiveSetting
+}
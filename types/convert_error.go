@@ -0,0 +1,54 @@
+package types
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 格式转换错误码，取值保持稳定，会出现在返回给客户端的错误体中，并可用于日志与指标筛选
+const (
+	ErrorCodeConvertRequestInvalid    ErrorCode = "CONVERT_REQUEST_INVALID"
+	ErrorCodeConvertModelMissing      ErrorCode = "CONVERT_MODEL_MISSING"
+	ErrorCodeConvertSystemInvalid     ErrorCode = "CONVERT_SYSTEM_INVALID"
+	ErrorCodeConvertMessageInvalid    ErrorCode = "CONVERT_MESSAGE_INVALID"
+	ErrorCodeConvertContentEncoding   ErrorCode = "CONVERT_CONTENT_ENCODING_INVALID"
+	ErrorCodeConvertImageTooLarge     ErrorCode = "CONVERT_IMAGE_TOO_LARGE"
+	ErrorCodeConvertToolSchemaInvalid ErrorCode = "CONVERT_TOOL_SCHEMA_INVALID"
+	ErrorCodeConvertToolChoiceInvalid ErrorCode = "CONVERT_TOOL_CHOICE_INVALID"
+	ErrorCodeConvertToolUnsupported   ErrorCode = "CONVERT_TOOL_UNSUPPORTED"
+	ErrorCodeConvertParamUnsupported  ErrorCode = "CONVERT_PARAM_UNSUPPORTED"
+	ErrorCodeConvertEncodeFailed      ErrorCode = "CONVERT_ENCODE_FAILED"
+	ErrorCodeConvertResponseInvalid   ErrorCode = "CONVERT_RESPONSE_INVALID"
+)
+
+// convertErrorCodePrefix 格式转换错误码的统一前缀
+const convertErrorCodePrefix = "CONVERT_"
+
+// NewConvertError 构造格式转换错误，转换错误由请求内容决定，重试其他渠道无意义，因此不重试
+// 错误以 Anthropic 风格保存，OpenAI 格式的客户端同样可以在 code 字段中拿到错误码
+// 参数:
+//   - code: 转换错误码
+//   - statusCode: 返回给客户端的状态码，4xx 对应 invalid_request_error，其余对应 api_error
+//   - format: 错误信息格式
+func NewConvertError(code ErrorCode, statusCode int, format string, args ...any) *NewAPIError {
+	errorType := "invalid_request_error"
+	if statusCode < http.StatusBadRequest || statusCode >= http.StatusInternalServerError {
+		errorType = "api_error"
+	}
+	e := WithClaudeError(ClaudeError{
+		Type:    errorType,
+		Message: fmt.Sprintf(format, args...),
+		Code:    string(code),
+	}, statusCode, ErrOptionWithSkipRetry())
+	e.errorCode = code
+	return e
+}
+
+// IsConvertError 判断错误是否为格式转换错误
+func IsConvertError(err *NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	return strings.HasPrefix(string(err.errorCode), convertErrorCodePrefix)
+}
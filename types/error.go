@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 )
@@ -19,6 +20,8 @@ type OpenAIError struct {
 type ClaudeError struct {
 	Type    string `json:"type,omitempty"`
 	Message string `json:"message,omitempty"`
+	// Code new api 附加的稳定错误码，用于客户端排查，上游返回的错误不包含该字段
+	Code string `json:"code,omitempty"`
 }
 
 type ErrorType string
@@ -63,7 +66,16 @@ const (
 	ErrorCodeAccessDenied          ErrorCode = "access_denied"
 
 	// request error
-	ErrorCodeBadRequestBody ErrorCode = "bad_request_body"
+	ErrorCodeBadRequestBody         ErrorCode = "bad_request_body"
+	ErrorCodeChannelCoolingDown     ErrorCode = "channel_cooling_down"
+	ErrorCodeChannelInMaintenance   ErrorCode = "channel_in_maintenance"
+	ErrorCodeConversionDisabled     ErrorCode = "conversion_disabled"
+	ErrorCodeEndpointNotAllowed     ErrorCode = "endpoint_not_allowed"
+	ErrorCodeEndpointUnsupported    ErrorCode = "endpoint_unsupported"
+	ErrorCodeToolCallBudgetExceeded ErrorCode = "tool_call_budget_exceeded"
+	ErrorCodeAbuseDetected          ErrorCode = "abuse_detected"
+	ErrorCodeRequestTimeout         ErrorCode = "request_timeout"
+	ErrorCodeTokenRateLimited       ErrorCode = "token_rate_limited"
 
 	// response error
 	ErrorCodeReadResponseBodyFailed ErrorCode = "read_response_body_failed"
@@ -92,6 +104,8 @@ type NewAPIError struct {
 	errorType      ErrorType
 	errorCode      ErrorCode
 	StatusCode     int
+	// RetryAfter 上游通过 Retry-After 或限流重置响应头建议的重试等待时长
+	RetryAfter time.Duration
 }
 
 func (e *NewAPIError) GetErrorCode() ErrorCode {
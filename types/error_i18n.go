@@ -0,0 +1,162 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 错误信息语言
+const (
+	ErrorLanguageEnglish = "en"
+	ErrorLanguageChinese = "zh"
+)
+
+// ErrorMessageKey 可本地化错误信息的标识，对应 errorMessages 中各语言的格式串
+type ErrorMessageKey string
+
+const (
+	ErrMsgResponsesEndpointOnly       ErrorMessageKey = "responses_endpoint_only"
+	ErrMsgResponsesModeUnsupported    ErrorMessageKey = "responses_mode_unsupported"
+	ErrMsgResponsesAPIUnsupported     ErrorMessageKey = "responses_api_unsupported"
+	ErrMsgChannelCoolingDown          ErrorMessageKey = "channel_cooling_down"
+	ErrMsgChannelClaudeBetaNative     ErrorMessageKey = "channel_claude_beta_native"
+	ErrMsgChannelClaudeBlockNative    ErrorMessageKey = "channel_claude_block_native"
+	ErrMsgChannelInMaintenance        ErrorMessageKey = "channel_in_maintenance"
+	ErrMsgConversionDisabled          ErrorMessageKey = "conversion_disabled"
+	ErrMsgChannelEndpointNotAllowed   ErrorMessageKey = "channel_endpoint_not_allowed"
+	ErrMsgChannelEndpointUnsupported  ErrorMessageKey = "channel_endpoint_unsupported"
+	ErrMsgEndpointNoSupportedChannel  ErrorMessageKey = "endpoint_no_supported_channel"
+	ErrMsgRetryGetChannelFailed       ErrorMessageKey = "retry_get_channel_failed"
+	ErrMsgRetryChannelNotFound        ErrorMessageKey = "retry_channel_not_found"
+	ErrMsgModelGroupNoAvailableModel  ErrorMessageKey = "model_group_no_available_model"
+	ErrMsgDistributorGetChannelFailed ErrorMessageKey = "distributor_get_channel_failed"
+	ErrMsgDistributorChannelNotFound  ErrorMessageKey = "distributor_channel_not_found"
+	ErrMsgToolCallBudgetExceeded      ErrorMessageKey = "tool_call_budget_exceeded"
+	ErrMsgAbuseRepeatedPrompt         ErrorMessageKey = "abuse_repeated_prompt"
+	ErrMsgAbuseToolLoop               ErrorMessageKey = "abuse_tool_loop"
+	ErrMsgAbuseTokenBlocked           ErrorMessageKey = "abuse_token_blocked"
+	ErrMsgRequestDeadlineExceeded     ErrorMessageKey = "request_deadline_exceeded"
+	ErrMsgTokenRateLimited            ErrorMessageKey = "token_rate_limited"
+)
+
+// errorMessages 各语言的错误信息格式串，英文为默认语言，缺失的翻译回退到英文
+var errorMessages = map[string]map[ErrorMessageKey]string{
+	ErrorLanguageEnglish: {
+		ErrMsgResponsesEndpointOnly:       "OpenAI Responses channel only supports the /v1/responses endpoint, current request: %s",
+		ErrMsgResponsesModeUnsupported:    "OpenAI Responses channel only supports Chat Completions and Responses API requests",
+		ErrMsgResponsesAPIUnsupported:     "OpenAI Responses channel does not support the %s API",
+		ErrMsgChannelCoolingDown:          "channel #%d is cooling down after rate limiting, %s remaining",
+		ErrMsgChannelClaudeBetaNative:     "channel #%d does not support Claude beta requests, a native Claude channel is required",
+		ErrMsgChannelClaudeBlockNative:    "channel #%d cannot preserve Claude %s content blocks, a native Claude channel is required",
+		ErrMsgChannelInMaintenance:        "channel #%d is in a scheduled maintenance window until %s",
+		ErrMsgConversionDisabled:          "conversion %s is disabled by the administrator, channel #%d cannot serve this request",
+		ErrMsgChannelEndpointNotAllowed:   "channel #%d is not allowed to serve the %s endpoint",
+		ErrMsgChannelEndpointUnsupported:  "channel #%d does not support the %s endpoint, supported endpoints: %s",
+		ErrMsgEndpointNoSupportedChannel:  "no channel for model %s supports the %s endpoint, available channels only support: %s",
+		ErrMsgRetryGetChannelFailed:       "failed to get an available channel in group %s for model %s (retry): %s",
+		ErrMsgRetryChannelNotFound:        "no available channel in group %s for model %s (retry)",
+		ErrMsgModelGroupNoAvailableModel:  "none of the models in model group %s (%s) has an available channel",
+		ErrMsgDistributorGetChannelFailed: "failed to get an available channel in group %s for model %s (distributor): %s",
+		ErrMsgDistributorChannelNotFound:  "no available channel in group %s for model %s (distributor)",
+		ErrMsgToolCallBudgetExceeded:      "tool call budget exceeded: the conversation already contains %d tool calls, the limit is %d",
+		ErrMsgAbuseRepeatedPrompt:         "the same prompt was sent %d times within %d seconds, please check the client for retry loops",
+		ErrMsgAbuseToolLoop:               "agent loop detected: tool call %s was repeated %d times in a row",
+		ErrMsgAbuseTokenBlocked:           "token is temporarily blocked due to abnormal traffic, %s remaining",
+		ErrMsgRequestDeadlineExceeded:     "request exceeded the deadline of %s and was cancelled",
+		ErrMsgTokenRateLimited:            "token rate limit exceeded: %d tokens used in the current minute, the limit is %d",
+	},
+	ErrorLanguageChinese: {
+		ErrMsgResponsesEndpointOnly:       "OpenAI Responses 渠道仅支持 /v1/responses 接口，当前请求: %s",
+		ErrMsgResponsesModeUnsupported:    "OpenAI Responses 渠道仅支持 Chat Completions 和 Responses API 请求",
+		ErrMsgResponsesAPIUnsupported:     "OpenAI Responses 渠道不支持 %s 接口",
+		ErrMsgChannelCoolingDown:          "渠道 #%d 处于限流冷却中，剩余 %s",
+		ErrMsgChannelClaudeBetaNative:     "渠道 #%d 不支持 Claude beta 请求，需要原生 Claude 渠道",
+		ErrMsgChannelClaudeBlockNative:    "渠道 #%d 无法保留 Claude %s 内容块，需要原生 Claude 渠道",
+		ErrMsgChannelInMaintenance:        "渠道 #%d 处于计划维护中，预计 %s 结束",
+		ErrMsgConversionDisabled:          "管理员已关闭 %s 格式转换，渠道 #%d 无法处理该请求",
+		ErrMsgChannelEndpointNotAllowed:   "渠道 #%d 未被允许处理 %s 接口",
+		ErrMsgChannelEndpointUnsupported:  "渠道 #%d 不支持 %s 接口，支持的接口: %s",
+		ErrMsgEndpointNoSupportedChannel:  "模型 %s 的渠道均不支持 %s 接口，可用渠道仅支持: %s",
+		ErrMsgRetryGetChannelFailed:       "获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s",
+		ErrMsgRetryChannelNotFound:        "分组 %s 下模型 %s 的可用渠道不存在（retry）",
+		ErrMsgModelGroupNoAvailableModel:  "模型组 %s 中的模型（%s）均无可用渠道",
+		ErrMsgDistributorGetChannelFailed: "获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s",
+		ErrMsgDistributorChannelNotFound:  "分组 %s 下模型 %s 无可用渠道（distributor）",
+		ErrMsgToolCallBudgetExceeded:      "工具调用次数超出上限：当前对话已包含 %d 次工具调用，上限为 %d",
+		ErrMsgAbuseRepeatedPrompt:         "相同的提示词在 %[2]d 秒内发送了 %[1]d 次，请检查客户端是否存在重试循环",
+		ErrMsgAbuseToolLoop:               "检测到 Agent 循环：工具调用 %s 连续重复了 %d 次",
+		ErrMsgAbuseTokenBlocked:           "令牌因异常流量被临时封禁，剩余 %s",
+		ErrMsgRequestDeadlineExceeded:     "请求超过截止时间 %s，已取消",
+		ErrMsgTokenRateLimited:            "令牌每分钟 token 用量超出上限：当前分钟已使用 %d，上限为 %d",
+	},
+}
+
+// LocalizedError 可按语言输出的错误，Error() 返回英文信息
+type LocalizedError struct {
+	Key  ErrorMessageKey
+	Args []any
+}
+
+// NewLocalizedError 创建可本地化的错误
+func NewLocalizedError(key ErrorMessageKey, args ...any) *LocalizedError {
+	return &LocalizedError{Key: key, Args: args}
+}
+
+func (e *LocalizedError) Error() string {
+	return e.Localize(ErrorLanguageEnglish)
+}
+
+// Localize 返回指定语言的错误信息，语言或翻译缺失时使用英文
+func (e *LocalizedError) Localize(lang string) string {
+	format, ok := errorMessages[lang][e.Key]
+	if !ok {
+		format, ok = errorMessages[ErrorLanguageEnglish][e.Key]
+		if !ok {
+			return string(e.Key)
+		}
+	}
+	return fmt.Sprintf(format, e.Args...)
+}
+
+// ParseErrorLanguage 从 Accept-Language 请求头中选出第一个支持的语言，均不支持时返回英文
+func ParseErrorLanguage(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		lang := strings.SplitN(tag, "-", 2)[0]
+		if _, ok := errorMessages[lang]; ok {
+			return lang
+		}
+	}
+	return ErrorLanguageEnglish
+}
+
+// LocalizeErrorMessage 返回错误信息，其中包装的可本地化错误替换为指定语言
+func LocalizeErrorMessage(err error, lang string) string {
+	var localizedErr *LocalizedError
+	if lang == ErrorLanguageEnglish || !errors.As(err, &localizedErr) {
+		return err.Error()
+	}
+	return strings.Replace(err.Error(), localizedErr.Error(), localizedErr.Localize(lang), 1)
+}
+
+// Localize 将错误中可本地化的部分替换为指定语言，错误码保持不变
+func (e *NewAPIError) Localize(lang string) {
+	if e == nil || e.Err == nil {
+		return
+	}
+	var localizedErr *LocalizedError
+	if lang == ErrorLanguageEnglish || !errors.As(e.Err, &localizedErr) {
+		return
+	}
+	message := LocalizeErrorMessage(e.Err, lang)
+	e.Err = errors.New(message)
+	switch relayError := e.RelayError.(type) {
+	case OpenAIError:
+		relayError.Message = message
+		e.RelayError = relayError
+	case ClaudeError:
+		relayError.Message = message
+		e.RelayError = relayError
+	}
+}
@@ -18,18 +18,38 @@ const (
 )
 
 type TokenCountMeta struct {
-	TokenType     TokenType   `json:"token_type,omitempty"`     // Type of tokens used in the request
-	CombineText   string      `json:"combine_text,omitempty"`   // Combined text from all messages
-	ToolsCount    int         `json:"tools_count,omitempty"`    // Number of tools used
-	NameCount     int         `json:"name_count,omitempty"`     // Number of names in the request
-	MessagesCount int         `json:"messages_count,omitempty"` // Number of messages in the request
-	Files         []*FileMeta `json:"files,omitempty"`          // List of files, each with type and content
-	MaxTokens     int         `json:"max_tokens,omitempty"`     // Maximum tokens allowed in the request
+	TokenType     TokenType        `json:"token_type,omitempty"`     // Type of tokens used in the request
+	CombineText   string           `json:"combine_text,omitempty"`   // Combined text from all messages
+	ToolsCount    int              `json:"tools_count,omitempty"`    // Number of tools used
+	NameCount     int              `json:"name_count,omitempty"`     // Number of names in the request
+	MessagesCount int              `json:"messages_count,omitempty"` // Number of messages in the request
+	Files         []*FileMeta      `json:"files,omitempty"`          // List of files, each with type and content
+	MaxTokens     int              `json:"max_tokens,omitempty"`     // Maximum tokens allowed in the request
+	SystemText    string           `json:"system_text,omitempty"`    // System prompt part of CombineText
+	ToolsText     string           `json:"tools_text,omitempty"`     // Tool definitions part of CombineText
+	ToolSchemas   []ToolSchemaMeta `json:"tool_schemas,omitempty"`   // Structured function tool definitions, used for schema-aware estimation
+	ToolChoice    string           `json:"tool_choice,omitempty"`    // Tool choice mode: auto, any, tool or none
 
 	ImagePriceRatio float64 `json:"image_ratio,omitempty"` // Ratio for image size, if applicable
 	//IsStreaming   bool        `json:"is_streaming,omitempty"`   // Indicates if the request is streaming
 }
 
+// ToolSchemaMeta is a function tool definition independent of the request format
+type ToolSchemaMeta struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"` // JSON schema of the tool input
+}
+
+// PromptTokenBreakdown describes how the pre-counted prompt tokens were spent
+type PromptTokenBreakdown struct {
+	System  int `json:"system"`  // System prompt / instructions tokens
+	Tools   int `json:"tools"`   // Tool schema tokens, including per-tool overhead
+	History int `json:"history"` // Message history and remaining text tokens
+	Images  int `json:"images"`  // Image tokens
+	Media   int `json:"media"`   // Audio, video and file tokens
+}
+
 type FileMeta struct {
 	FileType
 	MimeType   string
@@ -43,6 +43,18 @@ import { IconTreeTriangleDown, IconMore } from '@douyinfe/semi-icons';
 import { FaRandom } from 'react-icons/fa';
 
 // Render functions
+// 读取渠道设置中的余额告警阈值
+const getBalanceAlertThreshold = (record) => {
+  if (!record.settings) {
+    return 0;
+  }
+  try {
+    return JSON.parse(record.settings).balance_alert_threshold || 0;
+  } catch (error) {
+    return 0;
+  }
+};
+
 const renderType = (type, channelInfo = undefined, t) => {
   let type2label = new Map();
   for (let i = 0; i < CHANNEL_OPTIONS.length; i++) {
@@ -343,7 +355,12 @@ export const getChannelsColumns = ({
                   content={t('剩余额度$') + record.balance + t('，点击更新')}
                 >
                   <Tag
-                    color='white'
+                    color={
+                      getBalanceAlertThreshold(record) > 0 &&
+                      record.balance < getBalanceAlertThreshold(record)
+                        ? 'red'
+                        : 'white'
+                    }
                     type='ghost'
                     shape='circle'
                     onClick={() => updateChannelBalance(record)}
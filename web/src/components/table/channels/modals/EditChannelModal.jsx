@@ -24,6 +24,7 @@ import {
   showError,
   showInfo,
   showSuccess,
+  showWarning,
   verifyJSON,
 } from '../../../../helpers';
 import { useIsMobile } from '../../../../hooks/common/useIsMobile';
@@ -163,6 +164,10 @@ const EditChannelModal = (props) => {
     allow_service_tier: false,
     disable_store: false, // false = 允许透传（默认开启）
     allow_safety_identifier: false,
+    // 余额查询与告警（存入 settings）
+    balance_admin_key: '',
+    balance_monthly_budget: 0,
+    balance_alert_threshold: 0,
   };
   const [batch, setBatch] = useState(false);
   const [multiToSingle, setMultiToSingle] = useState(false);
@@ -571,6 +576,12 @@ const EditChannelModal = (props) => {
           data.disable_store = parsedSettings.disable_store || false;
           data.allow_safety_identifier =
             parsedSettings.allow_safety_identifier || false;
+          // 读取余额查询与告警设置
+          data.balance_admin_key = parsedSettings.balance_admin_key || '';
+          data.balance_monthly_budget =
+            parsedSettings.balance_monthly_budget || 0;
+          data.balance_alert_threshold =
+            parsedSettings.balance_alert_threshold || 0;
         } catch (error) {
           console.error('解析其他设置失败:', error);
           data.azure_responses_version = '';
@@ -581,6 +592,9 @@ const EditChannelModal = (props) => {
           data.allow_service_tier = false;
           data.disable_store = false;
           data.allow_safety_identifier = false;
+          data.balance_admin_key = '';
+          data.balance_monthly_budget = 0;
+          data.balance_alert_threshold = 0;
         }
       } else {
         // 兼容历史数据：老渠道没有 settings 时，默认按 json 展示
@@ -590,6 +604,9 @@ const EditChannelModal = (props) => {
         data.allow_service_tier = false;
         data.disable_store = false;
         data.allow_safety_identifier = false;
+        data.balance_admin_key = '';
+        data.balance_monthly_budget = 0;
+        data.balance_alert_threshold = 0;
       }
 
       if (
@@ -1199,6 +1216,15 @@ const EditChannelModal = (props) => {
       }
     }
 
+    // 余额查询与告警设置
+    settings.balance_alert_threshold =
+      Number(localInputs.balance_alert_threshold) || 0;
+    if (localInputs.type === 1 || localInputs.type === 14) {
+      settings.balance_admin_key = localInputs.balance_admin_key || '';
+      settings.balance_monthly_budget =
+        Number(localInputs.balance_monthly_budget) || 0;
+    }
+
     localInputs.settings = JSON.stringify(settings);
 
     // 清理不需要发送到后端的字段
@@ -1217,6 +1243,10 @@ const EditChannelModal = (props) => {
     delete localInputs.allow_service_tier;
     delete localInputs.disable_store;
     delete localInputs.allow_safety_identifier;
+    // 清理余额设置的临时字段
+    delete localInputs.balance_admin_key;
+    delete localInputs.balance_monthly_budget;
+    delete localInputs.balance_alert_threshold;
 
     let res;
     localInputs.auto_ban = localInputs.auto_ban ? 1 : 0;
@@ -1241,7 +1271,7 @@ const EditChannelModal = (props) => {
         channel: localInputs,
       });
     }
-    const { success, message } = res.data;
+    const { success, message, warnings } = res.data;
     if (success) {
       if (isEdit) {
         showSuccess(t('渠道更新成功！'));
@@ -1249,6 +1279,7 @@ const EditChannelModal = (props) => {
         showSuccess(t('渠道创建成功！'));
         setInputs(originInputs);
       }
+      (warnings || []).forEach((warning) => showWarning(warning));
       props.refresh();
       props.handleClose();
     } else {
@@ -2886,6 +2917,48 @@ const EditChannelModal = (props) => {
                         />
                       </>
                     )}
+
+                    {/* 余额查询与告警 */}
+                    <div className='mt-4 mb-2 text-sm font-medium text-gray-700'>
+                      {t('余额查询与告警')}
+                    </div>
+
+                    {(inputs.type === 1 || inputs.type === 14) && (
+                      <>
+                        <Form.Input
+                          field='balance_admin_key'
+                          label={t('组织 Admin Key')}
+                          placeholder={t('用于查询组织费用的 Admin Key，可选')}
+                          mode='password'
+                          showClear
+                          onChange={(value) =>
+                            handleInputChange('balance_admin_key', value)
+                          }
+                          extraText={t(
+                            '配置后通过组织费用接口查询余额，余额 = 月度预算 - 本月费用',
+                          )}
+                        />
+
+                        <Form.InputNumber
+                          field='balance_monthly_budget'
+                          label={t('月度预算（美元）')}
+                          min={0}
+                          onChange={(value) =>
+                            handleInputChange('balance_monthly_budget', value)
+                          }
+                        />
+                      </>
+                    )}
+
+                    <Form.InputNumber
+                      field='balance_alert_threshold'
+                      label={t('余额告警阈值')}
+                      min={0}
+                      onChange={(value) =>
+                        handleInputChange('balance_alert_threshold', value)
+                      }
+                      extraText={t('余额低于该值时通知管理员，0 表示不告警')}
+                    />
                   </Card>
                 </div>
 
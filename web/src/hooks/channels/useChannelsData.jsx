@@ -24,6 +24,7 @@ import {
   showError,
   showInfo,
   showSuccess,
+  showWarning,
   loadChannelModels,
   copy,
 } from '../../helpers';
@@ -724,7 +725,7 @@ export const useChannelsData = () => {
 
   const updateChannelBalance = async (record) => {
     const res = await API.get(`/api/channel/update_balance/${record.id}/`);
-    const { success, message, balance } = res.data;
+    const { success, message, balance, key_balances } = res.data;
     if (success) {
       updateChannelProperty(record.id, (channel) => {
         channel.balance = balance;
@@ -733,6 +734,16 @@ export const useChannelsData = () => {
       showInfo(
         t('通道 ${name} 余额更新成功！').replace('${name}', record.name),
       );
+      // 多密钥渠道：提示查询失败的密钥
+      const failedKeys = (key_balances || []).filter((item) => item.error);
+      if (failedKeys.length > 0) {
+        showWarning(
+          t('以下密钥余额查询失败：') +
+            failedKeys
+              .map((item) => `#${item.index + 1} ${item.error}`)
+              .join('; '),
+        );
+      }
     } else {
       showError(message);
     }
@@ -37,6 +37,7 @@ const thinkingExample = JSON.stringify(
 
 const defaultGlobalSettingInputs = {
   'global.pass_through_request_enabled': false,
+  'global.routing_headers_enabled': false,
   'global.thinking_model_blacklist': '[]',
   'general_setting.ping_interval_enabled': false,
   'general_setting.ping_interval_seconds': 60,
@@ -146,6 +147,21 @@ export default function SettingGlobalModel(props) {
                   }
                 />
               </Col>
+              <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                <Form.Switch
+                  label={t('返回路由信息响应头')}
+                  field={'global.routing_headers_enabled'}
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      'global.routing_headers_enabled': value,
+                    })
+                  }
+                  extraText={t(
+                    '开启后，响应头将包含 X-NewAPI-Channel-Type、X-NewAPI-Converted-From 与 X-NewAPI-Upstream-Model',
+                  )}
+                />
+              </Col>
             </Row>
             <Row>
               <Col span={24}>